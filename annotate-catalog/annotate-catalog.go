@@ -0,0 +1,184 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//
+// This program records free-form notes (e.g. "page 37 missing", or where a scan came from) against documents in a catalog.
+// The notes are kept in each document's Notes field, which no tool ever sets: they survive re-scans (the producers
+// carry them forward from the catalog they replace) and merges (notes written on both sides are combined).
+//
+// Exactly one of these is required:
+//
+//   --append TEXT     adds TEXT as a new line of the notes (unless already present)
+//   --set TEXT        replaces the notes with TEXT
+//   --clear           removes the notes
+//   --location TEXT   records where a physical copy (e.g. the paper original) is kept, as found by where-is
+//   --clear-location  removes the location
+//
+// The documents are selected, as for tag-catalog, with any combination of --key, --md5, --part-num and --path (a glob
+// matched against the whole filepath). Each may be given more than once; a document is changed if it matches any of them.
+// The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run annotate-catalog/annotate-catalog.go --append "page 37 missing" --md5 0123456789abcdef0123456789abcdef bin/local.yaml
+//
+
+type Document = document.Document
+
+// Returns a flag.Func handler that appends each value to list
+func appendTo(list *[]string) func(string) error {
+	return func(s string) error {
+		*list = append(*list, s)
+		return nil
+	}
+}
+
+func main() {
+	var query catalog.Query
+	flag.Func("key", "select the document with this catalog key (repeatable)", appendTo(&query.Keys))
+	flag.Func("md5", "select the document with this MD5 checksum (repeatable)", appendTo(&query.Md5s))
+	flag.Func("part-num", "select documents with this part number (repeatable)", appendTo(&query.PartNums))
+	flag.Func("path", "select documents whose filepath matches this glob (repeatable)", appendTo(&query.PathGlobs))
+	appendText := flag.String("append", "", "text to add as a new line of each selected document's notes")
+	setText := flag.String("set", "", "text to replace each selected document's notes")
+	clearNotes := flag.Bool("clear", false, "remove the notes of each selected document")
+	location := flag.String("location", "", "where a physical copy of each selected document is kept, e.g. 'Study, shelf 4'")
+	clearLocation := flag.Bool("clear-location", false, "remove the location of each selected document")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	var annotate func(doc *Document) bool
+	actions := 0
+	if *appendText != "" {
+		annotate = func(doc *Document) bool { return document.AppendNotes(doc, *appendText) }
+		actions += 1
+	}
+	if *setText != "" {
+		annotate = func(doc *Document) bool { return SetNotes(doc, *setText) }
+		actions += 1
+	}
+	if *clearNotes {
+		annotate = func(doc *Document) bool { return SetNotes(doc, "") }
+		actions += 1
+	}
+	if *location != "" {
+		annotate = func(doc *Document) bool { return SetLocation(doc, *location) }
+		actions += 1
+	}
+	if *clearLocation {
+		annotate = func(doc *Document) bool { return SetLocation(doc, "") }
+		actions += 1
+	}
+	if actions != 1 {
+		exitcode.UsageError("Please supply exactly one of --append, --set, --clear, --location or --clear-location")
+	}
+	if query.Empty() {
+		exitcode.UsageError("Please select documents with --key, --md5, --part-num or --path")
+	}
+	if err := query.Validate(); err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to annotate")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selected, changed := Annotate(documents, query, annotate)
+	if *verbose {
+		for _, key := range changed {
+			fmt.Printf("Annotated %s: %s\n", key, strings.ReplaceAll(documents[key].Notes, "\n", " / "))
+			if documents[key].Location != "" {
+				fmt.Printf("  location: %s\n", documents[key].Location)
+			}
+		}
+	}
+	if selected == 0 {
+		exitcode.Warning("WARNING: no document in %s was selected\n", inputFilename)
+	}
+	fmt.Printf("Selected %d documents, changed %d\n", selected, len(changed))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Replaces the notes of a document. Returns true if they changed.
+func SetNotes(doc *Document, notes string) bool {
+	notes = strings.TrimSpace(notes)
+	if doc.Notes == notes {
+		return false
+	}
+	doc.Notes = notes
+	return true
+}
+
+// Sets the location of a document's physical copy. Returns true if the location changed.
+func SetLocation(doc *Document, location string) bool {
+	location = strings.TrimSpace(location)
+	if doc.Location == location {
+		return false
+	}
+	doc.Location = location
+	return true
+}
+
+// Applies annotate to every document selected by the query.
+// Returns the number of documents selected and the keys of those actually changed, sorted.
+func Annotate(documents catalog.Catalog, query catalog.Query, annotate func(doc *Document) bool) (int, []string) {
+	selected := 0
+	var changed []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if !query.Matches(key, doc) {
+			continue
+		}
+		selected += 1
+		if annotate(&doc) {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return selected, changed
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {PartNum: "EK-KA630-TM", Md5: "a", Notes: "from DEC_0001"},
+		"b": {PartNum: "AA-0196C-TK", Md5: "b"},
+	}
+	appendNote := func(doc *Document) bool { return document.AppendNotes(doc, "page 37 missing") }
+
+	selected, changed := Annotate(documents, catalog.Query{Md5s: []string{"a", "b"}}, appendNote)
+	if (selected != 2) || !reflect.DeepEqual(changed, []string{"a", "b"}) {
+		t.Errorf(`Annotate() = %d, %q`, selected, changed)
+	}
+	if (documents["a"].Notes != "from DEC_0001\npage 37 missing") || (documents["b"].Notes != "page 37 missing") {
+		t.Errorf(`Annotate() gave %+v`, documents)
+	}
+
+	selected, changed = Annotate(documents, catalog.Query{Keys: []string{"b"}}, func(doc *Document) bool { return SetNotes(doc, "") })
+	if (selected != 1) || (len(changed) != 1) || (documents["b"].Notes != "") {
+		t.Errorf(`Annotate() to clear = %d, %q, %+v`, selected, changed, documents["b"])
+	}
+}
+
+func TestSetLocation(t *testing.T) {
+	doc := Document{Location: "Loft, box 7"}
+	if SetLocation(&doc, " Loft, box 7 ") {
+		t.Errorf("SetLocation() reported a change to the same location")
+	}
+	if !SetLocation(&doc, "Study, shelf 4") || (doc.Location != "Study, shelf 4") {
+		t.Errorf("SetLocation() = %q, expected Study, shelf 4", doc.Location)
+	}
+	if !SetLocation(&doc, "") || (doc.Location != "") {
+		t.Errorf("SetLocation() = %q, expected it to be cleared", doc.Location)
+	}
+}
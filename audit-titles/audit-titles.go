@@ -0,0 +1,420 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//
+// This program audits the titles in a catalog for the typos and inconsistencies that index files introduce
+// (e.g. "PROGRAMMRES", double spaces, trailing periods), which would otherwise stay in the catalog forever.
+//
+// It reports three kinds of suggestion, each proposing a canonical title to replace one or more variants:
+//
+//   format     a title with stray white space or a trailing period
+//   similar    a cluster of near-identical titles, i.e. within --max-distance of each other once case, white space and
+//              trailing punctuation are ignored (as a fraction of the title's length: 0.1 allows one edit in ten
+//              characters). Titles that differ in any number (e.g. "VAX-11/750" and "VAX-11/780") are never clustered.
+//   partnum    documents with the same part number but different titles
+//
+// The canonical title proposed is the variant used by the most documents, tidied. With --suggestions-output FILE the
+// suggestions are written as YAML; once edited (to choose a different canonical title, or to delete a suggestion),
+// --apply FILE rewrites the titles of the matching documents. A partnum suggestion only applies to documents with
+// that part number. When applying, the catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run audit-titles/audit-titles.go --suggestions-output titles.yaml bin/local.yaml
+//   go run audit-titles/audit-titles.go --apply titles.yaml bin/local.yaml
+//
+
+type Document = document.Document
+
+// The kinds of Suggestion
+const (
+	KindFormat  = "format"
+	KindSimilar = "similar"
+	KindPartNum = "partnum"
+)
+
+// Suggestion proposes a canonical title to replace one or more variants
+type Suggestion struct {
+	Kind      string
+	PartNum   string `yaml:",omitempty"` // For a partnum suggestion, the only part number whose documents are changed
+	Canonical string
+	Variants  []string
+}
+
+// Tokens shared by more titles than this are too common to help find similar titles
+const maxBlockSize = 500
+
+func main() {
+	maxDistance := flag.Float64("max-distance", 0.1, "the greatest edit distance, as a fraction of the title length, between titles considered similar")
+	suggestionsFilename := flag.String("suggestions-output", "", "filepath of a YAML file to receive the suggestions, for editing and --apply")
+	applyFilename := flag.String("apply", "", "filepath of a YAML file of suggestions whose canonical titles are to be applied to the catalog")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML when applying suggestions (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to audit")
+	}
+	if (*maxDistance < 0) || (*maxDistance >= 1) {
+		exitcode.UsageError("--max-distance must be at least 0 and less than 1")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	if *applyFilename != "" {
+		suggestions, err := ReadSuggestions(*applyFilename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", *applyFilename, err)
+		}
+		changed := Apply(documents, suggestions)
+		if *verbose {
+			for _, key := range changed {
+				fmt.Printf("Retitled %s: %s\n", key, documents[key].Title)
+			}
+		}
+		fmt.Printf("Applied %d suggestions, changed %d documents\n", len(suggestions), len(changed))
+
+		if *preview {
+			if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+				exitcode.Fatal("Cannot preview the changes: ", err)
+			} else if !confirmed {
+				fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+				exitcode.Exit()
+			}
+		}
+		if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed YAML write: ", err)
+		}
+		if *jsonlOutputFilename != "" {
+			if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+				exitcode.Fatal("Failed JSON Lines write: ", err)
+			}
+		}
+		exitcode.Exit()
+	}
+
+	suggestions := Audit(documents, *maxDistance)
+	counts := TitleCounts(documents)
+	kinds := make(map[string]int)
+	for _, suggestion := range suggestions {
+		kinds[suggestion.Kind] += 1
+		heading := suggestion.Kind
+		if suggestion.PartNum != "" {
+			heading += " " + suggestion.PartNum
+		}
+		fmt.Printf("%-8s %q (%d)\n", heading+":", suggestion.Canonical, counts[suggestion.Canonical])
+		for _, variant := range suggestion.Variants {
+			fmt.Printf("         %q (%d)\n", variant, counts[variant])
+		}
+	}
+	fmt.Printf("%d suggestions: %d format, %d similar, %d partnum\n", len(suggestions), kinds[KindFormat], kinds[KindSimilar], kinds[KindPartNum])
+
+	if *suggestionsFilename != "" {
+		data, err := document.MarshalYaml(suggestions)
+		if err != nil {
+			exitcode.Fatal("Bad YAML data: ", err)
+		}
+		if _, err := workspace.WriteFile(*suggestionsFilename, data, 0644); err != nil {
+			exitcode.Fatal("Failed suggestions write: ", err)
+		}
+		fmt.Printf("Suggestions written to %s\n", *suggestionsFilename)
+	}
+
+	exitcode.Exit()
+}
+
+// Reads a YAML file of suggestions, as written by --suggestions-output
+func ReadSuggestions(filename string) ([]Suggestion, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var suggestions []Suggestion
+	if err := document.UnmarshalYaml(data, &suggestions); err != nil {
+		return nil, err
+	}
+	for _, suggestion := range suggestions {
+		if strings.TrimSpace(suggestion.Canonical) == "" {
+			return nil, fmt.Errorf("a suggestion for %q has no canonical title", suggestion.Variants)
+		}
+	}
+	return suggestions, nil
+}
+
+// Returns the number of documents with each title
+func TitleCounts(documents catalog.Catalog) map[string]int {
+	counts := make(map[string]int)
+	for _, doc := range documents {
+		if doc.Title != "" {
+			counts[doc.Title] += 1
+		}
+	}
+	return counts
+}
+
+// Audits the titles of a catalog, returning the format suggestions, then the similar ones, then the partnum ones.
+func Audit(documents catalog.Catalog, maxDistance float64) []Suggestion {
+	counts := TitleCounts(documents)
+	var suggestions []Suggestion
+
+	titles := make([]string, 0, len(counts))
+	for title := range counts {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	// A title in a cluster is tidied along with the rest of the cluster, so needs no format suggestion of its own
+	clustered := make(map[string]bool)
+	var similar []Suggestion
+	for _, cluster := range SimilarTitles(titles, maxDistance) {
+		canonical := Canonical(cluster, counts)
+		similar = append(similar, Suggestion{Kind: KindSimilar, Canonical: canonical, Variants: without(cluster, canonical)})
+		for _, title := range cluster {
+			clustered[title] = true
+		}
+	}
+	for _, title := range titles {
+		if tidied := TidyTitle(title); (tidied != title) && !clustered[title] {
+			suggestions = append(suggestions, Suggestion{Kind: KindFormat, Canonical: tidied, Variants: []string{title}})
+		}
+	}
+	suggestions = append(suggestions, similar...)
+
+	byPartNum := documents.IndexByPublication()
+	partNums := make([]string, 0, len(byPartNum))
+	for partNum := range byPartNum {
+		partNums = append(partNums, partNum)
+	}
+	sort.Strings(partNums)
+	for _, partNum := range partNums {
+		var variants []string
+		partNumCounts := make(map[string]int)
+		for _, key := range byPartNum[partNum] {
+			if title := documents[key].Title; title != "" {
+				partNumCounts[title] += 1
+				if !slices.Contains(variants, title) {
+					variants = append(variants, title)
+				}
+			}
+		}
+		// Titles that differ only in case, white space or punctuation are already reported as similar
+		if len(uniqueComparisonKeys(variants)) < 2 {
+			continue
+		}
+		sort.Strings(variants)
+		canonical := Canonical(variants, partNumCounts)
+		suggestions = append(suggestions, Suggestion{Kind: KindPartNum, PartNum: documents[byPartNum[partNum][0]].PartNum, Canonical: canonical, Variants: without(variants, canonical)})
+	}
+
+	return suggestions
+}
+
+// Applies the suggestions to the catalog, replacing each variant title with the canonical title.
+// Returns the keys of the documents changed, sorted.
+func Apply(documents catalog.Catalog, suggestions []Suggestion) []string {
+	var changed []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		for _, suggestion := range suggestions {
+			if !slices.Contains(suggestion.Variants, doc.Title) || (doc.Title == suggestion.Canonical) {
+				continue
+			}
+			if (suggestion.PartNum != "") && (document.NormalisePartNumber(doc.PartNum) != document.NormalisePartNumber(suggestion.PartNum)) {
+				continue
+			}
+			doc.Title = suggestion.Canonical
+		}
+		if doc.Title != documents[key].Title {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Tidies a title: collapses runs of white space, and removes white space at either end and a single trailing period
+// (but not an ellipsis).
+func TidyTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if strings.HasSuffix(title, ".") && !strings.HasSuffix(title, "..") {
+		title = strings.TrimSpace(strings.TrimSuffix(title, "."))
+	}
+	return title
+}
+
+// Returns the proposed canonical title of a set of variants: the one used by the most documents, preferring one that
+// is already tidy and then the first alphabetically. The result is tidied.
+func Canonical(variants []string, counts map[string]int) string {
+	best := ""
+	for _, variant := range variants {
+		if (best == "") || (counts[variant] > counts[best]) ||
+			((counts[variant] == counts[best]) && (TidyTitle(variant) == variant) && (TidyTitle(best) != best)) {
+			best = variant
+		}
+	}
+	return TidyTitle(best)
+}
+
+// Returns the key used to compare titles: lower case, with runs of white space collapsed and trailing punctuation removed
+func comparisonKey(title string) string {
+	key := strings.ToLower(strings.Join(strings.Fields(title), " "))
+	return strings.TrimRightFunc(key, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) })
+}
+
+func uniqueComparisonKeys(titles []string) []string {
+	var keys []string
+	for _, title := range titles {
+		if key := comparisonKey(title); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Returns the numbers in a title, which must match exactly for two titles to be similar
+func numbers(title string) string {
+	return strings.Join(strings.FieldsFunc(title, func(r rune) bool { return !unicode.IsDigit(r) }), " ")
+}
+
+// Groups the titles into clusters of similar titles, each sorted, ignoring titles that are similar to no other.
+// Only titles that share a word (other than one that is very common) are compared, so that large catalogs can be audited.
+func SimilarTitles(titles []string, maxDistance float64) [][]string {
+	keys := make([][]rune, len(titles))
+	blocks := make(map[string][]int)
+	for i, title := range titles {
+		key := comparisonKey(title)
+		keys[i] = []rune(key)
+		for _, word := range uniqueWords(key) {
+			blocks[word] = append(blocks[word], i)
+		}
+	}
+
+	parent := make([]int, len(titles))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	compared := make(map[[2]int]bool)
+	for _, block := range blocks {
+		if len(block) > maxBlockSize {
+			continue
+		}
+		for x, i := range block {
+			for _, j := range block[x+1:] {
+				if compared[[2]int{i, j}] || (find(i) == find(j)) {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+				if Similar(keys[i], keys[j], maxDistance) && (numbers(titles[i]) == numbers(titles[j])) {
+					parent[find(j)] = find(i)
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	for i, title := range titles {
+		members[find(i)] = append(members[find(i)], title)
+	}
+	var clusters [][]string
+	for _, cluster := range members {
+		if len(cluster) > 1 {
+			sort.Strings(cluster)
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0] < clusters[j][0] })
+	return clusters
+}
+
+func uniqueWords(key string) []string {
+	var words []string
+	for _, word := range strings.Fields(key) {
+		if !slices.Contains(words, word) {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Reports whether two comparison keys are within maxDistance of each other: their edit distance as a fraction of the
+// length of the longer
+func Similar(a []rune, b []rune, maxDistance float64) bool {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return true
+	}
+	limit := int(maxDistance * float64(longest))
+	if (len(a)-len(b) > limit) || (len(b)-len(a) > limit) {
+		return false
+	}
+	return EditDistance(a, b) <= limit
+}
+
+// Returns the Levenshtein distance between two strings: the number of single character insertions, deletions and
+// substitutions needed to turn one into the other
+func EditDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// Returns the titles other than title
+func without(titles []string, title string) []string {
+	var others []string
+	for _, other := range titles {
+		if other != title {
+			others = append(others, other)
+		}
+	}
+	return others
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestTidyTitle(t *testing.T) {
+	tests := map[string]string{
+		" VAX  Programmers   Guide. ": "VAX Programmers Guide",
+		"More to come...":             "More to come...",
+		"KA630 Manual":                "KA630 Manual",
+	}
+	for title, expected := range tests {
+		if got := TidyTitle(title); got != expected {
+			t.Errorf("TidyTitle(%q) = %q, expected %q", title, got, expected)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	if got := EditDistance([]rune("programmres"), []rune("programmers")); got != 2 {
+		t.Errorf("EditDistance() = %d, expected 2", got)
+	}
+	if got := EditDistance([]rune(""), []rune("abc")); got != 3 {
+		t.Errorf("EditDistance() = %d, expected 3", got)
+	}
+}
+
+func TestAuditAndApply(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Title: "VAX MACRO PROGRAMMERS GUIDE", PartNum: "AA-1"},
+		"b": {Title: "VAX MACRO PROGRAMMERS GUIDE", PartNum: "AA-2"},
+		"c": {Title: "VAX MACRO PROGRAMMRES GUIDE", PartNum: "AA-3"},
+		"d": {Title: "VAX-11/780 Hardware Handbook", PartNum: "EK-780"},
+		"e": {Title: "VAX-11/750 Hardware Handbook", PartNum: "EK-750"},
+		"f": {Title: "KA630  CPU Module.", PartNum: "EK-KA630-TM"},
+		"g": {Title: "KA630 Technical Manual", PartNum: "EK-KA630-TM"},
+		"h": {Title: "KA630 Technical Manual", PartNum: "EK-KA630-TM"},
+	}
+
+	suggestions := Audit(documents, 0.1)
+	expected := []Suggestion{
+		{Kind: KindFormat, Canonical: "KA630 CPU Module", Variants: []string{"KA630  CPU Module."}},
+		{Kind: KindSimilar, Canonical: "VAX MACRO PROGRAMMERS GUIDE", Variants: []string{"VAX MACRO PROGRAMMRES GUIDE"}},
+		{Kind: KindPartNum, PartNum: "EK-KA630-TM", Canonical: "KA630 Technical Manual", Variants: []string{"KA630  CPU Module."}},
+	}
+	if !reflect.DeepEqual(suggestions, expected) {
+		t.Fatalf("Audit() = %#v", suggestions)
+	}
+
+	// Only the partnum suggestion is wanted for KA630
+	changed := Apply(documents, suggestions[1:])
+	if !reflect.DeepEqual(changed, []string{"c", "f"}) {
+		t.Errorf("Apply() changed %v", changed)
+	}
+	if (documents["c"].Title != "VAX MACRO PROGRAMMERS GUIDE") || (documents["f"].Title != "KA630 Technical Manual") {
+		t.Errorf("Apply() gave %q and %q", documents["c"].Title, documents["f"].Title)
+	}
+}
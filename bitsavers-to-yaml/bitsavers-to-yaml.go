@@ -2,8 +2,18 @@ package main
 
 import (
 	"bufio"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
-	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/enrich"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/md5url"
+	"docs-to-yaml/internal/remotestore"
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
 	"flag"
 	"fmt"
 	"log"
@@ -19,6 +29,12 @@ import (
 //
 // The IndexByDate.txt file does not contain any MD5 data. However the maintainer of manx supplied such
 // data and that is used to fill in the missing MD5 data, which is to be found in site.bitsavers.2021-10-01.md5.
+// Newer dated listings can be given with --md5-listing (which may be repeated): they are merged into the remote store,
+// the newest listing that has a file giving its MD5 checksum, and the store records which listing each checksum came
+// from (see remotestore.Listing).
+//
+// Ctrl-C (or SIGTERM) stops the run cleanly: the Remote Store is saved and the documents made so far are written to a
+// partial catalog (YAML-OUTPUT.partial) rather than the output catalog.
 //
 // Note that currently no command line arguments are accepted, so the "defaults" above are hard-coded!
 
@@ -43,19 +59,43 @@ type Document = document.Document
 
 var bitsavers_prefix = "http://bitsavers.org/pdf/"
 
+// The filepaths in an MD5 listing of the site are relative to this
+const bitsaversSiteUrl = "http://bitsavers.org/"
+
+// The MD5 listing used if --md5-listing is not given
+const DefaultMd5Listing = "data/site.bitsavers.2021-10-01.md5"
+
 func main() {
 
 	var docs []string
 
 	bitsavers_index_filename := "data/bitsavers-IndexByDate.txt"
-	bitsavers_md5_filename := "data/site.bitsavers.2021-10-01.md5"
+	var md5Listings []string
 	// output_file := "bin/bitsavers.yaml"
 	output_file := flag.String("yaml-output", "", "filepath of the output file to hold the generated yaml")
-	verbose := false
-	md5CacheFilename := "bin/md5.store"
-	md5CacheCreate := false
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	splitOutputBy := flag.String("split-output-by", "", "write the output catalog as a directory of one YAML file per collection, volume or format, plus an index")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	md5UrlStoreFilename := flag.String("md5-url-store", "", "filepath of an MD5-to-URL store (e.g. written by manx-to-yaml --md5-output) whose URLs are added to the locations of documents with the same MD5 checksum")
+	flag.Func("md5-listing", "filepath of a dated MD5 listing of the bitsavers site, e.g. site.bitsavers.2024-03-15.md5 (may be repeated; default "+DefaultMd5Listing+" if it exists)", func(s string) error {
+		md5Listings = append(md5Listings, s)
+		return nil
+	})
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store listing documents removed on purpose, which are left out")
+	enrichmentFilename := flag.String("enrichment", enrich.DefaultFilename, "filepath of the enrichment rules applied to the documents before the catalog is written")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	verbosity := console.Flags("Enable verbose reporting")
+	remoteStoreFilename := remotestore.DefaultFilename
 
 	flag.Parse()
+	verbose := *verbosity
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if err := catalog.ValidatePartitioning(*splitOutputBy); err != nil {
+		exitcode.UsageError(err)
+	}
 
 	fatal_error_seen := false
 
@@ -65,15 +105,33 @@ func main() {
 	}
 
 	if fatal_error_seen {
-		log.Fatal("Unable to continue because of one or more fatal errors")
+		exitcode.UsageError("Unable to continue because of one or more fatal errors")
 	}
+	partialCatalogFilename, err := workspace.Companion(*output_file, ".partial")
+	if err != nil {
+		exitcode.UsageErrorf("Bad --yaml-output: %v", err)
+	}
+
+	interrupt.Watch()
 
-	md5StoreInstantiation := persistentstore.Store[string, string]{}
-	md5Store, err := md5StoreInstantiation.Init(md5CacheFilename, md5CacheCreate, verbose)
+	remoteStore, err := remotestore.Open(remoteStoreFilename, verbose)
 	if err != nil {
-		fmt.Printf("Problem initialising MD5 Store: %+v\n", err)
+		exitcode.Warning("Problem initialising Remote Store: %+v\n", err)
 	} else if verbose {
-		fmt.Println("Size of new MD5 store: ", len(md5Store.Data))
+		fmt.Println("Size of Remote store: ", len(remoteStore.Data))
+	}
+
+	if len(md5Listings) == 0 {
+		if _, err := os.Stat(DefaultMd5Listing); err == nil {
+			md5Listings = append(md5Listings, DefaultMd5Listing)
+		}
+	}
+	if len(md5Listings) > 0 {
+		merged, err := MergeMd5Listings(remoteStore, md5Listings, verbose)
+		if err != nil {
+			exitcode.Fatal("Problem reading MD5 listing: ", err)
+		}
+		fmt.Printf("MD5 listings: %d URLs updated from %d listings\n", merged, len(md5Listings))
 	}
 
 	docs = FindAcceptablePaths(bitsavers_index_filename)
@@ -84,13 +142,107 @@ func main() {
 	// If no part number is present, use the title
 	// Look for duplicate (non-empty) MD5 values
 
-	documentsMap := MakeDocumentsFromPaths(bitsavers_md5_filename, docs, md5Store, verbose)
+	documentsMap := MakeDocumentsFromPaths(docs, remoteStore, verbose)
+
+	// The Remote Store is only modified when it is first seeded from the old stores or when MD5 listings are merged
+	remoteStore.Save(remoteStoreFilename)
+
+	// If interrupted, keep the documents made so far
+	if interrupt.Requested() {
+		SavePartialCatalog(partialCatalogFilename, documentsMap)
+		interrupt.Exit("To continue, re-run with the same arguments")
+	}
+
+	if *md5UrlStoreFilename != "" {
+		md5UrlStore, err := md5url.Open(*md5UrlStoreFilename, verbose)
+		if err != nil {
+			exitcode.Fatal("Problem reading MD5-to-URL Store: ", err)
+		}
+		fmt.Printf("Added %d locations from %s\n", AddMd5UrlLocations(documentsMap, md5UrlStore), *md5UrlStoreFilename)
+	}
+
+	// Leave out the documents that were removed on purpose, so that rebuilding the catalog does not bring them back
+	tombstones.ExcludeForCatalog(*tombstonesFilename, documentsMap, verbose)
+
+	// Apply the recurring fixups kept as enrichment rules
+	enrich.ApplyForCatalog(*enrichmentFilename, documentsMap, verbose)
+
+	// Keep any tags and notes that were added by hand to the catalog being replaced
+	if _, err := catalog.Catalog(documentsMap).PreserveAnnotationsFrom(*output_file); err != nil {
+		exitcode.Warning("WARNING: cannot carry forward tags and notes from %s: %s\n", *output_file, err)
+	}
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*output_file, documentsMap, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *output_file)
+			exitcode.Exit()
+		}
+	}
 
 	// Write the output YAML file
-	err = document.WriteDocumentsMapToOrderedYaml(documentsMap, *output_file)
+	err = catalog.SaveSplit(*output_file, documentsMap, *splitOutputBy)
 	if err != nil {
-		log.Fatal("Failed YAML write: ", err)
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documentsMap); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
 	}
+
+	// The run is complete, so any partial catalog is no longer needed
+	if err := os.Remove(partialCatalogFilename); err == nil {
+		fmt.Printf("Removed partial catalog %s\n", partialCatalogFilename)
+	}
+
+	exitcode.Exit()
+}
+
+// Writes the documents found before an interrupt, in a form that catalog.Load can read back.
+func SavePartialCatalog(filename string, documentsMap map[string]Document) {
+	err := checkpoint.SaveState(filename, documentsMap)
+	if err != nil {
+		exitcode.WarningAt("checkpoint-failed", filename, "WARNING: failed to write partial catalog %s: %s\n", filename, err)
+		return
+	}
+	fmt.Printf("Partial catalog: %d documents written to %s\n", len(documentsMap), filename)
+}
+
+// Reads the dated MD5 listings of the bitsavers site and merges them into the remote store, newest wins (see
+// remotestore.Store.MergeListings). Returns the number of URLs whose MD5 checksum changed.
+func MergeMd5Listings(remoteStore *remotestore.Store, filenames []string, verbose bool) (int, error) {
+	var listings []remotestore.Listing
+	for _, filename := range filenames {
+		listing, err := remotestore.ReadListing(filename, bitsaversSiteUrl)
+		if err != nil {
+			return 0, err
+		}
+		if verbose {
+			fmt.Printf("MD5 listing %s (%s): %d files\n", listing.Name, listing.Date, len(listing.Md5s))
+		}
+		listings = append(listings, listing)
+	}
+	return remoteStore.MergeListings(listings...), nil
+}
+
+// Adds the URL recorded in the MD5-to-URL store for each document's MD5 checksum to its locations, as another copy of
+// the same file. Returns the number of documents that gained a location.
+func AddMd5UrlLocations(documentsMap map[string]Document, md5UrlStore *md5url.Store) int {
+	added := 0
+	for key, doc := range documentsMap {
+		url, found := md5url.Lookup(md5UrlStore, doc.Md5)
+		if !found {
+			continue
+		}
+		document.NormaliseLocations(&doc)
+		if document.AddLocations(&doc, document.LocationOf(url, 0)) {
+			documentsMap[key] = doc
+			added += 1
+		}
+	}
+	return added
 }
 
 // Read the bitsavers IndexByDate.txt file and build a set of paths under DEC-related directories
@@ -107,7 +259,7 @@ func FindAcceptablePaths(filename string) []string {
 	// Open the bitsavers index file, complaining loudly on failure
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 	defer file.Close()
 
@@ -165,7 +317,7 @@ func FindAcceptablePaths(filename string) []string {
 
 	// Stop now if any error occurred.
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 
 	sort.Strings(docs)
@@ -210,13 +362,17 @@ func CreateBitsaversDocument(path string) Document {
 // Given a list of file paths for documents on bitsavers, this function
 // analyses each path and turns it into a Document struct.
 //
-// If the file path appears in the available MD5 data file, then that MD5 is used in the Document.
-func MakeDocumentsFromPaths(md5File string, documentPaths []string, md5Store *persistentstore.Store[string, string], verbose bool) map[string]Document {
+// If the file's URL has an MD5 checksum in the remote store, then that MD5 is used in the Document.
+// Once an interrupt has been received the remaining paths are left, and the documents made so far are returned.
+func MakeDocumentsFromPaths(documentPaths []string, remoteStore *remotestore.Store, verbose bool) map[string]Document {
 	droppedDocument := 0
 	duplicateKey := 0
 
 	documentsMap := make(map[string]Document)
 	for _, path := range documentPaths {
+		if interrupt.Requested() {
+			break
+		}
 		if strings.HasPrefix(path, "dec/pdp11/microfiche/Diagnostic_Program_Listings/") || strings.HasPrefix(path, "dec/vax/microfiche/vms-source-listings/") {
 			droppedDocument += 1
 			continue
@@ -237,6 +393,15 @@ func MakeDocumentsFromPaths(md5File string, documentPaths []string, md5Store *pe
 		if part_num_found {
 			newDocument.PartNum = part_num
 			newDocument.Title = title
+			// Some filenames carry more than one part number (e.g. an order number and a document number)
+			for {
+				alt_part_num, rest, found := strings.Cut(newDocument.Title, "_")
+				if !found || !document.ValidateDecPartNumber(alt_part_num) {
+					break
+				}
+				document.AddAltPartNums(&newDocument, alt_part_num)
+				newDocument.Title = rest
+			}
 		} else {
 			// If no "_" found, there is no part number and the whole filename is the title
 			newDocument.PartNum = ""
@@ -269,9 +434,10 @@ func MakeDocumentsFromPaths(md5File string, documentPaths []string, md5Store *pe
 		lookup_key := bitsavers_prefix + path
 		md5_store_found := false
 		md5_store_checksum := ""
-		if md5, found := md5Store.Lookup(lookup_key); found {
+		if md5, found := remoteStore.Md5(lookup_key); found {
 			if verbose {
-				fmt.Printf("MD5 Store: Found %s for %s\n", md5, filename)
+				listing, _ := remoteStore.Md5Listing(lookup_key)
+				fmt.Printf("MD5 Store: Found %s for %s (listing %q)\n", md5, filename, listing)
 			}
 			md5_store_checksum = md5
 			md5_store_found = true
@@ -295,9 +461,12 @@ func MakeDocumentsFromPaths(md5File string, documentPaths []string, md5Store *pe
 			}
 		}
 
-		if _, exists := documentsMap[key]; exists {
+		document.NormaliseLocations(&newDocument)
+		if existing, exists := documentsMap[key]; exists {
 			duplicateKey += 1
 			fmt.Printf("Duplicate key: [%s] (existing = %v\n", key, documentsMap[key])
+			// The same file at another path is another location, not a replacement
+			document.AddLocations(&newDocument, existing.Copies...)
 		}
 		documentsMap[key] = newDocument
 	}
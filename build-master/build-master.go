@@ -0,0 +1,426 @@
+package main
+
+import (
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/pdfa"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/internal/trust"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+//
+// This program combines the catalogs of every collection (e.g. local, bitsavers, manx and vaxhaven) into a single
+// master catalog in which each document appears once, however many collections hold a copy of it.
+//
+// The catalogs are named on the command line, in order of priority, either as NAME=FILEPATH or simply as FILEPATH
+// (when the name is the filename without its extension, e.g. "bitsavers" for bin/yaml/bitsavers.yaml). They may also
+// be listed in a YAML file given with --config, ahead of any on the command line:
+//
+//   - name: local
+//     path: bin/yaml/local.yaml
+//   - name: bitsavers
+//     path: bin/yaml/bitsavers.yaml
+//
+// A reference catalog maintained by someone else (e.g. their bitsavers catalog) is named with --reference, which may
+// be repeated, or marked "readonly: true" in the --config file. Its documents are read-only (see
+// document.MarkReadOnly): each records the catalog it came from as its origin, and is never changed when it is combined
+// with another copy. The catalogs named with --reference come after all others in priority.
+//
+// Duplicates are found in two passes:
+//
+//   1. documents with the same MD5 checksum are the same file, wherever they are held
+//   2. a document with no MD5 checksum (as in the manx and vaxhaven catalogs) is the same as another if they have the
+//      same part number (see document.NormalisePartNumber) and format, and no other document does. Documents whose
+//      partial fingerprints (see fingerprint-catalog) show them to be different files are never matched.
+//
+// The canonical entry for a set of duplicates is chosen by --duplicate-policy (see internal/retention); by default
+// it is the first found, i.e. the copy from the catalog of highest priority. Any fields it lacks are filled in from
+// the other copies, their tags, notes and part numbers are added to it, and their locations are added to its
+// Copies (see document.FileLocation), so that where-is can report every copy.
+//
+// Each catalog, and each volume, may be given a trust level (see internal/trust): "trust: low" in the --config file or
+// --trust NAME=LEVEL for a catalog, and the volume registry (--volumes) for a volume. The title, part number and date
+// of a more trusted copy replace those of the canonical entry, and a low-trust document is matched by part number (in
+// the second pass) only if its title agrees with that of the document it would be matched with.
+//
+// The number of documents read from each catalog, the duplicates combined in each pass and the size of the master
+// catalog are reported, and may also be written as YAML with --stats-output.
+//
+// The master catalog is written in the collation order of --collation (see internal/collate), by default that named by
+// DOCS_TO_YAML_COLLATION or else root, which ignores case and accents; --collation bytes keeps the older byte order.
+//
+// To run the program:
+//   go run build-master/build-master.go --yaml-output bin/yaml/master.yaml local=bin/local.yaml bin/yaml/bitsavers.yaml bin/yaml/manx.yaml
+//
+
+type Document = document.Document
+
+// Source is a catalog to be combined into the master catalog
+type Source = sourceconfig.Source
+
+// Input is the content of one Source
+type Input struct {
+	Name      string
+	Documents catalog.Catalog
+	Trust     trust.Level // How far the catalog's metadata can be relied upon
+}
+
+// SourceStats counts the documents read from one catalog
+type SourceStats struct {
+	Name      string
+	Documents int // Documents in the catalog
+	Combined  int // Documents found to duplicate one from the same or an earlier catalog
+}
+
+// Stats describes how a master catalog was built
+type Stats struct {
+	Sources           []SourceStats
+	CombinedByMd5     int            // Documents combined with another with the same MD5 checksum
+	CombinedByPartNum int            // Documents without an MD5 checksum combined with another with the same part number and format
+	Ambiguous         int            // Documents without an MD5 checksum whose part number and format match more than one document
+	RuledOut          int            // Matches by part number and format ruled out because the fingerprints differ
+	Untrusted         int            // Matches by part number and format ruled out because a low-trust copy's title disagrees
+	Documents         int            // Documents in the master catalog
+	MultipleLocations int            // Documents in the master catalog with more than one location
+	PdfA              map[string]int `yaml:",omitempty"` // PDFs in the master catalog with each PDF/A status (see pdfa-check)
+	PasswordProtected []string       `yaml:",omitempty"` // Filepaths of the documents in the master catalog that cannot be opened without a password
+	Restricted        int            // Documents in the master catalog encrypted only to restrict printing, copying etc.
+}
+
+func main() {
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the catalogs to combine (name and path), in order of priority")
+	var references []Source
+	flag.Func("reference", "a read-only reference catalog, as NAME=FILEPATH or FILEPATH, whose documents are never changed (may be repeated)", func(s string) error {
+		source := sourceconfig.Parse(s)
+		source.ReadOnly = true
+		references = append(references, source)
+		return nil
+	})
+	trustLevels := make(map[string]string)
+	flag.Func("trust", "how far a catalog's metadata can be relied upon, as NAME=LEVEL where LEVEL is low, normal or high (may be repeated)", func(s string) error {
+		name, level, found := strings.Cut(s, "=")
+		if !found || (name == "") {
+			return fmt.Errorf("expected NAME=LEVEL, found %q", s)
+		}
+		trustLevels[name] = level
+		return nil
+	})
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml), for the trust level of each volume")
+	duplicatePolicy := flag.String("duplicate-policy", "", "how to choose the canonical copy of a document: comma-separated rules from first, local, richer, newer and quality (default: first)")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output master catalog")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	splitOutputBy := flag.String("split-output-by", "", "write the output catalog as a directory of one YAML file per collection, volume or format, plus an index")
+	statsOutputFilename := flag.String("stats-output", "", "filepath of an optional YAML file to receive the statistics")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	collate.Flag()
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if err := catalog.ValidatePartitioning(*splitOutputBy); err != nil {
+		exitcode.UsageError(err)
+	}
+
+	if *yamlOutputFilename == "" {
+		exitcode.UsageError("Please supply --yaml-output")
+	}
+	policy, err := retention.Parse(*duplicatePolicy)
+	if err != nil {
+		exitcode.UsageErrorf("--duplicate-policy: %s", err)
+	}
+	sources, err := sourceconfig.Gather(*configFilename, flag.Args())
+	if err != nil {
+		exitcode.UsageErrorf("--config: %s", err)
+	}
+	sources = append(sources, references...)
+	if len(sources) == 0 {
+		exitcode.UsageError("Please supply at least one catalog, with --config or on the command line")
+	}
+
+	registry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+	levels, err := trust.FromRegistry(registry)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+
+	inputs := make([]Input, 0, len(sources))
+	for _, source := range sources {
+		if level, found := trustLevels[source.Name]; found {
+			source.Trust = level
+		}
+		level, err := trust.Parse(source.Trust)
+		if err != nil {
+			exitcode.UsageErrorf("%s: %s", source.Name, err)
+		}
+		load := catalog.Load
+		if source.ReadOnly {
+			load = catalog.LoadReadOnly
+		}
+		documents, err := load(source.Path)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", source.Path, err)
+		}
+		inputs = append(inputs, Input{Name: source.Name, Documents: documents, Trust: level})
+	}
+
+	master, stats := BuildMaster(inputs, policy, levels)
+	if *verbose {
+		for _, key := range master.Keys() {
+			if others := document.OtherLocations(master[key]); len(others) > 0 {
+				fmt.Printf("%s: %s\n", key, master[key].Filepath)
+				for _, filepath := range others {
+					fmt.Printf("    also %s\n", filepath)
+				}
+			}
+		}
+	}
+	for _, source := range stats.Sources {
+		fmt.Printf("%-12s %7d documents, %7d duplicates\n", source.Name, source.Documents, source.Combined)
+	}
+	fmt.Printf("Combined %d by MD5 checksum and %d by part number (%d ambiguous part numbers left alone, %d matches ruled out by fingerprint, %d by untrusted title)\n", stats.CombinedByMd5, stats.CombinedByPartNum, stats.Ambiguous, stats.RuledOut, stats.Untrusted)
+	fmt.Printf("Master catalog has %d documents, %d held in more than one place\n", stats.Documents, stats.MultipleLocations)
+	if len(stats.PdfA) > 0 {
+		var counts []string
+		for _, status := range pdfa.Statuses(stats.PdfA) {
+			counts = append(counts, fmt.Sprintf("%d %s", stats.PdfA[status], status))
+		}
+		fmt.Printf("PDF/A: %s\n", strings.Join(counts, ", "))
+	}
+	// A document that cannot be opened is dead weight unless its password can be found
+	if len(stats.PasswordProtected) > 0 {
+		fmt.Printf("%d documents cannot be opened without a password:\n", len(stats.PasswordProtected))
+		for _, filepath := range stats.PasswordProtected {
+			events.Info("password-protected", filepath, "    PASSWORD: %s\n", filepath)
+		}
+	}
+	if stats.Restricted > 0 {
+		fmt.Printf("%d documents are encrypted to restrict printing or copying\n", stats.Restricted)
+	}
+
+	if *statsOutputFilename != "" {
+		data, err := document.MarshalYaml(stats)
+		if err != nil {
+			exitcode.Fatal("Failed YAML marshal of the statistics: ", err)
+		}
+		if _, err := workspace.WriteFile(*statsOutputFilename, data, 0644); err != nil {
+			exitcode.Fatal("Failed statistics write: ", err)
+		}
+	}
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, master, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.SaveSplit(*yamlOutputFilename, master, *splitOutputBy); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, master); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Returns the key on which documents without an MD5 checksum are matched: the normalised part number and the format.
+// Returns "" for a document without a part number.
+func PublicationKey(doc Document) string {
+	partNum := document.NormalisePartNumber(doc.PartNum)
+	if partNum == "" {
+		return ""
+	}
+	return partNum + " " + doc.Format
+}
+
+// Combines the inputs, which are in order of priority, into a master catalog keyed (where possible) by MD5 checksum.
+// levels gives the trust level of each volume; a document on a volume without one has the level of its input.
+func BuildMaster(inputs []Input, policy retention.Policy, levels trust.Levels) (catalog.Catalog, Stats) {
+	type pending struct {
+		source int
+		doc    Document
+		level  trust.Level
+	}
+	var stats Stats
+	master := make(catalog.Catalog)
+	sourceOf := make(map[string]int)        // master key => index of the input the entry was first found in
+	levelOf := make(map[string]trust.Level) // master key => trust level of the entry's metadata
+	var withoutMd5 []pending
+
+	// Pass 1: documents with the same MD5 checksum
+	for i, input := range inputs {
+		stats.Sources = append(stats.Sources, SourceStats{Name: input.Name, Documents: len(input.Documents)})
+		for _, key := range input.Documents.Keys() {
+			doc := input.Documents[key]
+			level := levels.Of(doc, input.Trust)
+			if doc.Md5 == "" {
+				withoutMd5 = append(withoutMd5, pending{source: i, doc: doc, level: level})
+				continue
+			}
+			if existing, found := master[doc.Md5]; found {
+				master[doc.Md5] = CombineWithTrust(existing, levelOf[doc.Md5], doc, level, policy)
+				levelOf[doc.Md5] = max(levelOf[doc.Md5], level)
+				stats.CombinedByMd5 += 1
+				stats.Sources[i].Combined += 1
+				continue
+			}
+			master[doc.Md5] = doc
+			sourceOf[doc.Md5] = i
+			levelOf[doc.Md5] = level
+		}
+	}
+
+	// Pass 2: documents without an MD5 checksum that match exactly one document by part number and format
+	byPublication := master.IndexBy(PublicationKey)
+	for _, p := range withoutMd5 {
+		publication := PublicationKey(p.doc)
+		var matches []string
+		for _, key := range byPublication[publication] {
+			if hashing.FingerprintsDiffer(p.doc.Fingerprint, master[key].Fingerprint) {
+				stats.RuledOut += 1
+			} else if (min(p.level, levelOf[key]) == trust.Low) && !trust.TitlesAgree(p.doc.Title, master[key].Title) {
+				// A low-trust part number may have been misread, so the titles must confirm the match
+				stats.Untrusted += 1
+			} else {
+				matches = append(matches, key)
+			}
+		}
+		if (publication != "") && (len(matches) == 1) {
+			key := matches[0]
+			if p.source < sourceOf[key] {
+				master[key] = CombineWithTrust(p.doc, p.level, master[key], levelOf[key], policy)
+			} else {
+				master[key] = CombineWithTrust(master[key], levelOf[key], p.doc, p.level, policy)
+			}
+			levelOf[key] = max(levelOf[key], p.level)
+			stats.CombinedByPartNum += 1
+			stats.Sources[p.source].Combined += 1
+			continue
+		} else if len(matches) > 1 {
+			stats.Ambiguous += 1
+		}
+		key := unusedKey(master, document.BuildKeyFromDocument(p.doc), p.doc.Filepath)
+		master[key] = p.doc
+		sourceOf[key] = p.source
+		levelOf[key] = p.level
+		if publication != "" {
+			byPublication[publication] = append(byPublication[publication], key)
+		}
+	}
+
+	stats.Documents = len(master)
+	for _, doc := range master {
+		if len(doc.Copies) > 1 {
+			stats.MultipleLocations += 1
+		}
+	}
+	stats.PdfA = pdfa.Distribution(master)
+	for _, key := range master.Keys() {
+		switch master[key].Encryption {
+		case document.EncryptionPassword:
+			stats.PasswordProtected = append(stats.PasswordProtected, master[key].Filepath)
+		case document.EncryptionRestricted:
+			stats.Restricted += 1
+		}
+	}
+	return master, stats
+}
+
+// Returns key if it is not already in the catalog, otherwise the fallback, otherwise key with a number appended
+func unusedKey(documents catalog.Catalog, key string, fallback string) string {
+	if _, taken := documents[key]; !taken {
+		return key
+	}
+	if _, taken := documents[fallback]; !taken && (fallback != "") {
+		return fallback
+	}
+	for n := 2; ; n++ {
+		numbered := fmt.Sprintf("%s#%d", key, n)
+		if _, taken := documents[numbered]; !taken {
+			return numbered
+		}
+	}
+}
+
+// The Document fields that Combine fills in, if blank, from the copy that is not kept
+var fillFields = []string{"Format", "Size", "Md5", "Title", "PubDate", "PartNum", "PdfCreator", "PdfProducer", "PdfVersion", "PdfModified", "PublicUrl", "Section", "Location", "Fingerprint", "PdfA", "Encryption"}
+
+// Combines two copies of a document, first being the one found first. The policy chooses which is kept (see
+// retention.Policy.Resolve, which also adds the other's locations); the kept copy gains whatever it lacks from the other,
+// unless it is read-only (see document.MarkReadOnly), in which case it is kept exactly as it is.
+func Combine(first Document, second Document, policy retention.Policy) Document {
+	return CombineWithTrust(first, trust.Normal, second, trust.Normal, policy)
+}
+
+// Combines two copies of a document as Combine does, each with the trust level of its metadata. If the copy not kept
+// is the more trusted, its title, part number and date replace those of the kept copy (see trust.Prefer).
+func CombineWithTrust(first Document, firstLevel trust.Level, second Document, secondLevel trust.Level, policy retention.Policy) Document {
+	kept, preferSecond := policy.Resolve(first, second)
+	if document.IsReadOnly(kept) {
+		return kept
+	}
+	other, keptLevel, otherLevel := second, firstLevel, secondLevel
+	if preferSecond {
+		other, keptLevel, otherLevel = first, secondLevel, firstLevel
+	}
+
+	keptValue := reflect.ValueOf(&kept).Elem()
+	otherValue := reflect.ValueOf(other)
+	for _, name := range fillFields {
+		if field := keptValue.FieldByName(name); field.IsZero() {
+			field.Set(otherValue.FieldByName(name))
+		}
+	}
+	// The SHA-256 checksum belongs to the file, so it is only taken from a copy of the same file
+	if (kept.Sha256 == "") && (kept.Md5 == other.Md5) {
+		kept.Sha256 = other.Sha256
+	}
+	// A type given by hand is preferred to one given by the classification rules (see internal/doctype)
+	if codeSet := document.CodeSetFlags["DocType"]; (other.DocType != "") &&
+		((kept.DocType == "") || (strings.Contains(kept.Flags, codeSet) && !strings.Contains(other.Flags, codeSet))) {
+		kept.DocType = other.DocType
+		document.ClearFlags(&kept, codeSet)
+		if strings.Contains(other.Flags, codeSet) {
+			document.SetFlags(&kept, codeSet)
+		}
+	}
+	// A copy withheld from publication withholds the combined document too
+	kept.Visibility = visibility.Stricter(kept.Visibility, other.Visibility)
+	document.AddAltPartNums(&kept, document.PartNumbers(other)...)
+	document.AddTags(&kept, other.Tags...)
+	for _, line := range strings.Split(other.Notes, "\n") {
+		document.AppendNotes(&kept, line)
+	}
+	for _, entry := range other.Provenance {
+		retention.AddProvenance(&kept, entry)
+	}
+	if replaced := trust.Prefer(&kept, keptLevel, other, otherLevel); len(replaced) > 0 {
+		retention.AddProvenance(&kept, fmt.Sprintf("%s from %s: %s trust", strings.ToLower(strings.Join(replaced, ", ")), other.Filepath, otherLevel))
+	}
+	return kept
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/internal/trust"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestBuildMaster(t *testing.T) {
+	local := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Filepath: "file:///DEC_0001/vax/ka630.pdf", Tags: []string{"rare"}},
+		"def": {Format: "PDF", Md5: "def", PartNum: "AA-1234A-TC", Filepath: "file:///DEC_0001/vms/aa1234a.pdf"},
+	}
+	bitsavers := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		"ghi": {Format: "PDF", Md5: "ghi", PartNum: "AA-1234A-TC", Filepath: "http://bitsavers.org/pdf/dec/vms/aa1234a.pdf"},
+	}
+	manx := catalog.Catalog{
+		"EK-KA630-TM-001.pdf": {Format: "PDF", PartNum: "EK-KA630-TM.001", PubDate: "1986-03", Filepath: "http://manx-docs.org/ka630.pdf"},
+		"AA-1234A-TC.pdf":     {Format: "PDF", PartNum: "AA-1234A-TC", Filepath: "http://manx-docs.org/aa1234a.pdf"},
+		"EK-OTHER-UG.txt":     {Format: "TXT", PartNum: "EK-OTHER-UG", Filepath: "http://manx-docs.org/other.txt"},
+	}
+	inputs := []Input{{Name: "local", Documents: local}, {Name: "bitsavers", Documents: bitsavers}, {Name: "manx", Documents: manx}}
+
+	master, stats := BuildMaster(inputs, nil, trust.Levels{})
+	expected := catalog.Catalog{
+		"abc": {
+			Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", PubDate: "1986-03",
+			Filepath: "file:///DEC_0001/vax/ka630.pdf",
+			Copies: []document.FileLocation{
+				{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf"},
+				{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+				{Type: document.RemoteLocation, Url: "http://manx-docs.org/ka630.pdf"},
+			},
+			Tags: []string{"rare"},
+			Provenance: []string{
+				"kept over http://bitsavers.org/pdf/dec/vax/ka630.pdf: first found",
+				"kept over http://manx-docs.org/ka630.pdf: first found",
+			},
+		},
+		"def":             local["def"],
+		"ghi":             bitsavers["ghi"],
+		"AA-1234A-TC.pdf": manx["AA-1234A-TC.pdf"], // Matches both def and ghi, so left alone
+		"EK-OTHER-UG.txt": manx["EK-OTHER-UG.txt"],
+	}
+	if !reflect.DeepEqual(master, expected) {
+		t.Errorf(`BuildMaster() = %#v`, master)
+	}
+	expectedStats := Stats{
+		Sources:           []SourceStats{{Name: "local", Documents: 2}, {Name: "bitsavers", Documents: 2, Combined: 1}, {Name: "manx", Documents: 3, Combined: 1}},
+		CombinedByMd5:     1,
+		CombinedByPartNum: 1,
+		Ambiguous:         1,
+		Documents:         5,
+		MultipleLocations: 1,
+		PdfA:              map[string]int{"unchecked": 4},
+	}
+	if !reflect.DeepEqual(stats, expectedStats) {
+		t.Errorf(`BuildMaster() stats = %+v`, stats)
+	}
+}
+
+func TestBuildMasterFingerprints(t *testing.T) {
+	bitsavers := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf", Fingerprint: "65536:1000:aaaa"},
+	}
+	vaxhaven := catalog.Catalog{
+		"EK-KA630-TM-001": {Format: "PDF", PartNum: "EK-KA630-TM-001", Filepath: "http://www.vaxhaven.com/images/ka630.pdf", Fingerprint: "65536:2000:bbbb"},
+	}
+	master, stats := BuildMaster([]Input{{Name: "bitsavers", Documents: bitsavers}, {Name: "vaxhaven", Documents: vaxhaven}}, nil, trust.Levels{})
+	if (len(master) != 2) || (stats.RuledOut != 1) || (stats.CombinedByPartNum != 0) {
+		t.Errorf(`BuildMaster() with different fingerprints = %+v, %+v`, master, stats)
+	}
+}
+
+func TestCombinePolicy(t *testing.T) {
+	remote := Document{Md5: "abc", Title: "KA630", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}
+	local := Document{Md5: "abc", Filepath: "file:///DEC_0001/vax/ka630.pdf", Copies: []document.FileLocation{
+		{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf"},
+		{Type: document.LocalLocation, Volume: "DEC_0002", Path: "ka630.pdf"},
+	}}
+	combined := Combine(remote, local, retention.Policy{retention.Local})
+	expected := Document{
+		Md5: "abc", Title: "KA630", Filepath: "file:///DEC_0001/vax/ka630.pdf",
+		Copies: []document.FileLocation{
+			{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf"},
+			{Type: document.LocalLocation, Volume: "DEC_0002", Path: "ka630.pdf"},
+			{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		},
+		Provenance: []string{"kept over http://bitsavers.org/pdf/dec/vax/ka630.pdf: local copy preferred"},
+	}
+	if !reflect.DeepEqual(combined, expected) {
+		t.Errorf(`Combine() = %#v`, combined)
+	}
+}
+
+func TestCombineVisibility(t *testing.T) {
+	remote := Document{Md5: "abc", Title: "KA630", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}
+	local := Document{Md5: "abc", Filepath: "file:///DEC_0001/vax/ka630.pdf", Visibility: "restricted"}
+	if combined := Combine(remote, local, nil); combined.Visibility != "restricted" {
+		t.Errorf(`Combine() published a restricted copy: %#v`, combined)
+	}
+}
+
+func TestCombineReadOnly(t *testing.T) {
+	reference := Document{Md5: "abc", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf", Origin: "theirs.yaml"}
+	local := Document{Md5: "abc", Title: "KA630", Tags: []string{"rare"}, Filepath: "file:///DEC_0001/vax/ka630.pdf"}
+	if combined := Combine(reference, local, nil); !reflect.DeepEqual(combined, reference) {
+		t.Errorf(`Combine() changed the read-only document: %#v`, combined)
+	}
+	if combined := Combine(reference, local, retention.Policy{retention.Local}); (combined.Title != "KA630") || (combined.Origin != "") {
+		t.Errorf(`Combine() = %#v`, combined)
+	}
+}
+
+func TestBuildMasterTrust(t *testing.T) {
+	local := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Filepath: "file:///DEC_0001/ka630.pdf"},
+	}
+	manx := catalog.Catalog{
+		"abc":                 {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA63O CPU Modu1e", PubDate: "1986-03", Filepath: "http://manx-docs.org/ka630.pdf"},
+		"EK-KA660-TM-001.pdf": {Format: "PDF", PartNum: "EK-KA630-TM-001", Title: "KA660 CPU Module", Filepath: "http://manx-docs.org/ka660.pdf"},
+	}
+	// manx is read first, so its copy would be kept, but the title of the local copy is the more trusted
+	levels := trust.Levels{Volumes: map[string]trust.Level{"DEC_0001": trust.High}}
+	inputs := []Input{{Name: "manx", Documents: manx, Trust: trust.Low}, {Name: "local", Documents: local}}
+	master, stats := BuildMaster(inputs, nil, levels)
+	if doc := master["abc"]; (doc.Title != local["abc"].Title) || (doc.PubDate != "1986-03") || (doc.Filepath != manx["abc"].Filepath) {
+		t.Errorf(`BuildMaster() with trust levels kept %+v`, doc)
+	}
+	// The low-trust part number is not enough to match a document whose title disagrees
+	if (stats.Untrusted != 1) || (stats.CombinedByPartNum != 0) || (len(master) != 2) {
+		t.Errorf(`BuildMaster() with trust levels = %+v, %+v`, master, stats)
+	}
+}
+
+func TestBuildMasterEncrypted(t *testing.T) {
+	local := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", Filepath: "file:///DEC_0001/secret.pdf", Encryption: document.EncryptionPassword},
+		"def": {Format: "PDF", Md5: "def", Filepath: "file:///DEC_0001/locked.pdf", Encryption: document.EncryptionRestricted},
+	}
+	// The encryption belongs to the file, so a copy without metadata gains it
+	bitsavers := catalog.Catalog{
+		"ghi": {Format: "PDF", Md5: "ghi", Filepath: "http://bitsavers.org/pdf/dec/other.pdf"},
+		"abc": {Format: "PDF", Md5: "abc", Filepath: "http://bitsavers.org/pdf/dec/secret.pdf"},
+	}
+	master, stats := BuildMaster([]Input{{Name: "bitsavers", Documents: bitsavers}, {Name: "local", Documents: local}}, nil, trust.Levels{})
+	if master["abc"].Encryption != document.EncryptionPassword {
+		t.Errorf(`BuildMaster() gave %+v`, master["abc"])
+	}
+	if !reflect.DeepEqual(stats.PasswordProtected, []string{"http://bitsavers.org/pdf/dec/secret.pdf"}) || (stats.Restricted != 1) {
+		t.Errorf(`BuildMaster() stats = %+v`, stats)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/snapshots"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// This program answers questions about the history of a catalog, from the snapshots recorded by snapshot-catalog
+// (see internal/snapshots):
+//
+//   - with --key, "when did this document enter the catalog, and how has it changed?": every snapshot in which the
+//     document was added, changed (with the fields that changed) or removed
+//   - with --at, "what did the catalog look like then?": the catalog as of the last snapshot taken at or before a date
+//     (YYYY-MM-DD, meaning the end of that day) or time (RFC 3339), written to --yaml-output (and/or, as JSON Lines,
+//     --jsonl-output) if given
+//   - otherwise, a list of the snapshots
+//
+// --catalog names the catalog (as it was named to snapshot-catalog); it may be omitted if the store holds only one.
+//
+// To run the program:
+//   go run catalog-history/catalog-history.go --store bin/snapshots --catalog local --key 0123456789abcdef0123456789abcdef
+//   go run catalog-history/catalog-history.go --store bin/snapshots --catalog local --at 2026-01-31 --yaml-output january.yaml
+//
+
+func main() {
+	storeDir := flag.String("store", "bin/snapshots", "the directory holding the snapshot store")
+	name := flag.String("catalog", "", "the name of the catalog, as given to snapshot-catalog (may be omitted if the store holds only one)")
+	key := flag.String("key", "", "list the changes to the document with this key")
+	at := flag.String("at", "", "show the catalog as it was at this date (YYYY-MM-DD) or time (RFC 3339)")
+	yamlOutputFilename := flag.String("yaml-output", "", "with --at, filepath of a file to receive the catalog as it was")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "with --at, filepath of a JSON Lines (NDJSON) copy of the catalog as it was, one document per line")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if (*key != "") && (*at != "") {
+		exitcode.UsageError("Please supply only one of --key and --at")
+	}
+	if (*yamlOutputFilename != "") && (*at == "") {
+		exitcode.UsageError("--yaml-output needs --at")
+	}
+	if (*jsonlOutputFilename != "") && (*at == "") {
+		exitcode.UsageError("--jsonl-output needs --at")
+	}
+
+	store := snapshots.Open(*storeDir)
+	log, err := store.Log()
+	if err != nil {
+		exitcode.Fatal(err)
+	}
+	if len(log) == 0 {
+		exitcode.UsageErrorf("%s holds no snapshots", *storeDir)
+	}
+	if *name == "" {
+		names := CatalogNames(log)
+		if (len(names) > 1) && ((*key != "") || (*at != "")) {
+			exitcode.UsageErrorf("Please supply --catalog: %s holds snapshots of %s", *storeDir, strings.Join(names, ", "))
+		}
+		if len(names) == 1 {
+			*name = names[0]
+		}
+	}
+
+	switch {
+	case *key != "":
+		history, err := store.History(*name, *key)
+		if err != nil {
+			exitcode.Fatal(err)
+		}
+		if len(history) == 0 {
+			exitcode.Warning("WARNING: %s has never been in %s\n", *key, *name)
+		}
+		WriteHistory(os.Stdout, history)
+	case *at != "":
+		when, err := snapshots.ParseTime(*at)
+		if err != nil {
+			exitcode.UsageErrorf("--at: %s", err)
+		}
+		entry, found, err := store.At(*name, when)
+		if err != nil {
+			exitcode.Fatal(err)
+		}
+		if !found {
+			exitcode.UsageErrorf("%s had not been snapshotted by %s", *name, *at)
+		}
+		fmt.Printf("%s at %s: snapshot %.12s of %s, taken %s, %d documents\n", *name, *at, entry.Hash, entry.Path, entry.Time, entry.Documents)
+		if (*yamlOutputFilename != "") || (*jsonlOutputFilename != "") {
+			documents, err := store.Load(entry)
+			if err != nil {
+				exitcode.Fatal(err)
+			}
+			if *yamlOutputFilename != "" {
+				if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+					exitcode.Fatal(err)
+				}
+			}
+			if *jsonlOutputFilename != "" {
+				if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+					exitcode.Fatal(err)
+				}
+			}
+		}
+	default:
+		for _, entry := range log {
+			if (*name == "") || (entry.Catalog == *name) {
+				fmt.Printf("%s  %-12s %.12s  %6d documents  %s\n", entry.Time, entry.Catalog, entry.Hash, entry.Documents, entry.Path)
+			}
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Returns the names of the catalogs in a log, in the order they were first snapshotted
+func CatalogNames(log []snapshots.Entry) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range log {
+		if !seen[entry.Catalog] {
+			seen[entry.Catalog] = true
+			names = append(names, entry.Catalog)
+		}
+	}
+	return names
+}
+
+// Writes the history of a document: one line per snapshot in which it changed, followed by the fields that changed
+func WriteHistory(w io.Writer, history []snapshots.Event) {
+	for _, event := range history {
+		fmt.Fprintf(w, "%s %s (snapshot %.12s)\n", event.Snapshot.Time, event.Kind, event.Snapshot.Hash)
+		for _, field := range event.Fields {
+			fmt.Fprintf(w, "    %s: %q => %q\n", strings.ToLower(field.Field), field.Old, field.New)
+		}
+	}
+}
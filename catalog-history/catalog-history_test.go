@@ -0,0 +1,32 @@
+package main
+
+import (
+	"docs-to-yaml/internal/snapshots"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalogNames(t *testing.T) {
+	log := []snapshots.Entry{{Catalog: "local"}, {Catalog: "bitsavers"}, {Catalog: "local"}}
+	if names := CatalogNames(log); !reflect.DeepEqual(names, []string{"local", "bitsavers"}) {
+		t.Errorf("CatalogNames() = %v", names)
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	history := []snapshots.Event{
+		{Snapshot: snapshots.Entry{Time: "2026-01-10T12:00:00Z", Hash: "0123456789abcdef"}, Kind: catalog.Added},
+		{Snapshot: snapshots.Entry{Time: "2026-03-10T12:00:00Z", Hash: "fedcba9876543210"}, Kind: catalog.Modified, Fields: []catalog.FieldChange{{Field: "Title", Old: "KA630 Manual", New: "KA630 CPU Module Technical Manual"}}},
+	}
+	var out strings.Builder
+	WriteHistory(&out, history)
+	expected := `2026-01-10T12:00:00Z added (snapshot 0123456789ab)
+2026-03-10T12:00:00Z modified (snapshot fedcba987654)
+    title: "KA630 Manual" => "KA630 CPU Module Technical Manual"
+`
+	if out.String() != expected {
+		t.Errorf("WriteHistory() wrote\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/doctype"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+//
+// This program classifies the documents in a catalog by type (manual, print-set, brochure, datasheet, schematic, ...),
+// recording each in the document's doctype, and reports how many documents there are of each type.
+//
+// The type comes from the first matching rule (see internal/doctype): by default, maintenance print sets are recognised
+// by their MP- part numbers, other types by keywords in their titles, and anything else with a DEC manual part number
+// is a manual. --rules FILE gives other rules, as a YAML list. A type given by hand (with tag-catalog --doc-type or
+// edit-catalog --set doctype=...) is left alone unless --force is given; read-only documents are never changed.
+// --where EXPR (see catalog.Expr) restricts the documents classified, e.g. to those with no doctype yet.
+//
+// The catalog is rewritten in place unless --yaml-output is given; --preview shows the changes and asks before writing,
+// so answering no gives just the counts.
+//
+// To run the program:
+//   go run classify-catalog/classify-catalog.go --rules doctypes.yaml bin/local.yaml
+//
+
+type Document = document.Document
+
+func main() {
+	rulesFilename := flag.String("rules", "", "filepath of a YAML list of classification rules (default: the built-in rules)")
+	force := flag.Bool("force", false, "reclassify documents whose type was given by hand")
+	where := flag.String("where", "", "classify only documents matched by this filter expression, e.g. 'not doctype'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	rules, err := doctype.Load(*rulesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("Cannot read the classification rules: %s", err)
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to classify")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	changed := Classify(documents, filter, rules, *force)
+	if *verbose {
+		for _, key := range changed {
+			fmt.Printf("Classified %s as %q\n", key, documents[key].DocType)
+		}
+	}
+	WriteCounts(os.Stdout, documents.Where(filter))
+	fmt.Printf("Changed %d documents\n", len(changed))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Classifies every document matched by filter (all of them if it is nil) that is not read-only.
+// Returns the keys of the documents changed, sorted.
+func Classify(documents catalog.Catalog, filter *catalog.Expr, rules doctype.Rules, force bool) []string {
+	var changed []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if document.IsReadOnly(doc) || !filter.Matches(key, doc) {
+			continue
+		}
+		if doctype.Apply(&doc, rules, force) {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Writes the number of documents of each type, most numerous first, with those of no type last
+func WriteCounts(out io.Writer, documents catalog.Catalog) {
+	counts := make(map[string]int)
+	for _, doc := range documents {
+		counts[doc.DocType] += 1
+	}
+	types := make([]string, 0, len(counts))
+	for docType := range counts {
+		if docType != "" {
+			types = append(types, docType)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if counts[types[i]] != counts[types[j]] {
+			return counts[types[i]] > counts[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	for _, docType := range types {
+		fmt.Fprintf(out, "%-16s %6d\n", docType, counts[docType])
+	}
+	if counts[""] > 0 {
+		fmt.Fprintf(out, "%-16s %6d\n", "(unclassified)", counts[""])
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/doctype"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {PartNum: "MP-01234-00", Title: "KA630 CPU Module"},
+		"b": {PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", DocType: "print-set"},
+		"c": {Title: "RX50 Data Sheet", Origin: "theirs.yaml"},
+		"d": {Title: "VT100 Schematics", Collection: "manx"},
+	}
+	filter, err := catalog.ParseExpr("not collection = manx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := Classify(documents, filter, doctype.DefaultRules, false)
+	if !reflect.DeepEqual(changed, []string{"a"}) || (documents["a"].DocType != "print-set") || (documents["b"].DocType != "print-set") || (documents["c"].DocType != "") {
+		t.Errorf(`Classify() = %q, %+v`, changed, documents)
+	}
+	if changed := Classify(documents, nil, doctype.DefaultRules, true); !reflect.DeepEqual(changed, []string{"b", "d"}) || (documents["b"].DocType != "manual") {
+		t.Errorf(`Classify() with force = %q, %+v`, changed, documents)
+	}
+
+	var out bytes.Buffer
+	WriteCounts(&out, documents)
+	if expected := "manual                1\nprint-set             1\nschematic             1\n(unclassified)        1\n"; out.String() != expected {
+		t.Errorf("WriteCounts() wrote:\n%s", out.String())
+	}
+}
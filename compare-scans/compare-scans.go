@@ -0,0 +1,190 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/phash"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// This program helps decide which of two scans of the same manual to keep, when they are not identical files (so
+// find-duplicates and the duplicate policy do not catch them) but may differ only in resolution or cropping.
+//
+// The candidate pairs are the PDFs of one publication (documents sharing a part number, see catalog.Publications)
+// with different MD5 checksums. Sample pages of each (--pages, by default the first three) are rendered by pdftoppm
+// (from poppler-utils) at --dpi and compared by perceptual hash (see internal/phash), giving each pair a score from 0
+// (nothing alike) to 1 (visually identical). Pairs scoring at least --threshold are reported as the same scan; the
+// size of each file is shown to help choose between them, as the larger is usually the higher resolution, along with
+// its quality score if score-catalog has given it one.
+//
+// Local documents are found under --archive-root, --volume VOLUME=PATH and --tree-root (see internal/locator); a
+// document in a remote root is fetched to a temporary copy for pdftoppm. --where restricts the documents considered. The catalog is not changed.
+//
+// To run the program:
+//   go run compare-scans/compare-scans.go --archive-root /mnt/archive --pages 1,2,3,10 bin/local.yaml
+//
+
+type Document = document.Document
+
+// Pair is two documents that may be scans of the same manual
+type Pair struct {
+	PartNum string // The normalised part number they share
+	A       string // The key of one document
+	B       string // The key of the other
+	Score   float64
+}
+
+// The pages compared if --pages is not given
+var DefaultPages = []int{1, 2, 3}
+
+func main() {
+	locator := locator.Flags()
+	var pages []int
+	flag.Func("pages", "comma-separated page numbers to compare (default 1,2,3)", func(s string) error {
+		var err error
+		pages, err = ParsePages(s)
+		return err
+	})
+	dpi := flag.Int("dpi", 30, "resolution (dots per inch) at which pages are rendered for comparison")
+	threshold := flag.Float64("threshold", 0.9, "score (0 to 1) at or above which a pair is reported as the same scan")
+	where := flag.String("where", "", "consider only documents selected by this filter expression, e.g. 'collection = local'")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that documents can be found")
+	}
+	if pages == nil {
+		pages = DefaultPages
+	}
+	if *dpi < 10 {
+		exitcode.UsageError("--dpi must be at least 10")
+	}
+	if (*threshold < 0) || (*threshold > 1) {
+		exitcode.UsageError("--threshold must be between 0 and 1")
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	pairs := Candidates(documents.Where(filter))
+	if *verbose {
+		fmt.Printf("Comparing %d pairs of scans\n", len(pairs))
+	}
+	hashes := make(map[string][]phash.Hash)
+	hashesOf := func(key string) []phash.Hash {
+		if cached, found := hashes[key]; found {
+			return cached
+		}
+		doc := documents[key]
+		filename, release, err := locator.LocalFile(doc.Filepath)
+		if err == nil {
+			hashes[key], err = phash.PdfHashes(filename, pages, *dpi)
+			release()
+		}
+		if err != nil {
+			exitcode.WarningAt("compare-unreadable", doc.Filepath, "UNCOMPARED: %s (%s)\n", doc.Filepath, err)
+			hashes[key] = nil
+		}
+		return hashes[key]
+	}
+	var scored []Pair
+	for _, pair := range pairs {
+		a, b := hashesOf(pair.A), hashesOf(pair.B)
+		if (a == nil) || (b == nil) {
+			continue
+		}
+		pair.Score = phash.Similarity(a, b)
+		scored = append(scored, pair)
+	}
+
+	WriteReport(os.Stdout, documents, scored, *threshold)
+
+	exitcode.Exit()
+}
+
+// Parses a comma-separated list of page numbers, e.g. "1,2,10"
+func ParsePages(s string) ([]int, error) {
+	var pages []int
+	for _, field := range strings.Split(s, ",") {
+		page, err := strconv.Atoi(strings.TrimSpace(field))
+		if (err != nil) || (page < 1) {
+			return nil, fmt.Errorf("expected page numbers (from 1), found %q", field)
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// Returns every pair of PDFs of the same publication that are not the same file, in order of part number and key
+func Candidates(documents catalog.Catalog) []Pair {
+	pdfs := documents.Filter(func(key string, doc Document) bool { return doc.Format == "PDF" })
+	var pairs []Pair
+	for _, publication := range pdfs.Publications() {
+		for i, a := range publication.Keys {
+			for _, b := range publication.Keys[i+1:] {
+				if (pdfs[a].Md5 != "") && (pdfs[a].Md5 == pdfs[b].Md5) {
+					continue
+				}
+				pairs = append(pairs, Pair{PartNum: publication.PartNum, A: a, B: b})
+			}
+		}
+	}
+	return pairs
+}
+
+// Writes the pairs, most alike first, each with its verdict and the two files, followed by a summary
+func WriteReport(out io.Writer, documents catalog.Catalog, pairs []Pair, threshold float64) {
+	sorted := append([]Pair(nil), pairs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Score > sorted[j].Score })
+	same := 0
+	for _, pair := range sorted {
+		verdict := "look different"
+		if pair.Score >= threshold {
+			verdict = "look like the same scan"
+			same += 1
+			events.Info("same-scan", documents[pair.A].Filepath, "%s and %s look like the same scan (%.2f)", documents[pair.A].Filepath, documents[pair.B].Filepath, pair.Score)
+		}
+		fmt.Fprintf(out, "%.2f %s %s\n", pair.Score, pair.PartNum, verdict)
+		for _, key := range []string{pair.A, pair.B} {
+			fmt.Fprintf(out, "    %12d bytes  %s%s\n", documents[key].Size, documents[key].Filepath, qualityNote(documents[key]))
+		}
+	}
+	fmt.Fprintf(out, "Pairs compared:           %d\n", len(pairs))
+	fmt.Fprintf(out, "Pairs that look the same: %d\n", same)
+}
+
+// Describes the quality score of a document (see score-catalog), if it has one
+func qualityNote(doc Document) string {
+	if doc.Quality == 0 {
+		return ""
+	}
+	return fmt.Sprintf("  (quality %d)", doc.Quality)
+}
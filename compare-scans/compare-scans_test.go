@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCandidates(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Md5: "a", Format: "PDF", PartNum: "EK-KA630-TM-001"},
+		"b": {Md5: "b", Format: "PDF", PartNum: "ek-ka630-tm-001"},
+		"c": {Md5: "a", Format: "PDF", PartNum: "EK-KA630-TM-001"},
+		"d": {Md5: "d", Format: "TXT", PartNum: "EK-KA630-TM-001"},
+		"e": {Md5: "e", Format: "PDF", PartNum: "AA-0196C-TK"},
+		"g": {Md5: "g", Format: "PDF", PartNum: "AA-0196C-TK"},
+		"f": {Md5: "f", Format: "PDF"},
+	}
+	expected := []Pair{
+		{PartNum: "AA0196CTK", A: "e", B: "g"},
+		{PartNum: "EKKA630TM001", A: "a", B: "b"},
+		{PartNum: "EKKA630TM001", A: "b", B: "c"},
+	}
+	if pairs := Candidates(documents); !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("Candidates() = %+v, expected %+v", pairs, expected)
+	}
+}
+
+func TestParsePages(t *testing.T) {
+	if pages, err := ParsePages("1, 2,10"); (err != nil) || !reflect.DeepEqual(pages, []int{1, 2, 10}) {
+		t.Errorf("ParsePages() = %v, %v", pages, err)
+	}
+	for _, bad := range []string{"0", "1,,2", "first"} {
+		if _, err := ParsePages(bad); err == nil {
+			t.Errorf("ParsePages(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Size: 4000000, Filepath: "file:///DEC_0001/ka630.pdf"},
+		"b": {Size: 12000000, Filepath: "file:///DEC_0002/ka630.pdf"},
+		"c": {Size: 3000000, Filepath: "file:///DEC_0003/ka630-draft.pdf"},
+	}
+	pairs := []Pair{{PartNum: "EKKA630TM001", A: "a", B: "c", Score: 0.61}, {PartNum: "EKKA630TM001", A: "a", B: "b", Score: 0.97}}
+	var out bytes.Buffer
+	WriteReport(&out, documents, pairs, 0.9)
+	report := out.String()
+	if !strings.HasPrefix(report, "0.97 EKKA630TM001 look like the same scan\n         4000000 bytes  file:///DEC_0001/ka630.pdf\n        12000000 bytes  file:///DEC_0002/ka630.pdf\n0.61 EKKA630TM001 look different\n") {
+		t.Errorf("WriteReport() wrote:\n%s", report)
+	}
+	if !strings.Contains(report, "Pairs that look the same: 1\n") {
+		t.Errorf("WriteReport() summary:\n%s", report)
+	}
+}
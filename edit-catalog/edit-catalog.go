@@ -0,0 +1,223 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fieldedit"
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// This program corrects individual fields (e.g. a wrong PubDate) of documents in a catalog, without hand-editing the
+// YAML: the catalog is read and written by the same code as every other tool, so its order and formatting are kept.
+//
+// The changes are given with:
+//
+//   --set FIELD=VALUE   sets a field, e.g. --set pubdate=1987-01; a list field (altpartnums, tags) takes a
+//                       comma-separated list
+//   --unset FIELD       clears a field
+//
+// Each may be given more than once. Instead, --remove REASON removes the selected documents, recording a tombstone for
+// each (see internal/tombstones) in --tombstones (by default bin/tombstones.yaml) so that the generators do not bring
+// them back when the catalog is next rebuilt. Fields are named as in the YAML (without regard to case). The fields that identify
+// the file or record how it was chosen (md5, flags, provenance, copies, redirects and origin) cannot be edited.
+// Setting or clearing title, partnum, pubdate or doctype by hand clears the matching flag (T, P, D or K) that says the
+// value was guessed by code.
+//
+// The documents are selected, as for tag-catalog, with any combination of --key, --md5, --part-num, --path (a glob
+// matched against the whole filepath) and --title (a fragment of the title); each may be given more than once and a
+// document is selected if it matches any of them. --where EXPR (see catalog.Expr) further restricts the selection, or
+// on its own selects every document it matches. Read-only documents (taken from a reference catalog) are never changed.
+// The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run edit-catalog/edit-catalog.go --set pubdate=1987-01 --key 0123456789abcdef0123456789abcdef bin/local.yaml
+//
+
+type Document = document.Document
+
+// Edit is one change to a document field (see internal/fieldedit)
+type Edit = fieldedit.Edit
+
+// Returns a flag.Func handler that appends each value to list
+func appendTo(list *[]string) func(string) error {
+	return func(s string) error {
+		*list = append(*list, s)
+		return nil
+	}
+}
+
+func main() {
+	var edits []Edit
+	var query catalog.Query
+	flag.Func("set", "set a field of each selected document, as FIELD=VALUE (repeatable)", func(s string) error {
+		field, value, found := strings.Cut(s, "=")
+		if !found {
+			return fmt.Errorf("expected FIELD=VALUE, found %q", s)
+		}
+		edit, err := fieldedit.NewEdit(field, value, false)
+		edits = append(edits, edit)
+		return err
+	})
+	flag.Func("unset", "clear a field of each selected document (repeatable)", func(s string) error {
+		edit, err := fieldedit.NewEdit(s, "", true)
+		edits = append(edits, edit)
+		return err
+	})
+	flag.Func("key", "select the document with this catalog key (repeatable)", appendTo(&query.Keys))
+	flag.Func("md5", "select the document with this MD5 checksum (repeatable)", appendTo(&query.Md5s))
+	flag.Func("part-num", "select documents with this part number (repeatable)", appendTo(&query.PartNums))
+	flag.Func("path", "select documents whose filepath matches this glob (repeatable)", appendTo(&query.PathGlobs))
+	flag.Func("title", "select documents whose title contains this text, without regard to case (repeatable)", appendTo(&query.Titles))
+	removeReason := flag.String("remove", "", "remove the selected documents for this reason, recording tombstones so that they stay removed")
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store that records removed documents")
+	where := flag.String("where", "", "select only documents matched by this filter expression, e.g. 'collection = local and not pubdate'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if (len(edits) == 0) && (*removeReason == "") {
+		exitcode.UsageError("Please supply at least one --set or --unset, or --remove")
+	} else if (len(edits) > 0) && (*removeReason != "") {
+		exitcode.UsageError("--remove cannot be combined with --set or --unset")
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if query.Empty() && (filter == nil) {
+		exitcode.UsageError("Please select documents with --key, --md5, --part-num, --path, --title or --where")
+	}
+	if err := query.Validate(); err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to edit")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selects := func(key string, doc Document) bool {
+		return (query.Empty() || query.Matches(key, doc)) && filter.Matches(key, doc)
+	}
+	original := documents.Filter(selects)
+	var graveyard *tombstones.Store
+	var selected int
+	var changed, readOnly []string
+	if *removeReason != "" {
+		if graveyard, err = tombstones.Open(*tombstonesFilename, *verbose); err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", *tombstonesFilename, err)
+		}
+		selected, changed, readOnly = RemoveDocuments(documents, selects, graveyard, *removeReason, time.Now())
+	} else {
+		selected, changed, readOnly = EditCatalog(documents, selects, edits)
+	}
+	for _, key := range readOnly {
+		exitcode.WarningAt("read-only", inputFilename, "WARNING: %s is read-only (from %s) and was not changed\n", key, original[key].Origin)
+	}
+	if *verbose {
+		edited := documents.Filter(func(key string, _ Document) bool { _, found := original[key]; return found })
+		catalog.WriteDiff(os.Stdout, catalog.Diff(original, edited), original, edited)
+	}
+	if selected == 0 {
+		exitcode.Warning("WARNING: no document in %s was selected\n", inputFilename)
+	}
+	if graveyard != nil {
+		fmt.Printf("Selected %d documents, removed %d\n", selected, len(changed))
+	} else {
+		fmt.Printf("Selected %d documents, changed %d\n", selected, len(changed))
+	}
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+	// The tombstones are only written once the documents are gone from the catalog
+	if graveyard != nil {
+		graveyard.Save(*tombstonesFilename)
+	}
+
+	exitcode.Exit()
+}
+
+// Applies the edits to every document for which selects returns true, except read-only documents.
+// Returns the number of documents selected, the keys of those actually changed and the keys of the read-only documents
+// left unchanged, each sorted.
+func EditCatalog(documents catalog.Catalog, selects func(key string, doc Document) bool, edits []Edit) (int, []string, []string) {
+	selected := 0
+	var changed, readOnly []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if !selects(key, doc) {
+			continue
+		}
+		selected += 1
+		if document.IsReadOnly(doc) {
+			readOnly = append(readOnly, key)
+			continue
+		}
+		if fieldedit.Apply(&doc, edits) {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return selected, changed, readOnly
+}
+
+// Removes every document for which selects returns true, except read-only documents, recording a tombstone for each
+// with the reason and date. Returns the number of documents selected, the keys of those removed and the keys of the
+// read-only documents left in place, each sorted.
+func RemoveDocuments(documents catalog.Catalog, selects func(key string, doc Document) bool, graveyard *tombstones.Store, reason string, when time.Time) (int, []string, []string) {
+	selected := 0
+	var removed, readOnly []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if !selects(key, doc) {
+			continue
+		}
+		selected += 1
+		if document.IsReadOnly(doc) {
+			readOnly = append(readOnly, key)
+			continue
+		}
+		graveyard.Bury(key, doc, reason, when)
+		delete(documents, key)
+		removed = append(removed, key)
+	}
+	return selected, removed, readOnly
+}
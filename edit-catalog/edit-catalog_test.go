@@ -0,0 +1,56 @@
+package main
+
+import (
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/pkg/catalog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEditCatalog(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Md5: "a", PubDate: "1986"},
+		"b": {Md5: "b", PubDate: "1986", Origin: "bitsavers"},
+		"c": {Md5: "c", PubDate: "1990"},
+	}
+	filter, err := catalog.ParseExpr("pubdate = 1986")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, changed, readOnly := EditCatalog(documents, filter.Matches, []Edit{{Field: "PubDate", Value: "1987"}})
+	if (selected != 2) || !reflect.DeepEqual(changed, []string{"a"}) || !reflect.DeepEqual(readOnly, []string{"b"}) {
+		t.Errorf("EditCatalog() = %d, %q, %q", selected, changed, readOnly)
+	}
+	if (documents["a"].PubDate != "1987") || (documents["b"].PubDate != "1986") || (documents["c"].PubDate != "1990") {
+		t.Errorf("EditCatalog() gave %+v", documents)
+	}
+}
+
+func TestRemoveDocuments(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Md5: "a", PubDate: "1986", Title: "Bad Scan"},
+		"b": {Md5: "b", PubDate: "1986", Origin: "bitsavers"},
+		"c": {Md5: "c", PubDate: "1990"},
+	}
+	filter, err := catalog.ParseExpr("pubdate = 1986")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graveyard, err := tombstones.Open(filepath.Join(t.TempDir(), "tombstones.yaml"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, removed, readOnly := RemoveDocuments(documents, filter.Matches, graveyard, "superseded", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if (selected != 2) || !reflect.DeepEqual(removed, []string{"a"}) || !reflect.DeepEqual(readOnly, []string{"b"}) {
+		t.Errorf("RemoveDocuments() = %d, %q, %q", selected, removed, readOnly)
+	}
+	if _, found := documents["a"]; found || (len(documents) != 2) {
+		t.Errorf("RemoveDocuments() left %+v", documents)
+	}
+	expected := tombstones.Tombstone{Md5: "a", Title: "Bad Scan", Reason: "superseded", Removed: "2024-05-01"}
+	if tombstone, found := graveyard.Lookup("a"); !found || (tombstone != expected) || (len(graveyard.Data) != 1) {
+		t.Errorf("RemoveDocuments() recorded %+v", graveyard.Data)
+	}
+}
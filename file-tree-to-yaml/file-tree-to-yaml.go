@@ -23,23 +23,69 @@ package main
 // (1) Build initial YAML file for a new file tree of documents
 // (2) Take an existing file tree of documents with partial index documents and flesh these out
 //
+// The tree root may also be remote: sftp://[user@]host[:port]/path, smb://[user@]host/share/path or s3://bucket/prefix
+// (see internal/archivefs). The endpoint and credentials for s3:// roots come from --s3-config FILE and/or the usual
+// AWS environment variables; an object whose ETag is its MD5 checksum is not downloaded just to hash it.
+// A remote tree cannot be written to, so --update and --md5sums-output need a local tree root. --exif and --exec
+// need a local file, so a remote file is fetched to a temporary copy for them.
+//
+// --walk-workers N reads up to N directories of the tree at once (see internal/treewalk); on a NAS share or a remote
+// root most of the time taken to walk a big tree goes in waiting for directory listings. The files found are the same,
+// in the same order, whatever N is.
+//
+// --sha256 records the SHA-256 checksum of each file as well as its MD5 checksum, reading the file once for both. MD5
+// is enough to tell documents apart, but SHA-256 is the better record of a file's identity in the long term. A file
+// whose MD5 checksum is already known (from the YAML, md5sums or object storage) is still read if it has no SHA-256
+// checksum yet.
+//
+// The title and part number of an RNO file (or of a MEM file with its RNO source beside it) are read from its .TITLE
+// and .SUBTITLE directives (see internal/runoff), unless they were given by hand.
+//
+// --metadata-config FILE sends the files of chosen formats (e.g. DOC, PS, EPUB) to an Apache Tika server for --exif
+// rather than to exiftool (see pdfmetadata.Config).
+//
+// When mastering a volume, --volume-id ID records the volume in every document's volumeid and, with --volumes FILE,
+// registers it in the volume registry (see internal/volumes) along with the checksums of its index files (taken once
+// the catalog, md5sums and recovery data have been written). --volume-label, --medium, --burn-date and --capacity
+// describe the medium, --location, --container and --slot where it is kept (as reported by where-is), and --trust how far
+// its metadata can be relied upon (see internal/trust); any not given keep their registered values.
+//
 
 import (
-	"crypto/md5"
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/enrich"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/md5sums"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/par2"
 	"docs-to-yaml/internal/pdfmetadata"
-	"encoding/csv"
-	"encoding/hex"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/profiling"
+	"docs-to-yaml/internal/runlimit"
+	"docs-to-yaml/internal/runoff"
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/internal/treewalk"
+	"docs-to-yaml/internal/trust"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
 	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
 type Document = document.Document
@@ -52,6 +98,13 @@ type PathAndVolume struct {
 	Volume string
 }
 
+// PendingExif records a document whose PDF metadata is still to be extracted
+type PendingExif struct {
+	CatalogFilepath string // Key of the document in the map by filepath
+	Name            string // Name of the file within the tree
+	Md5Key          string // Key of the document in the map by MD5
+}
+
 // Md5Cache records information about the MD5 cache itself.
 type Md5Cache struct {
 	Active           bool              // True if the cache is in use
@@ -64,21 +117,132 @@ type Md5Cache struct {
 // For each entry, parses the specified HTML file.
 // Finally outputs the cumulative YAML file.
 func main() {
-	verbose := flag.Bool("verbose", false, "Enable verbose reporting")
+	verbose := console.Flags("Enable verbose reporting")
 	fnfList := flag.Bool("fnf-list", false, "Report file not found")
 	fnfDiscard := flag.Bool("fnf-discard", false, "Report file not found")
 	yamlOutputFilename := flag.String("yaml", "", "filepath of the output file to hold the generated yaml")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	summaryOutputFilename := flag.String("summary-output", "", "filepath of a YAML summary of each directory (documents, size, formats and representative titles), for browsing a large volume")
+	splitOutputBy := flag.String("split-output-by", "", "write the output catalog as a directory of one YAML file per collection, volume or format, plus an index")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
 	md5Gen := flag.Bool("md5-sum", false, "Enable generation of MD5 sums")
+	sha256Gen := flag.Bool("sha256", false, "also record SHA-256 checksums, computed in the same pass as the MD5 sums (needs --md5-sum)")
 	exifRead := flag.Bool("exif", false, "Enable EXIF reading")
+	exifCacheFilename := flag.String("exif-cache", "", "filepath of the file that holds the MD5 => PDF metadata cache")
+	exifCacheCreate := flag.Bool("exif-create-cache", false, "allow for the case of a non-existent PDF metadata cache file")
+	refreshExif := flag.Bool("refresh-exif", false, "ignore any cached PDF metadata and extract it again")
+	walkWorkers := flag.Int("walk-workers", treewalk.DefaultWorkers, "number of directories to read concurrently while walking the tree (1 reads one at a time)")
+	exifWorkers := flag.Int("exif-workers", pipeline.DefaultWorkers(), "number of PDF metadata extractions to run concurrently")
 	treeRoot := flag.String("tree-root", "", "root of the tree for which YAML should be generated")
 	update := flag.Bool("update", false, "Enable verbose reporting")
+	md5sumsInput := flag.Bool("md5sums-input", false, "Take MD5 sums from the md5sums file in the tree root, where present")
+	md5sumsOutput := flag.Bool("md5sums-output", false, "Write an md5sums file covering every file in the tree root")
+	par2Redundancy := flag.Int("par2-redundancy", 0, "create PAR2 recovery data of this percentage for every file in the tree root (0 disables)")
+	autosaveFiles := flag.Int("autosave-files", 0, "checkpoint the partial catalog and PDF metadata cache after this many files (0 disables)")
+	autosaveMinutes := flag.Int("autosave-minutes", 0, "checkpoint the partial catalog and PDF metadata cache after this many minutes (0 disables)")
+	resume := flag.Bool("resume", false, "resume from the partial catalog left by an interrupted run")
+	limit := flag.Int("limit", 0, "process at most this many files (0 means no limit)")
+	pathPrefix := flag.String("path-prefix", "", "process only files whose path within the tree starts with this prefix")
+	since := flag.String("since", "", "process only files modified on or after this date (YYYY-MM-DD)")
+	execCommand := flag.String("exec", "", "a command, with {path} and {md5} placeholders, to run for each document; key=value lines it prints are merged into the document")
+	s3ConfigFilename := flag.String("s3-config", "", "filepath of a YAML file giving the endpoint and credentials for an s3:// tree root")
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml) in which to register the volume given by --volume-id")
+	var volume volumes.Volume
+	volumeID := flag.String("volume-id", "", "the ID of the volume being catalogued (e.g. DEC_0042), recorded in each document")
+	flag.StringVar(&volume.Label, "volume-label", "", "the label of the volume, for the volume registry")
+	flag.StringVar(&volume.Medium, "medium", "", "the kind of medium holding the volume (e.g. CD-R, DVD-R, BD-R, HDD), for the volume registry")
+	flag.StringVar(&volume.BurnDate, "burn-date", "", "the date the medium was written (YYYY-MM-DD), for the volume registry")
+	flag.StringVar(&volume.Location, "location", "", "where the medium is kept (e.g. 'Study, shelf 2'), for the volume registry")
+	flag.StringVar(&volume.Container, "container", "", "the binder or box holding the medium, for the volume registry")
+	flag.StringVar(&volume.Slot, "slot", "", "the slot in the container holding the medium (e.g. 'sleeve 12'), for the volume registry")
+	flag.Int64Var(&volume.Capacity, "capacity", 0, "the capacity of the medium in bytes, for the volume registry")
+	flag.StringVar(&volume.Trust, "trust", "", "how far the volume's metadata can be relied upon (low, normal or high), for the volume registry")
+	metadataConfigFilename := flag.String("metadata-config", "", "filepath of a YAML file choosing the metadata backend (exiftool or tika) for each format")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file (see 'go tool pprof')")
+	memProfile := flag.String("memprofile", "", "write a memory profile to this file when the run ends")
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store listing documents removed on purpose, which are left out")
+	enrichmentFilename := flag.String("enrichment", enrich.DefaultFilename, "filepath of the enrichment rules applied to the documents before the catalog is written")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	statistics := flag.Bool("statistics", false, "report hashing, cache and exiftool metrics when the run ends")
+	metricsLogFilename := flag.String("metrics-log", "", "filepath of a file to which this run's hashing, cache and exiftool metrics are appended as NDJSON")
 
 	flag.Parse()
 
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if err := catalog.ValidatePartitioning(*splitOutputBy); err != nil {
+		exitcode.UsageError(err)
+	}
+	if err := profiling.Start(*cpuProfile, *memProfile); err != nil {
+		exitcode.UsageErrorf("Cannot start profiling: %s", err)
+	}
+	metrics.Start(*statistics, *metricsLogFilename)
+	metrics.SetScope(*volumeID)
+
+	interrupt.Watch()
+
 	var err error
 
 	if *yamlOutputFilename == "" {
-		log.Fatal("Please supply a filespec for the output YAML")
+		exitcode.UsageError("Please supply a filespec for the output YAML")
+	}
+	if *treeRoot == "" {
+		exitcode.UsageError("Please supply a --tree-root")
+	}
+
+	// Files outside any limits are left exactly as they were in the seed YAML
+	limits, err := runlimit.New(*limit, "", *pathPrefix, *since)
+	if err != nil {
+		exitcode.Fatal(err)
+	}
+	if limits != nil {
+		fmt.Printf("Partial run: %s\n", limits)
+	}
+
+	execHook, err := exechook.Parse(*execCommand)
+	if err != nil {
+		exitcode.UsageErrorf("--exec: %s", err)
+	}
+
+	s3Config, err := archivefs.ReadS3Config(*s3ConfigFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--s3-config: %s", err)
+	}
+	archivefs.ConfigureS3(s3Config)
+
+	metadataConfig, err := pdfmetadata.ReadConfig(*metadataConfigFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--metadata-config: %s", err)
+	}
+
+	if (*volumesFilename != "") && (*volumeID == "") {
+		exitcode.UsageError("--volumes needs --volume-id to say which volume is being catalogued")
+	}
+	if _, err := trust.Parse(volume.Trust); err != nil {
+		exitcode.UsageErrorf("--trust: %s", err)
+	}
+	volumeRegistry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+	pdfmetadata.Configure(metadataConfig)
+
+	// Paths recorded in the YAML are relative to the tree root and always use "/" as the separator
+	// (see fsutil), so that the same YAML is produced on Linux and on Windows.
+	treePrefix := *treeRoot
+	if !archivefs.IsRemote(treePrefix) {
+		treePrefix = filepath.Clean(treePrefix)
+	}
+	treeFS, err := archivefs.Open(treePrefix)
+	if err != nil {
+		exitcode.UsageErrorf("--tree-root: %s", err)
+	}
+	if *sha256Gen && !*md5Gen {
+		exitcode.UsageError("--sha256 needs --md5-sum")
+	}
+	if archivefs.IsRemote(treePrefix) && (*update || *md5sumsOutput || (*par2Redundancy != 0)) {
+		exitcode.UsageError("--update, --md5sums-output and --par2-redundancy need a local --tree-root")
 	}
 
 	var mapByMd5 map[string]Document = make(map[string]Document)
@@ -90,7 +254,7 @@ func main() {
 		/* TODO read CSV file into Document objects*/
 		csvMapByMd5, err = LoadCSV(*treeRoot)
 		if err != nil {
-			log.Fatalf("impossible to process CSV: %s", err)
+			exitcode.Fatalf("impossible to process CSV: %s", err)
 		}
 	} else {
 		fmt.Println("CSV NOT specified")
@@ -99,23 +263,36 @@ func main() {
 	var yamlSource = *yamlOutputFilename
 
 	if *update {
-		yamlSource = *treeRoot
-		if (*treeRoot)[len(*treeRoot)-1:] != "/" {
-			yamlSource += "/"
-		}
-		yamlSource += "index.yaml"
+		yamlSource = filepath.Join(*treeRoot, "index.yaml")
 	}
 
 	// TODO:
 	// --update means produce updated YAML from index.yaml and index.csv (at least one must exist)
 	//
 
+	// During a long run the catalog built so far is checkpointed periodically to a partial catalog.
+	// Resuming simply seeds the YAML from that partial catalog: files already present with an MD5 checksum are not hashed again.
+	autosavePolicy := checkpoint.Policy{EveryItems: *autosaveFiles, Every: time.Duration(*autosaveMinutes) * time.Minute}
+	partialCatalogFilename, err := workspace.Companion(*yamlOutputFilename, ".partial")
+	if err != nil {
+		exitcode.UsageErrorf("Bad --yaml: %v", err)
+	}
+	partialCatalogTimer := checkpoint.NewTimer(autosavePolicy)
+	if *resume {
+		if _, err := os.Stat(partialCatalogFilename); err == nil {
+			yamlSource = partialCatalogFilename
+		} else {
+			fmt.Printf("No partial catalog %s found: starting from the beginning\n", partialCatalogFilename)
+		}
+	}
+
 	// Start by reading the output yaml file.
 	fmt.Printf("Seeding YAML with %s\n", yamlSource)
-	initialData, err := YamlDataInit(yamlSource)
+	initialData, err := catalog.LoadIfExists(yamlSource)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
+	fmt.Printf("Initial  number of YAML entries: %d\n", len(initialData))
 
 	// The documents map is keyed on the path
 
@@ -126,24 +303,37 @@ func main() {
 	//  update MD5 if requested and not already specified
 	//  update PDF data if requested and not already specified
 
-	// Work out how long the root path is; this will be removed from the result to leave a relative path.
-	// (Ensure that the prefix finishes with a /)
-	treePrefix := *treeRoot
-	if treePrefix[len(treePrefix)-1:] != "/" {
-		treePrefix += "/"
+	// If requested, load any existing md5sums file so that its checksums can be used instead of recalculating them
+	existingMd5sums := make(map[string]string)
+	if *md5sumsInput {
+		entries, err := md5sums.ReadFS(treeFS, md5sums.Md5sumsFilename)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			exitcode.Fatalf("impossible to read %s: %s", md5sums.Md5sumsFilename, err)
+		}
+		existingMd5sums = md5sums.ToMap(entries)
+		fmt.Printf("Loaded %d MD5 sums from %s\n", len(existingMd5sums), md5sums.Md5sumsFilename)
 	}
-	treePrefixLength := len(treePrefix)
+
+	// Files with unusable names, or that cannot be read, are recorded here and reported at the end rather than stopping the scan.
+	var problemFilenames fsutil.ProblemFilenames
 
 	// Accumulate the path to each file under the root, ignoring any directories.
 	var relativePaths []string
-	err = filepath.WalkDir(*treeRoot, func(path string, d fs.DirEntry, err error) error {
+	err = treewalk.WalkDir(treeFS, ".", *walkWorkers, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d == nil {
+				return err
+			}
+			problemFilenames.Add(treeFS.Location(path), err.Error())
+			return nil
+		}
 		if !d.IsDir() {
-			relativePaths = append(relativePaths, path[treePrefixLength:])
+			relativePaths = append(relativePaths, path)
 		}
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("impossible to walk directories: %s", err)
+		exitcode.Fatalf("impossible to walk directories: %s", err)
 	}
 
 	for _, v := range initialData {
@@ -152,13 +342,13 @@ func main() {
 			md5 = v.Filepath
 		}
 		if _, found := mapByMd5[md5]; found {
-			fmt.Printf("WARNING: non-unique MD5 %s for %s and %s - dropped latter\n", v.Md5, mapByMd5[v.Md5].Filepath, v.Filepath)
+			exitcode.WarningAt("duplicate-md5", v.Filepath, "WARNING: non-unique MD5 %s for %s and %s - dropped latter\n", v.Md5, mapByMd5[v.Md5].Filepath, v.Filepath)
 		} else {
 			mapByMd5[md5] = v
 		}
 
 		if _, found := mapByFilepath[v.Filepath]; found {
-			fmt.Printf("WARNING: non-unique filepath %s for %s and %s - dropped latter\n", v.Filepath, mapByMd5[v.Filepath].Filepath, v.Filepath)
+			exitcode.WarningAt("duplicate-filepath", v.Filepath, "WARNING: non-unique filepath %s for %s and %s - dropped latter\n", v.Filepath, mapByMd5[v.Filepath].Filepath, v.Filepath)
 			delete(mapByMd5, v.Filepath) // Eliminate the matching MD5 entry too
 		} else {
 			mapByFilepath[v.Filepath] = v
@@ -166,21 +356,37 @@ func main() {
 	}
 
 	if len(mapByMd5) != len(mapByFilepath) {
-		log.Fatalf("After all processing, MD5 and Filepath maps are different sizes; %d docs listed by MD5 and %d listed by filepath\n", len(mapByMd5), len(mapByFilepath))
+		exitcode.Fatalf("After all processing, MD5 and Filepath maps are different sizes; %d docs listed by MD5 and %d listed by filepath\n", len(mapByMd5), len(mapByFilepath))
 	} else {
 		fmt.Printf("After loading and processing YAML file, %d documents are known (by filepath and by MD5).\n", len(mapByFilepath))
 	}
 
+	var pendingExif []PendingExif
+
 	for _, relativeFilepath := range relativePaths {
+		if interrupt.Requested() {
+			break
+		}
+
 		// Some 'index' files are added to a local file tree for tracking and cataloguing purposes.
 		// These are not part of the original data set and should not be recorded as a Document.
-		if (relativeFilepath == "index.csv") || (relativeFilepath == "index.yaml") || (relativeFilepath == "index.pdf") || (relativeFilepath == "index.txt") || (relativeFilepath == "index.html") {
+		if (relativeFilepath == indexcsv.IndexFilename) || (relativeFilepath == "index.yaml") || (relativeFilepath == "index.pdf") || (relativeFilepath == "index.txt") || (relativeFilepath == "index.html") || (relativeFilepath == md5sums.Md5sumsFilename) || par2.IsRecoveryFile(relativeFilepath) {
 			continue
 		}
 
-		doc, found := mapByFilepath[relativeFilepath]
+		// Names that are not valid UTF-8 are escaped before being recorded in the YAML.
+		// The unescaped name is still used to access the file itself.
+		problemFilenames.Check(relativeFilepath)
+		catalogFilepath := fsutil.EscapeInvalidUTF8(relativeFilepath)
+		fullPath := treeFS.Location(relativeFilepath)
+
+		if !limits.FileSelectedFS(treeFS, relativeFilepath) {
+			continue
+		}
+
+		doc, found := mapByFilepath[catalogFilepath]
 		if !found {
-			doc = CreateLocalDocument(relativeFilepath)
+			doc = CreateLocalDocument(catalogFilepath)
 		}
 		originalMd5 := doc.Md5
 
@@ -197,63 +403,100 @@ func main() {
 			doc.PartNum = data.PartNum
 			document.SetFlags(&doc, "P")
 		}
+		document.AddAltPartNums(&doc, data.AltPartNums...)
 		if doc.PubDate == "" {
 			doc.PubDate = data.PubDate
 			document.SetFlags(&doc, "D")
 		}
 
-		fullPath := treePrefix + doc.Filepath
+		// The RUNOFF source of a manual (or of its formatted MEM output) knows its real title and part number
+		if (doc.Format == "RNO") || (doc.Format == "MEM") {
+			result, found, err := runoff.Read(treeFS, relativeFilepath)
+			if err != nil {
+				problemFilenames.Add(relativeFilepath, fmt.Sprintf("cannot read RUNOFF directives: %s", err))
+			} else if found && runoff.Apply(&doc, result) && *verbose {
+				fmt.Printf("Title %q, part number %q read from the RUNOFF directives of %s\n", doc.Title, doc.PartNum, fullPath)
+			}
+		}
 
-		// Calculate the MD5 checksum if requested and not already present
+		// Calculate the MD5 checksum (and the SHA-256 checksum, with --sha256) if requested and not already present
 
 		if *md5Gen {
-			if doc.Md5 == "" {
+			if existingMd5, found := existingMd5sums[relativeFilepath]; found && (doc.Md5 == "") {
+				metrics.Add(metrics.Md5CacheHits, 1)
+				doc.Md5 = existingMd5
+			}
+			if knownMd5, known := archivefs.KnownMd5(treeFS, relativeFilepath); known && (doc.Md5 == "") {
+				doc.Md5 = knownMd5
+			}
+			// Neither md5sums nor object storage knows a SHA-256 checksum, so a file without one is read, computing
+			// both checksums in the one pass
+			if (doc.Md5 == "") || (*sha256Gen && (doc.Sha256 == "")) {
+				algorithms := hashing.MD5
+				if *sha256Gen {
+					algorithms |= hashing.SHA256
+				}
 				if *verbose {
 					fmt.Println("Calculating MD5 for ", fullPath)
 				}
-				fileBytes, err := os.ReadFile(fullPath)
+				metrics.Add(metrics.Md5CacheMisses, 1)
+				// The size is a by-product of hashing, so record it to save a separate stat
+				digests, err := hashing.HashFS(treeFS, relativeFilepath, algorithms)
 				if err != nil {
-					log.Fatalf("Cannot compute MD5 for %s: %s", fullPath, err)
+					problemFilenames.Add(relativeFilepath, fmt.Sprintf("cannot compute MD5: %s", err))
+					continue
+				}
+				doc.Md5 = digests.Md5
+				if *sha256Gen {
+					doc.Sha256 = digests.Sha256
+				}
+				if doc.Size == 0 {
+					doc.Size = digests.Size
 				}
-				md5Hash := md5.Sum(fileBytes)
-				md5Checksum := hex.EncodeToString(md5Hash[:])
-				doc.Md5 = md5Checksum
 			}
 		}
 
+		// Let the --exec hook (if any) supply metadata; this may change the document's key
+		if execHook != nil {
+			RunExecHook(execHook, &doc, treeFS, relativeFilepath)
+		}
+
 		md5Key := document.BuildKeyFromDocument(doc)
 
-		// Read the EXIF data if requested and any of it is missing
+		// Queue the EXIF data to be read if requested and any of it is missing.
+		// The extraction itself is slow, so it is performed in parallel once all files have been seen.
 		// TOOD only do this if the format is PDF!
 		if *exifRead {
 			if (doc.PdfCreator == "") || (doc.PdfProducer == "") || (doc.PdfVersion == "") || (doc.PdfModified == "") {
-				pdfMetadata := pdfmetadata.ExtractPdfMetadata(fullPath)
-
-				doc.PdfCreator = pdfMetadata.Creator
-				doc.PdfProducer = pdfMetadata.Producer
-				doc.PdfVersion = pdfMetadata.Format
-				doc.PdfModified = pdfMetadata.Modified
+				pendingExif = append(pendingExif, PendingExif{CatalogFilepath: catalogFilepath, Name: relativeFilepath, Md5Key: md5Key})
 			}
 		}
 
 		// Query the file size, unless it is already known
 		if doc.Size == 0 {
-			filestats, err := os.Stat(fullPath)
+			filestats, err := treeFS.Stat(relativeFilepath)
 			if err != nil {
-				log.Fatal(err)
+				problemFilenames.Add(relativeFilepath, fmt.Sprintf("cannot determine size: %s", err))
+				continue
 			}
 			doc.Size = filestats.Size()
 		}
 
 		// Update the map entry in case it has changed
-		mapByFilepath[relativeFilepath] = doc
+		document.NormaliseLocations(&doc)
+		mapByFilepath[catalogFilepath] = doc
 		// MD5 checksum may have changed: if so, remove the old entry from the map keyed on MD5 checksum
 		if originalMd5 != md5Key {
 			delete(mapByMd5, originalMd5)
 		}
+		// Another copy of the same file elsewhere in the tree is another location of the document
+		if existing, found := mapByMd5[md5Key]; found && (existing.Filepath != doc.Filepath) {
+			document.AddLocations(&doc, existing.Copies...)
+		}
 		mapByMd5[md5Key] = doc
-		if *verbose {
-			fmt.Printf("Added MD5 map entry key=%s title=%s\n", md5Key, doc.Title)
+		console.Debugf("Added MD5 map entry key=%s title=%s\n", md5Key, doc.Title)
+		if partialCatalogTimer.Add(1) {
+			SavePartialCatalog(partialCatalogFilename, mapByFilepath)
 		}
 
 		pathOK, badChar, nonAsciiChar := CheckPathForInadvisableCharacters(doc.Filepath)
@@ -269,6 +512,30 @@ func main() {
 		}
 	}
 
+	// If interrupted while scanning, save the documents processed so far and explain how to carry on
+	if interrupt.Requested() {
+		SavePartialCatalog(partialCatalogFilename, mapByFilepath)
+		interrupt.Exit("To continue, re-run with the same arguments plus --resume")
+	}
+
+	// Extract any outstanding PDF metadata in parallel and merge the results back in the original file order
+	if len(pendingExif) > 0 {
+		fmt.Printf("Extracting PDF metadata for %d files using %d workers\n", len(pendingExif), *exifWorkers)
+		exifCacheInstantiation := pdfmetadata.Cache{}
+		exifCache, err := exifCacheInstantiation.Init(*exifCacheFilename, *exifCacheCreate, *verbose)
+		if err != nil {
+			exitcode.Warning("Problem initialising PDF metadata cache: %+v\n", err)
+		}
+		exifCache.EnableAutosave(*exifCacheFilename, autosavePolicy)
+		MergePdfMetadata(treeFS, pendingExif, exifCache, *refreshExif, *exifWorkers, mapByFilepath, mapByMd5)
+		exifCache.Save(*exifCacheFilename)
+		// Metadata extracted before the interrupt is safe in the cache, so a resumed run will not extract it again
+		if interrupt.Requested() {
+			SavePartialCatalog(partialCatalogFilename, mapByFilepath)
+			interrupt.Exit("To continue, re-run with the same arguments plus --resume")
+		}
+	}
+
 	// If MD5 checksums have been generated, then there should be no blank MD5 checksums and there
 	// should be no documents where the MD5 checksum matches the filepath (at least if we ignore the pathological case
 	// of a document that is named for its MD5 checksum!).
@@ -283,19 +550,47 @@ func main() {
 		}
 	}
 
+	// If requested, create PAR2 recovery data for every file in the tree (other than md5sums, which is written afterwards
+	// so that it can cover the recovery files too). The tree's files then include the new recovery files instead of any old ones.
+	if *par2Redundancy != 0 {
+		var protected []string
+		for _, relativeFilepath := range relativePaths {
+			if (relativeFilepath != md5sums.Md5sumsFilename) && !par2.IsRecoveryFile(relativeFilepath) {
+				protected = append(protected, relativeFilepath)
+			}
+		}
+		manifest, err := par2.Create(treePrefix, protected, *par2Redundancy)
+		if err != nil {
+			exitcode.Fatalf("impossible to create PAR2 recovery data: %s", err)
+		}
+		fmt.Printf("Created %d PAR2 recovery files (%d%% redundancy) protecting %d files\n", len(manifest.Files), manifest.Redundancy, manifest.Protected)
+		relativePaths = append(protected, manifest.Files...)
+		relativePaths = append(relativePaths, par2.ManifestFilename)
+	}
+
+	// If requested, write an md5sums file that covers every file in the tree, including the index files but not md5sums itself.
+	// This replaces build-md5sums.sh when mastering a new archive volume.
+	if *md5sumsOutput {
+		err = WriteMd5sums(treePrefix, relativePaths, mapByFilepath, &problemFilenames, *verbose)
+		if err != nil {
+			exitcode.Fatalf("impossible to write %s: %s", md5sums.Md5sumsFilename, err)
+		}
+	}
+
 	// Ensure that each document is listed
 	fmt.Println("Finished with this many documents by filepath: ", len(mapByFilepath), " and this many by MD5: ", len(mapByMd5))
 
 	if *fnfList || *fnfDiscard {
 		for k, d := range mapByFilepath {
-			fullPath := treePrefix + d.Filepath
+			relativeFilepath := d.Filepath
 			if d.Filepath == "" && (k != d.Md5) {
-				fullPath = treePrefix + k
+				relativeFilepath = k
 			}
+			fullPath := treeFS.Location(relativeFilepath)
 			if *verbose {
 				fmt.Println("checking ", fullPath)
 			}
-			if _, err := os.Stat(fullPath); errors.Is(err, os.ErrNotExist) {
+			if _, err := fs.Stat(treeFS, relativeFilepath); errors.Is(err, fs.ErrNotExist) {
 				if *fnfList {
 					fmt.Println("Non-existent file found in YAML    :", fullPath)
 				}
@@ -354,6 +649,7 @@ func main() {
 			// Update the URL if appropriate
 			if (doc.PublicUrl != d.PublicUrl) && (doc.PublicUrl == "") {
 				doc.PublicUrl = d.PublicUrl
+				document.NormaliseLocations(&doc)
 				mapEntryUpdated = true
 				fmt.Printf("Updated URL for %s from CSV (%s): %s\n", doc.Md5, doc.Title, doc.PublicUrl)
 			}
@@ -381,65 +677,97 @@ func main() {
 	if *verbose {
 		fmt.Printf("Saving %d documents\n", len(mapByMd5))
 	}
+	if *volumeID != "" {
+		for key, doc := range mapByMd5 {
+			doc.VolumeID = *volumeID
+			mapByMd5[key] = doc
+		}
+	}
 
-	// Write the output YAML file
-	err = document.WriteDocumentsMapToOrderedYaml(mapByMd5, *yamlOutputFilename)
-	if err != nil {
-		log.Fatal("Failed YAML write: ", err)
+	// Leave out the documents that were removed on purpose, so that rebuilding the catalog does not bring them back
+	tombstones.ExcludeForCatalog(*tombstonesFilename, mapByMd5, *verbose)
+
+	// Apply the recurring fixups kept as enrichment rules
+	enrich.ApplyForCatalog(*enrichmentFilename, mapByMd5, *verbose)
+
+	problemFilenames.Report(os.Stdout)
+	exitcode.AddWarnings(len(problemFilenames.Entries))
+	for _, entry := range problemFilenames.Entries {
+		events.Emit(events.SeverityWarning, "problem-filename", entry.Path, entry.Problem)
 	}
 
-}
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, mapByMd5, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
 
-func YamlDataInit(filename string) (map[string]Document, error) {
-	documents := make(map[string]Document)
-	file, err := os.ReadFile(filename)
+	// Write the output YAML file
+	err = catalog.SaveSplit(*yamlOutputFilename, mapByMd5, *splitOutputBy)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return documents, nil
-		} else {
-			return documents, err
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, mapByMd5); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+	if *summaryOutputFilename != "" {
+		if err := catalog.SaveSummary(*summaryOutputFilename, mapByMd5); err != nil {
+			exitcode.Fatal("Failed summary write: ", err)
 		}
 	}
-	// Read the existing cache YAML data into the cache
-	err = yaml.Unmarshal(file, documents)
+
+	// Register the volume now that its index files are final
+	if *volumesFilename != "" {
+		volume.IndexMd5s, err = volumes.IndexChecksums(treeFS)
+		if err != nil {
+			exitcode.Error("Cannot checksum the index files of %s: %s\n", treePrefix, err)
+		}
+		if volumeRegistry.Update(*volumeID, volume) {
+			if err := volumeRegistry.Save(*volumesFilename); err != nil {
+				exitcode.Error("Cannot write the volume registry %s: %s\n", *volumesFilename, err)
+			}
+			fmt.Printf("Registered volume %s in %s\n", *volumeID, *volumesFilename)
+		}
+	}
+
+	// The run is complete, so any partial catalog is no longer needed
+	if err := os.Remove(partialCatalogFilename); err == nil {
+		fmt.Printf("Removed partial catalog %s\n", partialCatalogFilename)
+	}
+
+	exitcode.Exit()
+}
+
+// Writes a checkpoint of the documents processed so far, in a form that catalog.Load can read back.
+func SavePartialCatalog(filename string, mapByFilepath map[string]Document) {
+	err := checkpoint.SaveState(filename, mapByFilepath)
 	if err != nil {
-		fmt.Println("YAML: failed to unmarshal")
-		return documents, err
+		exitcode.WarningAt("checkpoint-failed", filename, "WARNING: failed to write partial catalog %s: %s\n", filename, err)
+		return
 	}
-	fmt.Printf("Initial  number of YAML entries: %d\n", len(documents))
-	return documents, err
+	fmt.Printf("Checkpoint: %d documents written to %s\n", len(mapByFilepath), filename)
 }
 
 // This function reads a CSV file and unpacks the information into a map of Document objects
-func LoadCSV(filepath string) (map[string]Document, error) {
+func LoadCSV(treeRoot string) (map[string]Document, error) {
 	var docs map[string]Document = make(map[string]Document)
 
-	var csvFilepath = filepath
-	if filepath[len(filepath)-1:] != "/" {
-		csvFilepath += "/"
-	}
-	csvFilepath += "index.csv"
-	csvFile, err := os.Open(csvFilepath)
-	if err != nil {
-		return nil, err
-	}
-	defer csvFile.Close()
-	reader := csv.NewReader(csvFile)
-	csvRecords, err := reader.ReadAll()
+	csvRecords, err := indexcsv.ReadFile(filepath.Join(treeRoot, indexcsv.IndexFilename))
 	if err != nil {
 		return nil, err
 	}
-	for _, row := range csvRecords {
+	for _, record := range csvRecords {
 		// Ignore any records that do not relate to a specific document
-		if row[0] != "Doc" {
+		if !record.IsDocument() {
 			continue
 		}
-		newDoc := CreateLocalDocument(row[2])
-		newDoc.Title = row[1]
-		newDoc.PublicUrl = row[3]
-		newDoc.PubDate = row[4]
-		newDoc.PartNum = row[5]
-		newDoc.Md5 = row[6]
+		newDoc := CreateLocalDocument(record.Filepath)
+		indexcsv.UpdateDocumentFromRecord(&newDoc, record)
 		// TODO handle collection in options?
 		docKey := document.BuildKeyFromDocument(newDoc)
 		fmt.Printf("CSV doc MD5=[%s] Key=[%s]\n", newDoc.Md5, docKey)
@@ -449,6 +777,75 @@ func LoadCSV(filepath string) (map[string]Document, error) {
 	return docs, nil
 }
 
+// Writes an md5sums file at the root of the tree describing every file in relativePaths (other than md5sums itself).
+// Checksums already known from the documents map are reused; any others (e.g. index files) are calculated.
+// Files that cannot be read are recorded as problems and omitted.
+func WriteMd5sums(treePrefix string, relativePaths []string, mapByFilepath map[string]Document, problemFilenames *fsutil.ProblemFilenames, verbose bool) error {
+	md5Map := make(map[string]string)
+	for _, relativeFilepath := range relativePaths {
+		if relativeFilepath == md5sums.Md5sumsFilename {
+			continue
+		}
+		if doc, found := mapByFilepath[fsutil.EscapeInvalidUTF8(relativeFilepath)]; found && (doc.Md5 != "") {
+			md5Map[relativeFilepath] = doc.Md5
+			continue
+		}
+		if verbose {
+			fmt.Println("Calculating MD5 for ", fsutil.JoinSlashPath(treePrefix, relativeFilepath))
+		}
+		md5Checksum, err := hashing.Md5File(fsutil.JoinSlashPath(treePrefix, relativeFilepath))
+		if err != nil {
+			problemFilenames.Add(relativeFilepath, fmt.Sprintf("cannot compute MD5: %s", err))
+			continue
+		}
+		md5Map[relativeFilepath] = md5Checksum
+	}
+	md5sumsPath := filepath.Join(treePrefix, md5sums.Md5sumsFilename)
+	fmt.Printf("Writing %d entries to %s\n", len(md5Map), md5sumsPath)
+	return md5sums.WriteFile(md5sumsPath, md5sums.FromMap(md5Map))
+}
+
+// Runs the --exec hook for the document whose file is the named file in the tree, merging the metadata it supplies
+// into the document. A remote file is fetched to a temporary copy for the command.
+func RunExecHook(execHook *exechook.Hook, doc *Document, treeFS archivefs.FS, name string) {
+	fullPath := treeFS.Location(name)
+	localPath, release, err := archivefs.LocalFile(treeFS, name)
+	if err != nil {
+		exitcode.WarningAt("exec-failed", fullPath, "--exec failed for %s: %s", fullPath, err)
+		return
+	}
+	defer release()
+	unrecognised, err := execHook.Process(doc, localPath)
+	if err != nil {
+		exitcode.WarningAt("exec-failed", fullPath, "--exec failed for %s: %s", fullPath, err)
+	}
+	for _, key := range unrecognised {
+		exitcode.WarningAt("exec-unknown-key", fullPath, "--exec printed unrecognised key %q (first seen for %s)", key, fullPath)
+	}
+}
+
+// Extracts the PDF metadata for all pending documents, using up to workers concurrent extractions,
+// and updates the corresponding entries in both document maps.
+// Metadata already in the cache is used unless refresh is set.
+func MergePdfMetadata(treeFS archivefs.FS, pendingExif []PendingExif, exifCache *pdfmetadata.Cache, refresh bool, workers int, mapByFilepath map[string]Document, mapByMd5 map[string]Document) {
+	var names []string
+	var keys []string
+	for _, pending := range pendingExif {
+		names = append(names, pending.Name)
+		keys = append(keys, pdfmetadata.CacheKeyFS(mapByFilepath[pending.CatalogFilepath].Md5, treeFS, pending.Name))
+	}
+	results := pdfmetadata.ExtractPdfMetadataFSCached(treeFS, names, keys, exifCache, refresh, workers)
+
+	for i, pending := range pendingExif {
+		doc := mapByFilepath[pending.CatalogFilepath]
+		pdfmetadata.Apply(&doc, results[i])
+		mapByFilepath[pending.CatalogFilepath] = doc
+		if md5Doc, found := mapByMd5[pending.Md5Key]; found && (md5Doc.Filepath == doc.Filepath) {
+			mapByMd5[pending.Md5Key] = doc
+		}
+	}
+}
+
 // This function function creates a Document struct with some default values set
 func CreateLocalDocument(relativeFilepath string) Document {
 	var newDocument Document
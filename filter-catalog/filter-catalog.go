@@ -0,0 +1,186 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+//
+// This program selects documents from one or more catalogs, writing them to a new catalog and/or counting them, while
+// holding only one document in memory at a time (see catalog.Reader), so that even a master catalog of a million
+// documents can be filtered, exported or summarised on a small machine.
+//
+// --where selects documents by a filter expression (see catalog.Expr), --tag and --without-tag (each repeatable) by
+// their tags, and --audience by who may see them (see internal/visibility; by default every document is selected); a
+// document must satisfy all that are given.
+//
+// --output FILE writes the selected documents to a catalog (as JSON Lines if FILE ends .jsonl; FILE may be a workspace
+// name), in the order they were read, so the documents of a catalog written in the same collation stay in order.
+//
+// The summary gives the number of documents read and selected and their total size, and --count-by FIELD (repeatable)
+// the number of selected documents with each value of a field (any field that --where accepts, e.g. format,
+// collection, doctype or tags, where a document counts once for each tag). Only the distinct values are held.
+//
+// To run the program:
+//   go run filter-catalog/filter-catalog.go --where 'format = PDF and pubdate < 1980' --output bin/early-pdfs.yaml bin/master.yaml
+//   go run filter-catalog/filter-catalog.go --count-by format --count-by collection bin/master.yaml
+//
+
+type Document = document.Document
+
+// Counts counts the selected documents with each value of one field
+type Counts struct {
+	Field  string
+	Values map[string]int
+}
+
+// Stats describes the documents read and selected
+type Stats struct {
+	Read          int
+	Selected      int
+	SelectedBytes int64
+	Counts        []Counts
+}
+
+func main() {
+	where := flag.String("where", "", "select only documents matching this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	audience := flag.String("audience", visibility.Private, "select only documents this audience may see: public, restricted or private")
+	outputFilename := flag.String("output", "", "filepath of a catalog to receive the selected documents (JSON Lines if it ends .jsonl)")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	var requiredTags, excludedTags, countBy []string
+	flag.Func("tag", "select only documents with this tag (repeatable)", func(s string) error {
+		requiredTags = append(requiredTags, s)
+		return nil
+	})
+	flag.Func("without-tag", "select only documents without this tag (repeatable)", func(s string) error {
+		excludedTags = append(excludedTags, s)
+		return nil
+	})
+	flag.Func("count-by", "count the selected documents by the values of this field, e.g. format (repeatable)", func(s string) error {
+		if !catalog.IsField(s) {
+			return fmt.Errorf("unknown field %q", s)
+		}
+		countBy = append(countBy, strings.ToLower(s))
+		return nil
+	})
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if !visibility.IsLevel(*audience) {
+		exitcode.UsageErrorf("--audience must be one of %s, found %q", strings.Join(visibility.Levels, ", "), *audience)
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog")
+	}
+
+	var writer *catalog.Writer
+	if *outputFilename != "" {
+		writer, err = catalog.CreateWriter(*outputFilename)
+		if err != nil {
+			exitcode.Fatalf("Cannot create %s: %v", *outputFilename, err)
+		}
+	}
+
+	keep := func(key string, doc Document) bool {
+		return visibility.Allowed(doc, *audience) && catalog.HasTags(doc, requiredTags, excludedTags) && filter.Matches(key, doc)
+	}
+	stats, err := FilterCatalogs(flag.Args(), keep, countBy, writer, *verbose)
+	if err != nil {
+		if writer != nil {
+			writer.Abandon()
+		}
+		exitcode.Fatal(err)
+	}
+	if writer != nil {
+		if err := writer.Close(); err != nil {
+			exitcode.Fatalf("Cannot write %s: %v", *outputFilename, err)
+		}
+	}
+
+	WriteStats(os.Stdout, stats)
+	if writer != nil {
+		fmt.Printf("Wrote %d documents to %s\n", writer.Count(), *outputFilename)
+	}
+
+	exitcode.Exit()
+}
+
+// Reads each catalog one document at a time, writing the documents that keep selects to writer (if not nil) and
+// counting them by each of the countBy fields
+func FilterCatalogs(filenames []string, keep func(key string, doc Document) bool, countBy []string, writer *catalog.Writer, verbose bool) (Stats, error) {
+	stats := Stats{}
+	for _, field := range countBy {
+		stats.Counts = append(stats.Counts, Counts{Field: field, Values: make(map[string]int)})
+	}
+	for _, filename := range filenames {
+		read := stats.Read
+		err := catalog.Each(filename, func(key string, doc Document) error {
+			stats.Read += 1
+			if !keep(key, doc) {
+				return nil
+			}
+			stats.Selected += 1
+			stats.SelectedBytes += doc.Size
+			for _, counts := range stats.Counts {
+				for _, value := range catalog.FieldValues(counts.Field, key, doc) {
+					counts.Values[value] += 1
+				}
+			}
+			if writer != nil {
+				return writer.Write(key, doc)
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, fmt.Errorf("cannot read %s: %w", filename, err)
+		}
+		if verbose {
+			fmt.Printf("Read %d documents from %s\n", stats.Read-read, filename)
+		}
+	}
+	return stats, nil
+}
+
+// Writes the summary, and the counts for each field with the most frequent values first
+func WriteStats(out io.Writer, stats Stats) {
+	fmt.Fprintf(out, "Selected %d of %d documents (%d bytes)\n", stats.Selected, stats.Read, stats.SelectedBytes)
+	for _, counts := range stats.Counts {
+		fmt.Fprintf(out, "\nBy %s:\n", counts.Field)
+		values := make([]string, 0, len(counts.Values))
+		for value := range counts.Values {
+			values = append(values, value)
+		}
+		slices.SortFunc(values, func(a string, b string) int {
+			if counts.Values[a] != counts.Values[b] {
+				return counts.Values[b] - counts.Values[a]
+			}
+			return strings.Compare(a, b)
+		})
+		for _, value := range values {
+			if value == "" {
+				fmt.Fprintf(out, "  %8d  (blank)\n", counts.Values[value])
+			} else {
+				fmt.Fprintf(out, "  %8d  %s\n", counts.Values[value], value)
+			}
+		}
+	}
+}
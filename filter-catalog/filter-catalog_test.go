@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/pkg/catalog"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilterCatalogs(t *testing.T) {
+	dir := t.TempDir()
+	master := catalog.Catalog{
+		"md5-a": {Title: "KA630 CPU Module Technical Manual", Md5: "md5-a", Format: "PDF", Size: 1000, Collection: "local", Filepath: "file:///DEC_0001/ka630.pdf", Tags: []string{"rare"}},
+		"md5-b": {Title: "KA630 CPU Module Technical Manual", Md5: "md5-b", Format: "TXT", Size: 10, Collection: "local", Filepath: "file:///DEC_0001/ka630.txt"},
+		"md5-c": {Title: "VAX Field Guide", Md5: "md5-c", Format: "PDF", Size: 500, Collection: "bitsavers", PublicUrl: "http://bitsavers.org/pdf/dec/guide.pdf", Tags: []string{"rare", "needs-rescan"}},
+	}
+	other := catalog.Catalog{
+		"md5-d": {Title: "PDP-11 Handbook", Md5: "md5-d", Format: "PDF", Size: 2000, Collection: "vaxhaven", PublicUrl: "http://vaxhaven.com/handbook.pdf"},
+	}
+	filenames := []string{filepath.Join(dir, "master.yaml"), filepath.Join(dir, "other.yaml")}
+	catalog.Save(filenames[0], master)
+	catalog.Save(filenames[1], other)
+
+	filter, _ := catalog.ParseExpr("format = PDF")
+	keep := func(key string, doc Document) bool {
+		return catalog.HasTags(doc, nil, []string{"needs-rescan"}) && filter.Matches(key, doc)
+	}
+	output := filepath.Join(dir, "pdfs.yaml")
+	writer, err := catalog.CreateWriter(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := FilterCatalogs(filenames, keep, []string{"collection", "tags"}, writer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := Stats{Read: 4, Selected: 2, SelectedBytes: 3000, Counts: []Counts{
+		{Field: "collection", Values: map[string]int{"local": 1, "vaxhaven": 1}},
+		{Field: "tags", Values: map[string]int{"rare": 1}},
+	}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("FilterCatalogs() = %+v, expected %+v", stats, expected)
+	}
+	written, err := catalog.Load(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := written.Keys(); !reflect.DeepEqual(keys, []string{"md5-a", "md5-d"}) {
+		t.Errorf("FilterCatalogs() wrote %v", keys)
+	}
+
+	var out bytes.Buffer
+	WriteStats(&out, stats)
+	report := "Selected 2 of 4 documents (3000 bytes)\n\nBy collection:\n         1  local\n         1  vaxhaven\n\nBy tags:\n         1  rare\n"
+	if out.String() != report {
+		t.Errorf("WriteStats() wrote\n%s\nexpected\n%s", out.String(), report)
+	}
+
+	if _, err := FilterCatalogs([]string{filepath.Join(dir, "missing.yaml")}, keep, nil, nil, false); err == nil {
+		t.Errorf("FilterCatalogs() of a missing catalog succeeded")
+	}
+}
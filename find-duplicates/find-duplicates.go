@@ -0,0 +1,306 @@
+package main
+
+import (
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/treewalk"
+	"flag"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+//
+// This program finds the duplicate files in one or more trees (e.g. before cataloguing a big tree for the first time)
+// without hashing every file. Files are first grouped by size, as files of different sizes cannot be the same; only
+// the files that share their size with another are hashed, and only to compare them with each other. On most trees
+// that rules out the great majority of files without reading them.
+//
+// --fingerprint adds a second, cheap, stage: files that share a size are grouped by their partial fingerprint (see
+// internal/hashing), which reads only the first and last blocks of each file, before any is hashed in full. This pays
+// off when many large files share a size (e.g. scans padded to a fixed size). Files in a remote root have no cheap
+// fingerprint, so a group that includes one goes straight to hashing.
+//
+// Each root is an archive root (see internal/archivefs), either a local directory or an sftp://, smb:// or s3:// URL.
+// An object whose ETag is its MD5 checksum is not downloaded just to hash it. Files smaller than --min-size bytes
+// (by default, empty files) are ignored. --walk-workers N reads up to N directories at once while finding the files
+// (see internal/treewalk), which is most of the time taken to scan a big tree on a NAS share.
+//
+// Each set of identical files is listed under its MD5 checksum and size, followed by a summary of how much hashing the
+// size and fingerprint stages saved.
+//
+// To run the program:
+//   go run find-duplicates/find-duplicates.go --fingerprint --workers 4 /mnt/nas/scans /mnt/nas/incoming
+//
+
+// File is a file found in one of the trees
+type File struct {
+	Root   int    // The index of the tree's root
+	Name   string // The name of the file within the tree
+	Size   int64
+	Print  string // The partial fingerprint, if taken
+	Md5    string // The MD5 checksum, if known
+	Hashed bool   // True if the file was read to find its MD5 checksum
+	Err    error  // The problem fingerprinting or hashing the file
+}
+
+// Stats counts the files ruled out at each stage
+type Stats struct {
+	Files           int   // Files found
+	Bytes           int64 // Their total size
+	RuledBySize     int   // Files whose size no other file has
+	RuledByPrint    int   // Files whose fingerprint no other file (of the same size) has
+	Hashed          int   // Files hashed in full
+	BytesHashed     int64 // Their total size
+	DuplicateSets   int   // Sets of identical files
+	DuplicateFiles  int   // Files that are a copy of another (i.e. excluding the first of each set)
+	DuplicateBytes  int64 // The space taken by those copies
+	FailedToCompare int   // Files that could not be fingerprinted or hashed
+}
+
+func main() {
+	fingerprint := flag.Bool("fingerprint", false, "compare the partial fingerprints of files of the same size before hashing them")
+	blockKB := flag.Int64("block-size", hashing.DefaultFingerprintBlock/1024, "size in KB of the blocks fingerprinted at the start and end of each file")
+	minSize := flag.Int64("min-size", 1, "ignore files smaller than this many bytes")
+	workers := flag.Int("workers", 1, "number of files to fingerprint or hash concurrently")
+	walkWorkers := flag.Int("walk-workers", treewalk.DefaultWorkers, "number of directories to read concurrently while finding the files (1 reads one at a time)")
+	s3ConfigFilename := flag.String("s3-config", "", "filepath of a YAML file giving the endpoint and credentials for s3:// roots")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	if *blockKB <= 0 {
+		exitcode.UsageError("--block-size must be at least 1 (KB)")
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one tree root")
+	}
+	s3Config, err := archivefs.ReadS3Config(*s3ConfigFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--s3-config: %s", err)
+	}
+	archivefs.ConfigureS3(s3Config)
+
+	var roots []archivefs.FS
+	var files []File
+	for i, rootName := range flag.Args() {
+		root, err := archivefs.Open(rootName)
+		if err != nil {
+			exitcode.Fatalf("Cannot open %s: %s", rootName, err)
+		}
+		found, err := ScanTree(root, i, *minSize, *walkWorkers)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %s", rootName, err)
+		}
+		if *verbose {
+			fmt.Printf("Found %d files in %s\n", len(found), rootName)
+		}
+		roots = append(roots, root)
+		files = append(files, found...)
+	}
+
+	stats := Stats{Files: len(files)}
+	for _, file := range files {
+		stats.Bytes += file.Size
+	}
+	groups := GroupBySize(files)
+	stats.RuledBySize = stats.Files - countFiles(groups)
+
+	keepGoing := func() bool { return !interrupt.Requested() }
+	if *fingerprint {
+		var local, remote [][]File
+		for _, group := range groups {
+			if HasLocalPaths(roots, group) {
+				local = append(local, group)
+			} else {
+				remote = append(remote, group)
+			}
+		}
+		block := *blockKB * 1024
+		printed, completed := pipeline.MapWhile(flatten(local), *workers, keepGoing, func(worker int, file File) File {
+			file.Print, file.Err = hashing.FingerprintFile(roots[file.Root].LocalPath(file.Name), block)
+			return file
+		})
+		if completed < len(printed) {
+			interrupt.Exit("Re-run to find the duplicates")
+		}
+		printed = dropFailed(roots, printed, &stats)
+		byPrint := SplitGroups(GroupBySize(printed), func(file File) string { return file.Print })
+		stats.RuledByPrint = len(printed) - countFiles(byPrint)
+		groups = append(byPrint, remote...)
+	}
+
+	hashed, completed := pipeline.MapWhile(flatten(groups), *workers, keepGoing, func(worker int, file File) File {
+		file.Md5, file.Hashed, file.Err = Md5(roots[file.Root], file.Name)
+		return file
+	})
+	if completed < len(hashed) {
+		interrupt.Exit("Re-run to find the duplicates")
+	}
+	for _, file := range hashed {
+		if file.Hashed {
+			stats.Hashed += 1
+			stats.BytesHashed += file.Size
+		}
+	}
+	hashed = dropFailed(roots, hashed, &stats)
+	duplicates := SplitGroups(GroupBySize(hashed), func(file File) string { return file.Md5 })
+
+	for _, set := range duplicates {
+		fmt.Printf("%s %d\n", set[0].Md5, set[0].Size)
+		for _, file := range set {
+			fmt.Printf("    %s\n", roots[file.Root].Location(file.Name))
+		}
+		events.Emit(events.SeverityInfo, "duplicate-files", roots[set[0].Root].Location(set[0].Name), fmt.Sprintf("%d copies of %s", len(set), set[0].Md5))
+		stats.DuplicateSets += 1
+		stats.DuplicateFiles += len(set) - 1
+		stats.DuplicateBytes += int64(len(set)-1) * set[0].Size
+	}
+	stats.Report(*fingerprint)
+
+	exitcode.Exit()
+}
+
+// Lists every regular file of at least minSize bytes in a tree, in the order found, reading up to walkWorkers
+// directories at once
+func ScanTree(fsys fs.FS, root int, minSize int64, walkWorkers int) ([]File, error) {
+	var files []File
+	err := treewalk.WalkDir(fsys, ".", walkWorkers, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() >= minSize {
+			files = append(files, File{Root: root, Name: name, Size: info.Size()})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Groups files by size, keeping only the groups of more than one file. The groups are in order of decreasing size
+// (so that the files that cost most to hash are dealt with first), and each keeps the order of the files given.
+func GroupBySize(files []File) [][]File {
+	bySize := make(map[int64][]File)
+	for _, file := range files {
+		bySize[file.Size] = append(bySize[file.Size], file)
+	}
+	var groups [][]File
+	for _, group := range bySize {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].Size > groups[j][0].Size })
+	return groups
+}
+
+// Splits each group by the given key, keeping only the groups of more than one file. The groups stay in order,
+// and those split from one group are in the order in which their first file appears.
+func SplitGroups(groups [][]File, key func(File) string) [][]File {
+	var split [][]File
+	for _, group := range groups {
+		byKey := make(map[string][]File)
+		var keys []string
+		for _, file := range group {
+			k := key(file)
+			if _, found := byKey[k]; !found {
+				keys = append(keys, k)
+			}
+			byKey[k] = append(byKey[k], file)
+		}
+		for _, k := range keys {
+			if len(byKey[k]) > 1 {
+				split = append(split, byKey[k])
+			}
+		}
+	}
+	return split
+}
+
+// Reports whether every file in a group has a native path, and so can be fingerprinted cheaply
+func HasLocalPaths(roots []archivefs.FS, group []File) bool {
+	for _, file := range group {
+		if roots[file.Root].LocalPath(file.Name) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the MD5 checksum of a file, without reading it if the archive already knows it. Reports whether the file
+// was read.
+func Md5(root archivefs.FS, name string) (string, bool, error) {
+	if md5, known := archivefs.KnownMd5(root, name); known {
+		return md5, false, nil
+	}
+	md5, err := hashing.Md5FS(root, name)
+	return md5, err == nil, err
+}
+
+// Prints the summary of a run
+func (stats Stats) Report(fingerprint bool) {
+	fmt.Printf("Files:                        %d (%d bytes)\n", stats.Files, stats.Bytes)
+	fmt.Printf("Ruled out by size:            %d\n", stats.RuledBySize)
+	if fingerprint {
+		fmt.Printf("Ruled out by fingerprint:     %d\n", stats.RuledByPrint)
+	}
+	fmt.Printf("Hashed:                       %d (%d bytes, %.1f%% of the total)\n", stats.Hashed, stats.BytesHashed, percent(stats.BytesHashed, stats.Bytes))
+	if stats.FailedToCompare > 0 {
+		fmt.Printf("Could not be compared:        %d\n", stats.FailedToCompare)
+	}
+	fmt.Printf("Sets of duplicates:           %d\n", stats.DuplicateSets)
+	fmt.Printf("Duplicate copies:             %d (%d bytes)\n", stats.DuplicateFiles, stats.DuplicateBytes)
+}
+
+// Reports the files that could not be fingerprinted or hashed, and returns the rest
+func dropFailed(roots []archivefs.FS, files []File, stats *Stats) []File {
+	var kept []File
+	for _, file := range files {
+		if file.Err != nil {
+			location := roots[file.Root].Location(file.Name)
+			exitcode.WarningAt("uncompared-file", location, "FAILED: %s (%s)\n", location, file.Err)
+			stats.FailedToCompare += 1
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+func flatten(groups [][]File) []File {
+	var files []File
+	for _, group := range groups {
+		files = append(files, group...)
+	}
+	return files
+}
+
+func countFiles(groups [][]File) int {
+	return len(flatten(groups))
+}
+
+func percent(part int64, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func names(groups [][]File) [][]string {
+	var result [][]string
+	for _, group := range groups {
+		var names []string
+		for _, file := range group {
+			names = append(names, file.Name)
+		}
+		result = append(result, names)
+	}
+	return result
+}
+
+func TestScanTree(t *testing.T) {
+	tree := fstest.MapFS{
+		"a.pdf":       {Data: []byte("abc")},
+		"empty.txt":   {Data: []byte{}},
+		"vax/b.pdf":   {Data: []byte("abc")},
+		"vax/c/d.txt": {Data: []byte("abcdef")},
+	}
+	files, err := ScanTree(tree, 1, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []File{{Root: 1, Name: "a.pdf", Size: 3}, {Root: 1, Name: "vax/b.pdf", Size: 3}, {Root: 1, Name: "vax/c/d.txt", Size: 6}}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("ScanTree() returned %+v, expected %+v", files, expected)
+	}
+}
+
+func TestGroupBySize(t *testing.T) {
+	files := []File{{Name: "a", Size: 3}, {Name: "b", Size: 10}, {Name: "c", Size: 3}, {Name: "d", Size: 7}, {Name: "e", Size: 10}, {Name: "f", Size: 3}}
+	got := names(GroupBySize(files))
+	expected := [][]string{{"b", "e"}, {"a", "c", "f"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GroupBySize() returned %v, expected %v", got, expected)
+	}
+}
+
+func TestSplitGroups(t *testing.T) {
+	groups := [][]File{
+		{{Name: "a", Md5: "x"}, {Name: "b", Md5: "y"}, {Name: "c", Md5: "x"}, {Name: "d", Md5: "y"}},
+		{{Name: "e", Md5: "x"}, {Name: "f", Md5: "z"}},
+	}
+	got := names(SplitGroups(groups, func(file File) string { return file.Md5 }))
+	expected := [][]string{{"a", "c"}, {"b", "d"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("SplitGroups() returned %v, expected %v", got, expected)
+	}
+}
@@ -8,23 +8,44 @@ package main
 // = files with identical MD5 sums are considered identical
 // = local files with certain strings in their filepaths are considered to have originated from an internet repository,
 //   for example a local file with "/bitsavers/" will not be considered unique
-// = any local file whose part # matches that of a remote document will will not be considered unique
+// = any local file whose part # matches that of a remote document in the same format will will not be considered unique;
+//   with --any-format, a remote document in any format (e.g. a TXT when the local file is a PDF) is enough.
+//   Every alias in AltPartNums is matched as well as the part number itself
 // = any local file whose filename matches that of a remote document will will not be considered unique
 //
+// With --where, only the local documents selected by a filter expression (see catalog.Expr) are considered at all,
+// e.g. --where 'format = PDF and collection ~ "^DEC_00"'.
+//
+// Any local document listed in the --whitelist file (by MD5 or filepath) bypasses these rules, for example when
+// a better local scan happens to share a filename with a remote document. It is kept with a processingnote explaining why it was forced in.
+//
+// When the same MD5 checksum appears in more than one of the --local (or --remote) catalogs, --duplicate-policy
+// chooses which copy is used, e.g. --duplicate-policy richer,newer (see internal/retention); by default the first found
+// is used. The decision is recorded in the provenance of the copy that is kept.
+//
+// The catalogs of every subscription listed in --subscriptions (by default data/subscriptions.yaml, if it exists) that
+// sync-subscriptions has cached in --subscription-cache are added to the --remote catalogs automatically, so the
+// catalogs other collectors publish count as remote without being named each time. --no-subscriptions leaves them out.
+//
 // Any local documents not filtered out by this processing will end up in the final YAMl file.
 // This file can then form the basis of further processing to produce a candidate list of files
 // to be made available to remote repositories, along with appropriate metdadata.
 
 import (
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/internal/subscriptions"
+	"docs-to-yaml/pkg/catalog"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
-
-	"gopkg.in/yaml.v2"
 )
 
 type Document = document.Document
@@ -38,6 +59,7 @@ func main() {
 	remoteYamlFiles := make([]string, 0)
 
 	uniqueDocuments := make(map[string]Document)
+	matchedDocuments := make(map[string]MatchedDocument)
 	flag.Func("local", "specify a set of YAML files describing local documents", func(s string) error {
 		fmt.Println("called local with ", s)
 		localYamlFiles = append(localYamlFiles, s)
@@ -50,44 +72,86 @@ func main() {
 		return nil
 	})
 
-	verbose := flag.Bool("verbose", false, "Enable verbose reporting")
+	verbose := console.Flags("Enable verbose reporting")
 	yamlOutputFilename := flag.String("yaml", "", "filepath of the output file to hold the generated yaml")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	matchedYamlOutputFilename := flag.String("matched-yaml", "", "filepath of an optional output file to hold the local documents that matched a remote document")
+	anyFormat := flag.Bool("any-format", false, "treat a remote document with the same part number in any format as a match")
+	whitelistFilename := flag.String("whitelist", "", "filepath of a file listing MD5 checksums or filepaths of local documents to include regardless")
+	duplicatePolicy := flag.String("duplicate-policy", "", "how to choose between documents with the same MD5 checksum: comma-separated rules from first, local, richer, newer and quality (default: first)")
+	where := flag.String("where", "", "consider only the local documents selected by this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	subscriptionsFilename := flag.String("subscriptions", subscriptions.DefaultFilename, "filepath of the list of subscribed catalogs, whose cached copies are also remote catalogs")
+	subscriptionCache := flag.String("subscription-cache", subscriptions.DefaultCacheDir, "directory holding the subscribed catalogs cached by sync-subscriptions")
+	noSubscriptions := flag.Bool("no-subscriptions", false, "do not use the subscribed catalogs as remote catalogs")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
 
 	flag.Parse()
 
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError("Bad --where: ", err)
+	}
+
+	policy, err := retention.Parse(*duplicatePolicy)
+	if err != nil {
+		exitcode.UsageError("Bad --duplicate-policy: ", err)
+	}
+
+	whitelist, err := ReadWhitelist(*whitelistFilename)
+	if err != nil {
+		exitcode.UsageError("Bad --whitelist: ", err)
+	}
+
+	if !*noSubscriptions {
+		cached, missing, err := subscriptions.CachedCatalogs(*subscriptionsFilename, *subscriptionCache)
+		if err != nil {
+			exitcode.UsageError("Bad --subscriptions: ", err)
+		}
+		for _, name := range missing {
+			exitcode.WarningAt("subscription-not-synced", *subscriptionsFilename, "WARNING: subscription %s has not been fetched; run sync-subscriptions\n", name)
+		}
+		remoteYamlFiles = append(remoteYamlFiles, cached...)
+	}
+
 	writeOutputYaml := (*yamlOutputFilename != "")
 	logLocallyUniqueFiles := *verbose || !writeOutputYaml
 	fmt.Printf("output YAML: [%s] write yaml: %t verbose: %t\n", *yamlOutputFilename, writeOutputYaml, *verbose)
 	// Build list of all remote files
-	localDocuments := BuildMapOfDocuments(localYamlFiles)
-	remoteDocuments := BuildMapOfDocuments(remoteYamlFiles)
+	localDocuments := catalog.Catalog(BuildMapOfDocuments(localYamlFiles, policy)).Where(filter)
+	remoteDocuments := BuildMapOfDocuments(remoteYamlFiles, policy)
 	if *verbose {
 		fmt.Println("Found ", len(localDocuments), "local documents")
 		fmt.Println("Found ", len(remoteDocuments), "remote documents")
 	}
 
-	var mapRemoteDocsByPartNum map[string]Document = make(map[string]Document)
-	var mapRemoteDocsByFilename map[string]Document = make(map[string]Document)
+	// These map to the key of the remote document in remoteDocuments
+	var mapRemoteDocsByPartNum map[string]string = make(map[string]string)
+	var mapRemoteDocsByFilename map[string]string = make(map[string]string)
 
 	// Build maps of remote documents by filename (not filepath) and by part number
-	for _, v := range remoteDocuments {
-		partNum := v.PartNum
-		partNum = strings.Replace(partNum, "-", "", -1)
-		partNum = strings.Replace(partNum, ".", "", -1)
-		if _, found := mapRemoteDocsByPartNum[partNum]; found {
-			if *verbose {
-				fmt.Printf("WARNING: non-unique Part Num %s (was %s) for %s and %s - dropped latter\n", partNum, v.PartNum, mapRemoteDocsByPartNum[v.PartNum].Filepath, v.Filepath)
+	for k, v := range remoteDocuments {
+		// Documents without a part number cannot be matched by part number
+		for _, partNum := range PartNumMatchKeys(v, *anyFormat) {
+			if existing, found := mapRemoteDocsByPartNum[partNum]; found {
+				if *verbose && (existing != k) {
+					exitcode.WarningAt("duplicate-part-number", v.Filepath, "WARNING: non-unique Part Num %s (was %s) for %s and %s - dropped latter\n", partNum, v.PartNum, remoteDocuments[existing].Filepath, v.Filepath)
+				}
+			} else {
+				mapRemoteDocsByPartNum[partNum] = k
 			}
-		} else {
-			mapRemoteDocsByPartNum[partNum] = v
 		}
 		fn := filepath.Base(v.Filepath)
 		if _, found := mapRemoteDocsByFilename[fn]; found {
 			if *verbose {
-				fmt.Printf("WARNING: non-unique filename %s for %s and %s - dropped latter\n", fn, v.Filepath, mapRemoteDocsByFilename[fn].Filepath)
+				exitcode.WarningAt("duplicate-filename", v.Filepath, "WARNING: non-unique filename %s for %s and %s - dropped latter\n", fn, v.Filepath, remoteDocuments[mapRemoteDocsByFilename[fn]].Filepath)
 			}
 		} else {
-			mapRemoteDocsByFilename[fn] = v
+			mapRemoteDocsByFilename[fn] = k
 		}
 	}
 
@@ -99,6 +163,7 @@ func main() {
 	matchedFN := 0
 	matchedPath := 0
 	matchedMD5 := 0
+	forceIncluded := 0
 
 	partialPathsToReject := []string{"/metadata/", "/bitsavers/", "/chook/", "/MDS/1994-"}
 
@@ -108,38 +173,68 @@ func main() {
 			localMissingMd5 += 1
 		}
 
+		rejection := ""
+		matchedKey := "" // The key of the remote document matched, if any
+
 		// Reject any document that contains any of the partial paths in its own filepath
-		rejectPartialPath := false
 		for _, p := range partialPathsToReject {
 			if strings.Contains(localDoc.Filepath, p) {
 				// Skip any file with bitsavers as a path element as it almost certainly came from an existing remote source in the first place
-				matchedPath += 1
-				rejectPartialPath = true
+				rejection = "path portion " + p
 				break
 			}
 		}
-		if rejectPartialPath {
-			continue
-		}
 
 		// Reject any local document that exactly matches a remote document's MD5 checksum
-		if _, found := remoteDocuments[localDoc.Md5]; found {
-			matchedMD5 += 1
-			continue
+		if rejection == "" {
+			if _, found := remoteDocuments[localDoc.Md5]; found {
+				rejection = "MD5"
+				matchedKey = localDoc.Md5
+			}
 		}
 
 		// Reject any document that matches a remote document's DEC part number
-		partNum := localDoc.PartNum
-		partNum = strings.Replace(partNum, "-", "", -1)
-		partNum = strings.Replace(partNum, ".", "", -1)
-		if _, foundPN := mapRemoteDocsByPartNum[partNum]; foundPN {
-			matchedPN += 1
-			continue
+		// (any alias of the local document may match any alias of the remote one)
+		if rejection == "" {
+			for _, partNum := range PartNumMatchKeys(localDoc, *anyFormat) {
+				if key, foundPN := mapRemoteDocsByPartNum[partNum]; foundPN {
+					rejection = "part number"
+					matchedKey = key
+					break
+				}
+			}
 		}
 
 		// Reject any document that matches a remote document's filename
-		if _, found := mapRemoteDocsByFilename[filepath.Base(localDoc.Filepath)]; found {
-			matchedFN += 1
+		if rejection == "" {
+			if key, found := mapRemoteDocsByFilename[filepath.Base(localDoc.Filepath)]; found {
+				rejection = "filename"
+				matchedKey = key
+			}
+		}
+
+		if rejection != "" {
+			// A whitelisted document is kept regardless, with a note recording why
+			if reason, found := whitelist.Lookup(localDoc); found {
+				localDoc.ProcessingNote = fmt.Sprintf("Force-included (would have been dropped by %s): %s", rejection, reason)
+				if logLocallyUniqueFiles {
+					fmt.Printf("Force-included:     %s (%s)\n", localDoc.Filepath, rejection)
+				}
+				uniqueDocuments[localDoc.Filepath] = localDoc
+				forceIncluded += 1
+				continue
+			}
+			matchedDocuments[localDoc.Filepath] = NewMatchedDocument(localDoc, rejection, matchedKey, remoteDocuments)
+			switch {
+			case strings.HasPrefix(rejection, "path portion"):
+				matchedPath += 1
+			case rejection == "MD5":
+				matchedMD5 += 1
+			case rejection == "part number":
+				matchedPN += 1
+			case rejection == "filename":
+				matchedFN += 1
+			}
 			continue
 		}
 
@@ -157,57 +252,72 @@ func main() {
 	fmt.Printf("Local files dropped by part number:    %d\n", matchedPN)
 	fmt.Printf("Local files dropped by filename:       %d\n", matchedFN)
 	fmt.Printf("Local files that are unique:           %d\n", locallyUnique)
+	fmt.Printf("Local files force-included:            %d\n", forceIncluded)
+	// The same publication may be present in several formats, so count the unique publications too
+	uniquePublications := catalog.Catalog(uniqueDocuments).Publications()
+	withoutPartNum := 0
+	for _, doc := range uniqueDocuments {
+		if document.NormalisePartNumber(doc.PartNum) == "" {
+			withoutPartNum += 1
+		}
+	}
+	fmt.Printf("Unique publications (by part number):  %d (and %d files without a part number)\n", len(uniquePublications), withoutPartNum)
 
 	// Write the output YAML file
 	if writeOutputYaml {
-		data, err := yaml.Marshal(&uniqueDocuments)
-		if err != nil {
-			log.Fatal("Bad YAML data: ", err)
+		if *preview {
+			if confirmed, err := catalog.Preview(*yamlOutputFilename, uniqueDocuments, os.Stdin, os.Stdout); err != nil {
+				exitcode.Fatal("Cannot preview the changes: ", err)
+			} else if !confirmed {
+				fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+				exitcode.Exit()
+			}
 		}
-
-		err = os.WriteFile(*yamlOutputFilename, data, 0644)
+		err := catalog.Save(*yamlOutputFilename, uniqueDocuments)
 		if err != nil {
-			log.Fatal("Failed YAML write: ", err)
+			exitcode.Fatal("Failed YAML write: ", err)
+		}
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, uniqueDocuments); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
 		}
 	}
-}
 
-func YamlDataInit(filename string) (map[string]Document, error) {
-	documents := make(map[string]Document)
+	// Write the matched documents, which (unless matched only by path portion) are candidates for removal from local storage
+	if *matchedYamlOutputFilename != "" {
+		data, err := document.MarshalYaml(matchedDocuments)
+		if err != nil {
+			exitcode.Fatal("Bad YAML data: ", err)
+		}
 
-	file, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return documents, nil
-		} else {
-			return documents, err
+		err = os.WriteFile(*matchedYamlOutputFilename, data, 0644)
+		if err != nil {
+			exitcode.Fatal("Failed YAML write: ", err)
 		}
 	}
-	// Read the existing cache YAML data into the cache
-	err = yaml.Unmarshal(file, documents)
-	if err != nil {
-		fmt.Println("YAML: failed to unmarshal")
-		return documents, err
-	}
-	fmt.Printf("Initial  number of YAML entries in %s: %d\n", filename, len(documents))
-	return documents, err
+
+	exitcode.Exit()
 }
 
 // Build a map of "key => Document"
 // where key is a string that is the MD5 checksum, if any, otherwise
 // use the part number or title or filepath.
-func BuildMapOfDocuments(filenames []string) map[string]Document {
+// Documents with the same MD5 checksum are resolved by the duplicate policy.
+func BuildMapOfDocuments(filenames []string, policy retention.Policy) map[string]Document {
 	documents := make(map[string]Document, 0)
 
 	for _, names := range filenames {
 		// Start by reading the output yaml file.
-		initialData, err := YamlDataInit(names)
+		initialData, err := catalog.LoadIfExists(names)
 		if err != nil {
-			log.Fatal(err)
+			exitcode.Fatal(err)
 		}
+		fmt.Printf("Initial  number of YAML entries in %s: %d\n", names, len(initialData))
 
 		// Loop through the new documents, adding them to the master list
-		for k, v := range initialData {
+		for _, k := range initialData.Keys() {
+			v := initialData[k]
 			// Pick an appropriate key, defaulting to the MD5 value
 			key := k
 			if (key != v.Md5) && (v.Md5 != "") {
@@ -221,7 +331,7 @@ func BuildMapOfDocuments(filenames []string) map[string]Document {
 				if v.Md5 != existing.Md5 {
 					fmt.Println("Found presumed-smae docs with the differing MD5: ", v, " and ", existing)
 				} else {
-					// Drop the duplicate silently
+					documents[key], _ = policy.Resolve(existing, v)
 				}
 			} else {
 				documents[key] = v
@@ -231,3 +341,98 @@ func BuildMapOfDocuments(filenames []string) map[string]Document {
 
 	return documents
 }
+
+// Returns the keys used to match a document by part number: each of its part numbers, including the aliases in
+// AltPartNums, normalised (see document.NormalisePartNumber) and qualified by the format, unless anyFormat is set.
+// Returns nil for a document without a part number.
+func PartNumMatchKeys(doc Document, anyFormat bool) []string {
+	var keys []string
+	for _, partNum := range document.PartNumbers(doc) {
+		key := document.NormalisePartNumber(partNum)
+		if key == "" {
+			continue
+		}
+		if !anyFormat {
+			key += "~" + doc.Format
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// MatchedDocument is a local document that matched a remote document, annotated with what it matched.
+type MatchedDocument struct {
+	Document    `yaml:",inline"`
+	MatchMethod string // How the match was made: "MD5", "part number", "filename" or "path portion ..."
+	MatchedKey  string `yaml:",omitempty"` // The key of the remote document matched (blank for a path portion match)
+	MatchedUrl  string `yaml:",omitempty"` // Where the remote document can be found
+}
+
+// Builds a MatchedDocument for a local document that matched the remote document with the specified key (if any).
+func NewMatchedDocument(localDoc Document, method string, remoteKey string, remoteDocuments map[string]Document) MatchedDocument {
+	matched := MatchedDocument{Document: localDoc, MatchMethod: method, MatchedKey: remoteKey}
+	if remote, found := remoteDocuments[remoteKey]; found {
+		matched.MatchedUrl = remote.PublicUrl
+		if matched.MatchedUrl == "" {
+			matched.MatchedUrl = remote.Filepath
+		}
+	}
+	return matched
+}
+
+// Whitelist holds the local documents that must be included in the output even if they match a remote document.
+// Each maps to the reason given for including it.
+type Whitelist struct {
+	ByMd5      map[string]string
+	ByFilepath map[string]string
+}
+
+var md5Regex = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// Reads a whitelist file. Each line holds an MD5 checksum or a document filepath (as it appears in the local YAML),
+// optionally followed by "#" and the reason for including the document:
+//
+//	# Better scans than the bitsavers copies
+//	4556f5bdf78aa195b18e06e35a64c89f  # 300dpi rescan, bitsavers copy is missing pages
+//	file:///DEC_0040/vax/ka630 rev B.pdf
+//
+// Blank lines and lines starting with "#" are ignored. A blank filename gives an empty whitelist.
+func ReadWhitelist(filename string) (Whitelist, error) {
+	whitelist := Whitelist{ByMd5: make(map[string]string), ByFilepath: make(map[string]string)}
+	if filename == "" {
+		return whitelist, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return whitelist, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, reason, _ := strings.Cut(line, "#")
+		entry = strings.TrimSpace(entry)
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			reason = "whitelisted"
+		}
+		if md5Regex.MatchString(entry) {
+			whitelist.ByMd5[strings.ToLower(entry)] = reason
+		} else {
+			whitelist.ByFilepath[entry] = reason
+		}
+	}
+	return whitelist, nil
+}
+
+// Returns the reason for including the document, if it is whitelisted
+func (whitelist Whitelist) Lookup(doc Document) (string, bool) {
+	if reason, found := whitelist.ByMd5[strings.ToLower(doc.Md5)]; found && (doc.Md5 != "") {
+		return reason, true
+	}
+	reason, found := whitelist.ByFilepath[doc.Filepath]
+	return reason, found
+}
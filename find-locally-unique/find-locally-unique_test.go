@@ -0,0 +1,89 @@
+package main
+
+import (
+	"docs-to-yaml/internal/document"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWhitelist(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "whitelist.txt")
+	text := "# Better scans\n\n4556F5BDF78AA195B18E06E35A64C89F  # 300dpi rescan\nfile:///DEC_0040/vax/ka630 rev B.pdf\n"
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	whitelist, err := ReadWhitelist(filename)
+	if err != nil {
+		t.Fatalf(`ReadWhitelist() failed: %v`, err)
+	}
+
+	tests := []struct {
+		doc      Document
+		reason   string
+		included bool
+	}{
+		{Document{Md5: "4556f5bdf78aa195b18e06e35a64c89f", Filepath: "file:///DEC_0001/a.pdf"}, "300dpi rescan", true},
+		{Document{Filepath: "file:///DEC_0040/vax/ka630 rev B.pdf"}, "whitelisted", true},
+		{Document{Filepath: "file:///DEC_0040/vax/other.pdf"}, "", false},
+		{Document{}, "", false},
+	}
+	for _, test := range tests {
+		reason, included := whitelist.Lookup(test.doc)
+		if (reason != test.reason) || (included != test.included) {
+			t.Errorf(`Lookup(%+v) = %q, %t`, test.doc, reason, included)
+		}
+	}
+
+	if empty, err := ReadWhitelist(""); (err != nil) || (len(empty.ByMd5)+len(empty.ByFilepath) != 0) {
+		t.Errorf(`ReadWhitelist("") = %+v, %v`, empty, err)
+	}
+}
+
+func TestNewMatchedDocument(t *testing.T) {
+	remoteDocuments := map[string]Document{
+		"aaaa": {Md5: "aaaa", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		"EK-1": {PartNum: "EK-1", Filepath: "/images/ek1.pdf", PublicUrl: "http://www.vaxhaven.com/images/ek1.pdf"},
+	}
+	local := Document{Md5: "aaaa", Filepath: "file:///DEC_0001/ka630.pdf"}
+
+	matched := NewMatchedDocument(local, "MD5", "aaaa", remoteDocuments)
+	if !reflect.DeepEqual(matched.Document, local) || (matched.MatchedUrl != "http://bitsavers.org/pdf/dec/vax/ka630.pdf") {
+		t.Errorf(`NewMatchedDocument(MD5) = %+v`, matched)
+	}
+	if matched := NewMatchedDocument(local, "part number", "EK-1", remoteDocuments); matched.MatchedUrl != "http://www.vaxhaven.com/images/ek1.pdf" {
+		t.Errorf(`NewMatchedDocument(part number) = %+v`, matched)
+	}
+	if matched := NewMatchedDocument(local, "path portion /bitsavers/", "", remoteDocuments); (matched.MatchedKey != "") || (matched.MatchedUrl != "") {
+		t.Errorf(`NewMatchedDocument(path portion) = %+v`, matched)
+	}
+
+	// The local document's fields appear alongside the match details
+	data, err := document.MarshalYaml(map[string]MatchedDocument{"k": matched})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "  md5: aaaa\n") || !strings.Contains(string(data), "  matchmethod: MD5\n") {
+		t.Errorf(`unexpected YAML:\n%s`, data)
+	}
+}
+
+func TestPartNumMatchKeys(t *testing.T) {
+	pdf := Document{PartNum: "EK-KA630-TM.001", Format: "PDF"}
+	txt := Document{PartNum: "ek-ka630-tm001", Format: "TXT"}
+	if reflect.DeepEqual(PartNumMatchKeys(pdf, false), PartNumMatchKeys(txt, false)) {
+		t.Errorf(`PartNumMatchKeys() matched different formats`)
+	}
+	if !reflect.DeepEqual(PartNumMatchKeys(pdf, true), []string{"EKKA630TM001"}) || !reflect.DeepEqual(PartNumMatchKeys(txt, true), []string{"EKKA630TM001"}) {
+		t.Errorf(`PartNumMatchKeys() with any format = %v, %v`, PartNumMatchKeys(pdf, true), PartNumMatchKeys(txt, true))
+	}
+	if PartNumMatchKeys(Document{Format: "PDF"}, false) != nil {
+		t.Errorf(`PartNumMatchKeys() gave a key for a document without a part number`)
+	}
+	aliased := Document{PartNum: "AA-0196C-TK", AltPartNums: []string{"AA-0196B-TK", "aa0196ctk"}, Format: "PDF"}
+	if keys := PartNumMatchKeys(aliased, false); !reflect.DeepEqual(keys, []string{"AA0196CTK~PDF", "AA0196BTK~PDF"}) {
+		t.Errorf(`PartNumMatchKeys() with aliases = %v`, keys)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/redirect"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// This program records a partial fingerprint (see internal/hashing) for documents in a catalog: the MD5 checksum of
+// just the first and last blocks of the file, and its size. For a remote file only those blocks are downloaded, using
+// HTTP Range requests, so even a huge PDF can be fingerprinted in a moment. build-master uses the fingerprints to rule
+// out matches between documents that have no MD5 checksum to compare.
+//
+// A document's file is read from the archive if it is held on a volume or in a tree whose root is given (--archive-root,
+// --volume VOLUME=PATH and --tree-root, see internal/locator), and otherwise fetched from its first remote location.
+// Documents that already have a fingerprint taken with the same --block-size are skipped unless --refresh is given.
+// A server that does not support Range requests is reported, rather than the whole file being downloaded. A URL that
+// permanently redirects is moved to where it leads, and the redirect is recorded (see document.AddRedirects).
+//
+// The catalog is rewritten in place unless --yaml-output is given. An interrupted run keeps the fingerprints taken so far.
+//
+// To run the program:
+//   go run fingerprint-catalog/fingerprint-catalog.go --workers 4 --where 'format = PDF' bin/yaml/vaxhaven.yaml
+//
+
+type Document = document.Document
+
+// Job is one document to fingerprint, from either a local file or a URL
+type Job struct {
+	Key   string
+	Local string         // The catalog filepath of a local copy whose root was given
+	Url   string         // The URL of a remote copy, if there is no local one
+	Print string         // The fingerprint, once taken
+	Chain redirect.Chain // The redirects followed to fetch a remote copy
+	Err   error
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute, Transport: metrics.Transport(nil)}
+
+func main() {
+	locator := locator.Flags()
+	blockKB := flag.Int64("block-size", hashing.DefaultFingerprintBlock/1024, "size in KB of the blocks hashed at the start and end of each file")
+	refresh := flag.Bool("refresh", false, "fingerprint documents again even if they already have a fingerprint")
+	workers := flag.Int("workers", 1, "number of files to fingerprint concurrently")
+	where := flag.String("where", "", "fingerprint only documents selected by this filter expression, e.g. 'format = PDF and size > 50000000'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	if *blockKB <= 0 {
+		exitcode.UsageError("--block-size must be at least 1 (KB)")
+	}
+	block := *blockKB * 1024
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	var jobs []Job
+	for _, key := range documents.Where(filter).Keys() {
+		doc := documents[key]
+		if !*refresh && HasFingerprint(doc, block) {
+			continue
+		}
+		job := Job{Key: key}
+		if job.Local, job.Url, err = Source(locator, doc); err != nil {
+			exitcode.WarningAt("unfingerprinted-document", doc.Filepath, "SKIPPED: %s (%s)\n", doc.Filepath, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	fmt.Printf("Fingerprinting %d documents using %d workers\n", len(jobs), *workers)
+	keepGoing := func() bool { return !interrupt.Requested() }
+	results, completed := pipeline.MapWhile(jobs, *workers, keepGoing, func(worker int, job Job) Job {
+		if job.Local != "" {
+			fsys, name, _ := locator.Locate(job.Local)
+			job.Print, job.Err = hashing.FingerprintFS(fsys, name, block)
+		} else {
+			job.Print, job.Chain, job.Err = hashing.FingerprintURL(httpClient, job.Url, documents[job.Key].Format, block)
+		}
+		return job
+	})
+	recorded, moved := 0, 0
+	for _, job := range results[:completed] {
+		doc := documents[job.Key]
+		if job.Err != nil {
+			exitcode.WarningAt("unfingerprinted-document", doc.Filepath, "FAILED: %s (%s)\n", doc.Filepath, job.Err)
+			continue
+		}
+		if *verbose {
+			fmt.Printf("%s %s\n", job.Print, doc.Filepath)
+		}
+		if size, found := hashing.FingerprintSize(job.Print); found && (doc.Size != 0) && (size != doc.Size) {
+			exitcode.WarningAt("size-mismatch", doc.Filepath, "WARNING: %s has size %d, but the catalog says %d\n", doc.Filepath, size, doc.Size)
+		}
+		doc.Fingerprint = job.Print
+		if document.AddRedirects(&doc, job.Chain.Redirects()) {
+			if *verbose {
+				fmt.Printf("%s moved to %s\n", job.Url, job.Chain.Canonical())
+			}
+			moved += 1
+		}
+		documents[job.Key] = doc
+		recorded += 1
+	}
+	fmt.Printf("Recorded %d fingerprints; %d documents moved to the URL they redirect to\n", recorded, moved)
+
+	if *preview && (completed == len(jobs)) {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	if completed < len(jobs) {
+		interrupt.Exit(fmt.Sprintf("%d documents were not fingerprinted; re-run to continue", len(jobs)-completed))
+	}
+
+	exitcode.Exit()
+}
+
+// Reports whether the document already has a fingerprint taken with the given block size
+func HasFingerprint(doc Document, block int64) bool {
+	return strings.HasPrefix(doc.Fingerprint, fmt.Sprintf("%d:", block))
+}
+
+// Returns where to read a document's file from: the catalog filepath of its first local copy whose root was given (and
+// is available), or failing that the URL of its first remote copy.
+func Source(locator *locator.Locator, doc Document) (string, string, error) {
+	url := ""
+	for _, location := range doc.Copies {
+		if location.Type == document.RemoteLocation {
+			if url == "" {
+				url = location.Url
+			}
+			continue
+		}
+		if _, _, err := locator.Locate(location.String()); err == nil {
+			return location.String(), "", nil
+		}
+	}
+	if url == "" {
+		return "", "", fmt.Errorf("no local root given and no remote copy")
+	}
+	return "", url, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/locator"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	archiveRoot, volumeRoot := t.TempDir(), t.TempDir()
+	for _, filename := range []string{filepath.Join(archiveRoot, "DEC_0001", "vax", "ka630.pdf"), filepath.Join(volumeRoot, "ka630.pdf")} {
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		os.WriteFile(filename, []byte("%PDF-1.4"), 0644)
+	}
+	locator := &locator.Locator{ArchiveRoot: archiveRoot, VolumeRoots: map[string]string{"DEC_0002": volumeRoot, "DEC_0003": filepath.Join(volumeRoot, "unmounted")}}
+	tests := []struct {
+		filepaths []string
+		local     string
+		url       string
+	}{
+		{[]string{"file:///DEC_0001/vax/ka630.pdf"}, "file:///DEC_0001/vax/ka630.pdf", ""},
+		{[]string{"http://bitsavers.org/pdf/ka630.pdf", "file:///DEC_0003/ka630.pdf", "file:///DEC_0002/ka630.pdf"}, "file:///DEC_0002/ka630.pdf", ""},
+		{[]string{"http://bitsavers.org/pdf/ka630.pdf", "http://vaxhaven.com/ka630.pdf", "vax/ka630.pdf"}, "", "http://bitsavers.org/pdf/ka630.pdf"},
+	}
+	for _, test := range tests {
+		var doc Document
+		for _, path := range test.filepaths {
+			document.AddLocations(&doc, document.LocationOf(path, 0))
+		}
+		local, url, err := Source(locator, doc)
+		if (err != nil) || (local != test.local) || (url != test.url) {
+			t.Errorf(`Source(%q) = %q, %q, %v`, test.filepaths, local, url, err)
+		}
+	}
+	if _, _, err := Source(locator, Document{Copies: []document.FileLocation{document.LocationOf("vax/ka630.pdf", 0)}}); err == nil {
+		t.Errorf(`Source() of a document that cannot be found succeeded`)
+	}
+}
+
+func TestHasFingerprint(t *testing.T) {
+	doc := Document{Fingerprint: "65536:1234:0123456789abcdef0123456789abcdef"}
+	if !HasFingerprint(doc, 65536) || HasFingerprint(doc, 1024) || HasFingerprint(Document{}, 65536) {
+		t.Errorf(`HasFingerprint() is wrong`)
+	}
+}
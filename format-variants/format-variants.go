@@ -0,0 +1,88 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// This program reports the publications that exist in more than one format (e.g. the PDF, TXT and RNO of one manual).
+// Documents are grouped into publications by their normalised part number (see document.NormalisePartNumber);
+// documents without a part number cannot be grouped and are only counted.
+//
+// Such variants inflate the number of apparently distinct documents, so the summary gives both the number of
+// documents and the number of publications. --where restricts the report to the documents selected by a filter
+// expression (see catalog.Expr), e.g. --where 'collection = DEC_0001'.
+//
+// To run the program:
+//   go run format-variants/format-variants.go --verbose bin/local.yaml
+//
+
+func main() {
+	verbose := console.Flags("list the documents of each publication")
+	where := flag.String("where", "", "include only documents selected by this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog")
+	}
+
+	documents := make(catalog.Catalog)
+	for _, filename := range flag.Args() {
+		loaded, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		for _, key := range documents.Add(loaded) {
+			exitcode.WarningAt("duplicate-document", filename, "WARNING: document %s in %s already seen - dropped latter\n", key, filename)
+		}
+	}
+
+	ReportFormatVariants(os.Stdout, documents.Where(filter), *verbose)
+
+	exitcode.Exit()
+}
+
+// Writes the publications with several format variants, followed by a summary.
+func ReportFormatVariants(out io.Writer, documents catalog.Catalog, verbose bool) {
+	variants := documents.FormatVariants()
+	for _, publication := range variants {
+		fmt.Fprintf(out, "%-24s %s\n", publication.PartNum, strings.Join(publication.Formats, ", "))
+		events.Info("format-variants", publication.PartNum, "%s exists as %s", publication.PartNum, strings.Join(publication.Formats, ", "))
+		if verbose {
+			for _, key := range publication.Keys {
+				fmt.Fprintf(out, "    %-5s %s\n", documents[key].Format, documents[key].Filepath)
+			}
+		}
+	}
+
+	withoutPartNum := 0
+	for _, doc := range documents {
+		if document.NormalisePartNumber(doc.PartNum) == "" {
+			withoutPartNum += 1
+		}
+	}
+	fmt.Fprintf(out, "Documents:                          %d\n", len(documents))
+	fmt.Fprintf(out, "Documents without a part number:    %d\n", withoutPartNum)
+	fmt.Fprintf(out, "Publications (by part number):      %d\n", len(documents.Publications()))
+	fmt.Fprintf(out, "Publications with several formats:  %d\n", len(variants))
+}
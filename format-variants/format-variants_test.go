@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/pkg/catalog"
+	"testing"
+)
+
+func TestReportFormatVariants(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {PartNum: "EK-KA630-TM", Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf"},
+		"b": {PartNum: "ek-ka630-tm", Format: "TXT", Filepath: "file:///DEC_0001/ka630.txt"},
+		"c": {PartNum: "AA-0196C-TK", Format: "PDF", Filepath: "file:///DEC_0002/algol.pdf"},
+		"d": {Format: "RNO", Filepath: "file:///DEC_0002/notes.rno"},
+	}
+	var out bytes.Buffer
+	ReportFormatVariants(&out, documents, true)
+	expected := `EKKA630TM                PDF, TXT
+    PDF   file:///DEC_0001/ka630.pdf
+    TXT   file:///DEC_0001/ka630.txt
+Documents:                          4
+Documents without a part number:    1
+Publications (by part number):      2
+Publications with several formats:  1
+`
+	if out.String() != expected {
+		t.Errorf("ReportFormatVariants() wrote:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
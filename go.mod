@@ -5,12 +5,11 @@ go 1.22.0
 toolchain go1.23.3
 
 require (
-	gopkg.in/yaml.v2 v2.4.0
+	github.com/barasher/go-exiftool v1.7.0
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require (
-	github.com/barasher/go-exiftool v1.7.0 // indirect
 	github.com/go-yaml/yaml v2.1.0+incompatible // indirect
 	github.com/unidoc/unipdf/v3 v3.29.0 // indirect
 	golang.org/x/exp v0.0.0-20241217172543-b2144cdd0a67 // indirect
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/internal/xlsx"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+//
+// This program reads back a review spreadsheet: a CSV written by yaml-to-csv, in which volunteers have corrected titles,
+// dates and part numbers, either as CSV or saved from Excel or LibreOffice as .xlsx. Only the fields the reviewers
+// changed are applied to the catalog.
+//
+// Each row is matched to a document by its MD5 checksum and, where several documents share it (or it is blank), by its
+// file path. A field is taken from the row when it differs from the value that was exported; if the catalog has also
+// changed that field since the export, the field is reported as a conflict and left as the catalog has it.
+//
+// The values that were exported are read from the catalog given by --exported, which should be the catalog as it was
+// when yaml-to-csv was run (e.g. as written by catalog-history --at DATE --yaml-output). Without it, every field that
+// differs from the catalog is applied.
+//
+// A title, part number or date taken from the spreadsheet clears the flag (T, P or D) that says the value was guessed
+// by code. A date must be YYYY, YYYY-MM or YYYY-MM-DD. The spreadsheet should be exported without --ascii, or every
+// title with an accent will be read as changed. Read-only documents are never changed.
+// The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run import-review/import-review.go --sheet review.xlsx --exported bin/local-at-export.yaml bin/local.yaml
+//
+
+type Document = document.Document
+
+// The fields that a review can change: the Document field and its value in a record
+var reviewedFields = []struct {
+	Name   string
+	Record func(rec indexcsv.Record) string
+	Field  func(doc *Document) *string
+}{
+	{"Title", func(rec indexcsv.Record) string { return rec.Title }, func(doc *Document) *string { return &doc.Title }},
+	{"PubDate", func(rec indexcsv.Record) string { return rec.Date }, func(doc *Document) *string { return &doc.PubDate }},
+	{"PartNum", func(rec indexcsv.Record) string { return rec.PartNum }, func(doc *Document) *string { return &doc.PartNum }},
+}
+
+// Conflict is a field changed both in the spreadsheet and in the catalog since the export
+type Conflict struct {
+	Key      string
+	Field    string // The Document field, e.g. "Title"
+	Exported string // The value when the spreadsheet was exported
+	Catalog  string // The value now in the catalog
+	Sheet    string // The value in the spreadsheet
+}
+
+// Problem is a row of the spreadsheet that could not be applied
+type Problem struct {
+	Row     int // The row number, counting the header as row 1
+	Message string
+}
+
+// Result describes what an import did
+type Result struct {
+	Changed   []string   // The keys of the documents changed, in row order
+	Fields    int        // The number of fields changed
+	Conflicts []Conflict // The fields not changed because the catalog changed them too
+	Problems  []Problem  // The rows not applied
+}
+
+func main() {
+	sheetFilename := flag.String("sheet", "", "filepath of the reviewed spreadsheet (.csv or .xlsx)")
+	exportedFilename := flag.String("exported", "", "filepath of the catalog as it was when the spreadsheet was exported (default: the catalog as it is now)")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if *sheetFilename == "" {
+		exitcode.UsageError("Please supply the reviewed spreadsheet with --sheet")
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to update")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+	exported := documents.Filter(func(string, Document) bool { return true })
+	if *exportedFilename != "" {
+		if exported, err = catalog.Load(*exportedFilename); err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", *exportedFilename, err)
+		}
+	}
+	records, err := ReadSheet(*sheetFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", *sheetFilename, err)
+	}
+
+	result := Import(documents, exported, records)
+	for _, problem := range result.Problems {
+		exitcode.WarningAt("import-row", *sheetFilename, "WARNING: row %d: %s\n", problem.Row, problem.Message)
+	}
+	for _, conflict := range result.Conflicts {
+		exitcode.WarningAt("import-conflict", inputFilename, "WARNING: %s %s changed since the export: exported %q, catalog now %q, spreadsheet %q\n",
+			conflict.Key, strings.ToLower(conflict.Field), conflict.Exported, conflict.Catalog, conflict.Sheet)
+	}
+	if *verbose {
+		for _, key := range result.Changed {
+			fmt.Printf("Updated %s: %s\n", key, documents[key].Title)
+		}
+	}
+	fmt.Printf("Read %d rows, changed %d fields of %d documents, %d conflicts\n", len(records), result.Fields, len(result.Changed), len(result.Conflicts))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Reads the records of a spreadsheet: an index.csv, or the first worksheet of an .xlsx workbook
+func ReadSheet(filename string) ([]indexcsv.Record, error) {
+	if !strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+		return indexcsv.ReadFile(filename)
+	}
+	rows, err := xlsx.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// A spreadsheet program may write empty cells beyond the last column
+	for i, row := range rows {
+		for (len(row) > 0) && (strings.TrimSpace(row[len(row)-1]) == "") {
+			row = row[:len(row)-1]
+		}
+		rows[i] = row
+	}
+	return indexcsv.FromRows(rows)
+}
+
+// Returns the key of the document a record describes: the one with its MD5 checksum, or, if several have it (or the
+// record has none), the one with its file path as well. Returns an error if no single document matches.
+func Match(documents catalog.Catalog, rec indexcsv.Record) (string, error) {
+	var byMd5, byPath []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		sameMd5 := (rec.Md5 != "") && strings.EqualFold(doc.Md5, rec.Md5)
+		samePath := (rec.Filepath != "") && (doc.Filepath == rec.Filepath)
+		if sameMd5 {
+			byMd5 = append(byMd5, key)
+		}
+		if samePath && (sameMd5 || (rec.Md5 == "")) {
+			byPath = append(byPath, key)
+		}
+	}
+	switch {
+	case len(byMd5) == 1:
+		return byMd5[0], nil
+	case len(byPath) == 1:
+		return byPath[0], nil
+	case (len(byMd5) == 0) && (len(byPath) == 0):
+		return "", fmt.Errorf("no document has MD5 %q and filepath %q", rec.Md5, rec.Filepath)
+	}
+	return "", fmt.Errorf("several documents have MD5 %q and filepath %q", rec.Md5, rec.Filepath)
+}
+
+// Applies the fields the reviewers changed in the records to the documents. A field counts as changed if it differs
+// from the document as it was exported (held in exported under the same key); it is applied only if the catalog
+// still has the exported value.
+func Import(documents catalog.Catalog, exported catalog.Catalog, records []indexcsv.Record) Result {
+	var result Result
+	for i, rec := range records {
+		row := i + 2 // The header is row 1
+		if !rec.IsDocument() {
+			continue
+		}
+		key, err := Match(documents, rec)
+		if err != nil {
+			result.Problems = append(result.Problems, Problem{Row: row, Message: err.Error()})
+			continue
+		}
+		doc := documents[key]
+		before, found := exported[key]
+		if !found {
+			result.Problems = append(result.Problems, Problem{Row: row, Message: fmt.Sprintf("%s was not in the exported catalog", key)})
+			continue
+		}
+
+		changed := 0
+		for _, field := range reviewedFields {
+			sheet := strings.TrimSpace(field.Record(rec))
+			was := *field.Field(&before)
+			now := field.Field(&doc)
+			if (sheet == was) || (sheet == *now) {
+				continue
+			}
+			if *now != was {
+				result.Conflicts = append(result.Conflicts, Conflict{Key: key, Field: field.Name, Exported: was, Catalog: *now, Sheet: sheet})
+				continue
+			}
+			if (field.Name == "PubDate") && (sheet != "") && !document.IsPubDate(sheet) {
+				result.Problems = append(result.Problems, Problem{Row: row, Message: fmt.Sprintf("%q is not a date (YYYY, YYYY-MM or YYYY-MM-DD)", sheet)})
+				continue
+			}
+			if document.IsReadOnly(doc) {
+				result.Problems = append(result.Problems, Problem{Row: row, Message: fmt.Sprintf("%s is read-only (from %s)", key, doc.Origin)})
+				break
+			}
+			*now = sheet
+			document.ClearFlags(&doc, document.CodeSetFlags[field.Name])
+			changed += 1
+		}
+		if changed > 0 {
+			documents[key] = doc
+			if !slices.Contains(result.Changed, key) {
+				result.Changed = append(result.Changed, key)
+			}
+			result.Fields += changed
+		}
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	documents := catalog.Catalog{
+		"a":  {Md5: "aaaa", Filepath: "file:///DEC_0001/ka630.pdf"},
+		"b1": {Md5: "bbbb", Filepath: "file:///DEC_0001/rx50.pdf"},
+		"b2": {Md5: "bbbb", Filepath: "file:///DEC_0002/rx50.pdf"},
+		"c":  {Filepath: "file:///DEC_0003/notes.txt"},
+	}
+	for _, test := range []struct {
+		md5      string
+		filepath string
+		expected string
+	}{
+		{"AAAA", "moved.pdf", "a"},
+		{"bbbb", "file:///DEC_0002/rx50.pdf", "b2"},
+		{"", "file:///DEC_0003/notes.txt", "c"},
+		{"bbbb", "file:///DEC_0009/rx50.pdf", ""},
+		{"cccc", "file:///DEC_0003/notes.txt", ""},
+	} {
+		key, err := Match(documents, indexcsv.Record{Md5: test.md5, Filepath: test.filepath})
+		if (key != test.expected) || ((err == nil) != (test.expected != "")) {
+			t.Errorf("Match(%q, %q) = %q, %v, expected %q", test.md5, test.filepath, key, err, test.expected)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	exported := catalog.Catalog{
+		"a": {Md5: "a", Title: "KA630 Manual", PubDate: "1986", PartNum: "EK-KA630-TM", Flags: "TD"},
+		"b": {Md5: "b", Title: "RX50 Guide", PubDate: "1984"},
+		"c": {Md5: "c", Title: "VT100 Manual", Origin: "bitsavers"},
+	}
+	documents := exported.Filter(func(string, Document) bool { return true })
+	// Since the export, the catalog's title for b was corrected
+	b := documents["b"]
+	b.Title = "RX50 User Guide"
+	documents["b"] = b
+
+	sheet := `Record,Title,File,URL,Date,Part Number,MD5 Checksum,Options
+Doc,KA630 CPU Module Technical Manual,,,1987-01,EK-KA630-TM,a,'collection=local'
+Doc,RX50 Owner's Guide,,,1985,,b,'collection=local'
+Doc,VT100 Manual,,,Jan78,,c,
+Doc,Unknown,,,,,d,
+`
+	records, err := indexcsv.Read(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Import(documents, exported, records)
+
+	if !reflect.DeepEqual(result.Changed, []string{"a", "b"}) || (result.Fields != 3) {
+		t.Errorf("Import() changed %q (%d fields)", result.Changed, result.Fields)
+	}
+	if a := documents["a"]; (a.Title != "KA630 CPU Module Technical Manual") || (a.PubDate != "1987-01") || (a.Flags != "") {
+		t.Errorf("Import() gave %+v", a)
+	}
+	if b := documents["b"]; (b.Title != "RX50 User Guide") || (b.PubDate != "1985") {
+		t.Errorf("Import() gave %+v", b)
+	}
+	expected := []Conflict{{Key: "b", Field: "Title", Exported: "RX50 Guide", Catalog: "RX50 User Guide", Sheet: "RX50 Owner's Guide"}}
+	if !reflect.DeepEqual(result.Conflicts, expected) {
+		t.Errorf("Import() gave conflicts %+v", result.Conflicts)
+	}
+	if (len(result.Problems) != 2) || (result.Problems[0].Row != 4) || (result.Problems[1].Row != 5) {
+		t.Errorf("Import() gave problems %+v", result.Problems)
+	}
+}
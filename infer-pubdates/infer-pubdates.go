@@ -0,0 +1,171 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/pubdate"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+)
+
+//
+// This program fills in the blank publication dates of the local documents in a catalog from the text of each
+// document's first page (see internal/pubdate), for the many scans whose title page says e.g. "March 1987". The first
+// page of a PDF is read by pdftotext (from poppler-utils), so only PDFs with a text layer give anything (see ocr-queue);
+// TXT, MEM and RNO files are read directly.
+//
+// Each date found is recorded with datesource "inferred" and a confidence from 0 to 1, and flagged as set by code
+// (D), so it is never mistaken for a date given by a catalog or by hand, and is replaced by one (e.g. edit-catalog
+// --set pubdate=...). Only years within --years (by default 1957-2010) count, and dates with a confidence below
+// --min-confidence are not recorded. Documents whose date was inferred before are only looked at again with --recheck
+// (e.g. after they have been OCRed).
+//
+// Local documents are found under --archive-root, --volume VOLUME=PATH and --tree-root (see internal/locator); a
+// document in a remote root is fetched to a temporary copy to be read. --where restricts the documents considered. The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run infer-pubdates/infer-pubdates.go --archive-root /mnt/archive --min-confidence 0.6 bin/local.yaml
+//
+
+type Document = document.Document
+
+func main() {
+	locator := locator.Flags()
+	dates := pubdate.DefaultRange
+	flag.Func("years", "the years a publication date may fall in, as YYYY-YYYY (default 1957-2010)", func(s string) error {
+		var err error
+		dates, err = pubdate.ParseRange(s)
+		return err
+	})
+	minConfidence := flag.Float64("min-confidence", 0, "record only dates inferred with at least this confidence (0 to 1)")
+	recheck := flag.Bool("recheck", false, "look again at documents whose date was inferred before")
+	where := flag.String("where", "", "consider only documents selected by this filter expression, e.g. 'collection = local:DEC_0001'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	if (*minConfidence < 0) || (*minConfidence > 1) {
+		exitcode.UsageErrorf("--min-confidence must be between 0 and 1, found %v", *minConfidence)
+	}
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that documents can be found")
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selected := documents.Where(filter)
+	examined, inferred := 0, 0
+	for _, key := range selected.Keys() {
+		doc := documents[key]
+		if !NeedsInference(doc, *recheck) || interrupt.Requested() {
+			continue
+		}
+		filename, release, err := locator.LocalFile(doc.Filepath)
+		var inference pubdate.Inference
+		var found bool
+		if err == nil {
+			inference, found, err = InferDocument(&doc, filename, dates, *minConfidence)
+			release()
+		}
+		if err != nil {
+			exitcode.WarningAt("pubdate-unread", doc.Filepath, "UNREAD: %s (%s)\n", doc.Filepath, err)
+			continue
+		}
+		documents[key] = doc
+		examined += 1
+		if !found {
+			if *verbose {
+				fmt.Printf("%-8s %s\n", "none", doc.Filepath)
+			}
+			continue
+		}
+		if *verbose {
+			events.Info("pubdate-inferred", doc.Filepath, "%-8s %s (%.1f from %q)\n", inference.Date, doc.Filepath, inference.Confidence, inference.Evidence)
+		}
+		inferred += 1
+	}
+	fmt.Printf("Inferred the publication dates of %d of the %d documents examined\n", inferred, examined)
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	if interrupt.Requested() {
+		interrupt.Exit("some documents were not examined; re-run to continue")
+	}
+	exitcode.Exit()
+}
+
+// Reports whether a document's date is to be inferred: it is a local document whose first page can be read, is not
+// read-only, and has no publication date (or, if recheck is set, has one that was inferred)
+func NeedsInference(doc Document, recheck bool) bool {
+	if !pubdate.Readable(doc.Format) || !retention.IsLocal(doc) || document.IsReadOnly(doc) {
+		return false
+	}
+	return (doc.PubDate == "") || (recheck && (doc.DateSource == pubdate.Inferred))
+}
+
+// Reads the first page of a document's file and records the date found in it, if there is one within dates and with
+// at least minConfidence. A date inferred before that can no longer be found is removed. Returns the date found and
+// whether it was recorded.
+func InferDocument(doc *Document, filename string, dates pubdate.Range, minConfidence float64) (pubdate.Inference, bool, error) {
+	text, err := pubdate.FirstPageText(filename, doc.Format)
+	if err != nil {
+		return pubdate.Inference{}, false, err
+	}
+	inference, found := pubdate.Infer(text, dates)
+	if !found || (inference.Confidence < minConfidence) {
+		if doc.DateSource == pubdate.Inferred {
+			doc.PubDate = ""
+			pubdate.Clear(doc)
+			document.ClearFlags(doc, document.CodeSetFlags["PubDate"])
+		}
+		return inference, false, nil
+	}
+	pubdate.Apply(doc, inference)
+	return inference, true, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"docs-to-yaml/internal/pubdate"
+	"errors"
+	"testing"
+)
+
+func TestNeedsInference(t *testing.T) {
+	for _, test := range []struct {
+		doc      Document
+		recheck  bool
+		expected bool
+	}{
+		{Document{Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf"}, false, true},
+		{Document{Format: "TXT", Filepath: "rsx/notes.txt"}, false, true},
+		{Document{Format: "JPEG", Filepath: "file:///DEC_0001/front.jpg"}, false, false},
+		{Document{Format: "PDF", Filepath: "http://bitsavers.org/pdf/dec/ka630.pdf"}, false, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PubDate: "1987-03"}, true, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PubDate: "1987-03", DateSource: pubdate.Inferred}, false, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PubDate: "1987-03", DateSource: pubdate.Inferred}, true, true},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", Origin: "reference.yaml"}, false, false},
+	} {
+		if needed := NeedsInference(test.doc, test.recheck); needed != test.expected {
+			t.Errorf("NeedsInference(%+v, %v) = %v", test.doc, test.recheck, needed)
+		}
+	}
+}
+
+func TestInferDocument(t *testing.T) {
+	defer func(firstPageText func(string, string) (string, error)) { pubdate.FirstPageText = firstPageText }(pubdate.FirstPageText)
+	pages := map[string]string{
+		"ka630.pdf": "KA630 CPU Module User's Guide\nMarch 1987",
+		"vaxar.pdf": "VAX Architecture Handbook\nCopyright (c) 1981",
+		"blank.pdf": "",
+	}
+	pubdate.FirstPageText = func(filename string, format string) (string, error) {
+		if page, found := pages[filename]; found {
+			return page, nil
+		}
+		return "", errors.New("pdftotext: exit status 1")
+	}
+
+	doc := Document{Format: "PDF"}
+	if inference, recorded, err := InferDocument(&doc, "ka630.pdf", pubdate.DefaultRange, 0.6); !recorded || (err != nil) || (doc.PubDate != "1987-03") || (doc.DateSource != pubdate.Inferred) || (doc.Confidence != inference.Confidence) || (doc.Flags != "D") {
+		t.Errorf(`InferDocument() = %+v, %v, %v giving %+v`, inference, recorded, err, doc)
+	}
+
+	// A copyright year alone is not confident enough
+	doc = Document{Format: "PDF"}
+	if _, recorded, err := InferDocument(&doc, "vaxar.pdf", pubdate.DefaultRange, 0.6); recorded || (err != nil) || (doc.PubDate != "") {
+		t.Errorf(`InferDocument() of a copyright year = %v, %v giving %+v`, recorded, err, doc)
+	}
+
+	// A date inferred before that can no longer be found is removed
+	doc = Document{Format: "PDF", PubDate: "1985", DateSource: pubdate.Inferred, Confidence: 0.5, Flags: "D"}
+	if _, recorded, err := InferDocument(&doc, "blank.pdf", pubdate.DefaultRange, 0); recorded || (err != nil) || (doc.PubDate != "") || (doc.DateSource != "") {
+		t.Errorf(`InferDocument() of a page without a date = %v, %v giving %+v`, recorded, err, doc)
+	}
+
+	if _, _, err := InferDocument(&Document{Format: "PDF"}, "missing.pdf", pubdate.DefaultRange, 0); err == nil {
+		t.Errorf(`InferDocument() of an unreadable file succeeded`)
+	}
+}
@@ -0,0 +1,211 @@
+package archivecategory
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// This package works out which category a local archive volume falls into, by looking for the index files
+// and directories found at the root of each kind of volume. The category determines how the volume is processed.
+//
+// Detection does not print anything. Instead it returns the evidence found and any problems that prevented
+// the volume being classified, so that each tool can report them in its own way.
+//
+// The categories are:
+//
+//	Regular:  index.htm is a list of documents
+//	HTML:     INDEX.HTM links to further index files in HTML/
+//	Metadata: index.htm links to further index files in metadata/
+//	Custom:   index.htm links both to documents and to further index files (marked by DEC_0040.CRC)
+//	CSV:      index.csv describes the documents (a volume built with file-tree-to-yaml)
+
+// Implement an enum for Category
+type Category int
+
+// These are the legal Category enum values
+const (
+	Undefined Category = iota
+	CSV
+	Regular
+	HTML
+	Metadata
+	Custom
+)
+
+// This turns Category enums into a text string
+func (category Category) String() string {
+	return [...]string{"AC_Undefined", "AC_CSV", "AC_Regular", "AC_HTML", "AC_Metadata", "AC_Custom"}[category]
+}
+
+// The names by which a category can be chosen by hand (e.g. "category=metadata" in an indirect file)
+var names = map[string]Category{"csv": CSV, "regular": Regular, "html": HTML, "metadata": Metadata, "custom": Custom}
+
+// Returns the category with the specified name (regular, html, metadata, custom or csv), ignoring case.
+func Parse(name string) (Category, error) {
+	if category, found := names[strings.ToLower(name)]; found {
+		return category, nil
+	}
+	return Undefined, fmt.Errorf("unknown category %q (expected regular, html, metadata, custom or csv)", name)
+}
+
+// Evidence records which of the distinguishing files and directories are present at the root of a volume.
+// File names are matched exactly (including case) so that index.htm and INDEX.HTM can be told apart
+// even when the volume is on a case-insensitive filesystem.
+type Evidence struct {
+	IndexHtm        bool // index.htm
+	INDEXHTM        bool // INDEX.HTM
+	IndexCsv        bool // index.csv
+	CustomIndicator bool // DEC_0040.CRC
+	HTMLDir         bool // HTML/
+	MetadataDir     bool // metadata/
+}
+
+// Lists the evidence found, for reporting
+func (evidence Evidence) String() string {
+	var found []string
+	for _, item := range []struct {
+		present bool
+		name    string
+	}{
+		{evidence.IndexHtm, "index.htm"},
+		{evidence.INDEXHTM, "INDEX.HTM"},
+		{evidence.IndexCsv, "index.csv"},
+		{evidence.CustomIndicator, "DEC_0040.CRC"},
+		{evidence.HTMLDir, "HTML/"},
+		{evidence.MetadataDir, "metadata/"},
+	} {
+		if item.present {
+			found = append(found, item.name)
+		}
+	}
+	if len(found) == 0 {
+		return "nothing recognisable"
+	}
+	return strings.Join(found, ", ")
+}
+
+// Result is the outcome of examining a volume
+type Result struct {
+	Root     string   // The volume root that was examined
+	Category Category // The category; Undefined if the volume could not be classified
+	Evidence Evidence // What was found at the volume root
+	Problems []string // Why the volume could not be classified (or inconsistencies found), if any
+}
+
+// Returns true if the volume was classified without problems
+func (result Result) Valid() bool {
+	return (result.Category != Undefined) && (len(result.Problems) == 0)
+}
+
+// Summarises the result on a single line, for reporting
+func (result Result) String() string {
+	summary := fmt.Sprintf("%s (found %s)", result.Category, result.Evidence)
+	if len(result.Problems) > 0 {
+		summary += ": " + strings.Join(result.Problems, "; ")
+	}
+	return summary
+}
+
+// Gathers the evidence at the root of the specified volume.
+func FindEvidence(archiveRoot string) Evidence {
+	return FindEvidenceFS(os.DirFS(archiveRoot))
+}
+
+// Gathers the evidence at the root of a volume that is accessed through fsys (e.g. a remote archive root).
+// Names must match exactly, including case, even where the filesystem itself ignores case.
+func FindEvidenceFS(fsys fs.FS) Evidence {
+	names := make(map[string]bool)
+	if entries, err := fs.ReadDir(fsys, "."); err == nil {
+		for _, entry := range entries {
+			names[entry.Name()] = true
+		}
+	}
+	isDir := func(name string) bool {
+		info, err := fs.Stat(fsys, name)
+		return names[name] && (err == nil) && info.IsDir()
+	}
+	return Evidence{
+		IndexHtm:        names["index.htm"],
+		INDEXHTM:        names["INDEX.HTM"],
+		IndexCsv:        names["index.csv"],
+		CustomIndicator: names["DEC_0040.CRC"],
+		HTMLDir:         isDir("HTML"),
+		MetadataDir:     isDir("metadata"),
+	}
+}
+
+// Examines the root of the specified volume and works out its category.
+func Detect(archiveRoot string) Result {
+	return DetectFS(os.DirFS(archiveRoot), archiveRoot)
+}
+
+// Examines the root of a volume accessed through fsys and works out its category. root describes the volume in the result.
+func DetectFS(fsys fs.FS, root string) Result {
+	result := Classify(FindEvidenceFS(fsys))
+	result.Root = root
+	return result
+}
+
+// Works out the category implied by the supplied evidence.
+func Classify(evidence Evidence) Result {
+	result := Result{Category: Undefined, Evidence: evidence}
+	problem := func(text string) {
+		result.Problems = append(result.Problems, text)
+	}
+
+	if evidence.INDEXHTM {
+		if !evidence.HTMLDir {
+			problem("found INDEX.HTM but no HTML/")
+		}
+		if evidence.IndexHtm || evidence.MetadataDir || evidence.CustomIndicator {
+			problem("found INDEX.HTM with one or more of index.htm, metadata/ or DEC_0040.CRC")
+		}
+		if len(result.Problems) == 0 {
+			result.Category = HTML
+		}
+	} else if evidence.HTMLDir {
+		problem("found HTML/ but no INDEX.HTM")
+	}
+
+	// A volume with neither index.htm nor INDEX.HTM can still be described by an index.csv
+	if !evidence.IndexHtm && !evidence.INDEXHTM && evidence.IndexCsv && (len(result.Problems) == 0) {
+		result.Category = CSV
+		return result
+	}
+
+	if !evidence.IndexHtm && (result.Category != HTML) {
+		problem("no index.htm found")
+	}
+
+	if evidence.MetadataDir {
+		if evidence.CustomIndicator {
+			problem("found both metadata/ and DEC_0040.CRC")
+		}
+		if len(result.Problems) == 0 {
+			result.Category = Metadata
+		}
+	}
+
+	if evidence.CustomIndicator && (len(result.Problems) == 0) {
+		result.Category = Custom
+	}
+
+	if (len(result.Problems) == 0) && (result.Category == Undefined) {
+		result.Category = Regular
+	}
+
+	// A volume with problems is never given a category, so that it is not processed
+	if len(result.Problems) > 0 {
+		result.Category = Undefined
+	}
+
+	return result
+}
+
+// Returns true if the specified path is a subdirectory
+func SubdirectoryExists(path string) bool {
+	info, err := os.Stat(path)
+	return (err == nil) && info.IsDir()
+}
@@ -0,0 +1,90 @@
+package archivecategory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		evidence Evidence
+		expected Category
+		problems int
+	}{
+		{"regular", Evidence{IndexHtm: true}, Regular, 0},
+		{"html", Evidence{INDEXHTM: true, HTMLDir: true}, HTML, 0},
+		{"metadata", Evidence{IndexHtm: true, MetadataDir: true}, Metadata, 0},
+		{"custom", Evidence{IndexHtm: true, CustomIndicator: true}, Custom, 0},
+		{"csv", Evidence{IndexCsv: true}, CSV, 0},
+		{"regular with csv", Evidence{IndexHtm: true, IndexCsv: true}, Regular, 0},
+		{"empty", Evidence{}, Undefined, 1},
+		{"INDEX.HTM without HTML/", Evidence{INDEXHTM: true}, Undefined, 2},
+		{"HTML/ without INDEX.HTM", Evidence{IndexHtm: true, HTMLDir: true}, Undefined, 1},
+		{"INDEX.HTM and index.htm", Evidence{INDEXHTM: true, HTMLDir: true, IndexHtm: true}, Undefined, 1},
+		{"metadata and custom", Evidence{IndexHtm: true, MetadataDir: true, CustomIndicator: true}, Undefined, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Classify(test.evidence)
+			if (result.Category != test.expected) || (len(result.Problems) != test.problems) {
+				t.Errorf(`Classify(%s) = %s, expected %s with %d problems`, test.evidence, result, test.expected, test.problems)
+			}
+			if result.Valid() != (test.problems == 0) {
+				t.Errorf(`Classify(%s).Valid() = %t`, test.evidence, result.Valid())
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "INDEX.HTM"), []byte("x"), 0644); err != nil {
+		t.Fatalf(`cannot create INDEX.HTM: %v`, err)
+	}
+	// A file called HTML is not the HTML/ directory
+	if err := os.WriteFile(filepath.Join(root, "HTML"), []byte("x"), 0644); err != nil {
+		t.Fatalf(`cannot create HTML: %v`, err)
+	}
+
+	result := Detect(root)
+	if result.Valid() || result.Evidence.HTMLDir || !result.Evidence.INDEXHTM {
+		t.Fatalf(`Detect() with a file called HTML = %s`, result)
+	}
+
+	if err := os.Remove(filepath.Join(root, "HTML")); err != nil {
+		t.Fatalf(`cannot remove HTML: %v`, err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "HTML"), 0755); err != nil {
+		t.Fatalf(`cannot create HTML/: %v`, err)
+	}
+	result = Detect(root)
+	if !result.Valid() || (result.Category != HTML) || (result.Root != root) {
+		t.Fatalf(`Detect() = %s, expected %s`, result, HTML)
+	}
+}
+
+func TestDetectFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.htm":         {Data: []byte("x")},
+		"metadata/0001.htm": {Data: []byte("x")},
+	}
+	result := DetectFS(fsys, "sftp://nas/volume1/DEC_0002")
+	if !result.Valid() || (result.Category != Metadata) || (result.Root != "sftp://nas/volume1/DEC_0002") {
+		t.Fatalf(`DetectFS() = %s, expected %s`, result, Metadata)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for name, expected := range map[string]Category{"regular": Regular, "HTML": HTML, "Metadata": Metadata, "custom": Custom, "csv": CSV} {
+		if category, err := Parse(name); (err != nil) || (category != expected) {
+			t.Errorf("Parse(%q) = %s, %v, expected %s", name, category, err, expected)
+		}
+	}
+	if _, err := Parse("undefined"); err == nil {
+		t.Errorf(`Parse("undefined") succeeded`)
+	}
+}
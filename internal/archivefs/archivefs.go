@@ -0,0 +1,376 @@
+package archivefs
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/fsutil"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This package gives read-only access to an archive root, wherever it lives, through the io/fs interfaces.
+//
+// An archive root is either a local directory (a native path, e.g. /mnt/nas/DEC_0001) or a directory on a machine that
+// can be reached but not mounted:
+//
+//   sftp://[user@]host[:port]/path/to/root    read with SFTP over ssh (which must be able to log in without a password
+//                                             prompt); the remote machine needs only its sftp subsystem
+//   smb://[user@]host/share/path/to/root      read with smbclient (the password, if any, comes from smbclient's usual
+//                                             sources, e.g. the PASSWD environment variable or an authentication file)
+//   s3://bucket/prefix                        read from S3-compatible object storage over HTTP (see S3Config)
+//
+// The sftp and smb backends run the system ssh and smbclient programs rather than linking SSH or SMB client libraries;
+// the sftp backend speaks the SFTP protocol itself over the one ssh connection it keeps for each root.
+// Names within an FS are always "/"-separated and relative to the root, as for any fs.FS.
+//
+// Every program that walks, hashes or extracts metadata from an archive does so through an FS, so a new kind of
+// storage only needs a backend (which lists a directory and reads a file) and an entry in the backends table below.
+// Tools that must have a native file (exiftool, --exec commands) get one from LocalFile, which fetches a temporary
+// copy of a remote file.
+
+// FS is an archive root
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	// Describes where the named file lives, for messages: a native path for a local root, a URL for a remote one
+	Location(name string) string
+	// Returns the native path of the named file, or "" if the root is remote and the file has no local path
+	LocalPath(name string) string
+	// Reports whether names are matched case-sensitively
+	CaseSensitive() bool
+}
+
+// The remote backends, by URL scheme
+var backends = map[string]func(u *url.URL) (FS, error){
+	"sftp": newSftpFS,
+	"smb":  newSmbFS,
+	"s3":   newS3FS,
+}
+
+// Reports whether root names a remote archive root (i.e. is a URL with a supported scheme)
+func IsRemote(root string) bool {
+	scheme, _, found := strings.Cut(root, "://")
+	_, supported := backends[scheme]
+	return found && supported
+}
+
+// Opens an archive root. Nothing is read from a remote root until it is used.
+func Open(root string) (FS, error) {
+	if !IsRemote(root) {
+		return &localFS{FS: os.DirFS(root), root: root, caseSensitive: fsutil.IsCaseSensitive(root)}, nil
+	}
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host in %s", root)
+	}
+	return backends[u.Scheme](u)
+}
+
+// Checks that an archive root can be read and is not empty. An unmounted share or an unreachable machine is reported as an error.
+func CheckAvailable(root string) error {
+	if !IsRemote(root) {
+		return fsutil.CheckDirAvailable(root)
+	}
+	fsys, err := Open(root)
+	if err != nil {
+		return err
+	}
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s is empty", root)
+	}
+	return nil
+}
+
+// Joins a root (local or remote) and a "/"-separated name within it, giving the root of that subdirectory
+func Join(root string, name string) string {
+	if !IsRemote(root) {
+		return fsutil.JoinSlashPath(root, name)
+	}
+	return remoteLocation(root, name)
+}
+
+// Returns the MD5 checksum of the named file if the FS already knows it without reading the file (as object storage
+// usually does), so that the file need not be fetched just to hash it.
+func KnownMd5(fsys fs.FS, name string) (string, bool) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return "", false
+	}
+	if remote, ok := info.(fileInfo); ok && (remote.md5 != "") {
+		return remote.md5, true
+	}
+	return "", false
+}
+
+// Returns a native path for the named file, for tools that cannot read through an FS. A file in a local root is
+// used where it is; a remote file is copied to a temporary file (with the same extension, as some tools go by it).
+// The caller must call release once it has finished with the file.
+func LocalFile(fsys FS, name string) (localPath string, release func(), err error) {
+	if localPath := fsys.LocalPath(name); localPath != "" {
+		return localPath, func() {}, nil
+	}
+	source, err := fsys.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer source.Close()
+	temporary, err := os.CreateTemp("", "archivefs-*"+path.Ext(name))
+	if err != nil {
+		return "", nil, err
+	}
+	release = func() { os.Remove(temporary.Name()) }
+	_, err = io.Copy(temporary, source)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		release()
+		return "", nil, fmt.Errorf("cannot fetch %s: %w", fsys.Location(name), err)
+	}
+	return temporary.Name(), release, nil
+}
+
+// Computes the location of a file in a remote root, given the root's URL
+func remoteLocation(root string, name string) string {
+	if name == "." {
+		return root
+	}
+	return strings.TrimSuffix(root, "/") + "/" + name
+}
+
+// localFS is a local directory
+type localFS struct {
+	fs.FS
+	root          string
+	caseSensitive bool
+}
+
+func (l *localFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(l.FS, name) }
+func (l *localFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(l.FS, name) }
+func (l *localFS) Location(name string) string                { return fsutil.JoinSlashPath(l.root, name) }
+func (l *localFS) LocalPath(name string) string               { return fsutil.JoinSlashPath(l.root, name) }
+func (l *localFS) CaseSensitive() bool                        { return l.caseSensitive }
+
+// backend is how a remote FS reaches its files
+type backend interface {
+	open(name string) (io.ReadCloser, error)
+	readDir(name string) ([]fs.DirEntry, error)
+}
+
+// remoteFS is an archive root on another machine. An archive does not change while it is being read, so each directory
+// is listed only once; files are described from the listing of their directory rather than by a command of their own.
+type remoteFS struct {
+	backend
+	root          string // The URL of the root
+	caseSensitive bool
+	mutex         sync.Mutex
+	dirs          map[string][]fs.DirEntry // Directory listings already fetched
+}
+
+func (r *remoteFS) Open(name string) (fs.File, error) {
+	info, err := r.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+	}
+	return &remoteFile{fsys: r, name: name, info: info}, nil
+}
+
+func (r *remoteFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		if _, err := r.ReadDir("."); err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+		}
+		return fileInfo{name: ".", dir: true}, nil
+	}
+	entries, err := r.ReadDir(path.Dir(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	base := path.Base(name)
+	for _, entry := range entries {
+		if (entry.Name() == base) || (!r.caseSensitive && strings.EqualFold(entry.Name(), base)) {
+			info := entry.(fileInfo)
+			info.name = base
+			return info, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (r *remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entries, found := r.dirs[name]; found {
+		return slices.Clone(entries), nil
+	}
+	entries, err := r.backend.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if r.dirs == nil {
+		r.dirs = make(map[string][]fs.DirEntry)
+	}
+	r.dirs[name] = entries
+	return slices.Clone(entries), nil
+}
+
+func (r *remoteFS) Location(name string) string  { return remoteLocation(r.root, name) }
+func (r *remoteFS) LocalPath(name string) string { return "" }
+func (r *remoteFS) CaseSensitive() bool          { return r.caseSensitive }
+
+// remoteFile is an open file in a remote root. Its contents are only fetched when it is first read.
+type remoteFile struct {
+	fsys    *remoteFS
+	name    string
+	info    fs.FileInfo
+	content io.ReadCloser
+	done    bool // The command supplying the contents has finished
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if f.content == nil {
+		content, err := f.fsys.backend.open(f.name)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.content = content
+	}
+	if f.done {
+		return 0, io.EOF
+	}
+	n, err := f.content.Read(p)
+	// A command that fails part way through must not look like a short file, so its failure is reported in place of EOF
+	if err == io.EOF {
+		f.done = true
+		if closeErr := f.content.Close(); closeErr != nil {
+			return n, &fs.PathError{Op: "read", Path: f.name, Err: closeErr}
+		}
+	}
+	return n, err
+}
+
+func (f *remoteFile) Close() error {
+	if (f.content == nil) || f.done {
+		return nil
+	}
+	f.done = true
+	return f.content.Close()
+}
+
+// fileInfo describes a remote file; it serves as both fs.FileInfo and fs.DirEntry
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	md5     string // The MD5 checksum of the contents, if the backend supplies it
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() any           { return nil }
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+func (i fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// Starts a command and returns its standard output. Closing the output waits for the command and reports
+// its failure (with whatever it wrote to standard error). Tests replace this to avoid running smbclient.
+var startCommand = func(name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandOutput{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+// commandOutput is the standard output of a running command
+type commandOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (c *commandOutput) Close() error {
+	// Drain anything unread, so that the command is not blocked writing to a full pipe
+	io.Copy(io.Discard, c.ReadCloser)
+	err := c.cmd.Wait()
+	if err != nil {
+		if message := strings.TrimSpace(c.stderr.String()); message != "" {
+			return fmt.Errorf("%s: %w: %s", c.cmd.Args[0], err, message)
+		}
+		return fmt.Errorf("%s: %w", c.cmd.Args[0], err)
+	}
+	return nil
+}
+
+// Runs a command to completion and returns its standard output
+func runCommand(name string, args ...string) ([]byte, error) {
+	output, err := startCommand(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	data, readErr := io.ReadAll(output)
+	if err := output.Close(); err != nil {
+		return nil, err
+	}
+	return data, readErr
+}
+
+// Joins the remote directory of a root and a name within it
+func remotePath(dir string, name string) string {
+	if name == "." {
+		return dir
+	}
+	return path.Join(dir, name)
+}
+
+// Returns the last element of a name, as fs.FileInfo.Name does
+func baseName(name string) string {
+	return path.Base(name)
+}
+
+// Sorts directory entries by name, as fs.ReadDir does
+func sortEntries(entries []fs.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+}
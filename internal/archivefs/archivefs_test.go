@@ -0,0 +1,375 @@
+package archivefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Replaces startCommand for the duration of a test. respond returns the output of a command given its arguments.
+func fakeCommands(t *testing.T, respond func(name string, args []string) (string, error)) *[][]string {
+	var commands [][]string
+	saved := startCommand
+	startCommand = func(name string, args ...string) (io.ReadCloser, error) {
+		commands = append(commands, append([]string{name}, args...))
+		output, err := respond(name, args)
+		return &fakeOutput{Reader: strings.NewReader(output), err: err}, nil
+	}
+	t.Cleanup(func() { startCommand = saved })
+	return &commands
+}
+
+// fakeOutput is the output of a fake command; its failure (if any) is reported on Close, as for a real one
+type fakeOutput struct {
+	io.Reader
+	err error
+}
+
+func (f *fakeOutput) Close() error { return f.err }
+
+// fakeSftpServer answers SFTP requests from a map of the remote machine's files (named without the leading "/").
+// A file whose mode is fs.ModeSymlink is a symbolic link to the file named by its contents.
+type fakeSftpServer struct {
+	files       fstest.MapFS
+	connections [][]string        // The arguments ssh was run with, for each connection
+	requests    []string          // Each request, as "type path"
+	handles     map[string]string // The open handles and the paths they were opened for
+	hangUp      string            // The type of request on which to end the connection
+	mutex       sync.Mutex
+}
+
+// Replaces startSftp for the duration of a test with a fake server
+func fakeSftp(t *testing.T, files fstest.MapFS) *fakeSftpServer {
+	server := &fakeSftpServer{files: files, handles: make(map[string]string)}
+	saved := startSftp
+	startSftp = func(args ...string) (io.ReadWriteCloser, error) {
+		client, conn := net.Pipe()
+		server.connections = append(server.connections, args)
+		go server.serve(conn)
+		return client, nil
+	}
+	t.Cleanup(func() { startSftp = saved })
+	return server
+}
+
+func (s *fakeSftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	if _, err := readPacket(conn); err != nil {
+		return
+	}
+	writePacket(conn, sftpPacket{sshFxpVersion}.uint32(3))
+	listed := make(map[string]bool)
+	for {
+		packet, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		request := &sftpReply{data: packet[1:]}
+		id := request.uint32()
+		name := request.string()
+		s.mutex.Lock()
+		kind := map[byte]string{sshFxpOpen: "open", sshFxpOpendir: "opendir", sshFxpReaddir: "readdir", sshFxpRead: "read", sshFxpClose: "close", sshFxpStat: "stat"}[packet[0]]
+		s.requests = append(s.requests, kind+" "+name)
+		hangUp := kind == s.hangUp
+		s.mutex.Unlock()
+		if hangUp {
+			return
+		}
+		reply := sftpPacket{sshFxpStatus}.uint32(id)
+		status := func(code uint32) sftpPacket { return reply.uint32(code).string("").string("") }
+		switch packet[0] {
+		case sshFxpOpen, sshFxpOpendir:
+			if info, err := fs.Stat(s.files, s.resolve(name)); (err != nil) || (info.IsDir() != (packet[0] == sshFxpOpendir)) {
+				reply = status(sshFxNoSuch)
+			} else {
+				s.mutex.Lock()
+				handle := fmt.Sprintf("h%d", id)
+				s.handles[handle] = s.resolve(name)
+				s.mutex.Unlock()
+				reply = sftpPacket{sshFxpHandle}.uint32(id).string(handle)
+			}
+		case sshFxpReaddir:
+			s.mutex.Lock()
+			dir := s.handles[name]
+			s.mutex.Unlock()
+			entries, _ := fs.ReadDir(s.files, dir)
+			if listed[name] {
+				reply = status(sshFxEOF)
+				break
+			}
+			listed[name] = true
+			reply = sftpPacket{sshFxpName}.uint32(id).uint32(uint32(len(entries) + 2))
+			reply = s.attributes(reply.string(".").string(""), path.Join(dir, "."), false)
+			reply = s.attributes(reply.string("..").string(""), path.Join(dir, ".."), false)
+			for _, entry := range entries {
+				reply = s.attributes(reply.string(entry.Name()).string(""), path.Join(dir, entry.Name()), false)
+			}
+		case sshFxpStat:
+			if _, err := fs.Stat(s.files, s.resolve(name)); err != nil {
+				reply = status(sshFxNoSuch)
+			} else {
+				reply = s.attributes(sftpPacket{sshFxpAttrs}.uint32(id), strings.TrimPrefix(name, "/"), true)
+			}
+		case sshFxpRead:
+			offset := request.uint64()
+			length := uint64(request.uint32())
+			s.mutex.Lock()
+			data, _ := fs.ReadFile(s.files, s.handles[name])
+			s.mutex.Unlock()
+			if offset >= uint64(len(data)) {
+				reply = status(sshFxEOF)
+			} else {
+				reply = sftpPacket{sshFxpData}.uint32(id).string(string(data[offset:min(offset+length, uint64(len(data)))]))
+			}
+		case sshFxpClose:
+			s.mutex.Lock()
+			delete(s.handles, name)
+			s.mutex.Unlock()
+			reply = status(0)
+		}
+		if err := writePacket(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Returns the file a remote path refers to, following a symbolic link
+func (s *fakeSftpServer) resolve(name string) string {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if file, found := s.files[name]; found && (file.Mode&fs.ModeSymlink != 0) {
+		return string(file.Data)
+	}
+	return name
+}
+
+// Appends the attributes of a file, of what it links to if follow is set
+func (s *fakeSftpServer) attributes(reply sftpPacket, name string, follow bool) sftpPacket {
+	if follow {
+		name = s.resolve(name)
+	}
+	if file, found := s.files[name]; found && (file.Mode&fs.ModeSymlink != 0) {
+		return reply.uint32(sftpAttrPerms).uint32(0120777)
+	}
+	info, _ := fs.Stat(s.files, name)
+	mode := uint32(0100644)
+	if info.IsDir() {
+		mode = 040755
+	}
+	return reply.uint32(sftpAttrSize | sftpAttrPerms | sftpAttrTimes).uint64(uint64(info.Size())).uint32(mode).uint32(0).uint32(uint32(info.ModTime().Unix()))
+}
+
+func TestLocalFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "vax"), 0755); err != nil {
+		t.Fatalf(`cannot create test directory: %v`, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vax", "ka630.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+	fsys, err := Open(dir)
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	if info, err := fsys.Stat("vax/ka630.pdf"); (err != nil) || (info.Size() != 4) {
+		t.Errorf(`Stat() = %v, %v`, info, err)
+	}
+	if fsys.LocalPath("vax/ka630.pdf") != filepath.Join(dir, "vax", "ka630.pdf") {
+		t.Errorf(`LocalPath() = %s`, fsys.LocalPath("vax/ka630.pdf"))
+	}
+	if err := CheckAvailable(dir); err != nil {
+		t.Errorf(`CheckAvailable() = %v`, err)
+	}
+}
+
+func TestSftpFS(t *testing.T) {
+	server := fakeSftp(t, fstest.MapFS{
+		"volume1/DEC_0001/ka630.pdf":      {Data: []byte("%PDF"), ModTime: time.Unix(1700000000, 0)},
+		"volume1/DEC_0001/vax/it's.pdf":   {Data: []byte(strings.Repeat("%PDF", 20000))},
+		"volume1/DEC_0001/latest":         {Data: []byte("volume1/DEC_0001/vax"), Mode: fs.ModeSymlink},
+		"volume1/DEC_0001/vax/broken.pdf": {Data: []byte("volume1/DEC_0001/gone.pdf"), Mode: fs.ModeSymlink},
+	})
+
+	fsys, err := Open("sftp://antonio@nas:2222/volume1/DEC_0001")
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if (err != nil) || (len(entries) != 3) || (entries[0].Name() != "ka630.pdf") || entries[0].IsDir() || !entries[1].IsDir() || !entries[2].IsDir() {
+		t.Fatalf(`ReadDir() = %v, %v`, entries, err)
+	}
+	if info, _ := entries[0].Info(); (info.Size() != 4) || !info.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf(`ReadDir() described ka630.pdf as %v`, info)
+	}
+	data, err := fs.ReadFile(fsys, "vax/it's.pdf")
+	if (err != nil) || (string(data) != strings.Repeat("%PDF", 20000)) {
+		t.Errorf(`ReadFile() = %d bytes, %v`, len(data), err)
+	}
+	if _, err := fsys.Stat("vax/broken.pdf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Stat() of a broken link = %v`, err)
+	}
+	if _, err := fsys.Stat("missing.pdf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Stat() of a missing file = %v`, err)
+	}
+	if _, err := fsys.Stat("missing/ka630.pdf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Stat() in a missing directory = %v`, err)
+	}
+	// Everything goes over one connection, and each directory is listed only once
+	expected := [][]string{{"-o", "BatchMode=yes", "-p", "2222", "-s", "antonio@nas", "sftp"}}
+	if !reflect.DeepEqual(server.connections, expected) {
+		t.Errorf(`ssh was run as %q`, server.connections)
+	}
+	listings := 0
+	for _, request := range server.requests {
+		if request == "opendir /volume1/DEC_0001" {
+			listings += 1
+		}
+	}
+	if listings != 1 {
+		t.Errorf(`the root was listed %d times`, listings)
+	}
+	if len(server.handles) != 0 {
+		t.Errorf(`handles left open: %v`, server.handles)
+	}
+	if fsys.Location("vax/ka630.pdf") != "sftp://antonio@nas:2222/volume1/DEC_0001/vax/ka630.pdf" || (fsys.LocalPath("vax/ka630.pdf") != "") {
+		t.Errorf(`Location() = %s`, fsys.Location("vax/ka630.pdf"))
+	}
+}
+
+func TestSmbFS(t *testing.T) {
+	listing := `  .                                   D        0  Mon Jan  5 10:11:12 1998
+  ..                                  D        0  Mon Jan  5 10:11:12 1998
+  index.htm                           A     2345  Mon Jan  5 10:11:12 1998
+  HTML                                D        0  Tue Feb 10 09:00:00 1998
+
+		12345 blocks of size 1024. 1234 blocks available
+`
+	commands := fakeCommands(t, func(name string, args []string) (string, error) {
+		command := args[len(args)-1]
+		switch {
+		case command == `ls "DEC_0001/*"`:
+			return listing, nil
+		case strings.HasPrefix(command, "ls"):
+			return "", errors.New("NT_STATUS_NO_SUCH_FILE listing")
+		case strings.HasPrefix(command, "get"):
+			return "<html>", nil
+		}
+		return "", errors.New("unexpected command")
+	})
+
+	fsys, err := Open("smb://antonio@nas/archive/DEC_0001")
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	if fsys.CaseSensitive() {
+		t.Errorf(`CaseSensitive() = true for an SMB share`)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if (err != nil) || (len(entries) != 2) || (entries[0].Name() != "HTML") || !entries[0].IsDir() {
+		t.Fatalf(`ReadDir() = %v, %v`, entries, err)
+	}
+	info, err := fsys.Stat("INDEX.HTM")
+	if (err != nil) || (info.Size() != 2345) || (info.Name() != "INDEX.HTM") || (info.ModTime().Year() != 1998) {
+		t.Errorf(`Stat() = %v, %v`, info, err)
+	}
+	data, err := fs.ReadFile(fsys, "INDEX.HTM")
+	if (err != nil) || (string(data) != "<html>") {
+		t.Errorf(`ReadFile() = %q, %v`, data, err)
+	}
+	expected := []string{"smbclient", "//nas/archive", "-E", "-U", "antonio", "-c", `get "DEC_0001/INDEX.HTM" -`}
+	if last := (*commands)[len(*commands)-1]; !reflect.DeepEqual(last, expected) {
+		t.Errorf(`ReadFile() ran %q`, last)
+	}
+	if _, err := fsys.Stat("missing.htm"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Stat() of a missing file = %v`, err)
+	}
+	if _, err := Open("smb://nas"); err == nil {
+		t.Errorf(`Open() accepted an SMB root without a share`)
+	}
+}
+
+func TestFailedReadIsReported(t *testing.T) {
+	fakeCommands(t, func(name string, args []string) (string, error) {
+		if strings.HasPrefix(args[len(args)-1], "ls") {
+			return "  ka630.pdf                           A      100  Mon Jan  5 10:11:12 1998\n", nil
+		}
+		return "%PD", errors.New("connection reset")
+	})
+	fsys, err := Open("smb://nas/archive")
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	if _, err := fs.ReadFile(fsys, "ka630.pdf"); err == nil {
+		t.Errorf(`ReadFile() hid the failure of the command`)
+	}
+
+	// An SFTP connection that ends part way through a file
+	server := fakeSftp(t, fstest.MapFS{"volume1/ka630.pdf": {Data: []byte(strings.Repeat("%PDF", 20000))}})
+	server.hangUp = "read"
+	fsys, err = Open("sftp://nas/volume1")
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	if _, err := fs.ReadFile(fsys, "ka630.pdf"); err == nil {
+		t.Errorf(`ReadFile() hid the end of the connection`)
+	}
+	// The next request connects again
+	server.hangUp = ""
+	if data, err := fs.ReadFile(fsys, "ka630.pdf"); (err != nil) || (len(data) != 80000) || (len(server.connections) != 2) {
+		t.Errorf(`ReadFile() after the connection ended = %d bytes, %v`, len(data), err)
+	}
+}
+
+func TestLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ka630.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+	local, _ := Open(dir)
+	if localPath, release, err := LocalFile(local, "ka630.pdf"); (err != nil) || (localPath != filepath.Join(dir, "ka630.pdf")) {
+		t.Errorf(`LocalFile() of a local file = %s, %v`, localPath, err)
+	} else {
+		release()
+	}
+
+	fakeSftp(t, fstest.MapFS{"volume1/ka630.pdf": {Data: []byte("%PDF")}})
+	remote, _ := Open("sftp://nas/volume1")
+	localPath, release, err := LocalFile(remote, "ka630.pdf")
+	if err != nil {
+		t.Fatalf(`LocalFile() of a remote file failed: %v`, err)
+	}
+	if data, err := os.ReadFile(localPath); (err != nil) || (string(data) != "%PDF") || (filepath.Ext(localPath) != ".pdf") {
+		t.Errorf(`LocalFile() copy %s = %q, %v`, localPath, data, err)
+	}
+	release()
+	if _, err := os.Stat(localPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`release() left %s behind`, localPath)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	if joined := Join("sftp://nas/volume1/", "DEC_0001"); joined != "sftp://nas/volume1/DEC_0001" {
+		t.Errorf(`Join() of a remote root = %s`, joined)
+	}
+	if joined := Join("/mnt/archive", "DEC_0001/vax"); joined != filepath.Join("/mnt/archive", "DEC_0001", "vax") {
+		t.Errorf(`Join() of a local root = %s`, joined)
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	for root, expected := range map[string]bool{"sftp://nas/x": true, "smb://nas/share": true, "s3://bucket": true, "/mnt/nas": false, "ftp://host/x": false, "C:\\archive": false} {
+		if IsRemote(root) != expected {
+			t.Errorf(`IsRemote(%s) = %v`, root, !expected)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package archivefs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sftpBackend reads a directory on a machine reachable over ssh. It speaks SFTP (version 3, which every server
+// supports) to the remote machine's sftp subsystem, over a single ssh connection that is opened when first needed and
+// shared by every operation. Nothing is run on the remote machine beyond the subsystem itself, so servers that only
+// allow SFTP (e.g. internal-sftp in a chroot) can be read.
+type sftpBackend struct {
+	target  string // [user@]host
+	port    string // "" for the default
+	dir     string // The remote directory that is the root
+	mutex   sync.Mutex
+	conn    io.ReadWriteCloser // nil until first used, or after the connection failed
+	request uint32             // The id of the last request sent
+}
+
+func newSftpFS(u *url.URL) (FS, error) {
+	b := &sftpBackend{target: u.Hostname(), port: u.Port(), dir: u.Path}
+	if u.User != nil {
+		b.target = u.User.Username() + "@" + b.target
+	}
+	if b.dir == "" {
+		b.dir = "."
+	}
+	return &remoteFS{backend: b, root: u.String(), caseSensitive: true}, nil
+}
+
+// SFTP packet types (draft-ietf-secsh-filexfer-02)
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+	sshFxpOpen    = 3
+	sshFxpClose   = 4
+	sshFxpRead    = 5
+	sshFxpOpendir = 11
+	sshFxpReaddir = 12
+	sshFxpStat    = 17
+	sshFxpStatus  = 101
+	sshFxpHandle  = 102
+	sshFxpData    = 103
+	sshFxpName    = 104
+	sshFxpAttrs   = 105
+)
+
+// Status codes, flags and file attributes
+const (
+	sshFxEOF       = 1
+	sshFxNoSuch    = 2
+	sshFxNoPermit  = 3
+	sshFxfRead     = 0x1 // The open flag asking for read access
+	sftpAttrSize   = 0x1
+	sftpAttrUidGid = 0x2
+	sftpAttrPerms  = 0x4
+	sftpAttrTimes  = 0x8
+	sftpAttrExt    = 0x80000000
+	sftpModeType   = 0170000
+	sftpModeDir    = 0040000
+	sftpModeLink   = 0120000
+)
+
+const (
+	sftpReadSize  = 32768   // The most data asked for at once, which every server allows
+	sftpMaxPacket = 1 << 20 // Larger than any reply to the requests made here
+)
+
+// Starts ssh running the sftp subsystem on the remote machine and returns a connection to it (the subsystem's standard
+// input and output). Closing the connection ends ssh. Tests replace this to avoid running ssh.
+var startSftp = func(args ...string) (io.ReadWriteCloser, error) {
+	cmd := exec.Command("ssh", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sshConn{WriteCloser: stdin, stdout: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+// sshConn is the standard input and output of a running ssh
+type sshConn struct {
+	io.WriteCloser
+	stdout io.Reader
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	ended  error // Why ssh ended, once it has
+	waited bool
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	// ssh closing its output means the connection is over: say why, rather than just reporting a short reply
+	if err == io.EOF {
+		c.wait()
+		if c.ended == nil {
+			c.ended = io.ErrUnexpectedEOF
+		}
+		return n, c.ended
+	}
+	return n, err
+}
+
+func (c *sshConn) Close() error {
+	c.WriteCloser.Close()
+	c.wait()
+	return nil
+}
+
+func (c *sshConn) wait() {
+	if c.waited {
+		return
+	}
+	c.waited = true
+	if err := c.cmd.Wait(); err != nil {
+		if message := strings.TrimSpace(c.stderr.String()); message != "" {
+			c.ended = fmt.Errorf("ssh: %w: %s", err, message)
+		} else {
+			c.ended = fmt.Errorf("ssh: %w", err)
+		}
+	}
+}
+
+// Returns the arguments to run the sftp subsystem with ssh
+func (b *sftpBackend) sshArgs() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if b.port != "" {
+		args = append(args, "-p", b.port)
+	}
+	return append(args, "-s", b.target, "sftp")
+}
+
+// Connects to the remote machine, if not already connected. The caller holds the mutex.
+func (b *sftpBackend) connect() error {
+	if b.conn != nil {
+		return nil
+	}
+	conn, err := startSftp(b.sshArgs()...)
+	if err != nil {
+		return err
+	}
+	init := sftpPacket{sshFxpInit}.uint32(3)
+	if err := writePacket(conn, init); err != nil {
+		conn.Close()
+		return err
+	}
+	reply, err := readPacket(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if reply[0] != sshFxpVersion {
+		conn.Close()
+		return fmt.Errorf("sftp: unexpected reply %d to the version request", reply[0])
+	}
+	b.conn = conn
+	return nil
+}
+
+// Sends a request and returns the type and contents of its reply. A request that cannot be sent, or whose reply
+// cannot be read, drops the connection so that the next request makes a new one.
+func (b *sftpBackend) call(packetType byte, payload sftpPacket) (byte, *sftpReply, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.connect(); err != nil {
+		return 0, nil, err
+	}
+	b.request += 1
+	packet := append(sftpPacket{packetType}.uint32(b.request), payload...)
+	reply, err := b.exchange(packet)
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return 0, nil, err
+	}
+	return reply[0], &sftpReply{data: reply[5:]}, nil
+}
+
+func (b *sftpBackend) exchange(packet sftpPacket) ([]byte, error) {
+	if err := writePacket(b.conn, packet); err != nil {
+		return nil, err
+	}
+	reply, err := readPacket(b.conn)
+	if err != nil {
+		return nil, err
+	}
+	if (len(reply) < 5) || (binary.BigEndian.Uint32(reply[1:5]) != b.request) {
+		return nil, errors.New("sftp: reply does not match the request")
+	}
+	return reply, nil
+}
+
+// Returns the error described by a status reply, or nil if it reports success
+func sftpStatus(reply *sftpReply) error {
+	code := reply.uint32()
+	message := reply.string()
+	switch {
+	case reply.err != nil:
+		return reply.err
+	case code == 0:
+		return nil
+	case code == sshFxEOF:
+		return io.EOF
+	case code == sshFxNoSuch:
+		return fs.ErrNotExist
+	case code == sshFxNoPermit:
+		return fs.ErrPermission
+	case message != "":
+		return fmt.Errorf("sftp: %s", message)
+	}
+	return fmt.Errorf("sftp: request failed with status %d", code)
+}
+
+// Returns the error for a reply that is neither the expected one nor a status
+func sftpUnexpected(packetType byte, reply *sftpReply) error {
+	if packetType == sshFxpStatus {
+		if err := sftpStatus(reply); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("sftp: unexpected reply %d", packetType)
+}
+
+// Opens a file or directory and returns its handle
+func (b *sftpBackend) openHandle(packetType byte, payload sftpPacket) (string, error) {
+	replyType, reply, err := b.call(packetType, payload)
+	if err != nil {
+		return "", err
+	}
+	if replyType != sshFxpHandle {
+		return "", sftpUnexpected(replyType, reply)
+	}
+	handle := reply.string()
+	return handle, reply.err
+}
+
+func (b *sftpBackend) closeHandle(handle string) error {
+	replyType, reply, err := b.call(sshFxpClose, sftpPacket{}.string(handle))
+	if err != nil {
+		return err
+	}
+	if replyType != sshFxpStatus {
+		return sftpUnexpected(replyType, reply)
+	}
+	return sftpStatus(reply)
+}
+
+func (b *sftpBackend) open(name string) (io.ReadCloser, error) {
+	payload := sftpPacket{}.string(remotePath(b.dir, name)).uint32(sshFxfRead).uint32(0)
+	handle, err := b.openHandle(sshFxpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{backend: b, handle: handle}, nil
+}
+
+func (b *sftpBackend) readDir(name string) ([]fs.DirEntry, error) {
+	dir := remotePath(b.dir, name)
+	handle, err := b.openHandle(sshFxpOpendir, sftpPacket{}.string(dir))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := b.readDirHandle(dir, handle)
+	if closeErr := b.closeHandle(handle); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (b *sftpBackend) readDirHandle(dir string, handle string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	var links []string
+	for {
+		replyType, reply, err := b.call(sshFxpReaddir, sftpPacket{}.string(handle))
+		if err != nil {
+			return nil, err
+		}
+		if replyType != sshFxpName {
+			if err := sftpUnexpected(replyType, reply); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		for count := reply.uint32(); (count > 0) && (reply.err == nil); count-- {
+			filename := reply.string()
+			reply.string() // The "ls -l" line, which is only for display
+			attributes := reply.attributes()
+			switch {
+			case (filename == ".") || (filename == ".."):
+			case attributes.mode&sftpModeType == sftpModeLink:
+				links = append(links, filename)
+			default:
+				entries = append(entries, attributes.info(filename))
+			}
+		}
+		if reply.err != nil {
+			return nil, reply.err
+		}
+	}
+	// A symbolic link is described by what it points to; one that points nowhere is left out, as it cannot be read
+	for _, link := range links {
+		replyType, reply, err := b.call(sshFxpStat, sftpPacket{}.string(path.Join(dir, link)))
+		if err != nil {
+			return nil, err
+		}
+		if replyType == sshFxpAttrs {
+			entries = append(entries, reply.attributes().info(link))
+		} else if err := sftpUnexpected(replyType, reply); !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// sftpFile is a remote file opened for reading, read in order
+type sftpFile struct {
+	backend *sftpBackend
+	handle  string
+	offset  uint64
+	pending []byte // Data received but not yet read
+	closed  bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(f.pending) == 0 {
+		payload := sftpPacket{}.string(f.handle).uint64(f.offset).uint32(sftpReadSize)
+		replyType, reply, err := f.backend.call(sshFxpRead, payload)
+		if err != nil {
+			return 0, err
+		}
+		if replyType != sshFxpData {
+			return 0, sftpUnexpected(replyType, reply)
+		}
+		f.pending = []byte(reply.string())
+		if reply.err != nil {
+			return 0, reply.err
+		}
+		f.offset += uint64(len(f.pending))
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+func (f *sftpFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.backend.closeHandle(f.handle)
+}
+
+// sftpPacket is a request being built
+type sftpPacket []byte
+
+func (p sftpPacket) uint32(v uint32) sftpPacket { return binary.BigEndian.AppendUint32(p, v) }
+func (p sftpPacket) uint64(v uint64) sftpPacket { return binary.BigEndian.AppendUint64(p, v) }
+func (p sftpPacket) string(s string) sftpPacket { return append(p.uint32(uint32(len(s))), s...) }
+
+func writePacket(w io.Writer, packet sftpPacket) error {
+	_, err := w.Write(append(sftpPacket{}.uint32(uint32(len(packet))), packet...))
+	return err
+}
+
+// Reads a packet: its type followed by its contents
+func readPacket(r io.Reader) ([]byte, error) {
+	// The connection ending, even between packets, leaves a request unanswered
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if (size == 0) || (size > sftpMaxPacket) {
+		return nil, fmt.Errorf("sftp: bad packet length %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+// sftpReply is the contents of a reply, read field by field. Reading past the end sets err.
+type sftpReply struct {
+	data []byte
+	err  error
+}
+
+func (r *sftpReply) take(n uint64) []byte {
+	if (r.err != nil) || (uint64(len(r.data)) < n) {
+		r.err = errors.New("sftp: reply is too short")
+		return nil
+	}
+	taken := r.data[:n]
+	r.data = r.data[n:]
+	return taken
+}
+
+func (r *sftpReply) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *sftpReply) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *sftpReply) string() string {
+	return string(r.take(uint64(r.uint32())))
+}
+
+// sftpAttributes are the parts of a file's attributes that an FS reports
+type sftpAttributes struct {
+	size    uint64
+	mode    uint32
+	modTime uint32
+}
+
+func (r *sftpReply) attributes() sftpAttributes {
+	var a sftpAttributes
+	flags := r.uint32()
+	if flags&sftpAttrSize != 0 {
+		a.size = r.uint64()
+	}
+	if flags&sftpAttrUidGid != 0 {
+		r.take(8)
+	}
+	if flags&sftpAttrPerms != 0 {
+		a.mode = r.uint32()
+	}
+	if flags&sftpAttrTimes != 0 {
+		r.take(4) // The access time
+		a.modTime = r.uint32()
+	}
+	if flags&sftpAttrExt != 0 {
+		for count := r.uint32(); (count > 0) && (r.err == nil); count-- {
+			r.string()
+			r.string()
+		}
+	}
+	return a
+}
+
+func (a sftpAttributes) info(name string) fileInfo {
+	return fileInfo{
+		name:    name,
+		size:    int64(a.size),
+		modTime: time.Unix(int64(a.modTime), 0),
+		dir:     a.mode&sftpModeType == sftpModeDir,
+	}
+}
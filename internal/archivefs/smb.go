@@ -0,0 +1,134 @@
+package archivefs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smbBackend reads a directory in a Windows (SMB/CIFS) share with smbclient, which runs one command per operation:
+// get to read a file and ls to list a directory.
+type smbBackend struct {
+	service string // //host/share
+	user    string // "" to connect as a guest
+	dir     string // The directory within the share that is the root ("" for the top of the share)
+}
+
+func newSmbFS(u *url.URL) (FS, error) {
+	share, dir, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if share == "" {
+		return nil, fmt.Errorf("no share in %s", u)
+	}
+	b := &smbBackend{service: "//" + u.Host + "/" + share, dir: strings.Trim(dir, "/")}
+	if u.User != nil {
+		b.user = u.User.Username()
+	}
+	// SMB shares match names without regard to case, as Windows does
+	return &remoteFS{backend: b, root: u.String(), caseSensitive: false}, nil
+}
+
+// Returns the arguments to run an smbclient command
+func (b *smbBackend) smbclientArgs(command string) []string {
+	args := []string{b.service, "-E"}
+	if b.user != "" {
+		args = append(args, "-U", b.user)
+	} else {
+		args = append(args, "-N")
+	}
+	return append(args, "-c", command)
+}
+
+// Returns the path within the share of a name within the root
+func (b *smbBackend) sharePath(name string) string {
+	if b.dir == "" {
+		return name
+	}
+	return remotePath(b.dir, name)
+}
+
+// Quotes the path of a name for an smbclient command. smbclient has no way to escape a double quote.
+func (b *smbBackend) quote(name string) (string, error) {
+	p := b.sharePath(name)
+	if strings.Contains(p, `"`) {
+		return "", fmt.Errorf("cannot name %q in an smbclient command", p)
+	}
+	return `"` + p + `"`, nil
+}
+
+// Converts an smbclient failure into fs.ErrNotExist where appropriate
+func smbError(err error) error {
+	if strings.Contains(err.Error(), "NT_STATUS_OBJECT_NAME_NOT_FOUND") || strings.Contains(err.Error(), "NT_STATUS_NO_SUCH_FILE") ||
+		strings.Contains(err.Error(), "NT_STATUS_OBJECT_PATH_NOT_FOUND") {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+func (b *smbBackend) open(name string) (io.ReadCloser, error) {
+	quoted, err := b.quote(name)
+	if err != nil {
+		return nil, err
+	}
+	return startCommand("smbclient", b.smbclientArgs("get "+quoted+" -")...)
+}
+
+func (b *smbBackend) readDir(name string) ([]fs.DirEntry, error) {
+	pattern := name + "/*"
+	if name == "." {
+		pattern = "*"
+	}
+	entries, err := b.list(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var result []fs.DirEntry
+	for _, entry := range entries {
+		if (entry.Name() != ".") && (entry.Name() != "..") {
+			result = append(result, entry)
+		}
+	}
+	sortEntries(result)
+	return result, nil
+}
+
+// Runs smbclient's ls on a name or pattern
+func (b *smbBackend) list(pattern string) ([]fs.DirEntry, error) {
+	quoted, err := b.quote(pattern)
+	if err != nil {
+		return nil, err
+	}
+	output, err := runCommand("smbclient", b.smbclientArgs("ls "+quoted)...)
+	if err != nil {
+		return nil, smbError(err)
+	}
+	return parseSmbLsOutput(output)
+}
+
+// Matches one entry in the output of smbclient's ls, e.g.
+//
+//	ka630.pdf                           A   123456  Mon Jan  5 10:11:12 1998
+var smbLsEntry = regexp.MustCompile(`^  (.+?)\s+([A-Z]*)\s+(\d+)\s+(\w{3} \w{3} [ \d]\d \d\d:\d\d:\d\d \d{4})$`)
+
+// Parses the output of smbclient's ls; lines that do not describe an entry (e.g. the free space summary) are ignored
+func parseSmbLsOutput(output []byte) ([]fs.DirEntry, error) {
+	if strings.Contains(string(output), "NT_STATUS_") {
+		return nil, smbError(fmt.Errorf("smbclient: %s", strings.TrimSpace(string(output))))
+	}
+	var entries []fs.DirEntry
+	for _, line := range strings.Split(string(output), "\n") {
+		match := smbLsEntry.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		info := fileInfo{name: match[1], dir: strings.Contains(match[2], "D")}
+		info.size, _ = strconv.ParseInt(match[3], 10, 64)
+		info.modTime, _ = time.ParseInLocation("Mon Jan _2 15:04:05 2006", match[4], time.Local)
+		entries = append(entries, info)
+	}
+	return entries, nil
+}
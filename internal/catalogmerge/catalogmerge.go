@@ -0,0 +1,257 @@
+package catalogmerge
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/retention"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// This package merges two copies of a catalog (a map of key => Document) that have diverged, for example
+// because each was updated on a different machine.
+//
+// When the common ancestor of the two copies is available the merge is a three-way merge, performed field by field:
+// a field changed on only one side takes the changed value; a field changed differently on both sides is a conflict.
+// Documents added on one side are kept, documents deleted on one side (and unchanged on the other) are dropped,
+// and a document deleted on one side but modified on the other is a conflict.
+//
+// Without an ancestor, nothing is known about deletions, so every document found in either copy is kept.
+// A field that is blank on one side takes the value from the other; a field set differently on both sides is a conflict.
+//
+// Tags are treated as a set rather than a single value: tags added on either side are kept and tags removed on either
+// side are dropped, so they never conflict. Likewise user notes written on both sides are combined.
+//
+// Whenever there is a conflict, the merged catalog holds "our" value so that it remains usable;
+// the conflicts are returned for manual resolution.
+//
+// A document added on both sides (i.e. not in the ancestor, or with no ancestor at all) is the same file arriving from
+// two sources. MergeWithPolicy lets a duplicate policy (see internal/retention) choose which copy is kept: its fields
+// win, blank fields are filled in from the other copy, no conflicts are reported and the decision is recorded in the
+// document's Provenance.
+//
+// A read-only document (see document.MarkReadOnly) is never merged field by field: whichever copy is kept is kept
+// whole, a read-only copy is preferred to one that is not, and no conflicts are reported for it.
+
+type Document = document.Document
+
+// Conflict describes a change that could not be merged automatically
+type Conflict struct {
+	Key    string // Key of the document in the catalogs
+	Field  string // The Document field in conflict; blank if the document was deleted on one side and modified on the other
+	Base   string `yaml:",omitempty"` // The value in the common ancestor, if any
+	Ours   string // Our value (or "<deleted>")
+	Theirs string // Their value (or "<deleted>")
+}
+
+// The value reported in a Conflict for a document that has been deleted
+const Deleted = "<deleted>"
+
+// The value reported in a Conflict for a document that has been modified
+const Modified = "<modified>"
+
+// Result is the outcome of a merge
+type Result struct {
+	Documents map[string]Document // The merged catalog
+	Conflicts []Conflict          // Changes that need manual resolution, in key order
+}
+
+// Merges two catalogs. base is the common ancestor; if it is nil, a two-way merge is performed.
+func Merge(base map[string]Document, ours map[string]Document, theirs map[string]Document) Result {
+	return MergeWithPolicy(base, ours, theirs, nil)
+}
+
+// Merges two catalogs as Merge does, except that the policy chooses which copy of a document added on both sides is
+// kept. An empty policy merges such documents field by field, reporting conflicts, as Merge does.
+func MergeWithPolicy(base map[string]Document, ours map[string]Document, theirs map[string]Document, policy retention.Policy) Result {
+	result := Result{Documents: make(map[string]Document)}
+
+	keySet := make(map[string]bool)
+	for _, catalog := range []map[string]Document{base, ours, theirs} {
+		for key := range catalog {
+			keySet[key] = true
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		baseDoc, inBase := base[key]
+		ourDoc, inOurs := ours[key]
+		theirDoc, inTheirs := theirs[key]
+
+		switch {
+		case inOurs && inTheirs && !inBase && (len(policy) > 0):
+			result.Documents[key] = resolveDocument(ourDoc, theirDoc, policy)
+		case inOurs && inTheirs:
+			var ancestor *Document
+			if inBase {
+				ancestor = &baseDoc
+			}
+			if document.IsReadOnly(ourDoc) || document.IsReadOnly(theirDoc) {
+				result.Documents[key] = chooseReadOnly(ancestor, ourDoc, theirDoc)
+				continue
+			}
+			merged, conflicts := mergeDocument(key, ancestor, ourDoc, theirDoc)
+			result.Documents[key] = merged
+			result.Conflicts = append(result.Conflicts, conflicts...)
+		case inOurs:
+			// Either added by us, or deleted by them
+			if !inBase {
+				result.Documents[key] = ourDoc
+			} else if !reflect.DeepEqual(ourDoc, baseDoc) {
+				result.Documents[key] = ourDoc
+				result.Conflicts = append(result.Conflicts, Conflict{Key: key, Ours: Modified, Theirs: Deleted})
+			}
+		case inTheirs:
+			// Either added by them, or deleted by us
+			if !inBase {
+				result.Documents[key] = theirDoc
+			} else if !reflect.DeepEqual(theirDoc, baseDoc) {
+				result.Conflicts = append(result.Conflicts, Conflict{Key: key, Ours: Deleted, Theirs: Modified})
+			}
+		default:
+			// Deleted on both sides
+		}
+	}
+
+	return result
+}
+
+// Merges one document field by field. ancestor is nil if there is no common ancestor.
+func mergeDocument(key string, ancestor *Document, ours Document, theirs Document) (Document, []Conflict) {
+	var conflicts []Conflict
+	merged := ours
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	ourValue := reflect.ValueOf(ours)
+	theirValue := reflect.ValueOf(theirs)
+
+	for i := 0; i < ourValue.NumField(); i++ {
+		ourField := ourValue.Field(i)
+		theirField := theirValue.Field(i)
+		if reflect.DeepEqual(ourField.Interface(), theirField.Interface()) {
+			continue
+		}
+		// Tags are a set, so changes on each side can always be combined
+		if ourValue.Type().Field(i).Name == "Tags" {
+			var baseTags []string
+			if ancestor != nil {
+				baseTags = ancestor.Tags
+			}
+			merged.Tags = mergeTags(baseTags, ours.Tags, theirs.Tags)
+			continue
+		}
+		// Provenance only ever records decisions, so the decisions made on each side are combined
+		if ourValue.Type().Field(i).Name == "Provenance" {
+			for _, entry := range theirs.Provenance {
+				retention.AddProvenance(&merged, entry)
+			}
+			continue
+		}
+		// Copies is a set of places, so the locations known on each side are combined
+		if ourValue.Type().Field(i).Name == "Copies" {
+			merged.Copies = slices.Clone(ours.Copies)
+			document.AddLocations(&merged, theirs.Copies...)
+			continue
+		}
+		// So are the redirects known on each side
+		if ourValue.Type().Field(i).Name == "Redirects" {
+			merged.Redirects = maps.Clone(ours.Redirects)
+			document.AddRedirects(&merged, theirs.Redirects)
+			continue
+		}
+		// Notes are only ever added to, so notes written on each side are combined rather than lost
+		if ourValue.Type().Field(i).Name == "Notes" {
+			var baseNotes string
+			if ancestor != nil {
+				baseNotes = ancestor.Notes
+			}
+			merged.Notes = mergeNotes(baseNotes, ours.Notes, theirs.Notes)
+			continue
+		}
+		conflict := Conflict{Key: key, Field: ourValue.Type().Field(i).Name, Ours: fmt.Sprint(ourField.Interface()), Theirs: fmt.Sprint(theirField.Interface())}
+		if ancestor != nil {
+			baseField := reflect.ValueOf(*ancestor).Field(i)
+			if reflect.DeepEqual(ourField.Interface(), baseField.Interface()) {
+				mergedValue.Field(i).Set(theirField)
+			} else if !reflect.DeepEqual(theirField.Interface(), baseField.Interface()) {
+				conflict.Base = fmt.Sprint(baseField.Interface())
+				conflicts = append(conflicts, conflict)
+			}
+		} else if ourField.IsZero() {
+			mergedValue.Field(i).Set(theirField)
+		} else if !theirField.IsZero() {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return merged, conflicts
+}
+
+// Merges two copies of a document added on both sides: the copy chosen by the policy is kept, and any of its fields that
+// are blank are filled in from the other copy.
+func resolveDocument(ours Document, theirs Document, policy retention.Policy) Document {
+	if reflect.DeepEqual(ours, theirs) {
+		return ours
+	}
+	kept, keptTheirs := policy.Resolve(ours, theirs)
+	if document.IsReadOnly(kept) {
+		return kept
+	}
+	other := theirs
+	if keptTheirs {
+		other = ours
+	}
+	// Conflicts are settled in favour of the kept copy, so they need not be reported. The kept copy is not read-only,
+	// and filling it in from a read-only copy does not make it so.
+	merged, _ := mergeDocument("", nil, kept, other)
+	merged.Origin = kept.Origin
+	return merged
+}
+
+// Chooses which of two copies of a document, at least one of them read-only, to keep whole: the read-only copy, or if
+// both are read-only, theirs if only theirs changed from the ancestor (i.e. their reference catalog is newer), otherwise ours.
+func chooseReadOnly(ancestor *Document, ours Document, theirs Document) Document {
+	if !document.IsReadOnly(theirs) {
+		return ours
+	}
+	if !document.IsReadOnly(ours) || ((ancestor != nil) && reflect.DeepEqual(ours, *ancestor)) {
+		return theirs
+	}
+	return ours
+}
+
+// Merges two sets of tags: a tag is kept if either side has it, unless one side removed it from the ancestor.
+func mergeTags(base []string, ours []string, theirs []string) []string {
+	removed := func(tag string, side []string) bool {
+		return slices.Contains(base, tag) && !slices.Contains(side, tag)
+	}
+	var merged []string
+	for _, tag := range append(append([]string(nil), ours...), theirs...) {
+		if !slices.Contains(merged, tag) && !removed(tag, ours) && !removed(tag, theirs) {
+			merged = append(merged, tag)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// Merges user notes. A side that left the notes unchanged takes the other side's notes; otherwise
+// any lines that only their side has are appended to ours.
+func mergeNotes(base string, ours string, theirs string) string {
+	if ours == base {
+		return theirs
+	}
+	merged := document.Document{Notes: ours}
+	if theirs != base {
+		for _, line := range strings.Split(theirs, "\n") {
+			document.AppendNotes(&merged, line)
+		}
+	}
+	return merged.Notes
+}
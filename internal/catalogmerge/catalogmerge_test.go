@@ -0,0 +1,185 @@
+package catalogmerge
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/retention"
+	"reflect"
+	"testing"
+)
+
+func TestMergeThreeWay(t *testing.T) {
+	base := map[string]Document{
+		"a": {Title: "Alpha", PartNum: "EK-A", Md5: "a"},
+		"b": {Title: "Bravo", PartNum: "EK-B", Md5: "b"},
+		"c": {Title: "Charlie", Md5: "c"},
+		"d": {Title: "Delta", Md5: "d"},
+		"e": {Title: "Echo", Md5: "e"},
+	}
+	ours := map[string]Document{
+		"a": {Title: "Alpha Manual", PartNum: "EK-A", Md5: "a"}, // title changed
+		"b": {Title: "Bravo", PartNum: "EK-B-001", Md5: "b"},    // part number changed
+		"c": {Title: "Charlie", Md5: "c"},                       // unchanged (deleted by them)
+		"d": {Title: "Delta Guide", Md5: "d"},                   // modified (deleted by them)
+		"f": {Title: "Foxtrot", Md5: "f"},                       // added
+		// "e" deleted
+	}
+	theirs := map[string]Document{
+		"a": {Title: "Alpha", PartNum: "EK-A", PubDate: "1987", Md5: "a"}, // date added
+		"b": {Title: "Bravo", PartNum: "EK-B-002", Md5: "b"},              // part number changed differently
+		"e": {Title: "Echo", Md5: "e"},                                    // unchanged (deleted by us)
+		"g": {Title: "Golf", Md5: "g"},                                    // added
+	}
+
+	result := Merge(base, ours, theirs)
+
+	expectedDocuments := map[string]Document{
+		"a": {Title: "Alpha Manual", PartNum: "EK-A", PubDate: "1987", Md5: "a"},
+		"b": {Title: "Bravo", PartNum: "EK-B-001", Md5: "b"},
+		"d": {Title: "Delta Guide", Md5: "d"},
+		"f": {Title: "Foxtrot", Md5: "f"},
+		"g": {Title: "Golf", Md5: "g"},
+	}
+	if !reflect.DeepEqual(result.Documents, expectedDocuments) {
+		t.Errorf("merged documents mismatch:\n%v\n%v", result.Documents, expectedDocuments)
+	}
+
+	expectedConflicts := []Conflict{
+		{Key: "b", Field: "PartNum", Base: "EK-B", Ours: "EK-B-001", Theirs: "EK-B-002"},
+		{Key: "d", Ours: Modified, Theirs: Deleted},
+	}
+	if !reflect.DeepEqual(result.Conflicts, expectedConflicts) {
+		t.Errorf("conflicts mismatch:\n%v\n%v", result.Conflicts, expectedConflicts)
+	}
+}
+
+func TestMergeTwoWay(t *testing.T) {
+	ours := map[string]Document{
+		"a": {Title: "Alpha", Size: 10},
+		"b": {Title: "Bravo", PartNum: "EK-B-001"},
+	}
+	theirs := map[string]Document{
+		"a": {Title: "Alpha", PartNum: "EK-A", Size: 10},
+		"b": {Title: "Bravo", PartNum: "EK-B-002"},
+		"c": {Title: "Charlie"},
+	}
+
+	result := Merge(nil, ours, theirs)
+
+	expectedDocuments := map[string]Document{
+		"a": {Title: "Alpha", PartNum: "EK-A", Size: 10},
+		"b": {Title: "Bravo", PartNum: "EK-B-001"},
+		"c": {Title: "Charlie"},
+	}
+	if !reflect.DeepEqual(result.Documents, expectedDocuments) {
+		t.Errorf("merged documents mismatch:\n%v\n%v", result.Documents, expectedDocuments)
+	}
+
+	expectedConflicts := []Conflict{{Key: "b", Field: "PartNum", Ours: "EK-B-001", Theirs: "EK-B-002"}}
+	if !reflect.DeepEqual(result.Conflicts, expectedConflicts) {
+		t.Errorf("conflicts mismatch:\n%v\n%v", result.Conflicts, expectedConflicts)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	base := map[string]Document{"a": {Title: "Alpha", Tags: []string{"old", "rare"}}}
+	ours := map[string]Document{"a": {Title: "Alpha", Tags: []string{"needs-rescan", "old", "rare"}}} // added needs-rescan
+	theirs := map[string]Document{"a": {Title: "Alpha", Tags: []string{"loaned-out", "rare"}}}        // removed old, added loaned-out
+
+	result := Merge(base, ours, theirs)
+	if len(result.Conflicts) != 0 {
+		t.Errorf(`Merge() conflicts: %+v`, result.Conflicts)
+	}
+	if tags := result.Documents["a"].Tags; !reflect.DeepEqual(tags, []string{"loaned-out", "needs-rescan", "rare"}) {
+		t.Errorf(`Merge() tags = %q`, tags)
+	}
+
+	// Without an ancestor, tags from both sides are kept
+	result = Merge(nil, ours, theirs)
+	if tags := result.Documents["a"].Tags; !reflect.DeepEqual(tags, []string{"loaned-out", "needs-rescan", "old", "rare"}) {
+		t.Errorf(`Merge() two-way tags = %q`, tags)
+	}
+}
+
+func TestMergeNotes(t *testing.T) {
+	base := map[string]Document{"a": {Title: "Alpha", Notes: "from DEC_0001"}, "b": {Title: "Bravo"}}
+	ours := map[string]Document{"a": {Title: "Alpha", Notes: "from DEC_0001\npage 37 missing"}, "b": {Title: "Bravo"}}
+	theirs := map[string]Document{"a": {Title: "Alpha", Notes: "from DEC_0001\nrescanned 2024"}, "b": {Title: "Bravo", Notes: "loaned out"}}
+
+	result := Merge(base, ours, theirs)
+	if len(result.Conflicts) != 0 {
+		t.Errorf(`Merge() conflicts: %+v`, result.Conflicts)
+	}
+	if notes := result.Documents["a"].Notes; notes != "from DEC_0001\npage 37 missing\nrescanned 2024" {
+		t.Errorf(`Merge() notes = %q`, notes)
+	}
+	if notes := result.Documents["b"].Notes; notes != "loaned out" {
+		t.Errorf(`Merge() notes = %q`, notes)
+	}
+}
+
+func TestMergeWithPolicy(t *testing.T) {
+	ours := map[string]Document{
+		"a": {Title: "Alpha", Md5: "a", Filepath: "http://bitsavers.org/pdf/alpha.pdf", Size: 10},
+		"b": {Title: "Bravo", PartNum: "EK-B-001", Md5: "b", Filepath: "file:///DEC_0001/bravo.pdf"},
+	}
+	theirs := map[string]Document{
+		"a": {Title: "Alpha Manual", PubDate: "1987", Md5: "a", Filepath: "file:///DEC_0002/alpha.pdf"},
+		"b": {Title: "Bravo Guide", PartNum: "EK-B-002", Md5: "b", Filepath: "http://bitsavers.org/pdf/bravo.pdf"},
+	}
+
+	result := MergeWithPolicy(nil, ours, theirs, retention.Policy{retention.Local})
+
+	expectedDocuments := map[string]Document{
+		"a": {Title: "Alpha Manual", PubDate: "1987", Md5: "a", Filepath: "file:///DEC_0002/alpha.pdf", Size: 10,
+			Provenance: []string{"kept over http://bitsavers.org/pdf/alpha.pdf: local copy preferred"},
+			Copies: []document.FileLocation{
+				{Type: document.LocalLocation, Volume: "DEC_0002", Path: "alpha.pdf"},
+				{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/alpha.pdf", Size: 10},
+			}},
+		"b": {Title: "Bravo", PartNum: "EK-B-001", Md5: "b", Filepath: "file:///DEC_0001/bravo.pdf",
+			Provenance: []string{"kept over http://bitsavers.org/pdf/bravo.pdf: local copy preferred"},
+			Copies: []document.FileLocation{
+				{Type: document.LocalLocation, Volume: "DEC_0001", Path: "bravo.pdf"},
+				{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/bravo.pdf"},
+			}},
+	}
+	if !reflect.DeepEqual(result.Documents, expectedDocuments) {
+		t.Errorf("merged documents mismatch:\n%v\n%v", result.Documents, expectedDocuments)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", result.Conflicts)
+	}
+}
+
+func TestMergeReadOnly(t *testing.T) {
+	reference := Document{Title: "Alpha", Md5: "a", Filepath: "http://bitsavers.org/pdf/alpha.pdf", Origin: "theirs.yaml"}
+	edited := Document{Title: "Alpha Manual", PubDate: "1987", Md5: "a", Filepath: "http://bitsavers.org/pdf/alpha.pdf"}
+	newer := reference
+	newer.PubDate = "1988"
+
+	for _, test := range []struct {
+		name     string
+		base     map[string]Document
+		ours     Document
+		theirs   Document
+		policy   retention.Policy
+		expected Document
+	}{
+		{"two-way, read-only theirs", nil, edited, reference, nil, reference},
+		{"two-way, read-only ours", nil, reference, edited, nil, reference},
+		{"policy keeps the read-only copy whole", nil, reference, edited, retention.Policy{retention.First}, reference},
+		{"reference updated on their side", map[string]Document{"a": reference}, reference, newer, nil, newer},
+		{"reference updated on our side", map[string]Document{"a": reference}, newer, reference, nil, newer},
+	} {
+		result := MergeWithPolicy(test.base, map[string]Document{"a": test.ours}, map[string]Document{"a": test.theirs}, test.policy)
+		if !reflect.DeepEqual(result.Documents["a"], test.expected) || (len(result.Conflicts) != 0) {
+			t.Errorf("%s: merged %v with conflicts %v", test.name, result.Documents["a"], result.Conflicts)
+		}
+	}
+
+	// A copy that is not read-only may be kept by the policy, but does not become read-only
+	result := MergeWithPolicy(nil, map[string]Document{"a": edited}, map[string]Document{"a": reference}, retention.Policy{retention.Richer})
+	if merged := result.Documents["a"]; (merged.Title != "Alpha Manual") || (merged.Origin != "") {
+		t.Errorf("policy: merged %v", merged)
+	}
+}
@@ -0,0 +1,77 @@
+package checkpoint
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/fsutil"
+	"os"
+	"time"
+)
+
+// This package supports periodic checkpointing during long runs, so that a power cut near the end
+// of a multi-hour scan does not lose all the work done so far.
+//
+// A Timer decides when a checkpoint is due, based on the number of items (files, documents) processed
+// and the time elapsed since the last checkpoint. SaveState and LoadState write and read an arbitrary
+// state value as YAML, atomically, so that an interrupted checkpoint never corrupts the previous one.
+
+// Policy specifies how often checkpoints should be taken.
+// A zero value for either field disables that trigger; if both are zero, checkpointing is disabled.
+type Policy struct {
+	EveryItems int           // Checkpoint after this many items have been processed
+	Every      time.Duration // Checkpoint after this much time has elapsed
+}
+
+// Returns true if the policy will ever trigger a checkpoint
+func (policy Policy) Enabled() bool {
+	return (policy.EveryItems > 0) || (policy.Every > 0)
+}
+
+// Timer tracks progress since the last checkpoint
+type Timer struct {
+	policy Policy
+	items  int
+	last   time.Time
+}
+
+// Creates a Timer that starts counting now
+func NewTimer(policy Policy) *Timer {
+	return &Timer{policy: policy, last: time.Now()}
+}
+
+// Records that n more items have been processed.
+// Returns true if a checkpoint is now due, in which case the counters are reset.
+func (timer *Timer) Add(n int) bool {
+	if (timer == nil) || !timer.policy.Enabled() {
+		return false
+	}
+	timer.items += n
+	due := (timer.policy.EveryItems > 0) && (timer.items >= timer.policy.EveryItems)
+	due = due || ((timer.policy.Every > 0) && (time.Since(timer.last) >= timer.policy.Every))
+	if due {
+		timer.items = 0
+		timer.last = time.Now()
+	}
+	return due
+}
+
+// Writes state to the specified file as YAML, atomically.
+func SaveState(filename string, state interface{}) error {
+	data, err := document.MarshalYaml(state)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filename, data, 0644)
+}
+
+// Reads state previously written by SaveState.
+// Returns false (and no error) if the file does not exist.
+func LoadState(filename string, state interface{}) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, document.UnmarshalYaml(data, state)
+}
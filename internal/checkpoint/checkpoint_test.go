@@ -0,0 +1,58 @@
+package checkpoint
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimerItems(t *testing.T) {
+	timer := NewTimer(Policy{EveryItems: 3})
+	due := []bool{timer.Add(1), timer.Add(1), timer.Add(1), timer.Add(1)}
+	if !reflect.DeepEqual(due, []bool{false, false, true, false}) {
+		t.Fatalf(`Timer.Add() sequence = %v`, due)
+	}
+}
+
+func TestTimerElapsed(t *testing.T) {
+	timer := NewTimer(Policy{Every: time.Millisecond})
+	time.Sleep(2 * time.Millisecond)
+	if !timer.Add(1) {
+		t.Fatalf(`Timer.Add() not due after the interval elapsed`)
+	}
+	if timer.Add(1) {
+		t.Fatalf(`Timer.Add() due immediately after a checkpoint`)
+	}
+}
+
+func TestTimerDisabled(t *testing.T) {
+	var timer *Timer
+	if timer.Add(100) || NewTimer(Policy{}).Add(100) {
+		t.Fatalf(`disabled Timer reported a checkpoint as due`)
+	}
+}
+
+func TestSaveLoadState(t *testing.T) {
+	type state struct {
+		Completed []string
+		Count     int
+	}
+	filename := filepath.Join(t.TempDir(), "state.yaml")
+
+	var loaded state
+	found, err := LoadState(filename, &loaded)
+	if found || err != nil {
+		t.Fatalf(`LoadState() of missing file = %t, %v`, found, err)
+	}
+
+	saved := state{Completed: []string{"DEC_0001", "DEC_0002"}, Count: 42}
+	err = SaveState(filename, saved)
+	if err != nil {
+		t.Fatalf(`SaveState() failed: %v`, err)
+	}
+	found, err = LoadState(filename, &loaded)
+	if !found || err != nil || !reflect.DeepEqual(saved, loaded) {
+		t.Fatalf(`LoadState() = %#v, %t, %v`, loaded, found, err)
+	}
+}
@@ -0,0 +1,186 @@
+package collate
+
+import (
+	"docs-to-yaml/internal/textnorm"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// This package orders text (titles, part numbers, ...) as a reader expects, rather than by the bytes of its UTF-8:
+// case and accents are ignored at first, so that "Éditeur" sorts among the E's and "apple" next to "Apple", and only
+// break ties between strings that are otherwise equal (unaccented before accented, then lower case before upper case).
+//
+// Some languages put accented letters elsewhere in the alphabet, and the collation locale says which:
+//
+//	root   the default: every accented letter sorts with its base letter (as in English, French and German)
+//	sv fi  å, ä and ö come after z
+//	da nb  æ, ø and å come after z (also no and nn)
+//	es     ñ comes after n
+//	bytes  the bytes of the UTF-8 (the order written by older versions of the tools); also C and POSIX
+//
+// A locale may be given as a language alone ("sv") or with a region and encoding ("sv_SE.UTF-8"); a language that is
+// not listed collates as root.
+//
+// The collation used for ordered output (catalogs, index CSV, rendered pages) is that set by --collation in the tools
+// that offer it, or else that named by DOCS_TO_YAML_COLLATION, or else root.
+
+// The environment variable naming the default collation locale
+const Variable = "DOCS_TO_YAML_COLLATION"
+
+const (
+	Root  = "root"
+	Bytes = "bytes"
+)
+
+// A letter placed elsewhere in the alphabet by a locale: after another letter, in rank order
+type placement struct {
+	after rune
+	rank  int
+}
+
+// The letters each language places elsewhere in the alphabet
+var tailorings = map[string]map[rune]placement{
+	"sv": {'å': {'z', 1}, 'ä': {'z', 2}, 'æ': {'z', 2}, 'ö': {'z', 3}, 'ø': {'z', 3}},
+	"da": {'æ': {'z', 1}, 'ä': {'z', 1}, 'ø': {'z', 2}, 'ö': {'z', 2}, 'å': {'z', 3}},
+	"es": {'ñ': {'n', 1}},
+}
+
+// Languages that collate as another
+var aliases = map[string]string{"fi": "sv", "nb": "da", "nn": "da", "no": "da", "c": Bytes, "posix": Bytes}
+
+// Collator orders strings for a locale
+type Collator struct {
+	Locale    string // The locale as given
+	bytes     bool
+	tailoring map[rune]placement
+}
+
+// Returns the collator for a locale (see above)
+func New(locale string) (*Collator, error) {
+	language := strings.ToLower(strings.TrimSpace(locale))
+	language, _, _ = strings.Cut(language, ".")
+	language, _, _ = strings.Cut(strings.ReplaceAll(language, "-", "_"), "_")
+	if alias, found := aliases[language]; found {
+		language = alias
+	}
+	if language == "" {
+		return nil, fmt.Errorf("no collation locale given")
+	}
+	for _, r := range language {
+		if (r < 'a') || (r > 'z') {
+			return nil, fmt.Errorf("invalid collation locale %q", locale)
+		}
+	}
+	return &Collator{Locale: locale, bytes: language == Bytes, tailoring: tailorings[language]}, nil
+}
+
+// Returns a key for a string such that comparing the keys of two strings (as strings) compares the strings in the
+// collation order. The key is only for comparing; it is not readable.
+func (c *Collator) Key(s string) string {
+	if c.bytes {
+		return s
+	}
+	folded := []rune(textnorm.ComposeLatin(s))
+	var key strings.Builder
+	// Primary: the letters without case or accents (other than those the locale places elsewhere), as three-byte
+	// weights ended by a zero weight, so that a string sorts before any longer string it starts
+	for _, r := range folded {
+		lower := unicode.ToLower(r)
+		if placed, found := c.tailoring[lower]; found {
+			writeWeight(&key, placed.after, placed.rank)
+			continue
+		}
+		for _, base := range textnorm.Unaccented(string(lower)) {
+			writeWeight(&key, base, 0)
+		}
+	}
+	key.WriteString("\x00\x00\x00")
+	// Secondary: the accents
+	key.WriteString(strings.ToLower(string(folded)))
+	key.WriteByte(0)
+	// Tertiary: the case, lower before upper
+	for _, r := range folded {
+		if unicode.IsUpper(r) {
+			key.WriteByte(2)
+		} else {
+			key.WriteByte(1)
+		}
+	}
+	key.WriteByte(0)
+	// Finally the string itself, so that only identical strings are equal
+	key.WriteString(s)
+	return key.String()
+}
+
+// Writes the weight of a letter (or of a letter placed rank places after it)
+func writeWeight(key *strings.Builder, r rune, rank int) {
+	weight := int(r)*8 + 1 + rank
+	key.WriteByte(byte(weight >> 16))
+	key.WriteByte(byte(weight >> 8))
+	key.WriteByte(byte(weight))
+}
+
+// Compares two strings in the collation order, returning -1, 0 or +1
+func (c *Collator) Compare(a string, b string) int {
+	return strings.Compare(c.Key(a), c.Key(b))
+}
+
+// Reports whether a sorts before b
+func (c *Collator) Less(a string, b string) bool {
+	return c.Compare(a, b) < 0
+}
+
+// Sorts strings in the collation order
+func (c *Collator) Sort(values []string) {
+	keys := make(map[string]string, len(values))
+	for _, s := range values {
+		keys[s] = c.Key(s)
+	}
+	slices.SortFunc(values, func(a string, b string) int { return strings.Compare(keys[a], keys[b]) })
+}
+
+var (
+	defaultCollator *Collator
+	defaultOnce     sync.Once
+)
+
+// Returns the collation used for ordered output: that set by SetDefault, or else that named by DOCS_TO_YAML_COLLATION,
+// or else root. An invalid DOCS_TO_YAML_COLLATION is reported once, on standard error, and root used instead.
+func Default() *Collator {
+	defaultOnce.Do(func() {
+		if defaultCollator != nil {
+			return
+		}
+		locale := os.Getenv(Variable)
+		if locale == "" {
+			locale = Root
+		}
+		collator, err := New(locale)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s; using %s\n", Variable, err, Root)
+			collator, _ = New(Root)
+		}
+		defaultCollator = collator
+	})
+	return defaultCollator
+}
+
+// Sets the collation used for ordered output
+func SetDefault(locale string) error {
+	collator, err := New(locale)
+	if err != nil {
+		return err
+	}
+	defaultCollator = collator
+	return nil
+}
+
+// Registers --collation on the default flag set, setting the collation used for ordered output
+func Flag() {
+	flag.Func("collation", "the collation locale for ordered output, e.g. sv or bytes (default: $"+Variable+", or root)", SetDefault)
+}
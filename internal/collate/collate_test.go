@@ -0,0 +1,69 @@
+package collate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		locale string
+		ok     bool
+	}{
+		{"root", true},
+		{"sv_SE.UTF-8", true},
+		{"nb-NO", true},
+		{"C", true},
+		{"", false},
+		{"s1", false},
+	} {
+		if _, err := New(test.locale); (err == nil) != test.ok {
+			t.Errorf("New(%q) gave %v", test.locale, err)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	titles := []string{"Zebra", "apple", "Øresund", "Éditeur", "Apple", "Ärger", "editeur", "Edition", "año", "ano", "anuario", "Straße", "Strasse", "Strand"}
+	for _, test := range []struct {
+		locale   string
+		expected []string
+	}{
+		{"root", []string{"ano", "año", "anuario", "apple", "Apple", "Ärger", "editeur", "Éditeur", "Edition", "Øresund", "Strand", "Strasse", "Straße", "Zebra"}},
+		{"sv_SE.UTF-8", []string{"ano", "año", "anuario", "apple", "Apple", "editeur", "Éditeur", "Edition", "Strand", "Strasse", "Straße", "Zebra", "Ärger", "Øresund"}},
+		{"da", []string{"ano", "año", "anuario", "apple", "Apple", "editeur", "Éditeur", "Edition", "Strand", "Strasse", "Straße", "Zebra", "Ärger", "Øresund"}},
+		{"es", []string{"ano", "anuario", "año", "apple", "Apple", "Ärger", "editeur", "Éditeur", "Edition", "Øresund", "Strand", "Strasse", "Straße", "Zebra"}},
+	} {
+		collator, err := New(test.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := slices.Clone(titles)
+		collator.Sort(sorted)
+		if !slices.Equal(sorted, test.expected) {
+			t.Errorf("%s: sorted %q", test.locale, sorted)
+		}
+	}
+
+	// bytes keeps the order of the UTF-8
+	bytes, _ := New("bytes")
+	sorted := slices.Clone(titles)
+	bytes.Sort(sorted)
+	if !slices.IsSorted(sorted) {
+		t.Errorf("bytes: sorted %q", sorted)
+	}
+	root, _ := New("root")
+	if (root.Compare("apple", "apple") != 0) || !root.Less("Apple", "apples") || !root.Less("résumé", "resumes") {
+		t.Errorf("root: unexpected comparisons")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer func(collator *Collator) { defaultCollator = collator }(defaultCollator)
+	if err := SetDefault("sv"); (err != nil) || !Default().Less("Zebra", "Ärger") {
+		t.Errorf("SetDefault(sv) did not take effect: %v", err)
+	}
+	if err := SetDefault("!"); err == nil {
+		t.Errorf("SetDefault() accepted an invalid locale")
+	}
+}
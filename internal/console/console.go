@@ -0,0 +1,156 @@
+package console
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// This package controls how much the tools print on the console, and how.
+//
+// Every tool registers the same flags through Flags:
+//
+//	--quiet     print nothing but the final summary line (warnings and errors still reach the events file)
+//	-v          print progress details (--verbose is the same)
+//	-vv         print per-file details as well (-v may also be given twice)
+//	--no-color  never colour the output
+//
+// Warnings, errors and the final summary are coloured by severity when standard output is a terminal, unless
+// --no-color is given or the NO_COLOR environment variable is set (see https://no-color.org).
+//
+// --quiet works by discarding standard output, so the many progress messages printed with fmt.Printf are dropped
+// without each needing a check; the summary line is written to the real standard output through Summary.
+
+// Level is the amount of console output requested
+type Level int
+
+const (
+	Quiet       Level = -1 // Only the final summary
+	Normal      Level = 0  // Progress, warnings and errors
+	Verbose     Level = 1  // -v: progress details
+	VeryVerbose Level = 2  // -vv: per-file details
+)
+
+// ANSI colours for each kind of message
+const (
+	Red    = "\033[31m"
+	Yellow = "\033[33m"
+	Green  = "\033[32m"
+	reset  = "\033[0m"
+)
+
+var level = Normal
+var verbose bool
+var noColor bool
+
+// The real standard output, kept for the summary line when --quiet discards everything else
+var stdout io.Writer = os.Stdout
+
+// The value of -v and --verbose: each use raises the level by one, as does "=true", while "=false" does nothing
+type verbosityFlag struct{}
+
+func (verbosityFlag) IsBoolFlag() bool { return true }
+func (verbosityFlag) String() string   { return "" }
+func (verbosityFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if (err == nil) && on {
+		raise(level + 1)
+	}
+	return err
+}
+
+// The value of -vv
+type veryVerboseFlag struct{}
+
+func (veryVerboseFlag) IsBoolFlag() bool { return true }
+func (veryVerboseFlag) String() string   { return "" }
+func (veryVerboseFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if (err == nil) && on {
+		raise(VeryVerbose)
+	}
+	return err
+}
+
+// Raises the verbosity to at least the specified level (unless --quiet was given)
+func raise(l Level) {
+	if (level != Quiet) && (l > level) {
+		level = min(l, VeryVerbose)
+		verbose = true
+	}
+}
+
+// Registers --quiet, -v, --verbose, -vv and --no-color on the default flag set. verboseUsage describes what -v adds
+// for the calling tool. The result is true (once the flags are parsed) if -v or -vv was given, so it can be used
+// wherever the tool previously used the value of a --verbose boolean flag.
+func Flags(verboseUsage string) *bool {
+	flag.Var(verbosityFlag{}, "verbose", verboseUsage+" (the same as -v)")
+	flag.Var(verbosityFlag{}, "v", verboseUsage+"; repeat, or use -vv, for per-file details")
+	flag.Var(veryVerboseFlag{}, "vv", "print per-file details as well as the -v output")
+	flag.BoolFunc("quiet", "print only the final summary line", func(s string) error {
+		on, err := strconv.ParseBool(s)
+		if (err == nil) && on {
+			SetLevel(Quiet)
+		}
+		return err
+	})
+	flag.BoolVar(&noColor, "no-color", false, "do not colour warnings, errors and the summary")
+	return &verbose
+}
+
+// Sets the output level directly. Quiet discards standard output from then on.
+func SetLevel(l Level) {
+	level = l
+	verbose = (l >= Verbose)
+	if l == Quiet {
+		// os.Open would give a read-only file, to which every write fails
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+	}
+}
+
+// Returns the output level requested
+func CurrentLevel() Level {
+	return level
+}
+
+// Prints a message (formatted as by fmt.Printf) only with -vv
+func Debugf(format string, args ...interface{}) {
+	if level >= VeryVerbose {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Returns the message wrapped in the specified colour, if colour is in use (a blank colour means none)
+func Colour(colour string, message string) string {
+	if (colour == "") || (message == "") || !useColour() {
+		return message
+	}
+	// Keep any trailing newline outside the colour, so that a terminal's next line is not coloured
+	body, newline := message, ""
+	if message[len(message)-1] == '\n' {
+		body, newline = message[:len(message)-1], "\n"
+	}
+	return colour + body + reset + newline
+}
+
+// Prints the final summary line of a run, in the specified colour. This is printed even with --quiet.
+func Summary(colour string, line string) {
+	fmt.Fprintln(stdout, Colour(colour, line))
+}
+
+// Reports whether output should be coloured: only when standard output is a terminal and colour is not disabled
+func useColour() bool {
+	if noColor || (os.Getenv("NO_COLOR") != "") {
+		return false
+	}
+	file, ok := stdout.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return (err == nil) && (info.Mode()&os.ModeCharDevice != 0)
+}
@@ -0,0 +1,58 @@
+package console
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected Level
+	}{
+		{[]string{}, Normal},
+		{[]string{"-v"}, Verbose},
+		{[]string{"--verbose"}, Verbose},
+		{[]string{"-v", "-v"}, VeryVerbose},
+		{[]string{"-vv"}, VeryVerbose},
+		{[]string{"-vv", "-v"}, VeryVerbose},
+		{[]string{"--verbose=false"}, Normal},
+	}
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+	for _, test := range tests {
+		flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+		level, verbose = Normal, false
+		isVerbose := Flags("Enable verbose reporting")
+		if err := flag.CommandLine.Parse(test.args); err != nil {
+			t.Fatalf(`Parse(%v) failed: %v`, test.args, err)
+		}
+		if (CurrentLevel() != test.expected) || (*isVerbose != (test.expected >= Verbose)) {
+			t.Errorf(`Parse(%v) gave level %d, verbose %v, expected %d`, test.args, CurrentLevel(), *isVerbose, test.expected)
+		}
+	}
+	level, verbose = Normal, false
+}
+
+func TestQuiet(t *testing.T) {
+	savedStdout := os.Stdout
+	defer func() { os.Stdout, level = savedStdout, Normal }()
+	SetLevel(Quiet)
+	raise(VeryVerbose)
+	if (CurrentLevel() != Quiet) || (os.Stdout == savedStdout) {
+		t.Errorf(`SetLevel(Quiet) left level %d`, CurrentLevel())
+	}
+	// Output is discarded, not refused
+	if _, err := fmt.Println("discarded"); err != nil {
+		t.Errorf(`writing to the discarded standard output failed: %v`, err)
+	}
+}
+
+func TestColour(t *testing.T) {
+	// Tests do not run with a terminal as standard output, so nothing is coloured
+	if got := Colour(Red, "FAILED\n"); got != "FAILED\n" {
+		t.Errorf(`Colour() without a terminal = %q`, got)
+	}
+}
@@ -0,0 +1,172 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/fsutil"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// This package converts a document held in a format that a browser cannot show well (TXT, RNO, MEM, ...) to one that
+// it can (HTML or PDF), on request, so that the archive need not be converted in advance.
+//
+// Each converter is given as FROM:TO=COMMAND, e.g.
+//
+//	RNO:html=rno2html {input}
+//	TXT:pdf=text2pdf -f Courier {input}
+//
+// The command is split into arguments as for an --exec hook (no shell is involved), {input} is replaced by the
+// filepath of the document, and whatever the command writes to its standard output is the converted document. A
+// converter that fails, or writes nothing, gives an error.
+//
+// Plain text (TXT and MEM) is converted to HTML without any command, as preformatted text, unless a converter is given
+// for it.
+
+// The formats a document can be converted to, with their content types
+var Targets = map[string]string{
+	"html": "text/html; charset=utf-8",
+	"pdf":  "application/pdf",
+}
+
+// The formats converted to HTML as preformatted text if no converter is given
+var PlainTextFormats = []string{"TXT", "MEM"}
+
+// The longest a converter may run
+const Timeout = 2 * time.Minute
+
+// Converter converts documents of one format to another
+type Converter struct {
+	From string // The format converted, e.g. "RNO"
+	To   string // One of Targets
+	hook *exechook.Hook
+}
+
+// Parses a converter given as FROM:TO=COMMAND
+func Parse(spec string) (Converter, error) {
+	formats, command, found := strings.Cut(spec, "=")
+	from, to, colon := strings.Cut(formats, ":")
+	from, to = strings.ToUpper(strings.TrimSpace(from)), strings.ToLower(strings.TrimSpace(to))
+	if !found || !colon || (from == "") || (strings.TrimSpace(command) == "") {
+		return Converter{}, fmt.Errorf("expected FROM:TO=COMMAND, found %q", spec)
+	}
+	if _, known := Targets[to]; !known {
+		return Converter{}, fmt.Errorf("cannot convert to %q: expected html or pdf", to)
+	}
+	hook, err := exechook.Parse(command)
+	if err != nil {
+		return Converter{}, err
+	}
+	return Converter{From: from, To: to, hook: hook}, nil
+}
+
+// Set is the converters available
+type Set []Converter
+
+// Returns the converter from one format to another, and whether there is one (or, for plain text to HTML, whether
+// it can be converted without one)
+func (set Set) Find(from string, to string) (Converter, bool) {
+	from, to = strings.ToUpper(from), strings.ToLower(to)
+	for _, converter := range set {
+		if (converter.From == from) && (converter.To == to) {
+			return converter, true
+		}
+	}
+	if (to == "html") && slices.Contains(PlainTextFormats, from) {
+		return Converter{From: from, To: to}, true
+	}
+	return Converter{}, false
+}
+
+// Returns the formats a document of the given format can be converted to, sorted
+func (set Set) TargetsOf(from string) []string {
+	var targets []string
+	for to := range Targets {
+		if _, found := set.Find(from, to); found {
+			targets = append(targets, to)
+		}
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+// Runs a command, returning what it writes to its standard output.
+// Tests replace this to avoid running converters.
+var runCommand = func(args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var output, messages bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &messages
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(messages.String()))
+	}
+	return output.Bytes(), nil
+}
+
+// Converts the document in a file, returning the converted document
+func (converter Converter) Convert(filename string) ([]byte, error) {
+	if converter.hook == nil {
+		text, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return PlainTextHtml(filepath.Base(filename), text), nil
+	}
+	output, err := runCommand(converter.hook.Expand(map[string]string{"{input}": filename}))
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("converting %s to %s gave nothing", filepath.Base(filename), converter.To)
+	}
+	return output, nil
+}
+
+// Returns an HTML page showing text as preformatted text
+func PlainTextHtml(title string, text []byte) []byte {
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<pre>", html.EscapeString(title))
+	// Form feeds separate the pages of a DEC text document
+	for i, pageText := range strings.Split(strings.ToValidUTF8(string(text), "�"), "\f") {
+		if i > 0 {
+			page.WriteString("</pre>\n<hr>\n<pre>")
+		}
+		page.WriteString(html.EscapeString(pageText))
+	}
+	page.WriteString("</pre>\n</body>\n</html>\n")
+	return page.Bytes()
+}
+
+// Cache keeps converted documents in a directory, named by the MD5 checksum of the original and the target format,
+// so that each document is converted once. A blank Dir caches nothing.
+type Cache struct {
+	Dir string
+}
+
+// Returns the converted document for an MD5 checksum, converting the file if it is not cached (and caching it)
+func (cache Cache) Convert(converter Converter, filename string, md5 string) ([]byte, error) {
+	if (cache.Dir == "") || (md5 == "") {
+		return converter.Convert(filename)
+	}
+	cached := filepath.Join(cache.Dir, md5+"."+converter.To)
+	if data, err := os.ReadFile(cached); err == nil {
+		return data, nil
+	}
+	data, err := converter.Convert(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cache.Dir, 0755); err == nil {
+		fsutil.WriteFileAtomic(cached, data, 0644)
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package convert
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		from string
+		to   string
+		ok   bool
+	}{
+		{"RNO:html=rno2html {input}", "RNO", "html", true},
+		{"txt:PDF=text2pdf -f Courier {input}", "TXT", "pdf", true},
+		{"RNO:docx=pandoc {input}", "", "", false},
+		{"RNO=rno2html {input}", "", "", false},
+		{"RNO:html=", "", "", false},
+		{"RNO:html=rno2html 'unterminated", "", "", false},
+	} {
+		converter, err := Parse(test.spec)
+		if ((err == nil) != test.ok) || (converter.From != test.from) || (converter.To != test.to) {
+			t.Errorf("Parse(%q) = %+v, %v", test.spec, converter, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	rno, _ := Parse("RNO:html=rno2html {input}")
+	txt, _ := Parse("TXT:pdf=text2pdf {input}")
+	set := Set{rno, txt}
+	if converter, found := set.Find("rno", "html"); !found || (converter.hook == nil) {
+		t.Errorf("Find(RNO, html) = %+v, %v", converter, found)
+	}
+	if converter, found := set.Find("TXT", "html"); !found || (converter.hook != nil) {
+		t.Errorf("Find(TXT, html) = %+v, %v; expected the built-in conversion", converter, found)
+	}
+	if _, found := set.Find("RNO", "pdf"); found {
+		t.Errorf("Find(RNO, pdf) found a converter")
+	}
+	if targets := set.TargetsOf("TXT"); !slices.Equal(targets, []string{"html", "pdf"}) {
+		t.Errorf("TargetsOf(TXT) = %v", targets)
+	}
+	if targets := set.TargetsOf("PDF"); len(targets) != 0 {
+		t.Errorf("TargetsOf(PDF) = %v", targets)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(filename, []byte("Page <one>\fPage two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, _ := Set{}.Find("TXT", "html")
+	page, err := plain.Convert(filename)
+	if (err != nil) || !strings.Contains(string(page), "Page &lt;one&gt;</pre>\n<hr>\n<pre>Page two") {
+		t.Errorf("Convert() of plain text = %s, %v", page, err)
+	}
+
+	defer func(run func([]string) ([]byte, error)) { runCommand = run }(runCommand)
+	var ran [][]string
+	runCommand = func(args []string) ([]byte, error) {
+		ran = append(ran, args)
+		if args[0] == "broken" {
+			return nil, errors.New("broken: exit status 1")
+		}
+		return []byte("%PDF-1.4 converted"), nil
+	}
+	converter, _ := Parse("TXT:pdf=text2pdf -f Courier {input}")
+	cache := Cache{Dir: filepath.Join(dir, "cache")}
+	for i := 0; i < 2; i++ {
+		if data, err := cache.Convert(converter, filename, "0123456789abcdef0123456789abcdef"); (err != nil) || (string(data) != "%PDF-1.4 converted") {
+			t.Errorf("Cache.Convert() = %q, %v", data, err)
+		}
+	}
+	if (len(ran) != 1) || !slices.Equal(ran[0], []string{"text2pdf", "-f", "Courier", filename}) {
+		t.Errorf("Cache.Convert() twice ran %v", ran)
+	}
+
+	broken, _ := Parse("TXT:pdf=broken {input}")
+	if _, err := broken.Convert(filename); err == nil {
+		t.Errorf("Convert() with a failing converter succeeded")
+	}
+}
@@ -0,0 +1,330 @@
+package crcfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This package reads and verifies the checksum files in older DEC formats that some archived discs carry, such as the
+// DEC_0040.CRC at the root of one volume or a .CRC file in each directory of others.
+//
+// Each line of such a file gives the CRC of one file, relative to the directory holding the .CRC file, but the layout
+// varies from disc to disc:
+//
+//	crc-first:  1A2B3C4D  KA630.PDF      the CRC, then the filename
+//	name-first: KA630.PDF 1A2B3C4D       the filename, then the CRC (as in an SFV file)
+//
+// The CRC is 8 hexadecimal digits for a CRC-32, 4 hexadecimal digits for a CRC-16, or 6 octal digits (as DEC software
+// usually printed them) for a CRC-16. Lines starting with ";", "!" or "#" are comments, and "\" in a filename is taken
+// to be a directory separator. The layout and width are detected from the file itself (see Parse).
+//
+// A CRC-32 is always the IEEE CRC-32 (as used by SFV and zip), but a CRC-16 may be either of the two in common use: the
+// CRC-16 of DDCMP and ARC or the CRC-16/CCITT. Which one a file uses is detected by Verify from the first file that
+// matches either.
+//
+// The CRCs are computed here rather than by an external program, so a check needs nothing beyond this repository.
+
+// The CRC algorithms
+const (
+	CRC32      = "crc32"       // CRC-32 (IEEE 802.3): polynomial 0x04C11DB7, reflected
+	CRC16      = "crc16"       // CRC-16 (DDCMP, ARC): polynomial 0x8005, reflected, initial value 0
+	CRC16CCITT = "crc16-ccitt" // CRC-16/CCITT: polynomial 0x1021, not reflected, initial value 0xFFFF
+)
+
+// The layouts of a line
+const (
+	CrcFirst  = "crc-first"
+	NameFirst = "name-first"
+)
+
+// The conventional extension of a checksum file
+const Extension = ".CRC"
+
+// Entry is the CRC recorded for one file
+type Entry struct {
+	Filepath string // Relative to the directory holding the checksum file, with "/" as the separator
+	Crc      uint32
+}
+
+// File is the content of a checksum file
+type File struct {
+	Layout  string // CrcFirst or NameFirst
+	Width   int    // 16 or 32 bits
+	Octal   bool   // True if the CRCs were written in octal
+	Entries []Entry
+}
+
+// Returns a description of the format, e.g. "crc-first, 16-bit octal"
+func (f File) Format() string {
+	radix := "hex"
+	if f.Octal {
+		radix = "octal"
+	}
+	return fmt.Sprintf("%s, %d-bit %s", f.Layout, f.Width, radix)
+}
+
+// Returns the algorithms that could have produced the file's CRCs
+func (f File) Algorithms() []string {
+	if f.Width == 32 {
+		return []string{CRC32}
+	}
+	return []string{CRC16, CRC16CCITT}
+}
+
+// Returns the width (in bits) and radix of a CRC written as token, or 0 if it is not a CRC
+func crcWidth(token string) (int, bool) {
+	isDigits := func(digits string) bool {
+		return strings.Trim(strings.ToLower(token), digits) == ""
+	}
+	switch {
+	case (len(token) == 8) && isDigits("0123456789abcdef"):
+		return 32, false
+	case (len(token) == 4) && isDigits("0123456789abcdef"):
+		return 16, false
+	case (len(token) == 6) && isDigits("01234567") && (token[0] <= '1'):
+		return 16, true
+	}
+	return 0, false
+}
+
+// Reads a checksum file. The layout and width are those of the first line that can only be read one way; every line
+// must then agree with them.
+func Parse(data []byte) (File, error) {
+	var lines [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (line == "") || strings.ContainsAny(line[:1], ";!#") {
+			continue
+		}
+		lines = append(lines, strings.Fields(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return File{}, err
+	}
+
+	var file File
+	for _, fields := range lines {
+		if len(fields) < 2 {
+			continue
+		}
+		firstWidth, firstOctal := crcWidth(fields[0])
+		lastWidth, lastOctal := crcWidth(fields[len(fields)-1])
+		if (firstWidth != 0) && (lastWidth == 0) {
+			file.Layout, file.Width, file.Octal = CrcFirst, firstWidth, firstOctal
+			break
+		} else if (lastWidth != 0) && (firstWidth == 0) {
+			file.Layout, file.Width, file.Octal = NameFirst, lastWidth, lastOctal
+			break
+		}
+	}
+	if file.Layout == "" {
+		return file, errors.New("no line gives a CRC and a filename")
+	}
+
+	for i, fields := range lines {
+		if len(fields) < 2 {
+			return file, fmt.Errorf("entry %d: expected a CRC and a filename, found %q", i+1, strings.Join(fields, " "))
+		}
+		token, name := fields[0], strings.Join(fields[1:], " ")
+		if file.Layout == NameFirst {
+			token, name = fields[len(fields)-1], strings.Join(fields[:len(fields)-1], " ")
+		}
+		width, octal := crcWidth(token)
+		if (width != file.Width) || (octal != file.Octal) {
+			return file, fmt.Errorf("entry %d: %q is not a %s CRC", i+1, token, file.Format())
+		}
+		base := 16
+		if octal {
+			base = 8
+		}
+		crc, _ := strconv.ParseUint(token, base, 32)
+		file.Entries = append(file.Entries, Entry{Filepath: strings.ReplaceAll(name, `\`, "/"), Crc: uint32(crc)})
+	}
+	return file, nil
+}
+
+var (
+	crc16Table      = makeTable16(0xA001, true)  // 0x8005 reflected
+	crc16CcittTable = makeTable16(0x1021, false) // Not reflected
+)
+
+// Returns the lookup table for a CRC-16, reflected (least significant bit first) or not
+func makeTable16(polynomial uint16, reflected bool) *[256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		var crc uint16
+		if reflected {
+			crc = uint16(i)
+			for bit := 0; bit < 8; bit++ {
+				if crc&1 != 0 {
+					crc = (crc >> 1) ^ polynomial
+				} else {
+					crc >>= 1
+				}
+			}
+		} else {
+			crc = uint16(i) << 8
+			for bit := 0; bit < 8; bit++ {
+				if crc&0x8000 != 0 {
+					crc = (crc << 1) ^ polynomial
+				} else {
+					crc <<= 1
+				}
+			}
+		}
+		table[i] = crc
+	}
+	return &table
+}
+
+// Computes the CRCs of everything read from r with each of the algorithms, in a single pass
+func Checksums(r io.Reader, algorithms []string) (map[string]uint32, error) {
+	crc16, crcCcitt := uint16(0), uint16(0xFFFF)
+	crc32Hash := crc32.NewIEEE()
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buffer)
+		for _, b := range buffer[:n] {
+			crc16 = (crc16 >> 8) ^ crc16Table[byte(crc16)^b]
+			crcCcitt = (crcCcitt << 8) ^ crc16CcittTable[byte(crcCcitt>>8)^b]
+		}
+		crc32Hash.Write(buffer[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	all := map[string]uint32{CRC32: crc32Hash.Sum32(), CRC16: uint32(crc16), CRC16CCITT: uint32(crcCcitt)}
+	crcs := make(map[string]uint32)
+	for _, algorithm := range algorithms {
+		crcs[algorithm] = all[algorithm]
+	}
+	return crcs, nil
+}
+
+// Computes the CRC of data with one algorithm
+func Checksum(data []byte, algorithm string) uint32 {
+	crcs, _ := Checksums(bytes.NewReader(data), []string{algorithm})
+	return crcs[algorithm]
+}
+
+// Result is the outcome of checking one file against its CRC
+type Result struct {
+	Filepath string // Relative to the root of fsys
+	Problem  string // Why the file does not match; blank if it does
+}
+
+// Checks every file listed in the named checksum file in fsys. The algorithm is the first (see File.Algorithms) that
+// gives the recorded CRC of a file; if none ever does, the first is assumed. Returns the algorithm and the outcome for
+// each file, in the order listed.
+func Verify(fsys fs.FS, name string) (string, []Result, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", nil, err
+	}
+	file, err := Parse(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", name, err)
+	}
+	dir := path.Dir(name)
+	candidates := file.Algorithms()
+	algorithm := ""
+	crcs := make([]map[string]uint32, len(file.Entries))
+	problems := make([]string, len(file.Entries))
+	results := make([]Result, len(file.Entries))
+	for i, entry := range file.Entries {
+		results[i].Filepath = resolve(fsys, path.Join(dir, entry.Filepath))
+		if crcs[i], err = checksumFile(fsys, results[i].Filepath, candidates); err != nil {
+			problems[i] = err.Error()
+			continue
+		}
+		for _, candidate := range candidates {
+			if (algorithm == "") && (crcs[i][candidate] == entry.Crc) {
+				algorithm = candidate
+			}
+		}
+	}
+	if algorithm == "" {
+		algorithm = candidates[0]
+	}
+	for i, entry := range file.Entries {
+		results[i].Problem = problems[i]
+		if (problems[i] == "") && (crcs[i][algorithm] != entry.Crc) {
+			results[i].Problem = fmt.Sprintf("%s is %s, recorded as %s", algorithm, format(crcs[i][algorithm], file), format(entry.Crc, file))
+		}
+	}
+	return algorithm, results, nil
+}
+
+// Writes a CRC as it appears in the file
+func format(crc uint32, file File) string {
+	switch {
+	case file.Octal:
+		return fmt.Sprintf("%06o", crc)
+	case file.Width == 16:
+		return fmt.Sprintf("%04X", crc)
+	}
+	return fmt.Sprintf("%08X", crc)
+}
+
+// Computes the CRCs of a file in fsys
+func checksumFile(fsys fs.FS, name string, algorithms []string) (map[string]uint32, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Checksums(file, algorithms)
+}
+
+// Returns the name of a file, matching each component without regard to case if there is no exact match (the discs
+// were written on Windows, so the names in a checksum file do not always match the case of the files)
+func resolve(fsys fs.FS, name string) string {
+	if _, err := fs.Stat(fsys, name); err == nil {
+		return name
+	}
+	resolved := "."
+	for _, component := range strings.Split(name, "/") {
+		if component == "." {
+			continue
+		}
+		match := component
+		if entries, err := fs.ReadDir(fsys, resolved); err == nil {
+			for _, entry := range entries {
+				if strings.EqualFold(entry.Name(), component) {
+					match = entry.Name()
+					break
+				}
+			}
+		}
+		resolved = path.Join(resolved, match)
+	}
+	return resolved
+}
+
+// Returns the checksum files (named *.CRC, without regard to case) in fsys, sorted
+func Find(fsys fs.FS) ([]string, error) {
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(path.Ext(name), Extension) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
@@ -0,0 +1,66 @@
+package crcfile
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestChecksum(t *testing.T) {
+	// The standard check values, for the CRC of "123456789"
+	for algorithm, expected := range map[string]uint32{CRC32: 0xCBF43926, CRC16: 0xBB3D, CRC16CCITT: 0x29B1} {
+		if crc := Checksum([]byte("123456789"), algorithm); crc != expected {
+			t.Errorf(`Checksum(%s) = %X, expected %X`, algorithm, crc, expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		expected File
+	}{
+		{"; Generated 1998\nCBF43926  KA630.PDF\ncbf43926 SUB\\README TXT.TXT\n",
+			File{Layout: CrcFirst, Width: 32, Entries: []Entry{{"KA630.PDF", 0xCBF43926}, {"SUB/README TXT.TXT", 0xCBF43926}}}},
+		{"KA630.PDF BB3D\n", File{Layout: NameFirst, Width: 16, Entries: []Entry{{"KA630.PDF", 0xBB3D}}}},
+		{"KA630.PDF  135475\n", File{Layout: NameFirst, Width: 16, Octal: true, Entries: []Entry{{"KA630.PDF", 0xBB3D}}}},
+		// "DEAD" could be a filename, so the layout is taken from the second line
+		{"BEEF DEAD\n1234 KA630.PDF\n", File{Layout: CrcFirst, Width: 16, Entries: []Entry{{"DEAD", 0xBEEF}, {"KA630.PDF", 0x1234}}}},
+	} {
+		file, err := Parse([]byte(test.data))
+		if (err != nil) || !reflect.DeepEqual(file, test.expected) {
+			t.Errorf(`Parse(%q) = %+v, %v, expected %+v`, test.data, file, err, test.expected)
+		}
+	}
+	for _, bad := range []string{"", "KA630.PDF\n", "CBF43926 KA630.PDF\nBB3D KA650.PDF\n"} {
+		if file, err := Parse([]byte(bad)); err == nil {
+			t.Errorf(`Parse(%q) = %+v, expected an error`, bad, file)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fsys := fstest.MapFS{
+		"DEC_0040.CRC":       {Data: []byte("Disc/ka630.pdf 29B1\nDISC/KA650.PDF 29B1\nDISC/MISSING.PDF 0000\n")},
+		"disc/ka630.pdf":     {Data: []byte("123456789")},
+		"disc/ka650.pdf":     {Data: []byte("12345678X")},
+		"other/FILES.CRC":    {Data: []byte("CBF43926 A.TXT\n")},
+		"other/a.txt":        {Data: []byte("123456789")},
+		"other/notes.crcbak": {Data: []byte("")},
+	}
+	algorithm, results, err := Verify(fsys, "DEC_0040.CRC")
+	if (err != nil) || (algorithm != CRC16CCITT) || (len(results) != 3) {
+		t.Fatalf(`Verify() = %s, %+v, %v`, algorithm, results, err)
+	}
+	if (results[0] != Result{Filepath: "disc/ka630.pdf"}) || (results[1].Filepath != "disc/ka650.pdf") || (results[1].Problem == "") || (results[2].Problem == "") {
+		t.Errorf(`Verify() gave %+v`, results)
+	}
+	if algorithm, results, err := Verify(fsys, "other/FILES.CRC"); (err != nil) || (algorithm != CRC32) || !reflect.DeepEqual(results, []Result{{Filepath: "other/a.txt"}}) {
+		t.Errorf(`Verify() of a CRC-32 file = %s, %+v, %v`, algorithm, results, err)
+	}
+
+	names, err := Find(fsys)
+	if (err != nil) || !reflect.DeepEqual(names, []string{"DEC_0040.CRC", "other/FILES.CRC"}) {
+		t.Errorf(`Find() = %v, %v`, names, err)
+	}
+}
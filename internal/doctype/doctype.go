@@ -0,0 +1,141 @@
+package doctype
+
+import (
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// This package classifies documents by type (manual, print set, brochure, datasheet, schematic, ...), recorded in
+// Document.DocType, for statistics and for deciding what to master onto which volume.
+//
+// The type is chosen by the first of a list of rules that matches the document. A rule matches if its part number
+// prefixes or its title keywords match (either is enough), optionally only for documents of given formats. The rules
+// may be read from a YAML file:
+//
+//	- type: print-set
+//	  partnumprefixes: [MP-]
+//	  keywords: [print set, engineering drawings]
+//	- type: datasheet
+//	  keywords: [data sheet, datasheet]
+//
+// A type set by a rule is marked with the "K" flag (see Document.Flags and document.CodeSetFlags); a type set by hand is
+// not, and is left alone when the catalog is classified again.
+
+type Document = document.Document
+
+// Rule assigns a type to the documents it matches
+type Rule struct {
+	Type            string   // The type given to matching documents, e.g. "manual"
+	PartNumPrefixes []string `yaml:",omitempty"` // Part number prefixes (compared without regard to case), e.g. "MP-"
+	Keywords        []string `yaml:",omitempty"` // Words or phrases found in the title (ignoring case and punctuation)
+	Formats         []string `yaml:",omitempty"` // Formats the rule is limited to, e.g. "PDF"; blank for any
+}
+
+// Rules are tried in order; the first that matches a document gives its type
+type Rules []Rule
+
+// The rules used when none are supplied. DEC maintenance print sets have part numbers starting MP-; the rest are
+// recognised from their titles, with anything that is still unmatched but has a DEC manual part number (EK-, AA-, AD-
+// or AV-) taken to be a manual.
+var DefaultRules = Rules{
+	{Type: "print-set", PartNumPrefixes: []string{"MP-"}, Keywords: []string{"print set", "print sets", "engineering drawings", "field maintenance print"}},
+	{Type: "schematic", Keywords: []string{"schematic", "schematics", "circuit diagram", "circuit diagrams", "wiring diagram"}},
+	{Type: "datasheet", Keywords: []string{"data sheet", "datasheet", "data sheets", "datasheets", "spec sheet"}},
+	{Type: "brochure", Keywords: []string{"brochure", "product bulletin", "product description", "sales guide", "flyer"}},
+	{Type: "handbook", Keywords: []string{"handbook"}},
+	{Type: "newsletter", Keywords: []string{"newsletter", "newsletters"}},
+	{Type: "manual", PartNumPrefixes: []string{"EK-", "AA-", "AD-", "AV-"}, Keywords: []string{"manual", "guide", "reference", "pocket service"}},
+}
+
+// A type is a lower-case word, or words joined by hyphens
+var typeRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Reports whether a type is well formed (lower-case words joined by hyphens, e.g. "print-set")
+func IsType(docType string) bool {
+	return typeRegexp.MatchString(docType)
+}
+
+// Reads rules from a YAML file. An empty filename gives DefaultRules.
+func Load(filename string) (Rules, error) {
+	if filename == "" {
+		return DefaultRules, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := document.UnmarshalYaml(data, &rules); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// Returns an error if a rule has a malformed type or nothing to match on
+func (rules Rules) Validate() error {
+	for i, rule := range rules {
+		if !IsType(rule.Type) {
+			return fmt.Errorf("rule %d: type must be lower-case words joined by hyphens, not %q", i+1, rule.Type)
+		}
+		if (len(rule.PartNumPrefixes) == 0) && (len(rule.Keywords) == 0) {
+			return fmt.Errorf("rule %d (%s): no partnumprefixes or keywords", i+1, rule.Type)
+		}
+	}
+	return nil
+}
+
+// Returns the type given to a document by the first rule that matches it, or "" if none does
+func (rules Rules) Classify(doc Document) string {
+	title := " " + simplify(doc.Title) + " "
+	partNums := append([]string{doc.PartNum}, doc.AltPartNums...)
+	for _, rule := range rules {
+		if (len(rule.Formats) > 0) && !slices.ContainsFunc(rule.Formats, func(f string) bool { return strings.EqualFold(f, doc.Format) }) {
+			continue
+		}
+		for _, prefix := range rule.PartNumPrefixes {
+			for _, partNum := range partNums {
+				if (partNum != "") && strings.HasPrefix(strings.ToUpper(partNum), strings.ToUpper(prefix)) {
+					return rule.Type
+				}
+			}
+		}
+		for _, keyword := range rule.Keywords {
+			if keyword = simplify(keyword); (keyword != "") && strings.Contains(title, " "+keyword+" ") {
+				return rule.Type
+			}
+		}
+	}
+	return ""
+}
+
+// Sets the type of a document by the rules, unless it was set by hand (force overrides this). Returns true if the
+// document changed.
+func Apply(doc *Document, rules Rules, force bool) bool {
+	if (doc.DocType != "") && !strings.Contains(doc.Flags, document.CodeSetFlags["DocType"]) && !force {
+		return false
+	}
+	docType := rules.Classify(*doc)
+	before := doc.Flags
+	if docType == "" {
+		document.ClearFlags(doc, document.CodeSetFlags["DocType"])
+	} else {
+		document.SetFlags(doc, document.CodeSetFlags["DocType"])
+	}
+	changed := (doc.DocType != docType) || (doc.Flags != before)
+	doc.DocType = docType
+	return changed
+}
+
+// Returns the letters and digits of a title, lower-cased, with single spaces between words
+func simplify(title string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !(((r >= 'a') && (r <= 'z')) || ((r >= '0') && (r <= '9')) || (r > 0x7F))
+	}), " ")
+}
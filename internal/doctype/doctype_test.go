@@ -0,0 +1,83 @@
+package doctype
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		doc      Document
+		expected string
+	}{
+		{Document{PartNum: "MP-01234-00", Title: "KA630 CPU Module"}, "print-set"},
+		{Document{AltPartNums: []string{"mp-01234-00"}}, "print-set"},
+		{Document{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Field Maintenance Print Set"}, "print-set"},
+		{Document{Title: "VT100 Schematics"}, "schematic"},
+		{Document{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual"}, "manual"},
+		{Document{Title: "DECmate II Brochure"}, "brochure"},
+		{Document{Title: "Microcomputer Handbook"}, "handbook"},
+		{Document{Title: "RX50 Data Sheet"}, "datasheet"},
+		{Document{Title: "Guidelines for Typesetting"}, ""}, // Keywords are whole words
+		{Document{Title: "VAX Field Guide"}, "manual"},
+		{Document{}, ""},
+	} {
+		if docType := DefaultRules.Classify(test.doc); docType != test.expected {
+			t.Errorf(`Classify(%+v) = %q, expected %q`, test.doc, docType, test.expected)
+		}
+	}
+	// A rule limited to some formats is passed over for others
+	rules := Rules{{Type: "listing", Keywords: []string{"sources"}, Formats: []string{"TXT"}}}
+	if docType := rules.Classify(Document{Title: "RT-11 Sources", Format: "PDF"}); docType != "" {
+		t.Errorf(`Classify() of a PDF = %q`, docType)
+	}
+	if docType := rules.Classify(Document{Title: "RT-11 Sources", Format: "TXT"}); docType != "listing" {
+		t.Errorf(`Classify() of a TXT = %q`, docType)
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := Document{Title: "VT100 Schematics"}
+	if !Apply(&doc, DefaultRules, false) || (doc.DocType != "schematic") || (doc.Flags != "K") {
+		t.Errorf(`Apply() gave %+v`, doc)
+	}
+	if Apply(&doc, DefaultRules, false) {
+		t.Errorf(`Apply() again reported a change`)
+	}
+	// A type given by hand is kept unless forced
+	doc = Document{Title: "VT100 Schematics", DocType: "print-set"}
+	if Apply(&doc, DefaultRules, false) || (doc.DocType != "print-set") {
+		t.Errorf(`Apply() changed a type given by hand: %+v`, doc)
+	}
+	if !Apply(&doc, DefaultRules, true) || (doc.DocType != "schematic") {
+		t.Errorf(`Apply() with force gave %+v`, doc)
+	}
+	// A type the rules no longer give is cleared
+	doc = Document{Title: "Untitled", DocType: "manual", Flags: "TK"}
+	if !Apply(&doc, DefaultRules, false) || (doc.DocType != "") || (doc.Flags != "T") {
+		t.Errorf(`Apply() of an unmatched document gave %+v`, doc)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if rules, err := Load(""); (err != nil) || (len(rules) != len(DefaultRules)) {
+		t.Errorf(`Load("") = %v, %v`, rules, err)
+	}
+	filename := filepath.Join(t.TempDir(), "doctypes.yaml")
+	if err := os.WriteFile(filename, []byte("- type: print-set\n  partnumprefixes: [MP-]\n- type: datasheet\n  keywords: [data sheet]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Load(filename)
+	if (err != nil) || (len(rules) != 2) || (rules[1].Keywords[0] != "data sheet") {
+		t.Errorf(`Load() = %+v, %v`, rules, err)
+	}
+	for _, bad := range []string{"- type: Print Set\n  keywords: [print set]\n", "- type: manual\n"} {
+		if err := os.WriteFile(filename, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(filename); err == nil {
+			t.Errorf(`Load() accepted %q`, bad)
+		}
+	}
+}
@@ -1,35 +1,68 @@
 package document
 
 import (
+	"bytes"
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/textnorm"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // The Document struct is how per-electronic-document data is represented in YAML
 type Document struct {
-	Format      string // File format (PDF, TXT, etc.)
-	Size        int64  // File size in bytes
-	Md5         string // File MD5 checksum
-	Title       string // Document title
-	PubDate     string // The publication date
-	PartNum     string // The manufacturer identifier or part number for the document
-	PdfCreator  string // PDF data: "Creator"
-	PdfProducer string // PDF data: "Producer"
-	PdfVersion  string // PDF data: "Format", this will be, for example, "PDF-1.2"
-	PdfModified string // PDF data: "Modified"
-	Collection  string // Name of collection that ostensibly initially supplied the document; "local" indicates locally scanned
-	Filepath    string // Relative file path of document in collection
-	PublicUrl   string // Public repository hosting the document; not necessarily originator of the docuemnt
-	Flags       string // "P": part num set by code, "T": title set by code, "D": PubDate set by code
+	Format         string            // File format (PDF, TXT, etc.)
+	Size           int64             // File size in bytes
+	Md5            string            // File MD5 checksum
+	Sha256         string            `yaml:",omitempty"` // File SHA-256 checksum, if recorded (see --sha256 of the generator tools)
+	Title          string            // Document title
+	TitleSource    string            `yaml:",omitempty"` // "runoff" if Title was read from the document's RUNOFF directives (see internal/runoff)
+	PubDate        string            // The publication date
+	DateSource     string            `yaml:",omitempty"` // "inferred" if PubDate was inferred from the document's first page (see internal/pubdate)
+	Confidence     float64           `yaml:",omitempty"` // How sure the inferred PubDate is, from 0 to 1
+	PartNum        string            // The manufacturer identifier or part number for the document
+	AltPartNums    []string          `yaml:",omitempty"` // Other part numbers for the same document (e.g. both an order number and a document number)
+	PdfCreator     string            // PDF data: "Creator"
+	PdfProducer    string            // PDF data: "Producer"
+	PdfVersion     string            // PDF data: "Format", this will be, for example, "PDF-1.2"
+	PdfModified    string            // PDF data: "Modified"
+	Pages          int               `yaml:",omitempty"` // Set by score-catalog: the page count (see internal/quality)
+	Dpi            int               `yaml:",omitempty"` // Set by score-catalog: the resolution of the page images, in dots per inch
+	Encryption     string            `yaml:",omitempty"` // "password" if the file cannot be opened without a password, "restricted" if it is encrypted only to restrict printing, copying etc.
+	Collection     string            // Name of collection that ostensibly initially supplied the document; "local" indicates locally scanned
+	Filepath       string            // Relative file path of document in collection
+	PublicUrl      string            // Public repository hosting the document; not necessarily originator of the docuemnt
+	Flags          string            // "P": part num set by code, "T": title set by code, "D": PubDate set by code, "K": DocType set by code
+	DocType        string            `yaml:",omitempty"` // The kind of document, e.g. "manual", "print-set", "datasheet" (see internal/doctype)
+	Section        string            `yaml:",omitempty"` // Section of the collection the document was listed in (e.g. VaxHaven "Hardware"), if known
+	ProcessingNote string            `yaml:",omitempty"` // Free-form remark about how the document was processed (e.g. why it was force-included); set by the tools, unlike Notes
+	Tags           []string          `yaml:",omitempty"` // Arbitrary user labels (e.g. "needs-rescan", "rare"), kept sorted
+	Notes          string            `yaml:",omitempty"` // Free-form user annotations (e.g. "page 37 missing", provenance); never set by the tools
+	OcrStatus      string            `yaml:",omitempty"` // Set by ocr-queue: "not-needed" (has a text layer), "pending", "done" or "failed"
+	OcrOutput      string            `yaml:",omitempty"` // Set by ocr-queue: filepath of the OCRed copy of the document
+	PdfA           string            `yaml:",omitempty"` // Set by pdfa-check: the PDF/A level claimed or validated (e.g. "valid-1b"), "convertible" or "none" (see internal/pdfa)
+	Quality        int               `yaml:",omitempty"` // Set by score-catalog: how good this copy is, from 0 to 100, for ranking the copies of a publication (see internal/quality)
+	VolumeID       string            `yaml:",omitempty"` // The archive volume holding the document (see internal/volumes), if known
+	Location       string            `yaml:",omitempty"` // Where a physical copy (e.g. the paper original) is kept; never set by the tools
+	Visibility     string            `yaml:",omitempty"` // Who may see the document: "public" (the default), "restricted" or "private" (see internal/visibility); never set by the tools
+	Provenance     []string          `yaml:",omitempty"` // How the document was chosen over others with the same MD5 checksum (see internal/retention)
+	Copies         []FileLocation    `yaml:",omitempty"` // Every place a copy of the file is held, including Filepath and PublicUrl (see FileLocation)
+	AltFilepaths   []string          `yaml:",omitempty"` // Read from catalogs written before Copies existed (by build-master) and moved into Copies as they are loaded; never written
+	Fingerprint    string            `yaml:",omitempty"` // Set by fingerprint-catalog: the MD5 checksum of the first and last blocks of the file, and its size (see internal/hashing)
+	Redirects      map[string]string `yaml:",omitempty"` // URLs of the document that permanently redirect, each mapped to the URL it redirects to (see AddRedirects)
+	Origin         string            `yaml:",omitempty"` // The reference catalog a read-only document was taken from (see MarkReadOnly)
 }
 
 // Determine the file format. This will be TXT, PDF, RNO etc.
@@ -39,7 +72,7 @@ type Document struct {
 // package.
 // Note that "HTM" will be returned as "HTML": both types exist in the collection but it makes no sense to allow both!
 // Similarly "JPG" will be returned as "JPEG".
-var KnownFileTypes = [...]string{"PDF", "TXT", "MEM", "RNO", "PS", "HTM", "HTML", "ZIP", "LN3", "TIF", "JPG", "JPEG", "PNG", "DOC"}
+var KnownFileTypes = [...]string{"PDF", "TXT", "MEM", "RNO", "PS", "HTM", "HTML", "ZIP", "LN3", "TIF", "JPG", "JPEG", "PNG", "DOC", "DOCX", "ODT"}
 
 // Sometimes the same file structure may be indicated by multiple filetypes, for
 // example HTML files may be ".HTM" or ".HTML", the JPEG file format might be ".JPEG" or ".JPG"
@@ -108,10 +141,19 @@ func DetermineDocumentPropertiesFromPath(path string, verbose bool) Document {
 	}
 
 	// If the final decision is that a valid part number has been found, record it in the Document and remove it from the title.
+	// Any further part numbers that immediately follow (e.g. an order number and a document number) are recorded as aliases.
 	// Otherwise the title (so far) is the whole original filename.
 	if partNumFound {
 		title = filename[len(partNum)+1:]
 		doc.PartNum = partNum
+		for {
+			altPartNum, rest, found := strings.Cut(title, "_")
+			if !found || !ValidateDecPartNumber(altPartNum) {
+				break
+			}
+			AddAltPartNums(&doc, altPartNum)
+			title = rest
+		}
 	} else {
 		title = filename
 		if verbose {
@@ -170,7 +212,7 @@ func ValidateDecPartNumber(partNumber string) bool {
 	pn := strings.ToUpper(partNumber)
 	match, err := regexp.MatchString(`^[[:alnum:]]{2}-[\/[:alnum:]]{4,5}(-|\.)[[:alnum:]]{2}((-|.)[[:alnum:]]{2,4})?$`, pn)
 	if err != nil {
-		log.Fatal("EK-NNNNN-JJ regexp faulty")
+		exitcode.Fatal("EK-NNNNN-JJ regexp faulty")
 	}
 	if match {
 		return true
@@ -178,7 +220,7 @@ func ValidateDecPartNumber(partNumber string) bool {
 
 	match, err = regexp.MatchString(`^DEC-[[:alnum:]]{2}-[[:alnum:]]{4,5}-[[:alnum:]](-[[:alnum:]])?$`, pn)
 	if err != nil {
-		log.Fatal("DEC-11-AAAAA-B-D regexp faulty")
+		exitcode.Fatal("DEC-11-AAAAA-B-D regexp faulty")
 	}
 	if match {
 		return true
@@ -186,7 +228,7 @@ func ValidateDecPartNumber(partNumber string) bool {
 
 	match, err = regexp.MatchString(`^MAINDEC-[[:alnum:]]{2}-[[:alnum:]]{4}-[[:alnum:]]$`, pn)
 	if err != nil {
-		log.Fatal("MAINDEC-08-AAAAA-B-D regexp faulty")
+		exitcode.Fatal("MAINDEC-08-AAAAA-B-D regexp faulty")
 	}
 	if match {
 		return true
@@ -194,7 +236,7 @@ func ValidateDecPartNumber(partNumber string) bool {
 
 	match, err = regexp.MatchString(`^K-MN-[[:alnum:]]{6}-[[:alnum:]]{2}-[[:alnum:]]{4}(-|.)[[:alnum:]]{3}$`, pn)
 	if err != nil {
-		log.Fatal("K-MN-AS8X00-00-JG00.A06 regexp faulty")
+		exitcode.Fatal("K-MN-AS8X00-00-JG00.A06 regexp faulty")
 	}
 	if match {
 		return true
@@ -202,7 +244,7 @@ func ValidateDecPartNumber(partNumber string) bool {
 
 	match, err = regexp.MatchString(`^MP(-)?[[:digit:]]{5}(-[[:digit:]]{2})?$`, pn)
 	if err != nil {
-		log.Fatal("MP printset regexp faulty")
+		exitcode.Fatal("MP printset regexp faulty")
 	}
 	if match {
 		return true
@@ -269,7 +311,24 @@ func ValidateDate(date string) string {
 	return ""
 }
 
-var knownFlags = "PTD"
+// The values of Document.Encryption
+const (
+	EncryptionPassword   = "password"   // The file cannot be opened without a password
+	EncryptionRestricted = "restricted" // The file opens without a password, but printing, copying etc. may be restricted
+)
+
+var knownFlags = "PTDK"
+
+// The flag that records that each Document field was set by code rather than by a person
+var CodeSetFlags = map[string]string{"Title": "T", "PartNum": "P", "PubDate": "D", "DocType": "K"}
+
+// The publication dates held in catalogs: YYYY, YYYY-MM or YYYY-MM-DD
+var pubDateRegexp = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// Reports whether a publication date has one of the forms held in catalogs (YYYY, YYYY-MM or YYYY-MM-DD)
+func IsPubDate(date string) bool {
+	return pubDateRegexp.MatchString(date)
+}
 
 // Set a flag in the Document.Flags field.
 // Unrecognised flags are ignored.
@@ -300,6 +359,148 @@ func ClearFlags(doc *Document, flags string) {
 	}
 }
 
+// Normalise a part number so that variants of the same publication can be matched: "EK-KA630-TM.001" and
+// "ek-ka630-tm001" both become "EKKA630TM001". Hyphens, dots and white space are removed and letters are upper-cased.
+func NormalisePartNumber(partNum string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r == '-') || (r == '.') || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, partNum))
+}
+
+// Returns all the part numbers of the Document: the part number (if any) followed by any aliases
+func PartNumbers(doc Document) []string {
+	var partNums []string
+	if doc.PartNum != "" {
+		partNums = append(partNums, doc.PartNum)
+	}
+	return append(partNums, doc.AltPartNums...)
+}
+
+// Add alternate part numbers to the Document.AltPartNums field, ignoring blanks and any that (once normalised)
+// duplicate the part number or an existing alias. Returns true if any alias was added.
+func AddAltPartNums(doc *Document, partNums ...string) bool {
+	changed := false
+	for _, partNum := range partNums {
+		partNum = strings.TrimSpace(partNum)
+		normalised := NormalisePartNumber(partNum)
+		if normalised == "" {
+			continue
+		}
+		known := false
+		for _, existing := range PartNumbers(*doc) {
+			if NormalisePartNumber(existing) == normalised {
+				known = true
+			}
+		}
+		if !known {
+			doc.AltPartNums = append(doc.AltPartNums, partNum)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Reports whether the Document has the specified tag
+func HasTag(doc Document, tag string) bool {
+	for _, t := range doc.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Add tags to the Document.Tags field, keeping it sorted and free of duplicates.
+// Blank tags are ignored. Returns true if any tag was added.
+func AddTags(doc *Document, tags ...string) bool {
+	changed := false
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if (tag != "") && !HasTag(*doc, tag) {
+			doc.Tags = append(doc.Tags, tag)
+			changed = true
+		}
+	}
+	sort.Strings(doc.Tags)
+	return changed
+}
+
+// Remove tags from the Document.Tags field. Returns true if any tag was removed.
+func RemoveTags(doc *Document, tags ...string) bool {
+	var kept []string
+	for _, t := range doc.Tags {
+		remove := false
+		for _, tag := range tags {
+			if t == strings.TrimSpace(tag) {
+				remove = true
+			}
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	changed := len(kept) != len(doc.Tags)
+	doc.Tags = kept
+	return changed
+}
+
+// Marks a Document as read-only, taken from the named reference catalog (e.g. someone else's bitsavers catalog).
+// A merge may keep or drop a read-only document, but never changes it: its fields, provenance and locations stay as
+// its origin recorded them. A document that is already read-only keeps its original Origin.
+func MarkReadOnly(doc *Document, origin string) {
+	if doc.Origin == "" {
+		doc.Origin = origin
+	}
+}
+
+// Reports whether a Document is read-only (see MarkReadOnly)
+func IsReadOnly(doc Document) bool {
+	return doc.Origin != ""
+}
+
+// Append a line to the Document.Notes field, unless the notes already contain it.
+// Returns true if the notes changed.
+func AppendNotes(doc *Document, notes string) bool {
+	notes = strings.TrimSpace(notes)
+	if (notes == "") || slices.Contains(strings.Split(doc.Notes, "\n"), notes) {
+		return false
+	}
+	if doc.Notes != "" {
+		doc.Notes += "\n"
+	}
+	doc.Notes += notes
+	return true
+}
+
+// Normalises the descriptive text of a Document (title, part numbers, notes and so on) by composing its accented
+// letters (see textnorm.ComposeLatin), so that the same title matches however its accents were encoded by its source.
+// Filepaths and URLs are left alone, as they must continue to name the file exactly.
+func NormaliseText(doc *Document) {
+	for _, field := range []*string{&doc.Title, &doc.PubDate, &doc.PartNum, &doc.PdfCreator, &doc.PdfProducer, &doc.Collection, &doc.Section, &doc.ProcessingNote, &doc.Notes} {
+		*field = textnorm.ComposeLatin(*field)
+	}
+	for _, list := range [][]string{doc.AltPartNums, doc.Tags} {
+		for i := range list {
+			list[i] = textnorm.ComposeLatin(list[i])
+		}
+	}
+}
+
+// Returns a copy of a map of Documents with the text of every Document normalised (see NormaliseText)
+func normalisedDocuments(documentsMap map[string]Document) map[string]Document {
+	normalised := make(map[string]Document, len(documentsMap))
+	for key, doc := range documentsMap {
+		doc.AltPartNums = slices.Clone(doc.AltPartNums)
+		doc.Tags = slices.Clone(doc.Tags)
+		NormaliseText(&doc)
+		normalised[key] = doc
+	}
+	return normalised
+}
+
 // Generate a string suitable for comparing one Document object with another
 func ComparisonString(doc Document) string {
 	// (documentsMap[keys[i]].Collection + documentsMap[keys[i]].Title + documentsMap[keys[i]].PartNum + strconv.FormatInt(documentsMap[keys[i]].Size, 10) + documentsMap[keys[i]].Filepath)
@@ -309,41 +510,134 @@ func ComparisonString(doc Document) string {
 	return key
 }
 
+// All YAML written by the tools in this repository uses the same indentation.
+// yaml.v2 (which was used by older versions of these tools) always indented by two spaces,
+// so that is retained here to avoid spurious differences between old and new catalogs.
+const YamlIndent = 2
+
+// Marshal a value into YAML using the canonical formatting options.
+// Every tool should write YAML via this function (or WriteDocumentsMapToOrderedYaml)
+// so that all catalogs and stores are formatted identically.
+// Unlike yaml.v2, long strings are never folded across multiple lines.
+func MarshalYaml(in interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(YamlIndent)
+	err := encoder.Encode(in)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal YAML data into the supplied value.
+// This accepts YAML written by both the current and older (yaml.v2 based) versions of the tools.
+func UnmarshalYaml(data []byte, out interface{}) error {
+	return yaml.Unmarshal(data, out)
+}
+
 // Takes a map of Documents (indexed by MD5 or similar) and writes
 // out an ordered set of Docuemnt entries in YAML format.
-// The order is determined by Document.ComparisonString.
+// The order is determined by Document.ComparisonString, in the collation order (see internal/collate).
+// The text of every Document is written normalised (see NormaliseText); the map itself is not changed.
 
 func WriteDocumentsMapToOrderedYaml(documentsMap map[string]Document, outputFilename string) error {
-	var err error
+	data, err := MarshalOrderedYaml(documentsMap)
+	if err != nil {
+		return err
+	}
+
+	// Write atomically so that an interrupted run never leaves a truncated YAML file behind
+	return fsutil.WriteFileAtomic(outputFilename, data, 0644)
+}
+
+// Returns the YAML that WriteDocumentsMapToOrderedYaml would write for a map of Documents
+func MarshalOrderedYaml(documentsMap map[string]Document) ([]byte, error) {
+	documentsMap = normalisedDocuments(documentsMap)
 
 	// Try to write out the YAML in alphabetical order by title.
 	// Do this by ordering the keys according to the title alphabetical order and
 	// then for each key (in order) marshalling a map with just that key and its Document.
+	// Marhsall each Document entry, one at a time
+	var data []byte
+	for _, key := range OrderedKeys(documentsMap) {
+		var oneMap map[string]Document = make(map[string]Document)
+		oneMap[key] = documentsMap[key]
+		entry, err := MarshalYaml(oneMap)
+		if err != nil {
+			return nil, fmt.Errorf("bad YAML data for %s: %w", key, err)
+		}
+		data = append(data, entry...)
+	}
+	return data, nil
+}
+
+// Returns the keys of a map of Documents in the order in which they are written, i.e. by Document.ComparisonString in
+// the collation order (see internal/collate), and then by key
+func OrderedKeys(documentsMap map[string]Document) []string {
 	var keys []string
-	for key := range documentsMap {
+	collationKeys := make(map[string]string, len(documentsMap))
+	for key, doc := range documentsMap {
 		keys = append(keys, key)
+		collationKeys[key] = collate.Default().Key(ComparisonString(doc))
 	}
-
 	sort.Slice(keys, func(i, j int) bool {
-		return ComparisonString(documentsMap[keys[i]]) < ComparisonString(documentsMap[keys[j]])
+		if collationKeys[keys[i]] != collationKeys[keys[j]] {
+			return collationKeys[keys[i]] < collationKeys[keys[j]]
+		}
+		return keys[i] < keys[j]
 	})
+	return keys
+}
 
-	// Marhsall each Document entry, one at a time
+// Takes a map of Documents and writes it out as JSON Lines (NDJSON): one JSON object per Document, in the same order
+// as WriteDocumentsMapToOrderedYaml. Each object starts with an "id" field holding the Document's key, followed by
+// the Document's fields under the names (and with the omissions) used in the YAML.
+func WriteDocumentsMapToOrderedJsonl(documentsMap map[string]Document, outputFilename string) error {
 	var data []byte
-	for _, key := range keys {
-		var oneMap map[string]Document = make(map[string]Document)
-		oneMap[key] = documentsMap[key]
-		entry, err := yaml.Marshal(&oneMap)
+	documentsMap = normalisedDocuments(documentsMap)
+	for _, key := range OrderedKeys(documentsMap) {
+		line, err := MarshalJsonLine(key, documentsMap[key])
 		if err != nil {
-			log.Fatal("Bad YAML data 2: ", err)
+			return fmt.Errorf("bad JSON data for %s: %w", key, err)
 		}
-		data = append(data, entry...)
+		data = append(data, line...)
 	}
+	return fsutil.WriteFileAtomic(outputFilename, data, 0644)
+}
 
-	err = os.WriteFile(outputFilename, data, 0644)
+// Marshals one Document (with its key as "id") into a single line of JSON, terminated by a newline.
+// The fields are written in struct order, named as in the YAML (the lower-cased field name, unless the yaml tag
+// says otherwise), and those tagged omitempty are left out when empty, so the JSON mirrors the YAML exactly.
+func MarshalJsonLine(key string, doc Document) ([]byte, error) {
+	var line bytes.Buffer
+	id, err := json.Marshal(key)
 	if err != nil {
-		log.Fatal("Failed YAML write: ", err)
+		return nil, err
 	}
-
-	return nil
+	line.WriteString(`{"id":`)
+	line.Write(id)
+	value := reflect.ValueOf(doc)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if strings.Contains(options, "omitempty") && value.Field(i).IsZero() {
+			continue
+		}
+		fieldValue, err := json.Marshal(value.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		line.WriteString(`,"` + name + `":`)
+		line.Write(fieldValue)
+	}
+	line.WriteString("}\n")
+	return line.Bytes(), nil
 }
@@ -1,6 +1,11 @@
 package document
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -125,6 +130,14 @@ func TestValidateDate(t *testing.T) {
 	}
 }
 
+func TestIsPubDate(t *testing.T) {
+	for date, expected := range map[string]bool{"1987": true, "1987-01": true, "1987-01-31": true, "Jan87": false, "198701": false, "1987-1": false, "": false} {
+		if IsPubDate(date) != expected {
+			t.Errorf(`IsPubDate(%q) should have returned %t`, date, expected)
+		}
+	}
+}
+
 func TestSetFlags(t *testing.T) {
 	var doc Document
 	doc.Flags = ""
@@ -175,3 +188,245 @@ func TestClearFlags(t *testing.T) {
 		t.Fatalf(`with doc.Flags = "PTD", document.ClearFlags(doc, "PD") returned flags: %s but should have been T`, doc.Flags)
 	}
 }
+
+func TestTags(t *testing.T) {
+	var doc Document
+
+	if !AddTags(&doc, "rare", "needs-rescan", " ", "rare") {
+		t.Fatalf(`AddTags() reported no change`)
+	}
+	if !reflect.DeepEqual(doc.Tags, []string{"needs-rescan", "rare"}) {
+		t.Fatalf(`AddTags() gave %q`, doc.Tags)
+	}
+	if AddTags(&doc, "rare") {
+		t.Errorf(`AddTags() of an existing tag reported a change`)
+	}
+	if !HasTag(doc, "rare") || HasTag(doc, "loaned-out") {
+		t.Errorf(`HasTag() wrong for %q`, doc.Tags)
+	}
+
+	if RemoveTags(&doc, "loaned-out") {
+		t.Errorf(`RemoveTags() of a missing tag reported a change`)
+	}
+	if !RemoveTags(&doc, "rare", "needs-rescan") || (len(doc.Tags) != 0) {
+		t.Errorf(`RemoveTags() left %q`, doc.Tags)
+	}
+}
+
+// YAML as written by older versions of the tools, which used yaml.v2.
+// Note that yaml.v2 folded long strings over multiple lines.
+var yamlV2Catalog = `0123456789abcdef0123456789abcdef:
+  format: PDF
+  size: 123456
+  md5: 0123456789abcdef0123456789abcdef
+  title: A very long title that goes on and on and on and on and on and on and on
+    and on forever
+  pubdate: 1983-03
+  partnum: EK-ABCDE-AA-001
+  pdfcreator: ""
+  pdfproducer: ""
+  pdfversion: "1.4"
+  pdfmodified: 2001:01:01 10:00:00+01:00
+  collection: local:DEC_0001
+  filepath: file:///DEC_0001/manuals/ek-abcde-aa-001.pdf
+  publicurl: ""
+  flags: "on"
+`
+
+func TestUnmarshalYamlFromOlderVersions(t *testing.T) {
+	documentsMap := make(map[string]Document)
+	err := UnmarshalYaml([]byte(yamlV2Catalog), &documentsMap)
+	if err != nil {
+		t.Fatalf(`UnmarshalYaml(yamlV2Catalog) failed: %v`, err)
+	}
+
+	doc, found := documentsMap["0123456789abcdef0123456789abcdef"]
+	if !found {
+		t.Fatalf(`UnmarshalYaml(yamlV2Catalog) did not produce the expected key: %#v`, documentsMap)
+	}
+	if (doc.Title != "A very long title that goes on and on and on and on and on and on and on and on forever") || (doc.Size != 123456) || (doc.PdfVersion != "1.4") || (doc.Flags != "on") {
+		t.Fatalf(`UnmarshalYaml(yamlV2Catalog) produced unexpected document: %#v`, doc)
+	}
+}
+
+func TestMarshalYamlRoundTrip(t *testing.T) {
+	documentsMap := make(map[string]Document)
+	err := UnmarshalYaml([]byte(yamlV2Catalog), &documentsMap)
+	if err != nil {
+		t.Fatalf(`UnmarshalYaml(yamlV2Catalog) failed: %v`, err)
+	}
+
+	data, err := MarshalYaml(documentsMap)
+	if err != nil {
+		t.Fatalf(`MarshalYaml() failed: %v`, err)
+	}
+
+	// The only expected difference from the yaml.v2 output is that the long title is no longer folded
+	expected := strings.Replace(yamlV2Catalog, "and on\n    and on", "and on and on", 1)
+	if string(data) != expected {
+		t.Fatalf("MarshalYaml() produced:\n%s\nbut expected:\n%s", data, expected)
+	}
+
+	roundTripMap := make(map[string]Document)
+	err = UnmarshalYaml(data, &roundTripMap)
+	if err != nil {
+		t.Fatalf(`UnmarshalYaml() of MarshalYaml() output failed: %v`, err)
+	}
+	if !reflect.DeepEqual(documentsMap, roundTripMap) {
+		t.Fatalf(`round trip mismatch: %#v != %#v`, documentsMap, roundTripMap)
+	}
+}
+
+func TestWriteDocumentsMapToOrderedYaml(t *testing.T) {
+	documentsMap := make(map[string]Document)
+	err := UnmarshalYaml([]byte(yamlV2Catalog), &documentsMap)
+	if err != nil {
+		t.Fatalf(`UnmarshalYaml(yamlV2Catalog) failed: %v`, err)
+	}
+
+	outputFilename := filepath.Join(t.TempDir(), "catalog.yaml")
+	err = WriteDocumentsMapToOrderedYaml(documentsMap, outputFilename)
+	if err != nil {
+		t.Fatalf(`WriteDocumentsMapToOrderedYaml() failed: %v`, err)
+	}
+
+	data, err := os.ReadFile(outputFilename)
+	if err != nil {
+		t.Fatalf(`cannot read %s: %v`, outputFilename, err)
+	}
+	roundTripMap := make(map[string]Document)
+	err = UnmarshalYaml(data, &roundTripMap)
+	if err != nil {
+		t.Fatalf(`UnmarshalYaml() of written catalog failed: %v`, err)
+	}
+	if !reflect.DeepEqual(documentsMap, roundTripMap) {
+		t.Fatalf(`round trip mismatch: %#v != %#v`, documentsMap, roundTripMap)
+	}
+}
+
+func TestNormaliseText(t *testing.T) {
+	// "Mémoire" written with a combining accent, in a file whose name has the same decomposed form
+	decomposed := "Me\u0301moire"
+	documentsMap := map[string]Document{"k": {Title: decomposed, Tags: []string{decomposed}, Filepath: decomposed + ".pdf"}}
+	outputFilename := filepath.Join(t.TempDir(), "catalog.yaml")
+	if err := WriteDocumentsMapToOrderedYaml(documentsMap, outputFilename); err != nil {
+		t.Fatalf(`WriteDocumentsMapToOrderedYaml() failed: %v`, err)
+	}
+	if documentsMap["k"].Tags[0] != decomposed {
+		t.Errorf(`WriteDocumentsMapToOrderedYaml() changed its input: %+v`, documentsMap["k"])
+	}
+	data, _ := os.ReadFile(outputFilename)
+	written := make(map[string]Document)
+	UnmarshalYaml(data, &written)
+	if doc := written["k"]; (doc.Title != "M\u00e9moire") || (doc.Tags[0] != "M\u00e9moire") || (doc.Filepath != decomposed+".pdf") {
+		t.Errorf(`WriteDocumentsMapToOrderedYaml() wrote %+v`, doc)
+	}
+}
+
+func TestAppendNotes(t *testing.T) {
+	var doc Document
+	if !AppendNotes(&doc, " page 37 missing ") || (doc.Notes != "page 37 missing") {
+		t.Fatalf(`AppendNotes() gave %q`, doc.Notes)
+	}
+	if AppendNotes(&doc, "page 37 missing") || AppendNotes(&doc, "") {
+		t.Errorf(`AppendNotes() of an existing or blank line reported a change`)
+	}
+	if !AppendNotes(&doc, "scanned from a loaned copy") || (doc.Notes != "page 37 missing\nscanned from a loaned copy") {
+		t.Errorf(`AppendNotes() gave %q`, doc.Notes)
+	}
+}
+
+func TestNormalisePartNumber(t *testing.T) {
+	tests := map[string]string{"EK-KA630-TM.001": "EKKA630TM001", "ek-ka630-tm001": "EKKA630TM001", " AA 0196C TK ": "AA0196CTK", "": ""}
+	for partNum, expected := range tests {
+		if result := NormalisePartNumber(partNum); result != expected {
+			t.Errorf(`NormalisePartNumber(%q) = %q, expected %q`, partNum, result, expected)
+		}
+	}
+}
+
+func TestAltPartNums(t *testing.T) {
+	doc := Document{PartNum: "EK-KA630-TM"}
+	if !AddAltPartNums(&doc, "AA-0196C-TK", " ", "ek-ka630-tm", "aa0196ctk") || !reflect.DeepEqual(doc.AltPartNums, []string{"AA-0196C-TK"}) {
+		t.Errorf(`AddAltPartNums() gave %v`, doc.AltPartNums)
+	}
+	if AddAltPartNums(&doc, "AA-0196C-TK") {
+		t.Errorf(`AddAltPartNums() of an existing alias reported a change`)
+	}
+	if partNums := PartNumbers(doc); !reflect.DeepEqual(partNums, []string{"EK-KA630-TM", "AA-0196C-TK"}) {
+		t.Errorf(`PartNumbers() = %v`, partNums)
+	}
+
+	path := "/path/path/EK-ABCDE-AA-001_AA-0196C-TK_Title_Text.pdf"
+	doc = DetermineDocumentPropertiesFromPath(path, false)
+	if (doc.PartNum != "EK-ABCDE-AA-001") || !reflect.DeepEqual(doc.AltPartNums, []string{"AA-0196C-TK"}) || (doc.Title != "Title Text") {
+		t.Errorf(`DetermineDocumentPropertiesFromPath(%s) gave PN=%s aliases=%v Title=%s`, path, doc.PartNum, doc.AltPartNums, doc.Title)
+	}
+}
+
+func TestMarshalJsonLine(t *testing.T) {
+	doc := Document{Format: "PDF", Size: 1234, Md5: "0123456789abcdef0123456789abcdef", Title: `VAX "Blue" Book`, PartNum: "EK-VAXAR-HB-001",
+		AltPartNums: []string{"EB-12345-20"}, Collection: "local", Filepath: "file:///DEC_0001/vax/ek-vaxar-hb.pdf"}
+	line, err := MarshalJsonLine("0123456789abcdef0123456789abcdef", doc)
+	if err != nil {
+		t.Fatalf(`MarshalJsonLine() failed: %v`, err)
+	}
+	expected := `{"id":"0123456789abcdef0123456789abcdef","format":"PDF","size":1234,"md5":"0123456789abcdef0123456789abcdef",` +
+		`"title":"VAX \"Blue\" Book","pubdate":"","partnum":"EK-VAXAR-HB-001","altpartnums":["EB-12345-20"],"pdfcreator":"",` +
+		`"pdfproducer":"","pdfversion":"","pdfmodified":"","collection":"local","filepath":"file:///DEC_0001/vax/ek-vaxar-hb.pdf",` +
+		`"publicurl":"","flags":""}` + "\n"
+	if string(line) != expected {
+		t.Fatalf("MarshalJsonLine() produced:\n%s\nbut expected:\n%s", line, expected)
+	}
+
+	// Every line of the file is a JSON object, in the same order as the YAML
+	documentsMap := map[string]Document{"b": {Title: "Beta"}, "a": {Title: "Alpha"}}
+	outputFilename := filepath.Join(t.TempDir(), "catalog.jsonl")
+	if err := WriteDocumentsMapToOrderedJsonl(documentsMap, outputFilename); err != nil {
+		t.Fatalf(`WriteDocumentsMapToOrderedJsonl() failed: %v`, err)
+	}
+	data, err := os.ReadFile(outputFilename)
+	if err != nil {
+		t.Fatalf(`cannot read %s: %v`, outputFilename, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if (len(lines) != 2) || !strings.HasPrefix(lines[0], `{"id":"a",`) || !strings.HasPrefix(lines[1], `{"id":"b",`) {
+		t.Fatalf(`WriteDocumentsMapToOrderedJsonl() wrote:\n%s`, data)
+	}
+}
+
+// Builds a catalog of the specified number of documents, shaped like the output of local-archive-to-yaml
+func syntheticCatalog(size int) map[string]Document {
+	documentsMap := make(map[string]Document, size)
+	for i := 0; i < size; i++ {
+		doc := Document{Format: "PDF", Size: int64(100000 + i), Md5: fmt.Sprintf("%032x", i), Title: fmt.Sprintf("VAX Document %d", i),
+			PubDate: "1985-06", PartNum: fmt.Sprintf("EK-%05d-UG-001", i), PdfCreator: "Acrobat 4.0", PdfProducer: "Acrobat Distiller",
+			PdfVersion: "PDF-1.3", Collection: "local", Filepath: fmt.Sprintf("file:///DEC_%04d/vax/doc%05d.pdf", i/500, i)}
+		documentsMap[doc.Md5] = doc
+	}
+	return documentsMap
+}
+
+func BenchmarkMarshalYaml(b *testing.B) {
+	documentsMap := syntheticCatalog(5000)
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalYaml(documentsMap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalYaml(b *testing.B) {
+	data, err := MarshalYaml(syntheticCatalog(5000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		documentsMap := make(map[string]Document)
+		if err := UnmarshalYaml(data, &documentsMap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package document
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// A document's file may be held in several places at once: on more than one archive volume, in a tree, and on one or
+// more public sites. Each place is a FileLocation, and Document.Copies lists them all. Filepath remains the
+// document's primary location (the one its catalog was built from) and is always among its Copies.
+//
+// Catalogs written before Copies existed record only Filepath and PublicUrl (and, from build-master, AltFilepaths);
+// NormaliseLocations builds the list from them, so old catalogs load as if they had been written with it.
+
+// The values of FileLocation.Type
+const (
+	LocalLocation  = "local"  // On an archive volume or in a tree
+	RemoteLocation = "remote" // At a URL
+)
+
+// FileLocation is one place a copy of a document's file is held
+type FileLocation struct {
+	Type     string `json:"type"`                                 // LocalLocation or RemoteLocation
+	Url      string `yaml:",omitempty" json:"url,omitempty"`      // The URL of a remote copy
+	Volume   string `yaml:",omitempty" json:"volume,omitempty"`   // The archive volume (e.g. DEC_0001) holding a local copy; blank for a tree
+	Path     string `yaml:",omitempty" json:"path,omitempty"`     // The path of a local copy within its volume or tree
+	Size     int64  `yaml:",omitempty" json:"size,omitempty"`     // The size of the copy in bytes, if known
+	Verified string `yaml:",omitempty" json:"verified,omitempty"` // The date (YYYY-MM-DD) the copy was last found to match the MD5 checksum
+}
+
+// Returns the location named by a catalog filepath: file:///VOLUME/path for a file on an archive volume, a URL for
+// a remote copy, or otherwise a path relative to a tree.
+func LocationOf(filepath string, size int64) FileLocation {
+	if rest, found := strings.CutPrefix(filepath, "file:///"); found {
+		volume, path, _ := strings.Cut(rest, "/")
+		return FileLocation{Type: LocalLocation, Volume: volume, Path: path, Size: size}
+	}
+	if strings.Contains(filepath, "://") {
+		return FileLocation{Type: RemoteLocation, Url: filepath, Size: size}
+	}
+	return FileLocation{Type: LocalLocation, Path: filepath, Size: size}
+}
+
+// Returns the location in the form of a catalog filepath (see LocationOf)
+func (l FileLocation) String() string {
+	switch {
+	case l.Type == RemoteLocation:
+		return l.Url
+	case l.Volume != "":
+		return "file:///" + l.Volume + "/" + l.Path
+	}
+	return l.Path
+}
+
+// Reports whether two locations are the same place, whatever else is recorded about them
+func (l FileLocation) SamePlace(other FileLocation) bool {
+	return (l.Type == other.Type) && (l.Url == other.Url) && (l.Volume == other.Volume) && (l.Path == other.Path)
+}
+
+// Adds locations to the Document.Copies field, in order. A location that is already present is not added again,
+// but the existing entry gains its size (if not known) and its verification date (if later).
+// Blank locations are ignored. Returns true if the locations changed.
+func AddLocations(doc *Document, locations ...FileLocation) bool {
+	changed := false
+	for _, location := range locations {
+		if location.String() == "" {
+			continue
+		}
+		found := false
+		for i := range doc.Copies {
+			existing := &doc.Copies[i]
+			if !existing.SamePlace(location) {
+				continue
+			}
+			found = true
+			if (existing.Size == 0) && (location.Size != 0) {
+				existing.Size = location.Size
+				changed = true
+			}
+			if location.Verified > existing.Verified {
+				existing.Verified = location.Verified
+				changed = true
+			}
+		}
+		if !found {
+			doc.Copies = append(doc.Copies, location)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Records date (YYYY-MM-DD) as the day the copy at filepath (a catalog filepath, see LocationOf) was found to match the
+// document's MD5 checksum. Returns true if the document has a location there whose verification date changed.
+func MarkVerified(doc *Document, filepath string, date string) bool {
+	place := LocationOf(filepath, 0)
+	for i := range doc.Copies {
+		if doc.Copies[i].SamePlace(place) && (doc.Copies[i].Verified != date) {
+			doc.Copies = slices.Clone(doc.Copies)
+			doc.Copies[i].Verified = date
+			return true
+		}
+	}
+	return false
+}
+
+// Makes sure that the document's Filepath and PublicUrl are among its Copies, adding them (Filepath first) if not,
+// and moves any AltFilepaths into them.
+// Generators call this for each document they create, and catalogs are normalised as they are loaded, so that those
+// written before Copies existed gain them. Returns true if the locations changed.
+func NormaliseLocations(doc *Document) bool {
+	changed := false
+	if doc.Filepath != "" {
+		changed = AddLocations(doc, LocationOf(doc.Filepath, doc.Size))
+	}
+	if doc.PublicUrl != "" {
+		// The size describes the file at Filepath, which may not be the same as the one at PublicUrl
+		size := int64(0)
+		if doc.Filepath == "" {
+			size = doc.Size
+		}
+		changed = AddLocations(doc, LocationOf(doc.PublicUrl, size)) || changed
+	}
+	// Older catalogs written by build-master list the copies of combined duplicates in AltFilepaths instead
+	for _, filepath := range doc.AltFilepaths {
+		changed = AddLocations(doc, LocationOf(filepath, doc.Size)) || changed
+	}
+	if doc.AltFilepaths != nil {
+		doc.AltFilepaths = nil
+		changed = true
+	}
+	return changed
+}
+
+// Returns the locations of the document, as catalog filepaths, other than its Filepath
+func OtherLocations(doc Document) []string {
+	var others []string
+	for _, location := range doc.Copies {
+		if filepath := location.String(); (filepath != doc.Filepath) && (filepath != "") {
+			others = append(others, filepath)
+		}
+	}
+	return others
+}
+
+// Adds redirects (each URL mapped to the URL it permanently redirects to) to the Document.Redirects field, and moves the
+// document's URLs (its Filepath, PublicUrl and remote Copies) to the URLs they finally redirect to. Only the
+// redirects that are followed from one of the document's URLs are recorded, so redirects may hold those of many
+// documents. Returns true if the document changed.
+func AddRedirects(doc *Document, redirects map[string]string) bool {
+	known := maps.Clone(doc.Redirects)
+	if known == nil {
+		known = make(map[string]string)
+	}
+	recorded := len(known)
+	follow := func(url string) string {
+		seen := map[string]bool{url: true}
+		for {
+			next, found := known[url]
+			if !found {
+				if next, found = redirects[url]; found {
+					known[url] = next
+				}
+			}
+			if !found || seen[next] {
+				return url
+			}
+			seen[next] = true
+			url = next
+		}
+	}
+
+	changed := false
+	if moved := follow(doc.Filepath); moved != doc.Filepath {
+		doc.Filepath, changed = moved, true
+	}
+	if moved := follow(doc.PublicUrl); moved != doc.PublicUrl {
+		doc.PublicUrl, changed = moved, true
+	}
+	locations := make([]FileLocation, 0, len(doc.Copies))
+	for _, location := range doc.Copies {
+		if location.Type == RemoteLocation {
+			if moved := follow(location.Url); moved != location.Url {
+				location.Url, changed = moved, true
+			}
+		}
+		locations = append(locations, location)
+	}
+	if changed {
+		// Two locations may now be the same place
+		doc.Copies = nil
+		AddLocations(doc, locations...)
+	}
+	if len(known) != recorded {
+		doc.Redirects, changed = known, true
+	}
+	return changed
+}
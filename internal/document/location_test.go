@@ -0,0 +1,117 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocationOf(t *testing.T) {
+	tests := []struct {
+		filepath string
+		expected FileLocation
+	}{
+		{"file:///DEC_0001/vax/ka630.pdf", FileLocation{Type: LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf"}},
+		{"http://bitsavers.org/pdf/dec/vax/ka630.pdf", FileLocation{Type: RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}},
+		{"vax/ka630.pdf", FileLocation{Type: LocalLocation, Path: "vax/ka630.pdf"}},
+	}
+	for _, test := range tests {
+		location := LocationOf(test.filepath, 0)
+		if location != test.expected {
+			t.Errorf(`LocationOf(%q) = %+v, expected %+v`, test.filepath, location, test.expected)
+		}
+		if location.String() != test.filepath {
+			t.Errorf(`LocationOf(%q).String() = %q`, test.filepath, location.String())
+		}
+	}
+}
+
+func TestAddLocations(t *testing.T) {
+	doc := Document{Filepath: "file:///DEC_0001/vax/ka630.pdf", Size: 1234, PublicUrl: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}
+	if !NormaliseLocations(&doc) || NormaliseLocations(&doc) {
+		t.Errorf(`NormaliseLocations() did not add the locations exactly once`)
+	}
+	verified := FileLocation{Type: LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf", Verified: "2024-05-01"}
+	other := FileLocation{Type: LocalLocation, Volume: "DEC_0002", Path: "ka630.pdf", Size: 1234}
+	if !AddLocations(&doc, verified, other, FileLocation{}) || AddLocations(&doc, other) {
+		t.Errorf(`AddLocations() did not add the locations exactly once`)
+	}
+	expected := []FileLocation{
+		{Type: LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf", Size: 1234, Verified: "2024-05-01"},
+		{Type: RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		other,
+	}
+	if !reflect.DeepEqual(doc.Copies, expected) {
+		t.Errorf(`AddLocations() gave %+v`, doc.Copies)
+	}
+	if others := OtherLocations(doc); !reflect.DeepEqual(others, []string{"http://bitsavers.org/pdf/dec/vax/ka630.pdf", "file:///DEC_0002/ka630.pdf"}) {
+		t.Errorf(`OtherLocations() = %q`, others)
+	}
+}
+
+func TestNormaliseAltFilepaths(t *testing.T) {
+	doc := Document{Filepath: "file:///DEC_0001/ka630.pdf", Size: 1234, AltFilepaths: []string{"file:///DEC_0002/ka630.pdf", "file:///DEC_0001/ka630.pdf"}}
+	if !NormaliseLocations(&doc) || NormaliseLocations(&doc) {
+		t.Errorf(`NormaliseLocations() did not move AltFilepaths exactly once`)
+	}
+	expected := []FileLocation{
+		{Type: LocalLocation, Volume: "DEC_0001", Path: "ka630.pdf", Size: 1234},
+		{Type: LocalLocation, Volume: "DEC_0002", Path: "ka630.pdf", Size: 1234},
+	}
+	if !reflect.DeepEqual(doc.Copies, expected) || (doc.AltFilepaths != nil) {
+		t.Errorf(`NormaliseLocations() gave %+v, leaving %q`, doc.Copies, doc.AltFilepaths)
+	}
+}
+
+func TestMarkVerified(t *testing.T) {
+	doc := Document{Filepath: "file:///DEC_0001/vax/ka630.pdf", PublicUrl: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}
+	NormaliseLocations(&doc)
+	original := doc.Copies
+	if !MarkVerified(&doc, "file:///DEC_0001/vax/ka630.pdf", "2024-06-01") || MarkVerified(&doc, "file:///DEC_0001/vax/ka630.pdf", "2024-06-01") {
+		t.Errorf(`MarkVerified() did not record the date exactly once`)
+	}
+	if (doc.Copies[0].Verified != "2024-06-01") || (doc.Copies[1].Verified != "") || (original[0].Verified != "") {
+		t.Errorf(`MarkVerified() gave %+v`, doc.Copies)
+	}
+	if MarkVerified(&doc, "file:///DEC_0002/ka630.pdf", "2024-06-01") {
+		t.Errorf(`MarkVerified() recorded a location the document does not have`)
+	}
+}
+
+func TestAddRedirects(t *testing.T) {
+	doc := Document{Filepath: "http://www.vaxhaven.com/images/ka630.pdf", PublicUrl: "http://www.vaxhaven.com/images/ka630.pdf"}
+	NormaliseLocations(&doc)
+	redirects := map[string]string{
+		"http://www.vaxhaven.com/images/ka630.pdf":  "https://www.vaxhaven.com/images/ka630.pdf",
+		"https://www.vaxhaven.com/images/ka630.pdf": "https://vaxhaven.com/images/ka630.pdf",
+		"https://vaxhaven.com/images/other.pdf":     "https://vaxhaven.com/images/other-2.pdf",
+		"https://vaxhaven.com/loop.pdf":             "https://vaxhaven.com/loop.pdf",
+	}
+	if !AddRedirects(&doc, redirects) || AddRedirects(&doc, redirects) {
+		t.Errorf(`AddRedirects() did not move the document exactly once`)
+	}
+	expected := Document{
+		Filepath:  "https://vaxhaven.com/images/ka630.pdf",
+		PublicUrl: "https://vaxhaven.com/images/ka630.pdf",
+		Copies:    []FileLocation{{Type: RemoteLocation, Url: "https://vaxhaven.com/images/ka630.pdf"}},
+		Redirects: map[string]string{
+			"http://www.vaxhaven.com/images/ka630.pdf":  "https://www.vaxhaven.com/images/ka630.pdf",
+			"https://www.vaxhaven.com/images/ka630.pdf": "https://vaxhaven.com/images/ka630.pdf",
+		},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf(`AddRedirects() = %+v`, doc)
+	}
+
+	// A regenerated document with the old URL is moved by the redirects it recorded
+	regenerated := Document{Filepath: "http://www.vaxhaven.com/images/ka630.pdf"}
+	NormaliseLocations(&regenerated)
+	AddRedirects(&regenerated, doc.Redirects)
+	if !reflect.DeepEqual(regenerated, Document{Filepath: expected.Filepath, Copies: expected.Copies, Redirects: expected.Redirects}) {
+		t.Errorf(`AddRedirects() of recorded redirects = %+v`, regenerated)
+	}
+
+	looped := Document{Filepath: "https://vaxhaven.com/loop.pdf"}
+	if AddRedirects(&looped, redirects); looped.Filepath != "https://vaxhaven.com/loop.pdf" {
+		t.Errorf(`AddRedirects() followed a loop to %s`, looped.Filepath)
+	}
+}
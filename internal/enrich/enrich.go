@@ -0,0 +1,351 @@
+package enrich
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fieldedit"
+	"docs-to-yaml/pkg/catalog"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This package applies enrichment rules: recurring fixups to the metadata of the documents in a catalog (e.g. strip a
+// prefix from the titles on one volume, or set the collection of everything under one path), kept as data rather than
+// written into each generator. The generators apply the rules to each catalog they build, before it is written.
+//
+// The rules are read from a YAML list and are applied in order, so a rule sees the changes made by those before it:
+//
+//	- name: DEC_0007 titles
+//	  volume: DEC_0007
+//	  where: title ~ "^DEC "
+//	  transform:
+//	    - field: title
+//	      trimprefix: "DEC "
+//	- name: Field service notes
+//	  path: "file:///DEC_0012/fs-notes/**"
+//	  set:
+//	    collection: local:fs-notes
+//	    doctype: note
+//	  unset: [publicurl]
+//	  tags: [field-service]
+//
+// A rule matches a document if every condition it gives holds (a rule with none matches every document):
+//
+//	volume  the archive volume: Document.VolumeID, or else the first element of a file:/// filepath
+//	path    a glob matched against the whole filepath: * and ? do not match /, ** matches anything
+//	where   a filter expression on any Document field, as for --where (see catalog.Expr)
+//
+// and then it makes its changes to the document in this order:
+//
+//	set        sets fields, given by name as in the YAML, checked as for edit-catalog --set (see internal/fieldedit)
+//	unset      clears fields
+//	transform  rewrites a text field: trimprefix and trimsuffix remove text, replace (a regular expression) is replaced
+//	           by with (which may refer to submatches as $1), and case (upper, lower or title) changes the case
+//	tags       adds tags
+//
+// A field given by set or unset counts as set by hand, so its code-set flag is cleared (see document.CodeSetFlags); a
+// transform keeps the flag, as the value still derives from that set by code. Read-only documents are never changed,
+// nor are the keys of the documents (see rekey-catalog).
+
+// The conventional location of the rules
+const DefaultFilename = "data/enrichment.yaml"
+
+type Document = document.Document
+
+// Transform rewrites one text field
+type Transform struct {
+	Field      string // The Document field, e.g. "title"
+	TrimPrefix string `yaml:",omitempty"` // Text removed from the start of the value
+	TrimSuffix string `yaml:",omitempty"` // Text removed from the end of the value
+	Replace    string `yaml:",omitempty"` // A regular expression whose matches are replaced by With
+	With       string `yaml:",omitempty"` // The replacement for Replace, which may refer to submatches as $1
+	Case       string `yaml:",omitempty"` // "upper", "lower" or "title"
+
+	field   string         // The Document field name
+	pattern *regexp.Regexp // Compiled Replace
+}
+
+// Rule changes the documents it matches
+type Rule struct {
+	Name      string            `yaml:",omitempty"` // A name for the rule, shown when it is reported
+	Volume    string            `yaml:",omitempty"` // The volume the rule is limited to
+	Path      string            `yaml:",omitempty"` // A glob that the filepath must match
+	Where     string            `yaml:",omitempty"` // A filter expression that the document must match
+	Set       map[string]string `yaml:",omitempty"` // Fields to set, and their values
+	Unset     []string          `yaml:",omitempty"` // Fields to clear
+	Transform []Transform       `yaml:",omitempty"` // Rewrites of text fields, in order
+	Tags      []string          `yaml:",omitempty"` // Tags to add
+
+	path  *regexp.Regexp
+	where *catalog.Expr
+	edits []fieldedit.Edit
+}
+
+// Rules are applied in order
+type Rules []Rule
+
+// Reads rules from a YAML file, and checks them
+func Load(filename string) (Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := document.UnmarshalYaml(data, &rules); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	if err := rules.Compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// Checks the rules and prepares them for use. Returns an error naming the first rule that is not valid.
+func (rules Rules) Compile() error {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d%s: %w", i+1, rules[i].label(), err)
+		}
+	}
+	return nil
+}
+
+// Returns the rule name in parentheses, if it has one
+func (rule *Rule) label() string {
+	if rule.Name == "" {
+		return ""
+	}
+	return " (" + rule.Name + ")"
+}
+
+func (rule *Rule) compile() error {
+	var err error
+	if rule.Path != "" {
+		if rule.path, err = globRegexp(rule.Path); err != nil {
+			return err
+		}
+	}
+	if rule.where, err = catalog.ParseExpr(rule.Where); err != nil {
+		return err
+	}
+	rule.edits = nil
+	fields := make([]string, 0, len(rule.Set))
+	for field := range rule.Set {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		edit, err := fieldedit.NewEdit(field, rule.Set[field], false)
+		if err != nil {
+			return err
+		}
+		rule.edits = append(rule.edits, edit)
+	}
+	for _, field := range rule.Unset {
+		edit, err := fieldedit.NewEdit(field, "", true)
+		if err != nil {
+			return err
+		}
+		rule.edits = append(rule.edits, edit)
+	}
+	for i := range rule.Transform {
+		if err := rule.Transform[i].compile(); err != nil {
+			return err
+		}
+	}
+	if (len(rule.edits) == 0) && (len(rule.Transform) == 0) && (len(rule.Tags) == 0) {
+		return fmt.Errorf("no set, unset, transform or tags")
+	}
+	return nil
+}
+
+func (t *Transform) compile() error {
+	edit, err := fieldedit.NewEdit(t.Field, "", true)
+	if err != nil {
+		return err
+	}
+	field, _ := reflect.TypeOf(Document{}).FieldByName(edit.Field)
+	if field.Type.Kind() != reflect.String {
+		return fmt.Errorf("transform: %s is not a text field", strings.ToLower(edit.Field))
+	}
+	t.field = edit.Field
+	if t.Replace != "" {
+		if t.pattern, err = regexp.Compile(t.Replace); err != nil {
+			return fmt.Errorf("transform of %s: %w", strings.ToLower(edit.Field), err)
+		}
+	}
+	switch strings.ToLower(t.Case) {
+	case "", "upper", "lower", "title":
+	default:
+		return fmt.Errorf("transform of %s: case must be upper, lower or title, not %q", strings.ToLower(edit.Field), t.Case)
+	}
+	if (t.TrimPrefix == "") && (t.TrimSuffix == "") && (t.pattern == nil) && (t.Case == "") {
+		return fmt.Errorf("transform of %s: no trimprefix, trimsuffix, replace or case", strings.ToLower(edit.Field))
+	}
+	return nil
+}
+
+// Converts a glob to a regular expression matching the whole of a filepath: * and ? do not match /, ** matches anything
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for rest := glob; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "**"):
+			pattern.WriteString(".*")
+			rest = rest[2:]
+		case rest[0] == '*':
+			pattern.WriteString("[^/]*")
+			rest = rest[1:]
+		case rest[0] == '?':
+			pattern.WriteString("[^/]")
+			rest = rest[1:]
+		default:
+			literal := strings.IndexAny(rest, "*?")
+			if literal < 0 {
+				literal = len(rest)
+			}
+			pattern.WriteString(regexp.QuoteMeta(rest[:literal]))
+			rest = rest[literal:]
+		}
+	}
+	pattern.WriteString("$")
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("path %q: %w", glob, err)
+	}
+	return re, nil
+}
+
+// Returns the archive volume of a document: its VolumeID, or else the first element of a file:/// filepath
+func volumeOf(doc Document) string {
+	if doc.VolumeID != "" {
+		return doc.VolumeID
+	}
+	if rest, found := strings.CutPrefix(doc.Filepath, "file:///"); found {
+		volume, _, _ := strings.Cut(rest, "/")
+		return volume
+	}
+	return ""
+}
+
+// Reports whether the rule matches the document (whose catalog key is key)
+func (rule *Rule) Matches(key string, doc Document) bool {
+	if (rule.Volume != "") && !strings.EqualFold(rule.Volume, volumeOf(doc)) {
+		return false
+	}
+	if (rule.path != nil) && !rule.path.MatchString(doc.Filepath) {
+		return false
+	}
+	return rule.where.Matches(key, doc)
+}
+
+// Makes the rule's changes to a document, without regard to whether it matches. Returns true if the document changed.
+func (rule *Rule) apply(doc *Document) bool {
+	changed := fieldedit.Apply(doc, rule.edits)
+	for _, t := range rule.Transform {
+		field := reflect.ValueOf(doc).Elem().FieldByName(t.field)
+		value := t.rewrite(field.String())
+		if value != field.String() {
+			field.SetString(value)
+			changed = true
+		}
+	}
+	if document.AddTags(doc, rule.Tags...) {
+		changed = true
+	}
+	return changed
+}
+
+// Returns the value as rewritten by the transform
+func (t Transform) rewrite(value string) string {
+	value = strings.TrimPrefix(value, t.TrimPrefix)
+	value = strings.TrimSuffix(value, t.TrimSuffix)
+	if t.pattern != nil {
+		value = t.pattern.ReplaceAllString(value, t.With)
+	}
+	switch strings.ToLower(t.Case) {
+	case "upper":
+		value = strings.ToUpper(value)
+	case "lower":
+		value = strings.ToLower(value)
+	case "title":
+		words := strings.Fields(strings.ToLower(value))
+		for i, word := range words {
+			first, size := utf8.DecodeRuneInString(word)
+			words[i] = string(unicode.ToUpper(first)) + word[size:]
+		}
+		value = strings.Join(words, " ")
+	}
+	return strings.TrimSpace(value)
+}
+
+// Applies the rules that match to a document, in order. Read-only documents are left alone.
+// Returns the names (or, for a rule without a name, the numbers) of the rules that changed the document.
+func (rules Rules) Apply(key string, doc *Document) []string {
+	if document.IsReadOnly(*doc) {
+		return nil
+	}
+	var applied []string
+	for i := range rules {
+		if rules[i].Matches(key, *doc) && rules[i].apply(doc) {
+			name := rules[i].Name
+			if name == "" {
+				name = fmt.Sprintf("rule %d", i+1)
+			}
+			applied = append(applied, name)
+		}
+	}
+	return applied
+}
+
+// Applies the rules to every document in documents. Returns the keys of the documents changed, sorted.
+func (rules Rules) ApplyAll(documents map[string]Document) []string {
+	var changed []string
+	for key, doc := range documents {
+		if len(rules.Apply(key, &doc)) > 0 {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Applies the rules in filename to every document in documents, as the generators do before writing a catalog.
+// Only the default file may be missing (there are then no rules); any other file must exist.
+// Returns the keys of the documents changed, sorted.
+func ApplyFrom(filename string, documents map[string]Document) ([]string, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) && (filename == DefaultFilename) {
+		return nil, nil
+	}
+	rules, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	return rules.ApplyAll(documents), nil
+}
+
+// Applies the rules in filename with ApplyFrom and reports the documents changed (each one if verbose), exiting with a
+// fatal error if the rules cannot be applied. This is the step every generator takes before writing its catalog.
+func ApplyForCatalog(filename string, documents map[string]Document, verbose bool) {
+	enriched, err := ApplyFrom(filename, documents)
+	if err != nil {
+		exitcode.Fatalf("Cannot apply %s: %v", filename, err)
+	}
+	if len(enriched) == 0 {
+		return
+	}
+	fmt.Printf("Changed %d documents by the enrichment rules (see %s)\n", len(enriched), filename)
+	if verbose {
+		for _, key := range enriched {
+			fmt.Printf("  enriched: %s\n", key)
+		}
+	}
+}
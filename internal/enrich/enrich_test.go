@@ -0,0 +1,129 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{"set", Rule{Set: map[string]string{"collection": "local:fs"}}, true},
+		{"tags only", Rule{Tags: []string{"rare"}}, true},
+		{"nothing to do", Rule{Volume: "DEC_0001"}, false},
+		{"unknown field", Rule{Set: map[string]string{"colour": "blue"}}, false},
+		{"fixed field", Rule{Unset: []string{"md5"}}, false},
+		{"bad pubdate", Rule{Set: map[string]string{"pubdate": "last year"}}, false},
+		{"bad where", Rule{Where: "title ~", Tags: []string{"rare"}}, false},
+		{"transform of a number", Rule{Transform: []Transform{{Field: "size", Case: "upper"}}}, false},
+		{"empty transform", Rule{Transform: []Transform{{Field: "title"}}}, false},
+		{"bad case", Rule{Transform: []Transform{{Field: "title", Case: "sentence"}}}, false},
+		{"bad replace", Rule{Transform: []Transform{{Field: "title", Replace: "("}}}, false},
+	} {
+		if err := (Rules{test.rule}).Compile(); (err == nil) != test.ok {
+			t.Errorf("%s: Compile() = %v", test.name, err)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	doc := Document{Format: "PDF", Title: "DEC VT100 User Guide", Filepath: "file:///DEC_0007/terminals/vt100/ek-vt100-ug.pdf"}
+	for _, test := range []struct {
+		rule    Rule
+		matches bool
+	}{
+		{Rule{}, true},
+		{Rule{Volume: "dec_0007"}, true},
+		{Rule{Volume: "DEC_0008"}, false},
+		{Rule{Path: "file:///DEC_0007/terminals/*"}, false},
+		{Rule{Path: "file:///DEC_0007/terminals/**"}, true},
+		{Rule{Path: "file:///DEC_000?/*/vt100/*.pdf"}, true},
+		{Rule{Where: `title ~ "^DEC " and format = pdf`}, true},
+		{Rule{Volume: "DEC_0007", Where: "format = TXT"}, false},
+	} {
+		test.rule.Tags = []string{"t"}
+		rules := Rules{test.rule}
+		if err := rules.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		if matches := rules[0].Matches("key", doc); matches != test.matches {
+			t.Errorf("%+v Matches() = %v", test.rule, matches)
+		}
+	}
+	doc.VolumeID = "RK05_12"
+	if rule := (Rule{Volume: "RK05_12"}); !rule.Matches("key", doc) {
+		t.Errorf("Matches() ignored the VolumeID")
+	}
+}
+
+func TestApply(t *testing.T) {
+	rules := Rules{
+		{Name: "titles", Volume: "DEC_0007", Transform: []Transform{{Field: "title", TrimPrefix: "DEC "}, {Field: "title", Replace: `\s*\(scan (\d+)\)$`, With: " [$1]"}}},
+		{Name: "fs-notes", Path: "file:///DEC_0012/fs-notes/**", Set: map[string]string{"collection": "local:fs-notes", "pubdate": "1984"}, Unset: []string{"publicurl"}, Tags: []string{"field-service"}},
+		{Where: "not partnum", Transform: []Transform{{Field: "partnum", Replace: "^$", With: "UNKNOWN"}, {Field: "partnum", Case: "lower"}}},
+	}
+	if err := rules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	documents := map[string]Document{
+		"a": {Title: "DEC VT100 User Guide (scan 2)", PartNum: "EK-VT100-UG", Filepath: "file:///DEC_0007/vt100.pdf", Flags: "T"},
+		"b": {Title: "FS Note 12", PartNum: "FS-12", PubDate: "1983", Filepath: "file:///DEC_0012/fs-notes/12/note.pdf", PublicUrl: "http://example.com/12", Flags: "D"},
+		"c": {Title: "Untitled", Filepath: "file:///DEC_0001/x.pdf"},
+		"d": {Title: "DEC Reference", Filepath: "file:///DEC_0007/ref.pdf", Origin: "master"},
+		"e": {Title: "Left alone", PartNum: "AA-1", Filepath: "file:///DEC_0001/y.pdf"},
+	}
+	changed := rules.ApplyAll(documents)
+	if !slices.Equal(changed, []string{"a", "b", "c"}) {
+		t.Errorf("ApplyAll() changed %v", changed)
+	}
+	if doc := documents["a"]; (doc.Title != "VT100 User Guide [2]") || (doc.Flags != "T") {
+		t.Errorf("transformed document = %+v", doc)
+	}
+	if doc := documents["b"]; (doc.Collection != "local:fs-notes") || (doc.PubDate != "1984") || (doc.PublicUrl != "") || (doc.Flags != "") || !slices.Equal(doc.Tags, []string{"field-service"}) {
+		t.Errorf("edited document = %+v", doc)
+	}
+	if doc := documents["c"]; doc.PartNum != "unknown" {
+		t.Errorf("document without a part number = %+v", doc)
+	}
+	if doc := documents["d"]; doc.Title != "DEC Reference" {
+		t.Errorf("read-only document changed: %+v", doc)
+	}
+
+	// A second pass changes nothing more
+	if changed := rules.ApplyAll(documents); len(changed) != 0 {
+		t.Errorf("second ApplyAll() changed %v", changed)
+	}
+}
+
+func TestApplyFrom(t *testing.T) {
+	dir := t.TempDir()
+	documents := map[string]Document{"a": {Title: "DEC VT100 User Guide", Filepath: "file:///DEC_0007/vt100.pdf"}}
+	if _, err := ApplyFrom(filepath.Join(dir, "missing.yaml"), documents); err == nil {
+		t.Errorf("ApplyFrom() accepted a missing rules file")
+	}
+	// (there is no data/enrichment.yaml beside the tests)
+	if changed, err := ApplyFrom(DefaultFilename, documents); (err != nil) || (len(changed) != 0) {
+		t.Errorf("ApplyFrom() without the default rules = %v, %v", changed, err)
+	}
+
+	filename := filepath.Join(dir, "enrichment.yaml")
+	rules := "- name: titles\n  volume: DEC_0007\n  transform:\n    - field: Title\n      trimprefix: \"DEC \"\n"
+	if err := os.WriteFile(filename, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := ApplyFrom(filename, documents); (err != nil) || !slices.Equal(changed, []string{"a"}) || (documents["a"].Title != "VT100 User Guide") {
+		t.Errorf("ApplyFrom() = %v, %v; documents %+v", changed, err, documents)
+	}
+
+	if err := os.WriteFile(filename, []byte("- set:\n    pubdate: soon\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyFrom(filename, documents); err == nil {
+		t.Errorf("ApplyFrom() accepted an invalid rule")
+	}
+}
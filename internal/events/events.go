@@ -0,0 +1,113 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// This package writes a machine-readable stream of the events (warnings, errors and informational messages)
+// that the tools report on the console, so that other scripts need not parse the free-form console output.
+//
+// When enabled with --events FILE, each event is written to FILE as a single line of JSON (NDJSON), e.g.
+//
+//	{"type":"missing-file","severity":"warning","volume":"DEC_0001","path":"vax/ka630.pdf","message":"MISSING file ..."}
+//
+// The console output is unchanged. When no events file has been opened, Emit does nothing.
+
+// The legal values for Event.Severity
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Event is a single record in the events file
+type Event struct {
+	Type     string `json:"type"`             // What kind of event this is, e.g. "duplicate-document"
+	Severity string `json:"severity"`         // One of the Severity* constants
+	Volume   string `json:"volume,omitempty"` // The archive volume being processed, if any
+	Path     string `json:"path,omitempty"`   // The file concerned, if any
+	Message  string `json:"message"`          // The human-readable message (as printed on the console)
+}
+
+var mutex sync.Mutex
+var file *os.File
+var encoder *json.Encoder
+var volume string
+var observers []func(Event)
+
+// Opens the events file, replacing any existing file. A blank filename leaves events disabled.
+func Open(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	file = f
+	encoder = json.NewEncoder(f)
+	encoder.SetEscapeHTML(false)
+	return nil
+}
+
+// Closes the events file, if one is open.
+func Close() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if file == nil {
+		return nil
+	}
+	err := file.Close()
+	file = nil
+	encoder = nil
+	return err
+}
+
+// Records the archive volume currently being processed; it is added to every subsequent event.
+// A blank name means no volume is being processed.
+func SetVolume(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	volume = name
+}
+
+// Calls fn with every event emitted from now on, whether or not an events file is open (e.g. to summarise a run, see
+// internal/notify). fn must not emit events itself.
+func Observe(fn func(Event)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	observers = append(observers, fn)
+}
+
+// Writes an event to the events file, if one is open, and passes it to any observers. The message has any trailing
+// newline removed.
+func Emit(severity string, eventType string, path string, message string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if (encoder == nil) && (len(observers) == 0) {
+		return
+	}
+	event := Event{Type: eventType, Severity: severity, Volume: volume, Path: path, Message: strings.TrimRight(message, "\n")}
+	for _, observer := range observers {
+		observer(event)
+	}
+	if encoder == nil {
+		return
+	}
+	if err := encoder.Encode(event); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write event: %s\n", err)
+	}
+}
+
+// Prints an informational message on the console (formatted as by fmt.Printf) and records it as an event.
+func Info(eventType string, path string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(message)
+	Emit(SeverityInfo, eventType, path, message)
+}
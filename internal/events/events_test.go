@@ -0,0 +1,60 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEmitWritesNDJSON(t *testing.T) {
+	// With no events file open, Emit does nothing
+	Emit(SeverityInfo, "ignored", "", "not recorded")
+
+	filename := filepath.Join(t.TempDir(), "events.ndjson")
+	if err := Open(filename); err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	Emit(SeverityWarning, "missing-file", "vax/a.pdf", "MISSING file <vax/a.pdf>\n")
+	SetVolume("DEC_0001")
+	Emit(SeverityError, "undefined-category", "", "Cannot process")
+	SetVolume("")
+	if err := Close(); err != nil {
+		t.Fatalf(`Close() failed: %v`, err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf(`cannot read events: %v`, err)
+	}
+	defer file.Close()
+	var got []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf(`line %q is not JSON: %v`, scanner.Text(), err)
+		}
+		got = append(got, event)
+	}
+
+	expected := []Event{
+		{Type: "missing-file", Severity: SeverityWarning, Path: "vax/a.pdf", Message: "MISSING file <vax/a.pdf>"},
+		{Type: "undefined-category", Severity: SeverityError, Volume: "DEC_0001", Message: "Cannot process"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("events mismatch:\n%#v\n%#v", got, expected)
+	}
+}
+
+func TestOpenBlankIsDisabled(t *testing.T) {
+	if err := Open(""); err != nil {
+		t.Fatalf(`Open("") failed: %v`, err)
+	}
+	Emit(SeverityInfo, "ignored", "", "not recorded")
+	if err := Close(); err != nil {
+		t.Fatalf(`Close() failed: %v`, err)
+	}
+}
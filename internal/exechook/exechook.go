@@ -0,0 +1,161 @@
+package exechook
+
+import (
+	"bufio"
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// This package runs a user-supplied command (the --exec hook) for each document processed, so that custom
+// extraction (a specific OCR, a classifier, etc.) can contribute to the catalog.
+//
+// The command is given as a single string, e.g.
+//
+//	--exec "classify --md5 {md5} {path}"
+//
+// It is split into arguments (honouring single and double quotes) before {path} and {md5} are replaced in each
+// argument, so a path containing spaces or shell metacharacters is passed as a single, uninterpreted argument.
+// No shell is involved.
+//
+// The command's standard output is read as key=value lines; the keys listed in Fields are copied into the
+// Document, replacing any existing value. Other lines are ignored, but each unrecognised key is reported (once).
+
+// Fields maps each recognised key to the Document field it sets
+var Fields = map[string]func(doc *document.Document, value string){
+	"title":          func(doc *document.Document, value string) { doc.Title = value },
+	"partnum":        func(doc *document.Document, value string) { doc.PartNum = value },
+	"pubdate":        func(doc *document.Document, value string) { doc.PubDate = value },
+	"publicurl":      func(doc *document.Document, value string) { doc.PublicUrl = value; document.NormaliseLocations(doc) },
+	"section":        func(doc *document.Document, value string) { doc.Section = value },
+	"processingnote": func(doc *document.Document, value string) { doc.ProcessingNote = value },
+}
+
+// Hook is a parsed --exec command
+type Hook struct {
+	args     []string        // The command and its arguments, still containing the placeholders
+	reported map[string]bool // Unrecognised keys that have already been reported
+}
+
+// Parses the command. A blank command gives a nil Hook, which does nothing.
+func Parse(command string) (*Hook, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, nil
+	}
+	args, err := splitArgs(command)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{args: args, reported: make(map[string]bool)}, nil
+}
+
+// Splits a command line into arguments, removing any quotes.
+func splitArgs(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	quote := rune(0)
+	for _, ch := range command {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				current.WriteRune(ch)
+			}
+		case (ch == '"') || (ch == '\''):
+			quote = ch
+			inArg = true
+		case (ch == ' ') || (ch == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(ch)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// Returns the command that would be run for the specified document
+func (hook *Hook) Command(path string, md5 string) []string {
+	return hook.Expand(map[string]string{"{path}": path, "{md5}": md5})
+}
+
+// Returns the command with each placeholder (e.g. "{path}") replaced by its value in every argument.
+// This lets other tools (e.g. ocr-queue) use their own placeholders.
+func (hook *Hook) Expand(placeholders map[string]string) []string {
+	var pairs []string
+	for placeholder, value := range placeholders {
+		pairs = append(pairs, placeholder, value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	command := make([]string, len(hook.args))
+	for i, arg := range hook.args {
+		command[i] = replacer.Replace(arg)
+	}
+	return command
+}
+
+// Runs the command for the specified file and returns the key=value pairs it printed.
+// Keys are lower-cased and surrounding white space is removed from keys and values.
+func (hook *Hook) Run(path string, md5 string) (map[string]string, error) {
+	command := hook.Command(path, md5)
+	cmd := exec.Command(command[0], command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// Runs the hook for a document (whose file is at path) and merges the recognised values into it.
+// Returns any unrecognised keys not reported before, sorted. A nil Hook does nothing.
+func (hook *Hook) Process(doc *document.Document, path string) ([]string, error) {
+	if hook == nil {
+		return nil, nil
+	}
+	values, err := hook.Run(path, doc.Md5)
+	if err != nil {
+		return nil, err
+	}
+	var unrecognised []string
+	for key, value := range values {
+		if set, found := Fields[key]; found {
+			if value != "" {
+				set(doc, value)
+			}
+		} else if !hook.reported[key] {
+			hook.reported[key] = true
+			unrecognised = append(unrecognised, key)
+		}
+	}
+	sort.Strings(unrecognised)
+	return unrecognised, nil
+}
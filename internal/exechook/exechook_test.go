@@ -0,0 +1,76 @@
+package exechook
+
+import (
+	"docs-to-yaml/internal/document"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+		fails    bool
+	}{
+		{"classify {path}", []string{"classify", "{path}"}, false},
+		{`ocr  --lang "en gb"	'{md5}.txt'`, []string{"ocr", "--lang", "en gb", "{md5}.txt"}, false},
+		{`run ""`, []string{"run", ""}, false},
+		{`run "{path}`, nil, true},
+	}
+	for _, test := range tests {
+		hook, err := Parse(test.command)
+		if (err != nil) != test.fails {
+			t.Errorf(`Parse(%q) error = %v`, test.command, err)
+		} else if !test.fails && !reflect.DeepEqual(hook.args, test.expected) {
+			t.Errorf(`Parse(%q) = %q, expected %q`, test.command, hook.args, test.expected)
+		}
+	}
+
+	if hook, err := Parse("  "); (hook != nil) || (err != nil) {
+		t.Errorf(`Parse() of a blank command = %v, %v`, hook, err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	hook, _ := Parse(`tool --md5={md5} {path}`)
+	command := hook.Command("/media/my docs/$HOME.pdf", "abc123")
+	expected := []string{"tool", "--md5=abc123", "/media/my docs/$HOME.pdf"}
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf(`Command() = %q, expected %q`, command, expected)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	hook, err := Parse(`sh -c "printf 'Title = New Title\nPARTNUM=EK-123\npubdate=\ncolour=blue\nnoise\n'; echo md5=$1" sh {md5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := document.Document{Title: "Old", PubDate: "1985", Md5: "abc"}
+	unrecognised, err := hook.Process(&doc, "/tmp/x.pdf")
+	if err != nil {
+		t.Fatalf(`Process() failed: %v`, err)
+	}
+	expected := document.Document{Title: "New Title", PartNum: "EK-123", PubDate: "1985", Md5: "abc"}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf(`Process() gave %+v, expected %+v`, doc, expected)
+	}
+	if !reflect.DeepEqual(unrecognised, []string{"colour", "md5"}) {
+		t.Errorf(`Process() unrecognised = %q`, unrecognised)
+	}
+
+	// Unrecognised keys are only reported once
+	unrecognised, _ = hook.Process(&doc, "/tmp/x.pdf")
+	if len(unrecognised) != 0 {
+		t.Errorf(`Process() reported %q again`, unrecognised)
+	}
+
+	failing, _ := Parse("false")
+	if _, err := failing.Process(&doc, "/tmp/x.pdf"); err == nil {
+		t.Errorf(`Process() of a failing command succeeded`)
+	}
+
+	var none *Hook
+	if _, err := none.Process(&doc, "/tmp/x.pdf"); err != nil {
+		t.Errorf(`Process() of a nil hook failed: %v`, err)
+	}
+}
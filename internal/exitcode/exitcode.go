@@ -0,0 +1,170 @@
+package exitcode
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/profiling"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// This package defines the exit status conventions shared by every tool, so that scripts can tell
+// "ran with warnings" apart from "failed":
+//
+//	0  Clean:                 the run completed with no warnings
+//	1  CompletedWithWarnings: the run completed, but reported one or more warnings
+//	2  InvalidUsage:          the command line was invalid; nothing was processed
+//	3  FatalError:            processing failed, or completed but found errors
+//
+// A run stopped by Ctrl-C exits with interrupt.ExitCode (130).
+//
+// Warnings and (non-fatal) errors are printed through Warning and Error so that they are counted.
+// At the end of a run, Exit prints a summary line with the counts and exits with the matching status.
+// Fatal and Fatalf print the same summary line before exiting.
+// Everything reported through this package is also recorded in the events file (see the events package), if one is open,
+// and any profiles and metrics requested (see the profiling and metrics packages) are written before exiting.
+// Warnings, errors and the summary line are coloured by severity on a terminal, and only the summary line is
+// printed with --quiet (see the console package).
+
+const (
+	Clean                 = 0
+	CompletedWithWarnings = 1
+	InvalidUsage          = 2
+	FatalError            = 3
+)
+
+var warningCount atomic.Int64
+var errorCount atomic.Int64
+var exitHooks []func(code int, summary string)
+
+// Calls fn with the exit status and summary line when the run ends, through Exit, Fatal or Fatalf, just before the
+// events file is closed (e.g. to send a notification, see internal/notify). It is not called for an invalid command
+// line, as nothing was processed.
+func OnExit(fn func(code int, summary string)) {
+	exitHooks = append(exitHooks, fn)
+}
+
+// Prints a warning (formatted as by fmt.Printf, so the caller supplies any newline) and counts it.
+func Warning(format string, args ...interface{}) {
+	WarningAt("warning", "", format, args...)
+}
+
+// Like Warning, but records the kind of warning and the file concerned (if any) in the events file.
+func WarningAt(eventType string, path string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(console.Colour(console.Yellow, message))
+	events.Emit(events.SeverityWarning, eventType, path, message)
+	warningCount.Add(1)
+}
+
+// Prints a non-fatal error (formatted as by fmt.Printf, so the caller supplies any newline) and counts it.
+// Processing continues, but the run will end with the FatalError status.
+func Error(format string, args ...interface{}) {
+	ErrorAt("error", "", format, args...)
+}
+
+// Like Error, but records the kind of error and the file concerned (if any) in the events file.
+func ErrorAt(eventType string, path string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(console.Colour(console.Red, message))
+	events.Emit(events.SeverityError, eventType, path, message)
+	errorCount.Add(1)
+}
+
+// Counts n warnings that have already been reported some other way (for example by fsutil.ProblemFilenames.Report).
+func AddWarnings(n int) {
+	warningCount.Add(int64(n))
+}
+
+// Counts n errors that have already been reported some other way.
+func AddErrors(n int) {
+	errorCount.Add(int64(n))
+}
+
+// Returns the number of warnings and errors counted so far
+func Counts() (warnings int, errors int) {
+	return int(warningCount.Load()), int(errorCount.Load())
+}
+
+// Returns the exit status implied by the warnings and errors counted so far
+func Code() int {
+	warnings, errors := Counts()
+	if errors > 0 {
+		return FatalError
+	} else if warnings > 0 {
+		return CompletedWithWarnings
+	}
+	return Clean
+}
+
+// Returns the summary line printed when the program exits with the specified status
+func Summary(code int) string {
+	warnings, errors := Counts()
+	outcome := "completed"
+	if code == FatalError {
+		outcome = "failed"
+	}
+	return fmt.Sprintf("%s: %s with %d warnings and %d errors (exit status %d)", filepath.Base(os.Args[0]), outcome, warnings, errors, code)
+}
+
+// Prints the summary line and exits with the status implied by the warnings and errors counted.
+func Exit() {
+	exit(Code())
+}
+
+// Prints (and records as an event) the summary line, then exits with the specified status.
+func exit(code int) {
+	metrics.Stop()
+	summary := Summary(code)
+	colour := console.Green
+	if code == FatalError {
+		colour = console.Red
+	} else if code == CompletedWithWarnings {
+		colour = console.Yellow
+	}
+	console.Summary(colour, summary)
+	events.SetVolume("")
+	events.Emit(events.SeverityInfo, "summary", "", summary)
+	for _, hook := range exitHooks {
+		hook(code, summary)
+	}
+	events.Close()
+	profiling.Stop()
+	os.Exit(code)
+}
+
+// Reports an invalid command line and exits with the InvalidUsage status. Arguments are handled as by log.Print.
+func UsageError(v ...interface{}) {
+	log.Print(v...)
+	events.Close()
+	profiling.Stop()
+	os.Exit(InvalidUsage)
+}
+
+// Reports an invalid command line and exits with the InvalidUsage status. Arguments are handled as by log.Printf.
+func UsageErrorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	events.Close()
+	profiling.Stop()
+	os.Exit(InvalidUsage)
+}
+
+// Reports a fatal processing error and exits with the FatalError status. This replaces log.Fatal, which exits with 1.
+func Fatal(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	log.Print(message)
+	events.Emit(events.SeverityError, "fatal", "", message)
+	exit(FatalError)
+}
+
+// Reports a fatal processing error and exits with the FatalError status. This replaces log.Fatalf, which exits with 1.
+func Fatalf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	log.Print(message)
+	events.Emit(events.SeverityError, "fatal", "", message)
+	exit(FatalError)
+}
@@ -0,0 +1,38 @@
+package exitcode
+
+import (
+	"strings"
+	"testing"
+)
+
+func reset() {
+	warningCount.Store(0)
+	errorCount.Store(0)
+}
+
+func TestCode(t *testing.T) {
+	reset()
+	if Code() != Clean {
+		t.Fatalf(`Code() with nothing reported = %d, expected %d`, Code(), Clean)
+	}
+
+	AddWarnings(2)
+	if Code() != CompletedWithWarnings {
+		t.Fatalf(`Code() with warnings = %d, expected %d`, Code(), CompletedWithWarnings)
+	}
+
+	AddErrors(1)
+	if Code() != FatalError {
+		t.Fatalf(`Code() with errors = %d, expected %d`, Code(), FatalError)
+	}
+
+	warnings, errors := Counts()
+	if (warnings != 2) || (errors != 1) {
+		t.Fatalf(`Counts() = %d, %d; expected 2, 1`, warnings, errors)
+	}
+	summary := Summary(Code())
+	if !strings.Contains(summary, "failed with 2 warnings and 1 errors (exit status 3)") {
+		t.Fatalf(`Summary() = %q`, summary)
+	}
+	reset()
+}
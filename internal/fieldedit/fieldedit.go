@@ -0,0 +1,104 @@
+package fieldedit
+
+import (
+	"docs-to-yaml/internal/doctype"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/pubdate"
+	"docs-to-yaml/internal/runoff"
+	"docs-to-yaml/internal/visibility"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This package sets and clears individual fields of a document given by name, as edit-catalog --set and --unset do and
+// as the enrichment rules do (see internal/enrich). Fields are named as in the YAML, without regard to case, and each
+// value is checked as it would be if typed by hand: a pubdate must be a date, a format one that is known, and so on.
+// The fields that identify the file or record how it was chosen cannot be edited.
+
+type Document = document.Document
+
+// Edit is one change to a document field
+type Edit struct {
+	Field string // The Document field, e.g. "PubDate"
+	Value string // The new value (ignored for Unset)
+	Unset bool   // Clear the field rather than set it
+}
+
+// The fields that cannot be edited, named as in the YAML
+var FixedFields = []string{"md5", "sha256", "flags", "provenance", "copies", "redirects", "origin"}
+
+// Returns an edit of the named field (matched without regard to case against the Document fields).
+// Returns an error if there is no such field, it cannot be edited, or value is not valid for it.
+func NewEdit(name string, value string, unset bool) (Edit, error) {
+	name = strings.TrimSpace(name)
+	field, found := reflect.TypeOf(Document{}).FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+	if !found {
+		return Edit{}, fmt.Errorf("unknown document field %q", name)
+	}
+	if slices.Contains(FixedFields, strings.ToLower(field.Name)) {
+		return Edit{}, fmt.Errorf("the %s field cannot be edited", strings.ToLower(field.Name))
+	}
+	edit := Edit{Field: field.Name, Value: strings.TrimSpace(value), Unset: unset}
+	if unset {
+		return edit, nil
+	}
+	switch {
+	case field.Type.Kind() == reflect.Int64:
+		if size, err := strconv.ParseInt(edit.Value, 10, 64); (err != nil) || (size < 0) {
+			return Edit{}, fmt.Errorf("%s must be a number of bytes, found %q", strings.ToLower(field.Name), value)
+		}
+	case (field.Name == "PubDate") && !document.IsPubDate(edit.Value):
+		return Edit{}, fmt.Errorf("pubdate must be YYYY, YYYY-MM or YYYY-MM-DD, found %q", value)
+	case (field.Name == "DocType") && !doctype.IsType(edit.Value):
+		return Edit{}, fmt.Errorf("doctype must be lower-case words joined by hyphens (e.g. print-set), found %q", value)
+	case (field.Name == "Visibility") && !visibility.IsLevel(edit.Value):
+		return Edit{}, fmt.Errorf("visibility must be one of %s, found %q", strings.Join(visibility.Levels, ", "), value)
+	case field.Name == "Format":
+		format, err := document.DetermineDocumentFormat("." + edit.Value)
+		if err != nil {
+			return Edit{}, fmt.Errorf("unknown format %q", value)
+		}
+		edit.Value = format
+	}
+	return edit, nil
+}
+
+// Applies the edits to a document. Returns true if the document changed.
+func Apply(doc *Document, edits []Edit) bool {
+	before := *doc
+	before.AltPartNums, before.Tags = slices.Clone(doc.AltPartNums), slices.Clone(doc.Tags)
+	before.Copies = slices.Clone(doc.Copies)
+	for _, edit := range edits {
+		field := reflect.ValueOf(doc).Elem().FieldByName(edit.Field)
+		switch {
+		case edit.Unset:
+			field.SetZero()
+		case edit.Field == "Tags":
+			doc.Tags = nil
+			document.AddTags(doc, strings.Split(edit.Value, ",")...)
+		case edit.Field == "AltPartNums":
+			doc.AltPartNums = nil
+			document.AddAltPartNums(doc, strings.Split(edit.Value, ",")...)
+		case field.Kind() == reflect.Int64:
+			size, _ := strconv.ParseInt(edit.Value, 10, 64)
+			field.SetInt(size)
+		default:
+			field.SetString(edit.Value)
+		}
+		// The value now comes from a person, not from code
+		if codeSet, found := document.CodeSetFlags[edit.Field]; found {
+			document.ClearFlags(doc, codeSet)
+		}
+		if edit.Field == "PubDate" {
+			pubdate.Clear(doc)
+		}
+		if edit.Field == "Title" {
+			runoff.Clear(doc)
+		}
+	}
+	document.NormaliseLocations(doc)
+	return !reflect.DeepEqual(before, *doc)
+}
@@ -0,0 +1,59 @@
+package fieldedit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewEdit(t *testing.T) {
+	for _, test := range []struct {
+		field    string
+		value    string
+		unset    bool
+		expected Edit
+		fails    bool
+	}{
+		{"pubdate", "1987-01", false, Edit{Field: "PubDate", Value: "1987-01"}, false},
+		{" PartNum ", " EK-KA630-TM-001 ", false, Edit{Field: "PartNum", Value: "EK-KA630-TM-001"}, false},
+		{"format", "htm", false, Edit{Field: "Format", Value: "HTML"}, false},
+		{"size", "1024", false, Edit{Field: "Size", Value: "1024"}, false},
+		{"processingnote", "", true, Edit{Field: "ProcessingNote", Unset: true}, false},
+		{"pubdate", "Jan87", false, Edit{}, true},
+		{"size", "big", false, Edit{}, true},
+		{"format", "XYZ", false, Edit{}, true},
+		{"doctype", "print-set", false, Edit{Field: "DocType", Value: "print-set"}, false},
+		{"doctype", "Print Set", false, Edit{}, true},
+		{"visibility", "private", false, Edit{Field: "Visibility", Value: "private"}, false},
+		{"visibility", "secret", false, Edit{}, true},
+		{"md5", "0123456789abcdef0123456789abcdef", false, Edit{}, true},
+		{"flags", "", true, Edit{}, true},
+		{"colour", "red", false, Edit{}, true},
+	} {
+		edit, err := NewEdit(test.field, test.value, test.unset)
+		if (err != nil) != test.fails {
+			t.Errorf("NewEdit(%q, %q) gave error %v", test.field, test.value, err)
+		} else if !test.fails && (edit != test.expected) {
+			t.Errorf("NewEdit(%q, %q) = %+v, expected %+v", test.field, test.value, edit, test.expected)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := Document{Title: "KA630 Manual", PubDate: "1986", PartNum: "EK-KA630-TM", Flags: "PTD", DateSource: "inferred", Confidence: 0.5, ProcessingNote: "forced", Tags: []string{"rare"}}
+	edits := []Edit{{Field: "PubDate", Value: "1987-01"}, {Field: "ProcessingNote", Unset: true}, {Field: "Tags", Value: "needs-rescan, rare"}}
+	if !Apply(&doc, edits) {
+		t.Errorf("Apply() reported no change")
+	}
+	expected := Document{Title: "KA630 Manual", PubDate: "1987-01", PartNum: "EK-KA630-TM", Flags: "PT", Tags: []string{"needs-rescan", "rare"}}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Apply() gave %+v, expected %+v", doc, expected)
+	}
+
+	// Confirming a value that code guessed clears its flag, which is itself a change
+	if !Apply(&doc, []Edit{{Field: "Title", Value: "KA630 Manual"}}) || (doc.Flags != "P") {
+		t.Errorf("Apply() to confirm the title gave flags %q", doc.Flags)
+	}
+	if Apply(&doc, []Edit{{Field: "Title", Value: "KA630 Manual"}}) {
+		t.Errorf("Apply() reported a change when nothing changed")
+	}
+}
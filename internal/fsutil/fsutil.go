@@ -0,0 +1,218 @@
+package fsutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This package provides helpers that allow the tools to behave the same way on Linux, on Windows
+// and on case-insensitive mounts (e.g. a NAS share or a FAT/NTFS/HFS+ volume mounted on Linux).
+//
+// Paths held in catalogs (Document.Filepath, store keys, index.csv and md5sums entries) always use
+// "/" as the separator. Paths used to access the local filesystem use the native separator.
+// The functions here convert between the two.
+
+// Returns the path of target relative to root, using "/" as the separator regardless of platform.
+func RelativeSlashPath(root string, target string) (string, error) {
+	relative, err := filepath.Rel(root, target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// Joins a native root path and a "/"-separated relative path to produce a native path.
+func JoinSlashPath(root string, relative string) string {
+	return filepath.Join(root, filepath.FromSlash(relative))
+}
+
+// Determines whether the filesystem holding dir treats filenames case-sensitively.
+//
+// The check looks for an entry in dir whose name contains a letter, then tries to stat that name with
+// its case swapped. If that finds the same file, the filesystem is case-insensitive.
+// If dir contains nothing suitable, the platform default is assumed (case-insensitive on Windows and macOS).
+func IsCaseSensitive(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			swapped := swapCase(name)
+			if swapped == name {
+				continue
+			}
+			originalInfo, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			swappedInfo, err := os.Stat(filepath.Join(dir, swapped))
+			if err != nil {
+				return true
+			}
+			return !os.SameFile(originalInfo, swappedInfo)
+		}
+	}
+	return (runtime.GOOS != "windows") && (runtime.GOOS != "darwin")
+}
+
+// Returns true if dir contains an entry whose name exactly matches name (including case).
+// On a case-insensitive filesystem os.Stat would succeed for any case variant, which is not always what is wanted.
+func ExactEntryExists(dir string, name string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that dir is a readable, non-empty directory. An archive root on a network share that is not mounted
+// usually shows up as a missing or empty directory, so either is reported as an error.
+func CheckDirAvailable(dir string) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s does not exist (not mounted?)", dir)
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s is empty (not mounted?)", dir)
+	}
+	return nil
+}
+
+func swapCase(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// The longest filename (i.e. a single path component) that common filesystems accept, in bytes.
+// Rescued files sometimes exceed this and then cannot be copied to, or opened on, another volume.
+const MaxNameLength = 255
+
+// Some rescued files have names that are not valid UTF-8 (typically Latin-1).
+// Such names cannot be written to YAML as text, so any byte that is not part of a valid UTF-8 sequence
+// is replaced by the escape \xNN. A name that is valid UTF-8 is returned unchanged, so that it still names its file.
+// In a name that is escaped, backslashes are doubled, so that an escape can be told from a backslash in the name.
+func EscapeInvalidUTF8(name string) string {
+	if utf8.ValidString(name) {
+		return name
+	}
+	var result strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if (r == utf8.RuneError) && (size <= 1) {
+			fmt.Fprintf(&result, "\\x%02X", name[i])
+			i += 1
+			continue
+		}
+		if r == '\\' {
+			result.WriteByte('\\')
+		}
+		result.WriteString(name[i : i+size])
+		i += size
+	}
+	return result.String()
+}
+
+// Checks a "/"-separated path for problems that would prevent it being recorded or copied safely.
+// Returns a description of each problem found; an empty result means the path is fine.
+func CheckFilename(path string) []string {
+	var problems []string
+	if !utf8.ValidString(path) {
+		problems = append(problems, "not valid UTF-8")
+	}
+	for _, component := range strings.Split(path, "/") {
+		if len(component) > MaxNameLength {
+			problems = append(problems, fmt.Sprintf("name is %d bytes long (limit %d): %s", len(component), MaxNameLength, EscapeInvalidUTF8(component)))
+		}
+	}
+	return problems
+}
+
+// ProblemFilename records a file that could not be processed normally, and why.
+type ProblemFilename struct {
+	Path    string // Path to the file (escaped so that it is printable)
+	Problem string // Description of the problem
+}
+
+// ProblemFilenames accumulates problem files during a scan so that they can be reported at the end
+// rather than stopping the scan part way through.
+type ProblemFilenames struct {
+	Entries []ProblemFilename
+}
+
+// Records a problem with the specified path.
+func (problems *ProblemFilenames) Add(path string, problem string) {
+	problems.Entries = append(problems.Entries, ProblemFilename{Path: EscapeInvalidUTF8(path), Problem: problem})
+}
+
+// Checks the specified path with CheckFilename and records any problems found.
+// Returns true if the path is fine.
+func (problems *ProblemFilenames) Check(path string) bool {
+	found := CheckFilename(path)
+	for _, problem := range found {
+		problems.Add(path, problem)
+	}
+	return len(found) == 0
+}
+
+// Writes a report of all problem files, if any.
+func (problems *ProblemFilenames) Report(w io.Writer) {
+	if len(problems.Entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Problem filenames: %d\n", len(problems.Entries))
+	for _, entry := range problems.Entries {
+		fmt.Fprintf(w, "  %s: %s\n", entry.Path, entry.Problem)
+	}
+}
+
+// Writes data to the named file atomically: the data is written to a temporary file in the same directory
+// which is then renamed over the target. If the process dies part way through, the previous contents
+// of the file (if any) are left intact rather than a truncated file.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tempName, filename)
+	}
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
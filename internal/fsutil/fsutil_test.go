@@ -0,0 +1,125 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativeSlashPath(t *testing.T) {
+	root := filepath.Join("archive", "DEC_0001")
+	target := filepath.Join(root, "manuals", "vax", "ek-vaxar-hb.pdf")
+	relative, err := RelativeSlashPath(root, target)
+	if err != nil || relative != "manuals/vax/ek-vaxar-hb.pdf" {
+		t.Fatalf(`RelativeSlashPath(%s, %s) = %s, %v`, root, target, relative, err)
+	}
+
+	if JoinSlashPath(root, relative) != target {
+		t.Fatalf(`JoinSlashPath(%s, %s) = %s, expected %s`, root, relative, JoinSlashPath(root, relative), target)
+	}
+}
+
+func TestExactEntryExists(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "INDEX.HTM"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+
+	if !ExactEntryExists(dir, "INDEX.HTM") {
+		t.Fatalf(`ExactEntryExists(INDEX.HTM) = false, expected true`)
+	}
+	// This must be false even on a case-insensitive filesystem
+	if ExactEntryExists(dir, "index.htm") {
+		t.Fatalf(`ExactEntryExists(index.htm) = true, expected false`)
+	}
+}
+
+func TestCheckDirAvailable(t *testing.T) {
+	dir := t.TempDir()
+	if err := CheckDirAvailable(dir); err == nil {
+		t.Errorf(`CheckDirAvailable() accepted an empty directory`)
+	}
+	if err := CheckDirAvailable(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf(`CheckDirAvailable() accepted a missing directory`)
+	}
+	filename := filepath.Join(dir, "index.htm")
+	if err := os.WriteFile(filename, []byte("x"), 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+	if err := CheckDirAvailable(filename); err == nil {
+		t.Errorf(`CheckDirAvailable() accepted a file`)
+	}
+	if err := CheckDirAvailable(dir); err != nil {
+		t.Errorf(`CheckDirAvailable() = %v for a populated directory`, err)
+	}
+}
+
+func TestIsCaseSensitive(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "Mixed.txt"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+
+	// Work out the expected answer independently by checking whether the swapped-case name can be opened
+	_, err = os.Stat(filepath.Join(dir, "mIXED.TXT"))
+	expected := (err != nil)
+	if IsCaseSensitive(dir) != expected {
+		t.Fatalf(`IsCaseSensitive(%s) = %t, expected %t`, dir, !expected, expected)
+	}
+}
+
+func TestEscapeInvalidUTF8(t *testing.T) {
+	if EscapeInvalidUTF8(`café.pdf`) != `café.pdf` {
+		t.Fatalf(`EscapeInvalidUTF8() altered a valid UTF-8 name`)
+	}
+	if EscapeInvalidUTF8(`C:\DOS\caf\xE9.pdf`) != `C:\DOS\caf\xE9.pdf` {
+		t.Fatalf(`EscapeInvalidUTF8() altered a valid UTF-8 name with backslashes`)
+	}
+
+	latin1 := "caf\xe9.pdf"
+	if escaped := EscapeInvalidUTF8(latin1); escaped != `caf\xE9.pdf` {
+		t.Fatalf(`EscapeInvalidUTF8(%q) = %s, expected caf\xE9.pdf`, latin1, escaped)
+	}
+	if escaped := EscapeInvalidUTF8("a\\b\xe9"); escaped != `a\\b\xE9` {
+		t.Fatalf(`EscapeInvalidUTF8() = %s, expected a\\b\xE9`, escaped)
+	}
+}
+
+func TestCheckFilename(t *testing.T) {
+	if problems := CheckFilename("manuals/vax/ek-vaxar-hb.pdf"); len(problems) != 0 {
+		t.Fatalf(`CheckFilename() reported problems for a good name: %v`, problems)
+	}
+
+	longName := strings.Repeat("a", MaxNameLength+1) + ".pdf"
+	problems := CheckFilename("manuals/caf\xe9/" + longName)
+	if len(problems) != 2 {
+		t.Fatalf(`CheckFilename() = %v, expected two problems`, problems)
+	}
+
+	var report ProblemFilenames
+	if report.Check("manuals/caf\xe9.pdf") || (len(report.Entries) != 1) || (report.Entries[0].Path != `manuals/caf\xE9.pdf`) {
+		t.Fatalf(`ProblemFilenames.Check() recorded %#v`, report.Entries)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "catalog.yaml")
+	for _, content := range []string{"first", "second"} {
+		err := WriteFileAtomic(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf(`WriteFileAtomic(%s) failed: %v`, content, err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil || string(data) != content {
+			t.Fatalf(`after WriteFileAtomic(%s) file contains %q, %v`, content, data, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	if len(entries) != 1 {
+		t.Fatalf(`WriteFileAtomic() left %d files behind`, len(entries))
+	}
+}
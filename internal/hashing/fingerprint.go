@@ -0,0 +1,210 @@
+package hashing
+
+import (
+	"crypto/md5"
+	"docs-to-yaml/internal/redirect"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A partial fingerprint is a cheap stand-in for the MD5 checksum of a large file, especially a remote one: the MD5
+// checksum of just the first and last blocks of the file, together with its size. It is written as
+// BLOCK:SIZE:MD5, e.g. 65536:73400320:0123456789abcdef0123456789abcdef. A file no larger than two blocks is hashed
+// in full.
+//
+// Two files with different fingerprints are certainly different, so a fingerprint can rule out a match without
+// downloading either file; two files with the same fingerprint are only probably the same.
+
+// The block size used when none is given
+const DefaultFingerprintBlock = 64 * 1024
+
+// ErrRangeNotSupported is returned by FingerprintURL when the server ignores the Range header, and so would send the
+// whole file
+var ErrRangeNotSupported = errors.New("server does not support range requests")
+
+// Returns the lengths of the head and tail blocks of a file of the given size. They never overlap.
+func fingerprintBlocks(size int64, block int64) (int64, int64) {
+	head := min(block, size)
+	tail := min(block, size-head)
+	return head, tail
+}
+
+// Formats a fingerprint from the head and tail blocks of a file
+func formatFingerprint(size int64, block int64, head []byte, tail []byte) string {
+	digest := md5.New()
+	digest.Write(head)
+	digest.Write(tail)
+	return fmt.Sprintf("%d:%d:%s", block, size, hex.EncodeToString(digest.Sum(nil)))
+}
+
+// Computes the fingerprint of a file of the given size, read from r, using blocks of the given size
+func Fingerprint(r io.ReaderAt, size int64, block int64) (string, error) {
+	headLength, tailLength := fingerprintBlocks(size, block)
+	head := make([]byte, headLength)
+	if _, err := r.ReadAt(head, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	tail := make([]byte, tailLength)
+	if _, err := r.ReadAt(tail, size-tailLength); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return formatFingerprint(size, block, head, tail), nil
+}
+
+// Computes the fingerprint of a local file
+func FingerprintFile(filename string, block int64) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	return Fingerprint(file, info.Size(), block)
+}
+
+// Computes the fingerprint of the named file in fsys (e.g. an archive root). Only the two blocks are read from a file
+// that can be read at any offset, as a local one can; any other is read through, discarding the middle.
+func FingerprintFS(fsys fs.FS, name string, block int64) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		return Fingerprint(readerAt, size, block)
+	}
+	headLength, tailLength := fingerprintBlocks(size, block)
+	head := make([]byte, headLength)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(io.Discard, file, size-headLength-tailLength); err != nil {
+		return "", err
+	}
+	tail := make([]byte, tailLength)
+	if _, err := io.ReadFull(file, tail); err != nil {
+		return "", err
+	}
+	return formatFingerprint(size, block, head, tail), nil
+}
+
+// Computes the fingerprint of a remote file of the given format with (at most) two HTTP Range requests, one for each
+// block, so that only those blocks are downloaded. Returns ErrRangeNotSupported if the server would send the whole file
+// instead. Also returns the redirects followed to reach the file (see internal/redirect); a redirect to a web page
+// rather than the file is an error.
+func FingerprintURL(client *http.Client, url string, format string, block int64) (string, redirect.Chain, error) {
+	head, size, chain, err := fetchRange(client, url, format, 0, block)
+	if chain.Landing {
+		return "", chain, fmt.Errorf("%s: redirected to a web page at %s", url, chain.Hops[len(chain.Hops)-1])
+	}
+	if err != nil {
+		return "", chain, err
+	}
+	headLength, tailLength := fingerprintBlocks(size, block)
+	if int64(len(head)) != headLength {
+		return "", chain, fmt.Errorf("%s: expected %d bytes, received %d", url, headLength, len(head))
+	}
+	var tail []byte
+	if tailLength > 0 {
+		// The tail is fetched from wherever the head was found
+		tail, _, _, err = fetchRange(client, chain.Hops[len(chain.Hops)-1], format, size-tailLength, tailLength)
+		if err != nil {
+			return "", chain, err
+		}
+		if int64(len(tail)) != tailLength {
+			return "", chain, fmt.Errorf("%s: expected %d bytes, received %d", url, tailLength, len(tail))
+		}
+	}
+	return formatFingerprint(size, block, head, tail), chain, nil
+}
+
+// Fetches length bytes of a remote file, starting at offset, following any redirects. Also returns the size of the
+// whole file, as given by the Content-Range header of the response, and the redirects followed.
+func fetchRange(client *http.Client, url string, format string, offset int64, length int64) ([]byte, int64, redirect.Chain, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, redirect.Chain{}, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, chain, err := redirect.Follow(client, request, format)
+	if err != nil {
+		return nil, 0, chain, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Servers send an empty file whole, as it has no range to send
+		if resp.ContentLength == 0 {
+			return nil, 0, chain, nil
+		}
+		return nil, 0, chain, ErrRangeNotSupported
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty file has no bytes to ask for
+		if size, err := rangeSize(resp.Header.Get("Content-Range")); (err == nil) && (size == 0) {
+			return nil, 0, chain, nil
+		}
+		fallthrough
+	default:
+		return nil, 0, chain, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	size, err := rangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, chain, fmt.Errorf("%s: %w", url, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, length))
+	return data, size, chain, err
+}
+
+// Returns the size of the whole file from a Content-Range header, e.g. "bytes 0-65535/73400320" or "bytes */0"
+func rangeSize(contentRange string) (int64, error) {
+	_, total, found := strings.Cut(contentRange, "/")
+	if !found || (total == "*") {
+		return 0, fmt.Errorf("no file size in Content-Range %q", contentRange)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// Reports whether two fingerprints show that their files are different: their sizes differ or, if they were taken
+// with the same block size, their digests differ. A blank or malformed fingerprint never shows a difference.
+func FingerprintsDiffer(a string, b string) bool {
+	aBlock, aSize, aDigest, aOK := parseFingerprint(a)
+	bBlock, bSize, bDigest, bOK := parseFingerprint(b)
+	if !aOK || !bOK {
+		return false
+	}
+	return (aSize != bSize) || ((aBlock == bBlock) && (aDigest != bDigest))
+}
+
+// Returns the size of the file recorded in a fingerprint. Returns false if the fingerprint is blank or malformed.
+func FingerprintSize(fingerprint string) (int64, bool) {
+	_, size, _, ok := parseFingerprint(fingerprint)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	return n, err == nil
+}
+
+func parseFingerprint(fingerprint string) (string, string, string, bool) {
+	parts := strings.Split(fingerprint, ":")
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
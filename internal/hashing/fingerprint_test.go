@@ -0,0 +1,135 @@
+package hashing
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	data := syntheticData(10000)
+	// A file no larger than two blocks is hashed in full
+	small, err := Fingerprint(bytes.NewReader(data[:30]), 30, 16)
+	if (err != nil) || (small != "16:30:"+mustMd5(data[:30])) {
+		t.Errorf(`Fingerprint() of a small file = %s, %v`, small, err)
+	}
+	large, err := Fingerprint(bytes.NewReader(data), int64(len(data)), 1024)
+	if (err != nil) || (large != "1024:10000:"+mustMd5(append(append([]byte{}, data[:1024]...), data[len(data)-1024:]...))) {
+		t.Errorf(`Fingerprint() of a large file = %s, %v`, large, err)
+	}
+
+	// Only the blocks at each end count
+	changed := bytes.Clone(data)
+	changed[5000] ^= 0xff
+	if middle, _ := Fingerprint(bytes.NewReader(changed), int64(len(changed)), 1024); middle != large {
+		t.Errorf(`Fingerprint() depends on the middle of the file`)
+	}
+	changed[9999] ^= 0xff
+	if end, _ := Fingerprint(bytes.NewReader(changed), int64(len(changed)), 1024); !FingerprintsDiffer(end, large) {
+		t.Errorf(`Fingerprint() does not depend on the end of the file`)
+	}
+
+	filename := filepath.Join(t.TempDir(), "doc.pdf")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+	if fromFile, err := FingerprintFile(filename, 1024); (err != nil) || (fromFile != large) {
+		t.Errorf(`FingerprintFile() = %s, %v`, fromFile, err)
+	}
+	fsys := fstest.MapFS{"doc.pdf": {Data: data}}
+	if fromFS, err := FingerprintFS(fsys, "doc.pdf", 1024); (err != nil) || (fromFS != large) {
+		t.Errorf(`FingerprintFS() = %s, %v`, fromFS, err)
+	}
+	if sequential, err := FingerprintFS(sequentialFS{fsys}, "doc.pdf", 1024); (err != nil) || (sequential != large) {
+		t.Errorf(`FingerprintFS() of a file that can only be read through = %s, %v`, sequential, err)
+	}
+}
+
+// sequentialFS hides every method of its files but those of fs.File, as a remote archive root does
+type sequentialFS struct{ fs.FS }
+
+func (s sequentialFS) Open(name string) (fs.File, error) {
+	file, err := s.FS.Open(name)
+	return struct{ fs.File }{file}, err
+}
+
+func TestFingerprintURL(t *testing.T) {
+	data := syntheticData(10000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch r.URL.Path {
+		case "/doc.pdf":
+			http.ServeContent(w, r, "doc.pdf", time.Time{}, bytes.NewReader(data))
+		case "/old/doc.pdf":
+			http.Redirect(w, r, "/doc.pdf", http.StatusMovedPermanently)
+		case "/moved.pdf":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Not here</html>"))
+		case "/empty.pdf":
+			http.ServeContent(w, r, "empty.pdf", time.Time{}, bytes.NewReader(nil))
+		default:
+			w.Write(data) // Ignores the Range header
+		}
+	}))
+	defer server.Close()
+
+	expected, _ := Fingerprint(bytes.NewReader(data), int64(len(data)), 1024)
+	if fingerprint, _, err := FingerprintURL(server.Client(), server.URL+"/doc.pdf", "PDF", 1024); (err != nil) || (fingerprint != expected) {
+		t.Errorf(`FingerprintURL() = %s, %v, expected %s`, fingerprint, err, expected)
+	}
+	if strings.Join(ranges, " ") != "bytes=0-1023 bytes=8976-9999" {
+		t.Errorf(`FingerprintURL() requested %q`, ranges)
+	}
+	fingerprint, chain, err := FingerprintURL(server.Client(), server.URL+"/old/doc.pdf", "PDF", 1024)
+	if (err != nil) || (fingerprint != expected) || (chain.Canonical() != server.URL+"/doc.pdf") {
+		t.Errorf(`FingerprintURL() through a redirect = %s, %+v, %v`, fingerprint, chain, err)
+	}
+	if _, _, err := FingerprintURL(server.Client(), server.URL+"/moved.pdf", "PDF", 1024); (err == nil) || !strings.Contains(err.Error(), "web page") {
+		t.Errorf(`FingerprintURL() redirected to a web page gave %v`, err)
+	}
+	if fingerprint, _, err := FingerprintURL(server.Client(), server.URL+"/empty.pdf", "PDF", 1024); (err != nil) || (fingerprint != "1024:0:"+mustMd5(nil)) {
+		t.Errorf(`FingerprintURL() of an empty file = %s, %v`, fingerprint, err)
+	}
+	if _, _, err := FingerprintURL(server.Client(), server.URL+"/no-ranges.pdf", "PDF", 1024); !errors.Is(err, ErrRangeNotSupported) {
+		t.Errorf(`FingerprintURL() without range support gave %v`, err)
+	}
+}
+
+func TestFingerprintsDiffer(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"1024:10000:aaaa", "1024:10000:aaaa", false},
+		{"1024:10000:aaaa", "1024:10000:bbbb", true},
+		{"1024:10000:aaaa", "1024:20000:aaaa", true},
+		{"1024:10000:aaaa", "2048:10000:bbbb", false}, // Different blocks cannot be compared
+		{"2048:10000:aaaa", "1024:20000:bbbb", true},  // but different sizes can
+		{"", "1024:10000:aaaa", false},
+		{"garbage", "1024:10000:aaaa", false},
+	}
+	for _, test := range tests {
+		if differ := FingerprintsDiffer(test.a, test.b); differ != test.expected {
+			t.Errorf(`FingerprintsDiffer(%q, %q) = %v`, test.a, test.b, differ)
+		}
+	}
+	if size, found := FingerprintSize("1024:10000:aaaa"); !found || (size != 10000) {
+		t.Errorf(`FingerprintSize() = %d, %v`, size, found)
+	}
+}
+
+func mustMd5(data []byte) string {
+	digests, _ := HashReader(bytes.NewReader(data), MD5)
+	return digests.Md5
+}
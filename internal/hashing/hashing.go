@@ -0,0 +1,140 @@
+package hashing
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"docs-to-yaml/internal/metrics"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// This package computes one or more digests of a file while reading it only once.
+// Every requested hash is fed from a single read of the data via io.MultiWriter, so asking for
+// MD5 and SHA-256 (and a CRC for legacy verification) costs one pass over the file rather than three.
+// The file is streamed, so large files are never held in memory.
+
+// Algorithm is a bitmask selecting which digests to compute
+type Algorithm uint
+
+// These are the supported digest algorithms
+const (
+	MD5    Algorithm = 1 << iota // MD5, as recorded in Document.Md5 and md5sums files
+	SHA256                       // SHA-256
+	CRC32                        // CRC-32 (IEEE), for legacy verification
+)
+
+// Digests holds the result of hashing a file. Only the requested digests are filled in;
+// each is a lowercase hex string.
+type Digests struct {
+	Md5    string
+	Sha256 string
+	Crc32  string
+	Size   int64 // Number of bytes hashed
+}
+
+// Computes the requested digests of everything read from r in a single pass.
+func HashReader(r io.Reader, algorithms Algorithm) (Digests, error) {
+	var writers []io.Writer
+	var md5Hash, sha256Hash hash.Hash
+	var crc32Hash hash.Hash32
+
+	if algorithms&MD5 != 0 {
+		md5Hash = md5.New()
+		writers = append(writers, md5Hash)
+	}
+	if algorithms&SHA256 != 0 {
+		sha256Hash = sha256.New()
+		writers = append(writers, sha256Hash)
+	}
+	if algorithms&CRC32 != 0 {
+		crc32Hash = crc32.NewIEEE()
+		writers = append(writers, crc32Hash)
+	}
+
+	var digests Digests
+	start := time.Now()
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return digests, err
+	}
+	metrics.AddHashed(size, start)
+	digests.Size = size
+
+	if md5Hash != nil {
+		digests.Md5 = hex.EncodeToString(md5Hash.Sum(nil))
+	}
+	if sha256Hash != nil {
+		digests.Sha256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	}
+	if crc32Hash != nil {
+		digests.Crc32 = hex.EncodeToString(crc32Hash.Sum(nil))
+	}
+	return digests, nil
+}
+
+// Computes the requested digests of the specified file in a single pass.
+func HashFile(filename string, algorithms Algorithm) (Digests, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Digests{}, err
+	}
+	defer file.Close()
+	return HashReader(file, algorithms)
+}
+
+// Convenience function that returns just the MD5 checksum of the specified file.
+func Md5File(filename string) (string, error) {
+	digests, err := HashFile(filename, MD5)
+	return digests.Md5, err
+}
+
+// Computes the requested digests of the named file in fsys (e.g. a remote archive root) in a single pass.
+func HashFS(fsys fs.FS, name string, algorithms Algorithm) (Digests, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return Digests{}, err
+	}
+	defer file.Close()
+	return HashReader(file, algorithms)
+}
+
+// Convenience function that returns just the MD5 checksum of the named file in fsys (e.g. a remote archive root).
+func Md5FS(fsys fs.FS, name string) (string, error) {
+	digests, err := HashFS(fsys, name, MD5)
+	return digests.Md5, err
+}
+
+// Checksums is what the MD5 store (see local-archive-to-yaml) holds for a file. An entry is the MD5 checksum, followed
+// by the SHA-256 checksum if that has been computed too ("MD5 SHA256"), so a store written before SHA-256 checksums
+// were recorded, in any of its formats, still reads as one with only MD5 checksums.
+type Checksums struct {
+	Md5    string
+	Sha256 string
+}
+
+// Reads a store entry
+func ParseChecksums(entry string) Checksums {
+	var checksums Checksums
+	fields := strings.Fields(entry)
+	if len(fields) > 0 {
+		checksums.Md5 = fields[0]
+	}
+	if len(fields) > 1 {
+		checksums.Sha256 = fields[1]
+	}
+	return checksums
+}
+
+// Returns the store entry
+func (c Checksums) String() string {
+	if c.Sha256 == "" {
+		return c.Md5
+	}
+	return c.Md5 + " " + c.Sha256
+}
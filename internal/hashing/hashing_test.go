@@ -0,0 +1,135 @@
+package hashing
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHashReader(t *testing.T) {
+	digests, err := HashReader(strings.NewReader("The quick brown fox jumps over the lazy dog"), MD5|SHA256|CRC32)
+	if err != nil {
+		t.Fatalf(`HashReader() failed: %v`, err)
+	}
+	if digests.Md5 != "9e107d9d372bb6826bd81d3542a419d6" {
+		t.Fatalf(`HashReader() MD5 = %s`, digests.Md5)
+	}
+	if digests.Sha256 != "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592" {
+		t.Fatalf(`HashReader() SHA-256 = %s`, digests.Sha256)
+	}
+	if digests.Crc32 != "414fa339" {
+		t.Fatalf(`HashReader() CRC-32 = %s`, digests.Crc32)
+	}
+	if digests.Size != 43 {
+		t.Fatalf(`HashReader() Size = %d`, digests.Size)
+	}
+}
+
+func TestHashFileOnlyRequested(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "doc.txt")
+	err := os.WriteFile(filename, []byte("The quick brown fox jumps over the lazy dog"), 0644)
+	if err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+
+	digests, err := HashFile(filename, MD5)
+	if err != nil || (digests.Md5 != "9e107d9d372bb6826bd81d3542a419d6") || (digests.Sha256 != "") || (digests.Crc32 != "") {
+		t.Fatalf(`HashFile(MD5) = %#v, %v`, digests, err)
+	}
+}
+
+func TestMd5FS(t *testing.T) {
+	fsys := fstest.MapFS{"vax/doc.txt": {Data: []byte("The quick brown fox jumps over the lazy dog")}}
+	md5, err := Md5FS(fsys, "vax/doc.txt")
+	if (err != nil) || (md5 != "9e107d9d372bb6826bd81d3542a419d6") {
+		t.Fatalf(`Md5FS() = %s, %v`, md5, err)
+	}
+	if _, err := Md5FS(fsys, "vax/missing.txt"); err == nil {
+		t.Fatalf(`Md5FS() of a missing file succeeded`)
+	}
+	if digests, err := HashFS(fsys, "vax/doc.txt", MD5); (err != nil) || (digests.Size != 43) {
+		t.Fatalf(`HashFS() = %+v, %v`, digests, err)
+	}
+}
+
+// Benchmarks. Run them with "go test -bench . ./internal/..." and compare runs with benchstat.
+
+// Returns size bytes of repeatable, incompressible-looking data
+func syntheticData(size int) []byte {
+	data := make([]byte, size)
+	state := uint32(2463534242)
+	for i := range data {
+		state ^= state << 13
+		state ^= state >> 17
+		state ^= state << 5
+		data[i] = byte(state)
+	}
+	return data
+}
+
+func BenchmarkHashReaderMd5(b *testing.B) {
+	data := syntheticData(4 << 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := HashReader(bytes.NewReader(data), MD5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashReaderAll(b *testing.B) {
+	data := syntheticData(4 << 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := HashReader(bytes.NewReader(data), MD5|SHA256|CRC32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Hashes every file of a synthetic tree on disk (100 directories of 10 files of 64KiB), as a tree scan does
+func BenchmarkMd5FSTree(b *testing.B) {
+	root := b.TempDir()
+	data := syntheticData(64 << 10)
+	var names []string
+	for d := 0; d < 100; d++ {
+		dir := fmt.Sprintf("dir%03d", d)
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < 10; f++ {
+			name := fmt.Sprintf("%s/doc%02d.pdf", dir, f)
+			if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+				b.Fatal(err)
+			}
+			names = append(names, name)
+		}
+	}
+	fsys := os.DirFS(root)
+	b.SetBytes(int64(len(names) * len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			if _, err := Md5FS(fsys, name); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	for entry, expected := range map[string]Checksums{
+		"9e107d9d372bb6826bd81d3542a419d6":          {Md5: "9e107d9d372bb6826bd81d3542a419d6"},
+		"9e107d9d372bb6826bd81d3542a419d6 d7a8fbb3": {Md5: "9e107d9d372bb6826bd81d3542a419d6", Sha256: "d7a8fbb3"},
+		"": {},
+	} {
+		checksums := ParseChecksums(entry)
+		if (checksums != expected) || (checksums.String() != entry) {
+			t.Errorf("ParseChecksums(%q) = %+v, giving %q", entry, checksums, checksums.String())
+		}
+	}
+}
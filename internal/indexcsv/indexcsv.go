@@ -0,0 +1,229 @@
+package indexcsv
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/textnorm"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// This package reads and writes the index.csv format described in INDEX-CSV.md.
+// All tools that consume or produce index.csv should do so via this package so that
+// the format can evolve in one place.
+//
+// Every record has exactly eight fields:
+//
+// | Field #  | Contents             | Record member
+// |----------|----------------------|----------------
+// |       1  | _Record type_        | .Type
+// |       2  | _Title_              | .Title
+// |       3  | _Local file path_    | .Filepath
+// |       4  | _Original URL_       | .Url
+// |       5  | _Document date_      | .Date
+// |       6  | _Part number_        | .PartNum
+// |       7  | _MD5 Checksum_       | .Md5
+// |       8  | _Options_            | .Options
+//
+// The first line of the file may be a header record (see Header). It is recognised by its
+// first field being "Record" and is skipped when reading. Records with fewer than eight fields
+// are padded with blank fields; records with more than eight fields are rejected.
+
+// The conventional name of the index file found at the root of an archive tree
+const IndexFilename = "index.csv"
+
+// The number of fields in every index.csv record
+const NumFields = 8
+
+// These are the legal values for the first ("Record type") field
+const (
+	RecordDoc        = "Doc"
+	RecordSection    = "Section"
+	RecordSubsection = "Subsection"
+	RecordVersion    = "Version"
+)
+
+// The header record written at the start of every index.csv
+var Header = []string{"Record", "Title", "File", "URL", "Date", "Part Number", "MD5 Checksum", "Options"}
+
+// The Record struct represents a single line of an index.csv file
+type Record struct {
+	Type     string // Record type: "Doc", "Section", "Subsection" or "Version"
+	Title    string // Document (or section) title
+	Filepath string // Path of the document relative to the index.csv
+	Url      string // URL from which the document was originally obtained
+	Date     string // Publication date
+	PartNum  string // Part number
+	Md5      string // MD5 checksum, if known
+	Options  string // Options, e.g. 'collection=local'
+}
+
+// Returns true if this record describes a document
+func (rec Record) IsDocument() bool {
+	return rec.Type == RecordDoc
+}
+
+// Returns the record as a slice of fields, in index.csv order
+func (rec Record) Fields() []string {
+	return []string{rec.Type, rec.Title, rec.Filepath, rec.Url, rec.Date, rec.PartNum, rec.Md5, rec.Options}
+}
+
+// Returns the record with its descriptive fields transliterated to 7-bit ASCII (see textnorm.ToASCII), as required
+// for the index.csv of an archive. The file path and URL are not changed: they must still name the file, and
+// file-tree-to-yaml already reports file paths that are not ASCII.
+func (rec Record) ASCII() Record {
+	rec.Title = textnorm.ToASCII(rec.Title)
+	rec.Date = textnorm.ToASCII(rec.Date)
+	rec.PartNum = textnorm.ToASCII(rec.PartNum)
+	rec.Options = textnorm.ToASCII(rec.Options)
+	return rec
+}
+
+// Builds a Record from a slice of CSV fields.
+// Missing trailing fields are treated as blank; too many fields is an error.
+// The descriptive fields have their accented letters composed (see internal/textnorm); paths, URLs and checksums are kept exactly.
+func RecordFromFields(fields []string) (Record, error) {
+	if len(fields) > NumFields {
+		return Record{}, fmt.Errorf("index.csv record has %d fields, expected at most %d", len(fields), NumFields)
+	}
+	padded := make([]string, NumFields)
+	copy(padded, fields)
+	return Record{
+		Type:     padded[0],
+		Title:    textnorm.ComposeLatin(padded[1]),
+		Filepath: padded[2],
+		Url:      padded[3],
+		Date:     textnorm.ComposeLatin(padded[4]),
+		PartNum:  textnorm.ComposeLatin(padded[5]),
+		Md5:      padded[6],
+		Options:  textnorm.ComposeLatin(padded[7]),
+	}, nil
+}
+
+// Returns true if the supplied fields look like the header record
+func isHeader(fields []string) bool {
+	return (len(fields) > 0) && strings.EqualFold(strings.TrimSpace(fields[0]), Header[0])
+}
+
+// Reads all index.csv records from the supplied reader.
+// A header record, if present as the first line, is skipped.
+func Read(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return FromRows(rows)
+}
+
+// Builds index.csv records from rows of fields, e.g. the rows of a spreadsheet.
+// A header record, if present as the first row, is skipped.
+func FromRows(rows [][]string) ([]Record, error) {
+	var records []Record
+	for i, fields := range rows {
+		if (i == 0) && isHeader(fields) {
+			continue
+		}
+		rec, err := RecordFromFields(fields)
+		if err != nil {
+			return records, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Reads all index.csv records from the specified file.
+func ReadFile(filename string) ([]Record, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Writes a header record followed by the supplied records to the supplied writer.
+func Write(w io.Writer, records []Record) error {
+	writer, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		err = writer.Write(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Writer writes records one at a time, for an index too large to hold in memory
+type Writer struct {
+	csv *csv.Writer
+}
+
+// Writes a header record to the supplied writer, and returns a Writer for the records that follow it
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Header); err != nil {
+		return nil, err
+	}
+	return &Writer{csv: writer}, nil
+}
+
+// Writes a record
+func (w *Writer) Write(rec Record) error {
+	return w.csv.Write(rec.Fields())
+}
+
+// Writes any buffered records, returning any error that occurred writing them
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// Writes a complete index.csv (header included) to the specified file.
+func WriteFile(filename string, records []Record) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = Write(file, records)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Builds a "Doc" Record from a Document.
+//
+// The options field records the collection: 'collection=X' taken from Document.Collection
+func RecordFromDocument(doc document.Document) Record {
+	return Record{
+		Type:     RecordDoc,
+		Title:    doc.Title,
+		Filepath: doc.Filepath,
+		Url:      doc.PublicUrl,
+		Date:     doc.PubDate,
+		PartNum:  doc.PartNum,
+		Md5:      doc.Md5,
+		Options:  fmt.Sprintf("'collection=%s'", doc.Collection),
+	}
+}
+
+// Copies the document-related fields of a Record into the supplied Document.
+// Fields that are not represented in index.csv are left untouched.
+func UpdateDocumentFromRecord(doc *document.Document, rec Record) {
+	doc.Title = rec.Title
+	doc.Filepath = rec.Filepath
+	doc.PublicUrl = rec.Url
+	doc.PubDate = rec.Date
+	doc.PartNum = rec.PartNum
+	doc.Md5 = rec.Md5
+}
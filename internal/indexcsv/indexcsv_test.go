@@ -0,0 +1,117 @@
+package indexcsv
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var sampleCsv = `Record,Title,File,URL,Date,Part Number,MD5 Checksum,Options
+Version,1.0,,,,,,
+Section,Manuals,,,,,,
+Doc,"VAX Architecture, Handbook",manuals/ek-vaxar-hb.pdf,http://example.com/ek-vaxar-hb.pdf,1981,EK-VAXAR-HB-001,0123456789abcdef0123456789abcdef,'collection=local'
+Subsection,Short
+`
+
+func TestRead(t *testing.T) {
+	records, err := Read(strings.NewReader(sampleCsv))
+	if err != nil {
+		t.Fatalf(`Read(sampleCsv) failed: %v`, err)
+	}
+	if len(records) != 4 {
+		t.Fatalf(`Read(sampleCsv) returned %d records, expected 4 (header should be skipped)`, len(records))
+	}
+
+	expected := Record{RecordDoc, "VAX Architecture, Handbook", "manuals/ek-vaxar-hb.pdf", "http://example.com/ek-vaxar-hb.pdf", "1981", "EK-VAXAR-HB-001", "0123456789abcdef0123456789abcdef", "'collection=local'"}
+	if !reflect.DeepEqual(records[2], expected) || !records[2].IsDocument() {
+		t.Fatalf(`Read(sampleCsv) Doc record = %#v, expected %#v`, records[2], expected)
+	}
+
+	// A short record is padded with blank fields
+	if (records[3].Type != RecordSubsection) || (records[3].Title != "Short") || (records[3].Options != "") {
+		t.Fatalf(`Read(sampleCsv) short record = %#v`, records[3])
+	}
+}
+
+func TestReadRejectsTooManyFields(t *testing.T) {
+	_, err := Read(strings.NewReader("Doc,a,b,c,d,e,f,g,h\n"))
+	if err == nil {
+		t.Fatalf(`Read() accepted a record with 9 fields`)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	records, err := Read(strings.NewReader(sampleCsv))
+	if err != nil {
+		t.Fatalf(`Read(sampleCsv) failed: %v`, err)
+	}
+
+	var buffer bytes.Buffer
+	err = Write(&buffer, records)
+	if err != nil {
+		t.Fatalf(`Write() failed: %v`, err)
+	}
+	if !strings.HasPrefix(buffer.String(), strings.Join(Header, ",")+"\n") {
+		t.Fatalf(`Write() did not start with the header: %s`, buffer.String())
+	}
+
+	reread, err := Read(&buffer)
+	if err != nil {
+		t.Fatalf(`Read() of written data failed: %v`, err)
+	}
+	if !reflect.DeepEqual(records, reread) {
+		t.Fatalf("Round trip mismatch:\n%#v\n%#v", records, reread)
+	}
+}
+
+func TestDocumentConversion(t *testing.T) {
+	doc := document.Document{Title: "Title", Filepath: "a/b.pdf", PublicUrl: "http://x/b.pdf", PubDate: "1983-03", PartNum: "EK-ABCDE-AA-001", Md5: "abc", Collection: "local"}
+	rec := RecordFromDocument(doc)
+	if (rec.Type != RecordDoc) || (rec.Options != "'collection=local'") {
+		t.Fatalf(`RecordFromDocument() = %#v`, rec)
+	}
+
+	var newDoc document.Document
+	newDoc.Collection = "untouched"
+	UpdateDocumentFromRecord(&newDoc, rec)
+	doc.Collection = "untouched"
+	if !reflect.DeepEqual(doc, newDoc) {
+		t.Fatalf(`UpdateDocumentFromRecord() = %#v, expected %#v`, newDoc, doc)
+	}
+}
+
+func TestASCII(t *testing.T) {
+	rec, _ := RecordFromFields([]string{RecordDoc, "Manuel d'utilisation \u2014 Te\u0301le\u0301type", "manuels/t\u00e9l\u00e9type.pdf", "", "1984", "EK-\u00c9T-001", "", "'collection=local'"})
+	if rec.Title != "Manuel d'utilisation \u2014 T\u00e9l\u00e9type" {
+		t.Errorf(`RecordFromFields() did not compose the title: %q`, rec.Title)
+	}
+	ascii := rec.ASCII()
+	if (ascii.Title != "Manuel d'utilisation - Teletype") || (ascii.PartNum != "EK-ET-001") || (ascii.Filepath != rec.Filepath) {
+		t.Errorf(`ASCII() = %#v`, ascii)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	var data bytes.Buffer
+	records := []Record{{Type: RecordVersion, Title: "1.0"}}
+	for i := 0; i < 5000; i++ {
+		if i%100 == 0 {
+			records = append(records, Record{Type: RecordSection, Title: fmt.Sprintf("Section %d", i/100)})
+		}
+		records = append(records, Record{Type: RecordDoc, Title: fmt.Sprintf("VAX Document %d, Second edition", i), Filepath: fmt.Sprintf("vax/doc%05d.pdf", i),
+			Date: "1985-06", PartNum: fmt.Sprintf("EK-%05d-UG-001", i), Md5: fmt.Sprintf("%032x", i), Options: "'collection=local'"})
+	}
+	if err := Write(&data, records); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(data.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if read, err := Read(bytes.NewReader(data.Bytes())); (err != nil) || (len(read) != len(records)) {
+			b.Fatalf(`Read() = %d records, %v`, len(read), err)
+		}
+	}
+}
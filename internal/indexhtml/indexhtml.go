@@ -0,0 +1,280 @@
+package indexhtml
+
+import (
+	"docs-to-yaml/internal/textnorm"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// This package parses the index HTML files found on the locally archived optical media.
+//
+// There are four known layouts (see Layout). All of them are hand-written HTML with one table row per link,
+// so they are parsed with regular expressions rather than a full HTML parser. Being hand-written, they are not always
+// consistent, so each table row is matched on its own: a row that does not fit the layout is skipped (and recorded,
+// with its line, in Index.Skipped) without disturbing its neighbours.
+//
+// Files are read through an fs.FS so that the parsing can be tested without a real archive volume.
+// Everything that needs the real filesystem (matching link targets to files, hashing them, etc.)
+// is left to the caller.
+
+// Layout identifies which of the known index HTML layouts a file uses
+type Layout int
+
+const (
+	// A list of documents. This is the index.htm of a "regular" volume, and also every sub-index.
+	LayoutDocuments Layout = iota
+	// An INDEX.HTM whose links all point to sub-indexes in HTML/ (link targets are upper-cased)
+	LayoutHTMLContents
+	// An index.htm whose links all point to sub-indexes in metadata/
+	LayoutMetadataContents
+	// The one index.htm (DEC_0040) that links both to documents and to sub-indexes (any target ending in .htm)
+	LayoutCustom
+)
+
+// This turns Layout enums into a text string
+func (layout Layout) String() string {
+	return [...]string{"Documents", "HTMLContents", "MetadataContents", "Custom"}[layout]
+}
+
+// Entry describes one document linked from an index HTML file
+type Entry struct {
+	Target  string // Link target, exactly as written in the HTML (relative to the HTML file)
+	PartNum string // Part number (or an analogue)
+	Title   string // Document title
+}
+
+// Index is the result of parsing an index HTML file
+type Index struct {
+	Entries    []Entry  // Documents linked from the file
+	SubIndexes []string // Links to further index files (relative to this file), each of which uses LayoutDocuments
+	Skipped    []Skip   // Table rows holding a link that could not be read, in file order
+}
+
+// Skip describes a link in a table row that does not fit the file's layout
+type Skip struct {
+	Line int    // The line, counting from 1, on which the link starts
+	Text string // That line, without surrounding whitespace
+}
+
+// Parse reports this (wrapped) when a file has no recognisable links at all
+var ErrNoMatches = errors.New("no matches found")
+
+// Each document entry looks like this:
+//
+//	<TR VALIGN=TOP>
+//	<TD> <A HREF="decmate/ssm.txt"> DEC-S8-OSSMB-A-D
+//	<TD> OS/8 SOFTWARE SUPPORT MANUAL
+//	</TR>
+//
+// Exceptionally, an entry in DEC_0002 looks like this:
+//
+//	<TR> <TD VALIGN=TOP>
+//	<A HREF="../manuals/internal/pvaxfw.pdf"> PVAX FW </A>
+//	<TD> Functional Specification for PVAX0 System Firmware Rev 0.3</TR>
+var documentsRegex = regexp.MustCompile(`(?ms)<TR(?:>\s*<TD)?\s+VALIGN=TOP>.*?(?:<TD>)?\s*<A HREF=\"(.*?)\">\s+(.*?)(?:</A>)?\s+<TD>\s+(.*?)</TR>`)
+var htmlContentsRegex = regexp.MustCompile(`(?m)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>\s+<\/TD>`)
+var metadataContentsRegex = regexp.MustCompile(`(?ms)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>`)
+var customRegex = regexp.MustCompile(`(?ms)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>\s*?<TD>\s*(.*?)\s*</TR>`)
+var breakRegex = regexp.MustCompile(`\s*<BR>(?:\s*<BR>\s*)*\s*`)
+
+var linkRegex = regexp.MustCompile(`<A HREF=`)
+var rowRegex = regexp.MustCompile(`<TR|</TABLE>`)
+
+// Parses the contents of an index HTML file that uses the specified layout.
+// Each table row is matched separately, and a link in a row that cannot be read is skipped (see Index.Skipped), so
+// that one malformed row costs only itself. It is an error (ErrNoMatches) for a file to contain no recognisable links.
+func Parse(data []byte, layout Layout) (Index, error) {
+	var index Index
+	var regex *regexp.Regexp
+	switch layout {
+	case LayoutDocuments:
+		regex = documentsRegex
+	case LayoutHTMLContents:
+		regex = htmlContentsRegex
+	case LayoutMetadataContents:
+		regex = metadataContentsRegex
+	case LayoutCustom:
+		regex = customRegex
+	default:
+		return index, fmt.Errorf("unknown index layout %d", layout)
+	}
+	text := string(data)
+	matches, skipped := matchRows(text, regex)
+	index.Skipped = skipped
+	for _, match := range matches {
+		switch {
+		case layout == LayoutDocuments:
+			index.Entries = append(index.Entries, Entry{Target: match[1], PartNum: textnorm.ComposeLatin(strings.TrimSpace(match[2])), Title: TidyTitle(match[3])})
+		case layout == LayoutHTMLContents:
+			index.SubIndexes = append(index.SubIndexes, strings.ToUpper(match[1]))
+		case layout == LayoutMetadataContents:
+			index.SubIndexes = append(index.SubIndexes, match[1])
+		case strings.HasSuffix(match[1], ".htm"):
+			index.SubIndexes = append(index.SubIndexes, match[1])
+		default:
+			index.Entries = append(index.Entries, Entry{Target: match[1], PartNum: textnorm.ComposeLatin(strings.TrimSpace(match[2])), Title: textnorm.ComposeLatin(strings.TrimSpace(match[3]))})
+		}
+	}
+	if (len(index.Entries) == 0) && (len(index.SubIndexes) == 0) {
+		return index, fmt.Errorf("%w for layout %s", ErrNoMatches, layout)
+	}
+	return index, nil
+}
+
+// Matches regex against each table row of text in turn (a row runs from one "<TR" to the next, or to "</TABLE>"), so
+// that no match can run on from a malformed row into the next. Returns the submatches of every match with a link
+// target, in file order, and every link in a row that is not part of one. Links outside the rows (e.g. navigation
+// before or after the table) are ignored.
+func matchRows(text string, regex *regexp.Regexp) ([][]string, []Skip) {
+	var matches [][]string
+	var skipped []Skip
+	boundaries := rowRegex.FindAllStringIndex(text, -1)
+	if (len(boundaries) == 0) || (boundaries[0][0] > 0) {
+		boundaries = append([][]int{{0, 0}}, boundaries...)
+	}
+	for i, boundary := range boundaries {
+		start, end := boundary[0], len(text)
+		if i+1 < len(boundaries) {
+			end = boundaries[i+1][0]
+		}
+		row := text[start:end]
+		isRow := strings.HasPrefix(row, "<TR")
+		var matched [][]int
+		for _, location := range regex.FindAllStringSubmatchIndex(row, -1) {
+			if location[3] > location[2] {
+				matches = append(matches, submatches(row, location))
+				matched = append(matched, location)
+			}
+		}
+		if !isRow {
+			continue
+		}
+		for _, link := range linkRegex.FindAllStringIndex(row, -1) {
+			if !slices.ContainsFunc(matched, func(location []int) bool { return (link[0] >= location[0]) && (link[0] < location[1]) }) {
+				skipped = append(skipped, skipAt(text, start+link[0]))
+			}
+		}
+	}
+	return matches, skipped
+}
+
+// Returns the submatches of s described by location (as given by FindStringSubmatchIndex)
+func submatches(s string, location []int) []string {
+	match := make([]string, len(location)/2)
+	for i := range match {
+		if location[2*i] >= 0 {
+			match[i] = s[location[2*i]:location[2*i+1]]
+		}
+	}
+	return match
+}
+
+// Describes the line of text holding the byte at offset
+func skipAt(text string, offset int) Skip {
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	lineEnd := strings.IndexByte(text[offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(text)
+	} else {
+		lineEnd += offset
+	}
+	return Skip{Line: strings.Count(text[:offset], "\n") + 1, Text: strings.TrimSpace(text[lineStart:lineEnd])}
+}
+
+// Reads and parses the named index HTML file from fsys.
+func ReadFile(fsys fs.FS, name string, layout Layout) (Index, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Index{}, err
+	}
+	index, err := Parse(data, layout)
+	if err != nil {
+		return index, fmt.Errorf("%s: %w", name, err)
+	}
+	return index, nil
+}
+
+// Returns the paths of any directories found beneath dir in fsys.
+// The sub-index directories (HTML/ and metadata/) are expected to be flat, so anything returned here deserves a warning.
+func FindSubdirectories(fsys fs.FS, dir string) ([]string, error) {
+	var subdirectories []string
+	err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path != dir) {
+			subdirectories = append(subdirectories, path)
+		}
+		return nil
+	})
+	return subdirectories, err
+}
+
+// SubIndexCheck is the outcome of checking the links in a top-level index against the files in its sub-index directory
+type SubIndexCheck struct {
+	Resolved     map[string]string // Each link that names a file => the name of that file in fsys
+	Unreferenced []string          // Files in the sub-index directory that no link names, sorted
+	Dangling     []string          // Links that name no file, in link order
+}
+
+// Checks that the links (relative to the root of fsys, as in Index.SubIndexes) and the files in the sub-index directory
+// dir (HTML or metadata) match one to one. Names are compared without regard to case, as the volumes were written on
+// a case-insensitive filesystem, and links are cleaned first, so "./html/a.htm" names HTML/A.HTM.
+// A link outside dir names a file only if that file exists with exactly that name.
+func CheckSubIndexes(fsys fs.FS, dir string, links []string) (SubIndexCheck, error) {
+	check := SubIndexCheck{Resolved: make(map[string]string)}
+	files := make(map[string]string) // lower-case name => name
+	err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files[strings.ToLower(name)] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return check, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, link := range links {
+		name := path.Clean(strings.ReplaceAll(link, "\\", "/"))
+		if file, found := files[strings.ToLower(name)]; found {
+			check.Resolved[link] = file
+			referenced[file] = true
+		} else if _, err := fs.Stat(fsys, name); (err == nil) && !strings.HasPrefix(strings.ToLower(name), strings.ToLower(dir)+"/") {
+			check.Resolved[link] = name
+		} else if !slices.Contains(check.Dangling, link) {
+			check.Dangling = append(check.Dangling, link)
+		}
+	}
+	for _, file := range files {
+		if !referenced[file] {
+			check.Unreferenced = append(check.Unreferenced, file)
+		}
+	}
+	sort.Strings(check.Unreferenced)
+	return check, nil
+}
+
+// Clean up a document title that has been read from HTML.
+//
+//	o remove leading/trailing whitespace
+//	o remove CRLF
+//	o collapse duplicate whitespace
+//	o replace "<BR><BR>", " <BR>" and "<BR>" with something sensible
+//	o compose accented letters (see textnorm.ComposeLatin)
+func TidyTitle(untidyTitle string) string {
+	title := strings.TrimSpace(untidyTitle)
+	title = strings.Replace(title, "\r\n", "", -1)
+	title = strings.Join(strings.Fields(title), " ") // Collapse duplicate whitespace
+	title = breakRegex.ReplaceAllString(title, ". ")
+	return textnorm.ComposeLatin(title)
+}
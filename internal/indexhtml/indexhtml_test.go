@@ -0,0 +1,279 @@
+package indexhtml
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Each testdata/*.htm file is parsed with the layout named by its filename prefix and the result compared
+// against the matching .golden file. Run "go test ./internal/indexhtml -update" to regenerate the golden files
+// after a deliberate change, and review the differences before committing.
+var update = flag.Bool("update", false, "update the golden files")
+
+var layoutPrefixes = map[string]Layout{
+	"documents-":        LayoutDocuments,
+	"html-contents":     LayoutHTMLContents,
+	"metadata-contents": LayoutMetadataContents,
+	"custom":            LayoutCustom,
+}
+
+// Formats an Index one item per line, so that golden files are easy to read and to diff
+func formatIndex(index Index) string {
+	var result strings.Builder
+	for _, entry := range index.Entries {
+		result.WriteString("document " + entry.Target + " | " + entry.PartNum + " | " + entry.Title + "\n")
+	}
+	for _, subIndex := range index.SubIndexes {
+		result.WriteString("index " + subIndex + "\n")
+	}
+	return result.String()
+}
+
+func TestParseGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.htm"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf(`no testdata found: %v`, err)
+	}
+	for _, input := range inputs {
+		name := filepath.Base(input)
+		t.Run(name, func(t *testing.T) {
+			layout := Layout(-1)
+			for prefix, l := range layoutPrefixes {
+				if strings.HasPrefix(name, prefix) {
+					layout = l
+				}
+			}
+			if layout < 0 {
+				t.Fatalf(`no layout known for %s`, name)
+			}
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatalf(`cannot read %s: %v`, input, err)
+			}
+			index, err := Parse(data, layout)
+			if err != nil {
+				t.Fatalf(`Parse(%s, %s) failed: %v`, name, layout, err)
+			}
+			got := formatIndex(index)
+
+			golden := strings.TrimSuffix(input, ".htm") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf(`cannot write %s: %v`, golden, err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf(`cannot read %s: %v`, golden, err)
+			}
+			if got != string(expected) {
+				t.Fatalf("Parse(%s, %s) mismatch\ngot:\n%s\nexpected:\n%s", name, layout, got, expected)
+			}
+		})
+	}
+}
+
+func TestParseNoMatches(t *testing.T) {
+	for _, layout := range []Layout{LayoutDocuments, LayoutHTMLContents, LayoutMetadataContents, LayoutCustom} {
+		if _, err := Parse([]byte("<HTML><BODY>Nothing here</BODY></HTML>"), layout); !errors.Is(err, ErrNoMatches) {
+			t.Fatalf(`Parse(%s) accepted a file with no links`, layout)
+		}
+	}
+}
+
+func TestParseSkipsMalformedRows(t *testing.T) {
+	data := "<TABLE>\n" +
+		"<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/a.pdf\"> EK-A\n<TD> First manual\n</TR>\n" +
+		"<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/b.pdf\"> EK-B\n</TR>\n" +
+		"<TR VALIGN=TOP>\n<TD> <A HREF=\"\"> EK-C\n<TD> Third manual\n</TR>\n" +
+		"<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/d.pdf\"> EK-D\n<TD> Fourth manual\n</TR>\n" +
+		"</TABLE>\n<A HREF=\"../index.htm\">Back</A>\n"
+	index, err := Parse([]byte(data), LayoutDocuments)
+	if err != nil {
+		t.Fatalf(`Parse() failed: %v`, err)
+	}
+	expected := []Entry{{Target: "vax/a.pdf", PartNum: "EK-A", Title: "First manual"}, {Target: "vax/d.pdf", PartNum: "EK-D", Title: "Fourth manual"}}
+	if !reflect.DeepEqual(index.Entries, expected) {
+		t.Errorf(`Parse() = %#v, expected %#v`, index.Entries, expected)
+	}
+	expectedSkipped := []Skip{{Line: 7, Text: `<TD> <A HREF="vax/b.pdf"> EK-B`}, {Line: 10, Text: `<TD> <A HREF=""> EK-C`}}
+	if !reflect.DeepEqual(index.Skipped, expectedSkipped) {
+		t.Errorf(`Parse() skipped %#v, expected %#v`, index.Skipped, expectedSkipped)
+	}
+}
+
+// Parse must cope with any markup at all: it may find nothing, but it must not panic, and whatever it reports must be
+// consistent with its input
+func FuzzParse(f *testing.F) {
+	inputs, _ := filepath.Glob(filepath.Join("testdata", "*.htm"))
+	for _, input := range inputs {
+		if data, err := os.ReadFile(input); err == nil {
+			f.Add(data)
+		}
+	}
+	f.Add(syntheticIndex(3))
+	f.Add([]byte("<TR VALIGN=TOP><TD> <A HREF=\"a\"> <TD> </TR><TR><A HREF=\"\"></TR>"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lines := bytes.Count(data, []byte("\n")) + 1
+		for _, layout := range []Layout{LayoutDocuments, LayoutHTMLContents, LayoutMetadataContents, LayoutCustom} {
+			index, err := Parse(data, layout)
+			if (err == nil) != ((len(index.Entries) > 0) || (len(index.SubIndexes) > 0)) {
+				t.Errorf(`Parse(%s) = %d entries, %d sub-indexes and error %v`, layout, len(index.Entries), len(index.SubIndexes), err)
+			}
+			for _, entry := range index.Entries {
+				if entry.Target == "" {
+					t.Errorf(`Parse(%s) gave an entry without a target: %#v`, layout, entry)
+				}
+			}
+			previous := 0
+			for _, skip := range index.Skipped {
+				if (skip.Line < previous) || (skip.Line > lines) || !strings.Contains(skip.Text, "<A HREF=") {
+					t.Errorf(`Parse(%s) skipped %#v in a file of %d lines`, layout, skip, lines)
+				}
+				previous = skip.Line
+			}
+		}
+	})
+}
+
+func TestReadFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"HTML/VAX.HTM": {Data: []byte("<TR VALIGN=TOP>\n<TD> <A HREF=\"../VAX/A.PDF\"> EK-A\n<TD> A manual\n</TR>\n")},
+	}
+	index, err := ReadFile(fsys, "HTML/VAX.HTM", LayoutDocuments)
+	if err != nil {
+		t.Fatalf(`ReadFile() failed: %v`, err)
+	}
+	expected := []Entry{{Target: "../VAX/A.PDF", PartNum: "EK-A", Title: "A manual"}}
+	if !reflect.DeepEqual(index.Entries, expected) {
+		t.Fatalf(`ReadFile() = %#v, expected %#v`, index.Entries, expected)
+	}
+
+	_, err = ReadFile(fsys, "HTML/MISSING.HTM", LayoutDocuments)
+	if err == nil {
+		t.Fatalf(`ReadFile() of a missing file succeeded`)
+	}
+}
+
+func TestFindSubdirectories(t *testing.T) {
+	fsys := fstest.MapFS{
+		"HTML/VAX.HTM":       {Data: []byte("x")},
+		"HTML/OLD/PDP11.HTM": {Data: []byte("x")},
+		"metadata/vax.htm":   {Data: []byte("x")},
+	}
+	subdirectories, err := FindSubdirectories(fsys, "HTML")
+	if err != nil {
+		t.Fatalf(`FindSubdirectories() failed: %v`, err)
+	}
+	if !reflect.DeepEqual(subdirectories, []string{"HTML/OLD"}) {
+		t.Fatalf(`FindSubdirectories() = %v`, subdirectories)
+	}
+	subdirectories, err = FindSubdirectories(fsys, "metadata")
+	if (err != nil) || (len(subdirectories) != 0) {
+		t.Fatalf(`FindSubdirectories(metadata) = %v, %v`, subdirectories, err)
+	}
+}
+
+func TestCheckSubIndexes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.htm":          {Data: []byte("x")},
+		"HTML/VAX.HTM":       {Data: []byte("x")},
+		"HTML/PDP11.HTM":     {Data: []byte("x")},
+		"HTML/OLD/PDP8.HTM":  {Data: []byte("x")},
+		"HTML/UNLINKED.HTM":  {Data: []byte("x")},
+		"metadata/other.htm": {Data: []byte("x")},
+	}
+	links := []string{"HTML/VAX.HTM", "./html/pdp11.htm", "HTML/OLD/PDP8.HTM", "HTML/MISSING.HTM", "metadata/other.htm", "elsewhere.htm"}
+	check, err := CheckSubIndexes(fsys, "HTML", links)
+	if err != nil {
+		t.Fatalf(`CheckSubIndexes() failed: %v`, err)
+	}
+	expected := SubIndexCheck{
+		Resolved: map[string]string{
+			"HTML/VAX.HTM":       "HTML/VAX.HTM",
+			"./html/pdp11.htm":   "HTML/PDP11.HTM",
+			"HTML/OLD/PDP8.HTM":  "HTML/OLD/PDP8.HTM",
+			"metadata/other.htm": "metadata/other.htm",
+		},
+		Unreferenced: []string{"HTML/UNLINKED.HTM"},
+		Dangling:     []string{"HTML/MISSING.HTM", "elsewhere.htm"},
+	}
+	if !reflect.DeepEqual(check, expected) {
+		t.Errorf(`CheckSubIndexes() = %#v`, check)
+	}
+}
+
+func TestTidyTitle(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Test case 1: Trim whitespace
+		{"  Hello World  ", "Hello World"}, // Leading and trailing spaces
+
+		// Test case 2: Removing CRLF
+		{"Title\r\nwith CRLF", "Titlewith CRLF"}, // CRLF characters should be removed
+
+		// Test case 3: Collapsing multiple spaces into a single space
+		{"Hello     World", "Hello World"}, // Multiple spaces should collapse
+
+		// Test case 4: Handling <BR> tags
+		{"Hello <BR> World", "Hello. World"},      // Single <BR> should be replaced with ". "
+		{"Hello <BR><BR> World", "Hello. World"},  // Multiple <BR> should be replaced with ". "
+		{"Hello <BR> <BR> World", "Hello. World"}, // Spaces around <BR> should be handled
+		{"Hello World <BR>", "Hello World. "},     // <BR> at the end should be replaced
+
+		// Test case 5: Combination of multiple rules
+		{"  Hello <BR>  World  <BR><BR> !  ", "Hello. World. !"}, // Multiple issues: spaces, <BR>, etc.
+
+		// Test case 6: Empty string
+		{"", ""}, // Empty string should return empty string
+
+		// Test case 7: Only <BR> tags, should replace all <BR> tags with ". "
+		{"<BR><BR><BR>", ". "}, // All <BR> should be replaced with ". "
+
+		// Test case 8: Special case of leading and trailing <BR> tags
+		{"<BR>Hello World<BR>", ". Hello World. "}, // <BR> before and after should be replaced
+
+		// Test case 9: String with no spaces or <BR> tags (no change expected)
+		{"HelloWorld", "HelloWorld"}, // No spaces, no <BR> tags, should remain the same
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result := TidyTitle(test.input)
+			if result != test.expected {
+				t.Errorf("For input '%s', expected '%s' but got '%s'", test.input, test.expected, result)
+			}
+		})
+	}
+}
+
+// Builds a LayoutDocuments index with the specified number of entries, in the style of testdata/documents-regular.htm
+func syntheticIndex(entries int) []byte {
+	var html strings.Builder
+	html.WriteString("<HTML>\n<HEAD><TITLE>DEC_9999</TITLE></HEAD>\n<BODY>\n<TABLE>\n")
+	for i := 0; i < entries; i++ {
+		fmt.Fprintf(&html, "<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/doc%05d.pdf\"> EK-%05d-UG-001\n<TD> VAX Document %d<BR>\n     Second edition\n</TR>\n", i, i, i)
+	}
+	html.WriteString("</TABLE>\n</BODY>\n</HTML>\n")
+	return []byte(html.String())
+}
+
+func BenchmarkParseDocuments(b *testing.B) {
+	data := syntheticIndex(2000)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if index, err := Parse(data, LayoutDocuments); (err != nil) || (len(index.Entries) != 2000) {
+			b.Fatalf(`Parse() = %d entries, %v`, len(index.Entries), err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package interrupt
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/profiling"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// This package lets the long-running tools stop cleanly when interrupted.
+//
+// Once Watch has been called, the first SIGINT (Ctrl-C) or SIGTERM does not kill the process. Instead it
+// sets a flag that the tools poll (via Requested) between units of work: they stop accepting new work,
+// save their stores and any partial output, print a hint explaining how to resume and then call Exit.
+// A second signal means the user really does want to stop now, so the process exits immediately.
+
+// The exit status used when a run stops because it was interrupted (the conventional 128 + SIGINT)
+const ExitCode = 130
+
+// Returned by operations that decline to start new work because an interrupt has been received
+var ErrInterrupted = errors.New("interrupted")
+
+var requested atomic.Bool
+
+// Starts watching for SIGINT and SIGTERM. Call this once, early in main.
+func Watch() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Printf("\nReceived %s: finishing the current item and saving state (signal again to stop immediately)\n", sig)
+		requested.Store(true)
+		sig = <-signals
+		fmt.Printf("\nReceived %s again: stopping immediately without saving state\n", sig)
+		os.Exit(ExitCode)
+	}()
+}
+
+// Returns true once an interrupt has been received.
+func Requested() bool {
+	return requested.Load()
+}
+
+// Prints a hint explaining how to carry on from where the run stopped, then exits with ExitCode.
+// Call this after any state has been saved.
+func Exit(resumeHint string) {
+	metrics.Stop()
+	console.Summary(console.Yellow, "Run interrupted.")
+	if resumeHint != "" {
+		console.Summary("", resumeHint)
+	}
+	events.Emit(events.SeverityInfo, "interrupted", "", "Run interrupted. "+resumeHint)
+	events.Close()
+	profiling.Stop()
+	os.Exit(ExitCode)
+}
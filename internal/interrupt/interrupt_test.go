@@ -0,0 +1,29 @@
+//go:build !windows
+
+package interrupt
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFirstSignalSetsRequested(t *testing.T) {
+	Watch()
+	if Requested() {
+		t.Fatalf(`Requested() is true before any signal`)
+	}
+
+	err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf(`cannot signal self: %v`, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !Requested() {
+		if time.Now().After(deadline) {
+			t.Fatalf(`Requested() still false after SIGTERM`)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+package locator
+
+import (
+	"docs-to-yaml/internal/archivefs"
+	"flag"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// This package finds the file of a local document, given its catalog filepath: file:///VOLUME/path for a document on
+// an archive volume (as written by local-archive-to-yaml), or a path relative to a tree (as written by
+// file-tree-to-yaml). A volume is found under its own root (--volume VOLUME=PATH) or, failing that, as the
+// subdirectory of the same name of the archive root (--archive-root); a relative path is found under --tree-root.
+//
+// Every root is opened through internal/archivefs, so it may be a local directory or a remote one (sftp://, smb://,
+// s3://). Programs that read a document do so through the FS that Locate returns; those that must pass a native file
+// to another program (e.g. ocrmypdf or veraPDF) get one from LocalFile, which fetches a temporary copy of a remote file.
+
+// Locator finds the file of a local document, given its catalog filepath. It may be used by several goroutines at once.
+type Locator struct {
+	ArchiveRoot string            // Directory holding every volume as a subdirectory of the same name
+	VolumeRoots map[string]string // Volume name => root, taking precedence over ArchiveRoot
+	TreeRoot    string            // Root for filepaths relative to a tree (i.e. not file:///...)
+
+	mutex sync.Mutex
+	roots map[string]openedRoot
+}
+
+// openedRoot is a root that has been opened, or the reason it could not be
+type openedRoot struct {
+	fsys archivefs.FS
+	err  error
+}
+
+// Returns a locator whose roots are given by the --archive-root, --volume and --tree-root flags. Call before flag.Parse.
+func Flags() *Locator {
+	locator := &Locator{VolumeRoots: make(map[string]string)}
+	flag.Func("volume", "the root of a local archive volume, as VOLUME=PATH (may be repeated)", func(s string) error {
+		name, path, found := strings.Cut(s, "=")
+		if !found || (name == "") || (path == "") {
+			return fmt.Errorf("expected VOLUME=PATH, found %q", s)
+		}
+		locator.VolumeRoots[name] = path
+		return nil
+	})
+	flag.StringVar(&locator.ArchiveRoot, "archive-root", "", "directory that holds each local archive volume as a subdirectory of the same name")
+	flag.StringVar(&locator.TreeRoot, "tree-root", "", "root of the tree for filepaths that are relative (as written by file-tree-to-yaml)")
+	return locator
+}
+
+// Reports whether any root has been given, i.e. whether any local document can be found
+func (l *Locator) Configured() bool {
+	return (l.ArchiveRoot != "") || (len(l.VolumeRoots) > 0) || (l.TreeRoot != "")
+}
+
+// Returns the root holding a document and the document's name within it, given its catalog filepath. Each root is
+// checked once, when first used: the documents in a root that is missing or empty (e.g. a volume that is not mounted)
+// cannot be found. A filepath that would lead outside its root (e.g. through "..") is refused.
+func (l *Locator) Locate(catalogPath string) (archivefs.FS, string, error) {
+	root, name := l.TreeRoot, catalogPath
+	if relative, found := strings.CutPrefix(catalogPath, "file:///"); found {
+		volume, path, found := strings.Cut(relative, "/")
+		if !found {
+			return nil, "", fmt.Errorf("no volume in filepath")
+		}
+		if !fs.ValidPath(volume) || (volume == ".") || strings.ContainsAny(volume, "/\\") {
+			return nil, "", fmt.Errorf("invalid volume %q in filepath", volume)
+		}
+		if volumeRoot, ok := l.VolumeRoots[volume]; ok {
+			root = volumeRoot
+		} else if l.ArchiveRoot != "" {
+			root = archivefs.Join(l.ArchiveRoot, volume)
+		} else {
+			return nil, "", fmt.Errorf("no root supplied for volume %s", volume)
+		}
+		name = path
+	} else if strings.Contains(catalogPath, "://") {
+		return nil, "", fmt.Errorf("not a local document")
+	} else if root == "" {
+		return nil, "", fmt.Errorf("no --tree-root supplied for a relative filepath")
+	}
+	if !fs.ValidPath(name) || (name == ".") {
+		return nil, "", fmt.Errorf("filepath does not name a file within %s", root)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.roots == nil {
+		l.roots = make(map[string]openedRoot)
+	}
+	opened, found := l.roots[root]
+	if !found {
+		if opened.err = archivefs.CheckAvailable(root); opened.err == nil {
+			opened.fsys, opened.err = archivefs.Open(root)
+		}
+		if opened.err != nil {
+			opened.err = fmt.Errorf("root unavailable: %w", opened.err)
+		}
+		l.roots[root] = opened
+	}
+	return opened.fsys, name, opened.err
+}
+
+// Returns a native path for the file of a local document, for programs that cannot read through an FS (see
+// archivefs.LocalFile): the file itself in a local root, or a temporary copy of a file in a remote one. The caller must
+// call release once it has finished with the file.
+func (l *Locator) LocalFile(catalogPath string) (localPath string, release func(), err error) {
+	fsys, name, err := l.Locate(catalogPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return archivefs.LocalFile(fsys, name)
+}
+
+// Returns where the file of a local document lives, for messages: a native path for a local root, a URL for a remote one
+func (l *Locator) Location(catalogPath string) (string, error) {
+	fsys, name, err := l.Locate(catalogPath)
+	if err != nil {
+		return "", err
+	}
+	return fsys.Location(name), nil
+}
@@ -0,0 +1,47 @@
+package locator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	archiveRoot := t.TempDir()
+	volumeRoot := t.TempDir()
+	treeRoot := t.TempDir()
+	for _, dir := range []string{filepath.Join(archiveRoot, "DEC_0001"), volumeRoot, treeRoot} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf(`cannot create test directory: %v`, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644); err != nil {
+			t.Fatalf(`cannot create test file: %v`, err)
+		}
+	}
+	locator := &Locator{ArchiveRoot: archiveRoot, VolumeRoots: map[string]string{"DEC_0002": volumeRoot}}
+	fsys, name, err := locator.Locate("file:///DEC_0002/vax/ka630.pdf")
+	if (err != nil) || (name != "vax/ka630.pdf") || (fsys.LocalPath(name) != filepath.Join(volumeRoot, "vax", "ka630.pdf")) {
+		t.Errorf(`Locate() = %v, %s, %v`, fsys, name, err)
+	}
+	if fsys, name, err := locator.Locate("file:///DEC_0001/vax/ka630.pdf"); (err != nil) || (fsys.LocalPath(name) != filepath.Join(archiveRoot, "DEC_0001", "vax", "ka630.pdf")) {
+		t.Errorf(`Locate() via the archive root = %v, %s, %v`, fsys, name, err)
+	}
+	for _, catalogPath := range []string{"vax/ka630.pdf", "file:///DEC_0001/../../etc/passwd", "file:///DEC_0001/", "https://bitsavers.org/pdf/x.pdf", "file:///DEC_0003/unmounted.pdf", "file:///../x", "file:///./x", "file:///DEC_0001\\..\\../x"} {
+		if _, _, err := locator.Locate(catalogPath); err == nil {
+			t.Errorf(`Locate(%s) succeeded`, catalogPath)
+		}
+	}
+
+	locator.TreeRoot = treeRoot
+	if location, err := locator.Location("scans/rn.pdf"); (err != nil) || (location != filepath.Join(treeRoot, "scans", "rn.pdf")) {
+		t.Errorf(`Location() with a tree root = %q, %v`, location, err)
+	}
+	if localPath, release, err := locator.LocalFile("index.html"); (err != nil) || (localPath != filepath.Join(treeRoot, "index.html")) {
+		t.Errorf(`LocalFile() = %q, %v`, localPath, err)
+	} else {
+		release()
+		if _, err := os.Stat(localPath); err != nil {
+			t.Errorf(`release() removed a file in a local root`)
+		}
+	}
+}
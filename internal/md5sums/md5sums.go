@@ -0,0 +1,196 @@
+package md5sums
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// This package reads and writes files in the format produced by GNU md5sum.
+//
+// A line from md5sum looks like this:
+//
+//	4556f5bdf78aa195b18e06e35a64c89f *mvxaaig1.pdf
+//
+// That's exactly 32 characters of md5 checksum, a space, either a space (text mode) or an asterisk (binary mode)
+// and finally a filepath (relative to the md5sums file).
+// On Linux the checksum is the same whether binary mode is selected or not.
+//
+// If a filepath contains a backslash or a newline, md5sum escapes them as "\\" and "\n" and
+// marks the line by starting it with a backslash:
+//
+//	\4556f5bdf78aa195b18e06e35a64c89f  odd\nname.pdf
+
+// The conventional name of the md5sums file found at the root of an archive tree
+const Md5sumsFilename = "md5sums"
+
+// The Entry struct represents a single line of an md5sums file
+type Entry struct {
+	Md5      string // MD5 checksum as 32 lowercase hex digits
+	Filepath string // Unescaped filepath, relative to the md5sums file
+	Binary   bool   // True if the checksum was generated in binary mode ("*")
+}
+
+var md5Regex = regexp.MustCompile(`^(\\?)([a-fA-F0-9]{32})\s(\s|\*)(.+)$`)
+
+// Parses a single md5sums line into an Entry.
+func ParseLine(line string) (Entry, error) {
+	matches := md5Regex.FindStringSubmatch(line)
+	if matches == nil {
+		return Entry{}, fmt.Errorf("invalid md5sum format: %s", line)
+	}
+	filepath := matches[4]
+	if matches[1] == "\\" {
+		var err error
+		filepath, err = unescape(filepath)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	return Entry{Md5: strings.ToLower(matches[2]), Filepath: filepath, Binary: matches[3] == "*"}, nil
+}
+
+// Formats an Entry as a single md5sums line (without a trailing newline), escaping the filepath if necessary.
+func (entry Entry) String() string {
+	prefix := ""
+	filepath := entry.Filepath
+	if strings.ContainsAny(filepath, "\\\n\r") {
+		prefix = "\\"
+		filepath = escape(filepath)
+	}
+	mode := " "
+	if entry.Binary {
+		mode = "*"
+	}
+	return prefix + entry.Md5 + " " + mode + filepath
+}
+
+func escape(filepath string) string {
+	filepath = strings.ReplaceAll(filepath, "\\", "\\\\")
+	filepath = strings.ReplaceAll(filepath, "\n", "\\n")
+	return strings.ReplaceAll(filepath, "\r", "\\r")
+}
+
+func unescape(filepath string) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(filepath); i++ {
+		if filepath[i] != '\\' {
+			result.WriteByte(filepath[i])
+			continue
+		}
+		i += 1
+		if i >= len(filepath) {
+			return "", fmt.Errorf("invalid escape at end of filepath: %s", filepath)
+		}
+		switch filepath[i] {
+		case '\\':
+			result.WriteByte('\\')
+		case 'n':
+			result.WriteByte('\n')
+		case 'r':
+			result.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("invalid escape \\%c in filepath: %s", filepath[i], filepath)
+		}
+	}
+	return result.String(), nil
+}
+
+// Reads all entries from the supplied reader.
+// Blank lines are ignored. The first malformed line stops processing and is reported along with its line number.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	lineCount := 0
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		lineCount += 1
+		if line == "" {
+			continue
+		}
+		entry, err := ParseLine(line)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %w", lineCount, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Reads all entries from the specified md5sums file.
+func ReadFile(filename string) ([]Entry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Reads all entries from the named md5sums file in fsys (e.g. a remote tree root).
+func ReadFS(fsys fs.FS, name string) ([]Entry, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Writes the supplied entries, one per line, in the order given.
+func Write(w io.Writer, entries []Entry) error {
+	writer := bufio.NewWriter(w)
+	for _, entry := range entries {
+		_, err := writer.WriteString(entry.String() + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Writes the supplied entries to the specified file, sorted by filepath.
+func WriteFile(filename string, entries []Entry) error {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Filepath < sorted[j].Filepath
+	})
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = Write(file, sorted)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Converts a set of entries into a map of filepath => MD5 checksum.
+func ToMap(entries []Entry) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range entries {
+		result[entry.Filepath] = entry.Md5
+	}
+	return result
+}
+
+// Converts a map of filepath => MD5 checksum into a set of text mode entries, sorted by filepath.
+func FromMap(md5Map map[string]string) []Entry {
+	var entries []Entry
+	for path, md5 := range md5Map {
+		entries = append(entries, Entry{Md5: md5, Filepath: path})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Filepath < entries[j].Filepath
+	})
+	return entries
+}
@@ -0,0 +1,67 @@
+package md5sums
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseLine(t *testing.T) {
+	entry, err := ParseLine("4556f5bdf78aa195b18e06e35a64c89f *mvxaaig1.pdf")
+	if err != nil || !reflect.DeepEqual(entry, Entry{"4556f5bdf78aa195b18e06e35a64c89f", "mvxaaig1.pdf", true}) {
+		t.Fatalf(`ParseLine(binary) = %#v, %v`, entry, err)
+	}
+
+	entry, err = ParseLine("4556F5BDF78AA195B18E06E35A64C89F  path with spaces/doc.txt")
+	if err != nil || !reflect.DeepEqual(entry, Entry{"4556f5bdf78aa195b18e06e35a64c89f", "path with spaces/doc.txt", false}) {
+		t.Fatalf(`ParseLine(text) = %#v, %v`, entry, err)
+	}
+
+	entry, err = ParseLine(`\4556f5bdf78aa195b18e06e35a64c89f  odd\\dir\nname.pdf`)
+	if err != nil || (entry.Filepath != "odd\\dir\nname.pdf") {
+		t.Fatalf(`ParseLine(escaped) = %#v, %v`, entry, err)
+	}
+
+	_, err = ParseLine("4556f5bdf78aa195b18e06e35a64c89 short.pdf")
+	if err == nil {
+		t.Fatalf(`ParseLine() accepted a 31 digit checksum`)
+	}
+}
+
+func TestReadWriteRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{"0123456789abcdef0123456789abcdef", "index.csv", false},
+		{"4556f5bdf78aa195b18e06e35a64c89f", "manuals/mvxaaig1.pdf", true},
+		{"fedcba9876543210fedcba9876543210", "odd\\dir\nname.pdf", false},
+	}
+	var buffer bytes.Buffer
+	err := Write(&buffer, entries)
+	if err != nil {
+		t.Fatalf(`Write() failed: %v`, err)
+	}
+	if strings.Count(buffer.String(), "\n") != 3 {
+		t.Fatalf(`Write() produced unexpected number of lines: %q`, buffer.String())
+	}
+
+	reread, err := Read(&buffer)
+	if err != nil {
+		t.Fatalf(`Read() failed: %v`, err)
+	}
+	if !reflect.DeepEqual(entries, reread) {
+		t.Fatalf("Round trip mismatch:\n%#v\n%#v", entries, reread)
+	}
+
+	fsys := fstest.MapFS{Md5sumsFilename: {Data: []byte("4556f5bdf78aa195b18e06e35a64c89f *manuals/mvxaaig1.pdf\n")}}
+	if fromFS, err := ReadFS(fsys, Md5sumsFilename); (err != nil) || !reflect.DeepEqual(fromFS, entries[1:2]) {
+		t.Fatalf(`ReadFS() = %#v, %v`, fromFS, err)
+	}
+}
+
+func TestReadReportsLineNumber(t *testing.T) {
+	_, err := Read(strings.NewReader("0123456789abcdef0123456789abcdef  a.pdf\nrubbish\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf(`Read() error = %v, expected a line 2 error`, err)
+	}
+}
@@ -0,0 +1,67 @@
+package md5url
+
+import (
+	"docs-to-yaml/internal/persistentstore"
+	"strings"
+)
+
+// This package defines the MD5-to-URL store: a persistentstore that maps the MD5 checksum of a file to a URL from
+// which a copy of that file can be fetched. manx-to-yaml writes one (with --md5-output) from the copies listed in the
+// manx database, and any program that knows a file's checksum can use it to find a copy online, e.g. bitsavers-to-yaml
+// adds each URL to the locations of the document with that checksum.
+//
+// The key scheme is fixed so that every reader and writer agrees on it:
+//
+//	key    the MD5 checksum as 32 lowercase hexadecimal digits (see Key)
+//	value  an absolute http or https URL; where several copies have the same checksum, the first one found
+//
+// This is the reverse of bin/md5.store, which maps a URL or local file path to its MD5 checksum.
+
+// The conventional location of the store
+const DefaultFilename = "bin/md5-to-url.store"
+
+// Store maps an MD5 checksum (see Key) to the URL of a copy of the file
+type Store = persistentstore.Store[string, string]
+
+// Returns the store key for an MD5 checksum, or "" if it is not one
+func Key(md5 string) string {
+	md5 = strings.ToLower(strings.TrimSpace(md5))
+	if len(md5) != 32 || strings.Trim(md5, "0123456789abcdef") != "" {
+		return ""
+	}
+	return md5
+}
+
+// Returns an empty store, not backed by any file, to be filled in and written with SaveAs
+func New() *Store {
+	store, _ := Store{}.Init("", false, false)
+	return store
+}
+
+// Reads a store. It is an error if the file does not exist.
+func Open(filename string, verbose bool) (*Store, error) {
+	return Store{}.Init(filename, false, verbose)
+}
+
+// Records the URL of a copy of the file with the given MD5 checksum, unless a URL is already recorded for it.
+// Returns false if the checksum is not a valid key or already had a URL.
+func Add(store *Store, md5 string, url string) bool {
+	key := Key(md5)
+	if (key == "") || (url == "") {
+		return false
+	}
+	if _, found := store.Lookup(key); found {
+		return false
+	}
+	store.Update(key, url)
+	return true
+}
+
+// Returns the URL recorded for a copy of the file with the given MD5 checksum
+func Lookup(store *Store, md5 string) (string, bool) {
+	key := Key(md5)
+	if key == "" {
+		return "", false
+	}
+	return store.Lookup(key)
+}
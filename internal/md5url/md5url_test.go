@@ -0,0 +1,50 @@
+package md5url
+
+import (
+	"docs-to-yaml/internal/persistentstore"
+	"path/filepath"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		md5      string
+		expected string
+	}{
+		{"0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef"},
+		{" 0123456789ABCDEF0123456789ABCDEF\n", "0123456789abcdef0123456789abcdef"},
+		{"0123456789abcdef", ""},
+		{"PART: EK-KA630-TM-001", ""},
+		{"0123456789abcdefg123456789abcdef", ""},
+	}
+	for _, test := range tests {
+		if key := Key(test.md5); key != test.expected {
+			t.Errorf(`Key(%q) = %q, expected %q`, test.md5, key, test.expected)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := New()
+	if !Add(store, "0123456789ABCDEF0123456789ABCDEF", "http://bitsavers.org/pdf/dec/vax/ka630.pdf") {
+		t.Errorf(`Add() of a new checksum failed`)
+	}
+	if Add(store, "0123456789abcdef0123456789abcdef", "http://example.com/ka630.pdf") || Add(store, "bad", "http://example.com/bad.pdf") {
+		t.Errorf(`Add() replaced a URL or accepted a bad checksum`)
+	}
+
+	filename := filepath.Join(t.TempDir(), "md5-to-url.store")
+	if err := store.SaveAs(filename, persistentstore.FormatYAML); err != nil {
+		t.Fatalf(`SaveAs() failed: %v`, err)
+	}
+	loaded, err := Open(filename, false)
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	if url, found := Lookup(loaded, "0123456789abcdef0123456789ABCDEF"); !found || (url != "http://bitsavers.org/pdf/dec/vax/ka630.pdf") {
+		t.Errorf(`Lookup() = %q, %v`, url, found)
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.store"), false); err == nil {
+		t.Errorf(`Open() of a missing store succeeded`)
+	}
+}
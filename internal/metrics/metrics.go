@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// This package counts the work done during a run (cache hits and misses, bytes hashed and the time taken, exiftool
+// processes and the files they handled, HTTP requests made), so that the cost of a run can be seen and tracked over time.
+//
+// Counts are added wherever the work is done (see Add); each is attributed to the current scope (see SetScope), which
+// the tools set to the archive volume being processed. Start is called once, early in main, to ask for the counts to
+// be printed (with --statistics) and/or appended to a metrics log (with --metrics-log FILE) when the run ends. As with
+// the profiling package, the tools exit through the exitcode and interrupt packages, which call Stop before exiting.
+//
+// The metrics log holds one line of JSON (NDJSON) per run, e.g.
+//
+//	{"time":"2026-10-16T09:30:00Z","program":"local-archive-to-yaml","scopes":{"DEC_0001":{"bytes-hashed":1048576,...}},"total":{...},"mbps":52.1}
+
+// Counter names one of the things counted
+type Counter string
+
+// These are the counters
+const (
+	Md5CacheHits      Counter = "md5-cache-hits"     // MD5 checksums found in the MD5 store
+	Md5CacheMisses    Counter = "md5-cache-misses"   // MD5 checksums not found in the MD5 store
+	BytesHashed       Counter = "bytes-hashed"       // Bytes read to compute digests
+	HashNanoseconds   Counter = "hash-ns"            // Time spent computing digests, in nanoseconds
+	ExifCacheHits     Counter = "exif-cache-hits"    // PDF metadata found in the PDF metadata cache
+	ExifCacheMisses   Counter = "exif-cache-misses"  // PDF metadata not found in the PDF metadata cache
+	ExiftoolProcesses Counter = "exiftool-processes" // exiftool processes started
+	ExiftoolFiles     Counter = "exiftool-files"     // Files passed to exiftool
+	HttpRequests      Counter = "http-requests"      // HTTP requests sent
+)
+
+// Counts holds the value of each counter
+type Counts map[Counter]int64
+
+// Returns the rate at which data was hashed, in MB/s, or 0 if nothing was hashed
+func (c Counts) MBPerSecond() float64 {
+	if c[HashNanoseconds] <= 0 {
+		return 0
+	}
+	return float64(c[BytesHashed]) / 1e6 / time.Duration(c[HashNanoseconds]).Seconds()
+}
+
+// Record is one line of the metrics log
+type Record struct {
+	Time    time.Time         `json:"time"`
+	Program string            `json:"program"`
+	Scopes  map[string]Counts `json:"scopes,omitempty"` // The counts for each scope (e.g. volume), if any was set
+	Total   Counts            `json:"total"`
+	Mbps    float64           `json:"mbps"` // The hashing rate over the whole run, in MB/s
+}
+
+var (
+	mutex       sync.Mutex
+	scope       string
+	scopes      []string // In the order first used
+	counts      = make(map[string]Counts)
+	report      bool
+	logFilename string
+)
+
+// Arranges for the counts to be printed (if show is true) and appended to the metrics log logFile (unless it is
+// blank) when Stop is called.
+func Start(show bool, logFile string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	report, logFilename = show, logFile
+}
+
+// Sets the scope to which subsequent counts are attributed, normally the archive volume being processed. A blank name
+// means counts are not attributed to any volume.
+func SetScope(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	scope = name
+}
+
+// Adds n to a counter in the current scope. Add may be called concurrently.
+func Add(counter Counter, n int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, found := counts[scope]; !found {
+		counts[scope] = make(Counts)
+		scopes = append(scopes, scope)
+	}
+	counts[scope][counter] += n
+}
+
+// Records that a digest of size bytes took the time since start to compute
+func AddHashed(size int64, start time.Time) {
+	Add(BytesHashed, size)
+	Add(HashNanoseconds, int64(time.Since(start)))
+}
+
+// Returns a record of the counts so far, with the total over all scopes
+func Snapshot() Record {
+	mutex.Lock()
+	defer mutex.Unlock()
+	record := Record{Time: time.Now().UTC(), Program: filepath.Base(os.Args[0]), Total: make(Counts)}
+	for _, name := range scopes {
+		for counter, n := range counts[name] {
+			record.Total[counter] += n
+		}
+		if name != "" {
+			if record.Scopes == nil {
+				record.Scopes = make(map[string]Counts)
+			}
+			record.Scopes[name] = maps.Clone(counts[name])
+		}
+	}
+	record.Mbps = record.Total.MBPerSecond()
+	return record
+}
+
+// Prints the counts for each scope in the order first used, followed by the total if there was more than one scope.
+// Counters that are zero are left out.
+func Report(w io.Writer) {
+	record := Snapshot()
+	mutex.Lock()
+	names := slices.Clone(scopes)
+	mutex.Unlock()
+
+	named := 0
+	for _, name := range names {
+		if name != "" {
+			fmt.Fprintf(w, "Metrics for volume %s:\n", name)
+			writeCounts(w, record.Scopes[name])
+			named += 1
+		}
+	}
+	if (named != 1) || (len(names) > 1) {
+		fmt.Fprintf(w, "Metrics for the run:\n")
+		writeCounts(w, record.Total)
+	}
+}
+
+func writeCounts(w io.Writer, c Counts) {
+	if (c[Md5CacheHits] != 0) || (c[Md5CacheMisses] != 0) {
+		fmt.Fprintf(w, "  MD5 store:      %d hits, %d misses\n", c[Md5CacheHits], c[Md5CacheMisses])
+	}
+	if c[BytesHashed] != 0 {
+		elapsed := time.Duration(c[HashNanoseconds]).Round(time.Millisecond)
+		fmt.Fprintf(w, "  Hashed:         %d bytes in %s (%.1f MB/s)\n", c[BytesHashed], elapsed, c.MBPerSecond())
+	}
+	if (c[ExifCacheHits] != 0) || (c[ExifCacheMisses] != 0) {
+		fmt.Fprintf(w, "  Metadata cache: %d hits, %d misses\n", c[ExifCacheHits], c[ExifCacheMisses])
+	}
+	if (c[ExiftoolProcesses] != 0) || (c[ExiftoolFiles] != 0) {
+		fmt.Fprintf(w, "  exiftool:       %d processes, %d files\n", c[ExiftoolProcesses], c[ExiftoolFiles])
+	}
+	if c[HttpRequests] != 0 {
+		fmt.Fprintf(w, "  HTTP requests:  %d\n", c[HttpRequests])
+	}
+}
+
+// Appends a record of the counts so far to the metrics log, creating it if need be
+func AppendLog(filename string) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(Snapshot()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Prints and logs the counts, if Start asked for either. Problems are reported but are not fatal: a missing record
+// should not change the outcome of a run. Calling Stop more than once is harmless.
+func Stop() {
+	mutex.Lock()
+	show, filename := report, logFilename
+	report, logFilename = false, ""
+	mutex.Unlock()
+
+	if show {
+		Report(os.Stdout)
+	}
+	if filename != "" {
+		if err := AppendLog(filename); err != nil {
+			fmt.Printf("Cannot write metrics log: %s\n", err)
+		}
+	}
+}
+
+// Returns an http.RoundTripper that counts each request sent through base (http.DefaultTransport if nil)
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return countingTransport{base}
+}
+
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+func (t countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	Add(HttpRequests, 1)
+	return t.base.RoundTrip(request)
+}
+
+// Discards all counts and the current scope
+func reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	scope, scopes, counts = "", nil, make(map[string]Counts)
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounts(t *testing.T) {
+	reset()
+	defer reset()
+	SetScope("DEC_0001")
+	Add(Md5CacheHits, 3)
+	Add(Md5CacheMisses, 1)
+	Add(BytesHashed, 2_000_000)
+	Add(HashNanoseconds, int64(time.Second))
+	SetScope("DEC_0002")
+	Add(Md5CacheHits, 2)
+	SetScope("")
+
+	record := Snapshot()
+	if got := record.Scopes["DEC_0001"][Md5CacheHits]; got != 3 {
+		t.Errorf(`DEC_0001 hits: got %d, want 3`, got)
+	}
+	if got := record.Total[Md5CacheHits]; got != 5 {
+		t.Errorf(`total hits: got %d, want 5`, got)
+	}
+	if record.Mbps != 2.0 {
+		t.Errorf(`MB/s: got %f, want 2.0`, record.Mbps)
+	}
+
+	var report strings.Builder
+	Report(&report)
+	for _, want := range []string{"Metrics for volume DEC_0001:", "Metrics for volume DEC_0002:", "Metrics for the run:", "MD5 store:      5 hits, 1 misses", "(2.0 MB/s)"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf(`report lacks %q:\n%s`, want, report.String())
+		}
+	}
+	if strings.Contains(report.String(), "HTTP requests") {
+		t.Errorf(`report includes a counter that is zero:\n%s`, report.String())
+	}
+}
+
+func TestAppendLog(t *testing.T) {
+	reset()
+	defer reset()
+	filename := filepath.Join(t.TempDir(), "metrics.ndjson")
+	Add(ExiftoolFiles, 4)
+	for i := 0; i < 2; i++ {
+		if err := AppendLog(filename); err != nil {
+			t.Fatalf(`AppendLog() failed: %v`, err)
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf(`line %d is not a record: %v`, lines+1, err)
+		}
+		if (record.Total[ExiftoolFiles] != 4) || (record.Scopes != nil) {
+			t.Errorf(`line %d: got %+v`, lines+1, record)
+		}
+	}
+	if lines != 2 {
+		t.Errorf(`got %d lines, want 2`, lines)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	reset()
+	defer reset()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(nil)}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if got := Snapshot().Total[HttpRequests]; got != 3 {
+		t.Errorf(`HTTP requests: got %d, want 3`, got)
+	}
+}
@@ -0,0 +1,236 @@
+package naming
+
+import (
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/document"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// This package checks the file names on an archive volume against the naming conventions for its category (see
+// internal/archivecategory), e.g. that every file in HTML/ is upper case, as the links in INDEX.HTM are.
+//
+// The conventions are given by a Policy, which may be read from a YAML file keyed by category name:
+//
+//	html:
+//	  - dir: HTML
+//	    case: upper
+//	csv:
+//	  - portable: true
+//
+// A volume that breaks the conventions can be brought into line before it is burned again by running the shell script
+// written by WriteRenameScript. Only the files are renamed; links to them in the volume's index files are not changed.
+
+// Rule is one naming convention for the files under a directory of a volume
+type Rule struct {
+	Dir      string `yaml:",omitempty"` // Directory (relative to the volume root) whose files the rule covers; blank for the whole volume
+	Case     string `yaml:",omitempty"` // "upper" or "lower": the case of every file name; blank for either
+	Portable bool   `yaml:",omitempty"` // File names may use only A-Z, a-z, 0-9, '.', '_' and '-'
+}
+
+// The values of Rule.Case
+const (
+	Upper = "upper"
+	Lower = "lower"
+)
+
+// Policy gives the naming rules for each category of volume
+type Policy map[archivecategory.Category][]Rule
+
+// The policy used when none is supplied: the sub-indexes of an HTML volume are upper case, like the links to them,
+// and those of a metadata volume are lower case.
+var DefaultPolicy = Policy{
+	archivecategory.HTML:     {{Dir: "HTML", Case: Upper}},
+	archivecategory.Metadata: {{Dir: "metadata", Case: Lower}},
+}
+
+// Reads a policy from a YAML file keyed by category name. An empty filename gives DefaultPolicy.
+func ReadPolicy(filename string) (Policy, error) {
+	if filename == "" {
+		return DefaultPolicy, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var byName map[string][]Rule
+	if err := document.UnmarshalYaml(data, &byName); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	policy := make(Policy)
+	for name, rules := range byName {
+		category, err := archivecategory.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, rule := range rules {
+			if (rule.Case != "") && (rule.Case != Upper) && (rule.Case != Lower) {
+				return nil, fmt.Errorf("%s: %s: case must be upper or lower, not %q", filename, name, rule.Case)
+			}
+		}
+		policy[category] = rules
+	}
+	return policy, nil
+}
+
+// Violation is a file whose name breaks a rule
+type Violation struct {
+	Path      string // The file, relative to the volume root
+	Problem   string // How the name breaks the rule
+	Suggested string // The name the file should have, relative to the volume root; blank if it cannot be renamed
+}
+
+// Returns the conforming form of a file name, following the rule
+func (rule Rule) Conform(name string) string {
+	switch rule.Case {
+	case Upper:
+		name = strings.ToUpper(name)
+	case Lower:
+		name = strings.ToLower(name)
+	}
+	if rule.Portable {
+		name = strings.Map(func(r rune) rune {
+			if isPortable(r) {
+				return r
+			}
+			return '_'
+		}, name)
+	}
+	return name
+}
+
+func isPortable(r rune) bool {
+	return ((r >= 'A') && (r <= 'Z')) || ((r >= 'a') && (r <= 'z')) || ((r >= '0') && (r <= '9')) || (r == '.') || (r == '_') || (r == '-')
+}
+
+// Describes how a file name breaks the rule, or returns "" if it does not
+func (rule Rule) problem(name string) string {
+	var problems []string
+	if (rule.Case != "") && (Rule{Case: rule.Case}.Conform(name) != name) {
+		problems = append(problems, "not "+rule.Case+" case")
+	}
+	if rule.Portable && (strings.IndexFunc(name, func(r rune) bool { return !isPortable(r) }) >= 0) {
+		problems = append(problems, "has characters other than A-Z, a-z, 0-9, '.', '_' and '-'")
+	}
+	return strings.Join(problems, " and ")
+}
+
+// Checks the names of the files in fsys against the rules. A rule for a directory that does not exist is ignored.
+// Returns the violations, sorted by path. A file is only given a suggested name if no other file has (or would be
+// given) that name, ignoring case.
+func Check(fsys fs.FS, rules []Rule) ([]Violation, error) {
+	existing := make(map[string]int) // lower-case path => number of files with that name
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			existing[strings.ToLower(name)] += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string]*Violation)
+	for _, rule := range rules {
+		dir := path.Clean("./" + rule.Dir)
+		err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			problem := rule.problem(d.Name())
+			if problem == "" {
+				return nil
+			}
+			violation, found := byPath[name]
+			if !found {
+				violation = &Violation{Path: name, Suggested: name}
+				byPath[name] = violation
+			} else {
+				problem = violation.Problem + "; " + problem
+			}
+			violation.Problem = problem
+			violation.Suggested = path.Join(path.Dir(violation.Suggested), rule.Conform(path.Base(violation.Suggested)))
+			return nil
+		})
+		if (err != nil) && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	// A rename must not clash with another file, or with another rename
+	suggested := make(map[string]int)
+	for _, violation := range byPath {
+		suggested[strings.ToLower(violation.Suggested)] += 1
+	}
+	violations := make([]Violation, 0, len(byPath))
+	for _, violation := range byPath {
+		target := strings.ToLower(violation.Suggested)
+		clashes := existing[target]
+		if target == strings.ToLower(violation.Path) {
+			clashes -= 1 // Only the case changes, so the file itself is not a clash
+		}
+		if (clashes > 0) || (suggested[target] > 1) {
+			violation.Problem += fmt.Sprintf("; cannot be renamed to %s, which is already taken", violation.Suggested)
+			violation.Suggested = ""
+		}
+		violations = append(violations, *violation)
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Path < violations[j].Path })
+	return violations, nil
+}
+
+// Quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Escapes the control characters in a string (e.g. a newline in a file name) as \xNN, so that it cannot end a comment
+// in a shell script early
+func commentEscape(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			fmt.Fprintf(&escaped, "\\x%02x", r)
+		} else {
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+// Writes a POSIX shell script that renames each file that has a suggested name, working in the volume root. A rename
+// that only changes case is done in two steps, so that it also works on a case-insensitive filesystem.
+func WriteRenameScript(w io.Writer, root string, violations []Violation) error {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("# Renames the files that break the naming policy. Links to them in index files are not changed.\n")
+	script.WriteString("set -e\n")
+	fmt.Fprintf(&script, "cd %s\n", shellQuote(root))
+	for _, violation := range violations {
+		if violation.Suggested == "" {
+			fmt.Fprintf(&script, "# %s\n", commentEscape(violation.Path+": "+violation.Problem))
+			continue
+		}
+		if strings.EqualFold(violation.Path, violation.Suggested) {
+			temporary := violation.Path + ".rename"
+			fmt.Fprintf(&script, "mv -- %s %s && mv -- %s %s\n", shellQuote(violation.Path), shellQuote(temporary), shellQuote(temporary), shellQuote(violation.Suggested))
+		} else {
+			fmt.Fprintf(&script, "mv -n -- %s %s\n", shellQuote(violation.Path), shellQuote(violation.Suggested))
+		}
+	}
+	_, err := io.WriteString(w, script.String())
+	return err
+}
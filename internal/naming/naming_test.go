@@ -0,0 +1,80 @@
+package naming
+
+import (
+	"docs-to-yaml/internal/archivecategory"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheck(t *testing.T) {
+	fsys := fstest.MapFS{
+		"INDEX.HTM":       {Data: []byte("x")},
+		"HTML/VAX.HTM":    {Data: []byte("x")},
+		"HTML/pdp11.htm":  {Data: []byte("x")},
+		"HTML/Pdp8.htm":   {Data: []byte("x")},
+		"HTML/PDP8.HTM":   {Data: []byte("x")},
+		"vax/my file.pdf": {Data: []byte("x")},
+	}
+	rules := []Rule{{Dir: "HTML", Case: Upper}, {Dir: "missing", Case: Lower}, {Portable: true}}
+	violations, err := Check(fsys, rules)
+	if err != nil {
+		t.Fatalf("Check() failed: %s", err)
+	}
+	expected := []Violation{
+		{Path: "HTML/Pdp8.htm", Problem: "not upper case; cannot be renamed to HTML/PDP8.HTM, which is already taken"},
+		{Path: "HTML/pdp11.htm", Problem: "not upper case", Suggested: "HTML/PDP11.HTM"},
+		{Path: "vax/my file.pdf", Problem: "has characters other than A-Z, a-z, 0-9, '.', '_' and '-'", Suggested: "vax/my_file.pdf"},
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("Check() = %#v", violations)
+	}
+
+	var script strings.Builder
+	if err := WriteRenameScript(&script, "/mnt/DEC_0001", violations); err != nil {
+		t.Fatalf("WriteRenameScript() failed: %s", err)
+	}
+	for _, line := range []string{
+		"cd '/mnt/DEC_0001'\n",
+		"# HTML/Pdp8.htm: not upper case; cannot be renamed",
+		"mv -- 'HTML/pdp11.htm' 'HTML/pdp11.htm.rename' && mv -- 'HTML/pdp11.htm.rename' 'HTML/PDP11.HTM'\n",
+		"mv -n -- 'vax/my file.pdf' 'vax/my_file.pdf'\n",
+	} {
+		if !strings.Contains(script.String(), line) {
+			t.Errorf("WriteRenameScript() does not contain %q:\n%s", line, script.String())
+		}
+	}
+
+	// A file name cannot break out of a comment to run a command
+	script.Reset()
+	unrenamable := []Violation{{Path: "vax/x\nrm -rf ~\n.pdf", Problem: "not upper case"}}
+	if err := WriteRenameScript(&script, "/mnt/DEC_0001", unrenamable); err != nil {
+		t.Fatalf("WriteRenameScript() failed: %s", err)
+	}
+	if !strings.Contains(script.String(), "# vax/x\\x0arm -rf ~\\x0a.pdf: not upper case\n") || strings.Contains(script.String(), "\nrm") {
+		t.Errorf("WriteRenameScript() wrote a bare control character:\n%s", script.String())
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	if policy, err := ReadPolicy(""); (err != nil) || !reflect.DeepEqual(policy, DefaultPolicy) {
+		t.Errorf(`ReadPolicy("") = %v, %v`, policy, err)
+	}
+	filename := filepath.Join(t.TempDir(), "naming.yaml")
+	if err := os.WriteFile(filename, []byte("csv:\n  - portable: true\nhtml:\n  - dir: HTML\n    case: upper\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := Policy{archivecategory.CSV: {{Portable: true}}, archivecategory.HTML: {{Dir: "HTML", Case: Upper}}}
+	if policy, err := ReadPolicy(filename); (err != nil) || !reflect.DeepEqual(policy, expected) {
+		t.Errorf(`ReadPolicy() = %v, %v`, policy, err)
+	}
+	if err := os.WriteFile(filename, []byte("csv:\n  - case: title\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPolicy(filename); err == nil {
+		t.Errorf(`ReadPolicy() accepted case: title`)
+	}
+}
@@ -0,0 +1,319 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This package sends a short summary of a run (documents added, warnings, errors and bit rot found) by email and/or
+// to a webhook or an ntfy topic, so that a nightly run from cron is noticed when, and only when, something happened.
+// Where to send it is given by a YAML file (conventionally data/notify.yaml):
+//
+//	smtp:
+//	  host: smtp.example.org
+//	  port: 587
+//	  username: archive
+//	  from: archive@example.org
+//	  to: [me@example.org]
+//	webhook: https://hooks.example.org/archive
+//	ntfy: https://ntfy.sh/my-archive
+//	ignorewarnings: true
+//
+// Any of smtp, webhook and ntfy may be given. The SMTP password is best left out of the file and given in the
+// environment as DOCS_TO_YAML_SMTP_PASSWORD. A webhook receives the Summary as JSON, with its text in "text"; an ntfy
+// topic receives the text, titled with the subject, at high priority if anything failed.
+//
+// A run is noteworthy if it added or removed documents, or reported bit rot, errors or (unless ignorewarnings is set)
+// warnings; a quiet run sends nothing. A program either builds its Summary itself (as run-pipeline does from its
+// stages) or calls Watch, which counts the events it reports and sends the summary when it exits.
+
+// The environment variable that gives the SMTP password, if the configuration does not
+const PasswordVariable = "DOCS_TO_YAML_SMTP_PASSWORD"
+
+// The most findings of each kind listed in a summary's text; the rest are only counted
+const maxListed = 20
+
+// The kinds of event (see internal/events) that mean a file has decayed or been altered since it was recorded
+var BitRotTypes = []string{"changed-document", "unreadable-file", "md5-mismatch", "size-mismatch", "crc-mismatch", "seal-broken"}
+
+// SmtpConfig says how to send a summary by email
+type SmtpConfig struct {
+	Host     string
+	Port     int `yaml:",omitempty"` // 587 if not given
+	Username string
+	Password string `yaml:",omitempty"` // Taken from PasswordVariable if not given
+	From     string
+	To       []string
+}
+
+// Config says where to send summaries
+type Config struct {
+	Smtp           *SmtpConfig `yaml:",omitempty"`
+	Webhook        string      `yaml:",omitempty"` // A URL to receive the summary as JSON
+	Ntfy           string      `yaml:",omitempty"` // The URL of an ntfy topic to receive the summary's text
+	IgnoreWarnings bool        `yaml:",omitempty"` // Whether warnings alone are not worth a notification
+}
+
+// Reads the configuration, checking that it says where to send summaries
+func ReadConfig(filename string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	if err := document.UnmarshalYaml(data, &config); err != nil {
+		return config, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	if smtpConfig := config.Smtp; smtpConfig != nil {
+		if (smtpConfig.Host == "") || (smtpConfig.From == "") || (len(smtpConfig.To) == 0) {
+			return config, fmt.Errorf("%s: smtp needs a host, from and to", filename)
+		}
+		if smtpConfig.Port == 0 {
+			smtpConfig.Port = 587
+		}
+		if smtpConfig.Password == "" {
+			smtpConfig.Password = os.Getenv(PasswordVariable)
+		}
+	}
+	if (config.Smtp == nil) && (config.Webhook == "") && (config.Ntfy == "") {
+		return config, fmt.Errorf("%s: give at least one of smtp, webhook and ntfy", filename)
+	}
+	return config, nil
+}
+
+// Summary describes a run
+type Summary struct {
+	Program          string   `json:"program"`
+	Host             string   `json:"host"`
+	Outcome          string   `json:"outcome"` // The summary line of the run (see exitcode.Summary)
+	DocumentsAdded   int      `json:"documentsAdded"`
+	DocumentsRemoved int      `json:"documentsRemoved"`
+	Warnings         int      `json:"warnings"`
+	Errors           int      `json:"errors"`
+	Fatal            []string `json:"fatal,omitempty"`   // Why the run (or a stage of it) stopped
+	BitRot           []string `json:"bitRot,omitempty"`  // Each file found decayed or altered, as "TYPE PATH"
+	Details          []string `json:"details,omitempty"` // Anything else worth reading, e.g. the outcome of each stage
+}
+
+// Returns an empty summary of a run of the program on this host
+func NewSummary(program string) Summary {
+	host, _ := os.Hostname()
+	return Summary{Program: program, Host: host}
+}
+
+// Counts an event in the summary
+func (s *Summary) Add(event events.Event) {
+	switch {
+	case event.Type == "fatal":
+		s.Fatal = append(s.Fatal, event.Message)
+	case slices.Contains(BitRotTypes, event.Type):
+		location := event.Path
+		if event.Volume != "" {
+			location = event.Volume + ": " + location
+		}
+		s.BitRot = append(s.BitRot, event.Type+" "+location)
+	}
+	switch event.Severity {
+	case events.SeverityWarning:
+		s.Warnings += 1
+	case events.SeverityError:
+		if event.Type != "fatal" {
+			s.Errors += 1
+		}
+	}
+}
+
+// Counts the events recorded in an events file (see internal/events), e.g. by a program that this one ran. A missing
+// file has no events.
+func (s *Summary) AddEventsFile(filename string) error {
+	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event events.Event
+		if json.Unmarshal(scanner.Bytes(), &event) == nil {
+			s.Add(event)
+		}
+	}
+	return scanner.Err()
+}
+
+// Reports whether the run is worth a notification
+func (s Summary) Noteworthy(config Config) bool {
+	return (s.DocumentsAdded > 0) || (s.DocumentsRemoved > 0) || (s.Errors > 0) || (len(s.Fatal) > 0) || (len(s.BitRot) > 0) || ((s.Warnings > 0) && !config.IgnoreWarnings)
+}
+
+// Returns whether the run needs attention: it failed, or found errors or bit rot
+func (s Summary) Urgent() bool {
+	return (s.Errors > 0) || (len(s.Fatal) > 0) || (len(s.BitRot) > 0)
+}
+
+// Returns a one-line subject, e.g. "verify-catalog on nas: 2 bit-rot findings, 2 errors"
+func (s Summary) Subject() string {
+	var parts []string
+	add := func(count int, one string, many string) {
+		if count == 1 {
+			parts = append(parts, "1 "+one)
+		} else if count > 1 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, many))
+		}
+	}
+	if len(s.Fatal) > 0 {
+		parts = append(parts, "FAILED")
+	}
+	add(len(s.BitRot), "bit-rot finding", "bit-rot findings")
+	add(s.Errors, "error", "errors")
+	add(s.Warnings, "warning", "warnings")
+	add(s.DocumentsAdded, "document added", "documents added")
+	add(s.DocumentsRemoved, "document removed", "documents removed")
+	if len(parts) == 0 {
+		parts = append(parts, "nothing to report")
+	}
+	return fmt.Sprintf("%s on %s: %s", s.Program, s.Host, strings.Join(parts, ", "))
+}
+
+// Returns the text of the summary
+func (s Summary) Text() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n", s.Subject())
+	if s.Outcome != "" {
+		fmt.Fprintf(&text, "%s\n", s.Outcome)
+	}
+	fmt.Fprintf(&text, "\nDocuments added:   %d\nDocuments removed: %d\nWarnings:          %d\nErrors:            %d\n", s.DocumentsAdded, s.DocumentsRemoved, s.Warnings, s.Errors)
+	list := func(heading string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&text, "\n%s:\n", heading)
+		for i, line := range lines {
+			if i == maxListed {
+				fmt.Fprintf(&text, "  ... and %d more\n", len(lines)-maxListed)
+				break
+			}
+			fmt.Fprintf(&text, "  %s\n", line)
+		}
+	}
+	list("Fatal errors", s.Fatal)
+	list("Bit rot", s.BitRot)
+	if len(s.Details) > 0 {
+		fmt.Fprintf(&text, "\n%s\n", strings.Join(s.Details, "\n"))
+	}
+	return text.String()
+}
+
+// The HTTP client used for webhooks and ntfy
+var httpClient = &http.Client{Timeout: time.Minute, Transport: metrics.Transport(nil)}
+
+// Sends an email. Tests replace this to avoid needing an SMTP server.
+var sendMail = smtp.SendMail
+
+// Sends the summary to everywhere the configuration gives, whether or not it is noteworthy. Returns the errors from
+// any that failed; the others are still sent to.
+func Send(config Config, summary Summary) error {
+	var errs []error
+	if config.Smtp != nil {
+		if err := sendEmail(*config.Smtp, summary); err != nil {
+			errs = append(errs, fmt.Errorf("smtp: %w", err))
+		}
+	}
+	if config.Webhook != "" {
+		data, err := json.Marshal(struct {
+			Summary
+			Subject string `json:"subject"`
+			Text    string `json:"text"`
+		}{summary, summary.Subject(), summary.Text()})
+		if err == nil {
+			err = post(config.Webhook, "application/json", data, nil)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if config.Ntfy != "" {
+		priority := "default"
+		if summary.Urgent() {
+			priority = "high"
+		}
+		headers := map[string]string{"Title": summary.Subject(), "Priority": priority}
+		if err := post(config.Ntfy, "text/plain; charset=utf-8", []byte(summary.Text()), headers); err != nil {
+			errs = append(errs, fmt.Errorf("ntfy: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sends the summary as an email
+func sendEmail(config SmtpConfig, summary Summary) error {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", summary.Subject())
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(summary.Text(), "\n", "\r\n"))
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return sendMail(net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), auth, config.From, config.To, message.Bytes())
+}
+
+// Posts data to a URL, failing unless the response is a success
+func post(url string, contentType string, data []byte, headers map[string]string) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if (response.StatusCode < 200) || (response.StatusCode > 299) {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	return nil
+}
+
+// Summarises the run of this program from the events it reports, and sends the summary when it exits if it is
+// noteworthy
+func Watch(config Config) {
+	summary := NewSummary(filepath.Base(os.Args[0]))
+	events.Observe(summary.Add)
+	exitcode.OnExit(func(code int, outcome string) {
+		summary.Outcome = outcome
+		if !summary.Noteworthy(config) {
+			return
+		}
+		if err := Send(config, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot send the notification: %v\n", err)
+		}
+	})
+}
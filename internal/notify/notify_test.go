@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"docs-to-yaml/internal/events"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(PasswordVariable, "secret")
+	for _, test := range []struct {
+		text     string
+		expected string // A fragment of the error expected, if any
+	}{
+		{"smtp:\n  host: smtp.example.org\n  from: a@example.org\n  to: [b@example.org]\n", ""},
+		{"ntfy: https://ntfy.sh/archive\nignorewarnings: true\n", ""},
+		{"smtp:\n  host: smtp.example.org\n", "smtp needs a host, from and to"},
+		{"ignorewarnings: true\n", "give at least one of"},
+	} {
+		filename := filepath.Join(dir, "notify.yaml")
+		if err := os.WriteFile(filename, []byte(test.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := ReadConfig(filename)
+		switch {
+		case (test.expected == "") && (err != nil):
+			t.Errorf("ReadConfig(%q) failed: %v", test.text, err)
+		case (test.expected == "") && (config.Smtp != nil) && ((config.Smtp.Port != 587) || (config.Smtp.Password != "secret")):
+			t.Errorf("ReadConfig(%q) gave %+v", test.text, *config.Smtp)
+		case (test.expected != "") && ((err == nil) || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("ReadConfig(%q) = %v, expected an error containing %q", test.text, err, test.expected)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	summary := Summary{Program: "verify-catalog", Host: "nas"}
+	if summary.Noteworthy(Config{}) {
+		t.Errorf("Noteworthy() of a quiet run")
+	}
+	summary.Add(events.Event{Type: "unchecked-document", Severity: events.SeverityWarning, Path: "a.pdf"})
+	if !summary.Noteworthy(Config{}) || summary.Noteworthy(Config{IgnoreWarnings: true}) {
+		t.Errorf("Noteworthy() of a run with a warning")
+	}
+	summary.Add(events.Event{Type: "changed-document", Severity: events.SeverityError, Volume: "DEC_0001", Path: "vax/ka630.pdf"})
+	summary.Add(events.Event{Type: "summary", Severity: events.SeverityInfo})
+	summary.Add(events.Event{Type: "fatal", Severity: events.SeverityError, Message: "cannot read catalog"})
+	if (summary.Warnings != 1) || (summary.Errors != 1) || (len(summary.BitRot) != 1) || (len(summary.Fatal) != 1) || !summary.Urgent() {
+		t.Errorf("Add() gave %+v", summary)
+	}
+	if subject := summary.Subject(); subject != "verify-catalog on nas: FAILED, 1 bit-rot finding, 1 error, 1 warning" {
+		t.Errorf("Subject() = %q", subject)
+	}
+	if text := summary.Text(); !strings.Contains(text, "Bit rot:\n  changed-document DEC_0001: vax/ka630.pdf\n") || !strings.Contains(text, "cannot read catalog") {
+		t.Errorf("Text() =\n%s", text)
+	}
+
+	// The events of another program
+	filename := filepath.Join(t.TempDir(), "events.ndjson")
+	os.WriteFile(filename, []byte(`{"type":"md5-mismatch","severity":"error","path":"b.pdf","message":"MISMATCH"}`+"\nnot json\n"), 0644)
+	if err := summary.AddEventsFile(filename); (err != nil) || (len(summary.BitRot) != 2) {
+		t.Errorf("AddEventsFile() = %v, giving %+v", err, summary.BitRot)
+	}
+	if err := summary.AddEventsFile(filename + ".missing"); err != nil {
+		t.Errorf("AddEventsFile() of a missing file = %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	received := make(map[string]*http.Request)
+	bodies := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received[r.URL.Path], bodies[r.URL.Path] = r, string(body)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var mailedTo []string
+	var mail string
+	sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if (addr != "smtp.example.org:25") || (auth != nil) {
+			t.Errorf("sendMail() to %s with %v", addr, auth)
+		}
+		mailedTo, mail = to, string(msg)
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	summary := Summary{Program: "run-pipeline", Host: "nas", DocumentsAdded: 12, Errors: 1}
+	config := Config{
+		Smtp:    &SmtpConfig{Host: "smtp.example.org", Port: 25, From: "a@example.org", To: []string{"b@example.org"}},
+		Webhook: server.URL + "/hook",
+		Ntfy:    server.URL + "/archive",
+	}
+	if err := Send(config, summary); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if (len(mailedTo) != 1) || !strings.Contains(mail, "Subject: run-pipeline on nas: 1 error, 12 documents added\r\n") {
+		t.Errorf("Send() mailed %v:\n%s", mailedTo, mail)
+	}
+	var hooked map[string]any
+	if err := json.Unmarshal([]byte(bodies["/hook"]), &hooked); (err != nil) || (hooked["documentsAdded"] != 12.0) || !strings.Contains(hooked["text"].(string), "Documents added:   12") {
+		t.Errorf("Send() posted to the webhook %s", bodies["/hook"])
+	}
+	if ntfy := received["/archive"]; (ntfy == nil) || (ntfy.Header.Get("Priority") != "high") || !strings.HasPrefix(bodies["/archive"], "run-pipeline on nas") {
+		t.Errorf("Send() posted to ntfy %s", bodies["/archive"])
+	}
+
+	// One failure does not stop the others
+	mailedTo = nil
+	config.Webhook = server.URL + "/broken"
+	if err := Send(config, summary); (err == nil) || !strings.Contains(err.Error(), "webhook") || (len(mailedTo) != 1) {
+		t.Errorf("Send() with a broken webhook = %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package par2
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// This package adds PAR2 recovery data to an archive volume, so that a volume damaged by bit rot or a scratched
+// disc can be repaired rather than merely found to be bad.
+//
+// The recovery data is created by par2cmdline (the "par2" program), which writes an index file and a set of
+// recovery volumes at the root of the tree:
+//
+//	recovery.par2
+//	recovery.vol000+01.par2
+//	recovery.vol001+02.par2
+//	...
+//
+// The names of these files, along with the redundancy used, are recorded in the volume metadata file recovery.yaml,
+// also at the root of the tree, so that a check of the volume can tell that recovery data is expected and complete.
+// To repair a damaged volume, run "par2 repair recovery.par2" in its root.
+
+// The base name given to par2 for the recovery files
+const RecoveryBasename = "recovery"
+
+// The volume metadata file that records the recovery files
+const ManifestFilename = "recovery.yaml"
+
+// Manifest records the recovery data of a volume
+type Manifest struct {
+	Tool       string   // The program that created the recovery files
+	Redundancy int      // The percentage of recovery data created
+	Protected  int      // The number of files protected
+	Files      []string // The recovery files, relative to the root of the volume
+}
+
+// Reports whether the named file (relative to the root of a volume) is part of the recovery data
+func IsRecoveryFile(name string) bool {
+	if name == ManifestFilename {
+		return true
+	}
+	return strings.HasPrefix(name, RecoveryBasename+".") && strings.HasSuffix(name, ".par2") && !strings.Contains(name, "/")
+}
+
+// Runs par2 in dir. Tests replace this to avoid needing par2cmdline.
+var runPar2 = func(dir string, args ...string) error {
+	cmd := exec.Command("par2", args...)
+	cmd.Dir = dir
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("par2 %s: %w: %s", args[0], err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// Creates recovery data with the given redundancy (a percentage) for the named files (relative to root, "/"-separated)
+// and writes the manifest. Any recovery files left by an earlier run are replaced. Returns the manifest written.
+func Create(root string, files []string, redundancy int) (Manifest, error) {
+	if (redundancy < 1) || (redundancy > 100) {
+		return Manifest{}, fmt.Errorf("redundancy must be between 1 and 100%%, not %d", redundancy)
+	}
+	var protected []string
+	for _, name := range files {
+		if !IsRecoveryFile(name) {
+			protected = append(protected, filepath.FromSlash(name))
+		}
+	}
+	if len(protected) == 0 {
+		return Manifest{}, fmt.Errorf("no files to protect in %s", root)
+	}
+	stale, err := findRecoveryFiles(root)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for _, name := range stale {
+		if err := os.Remove(filepath.Join(root, name)); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	// "--" ends the options, so that a file whose name starts with "-" is not taken for one
+	args := append([]string{"create", "-q", fmt.Sprintf("-r%d", redundancy), RecoveryBasename + ".par2", "--"}, protected...)
+	if err := runPar2(root, args...); err != nil {
+		return Manifest{}, err
+	}
+	created, err := findRecoveryFiles(root)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(created) == 0 {
+		return Manifest{}, fmt.Errorf("par2 created no recovery files in %s", root)
+	}
+	manifest := Manifest{Tool: "par2", Redundancy: redundancy, Protected: len(protected), Files: created}
+	data, err := document.MarshalYaml(manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return manifest, os.WriteFile(filepath.Join(root, ManifestFilename), data, 0644)
+}
+
+// Returns the names of the recovery files (but not the manifest) at the root of a volume, in order
+func findRecoveryFiles(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsRecoveryFile(entry.Name()) && (entry.Name() != ManifestFilename) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Reads the manifest of a volume. A volume without recovery data gives an error satisfying errors.Is(err, fs.ErrNotExist).
+func ReadManifest(fsys fs.FS) (Manifest, error) {
+	var manifest Manifest
+	data, err := fs.ReadFile(fsys, ManifestFilename)
+	if err != nil {
+		return manifest, err
+	}
+	if err := document.UnmarshalYaml(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("%s: %w", ManifestFilename, err)
+	}
+	return manifest, nil
+}
+
+// Returns the recovery files listed in the manifest that are missing from the volume
+func (manifest Manifest) MissingFiles(fsys fs.FS) []string {
+	var missing []string
+	for _, name := range manifest.Files {
+		if _, err := fs.Stat(fsys, path.Clean(name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,71 @@
+package par2
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Replaces runPar2 with a fake that writes the files par2 would, recording the arguments it was given
+func fakePar2(t *testing.T) *[]string {
+	var ran []string
+	saved := runPar2
+	runPar2 = func(dir string, args ...string) error {
+		ran = args
+		for _, name := range []string{"recovery.par2", "recovery.vol000+01.par2", "recovery.vol001+02.par2"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("PAR2"), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.Cleanup(func() { runPar2 = saved })
+	return &ran
+}
+
+func TestCreate(t *testing.T) {
+	ran := fakePar2(t)
+	root := t.TempDir()
+	// Recovery files from an earlier run are replaced
+	if err := os.WriteFile(filepath.Join(root, "recovery.vol003+04.par2"), []byte("old"), 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+
+	manifest, err := Create(root, []string{"index.csv", "vax/ka630.pdf", "recovery.vol003+04.par2", "recovery.yaml"}, 10)
+	if err != nil {
+		t.Fatalf(`Create() failed: %v`, err)
+	}
+	expected := Manifest{Tool: "par2", Redundancy: 10, Protected: 2, Files: []string{"recovery.par2", "recovery.vol000+01.par2", "recovery.vol001+02.par2"}}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf(`Create() = %+v`, manifest)
+	}
+	if args := strings.Join(*ran, " "); args != "create -q -r10 recovery.par2 -- index.csv "+filepath.FromSlash("vax/ka630.pdf") {
+		t.Errorf(`Create() ran par2 %s`, args)
+	}
+	if _, err := os.Stat(filepath.Join(root, "recovery.vol003+04.par2")); err == nil {
+		t.Errorf(`Create() left a stale recovery file`)
+	}
+
+	reread, err := ReadManifest(os.DirFS(root))
+	if (err != nil) || !reflect.DeepEqual(reread, expected) {
+		t.Errorf(`ReadManifest() = %+v, %v`, reread, err)
+	}
+	os.Remove(filepath.Join(root, "recovery.vol001+02.par2"))
+	if missing := reread.MissingFiles(os.DirFS(root)); !reflect.DeepEqual(missing, []string{"recovery.vol001+02.par2"}) {
+		t.Errorf(`MissingFiles() = %v`, missing)
+	}
+
+	if _, err := Create(root, []string{"index.csv"}, 0); err == nil {
+		t.Errorf(`Create() accepted a redundancy of 0`)
+	}
+}
+
+func TestIsRecoveryFile(t *testing.T) {
+	for name, expected := range map[string]bool{"recovery.par2": true, "recovery.vol000+01.par2": true, "recovery.yaml": true, "vax/recovery.par2": false, "recovery.pdf": false, "ka630.par2": false} {
+		if IsRecoveryFile(name) != expected {
+			t.Errorf(`IsRecoveryFile(%s) = %v`, name, !expected)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package pdfa
+
+import (
+	"bytes"
+	"compress/zlib"
+	"docs-to-yaml/internal/document"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// This package tells which PDFs are PDF/A (the archival subset of PDF), or could simply be converted to it, for
+// preservation planning.
+//
+// A PDF/A file claims its level in its XMP metadata (pdfaid:part and pdfaid:conformance, e.g. 1 and B for PDF/A-1b),
+// which is read directly from the file, including from compressed streams. A file that claims no level is checked for
+// what PDF/A forbids and a converter (e.g. "ocrmypdf --output-type pdfa" or Ghostscript) cannot simply put right:
+// encryption, JavaScript, launch actions, embedded files and fonts that are used but not embedded. A claim is only a
+// claim: it can be validated by veraPDF (see Validate), whose machine-readable report gives the verdict.
+//
+// The outcome is recorded in Document.PdfA as one of:
+//
+//	valid-1b      veraPDF found the file to conform to the level it claims
+//	invalid-1b    veraPDF found that it does not
+//	claims-1b     the file claims the level, but has not been validated
+//	convertible   the file claims no level, but nothing was found that would stop a simple conversion
+//	none          the file claims no level and has something that PDF/A forbids
+
+type Document = document.Document
+
+// The values of Document.PdfA that do not name a level
+const (
+	Convertible = "convertible"
+	None        = "none"
+)
+
+// The prefixes of the values of Document.PdfA that name a level
+const (
+	ValidPrefix   = "valid-"
+	InvalidPrefix = "invalid-"
+	ClaimsPrefix  = "claims-"
+)
+
+// The status given to PDFs that have not been checked, when counting them
+const Unchecked = "unchecked"
+
+// Report is what was found in a PDF
+type Report struct {
+	Level     string   // The level claimed in the XMP metadata, e.g. "1b" or "2u"; blank if none
+	Obstacles []string // What PDF/A forbids, e.g. "encrypted", in the order of Checks
+}
+
+// Returns the value of Document.PdfA for the report, before any validation
+func (r Report) Status() string {
+	switch {
+	case r.Level != "":
+		return ClaimsPrefix + r.Level
+	case len(r.Obstacles) == 0:
+		return Convertible
+	}
+	return None
+}
+
+// Check is something PDF/A forbids, found by a pattern in the PDF's objects
+type Check struct {
+	Obstacle string
+	Pattern  *regexp.Regexp
+}
+
+// The checks made of every PDF. Unembedded fonts are checked separately, as they need two patterns.
+var Checks = []Check{
+	{"encrypted", regexp.MustCompile(`/Encrypt\b`)},
+	{"JavaScript", regexp.MustCompile(`/(JavaScript|JS)\b`)},
+	{"launch actions", regexp.MustCompile(`/Launch\b`)},
+	{"embedded files", regexp.MustCompile(`/EmbeddedFiles?\b`)},
+}
+
+// The obstacle reported for fonts that are used but not embedded
+const UnembeddedFonts = "fonts not embedded"
+
+var (
+	fontRegexp     = regexp.MustCompile(`/Font\b`)
+	fontFileRegexp = regexp.MustCompile(`/FontFile[23]?\b`)
+	partRegexp     = regexp.MustCompile(`pdfaid:part(?:=["']|>)\s*(\d)`)
+	formRegexp     = regexp.MustCompile(`pdfaid:conformance(?:=["']|>)\s*([A-Za-z])`)
+	streamRegexp   = regexp.MustCompile(`stream\r?\n`)
+)
+
+// Streams larger than this are not inflated. Metadata and object streams are small; large streams are images and page
+// content.
+const maxInflatedStream = 8 << 20
+
+// Checks the named PDF file (see CheckPdf)
+func CheckFile(filename string) (Report, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Report{}, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return Report{}, errors.New("not a PDF file")
+	}
+	return CheckPdf(data), nil
+}
+
+// Checks PDF data: reads the level it claims and looks for what PDF/A forbids
+func CheckPdf(data []byte) Report {
+	parts := [][]byte{data}
+	for _, match := range streamRegexp.FindAllIndex(data, -1) {
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		if inflated, err := inflate(data[start : start+end]); err == nil {
+			parts = append(parts, inflated)
+		}
+	}
+	found := func(pattern *regexp.Regexp) bool {
+		for _, part := range parts {
+			if pattern.Match(part) {
+				return true
+			}
+		}
+		return false
+	}
+	var report Report
+	for _, part := range parts {
+		if match := partRegexp.FindSubmatch(part); match != nil {
+			report.Level = string(match[1])
+			if form := formRegexp.FindSubmatch(part); form != nil {
+				report.Level += strings.ToLower(string(form[1]))
+			}
+			break
+		}
+	}
+	for _, check := range Checks {
+		if found(check.Pattern) {
+			report.Obstacles = append(report.Obstacles, check.Obstacle)
+		}
+	}
+	if found(fontRegexp) && !found(fontFileRegexp) {
+		report.Obstacles = append(report.Obstacles, UnembeddedFonts)
+	}
+	return report
+}
+
+// Inflates Flate-encoded (zlib) data, giving up on data that is not zlib or would be too large
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, maxInflatedStream))
+	if (err != nil) && (len(inflated) == 0) {
+		return nil, err
+	}
+	return inflated, nil
+}
+
+var (
+	profileRegexp   = regexp.MustCompile(`<validationReport\b[^>]*\bprofileName="PDF/A-(\d[A-Za-z]?)`)
+	compliantRegexp = regexp.MustCompile(`<validationReport\b[^>]*\bisCompliant="(true|false)"`)
+)
+
+// Reads a veraPDF machine-readable report (--format mrr) of one file, returning the level it was validated against
+// (e.g. "1b") and the verdict
+func ParseReport(output []byte) (string, bool, error) {
+	profile, compliant := profileRegexp.FindSubmatch(output), compliantRegexp.FindSubmatch(output)
+	if (profile == nil) || (compliant == nil) {
+		return "", false, errors.New("no validation report in the veraPDF output")
+	}
+	return strings.ToLower(string(profile[1])), string(compliant[1]) == "true", nil
+}
+
+// Runs veraPDF (the command, already expanded for the file, e.g. "verapdf --format mrr FILE") and returns the value of
+// Document.PdfA it gives. veraPDF validates against the level the file claims. It exits with a non-zero status for a
+// file that does not conform, so its status only matters if it wrote no report. Tests replace this to avoid needing
+// veraPDF.
+var Validate = func(command []string) (string, error) {
+	var output, messages bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout, cmd.Stderr = &output, &messages
+	runErr := cmd.Run()
+	level, compliant, err := ParseReport(output.Bytes())
+	if (err != nil) && (runErr != nil) {
+		return "", fmt.Errorf("%s: %w: %s", command[0], runErr, strings.TrimSpace(messages.String()))
+	} else if err != nil {
+		return "", err
+	}
+	if compliant {
+		return ValidPrefix + level, nil
+	}
+	return InvalidPrefix + level, nil
+}
+
+// Returns the number of PDFs with each value of Document.PdfA, counting those not yet checked as Unchecked
+func Distribution(documents map[string]Document) map[string]int {
+	counts := make(map[string]int)
+	for _, doc := range documents {
+		if doc.Format != "PDF" {
+			continue
+		}
+		status := doc.PdfA
+		if status == "" {
+			status = Unchecked
+		}
+		counts[status] += 1
+	}
+	return counts
+}
+
+// Returns the statuses counted in a distribution: the levels in order, then convertible, none and unchecked
+func Statuses(counts map[string]int) []string {
+	order := map[string]int{Convertible: 1, None: 2, Unchecked: 3}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if order[statuses[i]] != order[statuses[j]] {
+			return order[statuses[i]] < order[statuses[j]]
+		}
+		return statuses[i] < statuses[j]
+	})
+	return statuses
+}
@@ -0,0 +1,69 @@
+package pdfa
+
+import (
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"testing"
+)
+
+// Returns data compressed as a PDF FlateDecode stream holds it
+func deflate(data string) string {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	writer.Write([]byte(data))
+	writer.Close()
+	return buf.String()
+}
+
+const xmp = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/" pdfaid:part="2" pdfaid:conformance="B"/></x:xmpmeta>`
+
+func TestCheckPdf(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected Report
+		status   string
+	}{
+		{"a scan", "%PDF-1.4\n1 0 obj << /Type /XObject /Subtype /Image >> endobj", Report{}, Convertible},
+		{"a claim in plain metadata", "%PDF-1.4\n1 0 obj << /Type /Metadata >>\nstream\n" + xmp + "\nendstream", Report{Level: "2b"}, "claims-2b"},
+		{"a claim in a compressed stream", "%PDF-1.7\n1 0 obj << /Filter /FlateDecode >>\nstream\n" + deflate("<pdfaid:part>1</pdfaid:part><pdfaid:conformance>A</pdfaid:conformance>") + "\nendstream", Report{Level: "1a"}, "claims-1a"},
+		{"an encrypted file", "%PDF-1.4\ntrailer << /Encrypt 5 0 R >>", Report{Obstacles: []string{"encrypted"}}, None},
+		{"a script and an unembedded font", "%PDF-1.4\n1 0 obj << /S /JavaScript /JS (app.alert(1)) >> 2 0 obj << /Font << /F1 3 0 R >> >>", Report{Obstacles: []string{"JavaScript", UnembeddedFonts}}, None},
+		{"an embedded font", "%PDF-1.4\n2 0 obj << /Font << /F1 3 0 R >> >> 4 0 obj << /FontDescriptor /FontFile2 5 0 R >>", Report{}, Convertible},
+	} {
+		report := CheckPdf([]byte(test.data))
+		if !reflect.DeepEqual(report, test.expected) || (report.Status() != test.status) {
+			t.Errorf("CheckPdf() of %s = %+v (%s)", test.name, report, report.Status())
+		}
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	report := `<report><jobs><job><item size="1234"><name>/mnt/DEC_0001/ka630.pdf</name></item>
+<validationReport jobEndStatus="normal" profileName="PDF/A-1B validation profile" statement="PDF file is not compliant with Validation Profile requirements." isCompliant="false">`
+	if level, compliant, err := ParseReport([]byte(report)); (level != "1b") || compliant || (err != nil) {
+		t.Errorf("ParseReport() = %q, %v, %v", level, compliant, err)
+	}
+	if _, _, err := ParseReport([]byte("Exception in thread main")); err == nil {
+		t.Errorf("ParseReport() did not fail without a report")
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	documents := map[string]Document{
+		"a": {Format: "PDF", PdfA: "valid-1b"},
+		"b": {Format: "PDF", PdfA: Convertible},
+		"c": {Format: "PDF", PdfA: Convertible},
+		"d": {Format: "PDF"},
+		"e": {Format: "PDF", PdfA: "claims-2u"},
+		"f": {Format: "TXT"},
+	}
+	counts := Distribution(documents)
+	if !reflect.DeepEqual(counts, map[string]int{"valid-1b": 1, Convertible: 2, Unchecked: 1, "claims-2u": 1}) {
+		t.Errorf("Distribution() = %v", counts)
+	}
+	if statuses := Statuses(counts); !reflect.DeepEqual(statuses, []string{"claims-2u", "valid-1b", Convertible, Unchecked}) {
+		t.Errorf("Statuses() = %v", statuses)
+	}
+}
@@ -0,0 +1,165 @@
+package pdfdraw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// This package writes simple PDF files: pages of text, rectangles and circles, as needed to print media labels.
+//
+// Text is set in Helvetica, one of the standard fonts every PDF reader provides, so no font is embedded; characters
+// outside Latin-1 are printed as "?". Coordinates and sizes are in points (1/72 inch), measured from the bottom left
+// corner of the page, as in PDF itself.
+
+// The number of points in a millimetre
+const PointsPerMm = 72 / 25.4
+
+// Document is a PDF document under construction
+type Document struct {
+	pages []*Page
+}
+
+// Page is one page of a Document
+type Page struct {
+	Width   float64
+	Height  float64
+	content bytes.Buffer
+}
+
+// Returns an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// Adds a page of the given size to the document
+func (d *Document) AddPage(width float64, height float64) *Page {
+	page := &Page{Width: width, Height: height}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Sets the grey level (0 is black, 1 is white) used for lines, fills and text from now on
+func (p *Page) SetGrey(level float64) {
+	fmt.Fprintf(&p.content, "%s g %s G\n", number(level), number(level))
+}
+
+// Sets the width of lines drawn from now on
+func (p *Page) SetLineWidth(width float64) {
+	fmt.Fprintf(&p.content, "%s w\n", number(width))
+}
+
+// Draws text in Helvetica with its baseline starting at x, y
+func (p *Page) Text(x float64, y float64, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", number(size), number(x), number(y), escape(text))
+}
+
+// Draws text in Helvetica centred horizontally on x, with its baseline at y
+func (p *Page) CentredText(x float64, y float64, size float64, text string) {
+	p.Text(x-TextWidth(text, size)/2, y, size, text)
+}
+
+// Draws a rectangle with its bottom left corner at x, y: filled if fill is set, otherwise outlined
+func (p *Page) Rect(x float64, y float64, width float64, height float64, fill bool) {
+	operator := "S"
+	if fill {
+		operator = "f"
+	}
+	fmt.Fprintf(&p.content, "%s %s %s %s re %s\n", number(x), number(y), number(width), number(height), operator)
+}
+
+// Outlines a circle centred on x, y, drawn as four Bézier curves
+func (p *Page) Circle(x float64, y float64, radius float64) {
+	k := radius * 4 * (math.Sqrt2 - 1) / 3
+	fmt.Fprintf(&p.content, "%s %s m\n", number(x+radius), number(y))
+	fmt.Fprintf(&p.content, "%s %s %s %s %s %s c\n", number(x+radius), number(y+k), number(x+k), number(y+radius), number(x), number(y+radius))
+	fmt.Fprintf(&p.content, "%s %s %s %s %s %s c\n", number(x-k), number(y+radius), number(x-radius), number(y+k), number(x-radius), number(y))
+	fmt.Fprintf(&p.content, "%s %s %s %s %s %s c\n", number(x-radius), number(y-k), number(x-k), number(y-radius), number(x), number(y-radius))
+	fmt.Fprintf(&p.content, "%s %s %s %s %s %s c S\n", number(x+k), number(y-radius), number(x+radius), number(y-k), number(x+radius), number(y))
+}
+
+// Writes the document as a PDF file
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 to 3 are the catalog, the page tree and the font; each page is then a page object and its content
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", number(page.Width), number(page.Height), 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.WriteTo(w)
+}
+
+// Formats a number for a content stream, without needless digits
+func number(n float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.3f", n), "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// Converts text to WinAnsiEncoding (Latin-1 is much the same) and escapes it for a PDF string
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case (r == '(') || (r == ')') || (r == '\\'):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case (r >= ' ') && (r <= '~'):
+			b.WriteRune(r)
+		case (r >= 0xA0) && (r <= 0xFF):
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// The widths of the printable ASCII characters in Helvetica, in thousandths of the font size
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// Returns the width of text set in Helvetica at a size
+func TextWidth(text string, size float64) float64 {
+	width := 0
+	for _, r := range text {
+		if (r >= ' ') && (r <= '~') {
+			width += helveticaWidths[r-' ']
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
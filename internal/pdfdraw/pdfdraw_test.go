@@ -0,0 +1,55 @@
+package pdfdraw
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	doc := New()
+	page := doc.AddPage(595.276, 841.89)
+	page.SetLineWidth(0.5)
+	page.Circle(100, 100, 50)
+	page.Rect(10, 10, 20, 30, true)
+	page.Text(72, 720, 12, "DEC_0001 (2004) \\ Café ∞")
+	doc.AddPage(200, 100)
+
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	pdf := out.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Errorf("WriteTo() wrote a malformed header or trailer")
+	}
+	for _, expected := range []string{"/Count 2", "/MediaBox [0 0 595.276 841.89]", "(DEC_0001 \\(2004\\) \\\\ Caf\\351 ?) Tj", "10 10 20 30 re f"} {
+		if !strings.Contains(pdf, expected) {
+			t.Errorf("WriteTo() did not write %s", expected)
+		}
+	}
+
+	// Every cross reference entry gives the offset of its object, and startxref that of the table
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	xref, _ := strconv.Atoi(startxref[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n0 8\n") {
+		t.Fatalf("startxref %d does not point at the cross reference table", xref)
+	}
+	for i, match := range regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllStringSubmatch(pdf, -1) {
+		offset, _ := strconv.Atoi(match[1])
+		if !strings.HasPrefix(pdf[offset:], strconv.Itoa(i+1)+" 0 obj\n") {
+			t.Errorf("cross reference entry %d does not point at its object", i+1)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	if width := TextWidth("DEC", 10); width != 21.11 {
+		t.Errorf("TextWidth(DEC) = %v", width)
+	}
+	if number(2.50) != "2.5" || number(3) != "3" || number(-0.0001) != "0" {
+		t.Errorf("number() formats badly: %s %s %s", number(2.50), number(3), number(-0.0001))
+	}
+}
@@ -0,0 +1,172 @@
+package pdfmetadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Word (OOXML) and OpenDocument files are zip archives whose metadata is held in XML parts, so it is read directly
+// rather than via exiftool:
+//
+//	DOCX  docProps/core.xml  dc:title, dc:creator, dcterms:created, dcterms:modified
+//	      docProps/app.xml   Application
+//	ODT   meta.xml           dc:title, meta:initial-creator, meta:creation-date, dc:date, meta:generator
+//
+// The author becomes the Creator, the application the Producer and the created date (to the month, as publication
+// dates usually are) becomes Created.
+
+// The formats whose metadata is read by the office backend
+var officeFormats = map[string]bool{"DOCX": true, "ODT": true}
+
+// The metadata parts of each kind of office document
+const (
+	ooxmlCorePart = "docProps/core.xml"
+	ooxmlAppPart  = "docProps/app.xml"
+	odfMetaPart   = "meta.xml"
+)
+
+// The parts of docProps/core.xml that are used. Element names match in any namespace.
+type ooxmlCore struct {
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	Created  string `xml:"created"`
+	Modified string `xml:"modified"`
+}
+
+// The parts of docProps/app.xml that are used
+type ooxmlApp struct {
+	Application string `xml:"Application"`
+}
+
+// The parts of meta.xml that are used
+type odfMeta struct {
+	Meta struct {
+		Title          string `xml:"title"`
+		InitialCreator string `xml:"initial-creator"`
+		Creator        string `xml:"creator"`
+		CreationDate   string `xml:"creation-date"`
+		Date           string `xml:"date"`
+		Generator      string `xml:"generator"`
+	} `xml:"meta"`
+}
+
+// Reads the metadata of a DOCX or ODT file
+func extractOffice(filename string) (PdfMetadata, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		if isEncryptedOffice(filename) {
+			return PdfMetadata{Encryption: document.EncryptionPassword}, nil
+		}
+		return PdfMetadata{}, err
+	}
+	defer archive.Close()
+
+	var metadata PdfMetadata
+	var meta odfMeta
+	found, err := readXmlPart(&archive.Reader, odfMetaPart, &meta)
+	if err != nil {
+		return metadata, err
+	} else if found {
+		metadata.Title = strings.TrimSpace(meta.Meta.Title)
+		metadata.Creator = strings.TrimSpace(meta.Meta.InitialCreator)
+		if metadata.Creator == "" {
+			metadata.Creator = strings.TrimSpace(meta.Meta.Creator)
+		}
+		metadata.Producer = strings.TrimSpace(meta.Meta.Generator)
+		metadata.Created = createdMonth(meta.Meta.CreationDate)
+		metadata.Modified = exiftoolDate(meta.Meta.Date)
+		return metadata, nil
+	}
+
+	var core ooxmlCore
+	found, err = readXmlPart(&archive.Reader, ooxmlCorePart, &core)
+	if err != nil {
+		return metadata, err
+	} else if !found {
+		return metadata, fmt.Errorf("neither %s nor %s found", ooxmlCorePart, odfMetaPart)
+	}
+	metadata.Title = strings.TrimSpace(core.Title)
+	metadata.Creator = strings.TrimSpace(core.Creator)
+	metadata.Created = createdMonth(core.Created)
+	metadata.Modified = exiftoolDate(core.Modified)
+	var app ooxmlApp
+	if _, err := readXmlPart(&archive.Reader, ooxmlAppPart, &app); err != nil {
+		return metadata, err
+	}
+	metadata.Producer = strings.TrimSpace(app.Application)
+	return metadata, nil
+}
+
+// Decodes the named part of a zip archive as XML. Returns false (and no error) if there is no such part.
+func readXmlPart(archive *zip.Reader, name string, v interface{}) (bool, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return true, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("%s: %w", name, err)
+	}
+	return true, nil
+}
+
+// The date formats found in office document metadata: W3CDTF (OOXML) and ISO 8601 without a time zone (ODF)
+var officeDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+func parseOfficeDate(s string) (time.Time, bool) {
+	for _, layout := range officeDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Returns an ISO 8601 date as YYYY-MM, or "" if it cannot be understood
+func createdMonth(s string) string {
+	if t, ok := parseOfficeDate(s); ok {
+		return t.Format("2006-01")
+	}
+	return ""
+}
+
+// Returns an ISO 8601 date in the form exiftool uses for PDF dates (e.g. 2001:05:14 17:22:01Z). A date that cannot
+// be understood is returned unchanged; one without a time zone is given none.
+func exiftoolDate(s string) string {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.Format("2006:01:02 15:04:05Z07:00")
+	}
+	if t, ok := parseOfficeDate(s); ok {
+		return t.Format("2006:01:02 15:04:05")
+	}
+	return s
+}
+
+// The signature of an OLE compound file, in which password-protected DOCX (and other Office Open XML) files are held
+var compoundFileSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// The name of the stream describing the encryption of a password-protected Office Open XML file, in UTF-16 as the
+// names of compound file streams are held
+var encryptionInfoName = []byte("E\x00n\x00c\x00r\x00y\x00p\x00t\x00i\x00o\x00n\x00I\x00n\x00f\x00o\x00")
+
+// Reports whether a file is a password-protected Office Open XML file: rather than a zip archive, an encrypted DOCX
+// is a compound file holding an EncryptionInfo stream and the encrypted package
+func isEncryptedOffice(filename string) bool {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(data, compoundFileSignature) && bytes.Contains(data, encryptionInfoName)
+}
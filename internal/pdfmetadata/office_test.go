@@ -0,0 +1,121 @@
+package pdfmetadata
+
+import (
+	"archive/zip"
+	"docs-to-yaml/internal/document"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes a zip archive holding the given parts
+func writeZip(t *testing.T, filename string, parts map[string]string) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf(`cannot create %s: %v`, filename, err)
+	}
+	archive := zip.NewWriter(file)
+	for name, content := range parts {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	archive.Close()
+	file.Close()
+}
+
+func TestExtractOffice(t *testing.T) {
+	dir := t.TempDir()
+	docx := filepath.Join(dir, "memo.docx")
+	writeZip(t, docx, map[string]string{
+		"word/document.xml": "<w:document/>",
+		"docProps/core.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<dc:title>VAX 9000 Migration Notes</dc:title><dc:creator>J. Smith</dc:creator><cp:lastModifiedBy>A. Jones</cp:lastModifiedBy>
+<dcterms:created xsi:type="dcterms:W3CDTF">2003-04-15T09:30:00Z</dcterms:created><dcterms:modified xsi:type="dcterms:W3CDTF">2004-01-02T03:04:05Z</dcterms:modified>
+</cp:coreProperties>`,
+		"docProps/app.xml": `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Application>Microsoft Office Word</Application></Properties>`,
+	})
+	odt := filepath.Join(dir, "notes.odt")
+	writeZip(t, odt, map[string]string{
+		"mimetype": "application/vnd.oasis.opendocument.text",
+		"meta.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/" office:version="1.3">
+<office:meta><meta:generator>LibreOffice/7.3.7.2$Linux_X86_64</meta:generator><dc:title>PDP-11 Notes</dc:title>
+<meta:initial-creator>A. Jones</meta:initial-creator><dc:creator>J. Smith</dc:creator>
+<meta:creation-date>2021-11-03T14:07:01.123456789</meta:creation-date><dc:date>2022-01-05T10:00:00.5</dc:date><meta:print-date>2023-01-01T00:00:00</meta:print-date></office:meta>
+</office:document-meta>`,
+	})
+	empty := filepath.Join(dir, "empty.docx")
+	writeZip(t, empty, map[string]string{"word/document.xml": "<w:document/>"})
+
+	tests := map[string]PdfMetadata{
+		docx: {Creator: "J. Smith", Producer: "Microsoft Office Word", Modified: "2004:01:02 03:04:05Z", Title: "VAX 9000 Migration Notes", Created: "2003-04"},
+		odt:  {Creator: "A. Jones", Producer: "LibreOffice/7.3.7.2$Linux_X86_64", Modified: "2022:01:05 10:00:00", Title: "PDP-11 Notes", Created: "2021-11"},
+	}
+	for filename, expected := range tests {
+		metadata, err := extractOffice(filename)
+		if (err != nil) || (metadata != expected) {
+			t.Errorf(`extractOffice(%s) = %+v, %v, expected %+v`, filepath.Base(filename), metadata, err, expected)
+		}
+	}
+	if _, err := extractOffice(empty); err == nil {
+		t.Errorf(`extractOffice() of a file without metadata succeeded`)
+	}
+
+	// DOCX and ODT files are read directly by default, so this needs no exiftool
+	results := ExtractPdfMetadataFiles([]string{docx, odt}, 1)
+	if (results[0] != tests[docx]) || (results[1] != tests[odt]) {
+		t.Errorf(`ExtractPdfMetadataFiles() = %+v`, results)
+	}
+}
+
+func TestApply(t *testing.T) {
+	metadata := PdfMetadata{Creator: "J. Smith", Producer: "Microsoft Office Word", Title: "VAX 9000 Migration Notes", Created: "2003-04"}
+
+	// A title and date guessed from the filename are replaced
+	doc := document.Document{Title: "memo", Flags: "TD"}
+	Apply(&doc, metadata)
+	if (doc.Title != "VAX 9000 Migration Notes") || (doc.PubDate != "2003-04") || (doc.PdfCreator != "J. Smith") || (doc.Flags != "TD") {
+		t.Errorf(`Apply() to guessed fields = %+v`, doc)
+	}
+
+	// A title and date from an index are kept
+	doc = document.Document{Title: "Migration Notes", PubDate: "2003"}
+	Apply(&doc, metadata)
+	if (doc.Title != "Migration Notes") || (doc.PubDate != "2003") || (doc.PdfProducer != "Microsoft Office Word") || (doc.Flags != "") {
+		t.Errorf(`Apply() to known fields = %+v`, doc)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	for _, test := range []struct {
+		fields   map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"Producer": "Acrobat Distiller 4.0"}, ""},
+		{map[string]interface{}{"Encryption": "Standard V2.3 (128-bit)"}, document.EncryptionRestricted},
+		{map[string]interface{}{"Encryption": "Standard V4.4 (128-bit)", "Error": "Document is password protected (use Password option)"}, document.EncryptionPassword},
+	} {
+		if encryption := exiftoolEncryption(test.fields); encryption != test.expected {
+			t.Errorf(`exiftoolEncryption(%v) = %q`, test.fields, encryption)
+		}
+	}
+	if metadata := tikaMetadata(map[string]interface{}{"pdf:encrypted": "true"}); metadata.Encryption != document.EncryptionRestricted {
+		t.Errorf(`tikaMetadata() of an encrypted PDF = %+v`, metadata)
+	}
+
+	// A password-protected DOCX is a compound file rather than a zip archive
+	filename := filepath.Join(t.TempDir(), "secret.docx")
+	data := append(append([]byte{}, compoundFileSignature...), encryptionInfoName...)
+	os.WriteFile(filename, data, 0644)
+	if metadata, err := extractOffice(filename); (err != nil) || (metadata.Encryption != document.EncryptionPassword) {
+		t.Errorf(`extractOffice() of a password-protected file = %+v, %v`, metadata, err)
+	}
+
+	doc := document.Document{}
+	Apply(&doc, PdfMetadata{Encryption: document.EncryptionPassword})
+	if doc.Encryption != document.EncryptionPassword {
+		t.Errorf(`Apply() of an encrypted file = %+v`, doc)
+	}
+}
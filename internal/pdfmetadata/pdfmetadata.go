@@ -1,29 +1,201 @@
 package pdfmetadata
 
 import (
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/pubdate"
 	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/barasher/go-exiftool"
 )
 
 // The PdfMetdata struct is used to record a subset of metadata that can be extracted from a PDF file
+// (or from another format with embedded metadata, such as DOCX or ODT)
 type PdfMetadata struct {
-	Creator  string
-	Producer string
-	Format   string
-	Modified string
+	Creator    string
+	Producer   string
+	Format     string
+	Modified   string
+	Title      string `yaml:",omitempty"` // Embedded title (only read from DOCX and ODT files)
+	Created    string `yaml:",omitempty"` // Creation date, as YYYY-MM (only read from DOCX and ODT files)
+	Encryption string `yaml:",omitempty"` // How the file is encrypted, as for Document.Encryption; blank if it is not
 }
 
+// PDF metadata never changes for a given file content, so extracted metadata can be cached across runs.
+// The cache is a persistent store keyed by MD5 checksum (see CacheKey).
+type Cache = persistentstore.Store[string, PdfMetadata]
+
+// Returns the key under which a file's metadata is cached.
+// The MD5 checksum is used if known. Otherwise the key is built from the file's size, modification time and name,
+// which is good enough to spot an unchanged file without reading it.
+func CacheKey(md5Checksum string, filename string) string {
+	if md5Checksum != "" {
+		return md5Checksum
+	}
+	info, err := os.Stat(filename)
+	return cacheKey(info, err, filepath.Base(filename))
+}
+
+// Like CacheKey, for the named file in fsys (e.g. a remote archive root).
+func CacheKeyFS(md5Checksum string, fsys fs.FS, name string) string {
+	if md5Checksum != "" {
+		return md5Checksum
+	}
+	info, err := fs.Stat(fsys, name)
+	return cacheKey(info, err, path.Base(name))
+}
+
+func cacheKey(info fs.FileInfo, err error, base string) string {
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("size=%d,mtime=%d,name=%s", info.Size(), info.ModTime().Unix(), base)
+}
+
+// Returns a native path for a file (which is already native unless the file was named within an FS), and a function
+// to call once exiftool has finished with it.
+type localFileFunc func(name string) (string, func(), error)
+
+func nativeFile(name string) (string, func(), error) { return name, func() {}, nil }
+
 // Given a PDF file, this function finds the associated metdata and returns those elements that will be stored in the YAML.
 func ExtractPdfMetadata(pdfFilename string) PdfMetadata {
 	et, err := exiftool.NewExiftool()
 	if err != nil {
 		log.Printf("Error when intializing: %v\n", err)
+		return PdfMetadata{}
 	}
 	defer et.Close()
 
+	return extractWith(et, pdfFilename)
+}
+
+// Extracts the metadata for a set of PDF files using up to workers concurrent exiftool processes.
+// The results are returned in the same order as the filenames.
+//
+// Starting exiftool is expensive, so each worker starts one exiftool process (when it first needs one) and reuses it for
+// all the files it handles. DOCX and ODT files are read directly (see extractOffice), and files whose format is configured
+// to use Tika (see Config) are sent to the Tika server instead.
+func ExtractPdfMetadataFiles(pdfFilenames []string, workers int) []PdfMetadata {
+	results, _ := extractFiles(pdfFilenames, nativeFile, workers)
+	return results
+}
+
+// Does the work for ExtractPdfMetadataFiles. If the run is interrupted (see the interrupt package), no further files
+// are started; the number of files actually processed (which are always the first ones) is returned along with the results.
+func extractFiles(pdfFilenames []string, localFile localFileFunc, workers int) ([]PdfMetadata, int) {
+	if workers < 1 {
+		workers = pipeline.DefaultWorkers()
+	}
+	tools := make([]*exiftool.Exiftool, workers)
+	failed := make([]bool, workers)
+
+	keepGoing := func() bool { return !interrupt.Requested() }
+	results, completed := pipeline.MapWhile(pdfFilenames, workers, keepGoing, func(worker int, pdfFilename string) PdfMetadata {
+		if backend := config.backendFor(pdfFilename); backend != BackendExiftool {
+			localPath, release, err := localFile(pdfFilename)
+			if err != nil {
+				fmt.Printf("Error concerning %v: %v\n", pdfFilename, err)
+				return PdfMetadata{}
+			}
+			defer release()
+			extract := extractTika
+			if backend == BackendOffice {
+				extract = extractOffice
+			}
+			metadata, err := extract(localPath)
+			if err != nil {
+				fmt.Printf("Error concerning %v: %v\n", pdfFilename, err)
+			}
+			return metadata
+		}
+		if (tools[worker] == nil) && !failed[worker] {
+			et, err := exiftool.NewExiftool()
+			if err != nil {
+				log.Printf("Error when intializing: %v\n", err)
+				failed[worker] = true
+			} else {
+				metrics.Add(metrics.ExiftoolProcesses, 1)
+				tools[worker] = et
+			}
+		}
+		if tools[worker] == nil {
+			return PdfMetadata{}
+		}
+		localPath, release, err := localFile(pdfFilename)
+		if err != nil {
+			fmt.Printf("Error concerning %v: %v\n", pdfFilename, err)
+			return PdfMetadata{}
+		}
+		defer release()
+		metrics.Add(metrics.ExiftoolFiles, 1)
+		return extractWith(tools[worker], localPath)
+	})
+
+	for _, et := range tools {
+		if et != nil {
+			et.Close()
+		}
+	}
+	return results, completed
+}
+
+// Like ExtractPdfMetadataFiles, but consults the cache first.
+// keys[i] is the cache key (see CacheKey) for pdfFilenames[i]; a blank key is never cached.
+// Only files missing from the cache (or all files, if refresh is true) are passed to exiftool, and their results are added to the cache.
+func ExtractPdfMetadataFilesCached(pdfFilenames []string, keys []string, cache *Cache, refresh bool, workers int) []PdfMetadata {
+	return extractCached(pdfFilenames, nativeFile, keys, cache, refresh, workers)
+}
+
+// Like ExtractPdfMetadataFilesCached, for named files in fsys. A file in a remote root is fetched to a temporary
+// copy for exiftool, but only if its metadata is not already cached.
+func ExtractPdfMetadataFSCached(fsys archivefs.FS, names []string, keys []string, cache *Cache, refresh bool, workers int) []PdfMetadata {
+	localFile := func(name string) (string, func(), error) { return archivefs.LocalFile(fsys, name) }
+	return extractCached(names, localFile, keys, cache, refresh, workers)
+}
+
+func extractCached(pdfFilenames []string, localFile localFileFunc, keys []string, cache *Cache, refresh bool, workers int) []PdfMetadata {
+	results := make([]PdfMetadata, len(pdfFilenames))
+	var missingIndexes []int
+	var missingFilenames []string
+	for i, filename := range pdfFilenames {
+		if (cache != nil) && !refresh && (keys[i] != "") {
+			if metadata, found := cache.Lookup(keys[i]); found {
+				metrics.Add(metrics.ExifCacheHits, 1)
+				results[i] = metadata
+				continue
+			}
+		}
+		if cache != nil {
+			metrics.Add(metrics.ExifCacheMisses, 1)
+		}
+		missingIndexes = append(missingIndexes, i)
+		missingFilenames = append(missingFilenames, filename)
+	}
+
+	// Files that were never processed because the run was interrupted must not be cached
+	extracted, completed := extractFiles(missingFilenames, localFile, workers)
+	for j, i := range missingIndexes[:completed] {
+		results[i] = extracted[j]
+		if (cache != nil) && (keys[i] != "") {
+			cache.Update(keys[i], extracted[j])
+		}
+	}
+	return results
+}
+
+// Uses an existing exiftool instance to extract the metadata of interest from one PDF file.
+func extractWith(et *exiftool.Exiftool, pdfFilename string) PdfMetadata {
 	fileInfos := et.ExtractMetadata(pdfFilename)
 	metadata := PdfMetadata{}
 	for _, fileInfo := range fileInfos {
@@ -46,7 +218,41 @@ func ExtractPdfMetadata(pdfFilename string) PdfMetadata {
 				metadata.Modified = v.(string)
 			}
 		}
+		metadata.Encryption = exiftoolEncryption(fileInfo.Fields)
 	}
 
 	return metadata
 }
+
+// Returns how exiftool found a file to be encrypted. exiftool reads the Encryption of any encrypted PDF, but reports
+// an error (or, for some formats, a warning) that the document is password protected if it cannot open it.
+func exiftoolEncryption(fields map[string]interface{}) string {
+	for _, key := range []string{"Error", "Warning"} {
+		if message, ok := fields[key].(string); ok && strings.Contains(strings.ToLower(message), "password protected") {
+			return document.EncryptionPassword
+		}
+	}
+	if encryption, ok := fields["Encryption"].(string); ok && (encryption != "") {
+		return document.EncryptionRestricted
+	}
+	return ""
+}
+
+// Records extracted metadata in a document. The embedded title and creation date of a DOCX or ODT file are only used
+// if the document has no title (or publication date), or has only one guessed by code (see document.SetFlags).
+func Apply(doc *document.Document, metadata PdfMetadata) {
+	doc.PdfCreator = metadata.Creator
+	doc.PdfProducer = metadata.Producer
+	doc.PdfVersion = metadata.Format
+	doc.PdfModified = metadata.Modified
+	doc.Encryption = metadata.Encryption
+	if (metadata.Title != "") && ((doc.Title == "") || strings.Contains(doc.Flags, "T")) {
+		doc.Title = metadata.Title
+		document.SetFlags(doc, "T")
+	}
+	if (metadata.Created != "") && ((doc.PubDate == "") || strings.Contains(doc.Flags, "D")) {
+		doc.PubDate = metadata.Created
+		document.SetFlags(doc, "D")
+		pubdate.Clear(doc)
+	}
+}
@@ -0,0 +1,191 @@
+package pdfmetadata
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/metrics"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config says which backend extracts the metadata of each file format. It is read from the YAML file given with
+// --metadata-config, which looks like this:
+//
+//	tikaurl: http://localhost:9998
+//	backends:
+//	  DOC: tika
+//	  PS: tika
+//	  EPUB: tika
+//
+// A format is a filename extension (without the "."), with the synonyms used by document.DetermineDocumentFormat (so
+// HTM means HTML and TIF means TIFF). Any format not listed uses exiftool, so an empty configuration (or no file at all)
+// behaves exactly as before, except that DOCX and ODT files use the built-in office backend.
+//
+// The tika backend sends the file to an Apache Tika server ("java -jar tika-server.jar", or the apache/tika Docker
+// image) and maps what it reports into the same fields that exiftool fills. Tika recognises far more formats than
+// exiftool, notably Word, PostScript and EPUB. Cached metadata is not re-extracted when the backend changes: use
+// --refresh-exif for that.
+type Config struct {
+	TikaURL  string            // The base URL of the Tika server
+	Backends map[string]string // Format => backend
+}
+
+// The metadata backends
+const (
+	BackendExiftool = "exiftool"
+	BackendTika     = "tika"
+	BackendOffice   = "office" // Reads the metadata parts of DOCX and ODT files directly (see extractOffice)
+)
+
+// The configuration used for metadata extraction; see Configure
+var config = Config{}
+
+// The HTTP client used to talk to the Tika server. Large documents can take a while to parse.
+var tikaClient = &http.Client{Timeout: 5 * time.Minute, Transport: metrics.Transport(nil)}
+
+// Reads a Config from a YAML file. A blank filename gives the default configuration (exiftool for everything).
+func ReadConfig(filename string) (Config, error) {
+	var result Config
+	if filename == "" {
+		return result, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return result, err
+	}
+	if err := document.UnmarshalYaml(data, &result); err != nil {
+		return result, fmt.Errorf("%s: %w", filename, err)
+	}
+	backends := make(map[string]string)
+	for format, backend := range result.Backends {
+		backend = strings.ToLower(backend)
+		if (backend != BackendExiftool) && (backend != BackendTika) && (backend != BackendOffice) {
+			return result, fmt.Errorf("%s: unknown backend %q for %s (expected %s, %s or %s)", filename, backend, format, BackendExiftool, BackendTika, BackendOffice)
+		}
+		if (backend == BackendOffice) && !officeFormats[normaliseFormat(format)] {
+			return result, fmt.Errorf("%s: the %s backend cannot read %s", filename, backend, format)
+		}
+		backends[normaliseFormat(format)] = backend
+		if (backend == BackendTika) && (result.TikaURL == "") {
+			return result, fmt.Errorf("%s: %s uses tika, but there is no tikaurl", filename, format)
+		}
+	}
+	result.Backends = backends
+	if result.TikaURL != "" {
+		if _, err := url.Parse(result.TikaURL); err != nil {
+			return result, fmt.Errorf("%s: bad tikaurl %q: %w", filename, result.TikaURL, err)
+		}
+		result.TikaURL = strings.TrimSuffix(result.TikaURL, "/")
+	}
+	return result, nil
+}
+
+// Sets the configuration used for metadata extraction from now on
+func Configure(c Config) {
+	config = c
+}
+
+// Returns the backend that extracts the metadata of the named file, according to its format
+func (c Config) backendFor(filename string) string {
+	format := normaliseFormat(filepath.Ext(filename))
+	if backend, found := c.Backends[format]; found {
+		return backend
+	}
+	if officeFormats[format] {
+		return BackendOffice
+	}
+	return BackendExiftool
+}
+
+// Turns a filename extension (with or without the ".") into a format, e.g. ".htm" => HTML
+func normaliseFormat(extension string) string {
+	format := strings.ToUpper(strings.TrimPrefix(extension, "."))
+	if recategorised, found := document.FileTypesToRecategorise[format]; found {
+		return recategorised
+	}
+	return format
+}
+
+// The Tika metadata keys that supply each field, in order of preference. Tika reports the PDF document information
+// dictionary under "pdf:docinfo:" and the XMP metadata under other prefixes; Office formats use their own keys.
+var (
+	tikaCreatorKeys   = []string{"xmp:CreatorTool", "pdf:docinfo:creator_tool", "extended-properties:Application", "Application-Name"}
+	tikaProducerKeys  = []string{"pdf:producer", "pdf:docinfo:producer", "producer"}
+	tikaVersionKeys   = []string{"pdf:PDFVersion"}
+	tikaModifiedKeys  = []string{"dcterms:modified", "pdf:docinfo:modified", "Last-Modified", "modified"}
+	tikaEncryptedKeys = []string{"pdf:encrypted"}
+)
+
+// Sends a file to the Tika server and returns the metadata of interest
+func extractTika(filename string) (PdfMetadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return PdfMetadata{}, err
+	}
+	defer file.Close()
+
+	request, err := http.NewRequest(http.MethodPut, config.TikaURL+"/meta", file)
+	if err != nil {
+		return PdfMetadata{}, err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := tikaClient.Do(request)
+	if err != nil {
+		return PdfMetadata{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		// Tika cannot parse a file it cannot decrypt, but says why
+		if (response.StatusCode == http.StatusUnprocessableEntity) && bytes.Contains(message, []byte("EncryptedDocumentException")) {
+			return PdfMetadata{Encryption: document.EncryptionPassword}, nil
+		}
+		return PdfMetadata{}, fmt.Errorf("tika: %s %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	var fields map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&fields); err != nil {
+		return PdfMetadata{}, fmt.Errorf("tika: cannot understand response: %w", err)
+	}
+	return tikaMetadata(fields), nil
+}
+
+// Maps the metadata reported by Tika into the same form as exiftool's
+func tikaMetadata(fields map[string]interface{}) PdfMetadata {
+	metadata := PdfMetadata{
+		Creator:  tikaField(fields, tikaCreatorKeys),
+		Producer: tikaField(fields, tikaProducerKeys),
+		Format:   tikaField(fields, tikaVersionKeys),
+		// Tika gives ISO 8601 dates (e.g. 2001-05-14T17:22:01Z), exiftool gives 2001:05:14 17:22:01Z
+		Modified: exiftoolDate(tikaField(fields, tikaModifiedKeys)),
+	}
+	if tikaField(fields, tikaEncryptedKeys) == "true" {
+		metadata.Encryption = document.EncryptionRestricted
+	}
+	return metadata
+}
+
+// Returns the first value found under any of the keys. A key with several values gives the first of them.
+func tikaField(fields map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case []interface{}:
+			for _, v := range value {
+				if s, ok := v.(string); ok && (s != "") {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
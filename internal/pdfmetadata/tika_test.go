@@ -0,0 +1,103 @@
+package pdfmetadata
+
+import (
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "metadata.yaml")
+	os.WriteFile(filename, []byte("tikaurl: http://localhost:9998/\nbackends:\n  doc: Tika\n  htm: tika\n  PDF: exiftool\n"), 0644)
+	c, err := ReadConfig(filename)
+	if err != nil {
+		t.Fatalf(`ReadConfig() failed: %v`, err)
+	}
+	if c.TikaURL != "http://localhost:9998" {
+		t.Errorf(`ReadConfig() TikaURL = %s`, c.TikaURL)
+	}
+	tests := map[string]string{"a/b.DOC": BackendTika, "x.html": BackendTika, "x.htm": BackendTika, "x.pdf": BackendExiftool, "x.ps": BackendExiftool, "x.odt": BackendOffice}
+	for name, expected := range tests {
+		if backend := c.backendFor(name); backend != expected {
+			t.Errorf(`backendFor(%s) = %s, expected %s`, name, backend, expected)
+		}
+	}
+
+	os.WriteFile(filename, []byte("backends:\n  DOC: tika\n"), 0644)
+	if _, err := ReadConfig(filename); (err == nil) || !strings.Contains(err.Error(), "no tikaurl") {
+		t.Errorf(`ReadConfig() without a tikaurl = %v`, err)
+	}
+	os.WriteFile(filename, []byte("tikaurl: http://localhost:9998\nbackends:\n  DOC: magic\n"), 0644)
+	if _, err := ReadConfig(filename); (err == nil) || !strings.Contains(err.Error(), "unknown backend") {
+		t.Errorf(`ReadConfig() with an unknown backend = %v`, err)
+	}
+	os.WriteFile(filename, []byte("backends:\n  PDF: office\n"), 0644)
+	if _, err := ReadConfig(filename); (err == nil) || !strings.Contains(err.Error(), "cannot read PDF") {
+		t.Errorf(`ReadConfig() with office for PDF = %v`, err)
+	}
+	if c, err := ReadConfig(""); (err != nil) || (c.backendFor("x.doc") != BackendExiftool) {
+		t.Errorf(`ReadConfig("") = %+v, %v`, c, err)
+	}
+}
+
+func TestExtractTika(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if (r.Method != http.MethodPut) || (r.URL.Path != "/meta") || (r.Header.Get("Accept") != "application/json") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if string(body) != "%!PS-Adobe-3.0" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, "cannot parse")
+			return
+		}
+		fmt.Fprint(w, `{"Content-Type":"application/postscript","xmp:CreatorTool":["dvips(k) 5.86",""],"producer":"Ghostscript","dcterms:modified":"1995-06-01T12:30:00Z"}`)
+	}))
+	defer server.Close()
+	saved := config
+	defer Configure(saved)
+	Configure(Config{TikaURL: server.URL, Backends: map[string]string{"PS": BackendTika}})
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "manual.ps")
+	bad := filepath.Join(dir, "broken.ps")
+	os.WriteFile(good, []byte("%!PS-Adobe-3.0"), 0644)
+	os.WriteFile(bad, []byte("junk"), 0644)
+
+	results := ExtractPdfMetadataFiles([]string{good, bad}, 1)
+	expected := PdfMetadata{Creator: "dvips(k) 5.86", Producer: "Ghostscript", Modified: "1995:06:01 12:30:00Z"}
+	if results[0] != expected {
+		t.Errorf(`ExtractPdfMetadataFiles() = %+v, expected %+v`, results[0], expected)
+	}
+	if results[1] != (PdfMetadata{}) {
+		t.Errorf(`ExtractPdfMetadataFiles() of a file Tika rejects = %+v`, results[1])
+	}
+	if _, err := extractTika(bad); (err == nil) || !strings.Contains(err.Error(), "cannot parse") {
+		t.Errorf(`extractTika() of a file Tika rejects = %v`, err)
+	}
+}
+
+func TestExtractTikaEncrypted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, "org.apache.tika.exception.EncryptedDocumentException: Unable to process: document is encrypted")
+	}))
+	defer server.Close()
+	saved := config
+	defer Configure(saved)
+	Configure(Config{TikaURL: server.URL})
+
+	filename := filepath.Join(t.TempDir(), "secret.pdf")
+	os.WriteFile(filename, []byte("%PDF-1.6"), 0644)
+	if metadata, err := extractTika(filename); (err != nil) || (metadata != PdfMetadata{Encryption: document.EncryptionPassword}) {
+		t.Errorf(`extractTika() of a password-protected file = %+v, %v`, metadata, err)
+	}
+}
@@ -0,0 +1,124 @@
+package persistentstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"docs-to-yaml/internal/document"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Implement an enum for the supported store file formats
+type Format int
+
+// These are the legal Format enum values
+const (
+	FormatYAML     Format = iota // Plain YAML, as written by document.MarshalYaml (the original format)
+	FormatGzipYAML               // The same YAML, gzip compressed
+	FormatGob                    // encoding/gob; compact and fast, but only readable by Go programs
+	FormatSQLite                 // An SQLite database, which can be queried with the sqlite3 shell (see sqlite.go)
+)
+
+// This turns Format enums into a text string
+func (format Format) String() string {
+	return [...]string{"yaml", "yaml.gz", "gob", "sqlite"}[format]
+}
+
+// Parses a format name, as produced by Format.String
+func ParseFormat(name string) (Format, error) {
+	for _, format := range []Format{FormatYAML, FormatGzipYAML, FormatGob, FormatSQLite} {
+		if strings.EqualFold(name, format.String()) {
+			return format, nil
+		}
+	}
+	return FormatYAML, fmt.Errorf("unknown store format %q (expected yaml, yaml.gz, gob or sqlite)", name)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// Works out the format implied by a filename's extension. Anything unrecognised is taken to be YAML.
+func FormatFromFilename(filename string) Format {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz":
+		return FormatGzipYAML
+	case ".gob":
+		return FormatGob
+	case ".sqlite", ".sqlite3", ".db":
+		return FormatSQLite
+	}
+	return FormatYAML
+}
+
+// Works out the format of a store file from its content, falling back on its extension.
+// gzip and SQLite files are recognised by content; gob has no reliable signature so relies on the extension.
+func DetectFormat(filename string, data []byte) (Format, error) {
+	if bytes.HasPrefix(data, sqliteMagic) {
+		return FormatSQLite, nil
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		return FormatGzipYAML, nil
+	}
+	return FormatFromFilename(filename), nil
+}
+
+// Encodes the store data in the specified format
+func encode(data interface{}, format Format) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return document.MarshalYaml(data)
+	case FormatGzipYAML:
+		yamlData, err := document.MarshalYaml(data)
+		if err != nil {
+			return nil, err
+		}
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(yamlData); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case FormatGob:
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(data); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case FormatSQLite:
+		return encodeSqlite(data)
+	}
+	return nil, fmt.Errorf("unknown store format %d", format)
+}
+
+// Decodes store data in the specified format into out (which must be a pointer to a map).
+// An empty file is valid in every format and decodes to nothing.
+func decode(data []byte, format Format, out interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	switch format {
+	case FormatYAML:
+		return document.UnmarshalYaml(data, out)
+	case FormatGzipYAML:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		yamlData, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return document.UnmarshalYaml(yamlData, out)
+	case FormatGob:
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(out)
+	case FormatSQLite:
+		return decodeSqlite(data, out)
+	}
+	return fmt.Errorf("unknown store format %d", format)
+}
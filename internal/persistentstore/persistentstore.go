@@ -1,28 +1,45 @@
 package persistentstore
 
 import (
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/workspace"
 	"fmt"
-	"log"
 	"os"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
-// This package implements a persistent map, which is preserved across invocations in a YAML file.
+// This package implements a persistent map, which is preserved across invocations in a file.
 // It is intended to be reasonably generic.
 // The key needs to be a comparable type (as the underlying representation is a map).
 // The stored data can be any type.
+//
+// The file may be held in any of the supported formats (see Format). The format of an existing file is detected
+// from its content (or, failing that, its extension) and the store is saved back in the same format,
+// so stores can be converted (see Convert) one at a time. In particular, a store held as an SQLite database
+// (see FormatSQLite) can be queried with the sqlite3 shell.
+//
+// Optionally (see EnableTimestamps) the store also records when each entry was last updated, in a companion
+// file alongside the store, so that callers can refresh entries that are older than some maximum age.
 
 // The Store type records the persistent data  and tracks whether the data has been modified
 type Store[K comparable, T any] struct {
 	Active bool    // True if the cache is in use
 	Dirty  bool    // True if the cache has been modified (and should be written out)
 	Data   map[K]T // A cache of key => stored-data
+
+	filename         string            // The file the store was initialised from
+	path             string            // The file read for filename, if it is a workspace name
+	format           Format            // The format of that file
+	autosaveFilename string            // If autosave is enabled, the file to save to
+	autosaveTimer    *checkpoint.Timer // If autosave is enabled, decides when to save
+	timestamps       map[K]time.Time   // If timestamps are enabled, when each entry was last updated
 }
 
-// Initialises the persistent store from a YAML file (with presumably appropriate data).
-// If the YAML file does not exist, it may optionally be created.
-// Data from the file is unmarshalled into the store.
+// Initialises the persistent store from a file (with presumably appropriate data) in any supported format.
+// If the file does not exist, it may optionally be created; it will be written in the format implied by its extension.
+// Data from the file is decoded into the store.
 //
 // On successful exit a pointer to the store and a nil error are returned.
 func (Store[K, T]) Init(storeFilename string, createIfMissing bool, verbose bool) (*Store[K, T], error) {
@@ -30,6 +47,9 @@ func (Store[K, T]) Init(storeFilename string, createIfMissing bool, verbose bool
 	store.Active = false
 	store.Dirty = false
 	store.Data = make(map[K]T)
+	if workspace.IsName(storeFilename) {
+		return store, store.initArtifact(storeFilename, createIfMissing, verbose)
+	}
 	if storeFilename != "" {
 		file, err := os.ReadFile(storeFilename)
 		if err != nil {
@@ -54,11 +74,16 @@ func (Store[K, T]) Init(storeFilename string, createIfMissing bool, verbose bool
 			}
 		}
 		store.Active = true
-		// Read the existing cache YAML data into the cache
-		err = yaml.Unmarshal(file, store.Data)
+		store.filename = storeFilename
+		store.format, err = DetectFormat(storeFilename, file)
+		if err != nil {
+			return store, err
+		}
+		// Read the existing cache data into the cache
+		err = decode(file, store.format, &store.Data)
 		if err != nil {
 			if verbose {
-				fmt.Println("persistentstore: failed to unmarshal")
+				fmt.Printf("persistentstore: failed to decode %s data\n", store.format)
 			}
 			return store, err
 		}
@@ -70,6 +95,41 @@ func (Store[K, T]) Init(storeFilename string, createIfMissing bool, verbose bool
 	return store, nil
 }
 
+// Initialises the store from the latest artifact of a workspace name (see internal/workspace). If there is none yet
+// the store starts empty (if createIfMissing is set), to be written as the name's first artifact.
+func (thing *Store[K, T]) initArtifact(name string, createIfMissing bool, verbose bool) error {
+	path, err := workspace.Resolve(name)
+	if os.IsNotExist(err) && createIfMissing {
+		thing.Active = true
+		thing.filename = name
+		thing.format = FormatFromFilename(name)
+		fmt.Printf("Starting empty store: %s\n", name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	thing.Active = true
+	thing.filename = name
+	thing.path = path
+	if thing.format, err = DetectFormat(path, file); err != nil {
+		return err
+	}
+	if err := decode(file, thing.format, &thing.Data); err != nil {
+		if verbose {
+			fmt.Printf("persistentstore: failed to decode %s data\n", thing.format)
+		}
+		return err
+	}
+	if verbose {
+		fmt.Printf("Initial number of store entries: %d from %s\n", len(thing.Data), path)
+	}
+	return nil
+}
+
 // Performs a lookup in the store and retrieves the data (if any) stored against the given key.
 // The return mimics that returned by a map, i.e. the value and a boolean true if the key exists.
 func (thing *Store[K, T]) Lookup(key K) (T, bool) {
@@ -88,22 +148,153 @@ func (thing *Store[K, T]) IsModified() bool {
 func (thing *Store[K, T]) Update(key K, data T) {
 	thing.Data[key] = data
 	thing.Dirty = true
+	if thing.timestamps != nil {
+		thing.timestamps[key] = time.Now()
+	}
+	if thing.autosaveTimer.Add(1) {
+		thing.Save(thing.autosaveFilename)
+	}
+}
+
+// Enables periodic saving of the store during a long run, according to the supplied policy.
+// Each Update counts as one item. This means that an interrupted run loses at most one checkpoint's worth of work.
+func (thing *Store[K, T]) EnableAutosave(filename string, policy checkpoint.Policy) {
+	if (filename == "") || !policy.Enabled() {
+		return
+	}
+	thing.autosaveFilename = filename
+	thing.autosaveTimer = checkpoint.NewTimer(policy)
+}
+
+// Returns the name of the companion file that holds the timestamps for the specified store file
+func TimestampsFilename(storeFilename string) string {
+	return storeFilename + ".timestamps"
+}
+
+// Starts recording when each entry is updated, loading any timestamps saved by previous runs.
+// Entries that have never been timestamped (e.g. those written before timestamps were enabled) have no age
+// and are therefore always considered stale.
+func (thing *Store[K, T]) EnableTimestamps() error {
+	thing.timestamps = make(map[K]time.Time)
+	if thing.filename == "" {
+		return nil
+	}
+	filename := TimestampsFilename(thing.filename)
+	if workspace.IsName(thing.filename) {
+		if thing.path == "" {
+			return nil
+		}
+		filename = TimestampsFilename(thing.path)
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	format, err := DetectFormat(filename, data)
+	if err != nil {
+		return err
+	}
+	return decode(data, format, &thing.timestamps)
+}
+
+// Returns how long ago the entry for the specified key was last updated.
+// The boolean is false if the time is unknown (or timestamps are not enabled).
+func (thing *Store[K, T]) Age(key K) (time.Duration, bool) {
+	updated, found := thing.timestamps[key]
+	if !found {
+		return 0, false
+	}
+	return time.Since(updated), true
+}
+
+// Returns the time the entry for the specified key was last updated, if known.
+func (thing *Store[K, T]) Updated(key K) (time.Time, bool) {
+	updated, found := thing.timestamps[key]
+	return updated, found
+}
+
+// Returns true if the entry for the specified key is older than maxAge, or its age is unknown.
+func (thing *Store[K, T]) IsStale(key K, maxAge time.Duration) bool {
+	age, known := thing.Age(key)
+	return !known || (age > maxAge)
+}
+
+// Records that the entry for the specified key has been confirmed as current, without changing its data.
+func (thing *Store[K, T]) Touch(key K) {
+	if thing.timestamps != nil {
+		thing.timestamps[key] = time.Now()
+		thing.Dirty = true
+	}
 }
 
 // Save the stored data, if it has changed.
 //
-// Data is stored as YAML in the specified file.
+// Data is stored in the specified file, in the format the store was read in (or, for a different file,
+// the format implied by its extension). If timestamps are enabled, they are saved to the companion file in the same format.
+// The file is replaced atomically, so an interrupted save leaves the previous version intact.
+// Once saved, the store is no longer considered modified.
 func (thing *Store[K, T]) Save(filename string) {
 	if thing.Active && thing.Dirty {
 		fmt.Println("Writing **new** Store")
-		data, err := yaml.Marshal(thing.Data)
-		if err != nil {
-			log.Fatal("Bad Store.Data: ", err)
+		format := FormatFromFilename(filename)
+		if filename == thing.filename {
+			format = thing.format
+		}
+		artifact, err := workspace.Create(filename)
+		if err == nil {
+			err = thing.write(artifact.Path, format)
 		}
-		err = os.WriteFile(filename, data, 0644)
 		if err != nil {
-			log.Fatal("Failed Store.Data write: ", err)
+			exitcode.Fatal("Failed Store.Data write: ", err)
 		}
+		if thing.timestamps != nil {
+			data, err := encode(thing.timestamps, format)
+			if err == nil {
+				err = fsutil.WriteFileAtomic(TimestampsFilename(artifact.Path), data, 0644)
+			}
+			if err != nil {
+				exitcode.Fatal("Failed Store timestamps write: ", err)
+			}
+		}
+		if err := artifact.Commit(); err != nil {
+			exitcode.Fatal("Failed Store write: ", err)
+		}
+		thing.Dirty = false
+	}
+
+}
+
+// Writes the stored data to the specified file in the specified format, whether or not it has changed.
+// The file is replaced atomically; a workspace name is written as a new artifact that becomes its latest.
+func (thing *Store[K, T]) SaveAs(filename string, format Format) error {
+	artifact, err := workspace.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := thing.write(artifact.Path, format); err != nil {
+		artifact.Abandon()
+		return err
 	}
+	return artifact.Commit()
+}
 
+// Writes the stored data to the specified file in the specified format, atomically
+func (thing *Store[K, T]) write(filename string, format Format) error {
+	data, err := encode(thing.Data, format)
+	if err != nil {
+		return fmt.Errorf("bad Store.Data: %w", err)
+	}
+	return fsutil.WriteFileAtomic(filename, data, 0644)
+}
+
+// Converts the store held in one file into another file, in the specified format.
+// Every entry is carried across unchanged. Returns the number of entries converted.
+func Convert[K comparable, T any](inputFilename string, outputFilename string, format Format) (int, error) {
+	store, err := Store[K, T]{}.Init(inputFilename, false, false)
+	if err != nil {
+		return 0, err
+	}
+	return len(store.Data), store.SaveAs(outputFilename, format)
 }
@@ -0,0 +1,180 @@
+package persistentstore
+
+import (
+	"docs-to-yaml/internal/workspace"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	Name string
+	Size int64
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	original := map[string]testRecord{"a": {Name: "alpha", Size: 1}, "b": {Name: "bravo", Size: 22}}
+
+	store, err := Store[string, testRecord]{}.Init(filepath.Join(dir, "new.yaml"), true, false)
+	if err != nil {
+		t.Fatalf(`Init() failed: %v`, err)
+	}
+	for k, v := range original {
+		store.Update(k, v)
+	}
+	store.Save(filepath.Join(dir, "new.yaml"))
+
+	// YAML => gob => gzip => SQLite => YAML, checking the detected format and the data at each step
+	steps := []struct {
+		filename string
+		format   Format
+	}{
+		{"store.gob", FormatGob},
+		{"store.gz", FormatGzipYAML},
+		{"store.sqlite", FormatSQLite},
+		{"final.yaml", FormatYAML},
+	}
+	previous := filepath.Join(dir, "new.yaml")
+	for _, step := range steps {
+		next := filepath.Join(dir, step.filename)
+		count, err := Convert[string, testRecord](previous, next, step.format)
+		if (err != nil) || (count != len(original)) {
+			t.Fatalf(`Convert(%s) = %d, %v`, step.filename, count, err)
+		}
+		converted, err := Store[string, testRecord]{}.Init(next, false, false)
+		if err != nil {
+			t.Fatalf(`Init(%s) failed: %v`, step.filename, err)
+		}
+		if converted.format != step.format {
+			t.Errorf(`Init(%s) detected %s, expected %s`, step.filename, converted.format, step.format)
+		}
+		if !reflect.DeepEqual(converted.Data, original) {
+			t.Errorf(`%s data = %v, expected %v`, step.filename, converted.Data, original)
+		}
+		previous = next
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     []byte
+		expected Format
+		fails    bool
+	}{
+		{"md5.store", []byte("a: b\n"), FormatYAML, false},
+		{"md5.store", []byte{0x1f, 0x8b, 0x08}, FormatGzipYAML, false},
+		{"md5.gob", []byte{0x0e, 0xff}, FormatGob, false},
+		{"md5.yaml.gz", nil, FormatGzipYAML, false},
+		{"md5.store", []byte("SQLite format 3\x00..."), FormatSQLite, false},
+		{"md5.db", nil, FormatSQLite, false},
+	}
+	for _, test := range tests {
+		format, err := DetectFormat(test.filename, test.data)
+		if (err != nil) != test.fails {
+			t.Errorf(`DetectFormat(%s) error = %v`, test.filename, err)
+		} else if !test.fails && (format != test.expected) {
+			t.Errorf(`DetectFormat(%s) = %s, expected %s`, test.filename, format, test.expected)
+		}
+	}
+}
+
+func TestSaveKeepsFormat(t *testing.T) {
+	// A gzip store with a misleading name must be written back as gzip
+	filename := filepath.Join(t.TempDir(), "md5.store")
+	if _, err := Convert[string, string](filepath.Join(t.TempDir(), "missing"), filename, FormatGzipYAML); err == nil {
+		t.Fatalf(`Convert() of a missing file succeeded`)
+	}
+	store := &Store[string, string]{Active: true, Data: map[string]string{"k": "v"}}
+	if err := store.SaveAs(filename, FormatGzipYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Store[string, string]{}.Init(filename, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Update("k2", "v2")
+	reloaded.Save(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, _ := DetectFormat(filename, data); format != FormatGzipYAML {
+		t.Errorf(`store was rewritten as %s`, format)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "filesize.store")
+	if err := os.WriteFile(filename, []byte("old: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Store[string, int64]{}.Init(filename, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableTimestamps(); err != nil {
+		t.Fatalf(`EnableTimestamps() failed: %v`, err)
+	}
+	if !store.IsStale("old", time.Hour) {
+		t.Errorf(`an entry without a timestamp is not stale`)
+	}
+	store.Update("new", 20)
+	store.Touch("old")
+	if store.IsStale("new", time.Hour) || store.IsStale("old", time.Hour) {
+		t.Errorf(`freshly updated entries are stale`)
+	}
+	store.Save(filename)
+
+	reloaded, err := Store[string, int64]{}.Init(filename, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.EnableTimestamps(); err != nil {
+		t.Fatal(err)
+	}
+	if age, known := reloaded.Age("new"); !known || (age > time.Hour) {
+		t.Errorf(`Age("new") after reload = %v, %t`, age, known)
+	}
+	if !reloaded.IsStale("new", 0) {
+		t.Errorf(`IsStale() with a zero maximum age returned false`)
+	}
+}
+
+func TestWorkspaceStore(t *testing.T) {
+	t.Setenv(workspace.RootVariable, t.TempDir())
+	store, err := Store[string, int64]{}.Init("ws:stores/filesize.yaml", true, false)
+	if err != nil {
+		t.Fatalf(`Init() of a new workspace store failed: %v`, err)
+	}
+	if err := store.EnableTimestamps(); err != nil {
+		t.Fatal(err)
+	}
+	store.Update("a", 10)
+	store.Save("ws:stores/filesize.yaml")
+
+	reloaded, err := Store[string, int64]{}.Init("ws:stores/filesize.yaml", false, false)
+	if err != nil {
+		t.Fatalf(`Init() of a workspace store failed: %v`, err)
+	}
+	if err := reloaded.EnableTimestamps(); err != nil {
+		t.Fatal(err)
+	}
+	if size, found := reloaded.Lookup("a"); !found || (size != 10) {
+		t.Errorf(`Lookup("a") after reload = %d, %t`, size, found)
+	}
+	if _, known := reloaded.Age("a"); !known {
+		t.Errorf(`the timestamps were not saved beside the store`)
+	}
+	reloaded.Update("b", 20)
+	reloaded.Save("ws:stores/filesize.yaml")
+	entries, _ := workspace.List(workspace.Root())
+	if (len(entries) != 1) || (len(entries[0].Artifacts) != 2) {
+		t.Errorf(`saving a workspace store twice gave %+v`, entries)
+	}
+}
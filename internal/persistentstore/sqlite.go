@@ -0,0 +1,573 @@
+package persistentstore
+
+import (
+	"docs-to-yaml/internal/document"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A store in FormatSQLite is an SQLite database holding a single table:
+//
+//	CREATE TABLE store(key TEXT, value TEXT)
+//
+// so that it can be queried with the sqlite3 shell or any SQLite binding, e.g.
+//
+//	sqlite3 bin/md5.sqlite "SELECT key, value FROM store WHERE key LIKE 'DEC_0001//%'"
+//
+// A key or value that is a string is stored as it is; any other is stored as YAML, as it would be in a YAML store.
+//
+// There is no SQLite library to hand, so the database is written and read directly in the SQLite file format
+// (see https://www.sqlite.org/fileformat.html). Writing produces a fresh database holding just the table. Reading
+// accepts any UTF-8 database whose "store" table is an ordinary (rowid) table with the key and value as its first two
+// columns, so a store that has been edited with the sqlite3 shell can still be read, provided it is not in WAL mode.
+
+const (
+	sqlitePageSize = 4096
+	sqliteTable    = "store"
+	sqliteSchema   = "CREATE TABLE store(key TEXT, value TEXT)"
+	sqliteVersion  = 3040001 // The SQLite version recorded as having written the file
+
+	sqliteLeafPage     = 0x0d // A table b-tree leaf page
+	sqliteInteriorPage = 0x05 // A table b-tree interior page
+)
+
+// sqliteCell is a row, as held in a table b-tree leaf page
+type sqliteCell struct {
+	rowid         int64
+	local         []byte   // The cell as it appears in the page, without the first overflow page number
+	overflow      [][]byte // The rest of the payload, one overflow page's worth at a time
+	firstOverflow uint32
+}
+
+// Returns the size of the cell in its page
+func (cell *sqliteCell) size() int {
+	if len(cell.overflow) > 0 {
+		return len(cell.local) + 4
+	}
+	return len(cell.local)
+}
+
+// sqliteNode is a page of a table b-tree
+type sqliteNode struct {
+	page     uint32
+	cells    []*sqliteCell // For a leaf
+	children []*sqliteNode // For an interior page; the last is its right-most child
+	maxRowid int64
+}
+
+// Encodes a map as an SQLite database
+func encodeSqlite(data interface{}) ([]byte, error) {
+	entries := reflect.ValueOf(data)
+	if entries.Kind() != reflect.Map {
+		return nil, fmt.Errorf("cannot store %T in SQLite", data)
+	}
+	type row struct{ key, value string }
+	var rows []row
+	iterator := entries.MapRange()
+	for iterator.Next() {
+		key, err := sqliteText(iterator.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := sqliteText(iterator.Value())
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row{key, value})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key < rows[j].key })
+
+	// Pack the rows into leaf pages, then build interior pages above them until one page, the root, remains
+	leaf := &sqliteNode{}
+	leaves := []*sqliteNode{leaf}
+	used := 0
+	for i, row := range rows {
+		cell := newSqliteCell(int64(i+1), sqliteRecord(row.key, row.value))
+		if used+cell.size()+2 > sqlitePageSize-8 {
+			leaf = &sqliteNode{}
+			leaves = append(leaves, leaf)
+			used = 0
+		}
+		leaf.cells = append(leaf.cells, cell)
+		leaf.maxRowid = cell.rowid
+		used += cell.size() + 2
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = sqliteInteriorLevel(level)
+	}
+
+	// Page 1 holds the schema and page 2 the root of the table; the rest of the table and then the overflow pages follow
+	pages := uint32(1)
+	var number func(node *sqliteNode)
+	var nodes []*sqliteNode
+	number = func(node *sqliteNode) {
+		pages += 1
+		node.page = pages
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			number(child)
+		}
+	}
+	number(level[0])
+	var overflowPages [][]byte
+	for _, leaf := range leaves {
+		for _, cell := range leaf.cells {
+			if len(cell.overflow) > 0 {
+				cell.firstOverflow = pages + 1
+			}
+			for i, chunk := range cell.overflow {
+				page := make([]byte, sqlitePageSize)
+				pages += 1
+				if i < len(cell.overflow)-1 {
+					binary.BigEndian.PutUint32(page, pages+1)
+				}
+				copy(page[4:], chunk)
+				overflowPages = append(overflowPages, page)
+			}
+		}
+	}
+
+	database := make([]byte, 0, int(pages)*sqlitePageSize)
+	schema := newSqliteCell(1, sqliteRecord("table", sqliteTable, sqliteTable, int64(2), sqliteSchema))
+	page := sqliteHeader(pages)
+	writeSqlitePage(page, 100, []*sqliteCell{schema}, nil)
+	database = append(database, page...)
+	for _, node := range nodes {
+		page := make([]byte, sqlitePageSize)
+		writeSqlitePage(page, 0, node.cells, node.children)
+		database = append(database, page...)
+	}
+	for _, page := range overflowPages {
+		database = append(database, page...)
+	}
+	return database, nil
+}
+
+// Returns the text stored for a key or value: a string as it is, anything else as YAML
+func sqliteText(value reflect.Value) (string, error) {
+	if value.Kind() == reflect.String {
+		return value.String(), nil
+	}
+	data, err := document.MarshalYaml(value.Interface())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Builds the interior pages above a level of pages, sharing the children evenly between as few pages as will hold them
+func sqliteInteriorLevel(children []*sqliteNode) []*sqliteNode {
+	// Each child but the last takes a cell (a page number and a rowid) and a cell pointer
+	perPage := (sqlitePageSize-12)/(4+9+2) + 1
+	count := (len(children) + perPage - 1) / perPage
+	level := make([]*sqliteNode, count)
+	for i := range level {
+		level[i] = &sqliteNode{children: children[i*len(children)/count : (i+1)*len(children)/count]}
+		level[i].maxRowid = level[i].children[len(level[i].children)-1].maxRowid
+	}
+	return level
+}
+
+// Returns the cell holding a row of a table, splitting the record between the page and overflow pages if need be
+func newSqliteCell(rowid int64, record []byte) *sqliteCell {
+	cell := &sqliteCell{rowid: rowid}
+	cell.local = appendSqliteVarint(nil, uint64(len(record)))
+	cell.local = appendSqliteVarint(cell.local, uint64(rowid))
+	local := sqliteLocalPayload(len(record), sqlitePageSize)
+	cell.local = append(cell.local, record[:local]...)
+	for rest := record[local:]; len(rest) > 0; {
+		chunk := min(len(rest), sqlitePageSize-4)
+		cell.overflow = append(cell.overflow, rest[:chunk])
+		rest = rest[chunk:]
+	}
+	return cell
+}
+
+// Returns how much of a payload is held in a table leaf page, the rest going to overflow pages
+func sqliteLocalPayload(payload int, usable int) int {
+	maxLocal := usable - 35
+	if payload <= maxLocal {
+		return payload
+	}
+	minLocal := ((usable-12)*32)/255 - 23
+	local := minLocal + (payload-minLocal)%(usable-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// Returns an SQLite record holding the values, each a string or an int64
+func sqliteRecord(values ...interface{}) []byte {
+	var types, body []byte
+	for _, value := range values {
+		switch value := value.(type) {
+		case string:
+			types = appendSqliteVarint(types, uint64(13+2*len(value)))
+			body = append(body, value...)
+		case int64:
+			serialType, size := uint64(6), 8
+			for _, candidate := range []struct {
+				serialType uint64
+				size       int
+			}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+				if limit := int64(1) << (8*candidate.size - 1); (value >= -limit) && (value < limit) {
+					serialType, size = candidate.serialType, candidate.size
+					break
+				}
+			}
+			types = appendSqliteVarint(types, serialType)
+			var buffer [8]byte
+			binary.BigEndian.PutUint64(buffer[:], uint64(value))
+			body = append(body, buffer[8-size:]...)
+		}
+	}
+	// The header size includes the varint that holds it
+	headerSize := len(types) + 1
+	for len(appendSqliteVarint(nil, uint64(headerSize))) != headerSize-len(types) {
+		headerSize += 1
+	}
+	record := appendSqliteVarint(nil, uint64(headerSize))
+	record = append(record, types...)
+	return append(record, body...)
+}
+
+// Returns page 1, with the database header for a database of the given number of pages
+func sqliteHeader(pages uint32) []byte {
+	page := make([]byte, sqlitePageSize)
+	copy(page, sqliteMagic)
+	binary.BigEndian.PutUint16(page[16:], sqlitePageSize)
+	page[18], page[19] = 1, 1 // Rollback journal, not WAL
+	page[21], page[22], page[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(page[24:], 1) // File change counter
+	binary.BigEndian.PutUint32(page[28:], pages)
+	binary.BigEndian.PutUint32(page[40:], 1) // Schema cookie
+	binary.BigEndian.PutUint32(page[44:], 4) // Schema format
+	binary.BigEndian.PutUint32(page[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(page[92:], 1) // The change counter that the page count is valid for
+	binary.BigEndian.PutUint32(page[96:], sqliteVersion)
+	return page
+}
+
+// Writes a table b-tree page: a leaf holding cells, or an interior page pointing to children
+func writeSqlitePage(page []byte, offset int, cells []*sqliteCell, children []*sqliteNode) {
+	headerSize := 8
+	page[offset] = sqliteLeafPage
+	var contents [][]byte
+	for _, cell := range cells {
+		content := cell.local
+		if len(cell.overflow) > 0 {
+			content = binary.BigEndian.AppendUint32(append([]byte(nil), content...), cell.firstOverflow)
+		}
+		contents = append(contents, content)
+	}
+	if len(children) > 0 {
+		headerSize = 12
+		page[offset] = sqliteInteriorPage
+		for _, child := range children[:len(children)-1] {
+			contents = append(contents, appendSqliteVarint(binary.BigEndian.AppendUint32(nil, child.page), uint64(child.maxRowid)))
+		}
+		binary.BigEndian.PutUint32(page[offset+8:], children[len(children)-1].page)
+	}
+	start := len(page)
+	for i, content := range contents {
+		start -= len(content)
+		copy(page[start:], content)
+		binary.BigEndian.PutUint16(page[offset+headerSize+2*i:], uint16(start))
+	}
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(contents)))
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(start))
+}
+
+// Appends an SQLite varint: big-endian, seven bits a byte, except that a ninth byte holds eight
+func appendSqliteVarint(buffer []byte, value uint64) []byte {
+	var encoded [9]byte
+	if value > (1<<56)-1 {
+		encoded[8] = byte(value)
+		value >>= 8
+		for i := 7; i >= 0; i-- {
+			encoded[i] = byte(value&0x7f) | 0x80
+			value >>= 7
+		}
+		return append(buffer, encoded[:]...)
+	}
+	n := 8
+	for {
+		encoded[n] = byte(value & 0x7f)
+		if n < 8 {
+			encoded[n] |= 0x80
+		}
+		value >>= 7
+		if value == 0 {
+			break
+		}
+		n -= 1
+	}
+	return append(buffer, encoded[n:]...)
+}
+
+// Reads an SQLite varint, returning its value and length (0 if it is truncated)
+func readSqliteVarint(buffer []byte) (uint64, int) {
+	var value uint64
+	for i := 0; (i < 8) && (i < len(buffer)); i++ {
+		value = (value << 7) | uint64(buffer[i]&0x7f)
+		if buffer[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	if len(buffer) < 9 {
+		return 0, 0
+	}
+	return (value << 8) | uint64(buffer[8]), 9
+}
+
+// errSqliteCorrupt is returned when a database does not follow the SQLite file format
+var errSqliteCorrupt = errors.New("corrupt SQLite database")
+
+// sqliteReader reads the table b-trees of an SQLite database
+type sqliteReader struct {
+	data     []byte
+	pageSize int
+	usable   int // The page size less the bytes reserved at the end of each page
+	visited  map[uint32]bool
+}
+
+// Decodes an SQLite database into out, which must be a pointer to a map
+func decodeSqlite(data []byte, out interface{}) error {
+	if len(data) < 100 {
+		return errSqliteCorrupt
+	}
+	db := sqliteReader{data: data, pageSize: int(binary.BigEndian.Uint16(data[16:])), visited: map[uint32]bool{}}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(data[20])
+	if (db.pageSize < 512) || (db.usable < 480) {
+		return errSqliteCorrupt
+	}
+	if (data[18] == 2) || (data[19] == 2) {
+		return errors.New("SQLite database is in WAL mode: run PRAGMA journal_mode=DELETE on it first")
+	}
+	if encoding := binary.BigEndian.Uint32(data[56:]); (encoding != 0) && (encoding != 1) {
+		return errors.New("SQLite database is not UTF-8")
+	}
+
+	// Find the table in the schema, which is the table b-tree rooted at page 1
+	root := uint32(0)
+	err := db.walk(1, func(values []interface{}) error {
+		if (len(values) >= 4) && (values[0] == "table") && (values[1] == sqliteTable) {
+			if page, ok := values[3].(int64); ok && (page > 0) && (page <= math.MaxUint32) {
+				root = uint32(page)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		return fmt.Errorf("SQLite database has no %s table", sqliteTable)
+	}
+
+	entries := reflect.ValueOf(out).Elem()
+	if entries.IsNil() {
+		entries.Set(reflect.MakeMap(entries.Type()))
+	}
+	return db.walk(root, func(values []interface{}) error {
+		values = append(values, nil, nil)
+		key := reflect.New(entries.Type().Key()).Elem()
+		if err := setSqliteText(key, values[0]); err != nil {
+			return err
+		}
+		value := reflect.New(entries.Type().Elem()).Elem()
+		if err := setSqliteText(value, values[1]); err != nil {
+			return fmt.Errorf("%v: %w", values[0], err)
+		}
+		entries.SetMapIndex(key, value)
+		return nil
+	})
+}
+
+// Sets a key or value from the column that holds it (see sqliteText)
+func setSqliteText(target reflect.Value, column interface{}) error {
+	var text string
+	switch column := column.(type) {
+	case string:
+		text = column
+	case []byte:
+		text = string(column)
+	case int64:
+		text = strconv.FormatInt(column, 10)
+	case float64:
+		text = strconv.FormatFloat(column, 'g', -1, 64)
+	}
+	if target.Kind() == reflect.String {
+		target.SetString(text)
+		return nil
+	}
+	return document.UnmarshalYaml([]byte(text), target.Addr().Interface())
+}
+
+// Returns a page of the database
+func (db *sqliteReader) page(number uint32) ([]byte, error) {
+	start := (int64(number) - 1) * int64(db.pageSize)
+	if (number == 0) || (start+int64(db.pageSize) > int64(len(db.data))) {
+		return nil, errSqliteCorrupt
+	}
+	return db.data[start : start+int64(db.pageSize)], nil
+}
+
+// Calls visit with the values of each row of the table b-tree rooted at a page, in rowid order
+func (db *sqliteReader) walk(number uint32, visit func([]interface{}) error) error {
+	// A page belongs to just one b-tree, so a page seen twice means the tree has a cycle
+	if db.visited[number] {
+		return errSqliteCorrupt
+	}
+	db.visited[number] = true
+	page, err := db.page(number)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if number == 1 {
+		offset = 100
+	}
+	headerSize := 8
+	switch page[offset] {
+	case sqliteLeafPage:
+	case sqliteInteriorPage:
+		headerSize = 12
+	default:
+		return fmt.Errorf("SQLite page %d is not part of an ordinary table", number)
+	}
+	cells := int(binary.BigEndian.Uint16(page[offset+3:]))
+	if offset+headerSize+2*cells > db.usable {
+		return errSqliteCorrupt
+	}
+	for i := 0; i < cells; i++ {
+		pointer := int(binary.BigEndian.Uint16(page[offset+headerSize+2*i:]))
+		if pointer >= db.usable {
+			return errSqliteCorrupt
+		}
+		cell := page[pointer:db.usable]
+		if page[offset] == sqliteInteriorPage {
+			if len(cell) < 4 {
+				return errSqliteCorrupt
+			}
+			if err := db.walk(binary.BigEndian.Uint32(cell), visit); err != nil {
+				return err
+			}
+			continue
+		}
+		payload, err := db.payload(cell)
+		if err != nil {
+			return err
+		}
+		values, err := readSqliteRecord(payload)
+		if err != nil {
+			return err
+		}
+		if err := visit(values); err != nil {
+			return err
+		}
+	}
+	if page[offset] == sqliteInteriorPage {
+		return db.walk(binary.BigEndian.Uint32(page[offset+8:]), visit)
+	}
+	return nil
+}
+
+// Returns the payload of a table leaf cell, gathering any part held in overflow pages
+func (db *sqliteReader) payload(cell []byte) ([]byte, error) {
+	size, n := readSqliteVarint(cell)
+	if (n == 0) || (size > uint64(len(db.data))) {
+		return nil, errSqliteCorrupt
+	}
+	_, m := readSqliteVarint(cell[n:])
+	if m == 0 {
+		return nil, errSqliteCorrupt
+	}
+	cell = cell[n+m:]
+	local := sqliteLocalPayload(int(size), db.usable)
+	if (len(cell) < local) || ((local < int(size)) && (len(cell) < local+4)) {
+		return nil, errSqliteCorrupt
+	}
+	payload := append([]byte(nil), cell[:local]...)
+	if local == int(size) {
+		return payload, nil
+	}
+	next := binary.BigEndian.Uint32(cell[local:])
+	for len(payload) < int(size) {
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := min(int(size)-len(payload), db.usable-4)
+		payload = append(payload, page[4:4+chunk]...)
+		next = binary.BigEndian.Uint32(page)
+	}
+	return payload, nil
+}
+
+// Returns the values held in an SQLite record: nil, int64, float64, string or []byte
+func readSqliteRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := readSqliteVarint(record)
+	if (n == 0) || (headerSize > uint64(len(record))) {
+		return nil, errSqliteCorrupt
+	}
+	header, body := record[n:headerSize], record[headerSize:]
+	var values []interface{}
+	for len(header) > 0 {
+		serialType, n := readSqliteVarint(header)
+		if n == 0 {
+			return nil, errSqliteCorrupt
+		}
+		header = header[n:]
+		size := 0
+		switch {
+		case serialType == 0, serialType == 8, serialType == 9:
+		case serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case (serialType == 6) || (serialType == 7):
+			size = 8
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		default:
+			return nil, errSqliteCorrupt
+		}
+		if (size < 0) || (size > len(body)) {
+			return nil, errSqliteCorrupt
+		}
+		field := body[:size]
+		body = body[size:]
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType == 8, serialType == 9:
+			values = append(values, int64(serialType-8))
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serialType <= 6:
+			var buffer [8]byte
+			if field[0]&0x80 != 0 {
+				buffer = [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+			}
+			copy(buffer[8-size:], field)
+			values = append(values, int64(binary.BigEndian.Uint64(buffer[:])))
+		case serialType%2 == 0:
+			values = append(values, append([]byte(nil), field...))
+		default:
+			values = append(values, string(field))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,66 @@
+package persistentstore
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSqliteRoundTrip(t *testing.T) {
+	// Enough entries to need interior pages, and a value too big for one page to need overflow pages
+	many := map[string]string{}
+	for i := 0; i < 5000; i++ {
+		many[fmt.Sprintf("DEC_%04d//doc/%d.pdf", i%50, i)] = fmt.Sprintf("%032x", i)
+	}
+	many["big"] = strings.Repeat("0123456789", 2000)
+	tests := []struct {
+		name string
+		data interface{}
+		out  interface{}
+	}{
+		{"many", many, &map[string]string{}},
+		{"empty", map[string]string{}, &map[string]string{}},
+		{"records", map[string]testRecord{"a": {Name: "alpha", Size: 1}, "b": {Name: "bravo", Size: -22}}, &map[string]testRecord{}},
+		{"sizes", map[string]int64{"x": 1 << 40}, &map[string]int64{}},
+		{"timestamps", map[string]time.Time{"a": time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)}, &map[string]time.Time{}},
+	}
+	for _, test := range tests {
+		data, err := encode(test.data, FormatSQLite)
+		if err != nil {
+			t.Fatalf(`%s: encode() failed: %v`, test.name, err)
+		}
+		if format, _ := DetectFormat("store", data); format != FormatSQLite {
+			t.Errorf(`%s: detected %s`, test.name, format)
+		}
+		if err := decode(data, FormatSQLite, test.out); err != nil {
+			t.Fatalf(`%s: decode() failed: %v`, test.name, err)
+		}
+		if out := reflect.ValueOf(test.out).Elem().Interface(); !reflect.DeepEqual(out, test.data) {
+			t.Errorf(`%s: decode() = %v, expected %v`, test.name, out, test.data)
+		}
+	}
+}
+
+func TestSqliteCorrupt(t *testing.T) {
+	data, err := encode(map[string]string{"key": strings.Repeat("v", 10000)}, FormatSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every truncation and every single-byte corruption must fail cleanly, never panic
+	for length := 0; length < len(data); length += 97 {
+		decode(data[:length], FormatSQLite, &map[string]string{})
+	}
+	for offset := 0; offset < len(data); offset += 13 {
+		corrupt := append([]byte(nil), data...)
+		corrupt[offset] ^= 0xa5
+		decode(corrupt, FormatSQLite, &map[string]string{})
+	}
+
+	wal := append([]byte(nil), data...)
+	wal[18], wal[19] = 2, 2
+	if err := decode(wal, FormatSQLite, &map[string]string{}); err == nil {
+		t.Errorf(`decode() of a WAL database succeeded`)
+	}
+}
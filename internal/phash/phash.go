@@ -0,0 +1,164 @@
+package phash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This package compares scans by how they look rather than by their bytes, so that two scans of the same manual that
+// differ only in resolution or cropping can be recognised as such.
+//
+// Sample pages of each PDF are rendered (by pdftoppm, from poppler-utils) at a low resolution and each is reduced to a
+// 64-bit difference hash: the page is trimmed to its printed area (so a wider or narrower scanner margin does not
+// matter), shrunk to 9x8 grey cells, and each bit records whether a cell is brighter than its right-hand neighbour.
+// Hashes of the same page scanned twice differ in a few bits at most; hashes of different pages differ in about half.
+
+// Hash is the difference hash of one page
+type Hash uint64
+
+// The number of bits in a Hash
+const HashBits = 64
+
+// A pixel darker than this (out of 255) is part of the printed area of a page
+const inkLevel = 160
+
+// Returns the number of bits in which two hashes differ: 0 for the same page, about 32 for unrelated pages
+func Distance(a Hash, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// Returns the difference hash of an image
+func Of(img image.Image) Hash {
+	area := PrintedArea(img)
+	if area.Empty() {
+		area = img.Bounds()
+	}
+	// The mean grey level of each of the 9x8 cells
+	var cells [8][9]float64
+	for row := 0; row < 8; row++ {
+		for column := 0; column < 9; column++ {
+			cell := image.Rect(
+				area.Min.X+column*area.Dx()/9, area.Min.Y+row*area.Dy()/8,
+				area.Min.X+(column+1)*area.Dx()/9, area.Min.Y+(row+1)*area.Dy()/8)
+			cells[row][column] = meanGrey(img, cell)
+		}
+	}
+	var hash Hash
+	for row := 0; row < 8; row++ {
+		for column := 0; column < 8; column++ {
+			hash <<= 1
+			if cells[row][column] > cells[row][column+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Returns the smallest rectangle holding every dark pixel of an image: the printed area of a page.
+// Returns an empty rectangle for a blank page.
+func PrintedArea(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	area := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if grey(img, x, y) < inkLevel {
+				area = area.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return area
+}
+
+// Returns the grey level (0 to 255) of a pixel
+func grey(img image.Image, x int, y int) float64 {
+	return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+}
+
+// Returns the mean grey level of a rectangle of an image; a rectangle too small to hold a pixel takes the pixel at
+// its corner
+func meanGrey(img image.Image, r image.Rectangle) float64 {
+	if r.Empty() {
+		return grey(img, r.Min.X, r.Min.Y)
+	}
+	total := 0.0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			total += grey(img, x, y)
+		}
+	}
+	return total / float64(r.Dx()*r.Dy())
+}
+
+// Returns how alike two documents look, from 0 (nothing alike) to 1 (identical), given the hashes of their sample
+// pages. Each page is scored against the most similar page of the other document, so an added or missing cover page
+// lowers the score only a little; the score is the mean over the pages of both documents.
+func Similarity(a []Hash, b []Hash) float64 {
+	if (len(a) == 0) || (len(b) == 0) {
+		return 0
+	}
+	best := func(page Hash, pages []Hash) float64 {
+		nearest := HashBits
+		for _, other := range pages {
+			nearest = min(nearest, Distance(page, other))
+		}
+		return 1 - float64(nearest)/HashBits
+	}
+	total := 0.0
+	for _, page := range a {
+		total += best(page, b)
+	}
+	for _, page := range b {
+		total += best(page, a)
+	}
+	return total / float64(len(a)+len(b))
+}
+
+// Renders one page (counting from 1) of a PDF as a grey image at the given resolution (in dots per inch).
+// Tests replace this to avoid needing pdftoppm.
+var RenderPage = func(filename string, page int, dpi int) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "phash")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	number := strconv.Itoa(page)
+	output := filepath.Join(dir, "page")
+	cmd := exec.Command("pdftoppm", "-png", "-gray", "-r", strconv.Itoa(dpi), "-f", number, "-l", number, "-singlefile", filename, output)
+	var messages bytes.Buffer
+	cmd.Stderr = &messages
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(messages.String()))
+	}
+	file, err := os.Open(output + ".png")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// Returns the hashes of the given pages (counting from 1) of a PDF, rendered at dpi. Pages beyond the end of a short
+// document are skipped, but the first page asked for must render.
+func PdfHashes(filename string, pages []int, dpi int) ([]Hash, error) {
+	var hashes []Hash
+	for i, page := range pages {
+		img, err := RenderPage(filename, page, dpi)
+		if (err != nil) && (i == 0) {
+			return nil, err
+		} else if err != nil {
+			continue
+		}
+		hashes = append(hashes, Of(img))
+	}
+	return hashes, nil
+}
@@ -0,0 +1,89 @@
+package phash
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Returns a white page of the given size (in pixels at scale 1) with margin pixels of white around it, on which dark
+// blocks are drawn as given by ink (in unscaled pixels), everything then scaled up by scale
+func testPage(width int, height int, margin int, scale int, ink func(x int, y int) bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, (width+2*margin)*scale, (height+2*margin)*scale))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+			px, py := x/scale-margin, y/scale-margin
+			if (px >= 0) && (px < width) && (py >= 0) && (py < height) && ink(px, py) {
+				img.SetGray(x, y, color.Gray{Y: 20})
+			}
+		}
+	}
+	return img
+}
+
+// Lines of "text" of varying length
+func textLines(x int, y int) bool {
+	return (y%12 < 6) && (x < 40+(y*37)%80)
+}
+
+// A diagonal band, as on a different page
+func diagonal(x int, y int) bool {
+	return (x+y)%60 < 25
+}
+
+func TestOf(t *testing.T) {
+	page := Of(testPage(120, 160, 10, 1, textLines))
+	for _, test := range []struct {
+		name     string
+		img      image.Image
+		distance int
+	}{
+		{"the same page at twice the resolution", testPage(120, 160, 10, 2, textLines), 4},
+		{"the same page with wider margins", testPage(120, 160, 40, 1, textLines), 4},
+	} {
+		if d := Distance(page, Of(test.img)); d > test.distance {
+			t.Errorf("%s differs from the page by %d bits, expected at most %d", test.name, d, test.distance)
+		}
+	}
+	if d := Distance(page, Of(testPage(120, 160, 10, 1, diagonal))); d < 16 {
+		t.Errorf("a different page differs from the page by only %d bits", d)
+	}
+	// A blank page has a hash, but no printed area
+	if area := PrintedArea(testPage(10, 10, 0, 1, func(int, int) bool { return false })); !area.Empty() {
+		t.Errorf("PrintedArea() of a blank page = %v", area)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	a := []Hash{0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF}
+	if s := Similarity(a, a); s != 1 {
+		t.Errorf("Similarity() of the same pages = %f", s)
+	}
+	// An extra cover page scores only itself against its nearest page
+	withCover := append([]Hash{0xFFFFFFFFFFFFFFFF}, a...)
+	if s := Similarity(a, withCover); math.Abs(s-(1-0.5/5)) > 1e-9 {
+		t.Errorf("Similarity() with an extra page = %f", s)
+	}
+	if s := Similarity(a, nil); s != 0 {
+		t.Errorf("Similarity() with no pages = %f", s)
+	}
+}
+
+func TestPdfHashes(t *testing.T) {
+	defer func(render func(string, int, int) (image.Image, error)) { RenderPage = render }(RenderPage)
+	RenderPage = func(filename string, page int, dpi int) (image.Image, error) {
+		if page > 2 {
+			return nil, errors.New("wrong page range")
+		}
+		return testPage(120, 160, 10, 1, textLines), nil
+	}
+	if hashes, err := PdfHashes("short.pdf", []int{1, 2, 5}, 30); (err != nil) || (len(hashes) != 2) {
+		t.Errorf("PdfHashes() = %v, %v, expected 2 hashes", hashes, err)
+	}
+	if _, err := PdfHashes("short.pdf", []int{3, 1}, 30); err == nil {
+		t.Errorf("PdfHashes() did not fail when the first page could not be rendered")
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"runtime"
+	"sync"
+)
+
+// This package provides a small bounded-concurrency pipeline for slow per-file work such as
+// PDF metadata extraction. Results are returned in the same order as the inputs, so callers
+// can merge them into their document maps deterministically regardless of which worker finished first.
+
+// Returns a sensible default number of workers: one per CPU.
+func DefaultWorkers() int {
+	return runtime.NumCPU()
+}
+
+// Applies process to every input using at most workers goroutines and returns the results in input order.
+//
+// Each call to process is told which worker (0 .. workers-1) is running it, so that callers can give each worker
+// its own expensive resource (for example, a long-running exiftool process) without locking.
+// If workers is less than 1, DefaultWorkers() is used.
+func Map[In any, Out any](inputs []In, workers int, process func(worker int, input In) Out) []Out {
+	results, _ := MapWhile(inputs, workers, nil, process)
+	return results
+}
+
+// Like Map, but keepGoing is consulted before each input is handed to a worker; once it returns false no further
+// inputs are started (for example, after the user has pressed Ctrl-C). Work already started is allowed to finish.
+//
+// Inputs are started in order, so the first completed results are valid and the remainder are zero values.
+// A nil keepGoing processes every input.
+func MapWhile[In any, Out any](inputs []In, workers int, keepGoing func() bool, process func(worker int, input In) Out) ([]Out, int) {
+	if workers < 1 {
+		workers = DefaultWorkers()
+	}
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+
+	results := make([]Out, len(inputs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = process(worker, inputs[i])
+			}
+		}(worker)
+	}
+
+	completed := 0
+	for i := range inputs {
+		if (keepGoing != nil) && !keepGoing() {
+			break
+		}
+		indexes <- i
+		completed += 1
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, completed
+}
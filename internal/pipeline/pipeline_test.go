@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapPreservesOrder(t *testing.T) {
+	var inputs []int
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, i)
+	}
+
+	results := Map(inputs, 8, func(worker int, input int) int {
+		// Make later inputs finish first
+		time.Sleep(time.Duration(100-input) * time.Microsecond)
+		return input * input
+	})
+
+	for i, result := range results {
+		if result != i*i {
+			t.Fatalf(`Map() result[%d] = %d, expected %d`, i, result, i*i)
+		}
+	}
+}
+
+func TestMapBoundsConcurrency(t *testing.T) {
+	var active, maxActive int32
+	inputs := make([]int, 50)
+
+	Map(inputs, 3, func(worker int, input int) int {
+		if (worker < 0) || (worker >= 3) {
+			t.Errorf(`Map() used worker %d with only 3 workers`, worker)
+		}
+		now := atomic.AddInt32(&active, 1)
+		for {
+			old := atomic.LoadInt32(&maxActive)
+			if (now <= old) || atomic.CompareAndSwapInt32(&maxActive, old, now) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Microsecond)
+		atomic.AddInt32(&active, -1)
+		return 0
+	})
+
+	if maxActive > 3 {
+		t.Fatalf(`Map() ran %d workers at once, expected at most 3`, maxActive)
+	}
+}
+
+func TestMapEmpty(t *testing.T) {
+	results := Map([]string{}, 4, func(worker int, input string) string { return input })
+	if len(results) != 0 {
+		t.Fatalf(`Map() of no inputs returned %d results`, len(results))
+	}
+}
+
+func TestMapWhileStops(t *testing.T) {
+	inputs := make([]int, 100)
+	for i := range inputs {
+		inputs[i] = i + 1
+	}
+
+	var started int32
+	results, completed := MapWhile(inputs, 4, func() bool { return atomic.LoadInt32(&started) < 10 }, func(worker int, input int) int {
+		atomic.AddInt32(&started, 1)
+		return input
+	})
+
+	if (completed < 10) || (completed >= len(inputs)) {
+		t.Fatalf(`MapWhile() completed %d inputs, expected it to stop shortly after 10`, completed)
+	}
+	for i, result := range results {
+		if (i < completed) && (result != i+1) {
+			t.Fatalf(`MapWhile() result[%d] = %d, expected %d`, i, result, i+1)
+		}
+		if (i >= completed) && (result != 0) {
+			t.Fatalf(`MapWhile() result[%d] = %d for an input that was never started`, i, result)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package prescan
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/pkg/catalog"
+	"fmt"
+	"slices"
+)
+
+// Before spending an hour scanning a paper document, it is worth knowing whether it is already covered. This package
+// finds every known copy of a document, by part number or title fragment, across a set of catalogs, and describes what
+// the catalogs record about the quality of each copy so that a person can judge whether a rescan is worthwhile:
+//
+//   - its format: a PDF or an image holds page images, while a TXT, MEM, RNO, HTML or word processor file is only a
+//     transcription
+//   - whether a scan has a text layer (see ocr-queue), and its PDF version and producer
+//   - its quality score, page count and resolution (see score-catalog)
+//   - the "needs-rescan" tag (see tag-catalog), any notes (see annotate-catalog), e.g. "page 37 missing", and where a
+//     paper copy is kept
+//
+// Used by pre-scan and by serve-catalog's /prescan endpoint.
+
+type Document = document.Document
+
+// The tag that marks a copy as worth replacing with a better scan
+const RescanTag = "needs-rescan"
+
+// The formats that hold a transcription of a document rather than images of its pages
+var TranscriptionFormats = []string{"TXT", "MEM", "RNO", "HTML", "LN3", "DOC", "DOCX", "ODT"}
+
+// Catalog is a named catalog to be searched
+type Catalog struct {
+	Name      string
+	Documents catalog.Catalog
+}
+
+// Copy is one known copy of a document
+type Copy struct {
+	Catalog  string   `json:"catalog"` // The name of the catalog holding the copy
+	Key      string   `json:"key"`     // The copy's key in that catalog
+	PartNum  string   `json:"partnum,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	PubDate  string   `json:"pubdate,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Md5      string   `json:"md5,omitempty"`
+	Filepath string   `json:"filepath,omitempty"`
+	Local    bool     `json:"local"`             // Whether a copy of the file is held locally, rather than only online
+	Scan     bool     `json:"scan"`              // Whether the copy holds page images, rather than a transcription
+	Quality  []string `json:"quality,omitempty"` // What is recorded about the copy's quality, e.g. "no text layer"
+	Rescan   bool     `json:"rescan"`            // Whether the copy is marked as worth replacing with a better scan
+}
+
+// Report is what is known of a document before it is scanned
+type Report struct {
+	Copies []Copy `json:"copies"` // In the order of the catalogs searched, and by key within each
+	Files  int    `json:"files"`  // The number of distinct files among the copies (copies with one MD5 checksum are one file)
+	Advice string `json:"advice"` // Whether a scan looks worthwhile, and why
+}
+
+// Finds every copy of the documents selected by the query (typically by part number and title fragment) in the catalogs
+func Find(catalogs []Catalog, query catalog.Query) Report {
+	var report Report
+	var md5s []string
+	for _, c := range catalogs {
+		selected := c.Documents.Select(query)
+		for _, key := range selected.Keys() {
+			doc := selected[key]
+			report.Copies = append(report.Copies, Describe(c.Name, key, doc))
+			if doc.Md5 == "" {
+				report.Files += 1
+			} else if !slices.Contains(md5s, doc.Md5) {
+				md5s = append(md5s, doc.Md5)
+				report.Files += 1
+			}
+		}
+	}
+	report.Advice = Advise(report.Copies)
+	return report
+}
+
+// Describes a copy of a document, and what is known of its quality
+func Describe(catalogName string, key string, doc Document) Copy {
+	c := Copy{Catalog: catalogName, Key: key, PartNum: doc.PartNum, Title: doc.Title, PubDate: doc.PubDate, Format: doc.Format, Size: doc.Size, Md5: doc.Md5, Filepath: doc.Filepath}
+	document.NormaliseLocations(&doc)
+	for _, location := range doc.Copies {
+		if location.Type == document.LocalLocation {
+			c.Local = true
+		}
+	}
+	c.Scan = !slices.Contains(TranscriptionFormats, doc.Format)
+	if !c.Scan {
+		c.Quality = append(c.Quality, "transcription (no page images)")
+	}
+	if doc.Quality > 0 {
+		c.Quality = append(c.Quality, fmt.Sprintf("quality score %d/100", doc.Quality))
+	}
+	if (doc.Pages > 0) && (doc.Dpi > 0) {
+		c.Quality = append(c.Quality, fmt.Sprintf("%d pages at %d dpi", doc.Pages, doc.Dpi))
+	} else if doc.Pages > 0 {
+		c.Quality = append(c.Quality, fmt.Sprintf("%d pages", doc.Pages))
+	}
+	if doc.PdfVersion != "" {
+		c.Quality = append(c.Quality, doc.PdfVersion)
+	}
+	if doc.PdfProducer != "" {
+		c.Quality = append(c.Quality, "produced by "+doc.PdfProducer)
+	}
+	switch doc.OcrStatus {
+	case "not-needed":
+		c.Quality = append(c.Quality, "has a text layer")
+	case "done":
+		c.Quality = append(c.Quality, "OCRed copy available")
+	case "pending", "failed":
+		c.Quality = append(c.Quality, "no text layer")
+	}
+	if document.HasTag(doc, RescanTag) {
+		c.Rescan = true
+		c.Quality = append(c.Quality, "tagged "+RescanTag)
+	}
+	if doc.Notes != "" {
+		c.Quality = append(c.Quality, "notes: "+doc.Notes)
+	}
+	if doc.Location != "" {
+		c.Quality = append(c.Quality, "paper copy: "+doc.Location)
+	}
+	return c
+}
+
+// Returns advice on whether a scan is worthwhile, given the copies already known
+func Advise(copies []Copy) string {
+	if len(copies) == 0 {
+		return "no copy is known: worth scanning"
+	}
+	var scans []Copy
+	for _, c := range copies {
+		if c.Scan {
+			scans = append(scans, c)
+		}
+	}
+	switch {
+	case len(scans) == 0:
+		return "only transcriptions are known: a scan would add the page images"
+	case !slices.ContainsFunc(scans, func(c Copy) bool { return !c.Rescan }):
+		return "every known scan is tagged " + RescanTag + ": worth rescanning"
+	case !slices.ContainsFunc(scans, func(c Copy) bool { return c.Local }):
+		return "a scan is held online but not locally: fetch it rather than scanning"
+	}
+	return "a scan is already held: a rescan is only worthwhile if its quality is poor"
+}
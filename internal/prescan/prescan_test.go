@@ -0,0 +1,64 @@
+package prescan
+
+import (
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	local := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Filepath: "file:///DEC_0001/vax/ka630.pdf", PdfVersion: "PDF-1.2", OcrStatus: "pending", Tags: []string{RescanTag}, Notes: "page 37 missing"},
+		"def": {Format: "TXT", Md5: "def", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Filepath: "file:///DEC_0001/vax/ka630.txt"},
+		"ghi": {Format: "PDF", Md5: "ghi", PartNum: "AA-1234A-TC", Title: "VAX ALGOL", Filepath: "file:///DEC_0002/vms/algol.pdf"},
+	}
+	bitsavers := catalog.Catalog{
+		"abc": {Format: "PDF", Md5: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+	}
+	catalogs := []Catalog{{Name: "local", Documents: local}, {Name: "bitsavers", Documents: bitsavers}}
+
+	report := Find(catalogs, catalog.Query{PartNums: []string{"ek-ka630-tm-001"}})
+	var keys []string
+	for _, c := range report.Copies {
+		keys = append(keys, c.Catalog+":"+c.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"local:abc", "local:def", "bitsavers:abc"}) || (report.Files != 2) {
+		t.Errorf("Find() found %v in %d files", keys, report.Files)
+	}
+	expected := []string{"PDF-1.2", "no text layer", "tagged needs-rescan", "notes: page 37 missing"}
+	if first := report.Copies[0]; !reflect.DeepEqual(first.Quality, expected) || !first.Rescan || !first.Local || !first.Scan {
+		t.Errorf("Find() described %+v", first)
+	}
+	if report.Copies[1].Scan || report.Copies[2].Local {
+		t.Errorf("Find() described %+v and %+v", report.Copies[1], report.Copies[2])
+	}
+	if report.Advice != Advise(report.Copies) {
+		t.Errorf("Find() advised %q", report.Advice)
+	}
+
+	if report := Find(catalogs, catalog.Query{Titles: []string{"algol"}}); (len(report.Copies) != 1) || (report.Copies[0].Key != "ghi") {
+		t.Errorf("Find() by title found %+v", report.Copies)
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	scan := Copy{Scan: true, Local: true}
+	online := Copy{Scan: true}
+	rescan := Copy{Scan: true, Local: true, Rescan: true}
+	transcription := Copy{Local: true}
+	tests := []struct {
+		copies   []Copy
+		expected string
+	}{
+		{nil, "no copy is known: worth scanning"},
+		{[]Copy{transcription}, "only transcriptions are known: a scan would add the page images"},
+		{[]Copy{rescan, transcription}, "every known scan is tagged needs-rescan: worth rescanning"},
+		{[]Copy{online, transcription}, "a scan is held online but not locally: fetch it rather than scanning"},
+		{[]Copy{rescan, scan}, "a scan is already held: a rescan is only worthwhile if its quality is poor"},
+	}
+	for _, test := range tests {
+		if advice := Advise(test.copies); advice != test.expected {
+			t.Errorf("Advise(%+v) = %q, expected %q", test.copies, advice, test.expected)
+		}
+	}
+}
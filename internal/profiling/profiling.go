@@ -0,0 +1,75 @@
+package profiling
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// This package writes the CPU and memory profiles requested with --cpuprofile and --memprofile on the
+// long-running tools, for inspection with "go tool pprof".
+//
+// Start is called once, early in main. The tools exit through the exitcode and interrupt packages, which call Stop
+// before exiting, so the profiles are complete however the run ends (other than a second Ctrl-C).
+
+var (
+	mutex       sync.Mutex
+	cpuFile     *os.File
+	memFilename string
+)
+
+// Starts CPU profiling into cpuFilename and arranges for a heap profile to be written to memFilename when Stop is
+// called. Either filename may be blank, in which case that profile is not written.
+func Start(cpuFilename string, heapFilename string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if cpuFilename != "" {
+		file, err := os.Create(cpuFilename)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %w", cpuFilename, err)
+		}
+		cpuFile = file
+	}
+	memFilename = heapFilename
+	return nil
+}
+
+// Stops CPU profiling and writes the heap profile, if either was requested. Problems are reported but are not
+// fatal: a missing profile should not change the outcome of a run. Calling Stop more than once is harmless.
+func Stop() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if cpuFile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuFile.Close(); err != nil {
+			fmt.Printf("Cannot write CPU profile: %s\n", err)
+		}
+		cpuFile = nil
+	}
+	if memFilename != "" {
+		if err := writeHeapProfile(memFilename); err != nil {
+			fmt.Printf("Cannot write memory profile: %s\n", err)
+		}
+		memFilename = ""
+	}
+}
+
+func writeHeapProfile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	// Collect garbage first so that the profile shows what is live at the end of the run
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
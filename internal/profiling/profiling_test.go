@@ -0,0 +1,28 @@
+package profiling
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartStop(t *testing.T) {
+	dir := t.TempDir()
+	cpuFilename := filepath.Join(dir, "cpu.prof")
+	memFilename := filepath.Join(dir, "mem.prof")
+	if err := Start(cpuFilename, memFilename); err != nil {
+		t.Fatalf(`Start() failed: %v`, err)
+	}
+	Stop()
+	Stop()
+	for _, filename := range []string{cpuFilename, memFilename} {
+		if info, err := os.Stat(filename); (err != nil) || (info.Size() == 0) {
+			t.Errorf(`profile %s not written: %v`, filepath.Base(filename), err)
+		}
+	}
+
+	if err := Start(filepath.Join(dir, "missing", "cpu.prof"), ""); err == nil {
+		Stop()
+		t.Errorf(`Start() into a missing directory succeeded`)
+	}
+}
@@ -0,0 +1,186 @@
+package pubdate
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This package infers a document's publication date from the text of its first page, for the many scans whose title
+// page says e.g. "March 1987" but whose catalog entry has no PubDate.
+//
+// The first page of a PDF is read by pdftotext (from poppler-utils), so a scan needs a text layer (see ocr-queue); a
+// text document (TXT, MEM, RNO) is read up to its first form feed. Two kinds of date are looked for:
+//
+//	month and year  "March 1987", "Mar. 1987", "12 March 1987", "March 12, 1987"     gives 1987-03
+//	copyright year  "Copyright (c) 1985, 1987", "(C) 1986", "© 1986"                 gives 1987 (the latest)
+//
+// Only years within a plausible Range count, so a model number or an address is not mistaken for a date. A title
+// page often carries the date of each printing ("First Printing, March 1985 / Revised, June 1987"), so the latest
+// month and year is taken, being that of the edition scanned.
+//
+// Each inferred date comes with a confidence from 0 to 1 (see Infer), and is recorded in the document with
+// DateSource set to Inferred, so that it can be told from a date given by a catalog or by hand, and replaced by one.
+
+type Document = document.Document
+
+// The value of Document.DateSource for a date inferred from the document's content
+const Inferred = "inferred"
+
+// Range is the span of years, inclusive, that a publication date may fall in
+type Range struct {
+	Earliest int
+	Latest   int
+}
+
+// The years a date may fall in if no other Range is given: from the founding of DEC to the last of its successors'
+// manuals found in the collection
+var DefaultRange = Range{Earliest: 1957, Latest: 2010}
+
+// Reports whether a year is within the range
+func (r Range) Contains(year int) bool {
+	return (year >= r.Earliest) && (year <= r.Latest)
+}
+
+// Parses a range written as YYYY-YYYY
+func ParseRange(s string) (Range, error) {
+	first, last, found := strings.Cut(s, "-")
+	earliest, err1 := strconv.Atoi(strings.TrimSpace(first))
+	latest, err2 := strconv.Atoi(strings.TrimSpace(last))
+	if !found || (err1 != nil) || (err2 != nil) || (earliest > latest) {
+		return Range{}, fmt.Errorf("expected a range of years such as 1957-2010, found %q", s)
+	}
+	return Range{Earliest: earliest, Latest: latest}, nil
+}
+
+// Inference is a publication date inferred from a document's text
+type Inference struct {
+	Date       string  // YYYY-MM or YYYY
+	Confidence float64 // From 0 (a guess) to 1 (certain)
+	Evidence   string  // The text the date was read from
+}
+
+// The confidence in each kind of date
+const (
+	confidenceAgreed    = 0.9 // One month and year, confirmed by the copyright year
+	confidenceMonthYear = 0.8 // One month and year
+	confidenceRevised   = 0.6 // The latest of several months and years
+	confidenceCopyright = 0.5 // Only a copyright year
+)
+
+var months = map[string]int{
+	"jan": 1, "january": 1, "feb": 2, "february": 2, "mar": 3, "march": 3, "apr": 4, "april": 4, "may": 5,
+	"jun": 6, "june": 6, "jul": 7, "july": 7, "aug": 8, "august": 8, "sep": 9, "sept": 9, "september": 9,
+	"oct": 10, "october": 10, "nov": 11, "november": 11, "dec": 12, "december": 12,
+}
+
+// A month, optionally with a day before or after it, and a four-digit year
+var monthYearRegex = regexp.MustCompile(`(?i)\b(?:\d{1,2}\s+)?(jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)\.?(?:\s+\d{1,2})?,?\s+(\d{4})\b`)
+
+// A copyright notice and the years that follow it
+var copyrightRegex = regexp.MustCompile(`(?i)(?:copyright|\(c\)|©)(?:\s*(?:\(c\)|©))?\s*((?:\d{4}\s*(?:[,-]|and)?\s*)+)`)
+
+var yearRegex = regexp.MustCompile(`\d{4}`)
+
+// Infers a publication date from the text of a document's first page. Returns false if there is no date within
+// dates. A single month and year is taken with confidence 0.8 (0.9 if a copyright notice gives the same year); the
+// latest of several different ones with 0.6; and failing those the latest copyright year with 0.5.
+func Infer(text string, dates Range) (Inference, bool) {
+	var best Inference
+	distinct := make(map[string]bool)
+	for _, match := range monthYearRegex.FindAllStringSubmatch(text, -1) {
+		// "DEC" in capitals is the company, not December
+		year, _ := strconv.Atoi(match[2])
+		if !dates.Contains(year) || (match[1] == "DEC") {
+			continue
+		}
+		date := fmt.Sprintf("%04d-%02d", year, months[strings.ToLower(match[1])])
+		distinct[date] = true
+		if date > best.Date {
+			best = Inference{Date: date, Evidence: strings.Join(strings.Fields(match[0]), " ")}
+		}
+	}
+
+	copyright, copyrightEvidence := 0, ""
+	for _, match := range copyrightRegex.FindAllStringSubmatch(text, -1) {
+		for _, y := range yearRegex.FindAllString(match[1], -1) {
+			if year, _ := strconv.Atoi(y); dates.Contains(year) && (year > copyright) {
+				copyright, copyrightEvidence = year, strings.Join(strings.Fields(match[0]), " ")
+			}
+		}
+	}
+
+	switch {
+	case len(distinct) > 1:
+		best.Confidence = confidenceRevised
+	case (len(distinct) == 1) && strings.HasPrefix(best.Date, strconv.Itoa(copyright)+"-"):
+		best.Confidence = confidenceAgreed
+	case len(distinct) == 1:
+		best.Confidence = confidenceMonthYear
+	case copyright > 0:
+		best = Inference{Date: strconv.Itoa(copyright), Confidence: confidenceCopyright, Evidence: copyrightEvidence}
+	default:
+		return Inference{}, false
+	}
+	return best, true
+}
+
+// Records an inferred date in a document, marked as set by code (see document.CodeSetFlags) and as Inferred
+func Apply(doc *Document, inference Inference) {
+	doc.PubDate = inference.Date
+	doc.DateSource = Inferred
+	doc.Confidence = inference.Confidence
+	document.SetFlags(doc, document.CodeSetFlags["PubDate"])
+}
+
+// Forgets how a document's date was found, when it has been given by other means
+func Clear(doc *Document) {
+	doc.DateSource = ""
+	doc.Confidence = 0
+}
+
+// The formats whose first page can be read
+var TextFormats = []string{"TXT", "MEM", "RNO"}
+
+// Reports whether the first page of a document of the given format can be read
+func Readable(format string) bool {
+	return (format == "PDF") || slices.Contains(TextFormats, format)
+}
+
+// The most of a text document read as its first page, if it has no form feed sooner
+const maxTextPage = 4096
+
+// Returns the text of the first page of a file of the given format (see Readable).
+// Tests replace this to avoid needing pdftotext.
+var FirstPageText = func(filename string, format string) (string, error) {
+	if format != "PDF" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxTextPage))
+		if err != nil {
+			return "", err
+		}
+		if page, _, found := bytes.Cut(data, []byte("\f")); found {
+			data = page
+		}
+		return string(data), nil
+	}
+	cmd := exec.Command("pdftotext", "-f", "1", "-l", "1", "-layout", filename, "-")
+	var text, messages bytes.Buffer
+	cmd.Stdout = &text
+	cmd.Stderr = &messages
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pdftotext: %w: %s", err, strings.TrimSpace(messages.String()))
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,67 @@
+package pubdate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInfer(t *testing.T) {
+	for _, test := range []struct {
+		text       string
+		date       string
+		confidence float64
+	}{
+		{"KA630 CPU Module\nUser's Guide\n\nMarch 1987\nEK-KA630-UG-001", "1987-03", 0.8},
+		{"Prepared by Educational Services\nMar. 12, 1987\nCopyright (c) 1987 Digital Equipment Corporation", "1987-03", 0.9},
+		{"First Printing, June 1985\nRevised, September 1987\nCopyright © 1985, 1987", "1987-09", 0.6},
+		{"VAX Architecture Handbook\nCopyright (C) 1979, 1981", "1981", 0.5},
+		{"12 december 1990", "1990-12", 0.8},
+		{"Ordering information: DEC 1990 price list\nMay 1992", "1992-05", 0.8},
+		{"Model 2048 March 2048", "", 0},
+		{"Nothing to date this by", "", 0},
+	} {
+		inference, found := Infer(test.text, DefaultRange)
+		if (found != (test.date != "")) || (inference.Date != test.date) || (inference.Confidence != test.confidence) {
+			t.Errorf(`Infer(%q) = %+v, %v`, test.text, inference, found)
+		}
+	}
+
+	if inference, found := Infer("June 1955", Range{Earliest: 1950, Latest: 1960}); !found || (inference.Date != "1955-06") {
+		t.Errorf(`Infer() with a narrower range = %+v, %v`, inference, found)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	if dates, err := ParseRange("1960-1999"); (err != nil) || (dates != Range{Earliest: 1960, Latest: 1999}) {
+		t.Errorf(`ParseRange() = %+v, %v`, dates, err)
+	}
+	for _, bad := range []string{"1960", "1999-1960", "sixty-ninety"} {
+		if _, err := ParseRange(bad); err == nil {
+			t.Errorf(`ParseRange(%q) gave no error`, bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := Document{Flags: "T"}
+	Apply(&doc, Inference{Date: "1987-03", Confidence: 0.8})
+	if (doc.PubDate != "1987-03") || (doc.DateSource != Inferred) || (doc.Confidence != 0.8) || (doc.Flags != "TD") {
+		t.Errorf(`Apply() = %+v`, doc)
+	}
+	Clear(&doc)
+	if (doc.DateSource != "") || (doc.Confidence != 0) || (doc.PubDate != "1987-03") {
+		t.Errorf(`Clear() = %+v`, doc)
+	}
+}
+
+func TestFirstPageTextOfTextFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "readme.txt")
+	os.WriteFile(filename, []byte("RSX-11M Release Notes\nApril 1983\n\fPage two, revised May 1985\n"), 0644)
+	if text, err := FirstPageText(filename, "TXT"); (err != nil) || (text != "RSX-11M Release Notes\nApril 1983\n") {
+		t.Errorf(`FirstPageText() = %q, %v`, text, err)
+	}
+	if _, err := FirstPageText(filename+".missing", "TXT"); err == nil {
+		t.Errorf(`FirstPageText() of a missing file succeeded`)
+	}
+}
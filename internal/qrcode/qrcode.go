@@ -0,0 +1,479 @@
+package qrcode
+
+import (
+	"errors"
+)
+
+// This package encodes short strings (typically URLs) as QR codes, for printing on media labels.
+//
+// Only what labels need is supported: byte mode, error correction level M (about 15% of the code can be damaged), and
+// versions 1 to 10 (21x21 to 57x57 modules, up to 213 bytes). The encoding follows ISO/IEC 18004: the data is split
+// into blocks, each given Reed-Solomon error correction codewords, the blocks are interleaved and placed around the
+// function patterns, and the mask that gives the lowest penalty score is applied.
+
+// The largest number of bytes that can be encoded
+const MaxBytes = 213
+
+// Code is an encoded QR code: a square of dark and light modules. It does not include the quiet zone (a light border
+// four modules wide) that must surround it when printed.
+type Code struct {
+	Version  int // 1 to 10
+	Size     int // The number of modules along each side: 17 + 4 * Version
+	Mask     int // The mask applied to the data, 0 to 7
+	modules  [][]bool
+	function [][]bool // Modules that are part of a function pattern (finder, timing, alignment, format or version)
+}
+
+// The block structure of each version at error correction level M
+type blockStructure struct {
+	ecPerBlock   int // Error correction codewords in each block
+	group1Blocks int
+	group1Data   int // Data codewords in each block of group 1
+	group2Blocks int
+	group2Data   int // Data codewords in each block of group 2 (always group1Data + 1)
+}
+
+var levelM = [...]blockStructure{
+	1:  {10, 1, 16, 0, 0},
+	2:  {16, 1, 28, 0, 0},
+	3:  {26, 1, 44, 0, 0},
+	4:  {18, 2, 32, 0, 0},
+	5:  {24, 2, 43, 0, 0},
+	6:  {16, 4, 27, 0, 0},
+	7:  {18, 4, 31, 0, 0},
+	8:  {22, 2, 38, 2, 39},
+	9:  {22, 3, 36, 2, 37},
+	10: {26, 4, 43, 1, 44},
+}
+
+// The centres of the alignment patterns of each version, along each axis
+var alignmentPositions = [...][]int{
+	1:  nil,
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+func (b blockStructure) dataCodewords() int {
+	return b.group1Blocks*b.group1Data + b.group2Blocks*b.group2Data
+}
+
+// Encodes data as a QR code of the smallest version that holds it
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v < len(levelM); v++ {
+		if len(data) <= capacity(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("too much data for a QR code: at most 213 bytes can be encoded")
+	}
+
+	codewords := interleave(encodeData(data, version), levelM[version])
+	best := -1
+	var code *Code
+	for mask := 0; mask < 8; mask++ {
+		candidate := newCode(version)
+		candidate.placeCodewords(codewords)
+		candidate.applyMask(mask)
+		candidate.drawFormat(mask)
+		if penalty := candidate.penalty(); (best < 0) || (penalty < best) {
+			best, code = penalty, candidate
+		}
+	}
+	return code, nil
+}
+
+// Reports whether the module at column x, row y is dark
+func (c *Code) Dark(x int, y int) bool {
+	return c.modules[y][x]
+}
+
+// The number of bytes that a version can hold
+func capacity(version int) int {
+	return (levelM[version].dataCodewords()*8 - 4 - countBits(version)) / 8
+}
+
+// The width of the character count that follows the mode indicator
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// Returns the data codewords for a version: the mode, the count, the data, a terminator and padding
+func encodeData(data []byte, version int) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4) // Byte mode
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	total := levelM[version].dataCodewords() * 8
+	bits.append(0, min(4, total-bits.length))
+	bits.append(0, (8-bits.length%8)%8)
+	for pad := 0xEC; bits.length < total; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes
+}
+
+// Splits the data codewords into blocks, adds error correction to each, and interleaves them
+func interleave(data []byte, structure blockStructure) []byte {
+	var blocks [][]byte
+	var ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < structure.group1Blocks+structure.group2Blocks; i++ {
+		length := structure.group1Data
+		if i >= structure.group1Blocks {
+			length = structure.group2Data
+		}
+		block := data[offset : offset+length]
+		offset += length
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomon(block, structure.ecPerBlock))
+	}
+
+	var result []byte
+	for i := 0; i < max(structure.group1Data, structure.group2Data); i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < structure.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// Returns a code with the function patterns drawn and the format and version areas reserved
+func newCode(version int) *Code {
+	size := 17 + 4*version
+	c := &Code{Version: version, Size: size}
+	c.modules = make([][]bool, size)
+	c.function = make([][]bool, size)
+	for y := range c.modules {
+		c.modules[y] = make([]bool, size)
+		c.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+	positions := alignmentPositions[version]
+	for i, x := range positions {
+		for j, y := range positions {
+			// Alignment patterns are not drawn over the finder patterns
+			last := len(positions) - 1
+			if !((i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0)) {
+				c.drawAlignment(x, y)
+			}
+		}
+	}
+	c.drawFormat(0) // Reserves the format areas, which are redrawn once the mask is chosen
+	c.drawVersion()
+	return c
+}
+
+func (c *Code) setFunction(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+// Draws a finder pattern, and its separator, centred on x, y
+func (c *Code) drawFinder(x int, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			distance := max(abs(dx), abs(dy))
+			if (x+dx >= 0) && (x+dx < c.Size) && (y+dy >= 0) && (y+dy < c.Size) {
+				c.setFunction(x+dx, y+dy, (distance != 2) && (distance != 4))
+			}
+		}
+	}
+}
+
+// Draws an alignment pattern centred on x, y
+func (c *Code) drawAlignment(x int, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// Draws both copies of the format information (the error correction level and the mask), and the dark module
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// Returns the 15 bit format information for level M and a mask
+func formatBits(mask int) int {
+	data := mask // Level M is 00
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	return ((data << 10) | remainder) ^ 0x5412
+}
+
+// Draws both copies of the version information, which is only present from version 7
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// Returns the 18 bit version information
+func versionBits(version int) int {
+	remainder := version
+	for i := 0; i < 12; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+	}
+	return (version << 12) | remainder
+}
+
+// Places the codewords in the modules that are not part of a function pattern, in two module wide columns that zigzag
+// up and down from the bottom right corner
+func (c *Code) placeCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // The vertical timing pattern is skipped
+		}
+		upward := ((right + 1) & 2) == 0
+		for vertical := 0; vertical < c.Size; vertical++ {
+			y := vertical
+			if upward {
+				y = c.Size - 1 - vertical
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !c.function[y][x] && (i < len(codewords)*8) {
+					c.modules[y][x] = (codewords[i>>3]>>(7-(i&7)))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// Inverts the modules selected by a mask, other than those of the function patterns
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+	c.Mask = mask
+}
+
+// Returns the penalty score of the code, by the four rules of the standard: runs of five or more modules of one colour,
+// 2x2 blocks of one colour, patterns that look like finder patterns, and an imbalance of dark and light modules
+func (c *Code) penalty() int {
+	penalty := 0
+	line := make([]bool, c.Size)
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if horizontal {
+					line[j] = c.modules[i][j]
+				} else {
+					line[j] = c.modules[j][i]
+				}
+			}
+			penalty += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if (x < c.Size-1) && (y < c.Size-1) {
+				colour := c.modules[y][x]
+				if (c.modules[y][x+1] == colour) && (c.modules[y+1][x] == colour) && (c.modules[y+1][x+1] == colour) {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	percent := dark * 100 / (c.Size * c.Size)
+	previous := percent - percent%5
+	penalty += min(abs(previous-50), abs(previous+5-50)) / 5 * 10
+	return penalty
+}
+
+// Returns the penalty for runs, and for finder-like patterns, in one row or column
+func linePenalty(line []bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if (i < len(line)) && (line[i] == line[i-1]) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += 3 + run - 5
+		}
+		run = 1
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	light := func(from int, to int) bool {
+		for i := from; i < to; i++ {
+			if (i >= 0) && (i < len(line)) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i+len(finder) <= len(line); i++ {
+		matched := true
+		for j, dark := range finder {
+			if line[i+j] != dark {
+				matched = false
+				break
+			}
+		}
+		// A finder-like pattern only counts with four light modules (or the edge of the code) on one side
+		if matched && (light(i-4, i) || light(i+7, i+11)) {
+			penalty += 40
+		}
+	}
+	return penalty
+}
+
+// Returns the Reed-Solomon error correction codewords for a block of data, over GF(256) with the polynomial 0x11D
+func reedSolomon(data []byte, count int) []byte {
+	// The generator polynomial is the product of (x - 2^i) for i from 0 to count - 1; its coefficients are held with
+	// the highest power first, omitting the leading 1
+	generator := make([]byte, count)
+	generator[count-1] = 1
+	root := byte(1)
+	for i := 0; i < count; i++ {
+		for j := 0; j < count; j++ {
+			generator[j] = multiply(generator[j], root)
+			if j+1 < count {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = multiply(root, 2)
+	}
+
+	remainder := make([]byte, count)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[count-1] = 0
+		for i := range remainder {
+			remainder[i] ^= multiply(generator[i], factor)
+		}
+	}
+	return remainder
+}
+
+// Multiplies two elements of GF(256), with the polynomial 0x11D
+func multiply(a byte, b byte) byte {
+	var product byte
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a&0x80 != 0
+		a <<= 1
+		if carry {
+			a ^= 0x1D
+		}
+	}
+	return product
+}
+
+// bitBuffer accumulates bits, most significant first
+type bitBuffer struct {
+	bytes  []byte
+	length int // In bits
+}
+
+func (b *bitBuffer) append(value int, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if b.length%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if (value>>i)&1 != 0 {
+			b.bytes[b.length/8] |= 0x80 >> (b.length % 8)
+		}
+		b.length++
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
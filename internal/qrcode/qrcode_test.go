@@ -0,0 +1,122 @@
+package qrcode
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// The data codewords of "HELLO WORLD" at 1-M (alphanumeric mode), and their error correction codewords
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if ec := reedSolomon(data, 10); !reflect.DeepEqual(ec, expected) {
+		t.Errorf("reedSolomon() = %v, expected %v", ec, expected)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	if bits := fmt.Sprintf("%015b", formatBits(0)); bits != "101010000010010" {
+		t.Errorf("formatBits(0) = %s", bits)
+	}
+	if bits := fmt.Sprintf("%015b", formatBits(5)); bits != "100000011001110" {
+		t.Errorf("formatBits(5) = %s", bits)
+	}
+	if bits := fmt.Sprintf("%018b", versionBits(7)); bits != "000111110010010100" {
+		t.Errorf("versionBits(7) = %s", bits)
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	codewords := encodeData([]byte("ab"), 1)
+	// Byte mode (0100), a count of 2, 'a', 'b', the terminator, then alternating padding
+	expected := []byte{0x40, 0x26, 0x16, 0x20, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	if !reflect.DeepEqual(codewords, expected) {
+		t.Errorf("encodeData() = % x, expected % x", codewords, expected)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		length  int
+		version int
+	}{
+		{1, 1}, {14, 1}, {15, 2}, {106, 6}, {107, 7}, {213, 10},
+	}
+	for _, test := range tests {
+		code, err := Encode([]byte(strings.Repeat("x", test.length)))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes) failed: %v", test.length, err)
+		}
+		if (code.Version != test.version) || (code.Size != 17+4*test.version) {
+			t.Errorf("Encode(%d bytes) gave version %d, size %d", test.length, code.Version, code.Size)
+		}
+		// Each finder pattern has a dark centre, a light ring and a dark ring, and a light separator
+		for _, corner := range [][2]int{{3, 3}, {code.Size - 4, 3}, {3, code.Size - 4}} {
+			x, y := corner[0], corner[1]
+			if !code.Dark(x, y) || code.Dark(x+2, y) || !code.Dark(x+3, y) || ((x+4 < code.Size) && code.Dark(x+4, y)) {
+				t.Errorf("version %d: bad finder pattern at %d, %d", code.Version, x, y)
+			}
+		}
+		// The format information records the mask chosen, and the dark module is always dark
+		bits := 0
+		for i := 0; i < 8; i++ {
+			if code.Dark(code.Size-1-i, 8) {
+				bits |= 1 << i
+			}
+		}
+		for i := 8; i < 15; i++ {
+			if code.Dark(8, code.Size-15+i) {
+				bits |= 1 << i
+			}
+		}
+		if (bits != formatBits(code.Mask)) || !code.Dark(8, code.Size-8) {
+			t.Errorf("version %d: format information %015b, expected %015b", code.Version, bits, formatBits(code.Mask))
+		}
+	}
+	if _, err := Encode(make([]byte, MaxBytes+1)); err == nil {
+		t.Errorf("Encode() accepted %d bytes", MaxBytes+1)
+	}
+}
+
+// Reading the modules back in placement order, and removing the mask, gives the codewords that were placed
+func TestPlacement(t *testing.T) {
+	for _, version := range []int{1, 7, 10} {
+		data := []byte(strings.Repeat("docs-to-yaml ", 20))[:capacity(version)]
+		codewords := interleave(encodeData(data, version), levelM[version])
+		code, _ := Encode(data)
+		if code.Version != version {
+			t.Fatalf("Encode() gave version %d, expected %d", code.Version, version)
+		}
+		unmasked := newCode(version)
+		unmasked.placeCodewords(make([]byte, len(codewords)))
+		unmasked.applyMask(code.Mask) // The mask pattern, over the data modules only
+		var read bitBuffer
+		for right := code.Size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			upward := ((right + 1) & 2) == 0
+			for vertical := 0; vertical < code.Size; vertical++ {
+				y := vertical
+				if upward {
+					y = code.Size - 1 - vertical
+				}
+				for j := 0; j < 2; j++ {
+					x := right - j
+					if !code.function[y][x] && (read.length < len(codewords)*8) {
+						bit := 0
+						if code.Dark(x, y) != unmasked.Dark(x, y) {
+							bit = 1
+						}
+						read.append(bit, 1)
+					}
+				}
+			}
+		}
+		if !reflect.DeepEqual(read.bytes, codewords) {
+			t.Errorf("version %d: the codewords read back differ from those placed", version)
+		}
+	}
+}
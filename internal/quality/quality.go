@@ -0,0 +1,243 @@
+package quality
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This package scores how good a copy of a document is, from 0 to 100, so that the copies of one publication can be
+// ranked automatically. The score, recorded in Document.Quality, adds up:
+//
+//	resolution     30  the resolution of the page images (600 dpi or more scores in full)
+//	pages          20  the page count, against the most pages of any copy of the publication (a short copy is
+//	                   probably missing pages)
+//	text layer     15  whether the copy has a text layer (see ocr-queue)
+//	completeness   15  lost for each completeness tag (e.g. "needs-rescan", "incomplete")
+//	format         10  the PDF version (later versions hold images better compressed), or the image format
+//	size           10  the bytes per page, or the file size against the largest copy if the pages are not known
+//
+// Anything not known (e.g. the resolution of a scan that has not been measured) scores a third of its share, so that
+// an unmeasured copy ranks below a good one and above a poor one. A transcription (TXT, MEM, RNO, ...) has no page
+// images, so scores nothing for resolution or format.
+//
+// The page count and resolution are measured by pdfinfo and pdfimages (from poppler-utils) and recorded in
+// Document.Pages and Document.Dpi.
+
+type Document = document.Document
+
+// The tags that say a copy is incomplete or otherwise poor, each costing CompletenessWeight/2
+var CompletenessTags = []string{"needs-rescan", "incomplete", "missing-pages"}
+
+// The formats that hold a transcription of a document rather than images of its pages
+var TranscriptionFormats = []string{"TXT", "MEM", "RNO", "HTML", "LN3", "DOC", "DOCX", "ODT"}
+
+// The most each part of the score can contribute
+const (
+	ResolutionWeight   = 30
+	PagesWeight        = 20
+	TextLayerWeight    = 15
+	CompletenessWeight = 15
+	FormatWeight       = 10
+	SizeWeight         = 10
+)
+
+// The resolution (in dots per inch) that scores in full
+const FullDpi = 600
+
+// The bytes per page that score in full for size: a 300 dpi greyscale page compresses to roughly this
+const FullBytesPerPage = 200 << 10
+
+// Peers is what is known of the other copies of a publication, against which a copy is scored
+type Peers struct {
+	MaxPages int   // The most pages of any copy
+	MaxSize  int64 // The largest file of any copy
+}
+
+// Returns the peers of each document in a set of copies of one publication, which is the same for all of them
+func PeersOf(copies []Document) Peers {
+	var peers Peers
+	for _, doc := range copies {
+		peers.MaxPages = max(peers.MaxPages, doc.Pages)
+		peers.MaxSize = max(peers.MaxSize, doc.Size)
+	}
+	return peers
+}
+
+// Part is one part of a score
+type Part struct {
+	Name   string
+	Points float64
+	Weight int
+}
+
+// Scores a copy of a publication against its peers. Returns the score (from 0 to 100) and how it was made up.
+func Score(doc Document, peers Peers) (int, []Part) {
+	transcription := slices.Contains(TranscriptionFormats, doc.Format)
+	unknown := func(weight int) float64 { return float64(weight) / 3 }
+	var parts []Part
+
+	resolution := unknown(ResolutionWeight)
+	switch {
+	case transcription:
+		resolution = 0
+	case doc.Dpi > 0:
+		resolution = ResolutionWeight * float64(min(doc.Dpi, FullDpi)) / FullDpi
+	}
+	parts = append(parts, Part{"resolution", resolution, ResolutionWeight})
+
+	pages := unknown(PagesWeight)
+	if (doc.Pages > 0) && (peers.MaxPages > 0) {
+		pages = PagesWeight * float64(doc.Pages) / float64(peers.MaxPages)
+	}
+	parts = append(parts, Part{"pages", pages, PagesWeight})
+
+	textLayer := unknown(TextLayerWeight)
+	switch {
+	case transcription || (doc.OcrStatus == "not-needed"):
+		textLayer = TextLayerWeight
+	case doc.OcrStatus == "done":
+		textLayer = TextLayerWeight * 2 / 3
+	case (doc.OcrStatus == "pending") || (doc.OcrStatus == "failed"):
+		textLayer = 0
+	}
+	parts = append(parts, Part{"text layer", textLayer, TextLayerWeight})
+
+	completeness := float64(CompletenessWeight)
+	for _, tag := range CompletenessTags {
+		if document.HasTag(doc, tag) {
+			completeness = max(0, completeness-CompletenessWeight/2.0)
+		}
+	}
+	parts = append(parts, Part{"completeness", completeness, CompletenessWeight})
+
+	format := unknown(FormatWeight)
+	switch {
+	case transcription:
+		format = 0
+	case doc.PdfVersion != "":
+		format = pdfVersionPoints(doc.PdfVersion)
+	case (doc.Format == "TIFF") || (doc.Format == "PNG"):
+		format = FormatWeight * 0.8
+	case doc.Format == "JPEG":
+		format = FormatWeight * 0.6
+	}
+	parts = append(parts, Part{"format", format, FormatWeight})
+
+	size := unknown(SizeWeight)
+	switch {
+	case doc.Size <= 0:
+	case doc.Pages > 0:
+		size = SizeWeight * float64(min(doc.Size/int64(doc.Pages), FullBytesPerPage)) / FullBytesPerPage
+	case peers.MaxSize > 0:
+		size = SizeWeight * float64(doc.Size) / float64(peers.MaxSize)
+	}
+	parts = append(parts, Part{"size", size, SizeWeight})
+
+	total := 0.0
+	for _, part := range parts {
+		total += part.Points
+	}
+	return min(100, int(total+0.5)), parts
+}
+
+// Returns the points for a PDF version such as "PDF-1.4": in full from 1.5 (which added object streams and JPEG 2000),
+// and less for each earlier version
+func pdfVersionPoints(version string) float64 {
+	minor, err := strconv.Atoi(strings.TrimPrefix(version, "PDF-1."))
+	if err != nil {
+		return FormatWeight / 3.0
+	}
+	return FormatWeight * float64(max(1, min(minor, 5))) / 5
+}
+
+// Ranks copies of one publication, best first: by score, then by size (larger first), then by key.
+func Rank(keys []string, scores map[string]int, sizes map[string]int64) []string {
+	ranked := slices.Clone(keys)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		switch {
+		case scores[a] != scores[b]:
+			return scores[a] > scores[b]
+		case sizes[a] != sizes[b]:
+			return sizes[a] > sizes[b]
+		}
+		return a < b
+	})
+	return ranked
+}
+
+// Measurement is what pdfinfo and pdfimages tell of a PDF
+type Measurement struct {
+	Pages int // The page count
+	Dpi   int // The median horizontal resolution of the page images; 0 if there are none
+}
+
+// Measures the page count and image resolution of a PDF.
+// Tests replace this to avoid needing pdfinfo and pdfimages.
+var MeasurePdf = func(filename string) (Measurement, error) {
+	info, err := run("pdfinfo", filename)
+	if err != nil {
+		return Measurement{}, err
+	}
+	images, err := run("pdfimages", "-list", "-f", "1", "-l", "5", filename)
+	if err != nil {
+		return Measurement{}, err
+	}
+	return Measurement{Pages: ParsePdfinfo(info), Dpi: ParseImageList(images)}, nil
+}
+
+// Runs a poppler command and returns what it writes
+func run(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var output, messages bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &messages
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(messages.String()))
+	}
+	return output.String(), nil
+}
+
+// Returns the page count given by pdfinfo, or 0 if there is none
+func ParsePdfinfo(output string) int {
+	for _, line := range strings.Split(output, "\n") {
+		if value, found := strings.CutPrefix(line, "Pages:"); found {
+			pages, _ := strconv.Atoi(strings.TrimSpace(value))
+			return pages
+		}
+	}
+	return 0
+}
+
+// Returns the median horizontal resolution (x-ppi) of the images listed by pdfimages -list, or 0 if there are none.
+// Small images (logos, rules) are ignored, as their resolution says nothing about the scan.
+func ParseImageList(output string) int {
+	var resolutions []int
+	var columns []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case (len(fields) > 0) && (fields[0] == "page"):
+			columns = fields
+		case (len(fields) == len(columns)) && (len(columns) > 0):
+			values := make(map[string]int)
+			for i, column := range columns {
+				values[column], _ = strconv.Atoi(fields[i])
+			}
+			if (values["width"] >= 500) && (values["x-ppi"] > 0) {
+				resolutions = append(resolutions, values["x-ppi"])
+			}
+		}
+	}
+	if len(resolutions) == 0 {
+		return 0
+	}
+	sort.Ints(resolutions)
+	return resolutions[len(resolutions)/2]
+}
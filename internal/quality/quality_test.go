@@ -0,0 +1,64 @@
+package quality
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	peers := Peers{MaxPages: 200, MaxSize: 40 << 20}
+	good := Document{Format: "PDF", PdfVersion: "PDF-1.5", Pages: 200, Dpi: 600, Size: 40 << 20, OcrStatus: "not-needed"}
+	if score, parts := Score(good, peers); (score != 100) || (len(parts) != 6) {
+		t.Errorf(`Score() of a complete 600 dpi scan = %d %+v`, score, parts)
+	}
+
+	// Missing pages, a lower resolution, no text layer and a needs-rescan tag all count against a copy
+	poor := Document{Format: "PDF", PdfVersion: "PDF-1.3", Pages: 150, Dpi: 300, Size: 15 << 20, OcrStatus: "pending", Tags: []string{"needs-rescan"}}
+	if score, _ := Score(poor, peers); score != 49 {
+		t.Errorf(`Score() of a poor scan = %d`, score)
+	}
+
+	// Nothing measured scores a third of each share, except completeness which is lost only by tags
+	if score, _ := Score(Document{Format: "PDF"}, Peers{}); score != 43 {
+		t.Errorf(`Score() of an unmeasured scan = %d`, score)
+	}
+
+	// A transcription has no page images
+	if score, _ := Score(Document{Format: "TXT", Size: 100 << 10}, Peers{MaxSize: 40 << 20}); score != 37 {
+		t.Errorf(`Score() of a transcription = %d`, score)
+	}
+}
+
+func TestRank(t *testing.T) {
+	scores := map[string]int{"a": 60, "b": 85, "c": 60, "d": 60}
+	sizes := map[string]int64{"a": 100, "b": 10, "c": 300, "d": 100}
+	if ranked := Rank([]string{"a", "b", "c", "d"}, scores, sizes); !reflect.DeepEqual(ranked, []string{"b", "c", "a", "d"}) {
+		t.Errorf(`Rank() = %v`, ranked)
+	}
+}
+
+func TestParsePdfinfo(t *testing.T) {
+	output := "Title:          KA630 CPU Module\nProducer:       Adobe Acrobat 4.0\nPages:          212\nPage size:      612 x 792 pts (letter)\n"
+	if pages := ParsePdfinfo(output); pages != 212 {
+		t.Errorf(`ParsePdfinfo() = %d`, pages)
+	}
+	if pages := ParsePdfinfo("Syntax Error: broken\n"); pages != 0 {
+		t.Errorf(`ParsePdfinfo() of no page count = %d`, pages)
+	}
+}
+
+func TestParseImageList(t *testing.T) {
+	output := `page   num  type   width height color comp bpc  enc interp  object ID x-ppi y-ppi size ratio
+--------------------------------------------------------------------------------------------
+   1     0 image    2550  3300  gray    1   1  ccitt  no         7  0   300   300  120K 1.1%
+   1     1 image      80    40  rgb     3   8  jpeg   no         8  0    72    72 2048B 21%
+   2     2 image    2550  3300  gray    1   1  ccitt  no        12  0   300   300  118K 1.1%
+   3     3 image    5100  6600  gray    1   1  jbig2  no        17  0   600   600  180K 0.4%
+`
+	if dpi := ParseImageList(output); dpi != 300 {
+		t.Errorf(`ParseImageList() = %d`, dpi)
+	}
+	if dpi := ParseImageList("page   num  type   width height color comp bpc  enc interp  object ID x-ppi y-ppi size ratio\n"); dpi != 0 {
+		t.Errorf(`ParseImageList() of no images = %d`, dpi)
+	}
+}
@@ -0,0 +1,135 @@
+package redirect
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// This package follows the redirects of a remote document's URL and records where they lead, so that catalogs built
+// from index pages (whose links often 301 to a new home) converge on stable links.
+//
+// Each redirect is followed by hand rather than by the http.Client, so that every URL visited is known. Only
+// permanent redirects (301 and 308) move a document's canonical URL: a temporary redirect (302, 303 and 307, often to
+// a mirror or a signed download link) is followed but is not somewhere to record. The request asks for the document's
+// format in its Accept header; a redirect that ends at a web page when something else was asked for (a login or
+// "file moved" page, say) is not recorded either.
+//
+// The permanent redirects are recorded in each document (see document.AddRedirects), which moves the document's URLs to
+// where they lead and remembers the redirects, so that the same links found again in an index page are moved too.
+
+// The most redirects followed for one URL
+const MaxRedirects = 10
+
+// The MIME type of each document format that a server may be asked for
+var MimeTypes = map[string]string{
+	"HTML": "text/html",
+	"JPEG": "image/jpeg",
+	"PDF":  "application/pdf",
+	"PNG":  "image/png",
+	"PS":   "application/postscript",
+	"TIFF": "image/tiff",
+	"TXT":  "text/plain",
+	"ZIP":  "application/zip",
+}
+
+// Chain records the URLs visited while following a URL's redirects
+type Chain struct {
+	Hops        []string // Every URL visited, starting with the one requested and ending with the one that answered
+	Permanent   int      // The number of redirects, from the start of Hops, that were permanent
+	ContentType string   // The media type of the final response (without parameters)
+	Landing     bool     // True if the redirects ended at a web page when another format was asked for
+}
+
+// Returns the URL that was requested
+func (c Chain) Url() string {
+	if len(c.Hops) == 0 {
+		return ""
+	}
+	return c.Hops[0]
+}
+
+// Returns the URL reached by following only the permanent redirects, or the requested URL if there were none or the
+// redirects ended at a web page
+func (c Chain) Canonical() string {
+	if c.Landing {
+		return c.Url()
+	}
+	return c.Hops[c.Permanent]
+}
+
+// Returns the permanent redirects that were followed, each URL mapped to the URL it redirects to, in the form kept
+// in Document.Redirects (see document.AddRedirects). Returns nil if there were none, or the redirects ended at a web page.
+func (c Chain) Redirects() map[string]string {
+	if (c.Permanent == 0) || c.Landing {
+		return nil
+	}
+	redirects := make(map[string]string)
+	for i := 0; i < c.Permanent; i++ {
+		redirects[c.Hops[i]] = c.Hops[i+1]
+	}
+	return redirects
+}
+
+// Returns the Accept header that asks for a document of the given format, preferring it over anything else
+func Accept(format string) string {
+	if mimeType, found := MimeTypes[format]; found {
+		return mimeType + ", */*;q=0.1"
+	}
+	return "*/*"
+}
+
+// Sends the request, following any redirects itself (the client's own redirect policy is ignored), and returns the
+// final response, which the caller must close, together with the chain of URLs visited. An Accept header for the
+// document's format (see Accept) is added unless the request already has one. The request's other headers (e.g.
+// Range or If-Modified-Since) are sent with every hop.
+func Follow(client *http.Client, request *http.Request, format string) (*http.Response, Chain, error) {
+	if request.Header.Get("Accept") == "" {
+		request.Header.Set("Accept", Accept(format))
+	}
+	manual := *client
+	manual.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	chain := Chain{Hops: []string{request.URL.String()}}
+	permanent := true
+	for {
+		resp, err := manual.Do(request)
+		if err != nil {
+			return nil, chain, err
+		}
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || (location == "") {
+			chain.ContentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			chain.Landing = (len(chain.Hops) > 1) && (chain.ContentType == "text/html") && (MimeTypes[format] != "text/html")
+			return resp, chain, nil
+		}
+		resp.Body.Close()
+		if len(chain.Hops) > MaxRedirects {
+			return nil, chain, fmt.Errorf("%s: more than %d redirects", chain.Url(), MaxRedirects)
+		}
+		next, err := request.URL.Parse(location)
+		if err != nil {
+			return nil, chain, fmt.Errorf("%s: bad redirect to %q: %w", request.URL, location, err)
+		}
+		if slices.Contains(chain.Hops, next.String()) {
+			return nil, chain, fmt.Errorf("%s: redirect loop at %s", chain.Url(), next)
+		}
+		permanent = permanent && ((resp.StatusCode == http.StatusMovedPermanently) || (resp.StatusCode == http.StatusPermanentRedirect))
+		if permanent {
+			chain.Permanent += 1
+		}
+		chain.Hops = append(chain.Hops, next.String())
+		request = request.Clone(request.Context())
+		request.URL = next
+		request.Host = ""
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFollow(t *testing.T) {
+	var accepted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = append(accepted, r.Header.Get("Accept"))
+		switch r.URL.Path {
+		case "/old/doc.pdf":
+			http.Redirect(w, r, "/new/doc.pdf", http.StatusMovedPermanently)
+		case "/new/doc.pdf":
+			http.Redirect(w, r, "/mirror/doc.pdf", http.StatusFound)
+		case "/mirror/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/gone.pdf":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		case "/loop.pdf":
+			http.Redirect(w, r, "/loop.pdf", http.StatusMovedPermanently)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+	}))
+	defer server.Close()
+
+	follow := func(path string) (Chain, error) {
+		request, _ := http.NewRequest(http.MethodHead, server.URL+path, nil)
+		resp, chain, err := Follow(server.Client(), request, "PDF")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return chain, err
+	}
+
+	chain, err := follow("/old/doc.pdf")
+	if err != nil {
+		t.Fatalf(`Follow() failed: %v`, err)
+	}
+	expected := Chain{Hops: []string{server.URL + "/old/doc.pdf", server.URL + "/new/doc.pdf", server.URL + "/mirror/doc.pdf"}, Permanent: 1, ContentType: "application/pdf"}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Errorf(`Follow() = %+v`, chain)
+	}
+	// The temporary redirect to the mirror is followed but not recorded
+	if chain.Canonical() != server.URL+"/new/doc.pdf" || !reflect.DeepEqual(chain.Redirects(), map[string]string{server.URL + "/old/doc.pdf": server.URL + "/new/doc.pdf"}) {
+		t.Errorf(`Follow() canonical = %s, redirects = %v`, chain.Canonical(), chain.Redirects())
+	}
+	if accepted[0] != "application/pdf, */*;q=0.1" {
+		t.Errorf(`Follow() sent Accept %q`, accepted[0])
+	}
+
+	chain, err = follow("/gone.pdf")
+	if (err != nil) || !chain.Landing || (chain.Canonical() != server.URL+"/gone.pdf") || (chain.Redirects() != nil) {
+		t.Errorf(`Follow() to a web page = %+v, %v`, chain, err)
+	}
+	if _, err := follow("/loop.pdf"); err == nil {
+		t.Errorf(`Follow() of a redirect loop succeeded`)
+	}
+	chain, err = follow("/mirror/doc.pdf")
+	if (err != nil) || (chain.Canonical() != server.URL+"/mirror/doc.pdf") || (chain.Redirects() != nil) {
+		t.Errorf(`Follow() without redirects = %+v, %v`, chain, err)
+	}
+}
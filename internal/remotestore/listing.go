@@ -0,0 +1,76 @@
+package remotestore
+
+import (
+	"docs-to-yaml/internal/md5sums"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Listing is a dated list of the MD5 checksums of the files on a site, such as the site.bitsavers.2021-10-01.md5 file
+// supplied by the maintainer of manx. A listing is in md5sum format, with filepaths relative to the root of the site.
+//
+// Listings are merged into the store newest first wins: an MD5 checksum is only replaced by one from a listing that is
+// at least as new as the listing it came from, which is recorded in Entry.Md5Listing so that the provenance of every
+// checksum survives later merges.
+type Listing struct {
+	Name string            // The name of the listing file, e.g. "site.bitsavers.2021-10-01.md5"
+	Date string            // The date of the listing, as YYYY-MM-DD
+	Md5s map[string]string // URL => MD5 checksum
+}
+
+// A listing is dated by the YYYY-MM-DD in its name
+var listingDateRegexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// Returns the date (YYYY-MM-DD) in the name of a listing, or "" if there is none
+func ListingDate(name string) string {
+	return listingDateRegexp.FindString(filepath.Base(name))
+}
+
+// Reads a listing, whose filepaths are relative to siteUrl (e.g. "http://bitsavers.org/"). The date is taken from the
+// listing's name.
+func ReadListing(filename string, siteUrl string) (Listing, error) {
+	listing := Listing{Name: filepath.Base(filename), Date: ListingDate(filename), Md5s: make(map[string]string)}
+	if listing.Date == "" {
+		return listing, fmt.Errorf("%s: no YYYY-MM-DD date in the name of the listing", filename)
+	}
+	entries, err := md5sums.ReadFile(filename)
+	if err != nil {
+		return listing, fmt.Errorf("%s: %w", filename, err)
+	}
+	siteUrl = strings.TrimSuffix(siteUrl, "/") + "/"
+	for _, entry := range entries {
+		listing.Md5s[siteUrl+strings.TrimPrefix(entry.Filepath, "./")] = entry.Md5
+	}
+	return listing, nil
+}
+
+// Merges listings into the store, oldest first, so that the newest listing that has a URL gives its MD5 checksum.
+// A checksum already in the store from a newer listing is kept; one recorded without a listing is replaced.
+// Returns the number of URLs whose checksum (or the listing it came from) changed.
+func (s *Store) MergeListings(listings ...Listing) int {
+	sorted := append([]Listing(nil), listings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date < sorted[j].Date })
+	urls := make(map[string]bool)
+	for _, listing := range sorted {
+		for url, md5 := range listing.Md5s {
+			entry, _ := s.Lookup(url)
+			if (entry.Md5Listing != "") && (ListingDate(entry.Md5Listing) > listing.Date) {
+				continue
+			}
+			if (entry.Md5 != md5) || (entry.Md5Listing != listing.Name) {
+				s.change(url, func(entry *Entry) { entry.Md5, entry.Md5Listing = md5, listing.Name })
+				urls[url] = true
+			}
+		}
+	}
+	return len(urls)
+}
+
+// Returns the name of the listing the MD5 checksum of the file at a URL came from, if it came from one
+func (s *Store) Md5Listing(url string) (string, bool) {
+	entry, found := s.Lookup(url)
+	return entry.Md5Listing, found && (entry.Md5Listing != "")
+}
@@ -0,0 +1,57 @@
+package remotestore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeListings(t *testing.T) {
+	dir := t.TempDir()
+	older := filepath.Join(dir, "site.bitsavers.2021-10-01.md5")
+	newer := filepath.Join(dir, "site.bitsavers.2024-03-15.md5")
+	if err := os.WriteFile(older, []byte("0123456789abcdef0123456789abcdef  ./pdf/dec/vax/ka630.pdf\n11111111111111111111111111111111  ./pdf/dec/vax/ka650.pdf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newer, []byte("22222222222222222222222222222222  pdf/dec/vax/ka650.pdf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	olderListing, err := ReadListing(older, "http://bitsavers.org")
+	if (err != nil) || (olderListing.Date != "2021-10-01") || (olderListing.Md5s["http://bitsavers.org/pdf/dec/vax/ka630.pdf"] != "0123456789abcdef0123456789abcdef") {
+		t.Fatalf(`ReadListing() = %+v, %v`, olderListing, err)
+	}
+	newerListing, err := ReadListing(newer, "http://bitsavers.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(filepath.Join(dir, "remote.store"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetMd5("http://bitsavers.org/pdf/dec/vax/ka630.pdf", "ffffffffffffffffffffffffffffffff")
+
+	// The newest listing wins, whatever order the listings are given in
+	if changed := store.MergeListings(newerListing, olderListing); changed != 2 {
+		t.Errorf(`MergeListings() changed %d URLs, expected 2`, changed)
+	}
+	expected := map[string]Entry{
+		"http://bitsavers.org/pdf/dec/vax/ka630.pdf": {Md5: "0123456789abcdef0123456789abcdef", Md5Listing: "site.bitsavers.2021-10-01.md5"},
+		"http://bitsavers.org/pdf/dec/vax/ka650.pdf": {Md5: "22222222222222222222222222222222", Md5Listing: "site.bitsavers.2024-03-15.md5"},
+	}
+	if !reflect.DeepEqual(store.Data, expected) {
+		t.Errorf(`MergeListings() gave %+v`, store.Data)
+	}
+	// Merging an older listing later does not replace a checksum from a newer one
+	if changed := store.MergeListings(olderListing); changed != 0 {
+		t.Errorf(`MergeListings() of an older listing changed %d URLs`, changed)
+	}
+	if listing, found := store.Md5Listing("http://bitsavers.org/pdf/dec/vax/ka650.pdf"); !found || (listing != "site.bitsavers.2024-03-15.md5") {
+		t.Errorf(`Md5Listing() = %q, %v`, listing, found)
+	}
+
+	if _, err := ReadListing(filepath.Join(dir, "site.bitsavers.md5"), "http://bitsavers.org/"); err == nil {
+		t.Errorf(`ReadListing() accepted a listing with no date`)
+	}
+}
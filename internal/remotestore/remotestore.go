@@ -0,0 +1,218 @@
+package remotestore
+
+import (
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/persistentstore"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// This package implements the remote metadata store: one persistent store, shared by the collectors, that records what
+// is known about each remote file, keyed by its URL. It replaces the separate stores each collector kept (bitsavers'
+// URL => MD5 entries in bin/md5.store, vaxhaven's bin/filesize.store and its timestamps, and bin/redirects.store), so
+// that a new collector records what it learns in the same place rather than adding another file.
+//
+// Each entry holds the file's MD5 checksum (and the dated listing it came from, see Listing) and size (where known),
+// when the file was last seen at the URL, and the outcome of the last check (see the Status* constants). A URL that
+// permanently redirects (see internal/redirect) has the status "moved" and records where it moved to.
+//
+// A store that is new is seeded from the old stores (see ImportLegacy), so switching to it loses nothing.
+
+// The conventional location of the store
+const DefaultFilename = "bin/remote.store"
+
+// The values of Entry.Status
+const (
+	StatusOK      = "ok"      // The file was found at the URL
+	StatusMissing = "missing" // The server said the file does not exist
+	StatusMoved   = "moved"   // The URL permanently redirects to MovedTo
+)
+
+// Entry is what is known about the remote file at one URL
+type Entry struct {
+	Md5        string    `yaml:",omitempty"`           // The file's MD5 checksum
+	Md5Listing string    `yaml:"md5listing,omitempty"` // The listing the MD5 checksum came from (see Listing), if any
+	Size       int64     `yaml:",omitempty"`           // The file's size in bytes
+	LastSeen   time.Time `yaml:"lastseen,omitempty"`   // When the file was last found (or confirmed unchanged) at the URL
+	Status     string    `yaml:",omitempty"`           // The outcome of the last check (see the Status* constants)
+	MovedTo    string    `yaml:"movedto,omitempty"`    // The URL this one permanently redirects to, if Status is "moved"
+}
+
+// Store maps the URL of each remote file to what is known about it
+type Store struct {
+	*persistentstore.Store[string, Entry]
+}
+
+// Reads the store, creating it if the file does not exist. A store that is empty is seeded from the old stores at
+// their conventional locations (see ImportLegacy).
+func Open(filename string, verbose bool) (*Store, error) {
+	store, err := persistentstore.Store[string, Entry]{}.Init(filename, true, verbose)
+	if err != nil {
+		return &Store{store}, err
+	}
+	s := &Store{store}
+	if len(s.Data) == 0 {
+		imported, err := s.ImportLegacy(LegacyMd5Filename, LegacySizeFilename, LegacyRedirectsFilename)
+		if err != nil {
+			return s, err
+		}
+		if imported > 0 {
+			fmt.Printf("Remote Store: imported %d URLs from the old stores\n", imported)
+		}
+	}
+	return s, nil
+}
+
+// Returns the MD5 checksum recorded for the file at a URL
+func (s *Store) Md5(url string) (string, bool) {
+	entry, found := s.Lookup(url)
+	return entry.Md5, found && (entry.Md5 != "")
+}
+
+// Returns the size recorded for the file at a URL
+func (s *Store) Size(url string) (int64, bool) {
+	entry, found := s.Lookup(url)
+	return entry.Size, found && ((entry.Size != 0) || !entry.LastSeen.IsZero())
+}
+
+// Returns when the file at a URL was last seen, if it ever has been
+func (s *Store) LastSeen(url string) (time.Time, bool) {
+	entry, found := s.Lookup(url)
+	return entry.LastSeen, found && !entry.LastSeen.IsZero()
+}
+
+// Returns true if the file at a URL was last seen more than maxAge ago, or has never been seen
+func (s *Store) IsStale(url string, maxAge time.Duration) bool {
+	seen, found := s.LastSeen(url)
+	return !found || (time.Since(seen) > maxAge)
+}
+
+// Records the MD5 checksum of the file at a URL
+func (s *Store) SetMd5(url string, md5 string) {
+	s.change(url, func(entry *Entry) { entry.Md5 = md5 })
+}
+
+// Records that the file at a URL was seen now, with the given size
+func (s *Store) SetSize(url string, size int64) {
+	s.change(url, func(entry *Entry) { entry.Size = size })
+	s.Seen(url)
+}
+
+// Records that the file at a URL was seen now (e.g. the server confirmed that it is unchanged). A URL that moved
+// keeps that status, as the file was seen by following its redirect.
+func (s *Store) Seen(url string) {
+	s.change(url, func(entry *Entry) {
+		entry.LastSeen = time.Now().UTC()
+		if entry.Status != StatusMoved {
+			entry.Status = StatusOK
+		}
+	})
+}
+
+// Records the outcome of a check of a URL that did not find the file (e.g. StatusMissing)
+func (s *Store) SetStatus(url string, status string) {
+	s.change(url, func(entry *Entry) { entry.Status = status })
+}
+
+// Records that a URL permanently redirects to another
+func (s *Store) SetMoved(url string, target string) {
+	s.change(url, func(entry *Entry) { entry.Status, entry.MovedTo = StatusMoved, target })
+}
+
+// Returns every recorded permanent redirect, each URL mapped to the URL it redirects to, in the form taken by
+// document.AddRedirects
+func (s *Store) Redirects() map[string]string {
+	redirects := make(map[string]string)
+	for url, entry := range s.Data {
+		if (entry.Status == StatusMoved) && (entry.MovedTo != "") {
+			redirects[url] = entry.MovedTo
+		}
+	}
+	return redirects
+}
+
+// Applies a change to the entry for a URL, marking the store as modified only if the entry changed
+func (s *Store) change(url string, apply func(*Entry)) {
+	entry, _ := s.Lookup(url)
+	changed := entry
+	apply(&changed)
+	if changed != entry {
+		s.Update(url, changed)
+	}
+}
+
+// The stores that the remote metadata store replaces, at their conventional locations
+var (
+	LegacyMd5Filename       = "bin/md5.store"       // URL or volume path => MD5 checksum
+	LegacySizeFilename      = "bin/filesize.store"  // URL => size, with a timestamps file
+	LegacyRedirectsFilename = "bin/redirects.store" // URL => the URL it permanently redirects to
+)
+
+// Adds the URL-keyed entries of the old stores that the store does not yet have: the MD5 checksums of remote files
+// from md5Filename (whose entries for local files are left to local-archive-to-yaml), the sizes of remote files and
+// when each was confirmed from sizeFilename and its timestamps file, and the redirects from redirectsFilename.
+// An old store that does not exist is skipped. Returns the number of URLs added to or updated in the store.
+func (s *Store) ImportLegacy(md5Filename string, sizeFilename string, redirectsFilename string) (int, error) {
+	urls := make(map[string]bool)
+	md5s, err := readLegacy[string](md5Filename)
+	if err != nil {
+		return 0, err
+	}
+	for url, md5 := range md5s {
+		if _, found := s.Md5(url); isURL(url) && !found {
+			// An entry written by local-archive-to-yaml --sha256 holds the SHA-256 checksum after the MD5 checksum
+			s.SetMd5(url, hashing.ParseChecksums(md5).Md5)
+			urls[url] = true
+		}
+	}
+
+	sizes, err := readLegacy[int64](sizeFilename)
+	if err != nil {
+		return 0, err
+	}
+	seen, err := readLegacy[time.Time](persistentstore.TimestampsFilename(sizeFilename))
+	if err != nil {
+		return 0, err
+	}
+	for url, size := range sizes {
+		if _, found := s.Size(url); isURL(url) && !found {
+			s.change(url, func(entry *Entry) {
+				entry.Size = size
+				if when, found := seen[url]; found {
+					entry.LastSeen, entry.Status = when.UTC(), StatusOK
+				}
+			})
+			urls[url] = true
+		}
+	}
+
+	redirects, err := readLegacy[string](redirectsFilename)
+	if err != nil {
+		return 0, err
+	}
+	for url, target := range redirects {
+		if entry, _ := s.Lookup(url); entry.Status != StatusMoved {
+			s.SetMoved(url, target)
+			urls[url] = true
+		}
+	}
+	return len(urls), nil
+}
+
+// Reads an old store, returning nothing if it does not exist
+func readLegacy[T any](filename string) (map[string]T, error) {
+	if _, err := os.Stat(filename); (filename == "") || os.IsNotExist(err) {
+		return nil, nil
+	}
+	store, err := persistentstore.Store[string, T]{}.Init(filename, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import %s: %w", filename, err)
+	}
+	return store.Data, nil
+}
+
+func isURL(key string) bool {
+	return strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://")
+}
@@ -0,0 +1,87 @@
+package remotestore
+
+import (
+	"docs-to-yaml/internal/persistentstore"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "remote.store")
+	store, err := Open(filename, false)
+	if err != nil {
+		t.Fatalf(`Open() failed: %v`, err)
+	}
+	url := "http://www.vaxhaven.com/images/ka630.pdf"
+	if _, found := store.Size(url); found || !store.IsStale(url, time.Hour) {
+		t.Errorf(`An empty store knows about %s`, url)
+	}
+	store.SetSize(url, 1234)
+	store.SetMd5(url, "0123456789abcdef0123456789abcdef")
+	store.SetMoved("http://www.vaxhaven.com/old/ka630.pdf", url)
+	store.SetSize("http://www.vaxhaven.com/old/ka630.pdf", 1234)
+	store.Save(filename)
+
+	store, err = Open(filename, false)
+	if err != nil {
+		t.Fatalf(`Open() of the saved store failed: %v`, err)
+	}
+	if size, found := store.Size(url); !found || (size != 1234) {
+		t.Errorf(`Size() = %d, %v`, size, found)
+	}
+	if md5, found := store.Md5(url); !found || (md5 != "0123456789abcdef0123456789abcdef") {
+		t.Errorf(`Md5() = %q, %v`, md5, found)
+	}
+	if store.IsStale(url, time.Hour) || !store.IsStale(url, -time.Hour) {
+		t.Errorf(`IsStale() is wrong for a file just seen`)
+	}
+	if redirects := store.Redirects(); !reflect.DeepEqual(redirects, map[string]string{"http://www.vaxhaven.com/old/ka630.pdf": url}) {
+		t.Errorf(`Redirects() = %v`, redirects)
+	}
+
+	// Recording what is already known does not modify the store
+	store.Dirty = false
+	store.SetMd5(url, "0123456789abcdef0123456789abcdef")
+	if store.IsModified() {
+		t.Errorf(`SetMd5() of the same checksum modified the store`)
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	dir := t.TempDir()
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	md5s := &persistentstore.Store[string, string]{Data: map[string]string{
+		"http://bitsavers.org/pdf/dec/vax/ka630.pdf": "0123456789abcdef0123456789abcdef",
+		"DEC_0001/vax/ka630.pdf":                     "0123456789abcdef0123456789abcdef",
+	}}
+	sizes := &persistentstore.Store[string, int64]{Data: map[string]int64{"http://www.vaxhaven.com/images/ka630.pdf": 1234}}
+	timestamps := &persistentstore.Store[string, time.Time]{Data: map[string]time.Time{"http://www.vaxhaven.com/images/ka630.pdf": seen}}
+	for filename, store := range map[string]interface {
+		SaveAs(string, persistentstore.Format) error
+	}{"md5.store": md5s, "filesize.store": sizes, "filesize.store.timestamps": timestamps} {
+		if err := store.SaveAs(filepath.Join(dir, filename), persistentstore.FormatYAML); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := Open(filepath.Join(dir, "remote.store"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := store.ImportLegacy(filepath.Join(dir, "md5.store"), filepath.Join(dir, "filesize.store"), filepath.Join(dir, "redirects.store"))
+	if (err != nil) || (imported != 2) {
+		t.Errorf(`ImportLegacy() = %d, %v`, imported, err)
+	}
+	expected := map[string]Entry{
+		"http://bitsavers.org/pdf/dec/vax/ka630.pdf": {Md5: "0123456789abcdef0123456789abcdef"},
+		"http://www.vaxhaven.com/images/ka630.pdf":   {Size: 1234, LastSeen: seen, Status: StatusOK},
+	}
+	if !reflect.DeepEqual(store.Data, expected) {
+		t.Errorf(`ImportLegacy() gave %+v`, store.Data)
+	}
+	if imported, _ := store.ImportLegacy(filepath.Join(dir, "md5.store"), filepath.Join(dir, "filesize.store"), ""); imported != 0 {
+		t.Errorf(`ImportLegacy() a second time imported %d URLs`, imported)
+	}
+}
@@ -0,0 +1,172 @@
+package retention
+
+import (
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// This package decides which of two documents with the same MD5 checksum to keep when they arrive from different
+// sources (e.g. two archive volumes, a local and a remote catalog, or two copies of a catalog being merged).
+//
+// A Policy is a list of rules, tried in order; the first rule that prefers one document decides, and if none does the
+// document that arrived first is kept. The rules are:
+//
+//	first    keep the document that arrived first (always decides)
+//	local    prefer a local document (file:///VOLUME/... or a relative filepath) over a remote one
+//	richer   prefer the document with more metadata (title, part numbers, dates, PDF data, ...) filled in
+//	newer    prefer the document with the later PubDate (only when both have one)
+//	quality  prefer the document with the higher quality score (only when both have one; see internal/quality)
+//
+// e.g. "local,richer" keeps a local copy if there is one and otherwise the better described copy.
+// The decision is recorded in the kept document's Provenance, naming the document it was kept over, and the locations of
+// the document dropped are added to the kept document's Copies.
+
+type Document = document.Document
+
+// Rule is one way of preferring one document over another
+type Rule string
+
+const (
+	First   Rule = "first"
+	Local   Rule = "local"
+	Richer  Rule = "richer"
+	Newer   Rule = "newer"
+	Quality Rule = "quality"
+)
+
+// The rules, in the order they are documented
+var Rules = []Rule{First, Local, Richer, Newer, Quality}
+
+// Policy is a list of rules, tried in order. An empty Policy keeps the document that arrived first.
+type Policy []Rule
+
+// Parses a comma-separated list of rules, e.g. "local,richer". An empty string gives an empty Policy.
+func Parse(s string) (Policy, error) {
+	var policy Policy
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		rule := Rule(name)
+		if !slices.Contains(Rules, rule) {
+			return nil, fmt.Errorf("unknown duplicate policy rule %q (expected %s)", name, joinRules(Rules))
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+func (p Policy) String() string {
+	if len(p) == 0 {
+		return string(First)
+	}
+	return joinRules(p)
+}
+
+func joinRules(rules []Rule) string {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = string(rule)
+	}
+	return strings.Join(names, ",")
+}
+
+// Decides which of two documents to keep. Returns true if the second should be kept rather than the first,
+// and the reason for the decision.
+func (p Policy) Choose(first Document, second Document) (bool, string) {
+	for _, rule := range p {
+		if preferSecond, reason, decided := rule.compare(first, second); decided {
+			return preferSecond, reason
+		}
+	}
+	return false, "first found"
+}
+
+// Returns the document to keep out of first and second, with the decision recorded in its Provenance.
+// The locations of the document that is dropped (and their redirects) are added to those of the one kept, as both are
+// copies of the same file.
+// Nothing is recorded if both have the same filepath, as that is the same file found twice rather than a duplicate.
+// A read-only document (see document.MarkReadOnly) that is kept is returned unchanged.
+// Also returns true if the second document was kept.
+func (p Policy) Resolve(first Document, second Document) (Document, bool) {
+	preferSecond, reason := p.Choose(first, second)
+	kept, dropped := first, second
+	if preferSecond {
+		kept, dropped = second, first
+	}
+	if document.IsReadOnly(kept) {
+		return kept, preferSecond
+	}
+	if kept.Filepath != dropped.Filepath {
+		AddProvenance(&kept, fmt.Sprintf("kept over %s: %s", dropped.Filepath, reason))
+	}
+	kept.Copies = slices.Clone(kept.Copies)
+	document.NormaliseLocations(&kept)
+	document.NormaliseLocations(&dropped)
+	document.AddLocations(&kept, dropped.Copies...)
+	document.AddRedirects(&kept, dropped.Redirects)
+	return kept, preferSecond
+}
+
+// Compares two documents by a single rule. Returns true (as the third value) if the rule prefers one of them,
+// in which case the first value says whether it is the second.
+func (r Rule) compare(first Document, second Document) (bool, string, bool) {
+	switch r {
+	case First:
+		return false, "first found", true
+	case Local:
+		if IsLocal(first) != IsLocal(second) {
+			return IsLocal(second), "local copy preferred", true
+		}
+	case Richer:
+		firstCount, secondCount := MetadataCount(first), MetadataCount(second)
+		if firstCount != secondCount {
+			return secondCount > firstCount, fmt.Sprintf("richer metadata (%d fields against %d)", max(firstCount, secondCount), min(firstCount, secondCount)), true
+		}
+	case Newer:
+		if (first.PubDate != "") && (second.PubDate != "") && (first.PubDate != second.PubDate) {
+			if second.PubDate > first.PubDate {
+				return true, fmt.Sprintf("newer pubdate (%s against %s)", second.PubDate, first.PubDate), true
+			}
+			return false, fmt.Sprintf("newer pubdate (%s against %s)", first.PubDate, second.PubDate), true
+		}
+	case Quality:
+		if (first.Quality > 0) && (second.Quality > 0) && (first.Quality != second.Quality) {
+			return second.Quality > first.Quality, fmt.Sprintf("higher quality (%d against %d)", max(first.Quality, second.Quality), min(first.Quality, second.Quality)), true
+		}
+	}
+	return false, "", false
+}
+
+// Reports whether a document is held locally: its filepath is file:///VOLUME/... or relative to a tree, not a URL
+func IsLocal(doc Document) bool {
+	return strings.HasPrefix(doc.Filepath, "file:///") || !strings.Contains(doc.Filepath, "://")
+}
+
+// The Document fields that describe the document itself, as counted by MetadataCount
+var metadataFields = []string{"Size", "Title", "PubDate", "PartNum", "AltPartNums", "PdfCreator", "PdfProducer", "PdfVersion", "PdfModified", "Section"}
+
+// Returns the number of descriptive fields (see metadataFields) that are filled in
+func MetadataCount(doc Document) int {
+	value := reflect.ValueOf(doc)
+	count := 0
+	for _, name := range metadataFields {
+		if !value.FieldByName(name).IsZero() {
+			count += 1
+		}
+	}
+	return count
+}
+
+// Adds an entry to a document's Provenance, unless it is already there. Returns true if it was added.
+func AddProvenance(doc *Document, entry string) bool {
+	if (entry == "") || slices.Contains(doc.Provenance, entry) {
+		return false
+	}
+	doc.Provenance = append(doc.Provenance, entry)
+	return true
+}
@@ -0,0 +1,80 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	policy, err := Parse(" Local, richer,,newer ")
+	if err != nil {
+		t.Fatalf("Parse() failed: %s", err)
+	}
+	if expected := (Policy{Local, Richer, Newer}); !reflect.DeepEqual(policy, expected) {
+		t.Errorf("Parse() = %v, expected %v", policy, expected)
+	}
+	if policy, err := Parse(""); (err != nil) || (len(policy) != 0) || (policy.String() != "first") {
+		t.Errorf(`Parse("") = %v, %v`, policy, err)
+	}
+	if _, err := Parse("local,biggest"); err == nil {
+		t.Errorf("Parse() accepted an unknown rule")
+	}
+}
+
+func TestChoose(t *testing.T) {
+	remote := Document{Filepath: "http://bitsavers.org/pdf/a.pdf", Title: "A", PartNum: "EK-A", PubDate: "1987"}
+	local := Document{Filepath: "file:///DEC_0001/a.pdf", Title: "A", PubDate: "1985"}
+	tree := Document{Filepath: "vax/a.pdf", Title: "A", PubDate: "1985"}
+	tests := []struct {
+		policy       string
+		first        Document
+		second       Document
+		preferSecond bool
+		reason       string
+	}{
+		{"", local, remote, false, "first found"},
+		{"local", remote, local, true, "local copy preferred"},
+		{"local", local, tree, false, "first found"},
+		{"local,richer", local, tree, false, "first found"},
+		{"richer", local, remote, true, "richer metadata (3 fields against 2)"},
+		{"newer", remote, local, false, "newer pubdate (1987 against 1985)"},
+		{"newer,local", Document{Filepath: "http://x/a.pdf"}, local, true, "local copy preferred"},
+		{"first,local", remote, local, false, "first found"},
+		{"quality", Document{Filepath: "a.pdf", Quality: 62}, Document{Filepath: "b.pdf", Quality: 81}, true, "higher quality (81 against 62)"},
+		{"quality,local", Document{Filepath: "file:///DEC_0001/a.pdf", Quality: 70}, remote, false, "local copy preferred"},
+	}
+	for _, test := range tests {
+		policy, _ := Parse(test.policy)
+		if preferSecond, reason := policy.Choose(test.first, test.second); (preferSecond != test.preferSecond) || (reason != test.reason) {
+			t.Errorf("%q.Choose(%s, %s) = %t, %q", test.policy, test.first.Filepath, test.second.Filepath, preferSecond, reason)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	remote := Document{Filepath: "http://bitsavers.org/pdf/a.pdf"}
+	local := Document{Filepath: "file:///DEC_0001/a.pdf", Provenance: []string{"kept over file:///DEC_0002/a.pdf: first found"}}
+	kept, keptSecond := Policy{Local}.Resolve(remote, local)
+	expected := []string{"kept over file:///DEC_0002/a.pdf: first found", "kept over http://bitsavers.org/pdf/a.pdf: local copy preferred"}
+	if !keptSecond || !reflect.DeepEqual(kept.Provenance, expected) {
+		t.Errorf("Resolve() = %v, %t", kept, keptSecond)
+	}
+	// The same file found twice is not a duplicate, so nothing is recorded
+	if kept, _ := (Policy{}).Resolve(remote, remote); len(kept.Provenance) != 0 {
+		t.Errorf("Resolve() recorded %v for the same file", kept.Provenance)
+	}
+}
+
+func TestResolveReadOnly(t *testing.T) {
+	reference := Document{Filepath: "http://bitsavers.org/pdf/a.pdf", Provenance: []string{"from their catalog"}, Origin: "theirs.yaml"}
+	local := Document{Filepath: "file:///DEC_0001/a.pdf"}
+	kept, keptSecond := Policy{}.Resolve(reference, local)
+	if keptSecond || !reflect.DeepEqual(kept, reference) {
+		t.Errorf("Resolve() changed the read-only document: %v", kept)
+	}
+	// A read-only document that is dropped is not changed either, but its locations are still recorded
+	kept, _ = Policy{Local}.Resolve(reference, local)
+	if len(kept.Copies) != 2 || len(reference.Copies) != 0 {
+		t.Errorf("Resolve() = %v, leaving %v", kept, reference)
+	}
+}
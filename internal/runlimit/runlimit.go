@@ -0,0 +1,133 @@
+package runlimit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This package restricts a run to a subset of its usual input, so that a change can be tried out
+// quickly on one volume or a handful of files without editing the indirect file.
+//
+// A run can be limited by:
+//   o volume name (--only-volume; a comma-separated list)
+//   o path prefix, relative to the volume or tree root (--path-prefix)
+//   o modification date (--since; files modified on or after the date)
+//   o the number of files processed (--limit)
+//
+// A nil *Limits imposes no limits, so callers need not check whether limits were requested.
+
+// Limits records the restrictions that apply to the current run
+type Limits struct {
+	MaxFiles   int             // Process at most this many files (0 means no limit)
+	Volumes    map[string]bool // Process only these volumes (empty means all volumes)
+	PathPrefix string          // Process only files whose "/"-separated relative path starts with this
+	Since      time.Time       // Process only files modified at or after this time (zero means no limit)
+	taken      int             // Number of files accepted so far
+}
+
+// The date formats accepted by --since
+var sinceFormats = []string{"2006-01-02", "2006-01-02T15:04:05", time.RFC3339}
+
+// Builds Limits from the command line flag values.
+// Returns nil (no limits) if none of the values restricts the run.
+func New(maxFiles int, onlyVolumes string, pathPrefix string, since string) (*Limits, error) {
+	limits := Limits{MaxFiles: maxFiles, PathPrefix: pathPrefix, Volumes: make(map[string]bool)}
+	if maxFiles < 0 {
+		return nil, fmt.Errorf("file limit must not be negative: %d", maxFiles)
+	}
+	for _, volume := range strings.Split(onlyVolumes, ",") {
+		volume = strings.TrimSpace(volume)
+		if volume != "" {
+			limits.Volumes[volume] = true
+		}
+	}
+	if since != "" {
+		parsed := false
+		for _, format := range sinceFormats {
+			if t, err := time.ParseInLocation(format, since, time.Local); err == nil {
+				limits.Since = t
+				parsed = true
+				break
+			}
+		}
+		if !parsed {
+			return nil, fmt.Errorf("cannot parse date %q: expected YYYY-MM-DD", since)
+		}
+	}
+	if (limits.MaxFiles == 0) && (len(limits.Volumes) == 0) && (limits.PathPrefix == "") && limits.Since.IsZero() {
+		return nil, nil
+	}
+	return &limits, nil
+}
+
+// Returns true if the named volume should be processed
+func (limits *Limits) VolumeSelected(volume string) bool {
+	return (limits == nil) || (len(limits.Volumes) == 0) || limits.Volumes[volume]
+}
+
+// Returns true if the file count limit has been reached, so no further files will be selected
+func (limits *Limits) Exhausted() bool {
+	return (limits != nil) && (limits.MaxFiles > 0) && (limits.taken >= limits.MaxFiles)
+}
+
+// Returns true if the file should be processed, in which case it counts towards the file limit.
+// relativePath is "/"-separated and relative to the volume (or tree) root; fullPath is used to find the modification time.
+func (limits *Limits) FileSelected(relativePath string, fullPath string) bool {
+	return limits.selected(relativePath, func() (fs.FileInfo, error) { return os.Stat(fullPath) })
+}
+
+// Like FileSelected, for a file accessed through fsys (e.g. in a remote archive root); name is relative to the volume root.
+func (limits *Limits) FileSelectedFS(fsys fs.FS, name string) bool {
+	return limits.selected(name, func() (fs.FileInfo, error) { return fs.Stat(fsys, name) })
+}
+
+// Applies the limits to a file, calling stat only if its modification time is needed
+func (limits *Limits) selected(relativePath string, stat func() (fs.FileInfo, error)) bool {
+	if limits == nil {
+		return true
+	}
+	if limits.Exhausted() {
+		return false
+	}
+	if !strings.HasPrefix(relativePath, limits.PathPrefix) {
+		return false
+	}
+	if !limits.Since.IsZero() {
+		info, err := stat()
+		if (err != nil) || info.ModTime().Before(limits.Since) {
+			return false
+		}
+	}
+	limits.taken += 1
+	return true
+}
+
+// Describes the limits in force, for reporting at the start of a run
+func (limits *Limits) String() string {
+	if limits == nil {
+		return "no limits"
+	}
+	var parts []string
+	if len(limits.Volumes) > 0 {
+		var volumes []string
+		for volume := range limits.Volumes {
+			volumes = append(volumes, volume)
+		}
+		sort.Strings(volumes)
+		parts = append(parts, "volumes "+strings.Join(volumes, ","))
+	}
+	if limits.PathPrefix != "" {
+		parts = append(parts, "path prefix "+limits.PathPrefix)
+	}
+	if !limits.Since.IsZero() {
+		parts = append(parts, "modified since "+limits.Since.Format("2006-01-02 15:04:05"))
+	}
+	if limits.MaxFiles > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d files", limits.MaxFiles))
+	}
+	return strings.Join(parts, "; ")
+}
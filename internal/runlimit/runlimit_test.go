@@ -0,0 +1,87 @@
+package runlimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewWithoutLimits(t *testing.T) {
+	limits, err := New(0, "", "", "")
+	if (err != nil) || (limits != nil) {
+		t.Fatalf(`New() with no limits = %v, %v; expected nil, nil`, limits, err)
+	}
+	if !limits.VolumeSelected("DEC_0001") || !limits.FileSelected("a.pdf", "/nonexistent/a.pdf") || limits.Exhausted() {
+		t.Fatalf(`nil Limits restricted the run`)
+	}
+}
+
+func TestNewRejectsBadValues(t *testing.T) {
+	if _, err := New(-1, "", "", ""); err == nil {
+		t.Fatalf(`New() accepted a negative limit`)
+	}
+	if _, err := New(0, "", "", "last tuesday"); err == nil {
+		t.Fatalf(`New() accepted an unparseable date`)
+	}
+}
+
+func TestVolumeSelected(t *testing.T) {
+	limits, err := New(0, "DEC_0001, DEC_0040", "", "")
+	if err != nil {
+		t.Fatalf(`New() failed: %v`, err)
+	}
+	if !limits.VolumeSelected("DEC_0001") || !limits.VolumeSelected("DEC_0040") || limits.VolumeSelected("DEC_0002") {
+		t.Fatalf(`VolumeSelected() wrong for %s`, limits)
+	}
+}
+
+func TestFileSelected(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.pdf")
+	newFile := filepath.Join(dir, "new.pdf")
+	for _, name := range []string{oldFile, newFile} {
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatalf(`cannot create %s: %v`, name, err)
+		}
+	}
+	oldTime := time.Date(2001, 1, 1, 0, 0, 0, 0, time.Local)
+	if err := os.Chtimes(oldFile, oldTime, oldTime); err != nil {
+		t.Fatalf(`cannot set time on %s: %v`, oldFile, err)
+	}
+
+	limits, err := New(2, "", "manuals/", "2010-06-30")
+	if err != nil {
+		t.Fatalf(`New() failed: %v`, err)
+	}
+	if limits.FileSelected("other/new.pdf", newFile) {
+		t.Fatalf(`FileSelected() ignored the path prefix`)
+	}
+	if limits.FileSelected("manuals/old.pdf", oldFile) {
+		t.Fatalf(`FileSelected() ignored the modification date`)
+	}
+	if !limits.FileSelected("manuals/new.pdf", newFile) || !limits.FileSelected("manuals/new.pdf", newFile) {
+		t.Fatalf(`FileSelected() rejected a matching file`)
+	}
+	if !limits.Exhausted() || limits.FileSelected("manuals/new.pdf", newFile) {
+		t.Fatalf(`FileSelected() exceeded the file limit`)
+	}
+}
+
+func TestFileSelectedFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"manuals/old.pdf": {Data: []byte("x"), ModTime: time.Date(2001, 1, 1, 0, 0, 0, 0, time.Local)},
+		"manuals/new.pdf": {Data: []byte("x"), ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	limits, err := New(0, "", "manuals/", "2010-06-30")
+	if err != nil {
+		t.Fatalf(`New() failed: %v`, err)
+	}
+	if limits.FileSelectedFS(fsys, "manuals/old.pdf") || !limits.FileSelectedFS(fsys, "manuals/new.pdf") {
+		t.Fatalf(`FileSelectedFS() ignored the modification date`)
+	}
+	if limits.FileSelectedFS(fsys, "manuals/missing.pdf") {
+		t.Fatalf(`FileSelectedFS() selected a missing file`)
+	}
+}
@@ -0,0 +1,205 @@
+package runoff
+
+import (
+	"bufio"
+	"docs-to-yaml/internal/document"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// This package reads the title and part number of a manual from its RUNOFF (DSR) source. Many RNO files in the
+// collection are the machine-readable sources of DEC manuals, whose filenames say little (e.g. "RSX11M.RNO"), but
+// whose directives carry the title printed at the top of each page:
+//
+//	.TITLE RSX-11M Executive Reference Manual
+//	.SUBTITLE AA-2555D-TC
+//
+// The first .TITLE (or .T) gives the title, and the part number is looked for in the first .SUBTITLE (.ST, .SUBTTL)
+// and then in any "Order No." or "Order Number" line of the text. A MEM file is the formatted output of RUNOFF, with no
+// directives left, so it is read through the RNO file of the same name beside it, if there is one.
+//
+// The text of a directive is stripped of the default DSR flags: "_" quotes the character after it, "#" is a space,
+// and "&", "^", "\" and "*" (underlining, case and bold) are dropped.
+//
+// A title read in this way is recorded with TitleSource set to Runoff, and flagged as set by code (see
+// document.CodeSetFlags), so that it is replaced by a title given by hand but not the other way round.
+
+type Document = document.Document
+
+// The value of Document.TitleSource for a title read from the document's RUNOFF directives
+const Runoff = "runoff"
+
+// The most of a source read when looking for its title; the .TITLE of a manual comes before its first chapter
+const maxSource = 64 * 1024
+
+// Result is what the directives of a RUNOFF source say about its document
+type Result struct {
+	Title    string
+	Subtitle string
+	PartNum  string
+}
+
+// A line of the text that gives the order number of the manual
+var orderNumberRegex = regexp.MustCompile(`(?i)\border\s+(?:no\.?|number)\s*:?\s*(\S+)`)
+
+// Removes the DSR flag characters from the text of a directive, and collapses its spacing
+func stripFlags(text string) string {
+	var stripped strings.Builder
+	accept := false
+	for _, r := range text {
+		switch {
+		case accept:
+			stripped.WriteRune(r)
+			accept = false
+		case r == '_':
+			accept = true
+		case r == '#':
+			stripped.WriteRune(' ')
+		case (r == '&') || (r == '^') || (r == '\\') || (r == '*'):
+		default:
+			stripped.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(stripped.String()), " ")
+}
+
+// Returns the first word of a directive, upper-cased, and the text after it
+func splitDirective(command string) (string, string) {
+	command = strings.TrimLeftFunc(command, unicode.IsSpace)
+	end := strings.IndexFunc(command, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		return strings.ToUpper(command), ""
+	}
+	return strings.ToUpper(command[:end]), command[end:]
+}
+
+// Returns the first word of some text that is a DEC part number, if there is one
+func findPartNum(text string) string {
+	for _, word := range strings.Fields(text) {
+		word = strings.Trim(word, `"'(),;:[]`)
+		word = strings.TrimSuffix(word, ".")
+		if document.ValidateDecPartNumber(word) {
+			return strings.ToUpper(word)
+		}
+	}
+	return ""
+}
+
+// Reads the directives of a RUNOFF source. Returns false if it has neither a title nor a part number.
+func Parse(r io.Reader) (Result, bool) {
+	var result Result
+	orderNumber := ""
+	scanner := bufio.NewScanner(io.LimitReader(r, maxSource))
+	scanner.Buffer(make([]byte, 0, 4096), maxSource)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if !strings.HasPrefix(line, ".") {
+			if match := orderNumberRegex.FindStringSubmatch(line); (match != nil) && (orderNumber == "") {
+				orderNumber = findPartNum(match[1])
+			}
+			continue
+		}
+		// Several directives may share a line, separated by ";", but one that takes text takes the rest of the line
+		for rest := line; strings.HasPrefix(rest, "."); {
+			keyword, text := splitDirective(rest[1:])
+			if keyword == "" {
+				// A comment (".!") or not a directive at all
+				break
+			}
+			switch keyword {
+			case "TITLE", "T":
+				if result.Title == "" {
+					result.Title = stripFlags(text)
+				}
+				rest = ""
+			case "SUBTITLE", "SUBTTL", "ST":
+				if result.Subtitle == "" {
+					result.Subtitle = stripFlags(text)
+				}
+				rest = ""
+			default:
+				_, after, found := strings.Cut(text, ";")
+				if !found {
+					rest = ""
+				} else {
+					rest = strings.TrimLeftFunc(after, unicode.IsSpace)
+				}
+			}
+		}
+	}
+
+	result.PartNum = findPartNum(result.Subtitle)
+	if result.PartNum == "" {
+		result.PartNum = orderNumber
+	}
+	return result, (result.Title != "") || (result.PartNum != "")
+}
+
+// Returns the RUNOFF source of a file: an RNO file is its own source, and a MEM file has the RNO file of the same
+// name in the same directory, if there is one
+func Source(fsys fs.FS, name string) (string, bool) {
+	ext := path.Ext(name)
+	switch strings.ToUpper(ext) {
+	case ".RNO":
+		return name, true
+	case ".MEM":
+		base := strings.TrimSuffix(name, ext)
+		for _, sourceExt := range []string{".RNO", ".rno", ".Rno"} {
+			if info, err := fs.Stat(fsys, base+sourceExt); (err == nil) && !info.IsDir() {
+				return base + sourceExt, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Reads the directives of the RUNOFF source of a file (see Source). Returns false if the file has no source, or if the
+// source has neither a title nor a part number.
+func Read(fsys fs.FS, name string) (Result, bool, error) {
+	source, found := Source(fsys, name)
+	if !found {
+		return Result{}, false, nil
+	}
+	file, err := fsys.Open(source)
+	if err != nil {
+		return Result{}, false, err
+	}
+	defer file.Close()
+	result, found := Parse(file)
+	return result, found, nil
+}
+
+// Records what a RUNOFF source says in a document, replacing only a title and part number that are blank or were set
+// by code (e.g. from the filename). Returns true if the document changed.
+func Apply(doc *Document, result Result) bool {
+	before := *doc
+	title := result.Title
+	if title == "" {
+		// A subtitle that is more than the part number will do if there is no title
+		title = strings.TrimSpace(strings.Replace(result.Subtitle, result.PartNum, "", 1))
+	}
+	if (title != "") && ((doc.Title == "") || strings.Contains(doc.Flags, document.CodeSetFlags["Title"])) {
+		doc.Title = title
+		doc.TitleSource = Runoff
+		document.SetFlags(doc, document.CodeSetFlags["Title"])
+	}
+	if (result.PartNum != "") && ((doc.PartNum == "") || strings.Contains(doc.Flags, document.CodeSetFlags["PartNum"])) {
+		previous := doc.PartNum
+		doc.PartNum = result.PartNum
+		if document.ValidateDecPartNumber(previous) {
+			// A part number taken from the filename may still be another number for the document
+			document.AddAltPartNums(doc, previous)
+		}
+		document.SetFlags(doc, document.CodeSetFlags["PartNum"])
+	}
+	return (doc.Title != before.Title) || (doc.PartNum != before.PartNum) || (doc.TitleSource != before.TitleSource)
+}
+
+// Forgets that a document's title was read from its RUNOFF source, when it has been given by other means
+func Clear(doc *Document) {
+	doc.TitleSource = ""
+}
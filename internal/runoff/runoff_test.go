@@ -0,0 +1,89 @@
+package runoff
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		source string
+		result Result
+		found  bool
+	}{
+		{".TITLE RSX-11M Executive Reference Manual\n.SUBTITLE AA-2555D-TC\n.PAGE\nText", Result{"RSX-11M Executive Reference Manual", "AA-2555D-TC", "AA-2555D-TC"}, true},
+		{".! Source of the handbook\n.ps 60,70;.lm 0;.t ^&VAX-11 Software Handbook\\&\n.st Chapter 1\n.t Later title", Result{"VAX-11 Software Handbook", "Chapter 1", ""}, true},
+		{".FIRST TITLE\n.TITLE\tPDP-11#Peripherals_#1  Handbook\r\n.NO SUBTITLE", Result{"PDP-11 Peripherals#1 Handbook", "", ""}, true},
+		{".title DECnet Guide\n.c;Order No. AA-H225A-TK.", Result{"DECnet Guide", "", ""}, true},
+		{".title DECnet Guide\nOrder No. AA-H225A-TK.\n", Result{"DECnet Guide", "", "AA-H225A-TK"}, true},
+		{".subttl EK-KA630-UG-001\n", Result{"", "EK-KA630-UG-001", "EK-KA630-UG-001"}, true},
+		{".PAGE\nNothing to title this by", Result{}, false},
+	} {
+		result, found := Parse(strings.NewReader(test.source))
+		if (found != test.found) || (result != test.result) {
+			t.Errorf("Parse(%q) = %+v, %v", test.source, result, found)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	tree := fstest.MapFS{
+		"a/GUIDE.RNO":  {Data: []byte(".TITLE Guide\n")},
+		"a/GUIDE.MEM":  {},
+		"b/orphan.mem": {},
+		"c/manual.mem": {},
+		"c/manual.rno": {Data: []byte(".TITLE Manual\n")},
+		"d/notes.txt":  {},
+	}
+	for name, expected := range map[string]string{
+		"a/GUIDE.RNO":  "a/GUIDE.RNO",
+		"a/GUIDE.MEM":  "a/GUIDE.RNO",
+		"b/orphan.mem": "",
+		"c/manual.mem": "c/manual.rno",
+		"d/notes.txt":  "",
+	} {
+		if source, found := Source(tree, name); (source != expected) || (found != (expected != "")) {
+			t.Errorf("Source(%s) = %q, %v", name, source, found)
+		}
+	}
+	if result, found, err := Read(tree, "c/manual.mem"); (err != nil) || !found || (result.Title != "Manual") {
+		t.Errorf("Read() = %+v, %v, %v", result, found, err)
+	}
+	if _, found, err := Read(tree, "d/notes.txt"); found || (err != nil) {
+		t.Errorf("Read() of a file with no source = %v, %v", found, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	result := Result{Title: "RSX-11M Executive Reference Manual", PartNum: "AA-2555D-TC"}
+
+	// A title and part number taken from the filename are replaced
+	doc := Document{Title: "RSX11M", PartNum: "DEC-11-OMERA-A-D", Flags: "TP"}
+	if !Apply(&doc, result) || (doc.Title != result.Title) || (doc.PartNum != result.PartNum) || (doc.TitleSource != Runoff) {
+		t.Errorf("Apply() gave %+v", doc)
+	}
+	if (len(doc.AltPartNums) != 1) || (doc.AltPartNums[0] != "DEC-11-OMERA-A-D") {
+		t.Errorf("Apply() kept the other part numbers %v", doc.AltPartNums)
+	}
+	if Apply(&doc, result) {
+		t.Errorf("Apply() a second time changed %+v", doc)
+	}
+
+	// Those given by hand are not
+	doc = Document{Title: "Executive Reference", PartNum: "AA-2555C-TC"}
+	if Apply(&doc, result) || (doc.TitleSource != "") || (doc.Flags != "") {
+		t.Errorf("Apply() replaced a title given by hand: %+v", doc)
+	}
+
+	// A subtitle stands in for a missing title
+	doc = Document{}
+	Apply(&doc, Result{Subtitle: "AA-2555D-TC Executive Guide", PartNum: "AA-2555D-TC"})
+	if (doc.Title != "Executive Guide") || (doc.Flags != "TP") {
+		t.Errorf("Apply() of a subtitle gave %+v", doc)
+	}
+	Clear(&doc)
+	if doc.TitleSource != "" {
+		t.Errorf("Clear() left %q", doc.TitleSource)
+	}
+}
@@ -0,0 +1,230 @@
+package snapshots
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// This package keeps the history of catalogs as snapshots, so that questions such as "when did this document enter the
+// catalog?" and "what did the catalog look like last January?" can be answered.
+//
+// A snapshot store is a directory holding
+//
+//	objects/HASH.yaml.gz   each distinct catalog ever snapshotted, as written by catalog.Save, gzipped and named after
+//	                       the SHA-256 checksum of its YAML, so an unchanged catalog is only ever stored once
+//	snapshots.yaml         the log: one Entry per snapshot, oldest first
+//
+// A snapshot of a catalog that has not changed since its last snapshot is not recorded: the last snapshot still
+// describes it.
+
+type Document = document.Document
+
+// The name of the log within a store
+const LogFilename = "snapshots.yaml"
+
+// The name of the directory holding the snapshotted catalogs within a store
+const ObjectsDir = "objects"
+
+// Entry records one snapshot
+type Entry struct {
+	Time      string // When the snapshot was taken, as RFC 3339
+	Catalog   string // The name of the catalog snapshotted (see sourceconfig), e.g. "local"
+	Path      string // The filepath it was read from
+	Hash      string // The SHA-256 checksum of the catalog as written by catalog.Save: the name of its object
+	Documents int    // The number of documents in the catalog
+}
+
+// Store is a directory of snapshots
+type Store struct {
+	Dir string
+}
+
+// Returns the store held in a directory, which is created when the first snapshot is taken
+func Open(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Returns every snapshot in the store, oldest first. A store with no snapshots yet has an empty log.
+func (s *Store) Log() ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, LogFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := document.UnmarshalYaml(data, &entries); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filepath.Join(s.Dir, LogFilename), err)
+	}
+	return entries, nil
+}
+
+// Returns the snapshots of one catalog, oldest first
+func (s *Store) Snapshots(name string) ([]Entry, error) {
+	entries, err := s.Log()
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Entry
+	for _, entry := range entries {
+		if entry.Catalog == name {
+			snapshots = append(snapshots, entry)
+		}
+	}
+	return snapshots, nil
+}
+
+// Snapshots a catalog (read from path) under a name. Returns the snapshot, and whether it was taken: if the catalog is
+// unchanged since its last snapshot, that snapshot is returned instead.
+func (s *Store) Take(name string, path string, documents catalog.Catalog, now time.Time) (Entry, bool, error) {
+	data, err := document.MarshalOrderedYaml(documents)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	sum := sha256.Sum256(data)
+	entry := Entry{Time: now.Format(time.RFC3339), Catalog: name, Path: path, Hash: hex.EncodeToString(sum[:]), Documents: len(documents)}
+
+	entries, err := s.Log()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Catalog == name {
+			if entries[i].Hash == entry.Hash {
+				return entries[i], false, nil
+			}
+			break
+		}
+	}
+
+	object := s.objectFilename(entry.Hash)
+	if _, err := os.Stat(object); os.IsNotExist(err) {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(data); err != nil {
+			return Entry{}, false, err
+		}
+		if err := writer.Close(); err != nil {
+			return Entry{}, false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+			return Entry{}, false, err
+		}
+		if err := fsutil.WriteFileAtomic(object, compressed.Bytes(), 0644); err != nil {
+			return Entry{}, false, err
+		}
+	}
+
+	log, err := document.MarshalYaml(append(entries, entry))
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return entry, true, fsutil.WriteFileAtomic(filepath.Join(s.Dir, LogFilename), log, 0644)
+}
+
+// Reads the catalog recorded by a snapshot
+func (s *Store) Load(entry Entry) (catalog.Catalog, error) {
+	file, err := os.Open(s.objectFilename(entry.Hash))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.objectFilename(entry.Hash), err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.objectFilename(entry.Hash), err)
+	}
+	documents := make(catalog.Catalog)
+	if err := document.UnmarshalYaml(data, &documents); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", s.objectFilename(entry.Hash), err)
+	}
+	return documents, nil
+}
+
+func (s *Store) objectFilename(hash string) string {
+	return filepath.Join(s.Dir, ObjectsDir, hash+".yaml.gz")
+}
+
+// Returns the snapshot of a catalog that describes it at a time: the last one taken at or before then.
+// Returns false if the catalog had not been snapshotted by then.
+func (s *Store) At(name string, at time.Time) (Entry, bool, error) {
+	snapshots, err := s.Snapshots(name)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		taken, err := time.Parse(time.RFC3339, snapshots[i].Time)
+		if err != nil {
+			return Entry{}, false, fmt.Errorf("snapshot %s has a bad time: %w", snapshots[i].Hash, err)
+		}
+		if !taken.After(at) {
+			return snapshots[i], true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// Event is a change to a document between one snapshot and the next
+type Event struct {
+	Snapshot Entry                 // The snapshot in which the change was first seen
+	Kind     string                // catalog.Added, catalog.Removed or catalog.Modified
+	Fields   []catalog.FieldChange // The fields that changed, for a modified document
+}
+
+// Returns the history of a document in a catalog: when it entered the catalog, each change to its fields, and when
+// (if ever) it left it, oldest first
+func (s *Store) History(name string, key string) ([]Event, error) {
+	snapshots, err := s.Snapshots(name)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	var previous *Document
+	for _, snapshot := range snapshots {
+		documents, err := s.Load(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		doc, found := documents[key]
+		switch {
+		case found && (previous == nil):
+			events = append(events, Event{Snapshot: snapshot, Kind: catalog.Added})
+		case !found && (previous != nil):
+			events = append(events, Event{Snapshot: snapshot, Kind: catalog.Removed})
+		case found:
+			if changes := catalog.Diff(catalog.Catalog{key: *previous}, catalog.Catalog{key: doc}); len(changes) > 0 {
+				events = append(events, Event{Snapshot: snapshot, Kind: catalog.Modified, Fields: changes[0].Fields})
+			}
+		}
+		previous = nil
+		if found {
+			previous = &doc
+		}
+	}
+	return events, nil
+}
+
+// Parses a time given on the command line: RFC 3339, or a date (YYYY-MM-DD), which means the end of that day
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a date (YYYY-MM-DD) or a time (RFC 3339), found %q", s)
+	}
+	return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
@@ -0,0 +1,88 @@
+package snapshots
+
+import (
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTakeAndHistory(t *testing.T) {
+	store := Open(filepath.Join(t.TempDir(), "snapshots"))
+	january := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	february := january.AddDate(0, 1, 0)
+	march := february.AddDate(0, 1, 0)
+	april := march.AddDate(0, 1, 0)
+
+	v1 := catalog.Catalog{"abc": {Md5: "abc", Title: "KA630 Manual", Format: "PDF"}}
+	v2 := catalog.Catalog{"abc": {Md5: "abc", Title: "KA630 Manual", Format: "PDF"}, "def": {Md5: "def", Title: "VAX ALGOL", Format: "PDF"}}
+	v3 := catalog.Catalog{"abc": {Md5: "abc", Title: "KA630 CPU Module Technical Manual", Format: "PDF"}}
+
+	for _, step := range []struct {
+		documents catalog.Catalog
+		at        time.Time
+		taken     bool
+	}{
+		{v1, january, true},
+		{v2, february, true},
+		{v2, february.Add(time.Hour), false}, // Unchanged
+		{v3, march, true},
+	} {
+		if _, taken, err := store.Take("local", "bin/local.yaml", step.documents, step.at); (err != nil) || (taken != step.taken) {
+			t.Fatalf("Take() at %s: taken %v, error %v", step.at, taken, err)
+		}
+	}
+	if _, _, err := store.Take("bitsavers", "bin/yaml/bitsavers.yaml", v1, april); err != nil {
+		t.Fatal(err)
+	}
+
+	// Identical catalogs share an object
+	objects, err := os.ReadDir(filepath.Join(store.Dir, ObjectsDir))
+	if (err != nil) || (len(objects) != 3) {
+		t.Errorf("the store holds %d objects, expected 3 (error %v)", len(objects), err)
+	}
+	log, _ := store.Log()
+	if len(log) != 4 {
+		t.Errorf("the log holds %d snapshots, expected 4", len(log))
+	}
+
+	events, err := store.History("local", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(events) != 2) || (events[0].Kind != catalog.Added) || (events[0].Snapshot.Time != february.Format(time.RFC3339)) || (events[1].Kind != catalog.Removed) {
+		t.Errorf("History(def) = %+v", events)
+	}
+	events, _ = store.History("local", "abc")
+	expected := []catalog.FieldChange{{Field: "Title", Old: "KA630 Manual", New: "KA630 CPU Module Technical Manual"}}
+	if (len(events) != 2) || (events[0].Snapshot.Time != january.Format(time.RFC3339)) || !reflect.DeepEqual(events[1].Fields, expected) {
+		t.Errorf("History(abc) = %+v", events)
+	}
+
+	entry, found, err := store.At("local", february.AddDate(0, 0, 10))
+	if (err != nil) || !found {
+		t.Fatalf("At() found nothing: %v", err)
+	}
+	documents, err := store.Load(entry)
+	if (err != nil) || !reflect.DeepEqual(documents.Keys(), []string{"abc", "def"}) {
+		t.Errorf("At() gave a snapshot holding %v (error %v)", documents.Keys(), err)
+	}
+	if _, found, _ := store.At("local", january.AddDate(0, 0, -1)); found {
+		t.Errorf("At() found a snapshot before the first")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	end, err := ParseTime("2026-01-31")
+	if (err != nil) || !end.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local).Add(-time.Nanosecond)) {
+		t.Errorf("ParseTime(date) = %v, %v", end, err)
+	}
+	if at, err := ParseTime("2026-01-31T10:00:00Z"); (err != nil) || !at.Equal(time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseTime(RFC 3339) = %v, %v", at, err)
+	}
+	if _, err := ParseTime("last January"); err == nil {
+		t.Errorf("ParseTime() accepted nonsense")
+	}
+}
@@ -0,0 +1,75 @@
+package sourceconfig
+
+import (
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Programs that read several catalogs at once (build-master, serve-catalog, pre-scan) name them in the same way: on the
+// command line as NAME=FILEPATH, or simply FILEPATH (named after the file, e.g. "bitsavers" for bin/yaml/bitsavers.yaml),
+// or listed in a YAML file given with --config, each with a name and path:
+//
+//   - name: local
+//     path: bin/local.yaml
+//   - path: bin/yaml/bitsavers.yaml
+//   - name: theirs
+//     path: /mnt/shared/bitsavers.yaml
+//     readonly: true
+//   - name: manx
+//     path: bin/yaml/manx.yaml
+//     trust: low
+
+// Source is a named catalog
+type Source struct {
+	Name     string // The name reported for the catalog, e.g. "bitsavers"
+	Path     string // The filepath of the catalog
+	ReadOnly bool   `yaml:"readonly,omitempty"` // True for a reference catalog, whose documents must not be changed
+	Trust    string `yaml:",omitempty"`         // How far the catalog's metadata can be relied upon: "low", "normal" or "high" (see internal/trust)
+}
+
+// Reads the list of sources from a YAML file. An empty filename gives no sources.
+func ReadConfig(filename string) ([]Source, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	if err := document.UnmarshalYaml(data, &sources); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	for i, source := range sources {
+		if source.Path == "" {
+			return nil, fmt.Errorf("%s: entry %d has no path", filename, i+1)
+		}
+		if source.Name == "" {
+			sources[i].Name = Parse(source.Path).Name
+		}
+	}
+	return sources, nil
+}
+
+// Parses a command line source: NAME=FILEPATH, or FILEPATH, which is named after the file
+func Parse(arg string) Source {
+	if name, path, found := strings.Cut(arg, "="); found && (name != "") {
+		return Source{Name: name, Path: path}
+	}
+	return Source{Name: strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg)), Path: arg}
+}
+
+// Returns the sources listed in the --config file (if any) followed by those on the command line
+func Gather(configFilename string, args []string) ([]Source, error) {
+	sources, err := ReadConfig(configFilename)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		sources = append(sources, Parse(arg))
+	}
+	return sources, nil
+}
@@ -0,0 +1,47 @@
+package sourceconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected Source
+	}{
+		{"bin/yaml/bitsavers.yaml", Source{Name: "bitsavers", Path: "bin/yaml/bitsavers.yaml"}},
+		{"local=bin/local.yaml", Source{Name: "local", Path: "bin/local.yaml"}},
+		{"=odd.yaml", Source{Name: "=odd", Path: "=odd.yaml"}},
+	}
+	for _, test := range tests {
+		if source := Parse(test.arg); source != test.expected {
+			t.Errorf(`Parse(%q) = %+v, expected %+v`, test.arg, source, test.expected)
+		}
+	}
+}
+
+func TestGather(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "sources.yaml")
+	data := "- name: local\n  path: bin/local.yaml\n- path: bin/yaml/bitsavers.yaml\n- name: theirs\n  path: theirs.yaml\n  readonly: true\n"
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := Gather(config, []string{"bin/yaml/manx.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Source{{Name: "local", Path: "bin/local.yaml"}, {Name: "bitsavers", Path: "bin/yaml/bitsavers.yaml"}, {Name: "theirs", Path: "theirs.yaml", ReadOnly: true}, {Name: "manx", Path: "bin/yaml/manx.yaml"}}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Gather() returned %+v, expected %+v", sources, expected)
+	}
+
+	if err := os.WriteFile(config, []byte("- name: local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(config); err == nil {
+		t.Errorf("ReadConfig() accepted an entry with no path")
+	}
+}
@@ -0,0 +1,181 @@
+package subscriptions
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/persistentstore"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Other collectors publish their catalogs as YAML at stable URLs. A subscription names one of them, and
+// sync-subscriptions fetches each into a local cache (one catalog per subscription, named after it), so that the
+// programs that compare against remote catalogs (e.g. find-locally-unique) can use them without fetching anything.
+//
+// The subscriptions are listed in a YAML file, each with a name, the URL of the catalog and how often it is to be
+// checked for changes (as a Go duration, by default DefaultRefresh):
+//
+//	- name: alice
+//	  url: https://example.org/alice/catalog.yaml
+//	  refresh: 168h
+//	- name: dec-docs
+//	  url: https://example.net/dec/docs.yaml
+//
+// Catalogs are fetched with conditional requests (If-None-Match and If-Modified-Since), so an unchanged catalog is not
+// downloaded again. What each server last said (ETag, Last-Modified) and when it was last checked are kept in a
+// persistent store beside the cached catalogs.
+
+// The conventional location of the list of subscriptions
+const DefaultFilename = "data/subscriptions.yaml"
+
+// The conventional location of the cached catalogs
+const DefaultCacheDir = "bin/subscriptions"
+
+// How often a subscription is checked if it does not say
+const DefaultRefresh = 24 * time.Hour
+
+// The name of the store, in the cache directory, recording the state of each subscription
+const StateFilename = "state.yaml"
+
+// Subscription is a remote catalog to be kept in the cache
+type Subscription struct {
+	Name    string        // Names the cached catalog, e.g. "alice" for bin/subscriptions/alice.yaml
+	URL     string        `yaml:"url"`
+	Refresh time.Duration `yaml:",omitempty"` // How long a fetched catalog is used before it is checked again
+}
+
+// State records what is known about the cached copy of a subscription's catalog
+type State struct {
+	ETag         string    `yaml:"etag,omitempty"`         // The ETag the server gave with the cached catalog
+	LastModified string    `yaml:"lastmodified,omitempty"` // The Last-Modified time the server gave with it
+	Checked      time.Time // When the server was last asked for the catalog
+	Fetched      time.Time `yaml:",omitempty"` // When the cached catalog was last downloaded
+	Documents    int       // The number of documents in the cached catalog
+}
+
+// Reads the list of subscriptions. Every subscription must have a URL and a name that is unique and can be used as a
+// filename.
+func ReadConfig(filename string) ([]Subscription, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []Subscription
+	if err := document.UnmarshalYaml(data, &subscriptions); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	names := make(map[string]bool)
+	for i, subscription := range subscriptions {
+		switch {
+		case subscription.URL == "":
+			return nil, fmt.Errorf("%s: entry %d has no url", filename, i+1)
+		case (subscription.Name == "") || strings.ContainsAny(subscription.Name, `/\`) || strings.HasPrefix(subscription.Name, "."):
+			return nil, fmt.Errorf("%s: entry %d: %q cannot be used as a name", filename, i+1, subscription.Name)
+		case names[subscription.Name]:
+			return nil, fmt.Errorf("%s: entry %d: the name %q is used more than once", filename, i+1, subscription.Name)
+		case subscription.Refresh < 0:
+			return nil, fmt.Errorf("%s: entry %d: refresh must not be negative", filename, i+1)
+		}
+		names[subscription.Name] = true
+		if subscription.Refresh == 0 {
+			subscriptions[i].Refresh = DefaultRefresh
+		}
+	}
+	return subscriptions, nil
+}
+
+// Returns the filepath of the cached catalog of a subscription
+func CachePath(cacheDir string, subscription Subscription) string {
+	return filepath.Join(cacheDir, subscription.Name+".yaml")
+}
+
+// Reads the state of every subscription from the cache directory, creating both if they do not exist
+func OpenState(cacheDir string, verbose bool) (*persistentstore.Store[string, State], error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+	return persistentstore.Store[string, State]{}.Init(filepath.Join(cacheDir, StateFilename), true, verbose)
+}
+
+// Reports whether a subscription is due to be checked: it never has been, or was last checked at least its refresh
+// interval before now
+func Due(subscription Subscription, state State, now time.Time) bool {
+	return state.Checked.IsZero() || (now.Sub(state.Checked) >= subscription.Refresh)
+}
+
+// Asks the server for a subscription's catalog, conditionally if the catalog is already cached, and caches it if it
+// has changed. A catalog that cannot be read as a catalog is not cached. Returns the new state and whether the cached
+// catalog was replaced.
+func Sync(client *http.Client, subscription Subscription, cacheDir string, state State, now time.Time) (State, bool, error) {
+	request, err := http.NewRequest(http.MethodGet, subscription.URL, nil)
+	if err != nil {
+		return state, false, err
+	}
+	cached := CachePath(cacheDir, subscription)
+	if _, err := os.Stat(cached); err == nil {
+		if state.ETag != "" {
+			request.Header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			request.Header.Set("If-Modified-Since", state.LastModified)
+		}
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return state, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		state.Checked = now
+		return state, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return state, false, fmt.Errorf("%s: %s", subscription.URL, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return state, false, fmt.Errorf("%s: %w", subscription.URL, err)
+	}
+	documents := make(map[string]document.Document)
+	if err := document.UnmarshalYaml(data, &documents); err != nil {
+		return state, false, fmt.Errorf("%s: not a catalog: %w", subscription.URL, err)
+	}
+	if err := fsutil.WriteFileAtomic(cached, data, 0644); err != nil {
+		return state, false, err
+	}
+	state = State{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Checked:      now,
+		Fetched:      now,
+		Documents:    len(documents),
+	}
+	return state, true, nil
+}
+
+// Returns the filepaths of the cached catalogs of the subscriptions listed in filename, and the names of those that
+// have not yet been fetched. A list that does not exist gives no subscriptions.
+func CachedCatalogs(filename string, cacheDir string) ([]string, []string, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	subscriptions, err := ReadConfig(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cached, missing []string
+	for _, subscription := range subscriptions {
+		path := CachePath(cacheDir, subscription)
+		if _, err := os.Stat(path); err == nil {
+			cached = append(cached, path)
+		} else {
+			missing = append(missing, subscription.Name)
+		}
+	}
+	return cached, missing, nil
+}
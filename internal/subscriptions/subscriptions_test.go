@@ -0,0 +1,105 @@
+package subscriptions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "subscriptions.yaml")
+	os.WriteFile(filename, []byte("- name: alice\n  url: https://example.org/alice.yaml\n  refresh: 168h\n- name: bob\n  url: https://example.net/bob.yaml\n"), 0644)
+	subscriptions, err := ReadConfig(filename)
+	expected := []Subscription{
+		{Name: "alice", URL: "https://example.org/alice.yaml", Refresh: 168 * time.Hour},
+		{Name: "bob", URL: "https://example.net/bob.yaml", Refresh: DefaultRefresh},
+	}
+	if (err != nil) || !reflect.DeepEqual(subscriptions, expected) {
+		t.Errorf(`ReadConfig() = %+v, %v`, subscriptions, err)
+	}
+
+	for _, bad := range []string{
+		"- name: alice\n",
+		"- url: https://example.org/alice.yaml\n",
+		"- name: ../alice\n  url: https://example.org/alice.yaml\n",
+		"- name: alice\n  url: https://example.org/a.yaml\n- name: alice\n  url: https://example.org/b.yaml\n",
+	} {
+		os.WriteFile(filename, []byte(bad), 0644)
+		if _, err := ReadConfig(filename); err == nil {
+			t.Errorf(`ReadConfig(%q) gave no error`, bad)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	catalog := "0123456789abcdef0123456789abcdef:\n  title: KA630 CPU Module Technical Manual\n  md5: 0123456789abcdef0123456789abcdef\n"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(catalog))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	subscription := Subscription{Name: "alice", URL: server.URL + "/catalog.yaml", Refresh: time.Hour}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state, changed, err := Sync(server.Client(), subscription, cacheDir, State{}, start)
+	if (err != nil) || !changed || (state.ETag != `"v1"`) || (state.Documents != 1) || !state.Fetched.Equal(start) {
+		t.Fatalf(`Sync() = %+v, %v, %v`, state, changed, err)
+	}
+	if data, err := os.ReadFile(CachePath(cacheDir, subscription)); (err != nil) || (string(data) != catalog) {
+		t.Errorf(`cached catalog = %q, %v`, data, err)
+	}
+
+	if Due(subscription, state, start.Add(time.Minute)) || !Due(subscription, state, start.Add(time.Hour)) {
+		t.Errorf(`Due() disregards the refresh interval`)
+	}
+
+	later := start.Add(2 * time.Hour)
+	state, changed, err = Sync(server.Client(), subscription, cacheDir, state, later)
+	if (err != nil) || changed || !state.Checked.Equal(later) || !state.Fetched.Equal(start) || (requests != 2) {
+		t.Errorf(`Sync() of an unchanged catalog = %+v, %v, %v`, state, changed, err)
+	}
+
+	// Without the cached catalog, the request is not conditional
+	os.Remove(CachePath(cacheDir, subscription))
+	if _, changed, err = Sync(server.Client(), subscription, cacheDir, state, later); (err != nil) || !changed {
+		t.Errorf(`Sync() without a cached catalog = %v, %v`, changed, err)
+	}
+
+	configFilename := filepath.Join(t.TempDir(), "subscriptions.yaml")
+	os.WriteFile(configFilename, []byte("- name: alice\n  url: "+subscription.URL+"\n- name: bob\n  url: https://example.net/bob.yaml\n"), 0644)
+	cached, missing, err := CachedCatalogs(configFilename, cacheDir)
+	if (err != nil) || !reflect.DeepEqual(cached, []string{CachePath(cacheDir, subscription)}) || !reflect.DeepEqual(missing, []string{"bob"}) {
+		t.Errorf(`CachedCatalogs() = %v, %v, %v`, cached, missing, err)
+	}
+	if cached, missing, err := CachedCatalogs(filepath.Join(cacheDir, "none.yaml"), cacheDir); (cached != nil) || (missing != nil) || (err != nil) {
+		t.Errorf(`CachedCatalogs() without a list = %v, %v, %v`, cached, missing, err)
+	}
+}
+
+func TestSyncRejectsNonCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>Not found</body></html>"))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	subscription := Subscription{Name: "alice", URL: server.URL}
+	if _, changed, err := Sync(server.Client(), subscription, cacheDir, State{}, time.Now()); (err == nil) || changed {
+		t.Errorf(`Sync() of a web page = %v, %v`, changed, err)
+	}
+	if _, err := os.Stat(CachePath(cacheDir, subscription)); !os.IsNotExist(err) {
+		t.Errorf(`a web page was cached`)
+	}
+}
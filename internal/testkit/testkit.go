@@ -0,0 +1,439 @@
+package testkit
+
+import (
+	"bytes"
+	"crypto/md5"
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/crcfile"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/internal/md5sums"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// This package synthesises small local archive volumes of each category (see internal/archivecategory) as directory
+// trees, so that the programs that read real volumes can be tested end to end without one.
+//
+// A volume holds a few known documents, each a file with made-up content in a directory named after its system
+// (e.g. vax/ek-ka630-tm-001.pdf), listed in the volume's index files in the layout of its category:
+//
+//	Regular:  index.htm listing every document
+//	HTML:     INDEX.HTM linking to HTML/VAX.HTM etc., each listing the documents of one system (names upper case)
+//	Metadata: index.htm linking to metadata/vax.htm etc., likewise
+//	Custom:   index.htm listing the documents of the first system and linking to a sub-index for each other system,
+//	          with the CRC-32 of every document in DEC_0040.CRC
+//	CSV:      index.csv, index.yaml and md5sums, as written by file-tree-to-yaml (and read-only, as when burned)
+//
+// Everything is derived from Spec.Seed, so the same Spec always gives the same volume, and a Spec may ask for some of
+// the documents to be corrupted (see Corruption) so that the checks that should catch each kind of damage can be
+// exercised.
+
+// Corruption is a kind of damage done to one document of a volume
+type Corruption string
+
+const (
+	// The document is listed in the index files but its file is absent
+	MissingFile Corruption = "missing-file"
+	// The document's file is present but listed in no index file
+	UnlistedFile Corruption = "unlisted-file"
+	// The index files link to the document with the case of its name changed (HTML categories only)
+	WrongCase Corruption = "wrong-case"
+	// The checksum recorded for the document is wrong: in md5sums (CSV) or in DEC_0040.CRC (Custom)
+	BadChecksum Corruption = "bad-checksum"
+	// The document's file is shorter than recorded (CSV and Custom only)
+	Truncated Corruption = "truncated"
+)
+
+// The categories each corruption applies to
+var applicable = map[Corruption][]archivecategory.Category{
+	MissingFile:  {archivecategory.Regular, archivecategory.HTML, archivecategory.Metadata, archivecategory.Custom, archivecategory.CSV},
+	UnlistedFile: {archivecategory.Regular, archivecategory.HTML, archivecategory.Metadata, archivecategory.Custom, archivecategory.CSV},
+	WrongCase:    {archivecategory.Regular, archivecategory.HTML, archivecategory.Metadata, archivecategory.Custom},
+	BadChecksum:  {archivecategory.Custom, archivecategory.CSV},
+	Truncated:    {archivecategory.Custom, archivecategory.CSV},
+}
+
+// Spec describes the volume to build
+type Spec struct {
+	Category    archivecategory.Category
+	Name        string       // The volume name; by default "DEC_0001" (a Custom volume is always DEC_0040)
+	Documents   int          // The number of documents; by default DefaultDocuments
+	Seed        int64        // Chooses the documents and their content, and which of them are corrupted
+	Corruptions []Corruption // Each is done to a different document
+}
+
+// The number of documents in a volume if the Spec does not say
+const DefaultDocuments = 6
+
+// Doc is one document of a built volume
+type Doc struct {
+	Path       string     // The file's path within the volume, e.g. "vax/ek-ka630-tm-001.pdf"
+	PartNum    string     // As listed in the index
+	Title      string     // As listed in the index
+	Format     string     // PDF or TXT
+	Md5        string     // The MD5 checksum of the document's intended content
+	Size       int64      // The size of the document's intended content
+	Corruption Corruption // The damage done to the document, if any
+}
+
+// Volume is a built volume
+type Volume struct {
+	Root      string // The directory holding the volume
+	Name      string
+	Category  archivecategory.Category
+	Documents []Doc // Every document, in the order listed
+}
+
+// Returns the documents of the volume without the given corruption (or, if none is given, without any)
+func (v Volume) Intact(allowed ...Corruption) []Doc {
+	var docs []Doc
+	for _, doc := range v.Documents {
+		if (doc.Corruption == "") || slices.Contains(allowed, doc.Corruption) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// Returns the document with the given corruption, and whether there is one
+func (v Volume) Corrupted(corruption Corruption) (Doc, bool) {
+	for _, doc := range v.Documents {
+		if doc.Corruption == corruption {
+			return doc, true
+		}
+	}
+	return Doc{}, false
+}
+
+// The systems the made-up documents belong to; each is a directory of the volume
+var systems = []struct {
+	dir      string
+	subjects [][2]string // Part number code, subject
+}{
+	{"vax", [][2]string{{"KA630", "KA630 CPU Module"}, {"VAXAR", "VAX Architecture"}, {"RQDX3", "RQDX3 Disk Controller"}, {"DELQA", "DELQA Ethernet Adapter"}}},
+	{"pdp11", [][2]string{{"11024", "PDP-11/24 System"}, {"RL02", "RL01/RL02 Disk Drive"}, {"TU58", "TU58 Tape Cartridge Drive"}, {"DZ11", "DZ11 Asynchronous Multiplexer"}}},
+}
+
+// The kinds of manual, with the code that appears in their part numbers
+var kinds = [][2]string{{"TM", "Technical Manual"}, {"UG", "User's Guide"}, {"MG", "Maintenance Guide"}, {"IN", "Installation Guide"}}
+
+// Builds the volume described by spec in a new temporary directory of the test, failing the test if it cannot
+func Build(t testing.TB, spec Spec) Volume {
+	t.Helper()
+	volume, err := BuildIn(t.TempDir(), spec)
+	if err != nil {
+		t.Fatalf("testkit: cannot build %s volume: %v", spec.Category, err)
+	}
+	return volume
+}
+
+// Builds the volume described by spec in dir, which should be empty
+func BuildIn(dir string, spec Spec) (Volume, error) {
+	volume := Volume{Root: dir, Name: spec.Name, Category: spec.Category}
+	if volume.Name == "" {
+		volume.Name = "DEC_0001"
+	}
+	if spec.Category == archivecategory.Custom {
+		volume.Name = "DEC_0040"
+	}
+	count := spec.Documents
+	if count == 0 {
+		count = DefaultDocuments
+	}
+	if len(spec.Corruptions) > count {
+		return volume, fmt.Errorf("%d corruptions asked for but only %d documents", len(spec.Corruptions), count)
+	}
+	for _, corruption := range spec.Corruptions {
+		if !slices.Contains(applicable[corruption], spec.Category) {
+			return volume, fmt.Errorf("%s does not apply to a %s volume", corruption, spec.Category)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(spec.Seed))
+	contents := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		system := systems[i%len(systems)]
+		subject := system.subjects[rng.Intn(len(system.subjects))]
+		kind := kinds[rng.Intn(len(kinds))]
+		doc := Doc{PartNum: fmt.Sprintf("EK-%s-%s-%03d", subject[0], kind[0], i+1), Title: subject[1] + " " + kind[1], Format: "PDF"}
+		if rng.Intn(4) == 0 {
+			doc.Format = "TXT"
+		}
+		name := strings.ToLower(doc.PartNum + "." + doc.Format)
+		doc.Path = path.Join(system.dir, name)
+		if spec.Category == archivecategory.HTML {
+			doc.Path = strings.ToUpper(doc.Path)
+		}
+		contents[i] = content(rng, doc)
+		sum := md5.Sum(contents[i])
+		doc.Md5, doc.Size = hex.EncodeToString(sum[:]), int64(len(contents[i]))
+		volume.Documents = append(volume.Documents, doc)
+	}
+	for i, victim := range rng.Perm(count)[:len(spec.Corruptions)] {
+		volume.Documents[victim].Corruption = spec.Corruptions[i]
+	}
+
+	for i, doc := range volume.Documents {
+		data := contents[i]
+		switch doc.Corruption {
+		case MissingFile:
+			continue
+		case Truncated:
+			data = data[:len(data)/2]
+		}
+		if err := writeFile(dir, doc.Path, data, 0644); err != nil {
+			return volume, err
+		}
+	}
+
+	var err error
+	switch spec.Category {
+	case archivecategory.Regular:
+		err = writeFile(dir, "index.htm", documentsIndex(listed(volume.Documents, "")), 0644)
+	case archivecategory.HTML:
+		err = writeContents(dir, volume.Documents, "INDEX.HTM", "HTML", ".HTM", true)
+	case archivecategory.Metadata:
+		err = writeContents(dir, volume.Documents, "index.htm", "metadata", ".htm", false)
+	case archivecategory.Custom:
+		err = writeCustom(dir, volume.Documents, contents)
+	case archivecategory.CSV:
+		err = writeCsv(dir, volume.Documents)
+	default:
+		err = fmt.Errorf("cannot build a %s volume", spec.Category)
+	}
+	return volume, err
+}
+
+// Returns the made-up content of a document: a recognisable header followed by a few hundred bytes chosen by rng
+func content(rng *rand.Rand, doc Doc) []byte {
+	var buffer bytes.Buffer
+	if doc.Format == "PDF" {
+		buffer.WriteString("%PDF-1.2\n% ")
+	}
+	fmt.Fprintf(&buffer, "%s %s\n", doc.PartNum, doc.Title)
+	filler := make([]byte, 200+rng.Intn(800))
+	for i := range filler {
+		filler[i] = byte('a' + rng.Intn(26))
+	}
+	buffer.Write(filler)
+	return buffer.Bytes()
+}
+
+// Writes a file of the volume, creating its directory if necessary
+func writeFile(root string, name string, data []byte, perm os.FileMode) error {
+	filename := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, perm)
+}
+
+// link is an entry of an index file
+type link struct {
+	target  string
+	partNum string
+	title   string
+}
+
+// Returns the index entries for the documents, with their paths relative to dir, leaving out the unlisted documents
+func listed(docs []Doc, dir string) []link {
+	var links []link
+	for _, doc := range docs {
+		if doc.Corruption == UnlistedFile {
+			continue
+		}
+		target := doc.Path
+		if dir != "" {
+			target, _ = filepath.Rel(dir, doc.Path)
+			target = filepath.ToSlash(target)
+		}
+		if doc.Corruption == WrongCase {
+			target = swapCase(target)
+		}
+		links = append(links, link{target, doc.PartNum, doc.Title})
+	}
+	return links
+}
+
+func swapCase(s string) string {
+	if upper := strings.ToUpper(s); upper != s {
+		return upper
+	}
+	return strings.ToLower(s)
+}
+
+// Returns an index HTML file listing documents (see indexhtml.LayoutDocuments)
+func documentsIndex(links []link) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("<HTML>\n<BODY>\n<TABLE>\n")
+	for _, l := range links {
+		fmt.Fprintf(&buffer, "<TR VALIGN=TOP>\n<TD> <A HREF=\"%s\"> %s\n<TD> %s\n</TR>\n", l.target, l.partNum, l.title)
+	}
+	buffer.WriteString("</TABLE>\n</BODY>\n</HTML>\n")
+	return buffer.Bytes()
+}
+
+// Returns the documents of each system, in the order of systems
+func bySystem(docs []Doc) [][]Doc {
+	grouped := make([][]Doc, len(systems))
+	for _, doc := range docs {
+		for i, system := range systems {
+			if strings.EqualFold(path.Dir(doc.Path), system.dir) {
+				grouped[i] = append(grouped[i], doc)
+			}
+		}
+	}
+	return grouped
+}
+
+// Writes a top-level index linking to one sub-index per system in subdir, each listing that system's documents
+// (see indexhtml.LayoutHTMLContents and indexhtml.LayoutMetadataContents)
+func writeContents(root string, docs []Doc, indexName string, subdir string, extension string, upper bool) error {
+	var buffer bytes.Buffer
+	buffer.WriteString("<HTML>\n<BODY>\n<TABLE>\n")
+	for i, group := range bySystem(docs) {
+		if len(group) == 0 {
+			continue
+		}
+		name := path.Join(subdir, systems[i].dir+extension)
+		if upper {
+			name = strings.ToUpper(name)
+		}
+		fmt.Fprintf(&buffer, "<TR>\n<TD> <A HREF=\"%s\"> %s </A> </TD>\n</TR>\n", name, strings.ToUpper(systems[i].dir))
+		if err := writeFile(root, name, documentsIndex(listed(group, subdir)), 0644); err != nil {
+			return err
+		}
+	}
+	buffer.WriteString("</TABLE>\n</BODY>\n</HTML>\n")
+	return writeFile(root, indexName, buffer.Bytes(), 0644)
+}
+
+// Writes the index.htm of a Custom volume, which lists the documents of the first system and links to a sub-index
+// (in the system's directory) for each other system (see indexhtml.LayoutCustom), and DEC_0040.CRC
+func writeCustom(root string, docs []Doc, contents [][]byte) error {
+	var buffer bytes.Buffer
+	buffer.WriteString("<HTML>\n<BODY>\n<TABLE>\n")
+	for i, group := range bySystem(docs) {
+		if i == 0 {
+			for _, l := range listed(group, "") {
+				fmt.Fprintf(&buffer, "<TR>\n<TD> <A HREF=\"%s\"> %s </A>\n<TD> %s\n</TR>\n", l.target, l.partNum, l.title)
+			}
+			continue
+		}
+		if len(group) == 0 {
+			continue
+		}
+		name := path.Join(systems[i].dir, systems[i].dir+"manuals.htm")
+		fmt.Fprintf(&buffer, "<TR>\n<TD> <A HREF=\"%s\"> %s manuals</A>\n<TD> Further %s manuals\n</TR>\n", name, strings.ToUpper(systems[i].dir), strings.ToUpper(systems[i].dir))
+		if err := writeFile(root, name, documentsIndex(listed(group, systems[i].dir)), 0644); err != nil {
+			return err
+		}
+	}
+	buffer.WriteString("</TABLE>\n</BODY>\n</HTML>\n")
+	if err := writeFile(root, "index.htm", buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	// The CRC file names the files in upper case, with "\" as the separator, as the discs do
+	var crcs bytes.Buffer
+	crcs.WriteString("; CRC-32 of every file on DEC_0040\n")
+	for i, doc := range docs {
+		if doc.Corruption == UnlistedFile {
+			continue
+		}
+		crc := crcfile.Checksum(contents[i], crcfile.CRC32)
+		if doc.Corruption == BadChecksum {
+			crc ^= 0xFFFF
+		}
+		fmt.Fprintf(&crcs, "%08X  %s\n", crc, strings.ToUpper(strings.ReplaceAll(doc.Path, "/", `\`)))
+	}
+	return writeFile(root, "DEC_0040.CRC", crcs.Bytes(), 0444)
+}
+
+// Writes index.csv, index.yaml and md5sums, read-only as on a burned volume
+func writeCsv(root string, docs []Doc) error {
+	var records []indexcsv.Record
+	catalog := make(map[string]document.Document)
+	var checksums []md5sums.Entry
+	for _, doc := range docs {
+		if doc.Corruption == UnlistedFile {
+			continue
+		}
+		entry := document.Document{Format: doc.Format, Size: doc.Size, Md5: doc.Md5, Title: doc.Title, PartNum: doc.PartNum, Collection: "local", Filepath: doc.Path}
+		catalog[doc.Md5] = entry
+		records = append(records, indexcsv.RecordFromDocument(entry))
+		md5 := doc.Md5
+		if doc.Corruption == BadChecksum {
+			md5 = strings.Repeat("0", len(md5))
+		}
+		checksums = append(checksums, md5sums.Entry{Md5: md5, Filepath: doc.Path})
+	}
+
+	var csvData bytes.Buffer
+	if err := indexcsv.Write(&csvData, records); err != nil {
+		return err
+	}
+	yamlData, err := document.MarshalOrderedYaml(catalog)
+	if err != nil {
+		return err
+	}
+	for _, metafile := range []struct {
+		name string
+		data []byte
+	}{{indexcsv.IndexFilename, csvData.Bytes()}, {"index.yaml", yamlData}} {
+		if err := writeFile(root, metafile.name, metafile.data, 0444); err != nil {
+			return err
+		}
+		sum := md5.Sum(metafile.data)
+		checksums = append(checksums, md5sums.Entry{Md5: hex.EncodeToString(sum[:]), Filepath: metafile.name})
+	}
+	if err := md5sums.WriteFile(filepath.Join(root, md5sums.Md5sumsFilename), checksums); err != nil {
+		return err
+	}
+	return os.Chmod(filepath.Join(root, md5sums.Md5sumsFilename), 0444)
+}
+
+// Records the events (see internal/events) reported for the rest of the test. Returns a function that gives the events
+// reported so far.
+func CaptureEvents(t testing.TB) func() []events.Event {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "events.ndjson")
+	if err := events.Open(filename); err != nil {
+		t.Fatalf("testkit: cannot open events file: %v", err)
+	}
+	t.Cleanup(func() { events.Close() })
+	return func() []events.Event {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("testkit: cannot read events file: %v", err)
+		}
+		var reported []events.Event
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			var event events.Event
+			if (len(line) > 0) && (json.Unmarshal(line, &event) == nil) {
+				reported = append(reported, event)
+			}
+		}
+		return reported
+	}
+}
+
+// Reports whether an event of the given type was reported for a path ending in suffix
+func HasEvent(reported []events.Event, eventType string, suffix string) bool {
+	for _, event := range reported {
+		if (event.Type == eventType) && strings.HasSuffix(event.Path, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package testkit
+
+import (
+	"docs-to-yaml/internal/archivecategory"
+	"reflect"
+	"testing"
+)
+
+func TestBuildIsDeterministic(t *testing.T) {
+	spec := Spec{Category: archivecategory.Metadata, Seed: 42, Corruptions: []Corruption{MissingFile, UnlistedFile}}
+	first, second := Build(t, spec), Build(t, spec)
+	if !reflect.DeepEqual(first.Documents, second.Documents) {
+		t.Errorf(`Build() gave %+v then %+v`, first.Documents, second.Documents)
+	}
+	if len(first.Intact()) != DefaultDocuments-2 {
+		t.Errorf(`Intact() = %d documents, want %d`, len(first.Intact()), DefaultDocuments-2)
+	}
+}
+
+func TestBuildDetectedCategory(t *testing.T) {
+	for _, category := range []archivecategory.Category{archivecategory.Regular, archivecategory.HTML, archivecategory.Metadata, archivecategory.Custom, archivecategory.CSV} {
+		volume := Build(t, Spec{Category: category, Seed: 1})
+		if result := archivecategory.Detect(volume.Root); result.Category != category {
+			t.Errorf(`Detect() of a built %s volume = %s %v`, category, result.Category, result.Problems)
+		}
+	}
+}
+
+func TestBuildRejectsInapplicableCorruption(t *testing.T) {
+	for _, spec := range []Spec{
+		{Category: archivecategory.Regular, Corruptions: []Corruption{BadChecksum}},
+		{Category: archivecategory.CSV, Corruptions: []Corruption{WrongCase}},
+		{Category: archivecategory.HTML, Documents: 1, Corruptions: []Corruption{MissingFile, UnlistedFile}},
+	} {
+		if _, err := BuildIn(t.TempDir(), spec); err == nil {
+			t.Errorf(`BuildIn(%+v) gave no error`, spec)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package textlayer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"regexp"
+)
+
+// This package tells a document that has a text layer (so it can be searched and copied from) from one that is only
+// page images, such as a PDF straight from a scanner.
+//
+// A PDF draws text with a font, and every font it uses is named in a /Font resource dictionary, so a PDF without any
+// /Font is image-only. Since PDF 1.5 the dictionaries may be held in compressed object streams, so each Flate-encoded
+// stream is also inflated and searched. The check does not tell invisible OCR text from visible text: a scan that has
+// already been OCRed has fonts, and so has a text layer, which is what is wanted.
+
+// Matches the start of a stream's data. The data follows the end of line after the keyword.
+var streamRegex = regexp.MustCompile(`stream\r?\n`)
+
+var fontKey = []byte("/Font")
+
+// Streams larger than this are not inflated. Object streams are small; large streams are images and page content.
+const maxInflatedStream = 8 << 20
+
+// Reports whether the named PDF file has a text layer
+func PdfFileHasText(filename string) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	return PdfHasText(data), nil
+}
+
+// Reports whether the PDF data has a text layer
+func PdfHasText(data []byte) bool {
+	if bytes.Contains(data, fontKey) {
+		return true
+	}
+	for _, match := range streamRegex.FindAllIndex(data, -1) {
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		inflated, err := inflate(data[start : start+end])
+		if (err == nil) && bytes.Contains(inflated, fontKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Inflates Flate-encoded (zlib) data, giving up on data that is not zlib or would be too large.
+// A truncated stream (e.g. one with trailing end-of-line bytes) still yields what could be inflated.
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, maxInflatedStream))
+	if (err != nil) && (len(inflated) == 0) {
+		return nil, err
+	}
+	return inflated, nil
+}
@@ -0,0 +1,58 @@
+package textlayer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Builds a minimal PDF whose page resources are the given dictionary, optionally held in a compressed object stream
+func buildPdf(resources string, compressed bool) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	page := fmt.Sprintf("<< /Type /Page /Resources %s /Contents 4 0 R >>", resources)
+	if compressed {
+		var stream bytes.Buffer
+		w := zlib.NewWriter(&stream)
+		w.Write([]byte(page))
+		w.Close()
+		fmt.Fprintf(&pdf, "5 0 obj\n<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode /Length %d >>\nstream\r\n", stream.Len())
+		pdf.Write(stream.Bytes())
+		pdf.WriteString("\r\nendstream\nendobj\n")
+	} else {
+		fmt.Fprintf(&pdf, "3 0 obj\n%s\nendobj\n", page)
+	}
+	pdf.WriteString("4 0 obj\n<< /Length 20 >>\nstream\nq 612 0 0 792 0 0 cm /Im1 Do Q\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestPdfHasText(t *testing.T) {
+	tests := []struct {
+		name       string
+		resources  string
+		compressed bool
+		expected   bool
+	}{
+		{"scan", "<< /XObject << /Im1 6 0 R >> >>", false, false},
+		{"text", "<< /Font << /F1 7 0 R >> >>", false, true},
+		{"compressed scan", "<< /XObject << /Im1 6 0 R >> >>", true, false},
+		{"compressed text", "<< /Font << /F1 7 0 R >> /XObject << /Im1 6 0 R >> >>", true, true},
+	}
+	for _, test := range tests {
+		if hasText := PdfHasText(buildPdf(test.resources, test.compressed)); hasText != test.expected {
+			t.Errorf(`PdfHasText(%s) = %v, expected %v`, test.name, hasText, test.expected)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "scan.pdf")
+	os.WriteFile(filename, buildPdf("<< >>", false), 0644)
+	if hasText, err := PdfFileHasText(filename); hasText || (err != nil) {
+		t.Errorf(`PdfFileHasText() = %v, %v`, hasText, err)
+	}
+	if _, err := PdfFileHasText(filename + ".missing"); err == nil {
+		t.Errorf(`PdfFileHasText() of a missing file succeeded`)
+	}
+}
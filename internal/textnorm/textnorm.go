@@ -0,0 +1,168 @@
+package textnorm
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This package normalises the text of document metadata, which arrives from many sources (index files, web pages,
+// PDF metadata) in a mixture of forms.
+//
+// ComposeLatin composes a letter followed by a combining accent into the single precomposed character, so that "é"
+// matches whether it was written as U+00E9 or as "e" + U+0301. It is not Unicode NFC: the standard library has no
+// Unicode normalisation, so only the Latin letters with the accents found in document titles (those of Latin-1 and
+// Latin Extended-A, with one accent each) are composed. Anything else is left as it is: letters with two accents,
+// Vietnamese, Greek and Cyrillic letters, Hangul, and the reordering of several combining marks. Two texts that NFC
+// would make equal may therefore still differ, but no text is changed in a way that NFC would not change it.
+//
+// ToASCII transliterates text for the archive files that must be 7-bit ASCII: accents are dropped ("é" => "e"),
+// ligatures and typographic punctuation are spelt out ("æ" => "ae", "—" => "-") and anything else becomes "?".
+//
+// Unaccented drops the accents in the same way, and spells out the letters that have no accent to drop ("ß" => "ss"),
+// but leaves everything else as it is, for comparing text without regard to accents (see internal/collate).
+//
+// ValidText makes text that is not valid UTF-8 (typically Latin-1, from the index files of rescued discs) fit to be
+// written to YAML. It is for text alone: a filepath must still name its file, so is escaped instead (see
+// fsutil.EscapeInvalidUTF8).
+
+// Each combining accent, with the letters it composes with and (in the same order) the precomposed results
+var compositions = []struct {
+	accent   rune
+	bases    string
+	composed string
+}{
+	{'\u0300', "AEIOUaeiou", "ÀÈÌÒÙàèìòù"},                             // grave
+	{'\u0301', "AEIOUYaeiouyCcNnSsZzLlRr", "ÁÉÍÓÚÝáéíóúýĆćŃńŚśŹźĹĺŔŕ"}, // acute
+	{'\u0302', "AEIOUaeiouCcGgHhJjSsWwYy", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷ"}, // circumflex
+	{'\u0303', "ANOanoIiUu", "ÃÑÕãñõĨĩŨũ"},                             // tilde
+	{'\u0304', "AaEeIiOoUu", "ĀāĒēĪīŌōŪū"},                             // macron
+	{'\u0306', "AaGgUuEeIiOo", "ĂăĞğŬŭĔĕĬĭŎŏ"},                         // breve
+	{'\u0307', "CcEeGgZzI", "ĊċĖėĠġŻżİ"},                               // dot above
+	{'\u0308', "AEIOUaeiouyY", "ÄËÏÖÜäëïöüÿŸ"},                         // diaeresis
+	{'\u030a', "AaUu", "ÅåŮů"},                                         // ring above
+	{'\u030b', "OoUu", "ŐőŰű"},                                         // double acute
+	{'\u030c', "CcDdEeNnRrSsTtZz", "ČčĎďĚěŇňŘřŠšŤťŽž"},                 // caron
+	{'\u0327', "CcSsTtGgKkLlNnRr", "ÇçŞşŢţĢģĶķĻļŅņŖŗ"},                 // cedilla
+	{'\u0328', "AaEeIiUu", "ĄąĘęĮįŲų"},                                 // ogonek
+}
+
+// base + accent => precomposed, and precomposed => base
+var composeTable = make(map[[2]rune]rune)
+var baseTable = make(map[rune]rune)
+
+func init() {
+	for _, c := range compositions {
+		bases, composed := []rune(c.bases), []rune(c.composed)
+		if len(bases) != len(composed) {
+			panic("textnorm: mismatched composition table for accent " + string(c.accent))
+		}
+		for i, base := range bases {
+			composeTable[[2]rune{base, c.accent}] = composed[i]
+			baseTable[composed[i]] = base
+		}
+	}
+}
+
+// Characters that have no accent to drop but an ASCII spelling
+var transliterations = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Ø': "O", 'ø': "o", 'Ł': "L", 'ł': "l",
+	'Đ': "D", 'đ': "d", 'Ð': "D", 'ð': "d", 'Þ': "Th", 'þ': "th", 'ı': "i",
+	'‘': "'", '’': "'", '‚': "'", '′': "'", '“': `"`, '”': `"`, '„': `"`, '″': `"`, '«': `"`, '»': `"`,
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...", '•': "*", '·': ".", '×': "x", '÷': "/", '©': "(C)", '®': "(R)", '™': "(TM)",
+	'µ': "u", 'μ': "u", '°': "deg", '½': "1/2", '¼': "1/4", '¾': "3/4", '¿': "?", '¡': "!",
+	'\u00a0': " ", '\u2009': " ", '\u202f': " ", '\u200b': "", '\ufeff': "",
+}
+
+// Returns s with each Latin letter followed by a combining accent replaced by the precomposed letter (see above for
+// what is and is not composed). Strings that are already composed (including all ASCII) are returned unchanged.
+func ComposeLatin(s string) string {
+	if !hasCombining(s) {
+		return s
+	}
+	var out []rune
+	for _, r := range s {
+		if n := len(out); (n > 0) && unicode.Is(unicode.Mn, r) {
+			if composed, ok := composeTable[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// Returns s transliterated to 7-bit ASCII (see above). Invalid UTF-8 becomes "?".
+func ToASCII(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			out.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// A combining accent that did not compose is dropped, as a composed one would be
+		case baseTable[r] != 0:
+			out.WriteRune(baseTable[r])
+		default:
+			if spelling, ok := transliterations[r]; ok {
+				out.WriteString(spelling)
+			} else {
+				out.WriteByte('?')
+			}
+		}
+	}
+	return out.String()
+}
+
+// Returns s with the accents dropped and the letters without an ASCII form spelt out (see above); everything else,
+// including letters of other scripts, is unchanged
+func Unaccented(s string) string {
+	var out strings.Builder
+	for _, r := range ComposeLatin(s) {
+		switch {
+		case r < utf8.RuneSelf:
+			out.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+		case baseTable[r] != 0:
+			out.WriteRune(baseTable[r])
+		case unicode.IsLetter(r) && (transliterations[r] != ""):
+			out.WriteString(transliterations[r])
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// Returns s with each byte that is not part of a valid UTF-8 sequence taken as the Latin-1 character it would be, or
+// as U+FFFD if that would be a control character. Valid UTF-8 (including any backslash) is returned unchanged.
+func ValidText(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError) && (size <= 1) {
+			r = utf8.RuneError
+			if s[i] >= 0xa0 {
+				r = rune(s[i])
+			}
+		}
+		out.WriteRune(r)
+		i += size
+	}
+	return out.String()
+}
+
+// Reports whether s contains a combining mark (so may need composing)
+func hasCombining(s string) bool {
+	for _, r := range s {
+		if (r >= utf8.RuneSelf) && unicode.Is(unicode.Mn, r) {
+			return true
+		}
+	}
+	return false
+}
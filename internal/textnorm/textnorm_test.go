@@ -0,0 +1,68 @@
+package textnorm
+
+import "testing"
+
+func TestComposeLatin(t *testing.T) {
+	tests := map[string]string{
+		"Café":                  "Café",
+		"Café":                   "Café",
+		"Schaltpläne":           "Schaltpläne",
+		"Ångström":             "Ångström",
+		"Façade été":          "Façade été",
+		"VAX 8800 System Manual": "VAX 8800 System Manual",
+		"x́":                     "x́", // no precomposed form
+		"́leading accent":        "́leading accent",
+	}
+	for input, expected := range tests {
+		if got := ComposeLatin(input); got != expected {
+			t.Errorf(`ComposeLatin(%q) = %q, expected %q`, input, got, expected)
+		}
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	tests := map[string]string{
+		"Café":                      "Cafe",
+		"Café":                     "Cafe",
+		"x́":                        "x",
+		"Łódź Straße":               "Lodz Strasse",
+		"PDP–11 “Handbook” — 1975…": `PDP-11 "Handbook" - 1975...`,
+		"Œuvre © DEC":               "OEuvre (C) DEC",
+		"日本":                        "??",
+		"bad\xffbyte":               "bad?byte",
+	}
+	for input, expected := range tests {
+		if got := ToASCII(input); got != expected {
+			t.Errorf(`ToASCII(%q) = %q, expected %q`, input, got, expected)
+		}
+	}
+}
+
+func TestUnaccented(t *testing.T) {
+	tests := map[string]string{
+		"Café":              "Cafe",
+		"Cafe\u0301":        "Cafe",
+		"Łódź Straße Æther": "Lodz Strasse AEther",
+		"PDP–11 “Handbook”": "PDP–11 “Handbook”",
+		"日本 résumé":         "日本 resume",
+		"VAX 8800 Handbook": "VAX 8800 Handbook",
+	}
+	for input, expected := range tests {
+		if got := Unaccented(input); got != expected {
+			t.Errorf(`Unaccented(%q) = %q, expected %q`, input, got, expected)
+		}
+	}
+}
+
+func TestValidText(t *testing.T) {
+	tests := map[string]string{
+		`C:\DOS utilities`: `C:\DOS utilities`,
+		"Café":             "Café",
+		"Caf\xe9 \x85":     "Café \uFFFD",
+	}
+	for input, expected := range tests {
+		if got := ValidText(input); got != expected {
+			t.Errorf(`ValidText(%q) = %q, expected %q`, input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package tombstones
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/persistentstore"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// This package records the documents that were removed from a catalog on purpose (e.g. a bad scan superseded by a
+// better one), so that the generators that rebuild a catalog from a tree or a website do not bring them back.
+//
+// The tombstones are kept in a persistent store, keyed by the key the document had in its catalog:
+//
+//	0123456789abcdef0123456789abcdef:
+//	  md5: 0123456789abcdef0123456789abcdef
+//	  filepath: file:///DEC_0001/vax/ka630.pdf
+//	  title: KA630 CPU Module Technical Manual
+//	  reason: superseded by the rescan in DEC_0042
+//	  removed: 2024-05-01
+//
+// A tombstone that records an MD5 checksum buries every document with that checksum, whatever its key, and only those,
+// so a new scan written to the same filepath is not buried. One without a checksum (e.g. for a remote document whose
+// checksum is not known) buries the document with its key.
+
+// The conventional location of the store
+const DefaultFilename = "bin/tombstones.yaml"
+
+type Document = document.Document
+
+// Tombstone records why and when a document was removed
+type Tombstone struct {
+	Md5      string `yaml:",omitempty"` // The MD5 checksum of the removed document's file, if known
+	Filepath string `yaml:",omitempty"` // Where the removed document was, for reference
+	Title    string `yaml:",omitempty"` // The removed document's title, for reference
+	Reason   string // Why the document was removed
+	Removed  string // When the document was removed, as YYYY-MM-DD
+}
+
+// Store maps the key of each removed document to its tombstone
+type Store struct {
+	*persistentstore.Store[string, Tombstone]
+}
+
+// Reads the store. A store that does not exist is empty; it is created when it is first saved.
+func Open(filename string, verbose bool) (*Store, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		store, err := persistentstore.Store[string, Tombstone]{}.Init("", false, verbose)
+		store.Active = true
+		return &Store{store}, err
+	}
+	store, err := persistentstore.Store[string, Tombstone]{}.Init(filename, false, verbose)
+	return &Store{store}, err
+}
+
+// Records that the document with the given key was removed, and why
+func (s *Store) Bury(key string, doc Document, reason string, when time.Time) {
+	s.Update(key, Tombstone{Md5: doc.Md5, Filepath: doc.Filepath, Title: doc.Title, Reason: reason, Removed: when.Format(time.DateOnly)})
+}
+
+// Returns the tombstone that buries a document, if there is one
+func (s *Store) Find(key string, doc Document) (Tombstone, bool) {
+	return s.find(key, doc, s.byMd5())
+}
+
+// Returns the tombstone that buries a document, if there is one, looking up its MD5 checksum in byMd5 (see byMd5)
+func (s *Store) find(key string, doc Document, byMd5 map[string]Tombstone) (Tombstone, bool) {
+	if tombstone, found := s.Lookup(key); found && ((tombstone.Md5 == "") || (tombstone.Md5 == doc.Md5)) {
+		return tombstone, true
+	}
+	if doc.Md5 == "" {
+		return Tombstone{}, false
+	}
+	tombstone, found := byMd5[doc.Md5]
+	return tombstone, found
+}
+
+// Returns the tombstones that record an MD5 checksum, by checksum; where several share one, that with the first key
+func (s *Store) byMd5() map[string]Tombstone {
+	byMd5 := make(map[string]Tombstone)
+	for _, key := range s.Keys() {
+		tombstone := s.Data[key]
+		if _, found := byMd5[tombstone.Md5]; !found && (tombstone.Md5 != "") {
+			byMd5[tombstone.Md5] = tombstone
+		}
+	}
+	return byMd5
+}
+
+// Removes every buried document from documents. Returns the keys of the documents removed, sorted.
+func (s *Store) Exclude(documents map[string]Document) []string {
+	byMd5 := s.byMd5()
+	var removed []string
+	for key, doc := range documents {
+		if _, found := s.find(key, doc, byMd5); found {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		delete(documents, key)
+	}
+	return removed
+}
+
+// Deletes every tombstone for which purge returns true, so that the documents they buried can return.
+// Returns the keys of the tombstones deleted, sorted.
+func (s *Store) Purge(purge func(key string, tombstone Tombstone) bool) []string {
+	var purged []string
+	for key, tombstone := range s.Data {
+		if purge(key, tombstone) {
+			purged = append(purged, key)
+		}
+	}
+	sort.Strings(purged)
+	for _, key := range purged {
+		delete(s.Data, key)
+		s.Dirty = true
+	}
+	return purged
+}
+
+// Returns the keys of the tombstones, sorted
+func (s *Store) Keys() []string {
+	keys := make([]string, 0, len(s.Data))
+	for key := range s.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Removes every document buried by the store in filename (if it exists) from documents, as the generators do before
+// writing a catalog. Returns the keys of the documents removed, sorted.
+func ExcludeFrom(filename string, documents map[string]Document) ([]string, error) {
+	store, err := Open(filename, false)
+	if err != nil {
+		return nil, err
+	}
+	return store.Exclude(documents), nil
+}
+
+// Removes the buried documents with ExcludeFrom and reports those removed (each one if verbose), exiting with a fatal
+// error if the store cannot be read. This is the step every generator takes before writing its catalog.
+func ExcludeForCatalog(filename string, documents map[string]Document, verbose bool) {
+	buried, err := ExcludeFrom(filename, documents)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", filename, err)
+	}
+	if len(buried) == 0 {
+		return
+	}
+	fmt.Printf("Left out %d documents removed on purpose (see %s)\n", len(buried), filename)
+	if verbose {
+		for _, key := range buried {
+			fmt.Printf("  removed: %s\n", key)
+		}
+	}
+}
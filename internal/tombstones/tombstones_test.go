@@ -0,0 +1,48 @@
+package tombstones
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tombstones.yaml")
+	store, err := Open(filename, false)
+	if (err != nil) || (len(store.Data) != 0) {
+		t.Fatalf(`Open() of a missing store = %v, %v`, store.Data, err)
+	}
+	badScan := Document{Md5: "0123456789abcdef0123456789abcdef", Filepath: "file:///DEC_0001/ka630.pdf", Title: "KA630 Technical Manual"}
+	store.Bury("0123456789abcdef0123456789abcdef", badScan, "superseded by the rescan in DEC_0042", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store.Bury("bitsavers@dec/vax/ka630.pdf", Document{Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}, "broken link", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC))
+	store.Save(filename)
+
+	store, err = Open(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Tombstone{Md5: badScan.Md5, Filepath: badScan.Filepath, Title: badScan.Title, Reason: "superseded by the rescan in DEC_0042", Removed: "2024-05-01"}
+	if tombstone, found := store.Lookup(badScan.Md5); !found || (tombstone != expected) {
+		t.Errorf(`Lookup() = %+v, %v`, tombstone, found)
+	}
+
+	documents := map[string]Document{
+		"0123456789abcdef0123456789abcdef": badScan,
+		"EK-KA630-TM.pdf":                  {Md5: badScan.Md5, PartNum: "EK-KA630-TM"},                            // Same file, other key
+		"fedcba9876543210fedcba9876543210": {Md5: "fedcba9876543210fedcba9876543210", Filepath: badScan.Filepath}, // New scan, same filepath
+		"bitsavers@dec/vax/ka630.pdf":      {Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+	}
+	removed := store.Exclude(documents)
+	if !reflect.DeepEqual(removed, []string{"0123456789abcdef0123456789abcdef", "EK-KA630-TM.pdf", "bitsavers@dec/vax/ka630.pdf"}) {
+		t.Errorf(`Exclude() removed %v`, removed)
+	}
+	if _, found := documents["fedcba9876543210fedcba9876543210"]; !found || (len(documents) != 1) {
+		t.Errorf(`Exclude() left %v`, documents)
+	}
+
+	purged := store.Purge(func(key string, tombstone Tombstone) bool { return tombstone.Removed < "2024-01-01" })
+	if !reflect.DeepEqual(purged, []string{"bitsavers@dec/vax/ka630.pdf"}) || !store.IsModified() || !reflect.DeepEqual(store.Keys(), []string{badScan.Md5}) {
+		t.Errorf(`Purge() = %v, leaving %v`, purged, store.Keys())
+	}
+}
@@ -0,0 +1,185 @@
+package treewalk
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// This package walks a directory tree as fs.WalkDir does, but reads several directories at once. On a NAS share or a
+// remote archive root (see internal/archivefs) nearly all the time of a walk goes in waiting for directory listings,
+// one after another, before any file can be hashed; reading the listings ahead of the walk, in parallel, hides most of
+// that wait.
+//
+// The walk itself is unchanged: the function is called on one goroutine, for the same entries, in the same (lexical)
+// order and with the same errors as fs.WalkDir, and fs.SkipDir and fs.SkipAll behave in the same way, so the results
+// do not depend on which directory happened to be read first. Only the reading of the listings is done in parallel,
+// by a bounded number of workers that read the directories nearest to the walk first.
+
+// The number of directories read at once by default; reading a directory mostly waits on the disk or network, so more
+// than one per CPU is worthwhile
+const DefaultWorkers = 8
+
+// A directory listing, read ahead of the walk
+type listing struct {
+	started bool // Set once a worker (or the walk) has started to read the directory
+	entries []fs.DirEntry
+	err     error
+	ready   chan struct{} // Closed once entries and err are set
+}
+
+// A walker reads directory listings ahead of the walk
+type walker struct {
+	fsys     fs.FS
+	mutex    sync.Mutex
+	wake     *sync.Cond
+	listings map[string]*listing // Listings read or being read, by directory, until the walk takes them
+	pending  []string            // Directories to read, the next to be read last
+	stopped  bool
+	workers  sync.WaitGroup
+}
+
+// Walks the tree rooted at root, calling fn for each file or directory in the tree, including root, exactly as
+// fs.WalkDir does (see there), while reading up to workers directories at once. With workers less than 2 it is
+// fs.WalkDir.
+func WalkDir(fsys fs.FS, root string, workers int, fn fs.WalkDirFunc) error {
+	if workers < 2 {
+		return fs.WalkDir(fsys, root, fn)
+	}
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w := &walker{fsys: fsys, listings: make(map[string]*listing)}
+		w.wake = sync.NewCond(&w.mutex)
+		if info.IsDir() {
+			w.readAhead([]string{root})
+			for i := 0; i < workers; i++ {
+				w.workers.Add(1)
+				go w.work()
+			}
+		}
+		err = w.walkDir(root, fs.FileInfoToDirEntry(info), fn)
+		w.stop()
+	}
+	if (err == fs.SkipDir) || (err == fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// Queues directories to be read, the first of them to be read first
+func (w *walker) readAhead(dirs []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		w.listings[dirs[i]] = &listing{ready: make(chan struct{})}
+		w.pending = append(w.pending, dirs[i])
+	}
+	w.wake.Broadcast()
+}
+
+// Reads queued directories until the walk stops. The directories most recently queued are read first, as they are
+// the subdirectories of the directory the walk has most recently reached (or is about to reach).
+func (w *walker) work() {
+	defer w.workers.Done()
+	for {
+		w.mutex.Lock()
+		for (len(w.pending) == 0) && !w.stopped {
+			w.wake.Wait()
+		}
+		if w.stopped {
+			w.mutex.Unlock()
+			return
+		}
+		dir := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		l := w.listings[dir]
+		if (l == nil) || l.started {
+			w.mutex.Unlock()
+			continue
+		}
+		l.started = true
+		w.mutex.Unlock()
+		w.read(dir, l)
+	}
+}
+
+// Reads a directory into its listing, and queues its subdirectories to be read
+func (w *walker) read(dir string, l *listing) {
+	l.entries, l.err = fs.ReadDir(w.fsys, dir)
+	close(l.ready)
+	var subdirs []string
+	for _, entry := range l.entries {
+		if entry.IsDir() {
+			subdirs = append(subdirs, path.Join(dir, entry.Name()))
+		}
+	}
+	w.readAhead(subdirs)
+}
+
+// Stops the workers, once the walk is over, abandoning any directories not yet read
+func (w *walker) stop() {
+	w.mutex.Lock()
+	w.stopped = true
+	w.pending = nil
+	w.wake.Broadcast()
+	w.mutex.Unlock()
+	w.workers.Wait()
+}
+
+// Returns the listing of a directory. If no worker has started to read it yet, the walk reads it itself rather than
+// wait for a worker to reach it; otherwise it waits for the worker to finish.
+func (w *walker) readDir(dir string) ([]fs.DirEntry, error) {
+	w.mutex.Lock()
+	l, found := w.listings[dir]
+	delete(w.listings, dir)
+	if !found {
+		// Only a directory that appeared after its parent was read was not queued
+		l = &listing{ready: make(chan struct{})}
+	}
+	start := !l.started
+	l.started = true
+	w.mutex.Unlock()
+	if start {
+		w.read(dir, l)
+	}
+	<-l.ready
+	return l.entries, l.err
+}
+
+// Walks a directory as fs.WalkDir does, but with listings read ahead
+func (w *walker) walkDir(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); (err != nil) || !d.IsDir() {
+		if (err == fs.SkipDir) && d.IsDir() {
+			// Successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := w.readDir(name)
+	if err != nil {
+		// Second call, to report ReadDir error
+		err = fn(name, d, err)
+		if err != nil {
+			if (err == fs.SkipDir) && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := w.walkDir(path.Join(name, entry.Name()), entry, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package treewalk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// failingFS is a MapFS whose directories named "unreadable" cannot be read
+type failingFS struct {
+	fstest.MapFS
+}
+
+func (f failingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if strings.HasSuffix(name, "unreadable") {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+// Records each call of the walk function, and returns the error chosen for its name
+func recorder(calls *[]string, results map[string]error) fs.WalkDirFunc {
+	return func(name string, d fs.DirEntry, err error) error {
+		*calls = append(*calls, fmt.Sprintf("%s %v", name, err))
+		return results[name]
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	tree := failingFS{fstest.MapFS{
+		"a/1.pdf":            {},
+		"a/b/2.pdf":          {},
+		"a/b/c/3.pdf":        {},
+		"a/skipped/4.pdf":    {},
+		"a/unreadable/5.pdf": {},
+		"d/6.pdf":            {},
+		"d/7.pdf":            {},
+		"d/8.pdf":            {},
+		"e/f/9.pdf":          {},
+		"index.yaml":         {},
+	}}
+	for _, test := range []struct {
+		name    string
+		root    string
+		results map[string]error
+	}{
+		{"everything", ".", nil},
+		{"subtree", "a/b", nil},
+		{"missing root", "nowhere", nil},
+		{"file root", "index.yaml", nil},
+		{"skip a directory", ".", map[string]error{"a/skipped": fs.SkipDir}},
+		{"skip the rest of a directory", ".", map[string]error{"d/7.pdf": fs.SkipDir}},
+		{"skip all", ".", map[string]error{"a/b/c/3.pdf": fs.SkipAll}},
+		{"stop on an error", ".", map[string]error{"d": errors.New("stop")}},
+	} {
+		var expected, calls []string
+		expectedErr := fs.WalkDir(tree, test.root, recorder(&expected, test.results))
+		for _, workers := range []int{1, 2, 8} {
+			calls = nil
+			err := WalkDir(tree, test.root, workers, recorder(&calls, test.results))
+			if !slices.Equal(calls, expected) || (fmt.Sprint(err) != fmt.Sprint(expectedErr)) {
+				t.Errorf("%s with %d workers: WalkDir() = %v, calls\n%s\nexpected %v, calls\n%s", test.name, workers, err, strings.Join(calls, "\n"), expectedErr, strings.Join(expected, "\n"))
+			}
+		}
+	}
+}
+
+// syntheticFS is a tree of dirs directories, each of files files, under fanout top-level directories, that takes
+// latency to list each directory (as a NAS share does)
+type syntheticFS struct {
+	fanout  int
+	dirs    int
+	files   int
+	latency time.Duration
+}
+
+type syntheticInfo struct {
+	name string
+	dir  bool
+}
+
+func (i syntheticInfo) Name() string       { return i.name }
+func (i syntheticInfo) Size() int64        { return 0 }
+func (i syntheticInfo) ModTime() time.Time { return time.Time{} }
+func (i syntheticInfo) IsDir() bool        { return i.dir }
+func (i syntheticInfo) Sys() any           { return nil }
+
+func (i syntheticInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (s syntheticFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+}
+
+func (s syntheticFS) Stat(name string) (fs.FileInfo, error) {
+	return syntheticInfo{name: name, dir: true}, nil
+}
+
+func (s syntheticFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	time.Sleep(s.latency)
+	var entries []fs.DirEntry
+	switch depth := strings.Count(name, "/"); {
+	case name == ".":
+		for i := 0; i < s.fanout; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(syntheticInfo{fmt.Sprintf("top%03d", i), true}))
+		}
+	case depth == 0:
+		for i := 0; i < s.dirs/s.fanout; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(syntheticInfo{fmt.Sprintf("dir%03d", i), true}))
+		}
+	default:
+		for i := 0; i < s.files; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(syntheticInfo{fmt.Sprintf("file%04d.pdf", i), false}))
+		}
+	}
+	return entries, nil
+}
+
+// Walks a tree of 100,000 files in 1,000 directories, with each listing taking the given latency, as fs.WalkDir and
+// as WalkDir with 8 and 32 workers, e.g.
+//
+//	go test -bench WalkDir -benchtime 3x ./internal/treewalk/
+func benchmarkWalkDir(b *testing.B, latency time.Duration) {
+	tree := syntheticFS{fanout: 10, dirs: 1000, files: 100, latency: latency}
+	for _, workers := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				count := 0
+				err := WalkDir(tree, ".", workers, func(name string, d fs.DirEntry, err error) error {
+					if (err == nil) && !d.IsDir() {
+						count += 1
+					}
+					return err
+				})
+				if (err != nil) || (count != 100000) {
+					b.Fatalf("WalkDir() = %v after %d files", err, count)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWalkDirLocal(b *testing.B) { benchmarkWalkDir(b, 0) }
+func BenchmarkWalkDirNAS(b *testing.B)   { benchmarkWalkDir(b, time.Millisecond) }
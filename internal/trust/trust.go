@@ -0,0 +1,146 @@
+package trust
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/volumes"
+	"fmt"
+	"strings"
+)
+
+// This package records how far the metadata (title, part number and publication date) of each source can be relied
+// upon, so that when two copies of a document are combined the better described one wins, and so that unreliable part
+// numbers do not cause false matches.
+//
+// A whole catalog (a collection, such as manx, whose entries were OCRed from old indexes) is given a level where it is
+// named (e.g. "trust: low" in a --config file); a single archive volume is given one in the volume registry:
+//
+//	DEC_0001:
+//	  label: DEC manuals 1
+//	  trust: high
+//
+// The level of a volume takes precedence over that of the catalog it is listed in. Sources given no level are Normal.
+
+type Document = document.Document
+
+// Level is how far a source's metadata can be relied upon
+type Level int
+
+const (
+	Low    Level = -1 // e.g. an OCRed index
+	Normal Level = 0
+	High   Level = 1 // e.g. my own scans, titled by hand
+)
+
+// The Document fields whose values depend on the trust placed in their source
+var Fields = []string{"Title", "PubDate", "PartNum"}
+
+// Parses a level: "low", "normal" or "high" (without regard to case). A blank level is Normal.
+func Parse(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return Low, nil
+	case "", "normal":
+		return Normal, nil
+	case "high":
+		return High, nil
+	}
+	return Normal, fmt.Errorf("unknown trust level %q (expected low, normal or high)", s)
+}
+
+func (l Level) String() string {
+	switch l {
+	case Low:
+		return "low"
+	case High:
+		return "high"
+	}
+	return "normal"
+}
+
+// Levels holds the trust levels of the archive volumes
+type Levels struct {
+	Volumes map[string]Level // Volume ID => level, for volumes whose level is set in the registry
+}
+
+// Returns the levels set in a volume registry. Returns an error if a volume's level is not recognised.
+func FromRegistry(registry volumes.Registry) (Levels, error) {
+	levels := Levels{Volumes: make(map[string]Level)}
+	for _, id := range registry.IDs() {
+		if registry[id].Trust == "" {
+			continue
+		}
+		level, err := Parse(registry[id].Trust)
+		if err != nil {
+			return Levels{}, fmt.Errorf("volume %s: %w", id, err)
+		}
+		levels.Volumes[id] = level
+	}
+	return levels, nil
+}
+
+// Returns the level of a document read from a catalog trusted at catalogLevel: the level of its volume, if the
+// registry sets one, otherwise that of the catalog
+func (l Levels) Of(doc Document, catalogLevel Level) Level {
+	volume := doc.VolumeID
+	if volume == "" {
+		volume = volumes.IDFromFilepath(doc.Filepath)
+	}
+	if level, found := l.Volumes[volume]; found && (volume != "") {
+		return level
+	}
+	return catalogLevel
+}
+
+// Replaces the trust-dependent fields (see Fields) of kept with those of other wherever other is more trusted and has
+// a value, so that a low-trust title never stands in for a high-trust one. A value taken is marked as set by code (see
+// Document.Flags) only if it was in other. Returns the fields replaced.
+func Prefer(kept *Document, keptLevel Level, other Document, otherLevel Level) []string {
+	if otherLevel <= keptLevel {
+		return nil
+	}
+	var replaced []string
+	for _, name := range Fields {
+		keptValue, otherValue := field(kept, name), field(&other, name)
+		if (*otherValue == "") || (*otherValue == *keptValue) {
+			continue
+		}
+		*keptValue = *otherValue
+		flag := document.CodeSetFlags[name]
+		if strings.Contains(other.Flags, flag) {
+			document.SetFlags(kept, flag)
+		} else {
+			document.ClearFlags(kept, flag)
+		}
+		replaced = append(replaced, name)
+	}
+	return replaced
+}
+
+// Returns the named trust-dependent field of a document
+func field(doc *Document, name string) *string {
+	switch name {
+	case "Title":
+		return &doc.Title
+	case "PubDate":
+		return &doc.PubDate
+	}
+	return &doc.PartNum
+}
+
+// Reports whether two titles plausibly name the same document: the same once case, punctuation and spacing are
+// ignored, or one contained in the other (e.g. "KA630 Manual" and "KA630-AA CPU Module Manual" do not agree, but
+// "KA630 CPU Module Technical Manual" and "ka630 cpu module" do). A blank title agrees with nothing.
+func TitlesAgree(a string, b string) bool {
+	a, b = simplify(a), simplify(b)
+	if (a == "") || (b == "") {
+		return false
+	}
+	return strings.Contains(a, b) || strings.Contains(b, a)
+}
+
+// Returns the letters and digits of a title, lower-cased, with single spaces between words
+func simplify(title string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !(((r >= 'a') && (r <= 'z')) || ((r >= '0') && (r <= '9')) || (r > 0x7F))
+	}), " ")
+}
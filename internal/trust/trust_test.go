@@ -0,0 +1,73 @@
+package trust
+
+import (
+	"docs-to-yaml/internal/volumes"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for s, expected := range map[string]Level{"low": Low, " High ": High, "": Normal, "normal": Normal} {
+		if level, err := Parse(s); (err != nil) || (level != expected) {
+			t.Errorf(`Parse(%q) = %v, %v`, s, level, err)
+		}
+	}
+	if _, err := Parse("medium"); err == nil {
+		t.Errorf(`Parse("medium") did not fail`)
+	}
+}
+
+func TestOf(t *testing.T) {
+	levels, err := FromRegistry(volumes.Registry{"DEC_0001": {Trust: "high"}, "DEC_0002": {Label: "unrated"}})
+	if err != nil {
+		t.Fatalf(`FromRegistry() failed: %v`, err)
+	}
+	for _, test := range []struct {
+		doc      Document
+		expected Level
+	}{
+		{Document{Filepath: "file:///DEC_0001/ka630.pdf"}, High},
+		{Document{VolumeID: "DEC_0001", Filepath: "ka630.pdf"}, High},
+		{Document{Filepath: "file:///DEC_0002/ka630.pdf"}, Low},
+		{Document{Filepath: "http://manx-docs.org/ka630.pdf"}, Low},
+	} {
+		if level := levels.Of(test.doc, Low); level != test.expected {
+			t.Errorf(`Of(%+v) = %v, expected %v`, test.doc, level, test.expected)
+		}
+	}
+	if _, err := FromRegistry(volumes.Registry{"DEC_0001": {Trust: "total"}}); err == nil {
+		t.Errorf(`FromRegistry() accepted an unknown level`)
+	}
+}
+
+func TestPrefer(t *testing.T) {
+	kept := Document{Title: "KA63O CPU Modu1e", PartNum: "EK-KA630-TM-001", Flags: "T"}
+	other := Document{Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM-001", PubDate: "1986-03"}
+	if replaced := Prefer(&kept, Normal, other, Low); replaced != nil {
+		t.Errorf(`Prefer() took fields from a less trusted copy: %v`, replaced)
+	}
+	replaced := Prefer(&kept, Low, other, High)
+	if !reflect.DeepEqual(replaced, []string{"Title", "PubDate"}) {
+		t.Errorf(`Prefer() replaced %v`, replaced)
+	}
+	expected := Document{Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM-001", PubDate: "1986-03"}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf(`Prefer() gave %+v`, kept)
+	}
+}
+
+func TestTitlesAgree(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"KA630 CPU Module Technical Manual", "ka630 cpu module", true},
+		{"VAX-11/780  Hardware Handbook", "vax 11 780 hardware handbook", true},
+		{"KA630 Manual", "KA630-AA CPU Module Manual", false},
+		{"", "KA630", false},
+	} {
+		if agree := TitlesAgree(test.a, test.b); agree != test.expected {
+			t.Errorf(`TitlesAgree(%q, %q) = %v`, test.a, test.b, agree)
+		}
+	}
+}
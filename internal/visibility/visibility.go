@@ -0,0 +1,74 @@
+package visibility
+
+import (
+	"docs-to-yaml/internal/document"
+	"slices"
+	"sort"
+)
+
+// This package says who may see a document, as recorded in Document.Visibility, so that internal or confidential
+// material never appears in what is published:
+//
+//	public      anyone; the default for a document without a visibility
+//	restricted  only those trusted with internal material (e.g. an internal wiki)
+//	private     no one beyond the collection itself
+//
+// Every program that writes a publication output (render-catalog, yaml-to-csl) renders only the documents visible to
+// its audience, which is the public unless it is told otherwise, and yaml-to-submission refuses to stage a document
+// that is not public at all. The visibility is given by hand (e.g. edit-catalog --set visibility=private) and is never
+// lost when a catalog is regenerated.
+
+type Document = document.Document
+
+// The visibilities, from the least to the most restricted
+const (
+	Public     = "public"
+	Restricted = "restricted"
+	Private    = "private"
+)
+
+var Levels = []string{Public, Restricted, Private}
+
+// Reports whether a visibility is one of Levels
+func IsLevel(level string) bool {
+	return slices.Contains(Levels, level)
+}
+
+// Returns the visibility of a document: Public if it has none, and Private if it has one that is not known, so that a
+// mistyped visibility withholds a document rather than publishing it
+func Of(doc Document) string {
+	switch {
+	case doc.Visibility == "":
+		return Public
+	case IsLevel(doc.Visibility):
+		return doc.Visibility
+	}
+	return Private
+}
+
+// Reports whether a document may be seen by an audience (one of Levels): a restricted audience sees public and
+// restricted documents, a private one sees everything
+func Allowed(doc Document, audience string) bool {
+	return slices.Index(Levels, Of(doc)) <= slices.Index(Levels, audience)
+}
+
+// Returns the more restricted of two visibilities, so that combining two copies of a document never publishes one that
+// either copy withheld
+func Stricter(first string, second string) string {
+	if slices.Index(Levels, Of(Document{Visibility: second})) > slices.Index(Levels, Of(Document{Visibility: first})) {
+		return second
+	}
+	return first
+}
+
+// Returns the keys of the documents that an audience may not see, sorted
+func Withheld(documents map[string]Document, audience string) []string {
+	var keys []string
+	for key, doc := range documents {
+		if !Allowed(doc, audience) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
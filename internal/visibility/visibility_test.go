@@ -0,0 +1,54 @@
+package visibility
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	for _, test := range []struct {
+		visibility string
+		audience   string
+		expected   bool
+	}{
+		{"", Public, true},
+		{Public, Public, true},
+		{Restricted, Public, false},
+		{Restricted, Restricted, true},
+		{Private, Restricted, false},
+		{Private, Private, true},
+		{"secret", Restricted, false}, // An unknown visibility is taken to be private
+		{"secret", Private, true},
+	} {
+		if allowed := Allowed(Document{Visibility: test.visibility}, test.audience); allowed != test.expected {
+			t.Errorf(`Allowed(%q, %q) = %v`, test.visibility, test.audience, allowed)
+		}
+	}
+}
+
+func TestStricter(t *testing.T) {
+	for _, test := range [][3]string{
+		{"", Restricted, Restricted},
+		{Private, Restricted, Private},
+		{Public, "", Public},
+		{"secret", Restricted, "secret"},
+	} {
+		if stricter := Stricter(test[0], test[1]); stricter != test[2] {
+			t.Errorf(`Stricter(%q, %q) = %q`, test[0], test[1], stricter)
+		}
+	}
+}
+
+func TestWithheld(t *testing.T) {
+	documents := map[string]Document{
+		"a": {Title: "Public"},
+		"b": {Title: "Internal", Visibility: Restricted},
+		"c": {Title: "Confidential", Visibility: Private},
+	}
+	if withheld := Withheld(documents, Public); !reflect.DeepEqual(withheld, []string{"b", "c"}) {
+		t.Errorf(`Withheld(public) = %v`, withheld)
+	}
+	if withheld := Withheld(documents, Private); withheld != nil {
+		t.Errorf(`Withheld(private) = %v`, withheld)
+	}
+}
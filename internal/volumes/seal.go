@@ -0,0 +1,131 @@
+package volumes
+
+import (
+	"crypto/sha256"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/md5sums"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A volume that has passed local-archive-check can be sealed, so that any later change to it is found in seconds
+// rather than by hashing every file again. The seal is a Merkle tree of the volume: each file is represented by its
+// name, size and MD5 checksum (as listed in md5sums; the index files, which md5sums does not cover, are hashed, as are
+// any files it does not list), and each directory by the SHA-256 digest of the digest of its files and the digest of
+// each subdirectory, in order:
+//
+//	files DIGEST           the digest of the lines "f NAME SIZE MD5", one for each file in the directory
+//	d NAME DIGEST          for each subdirectory
+//
+// The digest of the root directory seals the whole volume. Checking a seal needs only the directory listings, the
+// md5sums file and the index files, so a file added, removed, renamed or resized, an index file edited or an md5sums
+// entry changed is found without reading any document. The digest of the files of each directory is kept too, so
+// that the directories that changed can be named. A document altered in place, keeping its size, is only found by
+// checking its MD5 checksum again (local-archive-check --force-md5-sum).
+
+// Seal records the Merkle digest of a volume when it was sealed
+type Seal struct {
+	Digest string            // The digest of the root directory
+	Date   string            // When the volume was sealed, as YYYY-MM-DD
+	Dirs   map[string]string `yaml:",omitempty"` // Directory (relative to the volume root, "." for the root) => digest of its files
+}
+
+// Computes the seal of the volume at the root of fsys. The Date is left for the caller to set.
+func ComputeSeal(fsys fs.FS) (Seal, error) {
+	listed := make(map[string]string)
+	if entries, err := md5sums.ReadFS(fsys, md5sums.Md5sumsFilename); err == nil {
+		listed = md5sums.ToMap(entries)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Seal{}, err
+	}
+	seal := Seal{Dirs: make(map[string]string)}
+	digest, err := sealDir(fsys, ".", listed, seal.Dirs)
+	if err != nil {
+		return Seal{}, err
+	}
+	seal.Digest = digest
+	return seal, nil
+}
+
+// Returns the digest of a directory, recording the digests of its files and those of its subdirectories in dirs
+func sealDir(fsys fs.FS, dir string, listed map[string]string, dirs map[string]string) (string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return "", err
+	}
+	files := sha256.New()
+	var subdirs strings.Builder
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			digest, err := sealDir(fsys, name, listed, dirs)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&subdirs, "d %s %s\n", entry.Name(), digest)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		md5, found := listed[name]
+		if !found || ((dir == ".") && IsIndexFile(entry.Name())) {
+			if md5, err = hashing.Md5FS(fsys, name); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(files, "f %s %d %s\n", entry.Name(), info.Size(), md5)
+	}
+	dirs[dir] = hex.EncodeToString(files.Sum(nil))
+	digest := sha256.Sum256([]byte("files " + dirs[dir] + "\n" + subdirs.String()))
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// Checks a volume against its seal. Returns the directories whose files have changed since it was sealed (and those
+// added or removed), outermost first, or none if the volume is as it was sealed.
+func CheckSeal(fsys fs.FS, seal Seal) ([]string, error) {
+	current, err := ComputeSeal(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if current.Digest == seal.Digest {
+		return nil, nil
+	}
+
+	// The root digest differs, so find the directories whose files differ, or which have come or gone
+	if len(seal.Dirs) == 0 {
+		return []string{"."}, nil
+	}
+	var changed []string
+	for dir, digest := range current.Dirs {
+		if seal.Dirs[dir] != digest {
+			changed = append(changed, dir)
+		}
+	}
+	for dir := range seal.Dirs {
+		if _, found := current.Dirs[dir]; !found {
+			changed = append(changed, dir)
+		}
+	}
+	sort.Slice(changed, func(i int, j int) bool {
+		if depth(changed[i]) != depth(changed[j]) {
+			return depth(changed[i]) < depth(changed[j])
+		}
+		return changed[i] < changed[j]
+	})
+	return changed, nil
+}
+
+// The depth of a directory below the volume root
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
@@ -0,0 +1,59 @@
+package volumes
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSeal(t *testing.T) {
+	volume := fstest.MapFS{
+		"index.csv":      {Data: []byte("Filepath,Md5\n")},
+		"md5sums":        {Data: []byte("0123456789abcdef0123456789abcdef  DEC/a.pdf\nfedcba9876543210fedcba9876543210  DEC/VAX/b.pdf\n")},
+		"DEC/a.pdf":      {Data: []byte("a")},
+		"DEC/VAX/b.pdf":  {Data: []byte("bb")},
+		"HTML/INDEX.HTM": {Data: []byte("<html>")},
+	}
+	seal, err := ComputeSeal(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(seal.Digest) != 64) || (len(seal.Dirs) != 4) {
+		t.Errorf("ComputeSeal() = %+v", seal)
+	}
+	if changed, err := CheckSeal(volume, seal); (err != nil) || (len(changed) != 0) {
+		t.Errorf("CheckSeal() of an unchanged volume = %v, %v", changed, err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		change  func(fstest.MapFS)
+		changed []string
+	}{
+		{"resized", func(v fstest.MapFS) { v["DEC/VAX/b.pdf"] = &fstest.MapFile{Data: []byte("b")} }, []string{"DEC/VAX"}},
+		{"added", func(v fstest.MapFS) { v["DEC/c.pdf"] = &fstest.MapFile{Data: []byte("c")} }, []string{"DEC"}},
+		{"index edited", func(v fstest.MapFS) { v["index.csv"] = &fstest.MapFile{Data: []byte("Filepath,MD5\n")} }, []string{"."}},
+		{"md5sums entry changed", func(v fstest.MapFS) {
+			v["md5sums"] = &fstest.MapFile{Data: []byte("0123456789abcdef0123456789abcdef  DEC/a.pdf\n00000000000000000000000000000000  DEC/VAX/b.pdf\n")}
+		}, []string{".", "DEC/VAX"}},
+		{"renamed", func(v fstest.MapFS) {
+			v["HTML/PAGES.HTM"] = v["HTML/INDEX.HTM"]
+			delete(v, "HTML/INDEX.HTM")
+		}, []string{"HTML"}},
+		{"removed", func(v fstest.MapFS) { delete(v, "DEC/VAX/b.pdf") }, []string{"DEC/VAX"}},
+	} {
+		changedVolume := fstest.MapFS{}
+		for name, file := range volume {
+			changedVolume[name] = file
+		}
+		test.change(changedVolume)
+		if changed, err := CheckSeal(changedVolume, seal); (err != nil) || !reflect.DeepEqual(changed, test.changed) {
+			t.Errorf("CheckSeal() of a volume with a file %s = %v, %v", test.name, changed, err)
+		}
+	}
+
+	// A seal that kept only its root digest can only say that the volume changed
+	if changed, _ := CheckSeal(fstest.MapFS{"index.csv": {}}, Seal{Digest: seal.Digest}); !reflect.DeepEqual(changed, []string{"."}) {
+		t.Errorf("CheckSeal() with only the root digest = %v", changed)
+	}
+}
@@ -0,0 +1,176 @@
+package volumes
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/md5sums"
+	"docs-to-yaml/internal/par2"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// This package maintains the volume registry: a YAML file (conventionally bin/volumes.yaml) describing every archived
+// medium, keyed by volume ID (the name that appears in filepaths such as file:///DEC_0001/...):
+//
+//	DEC_0001:
+//	  label: DEC manuals 1
+//	  medium: CD-R
+//	  burndate: "2004-03-17"
+//	  location: Study, shelf 2
+//	  container: Binder 3
+//	  slot: sleeve 12
+//	  capacity: 700000000
+//	  trust: high
+//	  indexmd5s:
+//	    index.htm: 0123456789abcdef0123456789abcdef
+//	  seal:
+//	    digest: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    date: "2024-05-01"
+//	    dirs: ...
+//
+// The index file checksums are recorded by the tools that read or master a volume (file-tree-to-yaml and
+// local-archive-to-yaml); a change in them means that the volume's index no longer matches the one catalogued. The
+// seal is recorded by local-archive-check --seal (see Seal).
+// The other fields describe the physical medium, so they are supplied by hand (or by flags when mastering) and are
+// never cleared by a tool that does not know them. Documents refer to their volume through Document.VolumeID.
+
+// Volume describes one archived medium
+type Volume struct {
+	Label     string            `yaml:",omitempty"` // The label written on (or burned into) the medium
+	Medium    string            `yaml:",omitempty"` // The kind of medium, e.g. "CD-R", "DVD-R", "BD-R" or "HDD"
+	BurnDate  string            `yaml:",omitempty"` // When the medium was written, as YYYY-MM-DD
+	Location  string            `yaml:",omitempty"` // Where the medium is kept, e.g. "Study, shelf 2"
+	Container string            `yaml:",omitempty"` // The binder, box or case holding the medium, e.g. "Binder 3"
+	Slot      string            `yaml:",omitempty"` // The medium's place within its container, e.g. "sleeve 12"
+	Capacity  int64             `yaml:",omitempty"` // The capacity of the medium in bytes
+	Trust     string            `yaml:",omitempty"` // How far the metadata of the volume's documents can be relied upon: "low", "normal" or "high" (see internal/trust)
+	IndexMd5s map[string]string `yaml:",omitempty"` // Index file (relative to the volume root) => MD5 checksum
+	Seal      *Seal             `yaml:",omitempty"` // The seal recorded once the volume passed local-archive-check (see Seal)
+}
+
+// Registry maps a volume ID to its Volume
+type Registry map[string]Volume
+
+// Reads a registry, returning an empty registry if the file does not exist.
+func Load(filename string) (Registry, error) {
+	registry := make(Registry)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return registry, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := document.UnmarshalYaml(data, &registry); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	return registry, nil
+}
+
+// Writes the registry, atomically.
+func (r Registry) Save(filename string) error {
+	data, err := document.MarshalYaml(r)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filename, data, 0644)
+}
+
+// Records what is known about a volume. Fields of update that are blank leave the registered values unchanged, so a
+// tool that only knows the index checksums does not clear the description of the medium. Returns true if the
+// registry changed.
+func (r Registry) Update(id string, update Volume) bool {
+	volume := r[id]
+	changed := false
+	set := func(field *string, value string) {
+		if (value != "") && (*field != value) {
+			*field, changed = value, true
+		}
+	}
+	set(&volume.Label, update.Label)
+	set(&volume.Medium, update.Medium)
+	set(&volume.BurnDate, update.BurnDate)
+	set(&volume.Location, update.Location)
+	set(&volume.Container, update.Container)
+	set(&volume.Slot, update.Slot)
+	set(&volume.Trust, update.Trust)
+	if (update.Capacity != 0) && (volume.Capacity != update.Capacity) {
+		volume.Capacity, changed = update.Capacity, true
+	}
+	if (len(update.IndexMd5s) > 0) && !maps.Equal(volume.IndexMd5s, update.IndexMd5s) {
+		volume.IndexMd5s, changed = update.IndexMd5s, true
+	}
+	if (update.Seal != nil) && !reflect.DeepEqual(volume.Seal, update.Seal) {
+		volume.Seal, changed = update.Seal, true
+	}
+	if _, found := r[id]; !found {
+		changed = true
+	}
+	r[id] = volume
+	return changed
+}
+
+// Returns where the medium is physically kept, e.g. "Study, shelf 2 / Binder 3 / sleeve 12", or "" if not recorded
+func (v Volume) PhysicalLocation() string {
+	var parts []string
+	for _, part := range []string{v.Location, v.Container, v.Slot} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// Returns the IDs of the registered volumes, sorted
+func (r Registry) IDs() []string {
+	ids := make([]string, 0, len(r))
+	for id := range r {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Returns the volume ID of a catalog filepath of the form file:///VOLUME/path, or "" for any other filepath.
+func IDFromFilepath(filepath string) string {
+	rest, found := strings.CutPrefix(filepath, "file:///")
+	if !found {
+		return ""
+	}
+	id, _, found := strings.Cut(rest, "/")
+	if !found {
+		return ""
+	}
+	return id
+}
+
+// Reports whether a file at the root of a volume is one of its index files: index.* (index.htm, index.csv,
+// index.yaml, ...), the md5sums file or the recovery manifest.
+func IsIndexFile(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "index.") || (name == md5sums.Md5sumsFilename) || (name == par2.ManifestFilename)
+}
+
+// Returns the MD5 checksum of each index file (see IsIndexFile) at the root of fsys.
+func IndexChecksums(fsys fs.FS) (map[string]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsIndexFile(entry.Name()) {
+			continue
+		}
+		md5, err := hashing.Md5FS(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		checksums[entry.Name()] = md5
+	}
+	return checksums, nil
+}
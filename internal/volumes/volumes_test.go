@@ -0,0 +1,90 @@
+package volumes
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegistry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "volumes.yaml")
+	registry, err := Load(filename)
+	if (err != nil) || (len(registry) != 0) {
+		t.Fatalf(`Load() of a missing registry = %v, %v`, registry, err)
+	}
+
+	// Mastering describes the medium; a later scan only knows the index checksums
+	if !registry.Update("DEC_0001", Volume{Label: "DEC manuals 1", Medium: "CD-R", BurnDate: "2004-03-17", Capacity: 700000000}) {
+		t.Errorf(`Update() of a new volume reported no change`)
+	}
+	if !registry.Update("DEC_0001", Volume{IndexMd5s: map[string]string{"index.htm": "abc"}}) {
+		t.Errorf(`Update() of the index checksums reported no change`)
+	}
+	if registry.Update("DEC_0001", Volume{Medium: "CD-R", IndexMd5s: map[string]string{"index.htm": "abc"}}) {
+		t.Errorf(`Update() with nothing new reported a change`)
+	}
+	expected := Volume{Label: "DEC manuals 1", Medium: "CD-R", BurnDate: "2004-03-17", Capacity: 700000000, IndexMd5s: map[string]string{"index.htm": "abc"}}
+	if !reflect.DeepEqual(registry["DEC_0001"], expected) {
+		t.Errorf(`Update() gave %+v`, registry["DEC_0001"])
+	}
+
+	if err := registry.Save(filename); err != nil {
+		t.Fatalf(`Save() failed: %v`, err)
+	}
+	reloaded, err := Load(filename)
+	if (err != nil) || !reflect.DeepEqual(reloaded, registry) || !reflect.DeepEqual(reloaded.IDs(), []string{"DEC_0001"}) {
+		t.Errorf(`Load() after Save() = %+v, %v`, reloaded, err)
+	}
+}
+
+func TestIDFromFilepath(t *testing.T) {
+	tests := map[string]string{"file:///DEC_0001/vax/ka630.pdf": "DEC_0001", "file:///DEC_0001": "", "vax/ka630.pdf": "", "http://bitsavers.org/pdf/a.pdf": ""}
+	for filepath, expected := range tests {
+		if got := IDFromFilepath(filepath); got != expected {
+			t.Errorf(`IDFromFilepath(%q) = %q, expected %q`, filepath, got, expected)
+		}
+	}
+}
+
+func TestPhysicalLocation(t *testing.T) {
+	tests := []struct {
+		volume   Volume
+		expected string
+	}{
+		{Volume{Location: "Study, shelf 2", Container: "Binder 3", Slot: "sleeve 12"}, "Study, shelf 2 / Binder 3 / sleeve 12"},
+		{Volume{Container: "Binder 3"}, "Binder 3"},
+		{Volume{Medium: "CD-R"}, ""},
+	}
+	for _, test := range tests {
+		if got := test.volume.PhysicalLocation(); got != test.expected {
+			t.Errorf(`PhysicalLocation(%+v) = %q, expected %q`, test.volume, got, test.expected)
+		}
+	}
+}
+
+func TestIndexChecksums(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.htm":      {Data: []byte("<HTML>")},
+		"INDEX.CSV":      {Data: []byte("")},
+		"md5sums":        {Data: []byte("x")},
+		"vax/index.htm":  {Data: []byte("not at the root")},
+		"vax/ka630.pdf":  {Data: []byte("%PDF")},
+		"readme.txt":     {Data: []byte("hello")},
+		"recovery.yaml":  {Data: []byte("tool: par2")},
+		"recovery.par2":  {Data: []byte("PAR2")},
+		"index.htm.orig": {Data: []byte("<HTML>")},
+	}
+	checksums, err := IndexChecksums(fsys)
+	if err != nil {
+		t.Fatalf(`IndexChecksums() failed: %v`, err)
+	}
+	for _, name := range []string{"index.htm", "INDEX.CSV", "md5sums", "recovery.yaml", "index.htm.orig"} {
+		if _, found := checksums[name]; !found {
+			t.Errorf(`IndexChecksums() lacks %s: %v`, name, checksums)
+		}
+	}
+	if (len(checksums) != 5) || (checksums["INDEX.CSV"] != "d41d8cd98f00b204e9800998ecf8427e") || (checksums["md5sums"] != "9dd4e461268c8034f5c8564e155c67a6") {
+		t.Errorf(`IndexChecksums() = %v`, checksums)
+	}
+}
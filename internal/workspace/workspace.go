@@ -0,0 +1,443 @@
+package workspace
+
+import (
+	"docs-to-yaml/internal/fsutil"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This package manages a workspace: a directory (by default bin/workspace, or that named by the
+// DOCS_TO_YAML_WORKSPACE environment variable) where the tools keep the artifacts they write (catalogs, stores and
+// reports) under fixed names, rather than wherever each output flag points.
+//
+// Wherever a tool takes the filepath of a catalog, store or report, a workspace name may be given instead:
+//
+//	ws:catalogs/local            the catalog named "local" (a .yaml file)
+//	ws:reports/unique.txt        the report named "unique", a .txt file
+//	ws:stores/md5.store          the store named "md5"
+//
+// Each time an artifact is written it is kept as a new file named after the time, within a directory for the name,
+// and a "latest" symbolic link is pointed at it once it is complete. Reading an artifact reads the latest:
+//
+//	bin/workspace/catalogs/local/local-20261016T120000Z.yaml
+//	bin/workspace/catalogs/local/local-20261016T141502Z.yaml
+//	bin/workspace/catalogs/local/latest.yaml -> local-20261016T141502Z.yaml
+//
+// Several tools may write to the workspace at once. Each new file is reserved by creating it exclusively (a second
+// artifact written in the same second gets a suffix, e.g. local-20261016T141502Z-2.yaml), the link is replaced
+// atomically under a lock, and it is only moved forwards, so "latest" always names the newest complete artifact.
+// Clean removes only artifacts older than the latest, so never one still being written.
+
+// The prefix of a workspace name
+const Prefix = "ws:"
+
+// The environment variable naming the workspace root
+const RootVariable = "DOCS_TO_YAML_WORKSPACE"
+
+// The workspace root if RootVariable is not set
+const DefaultRoot = "bin/workspace"
+
+// The kinds of artifact, each a directory of the workspace
+var Kinds = []string{"catalogs", "stores", "reports"}
+
+// The extension given to an artifact whose name has none, by kind
+var defaultExtensions = map[string]string{"catalogs": ".yaml"}
+
+// The base name (before the extension) of the link to the latest artifact
+const latestName = "latest"
+
+// The layout of the time in an artifact's filename
+const timeLayout = "20060102T150405Z"
+
+// Returns the workspace root
+func Root() string {
+	if root := os.Getenv(RootVariable); root != "" {
+		return root
+	}
+	return DefaultRoot
+}
+
+// Reports whether a filepath is a workspace name
+func IsName(filename string) bool {
+	return strings.HasPrefix(filename, Prefix)
+}
+
+// Ref is a parsed workspace name
+type Ref struct {
+	Kind string // One of Kinds
+	Name string // The name, without any extension
+	Ext  string // The extension of the artifact files, e.g. ".yaml"
+}
+
+// Parses a workspace name such as "ws:catalogs/local"
+func Parse(filename string) (Ref, error) {
+	rest, found := strings.CutPrefix(filename, Prefix)
+	kind, name, slash := strings.Cut(rest, "/")
+	if !found || !slash || (name == "") {
+		return Ref{}, fmt.Errorf("expected %sKIND/NAME, found %q", Prefix, filename)
+	}
+	if !slices.Contains(Kinds, kind) {
+		return Ref{}, fmt.Errorf("bad workspace name %q: the kind must be one of %s", filename, strings.Join(Kinds, ", "))
+	}
+	ext := path.Ext(name)
+	ref := Ref{Kind: kind, Name: strings.TrimSuffix(name, ext), Ext: ext}
+	if ref.Ext == "" {
+		ref.Ext = defaultExtensions[kind]
+	}
+	if (ref.Name == "") || strings.ContainsAny(ref.Name, `/\`) || strings.HasPrefix(ref.Name, ".") || (ref.Name == latestName) {
+		return Ref{}, fmt.Errorf("bad workspace name %q", filename)
+	}
+	return ref, nil
+}
+
+// Returns the workspace name of the ref
+func (ref Ref) String() string {
+	return Prefix + ref.Kind + "/" + ref.Name + ref.Ext
+}
+
+// Returns the directory holding the ref's artifacts
+func (ref Ref) Dir(root string) string {
+	return filepath.Join(root, ref.Kind, ref.Name)
+}
+
+// Returns the filepath of the link to the ref's latest artifact
+func (ref Ref) LatestLink(root string) string {
+	return filepath.Join(ref.Dir(root), latestName+ref.Ext)
+}
+
+// Returns the regular expression matching the filenames of the ref's artifacts (with any extension), capturing the
+// time, any suffix and the extension
+func (ref Ref) artifactRegex() *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(ref.Name) + `-(\d{8}T\d{6}Z)(?:-(\d+))?(\.[^.]*)?$`)
+}
+
+// Returns the filepath to read for a filepath that may be a workspace name: the latest artifact of a workspace name,
+// and any other filepath unchanged. If the name has no artifact yet the error satisfies os.IsNotExist.
+func Resolve(filename string) (string, error) {
+	if !IsName(filename) {
+		return filename, nil
+	}
+	ref, err := Parse(filename)
+	if err != nil {
+		return "", err
+	}
+	link := ref.LatestLink(Root())
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+	return filepath.Join(ref.Dir(Root()), target), nil
+}
+
+// Returns the filepath of a working file kept beside an output (e.g. the checkpoint of a catalog being written): the
+// filepath with suffix added, or for a workspace name a file in the name's directory (which is created)
+func Companion(filename string, suffix string) (string, error) {
+	if !IsName(filename) {
+		return filename + suffix, nil
+	}
+	ref, err := Parse(filename)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(ref.Dir(Root()), 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(ref.Dir(Root()), ref.Name+ref.Ext+suffix), nil
+}
+
+// Artifact is a file being written, which for a workspace name is a new file of the workspace
+type Artifact struct {
+	Path string // The filepath to write
+	ref  *Ref   // The workspace name, if it is one
+	root string
+}
+
+// Returns the artifact to write for a filepath that may be a workspace name. For a workspace name a new file is
+// reserved, which becomes the latest once Commit is called; any other filepath is written as it is.
+func Create(filename string) (*Artifact, error) {
+	if !IsName(filename) {
+		return &Artifact{Path: filename}, nil
+	}
+	ref, err := Parse(filename)
+	if err != nil {
+		return nil, err
+	}
+	root := Root()
+	if err := os.MkdirAll(ref.Dir(root), 0755); err != nil {
+		return nil, err
+	}
+	stamp := time.Now().UTC().Format(timeLayout)
+	for n := 1; ; n++ {
+		name := ref.Name + "-" + stamp + ref.Ext
+		if n > 1 {
+			name = ref.Name + "-" + stamp + "-" + strconv.Itoa(n) + ref.Ext
+		}
+		reserved := filepath.Join(ref.Dir(root), name)
+		file, err := os.OpenFile(reserved, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		file.Close()
+		return &Artifact{Path: reserved, ref: &ref, root: root}, nil
+	}
+}
+
+// Makes the artifact the latest of its workspace name, unless a newer one already is. Does nothing for a filepath that
+// is not a workspace name.
+func (a *Artifact) Commit() error {
+	if a.ref == nil {
+		return nil
+	}
+	dir := a.ref.Dir(a.root)
+	unlock, err := lock(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	name := filepath.Base(a.Path)
+	link := a.ref.LatestLink(a.root)
+	if current, err := os.Readlink(link); (err == nil) && (a.ref.compare(current, name) > 0) {
+		return nil
+	}
+	temp := filepath.Join(dir, fmt.Sprintf(".%s%s.%d", latestName, a.ref.Ext, os.Getpid()))
+	os.Remove(temp)
+	if err := os.Symlink(name, temp); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, link); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// Removes an artifact that was reserved but not written (e.g. after an error). Does nothing for a filepath that is not
+// a workspace name.
+func (a *Artifact) Abandon() {
+	if a.ref != nil {
+		os.Remove(a.Path)
+	}
+}
+
+// Writes a file (see fsutil.WriteFileAtomic) whose filepath may be a workspace name. Returns the filepath written.
+func WriteFile(filename string, data []byte, perm os.FileMode) (string, error) {
+	artifact, err := Create(filename)
+	if err != nil {
+		return "", err
+	}
+	if err := fsutil.WriteFileAtomic(artifact.Path, data, perm); err != nil {
+		artifact.Abandon()
+		return "", err
+	}
+	return artifact.Path, artifact.Commit()
+}
+
+// Compares two artifact filenames of the ref by time (and suffix): negative if a is older, positive if newer. A name
+// that is not an artifact of the ref is older than any that is.
+func (ref Ref) compare(a string, b string) int {
+	ta, na, oka := ref.stamp(a)
+	tb, nb, okb := ref.stamp(b)
+	switch {
+	case oka != okb:
+		if oka {
+			return 1
+		}
+		return -1
+	case ta != tb:
+		return strings.Compare(ta, tb)
+	}
+	return na - nb
+}
+
+// Returns the time and suffix (1 if there is none) of an artifact filename of the ref, and whether it is one
+func (ref Ref) stamp(name string) (string, int, bool) {
+	match := ref.artifactRegex().FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+	n := 1
+	if match[2] != "" {
+		n, _ = strconv.Atoi(match[2])
+	}
+	return match[1], n, true
+}
+
+// How long a lock is waited for, and the age at which a lock is taken to have been left by a process that died
+const (
+	lockWait  = 10 * time.Second
+	lockStale = time.Minute
+)
+
+// Takes the lock on an artifact directory, returning the function that releases it
+func lock(dir string) (func(), error) {
+	filename := filepath.Join(dir, ".lock")
+	deadline := time.Now().Add(lockWait)
+	for {
+		file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(filename) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(filename); (err == nil) && (time.Since(info.ModTime()) > lockStale) {
+			os.Remove(filename)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot lock %s: held by another process", dir)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// File is one artifact in the workspace
+type File struct {
+	Path string
+	Time time.Time // When it was written, from its filename
+	Size int64
+}
+
+// Entry is a workspace name and its artifacts
+type Entry struct {
+	Ref       Ref
+	Artifacts []File // Oldest first
+	Latest    string // The filepath of the latest artifact; blank if there is none yet
+}
+
+// Lists every workspace name under root that has artifacts, by kind and name
+func List(root string) ([]Entry, error) {
+	var entries []Entry
+	for _, kind := range Kinds {
+		names, err := os.ReadDir(filepath.Join(root, kind))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !name.IsDir() || strings.HasPrefix(name.Name(), ".") {
+				continue
+			}
+			entry, err := listName(root, kind, name.Name())
+			if err != nil {
+				return nil, err
+			}
+			if len(entry.Artifacts) > 0 {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Lists the artifacts of one name, which may have been written with several extensions
+func listName(root string, kind string, name string) (Entry, error) {
+	entry := Entry{Ref: Ref{Kind: kind, Name: name}}
+	dir := filepath.Join(root, kind, name)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return entry, err
+	}
+	for _, file := range files {
+		base := file.Name()
+		if rest, found := strings.CutPrefix(base, latestName); found && (file.Type()&fs.ModeSymlink != 0) {
+			entry.Ref.Ext = rest
+			if target, err := os.Readlink(filepath.Join(dir, base)); err == nil {
+				entry.Latest = filepath.Join(dir, target)
+			}
+		}
+	}
+	regex := entry.Ref.artifactRegex()
+	for _, file := range files {
+		match := regex.FindStringSubmatch(file.Name())
+		if (match == nil) || !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return entry, err
+		}
+		written, _ := time.Parse(timeLayout, match[1])
+		entry.Artifacts = append(entry.Artifacts, File{Path: filepath.Join(dir, file.Name()), Time: written, Size: info.Size()})
+		if entry.Ref.Ext == "" {
+			entry.Ref.Ext = match[3]
+		}
+	}
+	sort.SliceStable(entry.Artifacts, func(i, j int) bool {
+		return entry.Ref.compare(filepath.Base(entry.Artifacts[i].Path), filepath.Base(entry.Artifacts[j].Path)) < 0
+	})
+	return entry, nil
+}
+
+// Removes old artifacts from the workspace: of each name, those older than the latest, beyond the newest keep of them,
+// and written more than olderThan before now. An artifact newer than the latest (perhaps still being written) is never
+// removed, nor is any of a name with no latest. Returns the filepaths removed (or, if dryRun is set, that would be).
+func Clean(root string, keep int, olderThan time.Duration, now time.Time, dryRun bool) ([]string, error) {
+	entries, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, entry := range entries {
+		if entry.Latest == "" {
+			continue
+		}
+		unlock, err := lock(entry.Ref.Dir(root))
+		if err != nil {
+			return removed, err
+		}
+		latest := filepath.Base(entry.Latest)
+		var older []File
+		for _, artifact := range entry.Artifacts {
+			if entry.Ref.compare(filepath.Base(artifact.Path), latest) < 0 {
+				older = append(older, artifact)
+			}
+		}
+		// The latest counts towards those kept
+		for _, artifact := range older[:max(0, len(older)-max(0, keep-1))] {
+			if now.Sub(artifact.Time) < olderThan {
+				continue
+			}
+			if !dryRun {
+				if err := removeArtifact(artifact.Path); err != nil {
+					unlock()
+					return removed, err
+				}
+			}
+			removed = append(removed, artifact.Path)
+		}
+		unlock()
+	}
+	return removed, nil
+}
+
+// Removes an artifact and any companion files written beside it (e.g. a store's timestamps)
+func removeArtifact(filename string) error {
+	dir, base := filepath.Split(filename)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), base+".") {
+			os.Remove(filepath.Join(dir, file.Name()))
+		}
+	}
+	return os.Remove(filename)
+}
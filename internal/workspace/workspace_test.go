@@ -0,0 +1,176 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		expected Ref
+		ok       bool
+	}{
+		{"ws:catalogs/local", Ref{"catalogs", "local", ".yaml"}, true},
+		{"ws:catalogs/local.jsonl", Ref{"catalogs", "local", ".jsonl"}, true},
+		{"ws:reports/unique.txt", Ref{"reports", "unique", ".txt"}, true},
+		{"ws:stores/md5", Ref{"stores", "md5", ""}, true},
+		{"ws:catalogs", Ref{}, false},
+		{"ws:pictures/local", Ref{}, false},
+		{"ws:catalogs/../local", Ref{}, false},
+		{"ws:catalogs/latest", Ref{}, false},
+		{"bin/local.yaml", Ref{}, false},
+	} {
+		ref, err := Parse(test.name)
+		if (err == nil) != test.ok || (ref != test.expected) {
+			t.Errorf("Parse(%q) = %+v, %v", test.name, ref, err)
+		}
+	}
+}
+
+// Writes an artifact with the given content, failing the test if it cannot
+func write(t *testing.T, name string, content string) string {
+	t.Helper()
+	written, err := WriteFile(name, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", name, err)
+	}
+	return written
+}
+
+// Reads the latest artifact of a name, failing the test if it cannot
+func read(t *testing.T, name string) string {
+	t.Helper()
+	filename, err := Resolve(name)
+	if err != nil {
+		t.Fatalf("Resolve(%q) failed: %v", name, err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteAndResolve(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(RootVariable, root)
+
+	if _, err := Resolve("ws:catalogs/local"); !os.IsNotExist(err) {
+		t.Errorf("Resolve() of a name never written = %v", err)
+	}
+	if filename, err := Resolve("bin/local.yaml"); (filename != "bin/local.yaml") || (err != nil) {
+		t.Errorf("Resolve() of a filepath = %q, %v", filename, err)
+	}
+
+	first := write(t, "ws:catalogs/local", "first")
+	second := write(t, "ws:catalogs/local", "second")
+	if (first == second) || !strings.HasPrefix(filepath.Base(first), "local-") || (filepath.Dir(first) != filepath.Join(root, "catalogs", "local")) {
+		t.Errorf("WriteFile() wrote %s then %s", first, second)
+	}
+	if content := read(t, "ws:catalogs/local"); content != "second" {
+		t.Errorf("Resolve() read %q, expected the latest", content)
+	}
+
+	// The latest is never moved back to an older artifact
+	older := filepath.Join(root, "catalogs", "local", "local-19990101T000000Z.yaml")
+	if err := os.WriteFile(older, []byte("older"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := Parse("ws:catalogs/local")
+	if err := (&Artifact{Path: older, ref: &ref, root: root}).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if content := read(t, "ws:catalogs/local"); content != "second" {
+		t.Errorf("Commit() of an older artifact made it the latest")
+	}
+
+	if companion, err := Companion("ws:catalogs/local", ".partial"); (err != nil) || (companion != filepath.Join(root, "catalogs", "local", "local.yaml.partial")) {
+		t.Errorf("Companion() = %q, %v", companion, err)
+	}
+	if companion, _ := Companion("bin/local.yaml", ".partial"); companion != "bin/local.yaml.partial" {
+		t.Errorf("Companion() of a filepath = %q", companion)
+	}
+
+	// A filepath that is not a workspace name is written as it is
+	plain := filepath.Join(root, "plain.txt")
+	if written := write(t, plain, "plain"); written != plain {
+		t.Errorf("WriteFile() of a filepath wrote %s", written)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	t.Setenv(RootVariable, t.TempDir())
+	const writers = 8
+	var wg sync.WaitGroup
+	written := make([]string, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			written[i], _ = WriteFile("ws:reports/unique.txt", []byte("report"), 0644)
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := List(Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(entries) != 1) || (len(entries[0].Artifacts) != writers) {
+		t.Fatalf("List() after %d concurrent writes = %+v", writers, entries)
+	}
+	// The latest is the newest of them
+	if newest := entries[0].Artifacts[writers-1].Path; entries[0].Latest != newest {
+		t.Errorf("latest is %s, expected %s", entries[0].Latest, newest)
+	}
+	if read(t, "ws:reports/unique.txt") != "report" {
+		t.Errorf("the latest artifact is incomplete")
+	}
+}
+
+func TestClean(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(RootVariable, root)
+	dir := filepath.Join(root, "stores", "md5")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"md5-20260101T000000Z.store", "md5-20260201T000000Z.store", "md5-20260201T000000Z.store.timestamps", "md5-20260301T000000Z.store", "md5-20260401T000000Z.store", "md5-20260501T000000Z.store"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The newest is being written, so the latest is the one before
+	if err := os.Symlink("md5-20260401T000000Z.store", filepath.Join(dir, "latest.store")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	// Nothing is old enough
+	if removed, err := Clean(root, 1, 365*24*time.Hour, now, false); (len(removed) != 0) || (err != nil) {
+		t.Errorf("Clean() of nothing old enough = %v, %v", removed, err)
+	}
+	if removed, _ := Clean(root, 2, 0, now, true); len(removed) != 2 {
+		t.Errorf("Clean() --dry-run would remove %v", removed)
+	}
+	removed, err := Clean(root, 2, 0, now, false)
+	if (len(removed) != 2) || (err != nil) {
+		t.Fatalf("Clean() = %v, %v", removed, err)
+	}
+	entries, _ := List(root)
+	var left []string
+	for _, artifact := range entries[0].Artifacts {
+		left = append(left, filepath.Base(artifact.Path))
+	}
+	if strings.Join(left, " ") != "md5-20260301T000000Z.store md5-20260401T000000Z.store md5-20260501T000000Z.store" {
+		t.Errorf("Clean() left %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "md5-20260201T000000Z.store.timestamps")); !os.IsNotExist(err) {
+		t.Errorf("Clean() left the timestamps of a removed store")
+	}
+}
@@ -0,0 +1,320 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This package reads the cells of an Excel workbook (.xlsx), as saved by Excel or LibreOffice, so that a spreadsheet
+// exported as CSV and edited in either can be read back without first being saved as CSV again.
+//
+// Only the text of the cells of the first worksheet is read: shared strings, inline strings and numbers (as written in
+// the file, so a number is read in its stored form rather than as displayed). Formatting, formulas and the other
+// worksheets are ignored. Excel stores a date as a number (of days since 1900, or 1904) in a cell formatted as a date,
+// which is how it keeps anything it takes for a date (e.g. a PubDate such as 1987-03); such a cell is read back as
+// YYYY-MM-DD, or as YYYY-MM or YYYY if its format shows no day or no month. A time of day is dropped.
+//
+// Rows are numbered as in the worksheet: a row that Excel leaves out because it is empty is returned as an empty row,
+// so the position of a row in the result is always its row number less one.
+
+// The parts of a workbook read by this package
+type workbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"` // Serial day numbers count from 1904 rather than 1900
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Id string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type relationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type sharedStrings struct {
+	Items []richText `xml:"si"`
+}
+
+// richText is the text of a shared or inline string: plain, or split into runs
+type richText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r richText) String() string {
+	var b strings.Builder
+	b.WriteString(r.T)
+	for _, run := range r.Runs {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+// styleSheet holds the number formats of the cell styles, by which dates are told from other numbers
+type styleSheet struct {
+	NumberFormats []struct {
+		Id   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellFormats []struct {
+		NumberFormat int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type worksheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"` // 1-based; 0 if not given, when the row follows the one before
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Style  int      `xml:"s,attr"`
+			Value  string   `xml:"v"`
+			Inline richText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Returns the rows of the first worksheet of the named workbook, each as the text of its cells.
+// Empty cells before the last cell of a row are returned as "".
+func ReadFile(filename string) ([][]string, error) {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	rows, err := Read(&reader.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rows, nil
+}
+
+// Returns the rows of the first worksheet of a workbook (see ReadFile)
+func Read(reader *zip.Reader) ([][]string, error) {
+	var book workbook
+	if err := readPart(reader, "xl/workbook.xml", &book); err != nil {
+		return nil, err
+	}
+	if len(book.Sheets) == 0 {
+		return nil, fmt.Errorf("the workbook has no worksheets")
+	}
+	var rels relationships
+	if err := readPart(reader, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetPart := ""
+	for _, rel := range rels.Relationships {
+		if rel.Id == book.Sheets[0].Id {
+			// The target is relative to xl/, unless it is absolute within the package
+			sheetPart = path.Join("xl", rel.Target)
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetPart = strings.TrimPrefix(rel.Target, "/")
+			}
+		}
+	}
+	if sheetPart == "" {
+		return nil, fmt.Errorf("cannot find the first worksheet (%s)", book.Sheets[0].Id)
+	}
+
+	// A workbook holding only numbers or inline strings has no shared strings
+	var shared sharedStrings
+	if err := readPart(reader, "xl/sharedStrings.xml", &shared); (err != nil) && !errors.Is(err, errNoPart) {
+		return nil, err
+	}
+	// As is a workbook with no formatting
+	var styles styleSheet
+	if err := readPart(reader, "xl/styles.xml", &styles); (err != nil) && !errors.Is(err, errNoPart) {
+		return nil, err
+	}
+	dateLayouts := styles.dateLayouts()
+	var sheet worksheet
+	if err := readPart(reader, sheetPart, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		if row.Number > 0 {
+			if row.Number <= len(rows) {
+				return nil, fmt.Errorf("row %d is out of order", row.Number)
+			}
+			for len(rows) < row.Number-1 {
+				rows = append(rows, nil)
+			}
+		}
+		var cells []string
+		for _, cell := range row.Cells {
+			column := len(cells)
+			if cell.Ref != "" {
+				var err error
+				if column, err = columnIndex(cell.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for len(cells) <= column {
+				cells = append(cells, "")
+			}
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if (err != nil) || (index < 0) || (index >= len(shared.Items)) {
+					return nil, fmt.Errorf("cell %s refers to a missing shared string %q", cell.Ref, cell.Value)
+				}
+				cells[column] = shared.Items[index].String()
+			case "inlineStr":
+				cells[column] = cell.Inline.String()
+			case "", "n":
+				cells[column] = cell.Value
+				if (cell.Style >= 0) && (cell.Style < len(dateLayouts)) && (dateLayouts[cell.Style] != "") {
+					if date, ok := serialDate(cell.Value, book.Properties.Date1904, dateLayouts[cell.Style]); ok {
+						cells[column] = date
+					}
+				}
+			default:
+				cells[column] = cell.Value
+			}
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+// The error returned (wrapped) for a missing part
+var errNoPart = errors.New("not a workbook")
+
+// Unmarshals the named part of the workbook's package
+func readPart(reader *zip.Reader, name string, out interface{}) error {
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+		part, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer part.Close()
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		if err := xml.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no %s", errNoPart, name)
+}
+
+// The built-in number formats that show a date (those not listed show no date, or only a time of day)
+var builtinDateLayouts = map[int]string{
+	14: "2006-01-02", 15: "2006-01-02", 16: "2006-01-02", 17: "2006-01", 22: "2006-01-02",
+	27: "2006-01-02", 28: "2006-01-02", 29: "2006-01-02", 30: "2006-01-02", 31: "2006-01-02", 32: "2006-01-02",
+	33: "2006-01-02", 34: "2006-01-02", 35: "2006-01-02", 36: "2006-01-02", 50: "2006-01-02", 51: "2006-01-02",
+	52: "2006-01-02", 53: "2006-01-02", 54: "2006-01-02", 55: "2006-01-02", 56: "2006-01-02", 57: "2006-01-02",
+	58: "2006-01-02",
+}
+
+// Returns, for each cell style (by index), the layout (as for time.Format) in which its numbers are read as dates,
+// or "" if they are not dates
+func (styles styleSheet) dateLayouts() []string {
+	custom := make(map[int]string)
+	for _, format := range styles.NumberFormats {
+		custom[format.Id] = dateLayout(format.Code)
+	}
+	layouts := make([]string, len(styles.CellFormats))
+	for i, cellFormat := range styles.CellFormats {
+		if layout, found := custom[cellFormat.NumberFormat]; found {
+			layouts[i] = layout
+		} else {
+			layouts[i] = builtinDateLayouts[cellFormat.NumberFormat]
+		}
+	}
+	return layouts
+}
+
+// Returns the layout in which to read numbers shown by a custom number format (e.g. "yyyy\-mm" or "d mmm yyyy") as
+// dates, or "" if it does not show a date. An "m" is a month only alongside a day or a year, as it is otherwise
+// taken to be minutes.
+func dateLayout(code string) string {
+	var letters strings.Builder
+	quoted, bracketed, escaped := false, false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted:
+			quoted = (r != '"')
+		case bracketed:
+			bracketed = (r != ']')
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = true
+		case r == '[':
+			bracketed = true
+		default:
+			letters.WriteRune(r)
+		}
+	}
+	shown := letters.String()
+	year, day := strings.ContainsRune(shown, 'y'), strings.ContainsRune(shown, 'd')
+	switch {
+	case day:
+		return "2006-01-02"
+	case year && strings.ContainsRune(shown, 'm'):
+		return "2006-01"
+	case year:
+		return "2006"
+	}
+	return ""
+}
+
+// Returns the date of an Excel serial day number in the given layout, or false if value is not a day number
+func serialDate(value string, date1904 bool, layout string) (string, bool) {
+	serial, err := strconv.ParseFloat(value, 64)
+	if (err != nil) || (serial < 0) || (serial > 2958465) {
+		return "", false
+	}
+	days := int(math.Floor(serial))
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	switch {
+	case date1904:
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	case days == 0:
+		return "", false
+	case days < 61:
+		// Excel counts a 29 February 1900 that never was, so only the days after it are counted from 30 December
+		epoch = time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	return epoch.AddDate(0, 0, days).Format(layout), true
+}
+
+// Returns the (0-based) column of a cell reference such as "C7"
+func columnIndex(ref string) (int, error) {
+	column := 0
+	letters := 0
+	for _, r := range strings.ToUpper(ref) {
+		if (r < 'A') || (r > 'Z') {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+		letters += 1
+	}
+	if letters == 0 {
+		return 0, fmt.Errorf("bad cell reference %q", ref)
+	}
+	return column - 1, nil
+}
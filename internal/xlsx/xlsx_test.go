@@ -0,0 +1,114 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Returns a workbook holding the named parts
+func testWorkbook(t *testing.T, parts map[string]string) *zip.Reader {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range parts {
+		part, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestRead(t *testing.T) {
+	reader := testWorkbook(t, map[string]string{
+		"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="index" sheetId="1" r:id="rId2"/><sheet name="notes" sheetId="2" r:id="rId3"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings" Target="sharedStrings.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet1.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="3" uniqueCount="3">
+<si><t>Record</t></si><si><t>Doc</t></si><si><r><t>KA630 </t></r><r><rPr><b/></rPr><t>Manual</t></r></si></sst>`,
+		"xl/worksheets/sheet2.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="inlineStr"><is><t>Title</t></is></c></row>
+<row r="2"><c r="A2" t="s"><v>1</v></c><c r="B2" t="s"><v>2</v></c><c r="E2"><v>1987</v></c></row>
+<row r="4"><c r="A4" t="s"><v>1</v></c><c r="E4" s="1"><v>31837</v></c><c r="F4" s="2"><v>31837.5</v></c><c r="G4" s="3"><v>31837</v></c></row>
+</sheetData></worksheet>`,
+		"xl/styles.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm;@"/></numFmts>
+<cellXfs count="4"><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="14"/><xf numFmtId="2"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c><v>other sheet</v></c></row></sheetData></worksheet>`,
+	})
+	rows, err := Read(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"Record", "Title"}, {"Doc", "KA630 Manual", "", "", "1987"}, nil, {"Doc", "", "", "", "1987-03", "1987-03-01", "31837"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Read() = %q, expected %q", rows, expected)
+	}
+
+	if _, err := Read(testWorkbook(t, map[string]string{"word/document.xml": "<document/>"})); err == nil {
+		t.Errorf("Read() accepted a file that is not a workbook")
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	for ref, expected := range map[string]int{"A1": 0, "H12": 7, "Z3": 25, "AA1": 26, "ab7": 27} {
+		if column, err := columnIndex(ref); (err != nil) || (column != expected) {
+			t.Errorf("columnIndex(%q) = %d, %v, expected %d", ref, column, err, expected)
+		}
+	}
+	if _, err := columnIndex("12"); err == nil {
+		t.Errorf("columnIndex() accepted a reference with no column")
+	}
+}
+
+func TestDateLayout(t *testing.T) {
+	for code, expected := range map[string]string{
+		"yyyy\\-mm;@": "2006-01", "d mmm yyyy": "2006-01-02", "yyyy": "2006", "mmm-yy": "2006-01",
+		"[$-409]mmmm d, yyyy": "2006-01-02", "General": "", "0.00": "", "mm:ss": "", `"day" 0`: "", "[h]:mm": "",
+	} {
+		if layout := dateLayout(code); layout != expected {
+			t.Errorf("dateLayout(%q) = %q, expected %q", code, layout, expected)
+		}
+	}
+}
+
+func TestSerialDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		date1904 bool
+		expected string
+	}{
+		{"1", false, "1900-01-01"},
+		{"59", false, "1900-02-28"},
+		{"61", false, "1900-03-01"},
+		{"31837", false, "1987-03-01"},
+		{"45658.75", false, "2025-01-01"},
+		{"30375", true, "1987-03-01"},
+	}
+	for _, test := range tests {
+		if date, ok := serialDate(test.value, test.date1904, "2006-01-02"); !ok || (date != test.expected) {
+			t.Errorf("serialDate(%s, %v) = %q, %v, expected %q", test.value, test.date1904, date, ok, test.expected)
+		}
+	}
+	if _, ok := serialDate("March", false, "2006-01-02"); ok {
+		t.Errorf("serialDate() accepted text")
+	}
+}
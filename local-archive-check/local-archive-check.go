@@ -1,21 +1,34 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/crcfile"
 	"docs-to-yaml/internal/document"
-	"encoding/csv"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/md5sums"
+	"docs-to-yaml/internal/naming"
+	"docs-to-yaml/internal/notify"
+	"docs-to-yaml/internal/par2"
+	"docs-to-yaml/internal/profiling"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/pkg/catalog"
 	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
-	"log"
-	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
 // The purpose of this program is to examine the root of a possible local archive tree and verify that all is in order.
@@ -26,11 +39,26 @@ import (
 //
 //   go run local-archive-check/local-archive-check.go --verbose --md5-cache bin/md5.store  --force-md5-sum --strict --tree-root ROOT
 //
-//  --verbose        turns on additional messages that may be useful in tracking program operation
+//  --verbose        turns on additional messages that may be useful in tracking program operation (also -v; -vv for more)
+//  --quiet          prints only the final summary line
 //  --md5-cache      checks index.* MD5 checksums against those in the store
 //  --force-md5-sum  causes MD5 checksums to be re-calculated
-//  --tree-root      root of the tree which should be checked as a local archive
+//  --tree-root      root of the tree which should be checked as a local archive; it may also be remote
+//                   (sftp://, smb:// or s3://, see internal/archivefs)
+//  --s3-config      a YAML file giving the endpoint and credentials for an s3:// tree root
 //  --fully-check    keep checking even in the face of severe errors to try to catch as many errors as possible; if not specified, stop on first fatal error
+//  --require-recovery  treat a volume without PAR2 recovery data (see internal/par2) as an error
+//  --naming-policy  a YAML file giving the file naming conventions for each category of volume (see internal/naming);
+//                   by default the files in HTML/ must be upper case and those in metadata/ lower case
+//  --rename-script  write a shell script that renames the files breaking the naming conventions, e.g. before re-burning
+//  --verify-crc     check the files listed in any legacy DEC checksum files (*.CRC) against their CRCs
+//  --seal           once every check has passed, seal the volume: record its Merkle digest (see volumes.Seal) in the
+//                   volume registry given by --volumes, under --volume-id (by default the last element of the tree root)
+//  --check-seal     only check the volume against its seal, which takes seconds as no document is read
+//  --catalog        a catalog (e.g. bin/local.yaml) in which to record, against each location on the volume (see
+//                   document.FileLocation), the date its file was found to match its MD5 checksum; needs --force-md5-sum
+//  --notify         a YAML file saying where to send a summary of the run (by email, webhook or ntfy) if anything
+//                   noteworthy happened, e.g. bit rot or a broken seal (see internal/notify)
 //
 // NOTES
 // md5sum
@@ -43,8 +71,22 @@ import (
 //    Must represent every file
 //    Must list an MD5 for every (file) entry
 //    Optionally check every MD5 entry
+//    The size of every file must match the size recorded in index.yaml (checked before any MD5)
 //  index.html, index.pdf, index.txt should exist
 //  No index.csv/.yaml other than at top level
+// Encryption (as recorded in index.yaml from the files' metadata)
+//    Documents that cannot be opened without a password are reported
+// File names
+//    Must follow the naming policy for the volume's category (see internal/naming)
+// *.CRC (optional, found on older discs, e.g. DEC_0040.CRC; see internal/crcfile)
+//    Must be in a recognised format
+//    Optionally check every entry
+// Seal (optional, see internal/volumes)
+//    With --check-seal, the volume must match the seal recorded in the volume registry; the directories whose files
+//    have changed since are reported
+// recovery.yaml (optional, written by file-tree-to-yaml --par2-redundancy)
+//    Every PAR2 recovery file it lists must be present
+//    The recovery files are not documents, so need not appear in index.csv or index.yaml
 
 type Document = document.Document
 
@@ -68,56 +110,241 @@ type MetaFiles struct {
 }
 
 func main() {
-	verbose := flag.Bool("verbose", false, "Enable verbose reporting")
+	verbose := console.Flags("Enable verbose reporting")
 	fullyCheck := flag.Bool("fully-check", false, "Continue in the face of errors")
-	// forceMd5Gen := flag.Bool("force-md5-sum", false, "Enable generation of MD5 sums")
+	forceMd5Gen := flag.Bool("force-md5-sum", false, "Re-calculate the MD5 sum of every file listed in md5sums and check it")
 	treeRoot := flag.String("tree-root", "", "root of the tree for which YAML should be generated")
+	requireRecovery := flag.Bool("require-recovery", false, "Report a volume without PAR2 recovery data as an error")
 	// md5Storeilename := flag.String("md5-cache", "", "filepath of the file that holds the volume path => MD5sum map")
+	namingPolicyFilename := flag.String("naming-policy", "", "filepath of a YAML file giving the file naming conventions for each category of volume")
+	renameScriptFilename := flag.String("rename-script", "", "filepath of a shell script to write that renames the files breaking the naming conventions")
+	verifyCrc := flag.Bool("verify-crc", false, "Re-calculate the CRC of every file listed in a legacy DEC checksum file (*.CRC) and check it")
+	s3ConfigFilename := flag.String("s3-config", "", "filepath of a YAML file giving the endpoint and credentials for an s3:// tree root")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file (see 'go tool pprof')")
+	memProfile := flag.String("memprofile", "", "write a memory profile to this file when the run ends")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	notifyFilename := flag.String("notify", "", "filepath of a YAML file saying where to send a summary of the run if anything noteworthy happened")
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml) holding the volume's seal")
+	volumeID := flag.String("volume-id", "", "the ID of the volume (e.g. DEC_0042) in the volume registry; by default the last element of --tree-root")
+	sealVolume := flag.Bool("seal", false, "Record the volume's seal in the volume registry once every check has passed")
+	checkSeal := flag.Bool("check-seal", false, "Only check the volume against the seal recorded in the volume registry")
+	catalogFilename := flag.String("catalog", "", "filepath of a catalog in which to record the date each file on the volume was found to match its MD5 checksum")
 
 	flag.Parse()
 
-	// Work out how long the root path is; this will be removed from the result to leave a relative path.
-	// (Ensure that the prefix finishes with a /)
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if *notifyFilename != "" {
+		notifyConfig, err := notify.ReadConfig(*notifyFilename)
+		if err != nil {
+			exitcode.UsageErrorf("Bad --notify: %v", err)
+		}
+		notify.Watch(notifyConfig)
+	}
+	if err := profiling.Start(*cpuProfile, *memProfile); err != nil {
+		exitcode.UsageErrorf("Cannot start profiling: %s", err)
+	}
+
+	interrupt.Watch()
+
+	if *treeRoot == "" {
+		exitcode.UsageError("--tree-root is mandatory - specify the root of the tree to check")
+	}
+	if (*sealVolume || *checkSeal) && (*volumesFilename == "") {
+		exitcode.UsageError("--seal and --check-seal need --volumes to say where the seal is kept")
+	}
+	if *sealVolume && *checkSeal {
+		exitcode.UsageError("--seal and --check-seal cannot be used together")
+	}
+	if (*catalogFilename != "") && !*forceMd5Gen {
+		exitcode.UsageError("--catalog needs --force-md5-sum, as only re-calculated MD5 checksums are recorded")
+	}
+	if *volumeID == "" {
+		*volumeID = path.Base(strings.TrimRight(filepath.ToSlash(*treeRoot), "/"))
+	}
+	volumeRegistry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+
+	s3Config, err := archivefs.ReadS3Config(*s3ConfigFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--s3-config: %s", err)
+	}
+	archivefs.ConfigureS3(s3Config)
+
+	namingPolicy, err := naming.ReadPolicy(*namingPolicyFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--naming-policy: %s", err)
+	}
+
+	// Paths are compared relative to the tree root, always using "/" as the separator (see fsutil).
 	treePrefix := *treeRoot
-	if treePrefix[len(treePrefix)-1:] != "/" {
-		treePrefix += "/"
+	if !archivefs.IsRemote(treePrefix) {
+		treePrefix = filepath.Clean(treePrefix)
+	}
+	treeFS, err := archivefs.Open(treePrefix)
+	if err != nil {
+		exitcode.UsageErrorf("--tree-root: %s", err)
 	}
-	treePrefixLength := len(treePrefix)
 
+	if *checkSeal {
+		CheckVolumeSeal(treeFS, *volumeID, volumeRegistry[*volumeID])
+		exitcode.Exit()
+	}
+
+	// Report how the tree would be classified as an archive volume. Only AC_CSV volumes are laid out the way
+	// this program expects, so anything else deserves a warning explaining why.
+	detected := archivecategory.DetectFS(treeFS, treePrefix)
+	events.Info("archive-category", *treeRoot, "INFO:  Archive category: %s\n", detected)
+	if detected.Category != archivecategory.CSV {
+		exitcode.WarningAt("category-problem", *treeRoot, "WARNING: tree is not an %s volume, so the checks below may not apply\n", archivecategory.CSV)
+	}
+
+	// Check the file names against the naming conventions for the category
+	violations, err := naming.Check(treeFS, namingPolicy[detected.Category])
+	if err != nil {
+		exitcode.Fatalf("FATAL: impossible to walk directories: %s", err)
+	}
+	for _, violation := range violations {
+		exitcode.WarningAt("naming-violation", violation.Path, "WARNING: File name %s breaks the naming policy: %s\n", violation.Path, violation.Problem)
+	}
+	if *renameScriptFilename != "" {
+		var script bytes.Buffer
+		if err := naming.WriteRenameScript(&script, *treeRoot, violations); err != nil {
+			exitcode.Fatal("Failed rename script write: ", err)
+		}
+		if err := fsutil.WriteFileAtomic(*renameScriptFilename, script.Bytes(), 0755); err != nil {
+			exitcode.Fatal("Failed rename script write: ", err)
+		}
+		fmt.Printf("Rename script for %d files written to %s\n", len(violations), *renameScriptFilename)
+	}
+
+	yamlDocumentsMap, verified, filesRepresentedCorrectly := CheckTree(treeFS, CheckOptions{
+		FullyCheck:      *fullyCheck,
+		ForceMd5:        *forceMd5Gen,
+		RequireRecovery: *requireRecovery,
+		VerifyCrc:       *verifyCrc,
+		Verbose:         *verbose,
+	})
+	if !filesRepresentedCorrectly && !*fullyCheck {
+		exitcode.Fatal("Stopping because of FATAL error.")
+	}
+
+	events.Info("document-count", "", "INFO:  Found (in YAML) %d documents\n", len(yamlDocumentsMap))
+
+	if *catalogFilename != "" {
+		recorded, err := RecordVerified(*catalogFilename, *volumeID, verified, time.Now().Format("2006-01-02"))
+		if err != nil {
+			exitcode.Fatalf("FATAL: cannot record verification dates in %s: %s", *catalogFilename, err)
+		}
+		events.Info("verified-count", *catalogFilename, "INFO:  Recorded %d verified locations in %s\n", recorded, *catalogFilename)
+	}
+
+	// A document that cannot be opened without a password is dead weight in the archive unless the password is known
+	passwordProtected, restricted := EncryptedDocuments(yamlDocumentsMap)
+	for _, path := range passwordProtected {
+		exitcode.WarningAt("password-protected", path, "WARNING: Document cannot be opened without a password: %s\n", path)
+	}
+	for _, path := range restricted {
+		if *verbose {
+			events.Info("restricted-document", path, "INFO:  Document is encrypted to restrict printing or copying: %s\n", path)
+		}
+	}
+	if (len(passwordProtected) > 0) || (len(restricted) > 0) {
+		events.Info("encrypted-count", "", "INFO:  Found %d password-protected and %d restricted documents\n", len(passwordProtected), len(restricted))
+	}
+
+	// Only a volume that has passed every check is worth sealing
+	if *sealVolume {
+		if _, failed := exitcode.Counts(); failed > 0 {
+			exitcode.Error("ERROR: Volume %s not sealed, as it failed %d checks\n", *volumeID, failed)
+		} else {
+			seal, err := volumes.ComputeSeal(treeFS)
+			if err != nil {
+				exitcode.Fatalf("FATAL: cannot seal volume %s: %s", *volumeID, err)
+			}
+			seal.Date = time.Now().Format("2006-01-02")
+			volumeRegistry.Update(*volumeID, volumes.Volume{Seal: &seal})
+			if err := volumeRegistry.Save(*volumesFilename); err != nil {
+				exitcode.Fatalf("FATAL: cannot write the volume registry %s: %s", *volumesFilename, err)
+			}
+			events.Info("volume-sealed", *treeRoot, "INFO:  Sealed volume %s in %s: %s\n", *volumeID, *volumesFilename, seal.Digest)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Checks a volume against the seal recorded for it, reporting each directory whose files have changed since as an error
+func CheckVolumeSeal(treeFS fs.FS, volumeID string, volume volumes.Volume) {
+	if volume.Seal == nil {
+		exitcode.Error("ERROR: Volume %s has not been sealed\n", volumeID)
+		return
+	}
+	start := time.Now()
+	changed, err := volumes.CheckSeal(treeFS, *volume.Seal)
+	if err != nil {
+		exitcode.Fatalf("FATAL: cannot check the seal of volume %s: %s", volumeID, err)
+	}
+	for _, dir := range changed {
+		exitcode.ErrorAt("seal-broken", dir, "ERROR: Directory %s of volume %s has changed since it was sealed on %s\n", dir, volumeID, volume.Seal.Date)
+	}
+	if len(changed) == 0 {
+		events.Info("seal-intact", volumeID, "INFO:  Volume %s is as it was sealed on %s (checked in %s)\n", volumeID, volume.Seal.Date, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// CheckOptions are the checks asked for on the command line
+type CheckOptions struct {
+	FullyCheck      bool // Keep checking after a fatal problem with the index files
+	ForceMd5        bool // Re-calculate the MD5 checksum of every file listed in md5sums
+	RequireRecovery bool // Treat a volume without PAR2 recovery data as an error
+	VerifyCrc       bool // Re-calculate the CRC of every file listed in a legacy DEC checksum file
+	Verbose         bool
+}
+
+// Checks that index.yaml, index.csv and md5sums are present and agree with each other and with the files in the tree,
+// along with any recovery data and legacy checksum files. Every problem is reported as an error. Unless FullyCheck is
+// set, a fatal problem with the index files stops the checks. Returns the documents in index.yaml, the files whose MD5
+// checksums were re-calculated and matched (sorted), and whether every file is represented correctly.
+func CheckTree(treeFS fs.FS, options CheckOptions) (map[string]Document, []string, bool) {
 	// Check for the presence of critical meta files
 
 	metafiles := []MetaFiles{
-		{"index.csv", MF_CSV, false, false, nil},
+		{indexcsv.IndexFilename, MF_CSV, false, false, nil},
 		{"index.yaml", MF_YAML, false, false, nil},
-		{"md5sums", MF_MD5, false, false, nil},
+		{md5sums.Md5sumsFilename, MF_MD5, false, false, nil},
 	}
 
-	yamlDocumentsMap, csvRecords, md5Documents, err := HandleMetalFiles(treePrefix, metafiles)
+	yamlDocumentsMap, csvRecords, md5Documents, err := HandleMetalFiles(treeFS, metafiles)
 	if err != nil {
 		fmt.Println(err)
-		if !*fullyCheck {
-			log.Fatal("Stopping because of FATAL error.")
+		if !options.FullyCheck {
+			return yamlDocumentsMap, nil, false
 		}
 	}
 
 	// Accumulate the relative path to each file under the root, ignoring any directories.
 	archiveDocumentsRelativeFilePaths := make(map[string]string)
-	err = filepath.WalkDir(treePrefix, func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(treeFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if !d.IsDir() {
-			if path != "index.csv" && path != "index.yaml" {
-				archiveDocumentsRelativeFilePaths[path[treePrefixLength:]] = path[treePrefixLength:]
-			}
+			archiveDocumentsRelativeFilePaths[path] = path
 		}
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("FATAL: impossible to walk directories: %s", err)
+		exitcode.Fatalf("FATAL: impossible to walk directories: %s", err)
 	}
 
 	// TODO Temporary display of paths
-	if *verbose {
-		for _, doc := range archiveDocumentsRelativeFilePaths {
-			fmt.Printf("INFO:  Found: %s\n", doc)
+	if options.Verbose {
+		for _, doc := range sortedPaths(archiveDocumentsRelativeFilePaths) {
+			events.Info("document-found", doc, "INFO:  Found: %s\n", doc)
 		}
 	}
 
@@ -133,8 +360,8 @@ func main() {
 
 	csvDocsByPath := make(map[string]string)
 	for _, record := range csvRecords {
-		if record[0] == "Doc" {
-			csvDocsByPath[record[2]] = record[6]
+		if record.IsDocument() {
+			csvDocsByPath[record.Filepath] = record.Md5
 		}
 	}
 
@@ -143,23 +370,23 @@ func main() {
 	// Verify the YAML file vs tree only if the YAML file is present (its absence will be noted as FATAL anyway)
 	if len(yamlDocsByPath) > 0 {
 		// Verify that every document in the tree appears in the YAML
-		for _, docPath := range archiveDocumentsRelativeFilePaths {
+		for _, docPath := range sortedPaths(archiveDocumentsRelativeFilePaths) {
 			if _, present := yamlDocsByPath[docPath]; !present {
-				if docPath != "index.csv" && docPath != "index.yaml" && docPath != "md5sums" {
-					fmt.Printf("FATAL: Document missing from index.yaml: %s\n", docPath)
+				if docPath != "index.csv" && docPath != "index.yaml" && docPath != "md5sums" && !par2.IsRecoveryFile(docPath) {
+					exitcode.ErrorAt("missing-document", docPath, "FATAL: Document missing from index.yaml: %s\n", docPath)
 					filesRepresentedCorrectly = false
 				}
 			} else {
-				if *verbose {
-					fmt.Printf("INFO:  Document present in index.yaml: %s\n", docPath)
+				if options.Verbose {
+					events.Info("document-present", docPath, "INFO:  Document present in index.yaml: %s\n", docPath)
 				}
 			}
 		}
 
 		// Verify that every document listed in the YAML appears in the tree
-		for _, doc := range yamlDocumentsMap {
-			if _, present := archiveDocumentsRelativeFilePaths[doc.Filepath]; !present {
-				fmt.Printf("FATAL: Document in index.yaml not present in file tree: %s\n", doc.Filepath)
+		for _, path := range sortedPaths(yamlDocsByPath) {
+			if _, present := archiveDocumentsRelativeFilePaths[path]; !present {
+				exitcode.ErrorAt("unexpected-document", path, "FATAL: Document in index.yaml not present in file tree: %s\n", path)
 				filesRepresentedCorrectly = false
 			}
 		}
@@ -168,23 +395,23 @@ func main() {
 	// Verify the CSV file vs tree only if the CSV file is present (its absence will be noted as FATAL anyway)
 	if len(csvDocsByPath) > 0 {
 		// Verify that every document in the tree appears in the CSV
-		for _, docPath := range archiveDocumentsRelativeFilePaths {
+		for _, docPath := range sortedPaths(archiveDocumentsRelativeFilePaths) {
 			if _, present := csvDocsByPath[docPath]; !present {
-				if docPath != "index.csv" && docPath != "index.yaml" && docPath != "md5sums" {
-					fmt.Printf("FATAL: Document missing from index.csv: %s\n", docPath)
+				if docPath != "index.csv" && docPath != "index.yaml" && docPath != "md5sums" && !par2.IsRecoveryFile(docPath) {
+					exitcode.ErrorAt("missing-document", docPath, "FATAL: Document missing from index.csv: %s\n", docPath)
 					filesRepresentedCorrectly = false
 				}
 			} else {
-				if *verbose {
-					fmt.Printf("INFO:  Document present in index.csv: %s\n", docPath)
+				if options.Verbose {
+					events.Info("document-present", docPath, "INFO:  Document present in index.csv: %s\n", docPath)
 				}
 			}
 		}
 
 		// Verify that every document in the CSV appears in the tree
-		for path, _ := range csvDocsByPath {
+		for _, path := range sortedPaths(csvDocsByPath) {
 			if _, present := archiveDocumentsRelativeFilePaths[path]; !present {
-				fmt.Printf("FATAL: Document in index.csv not present in file tree: %s\n", path)
+				exitcode.ErrorAt("unexpected-document", path, "FATAL: Document in index.csv not present in file tree: %s\n", path)
 				filesRepresentedCorrectly = false
 			}
 		}
@@ -193,24 +420,45 @@ func main() {
 	// Verify the md5sum file vs tree only if the md5sum file is present (its absence will be noted as FATAL anyway)
 	if len(md5Documents) > 0 {
 		// Verify that every document in the tree appears in the md5sum
-		for _, docPath := range archiveDocumentsRelativeFilePaths {
+		for _, docPath := range sortedPaths(archiveDocumentsRelativeFilePaths) {
 			if _, present := md5Documents[docPath]; !present {
 				// md5sums is expected to contain all files including metadata files, other than itself
 				if docPath != "md5sums" {
-					fmt.Printf("FATAL: Document missing from md5sum: %s\n", docPath)
+					exitcode.ErrorAt("missing-document", docPath, "FATAL: Document missing from md5sum: %s\n", docPath)
 					filesRepresentedCorrectly = false
 				}
 			} else {
-				if *verbose {
-					fmt.Printf("INFO:  Document present in md5sum: %s\n", docPath)
+				if options.Verbose {
+					events.Info("document-present", docPath, "INFO:  Document present in md5sum: %s\n", docPath)
 				}
 			}
 		}
 
 		// Verify that every document in the md5sum file appears in the tree
-		for path, _ := range md5Documents {
+		for _, path := range sortedPaths(md5Documents) {
 			if _, present := archiveDocumentsRelativeFilePaths[path]; !present {
-				fmt.Printf("FATAL: Document in index.yaml not present in file tree: %s\n", path)
+				exitcode.ErrorAt("unexpected-document", path, "FATAL: Document in index.yaml not present in file tree: %s\n", path)
+				filesRepresentedCorrectly = false
+			}
+		}
+	}
+
+	// Verify that the PAR2 recovery data recorded for the volume (if any) is all present
+	if !CheckRecoveryFiles(treeFS, options.RequireRecovery) {
+		filesRepresentedCorrectly = false
+	}
+
+	// Verify that every file listed in the YAML still has the recorded size.
+	// This only needs a stat of each file, so it is done before any (much slower) MD5 checks.
+	if len(yamlDocsByPath) > 0 {
+		events.Info("check", "", "INFO:  Checking YAML sizes vs tree\n")
+		for _, path := range sortedPaths(yamlDocsByPath) {
+			doc := yamlDocsByPath[path]
+			if _, present := archiveDocumentsRelativeFilePaths[path]; !present {
+				continue // Already reported above
+			}
+			if problem := CheckFileSize(treeFS, path, doc.Size); problem != "" {
+				exitcode.ErrorAt("size-mismatch", path, "FATAL: Size mismatch for %s: %s\n", path, problem)
 				filesRepresentedCorrectly = false
 			}
 		}
@@ -218,14 +466,15 @@ func main() {
 
 	// Verify MD5 checksums between YAML and CSV
 	if (len(yamlDocsByPath) > 0) && (len(csvDocsByPath) > 0) {
-		fmt.Println("INFO:  Checking YAML vs CSV")
-		for path, doc := range yamlDocsByPath {
+		events.Info("check", "", "INFO:  Checking YAML vs CSV\n")
+		for _, path := range sortedPaths(yamlDocsByPath) {
+			doc := yamlDocsByPath[path]
 			if csvDocMd5, present := csvDocsByPath[path]; !present {
-				fmt.Printf("FATAL: checking YAML MD5 vs CSV MD5, document missing in CSV: %s\n", path)
+				exitcode.ErrorAt("missing-document", path, "FATAL: checking YAML MD5 vs CSV MD5, document missing in CSV: %s\n", path)
 				filesRepresentedCorrectly = false
 			} else {
 				if doc.Md5 != csvDocMd5 {
-					fmt.Printf("FATAL: checking YAML MD5 vs CSV MD5, mismatch for: %s (YAML MD5=%s CSV MD5=%s\n", path, doc.Md5, csvDocMd5)
+					exitcode.ErrorAt("md5-mismatch", path, "FATAL: checking YAML MD5 vs CSV MD5, mismatch for: %s (YAML MD5=%s CSV MD5=%s)\n", path, doc.Md5, csvDocMd5)
 					filesRepresentedCorrectly = false
 				}
 			}
@@ -234,14 +483,15 @@ func main() {
 
 	// Verify MD5 checksums between YAML and md5sum
 	if (len(yamlDocsByPath) > 0) && (len(md5Documents) > 0) {
-		fmt.Println("INFO:  Checking YAML vs md5sum")
-		for path, doc := range yamlDocsByPath {
-			if md5Md5, present := md5Documents[path]; !present {
-				fmt.Printf("FATAL: checking YAML MD5 vs md5sum MD5, document missing in md5sum: %s\n", path)
+		events.Info("check", "", "INFO:  Checking YAML vs md5sum\n")
+		for _, path := range sortedPaths(yamlDocsByPath) {
+			doc := yamlDocsByPath[path]
+			if md5sumMd5, present := md5Documents[path]; !present {
+				exitcode.ErrorAt("missing-document", path, "FATAL: checking YAML MD5 vs md5sum MD5, document missing in md5sum: %s\n", path)
 				filesRepresentedCorrectly = false
 			} else {
-				if doc.Md5 != md5Md5 {
-					fmt.Printf("FATAL: checking YAML MD5 vs md5sum MD5, mismatch for: %s (YAML MD5=%s md5sum MD5=%s\n", path, doc.Md5, md5Md5)
+				if doc.Md5 != md5sumMd5 {
+					exitcode.ErrorAt("md5-mismatch", path, "FATAL: checking YAML MD5 vs md5sum MD5, mismatch for: %s (YAML MD5=%s md5sum MD5=%s)\n", path, doc.Md5, md5sumMd5)
 					filesRepresentedCorrectly = false
 				}
 			}
@@ -251,14 +501,15 @@ func main() {
 
 	// Verify MD5 checksums between CSV and md5sum
 	if (len(csvDocsByPath) > 0) && (len(md5Documents) > 0) {
-		fmt.Println("INFO:  Checking CSV vs md5sum")
-		for path, csvDocMd5 := range csvDocsByPath {
-			if md5Md5, present := md5Documents[path]; !present {
-				fmt.Printf("FATAL: checking CSV MD5 vs md5sum MD5, document missing in md5sum: %s\n", path)
+		events.Info("check", "", "INFO:  Checking CSV vs md5sum\n")
+		for _, path := range sortedPaths(csvDocsByPath) {
+			csvDocMd5 := csvDocsByPath[path]
+			if md5sumMd5, present := md5Documents[path]; !present {
+				exitcode.ErrorAt("missing-document", path, "FATAL: checking CSV MD5 vs md5sum MD5, document missing in md5sum: %s\n", path)
 				filesRepresentedCorrectly = false
 			} else {
-				if csvDocMd5 != md5Md5 {
-					fmt.Printf("FATAL: checking YAML MD5 vs md5sum MD5, mismatch for: %s (YAML MD5=%s md5sum MD5=%s\n", path, csvDocMd5, md5Md5)
+				if csvDocMd5 != md5sumMd5 {
+					exitcode.ErrorAt("md5-mismatch", path, "FATAL: checking CSV MD5 vs md5sum MD5, mismatch for: %s (CSV MD5=%s md5sum MD5=%s)\n", path, csvDocMd5, md5sumMd5)
 					filesRepresentedCorrectly = false
 				}
 			}
@@ -266,15 +517,182 @@ func main() {
 		}
 	}
 
+	// Verify that every file listed in md5sums still has the recorded MD5 checksum
+	var verified []string
+	if options.ForceMd5 && (len(md5Documents) > 0) {
+		events.Info("check", "", "INFO:  Re-calculating MD5 checksums\n")
+		for _, path := range sortedPaths(md5Documents) {
+			md5sumMd5 := md5Documents[path]
+			if interrupt.Requested() {
+				// Verification dates are only recorded after a complete check, so there is no state to save
+				interrupt.Exit("MD5 checksums were not all re-calculated; re-run to check them again")
+			}
+			md5Checksum, err := hashing.Md5FS(treeFS, path)
+			if err != nil {
+				exitcode.ErrorAt("unreadable-file", path, "FATAL: cannot calculate MD5 for %s: %v\n", path, err)
+				filesRepresentedCorrectly = false
+			} else if md5Checksum != md5sumMd5 {
+				exitcode.ErrorAt("md5-mismatch", path, "FATAL: calculated MD5 mismatch for: %s (calculated MD5=%s md5sum MD5=%s)\n", path, md5Checksum, md5sumMd5)
+				filesRepresentedCorrectly = false
+			} else {
+				verified = append(verified, path)
+				if options.Verbose {
+					events.Info("md5-match", path, "INFO:  Calculated MD5 matches for: %s\n", path)
+				}
+			}
+		}
+	}
+
+	// Check the legacy DEC checksum files that some older discs carry
+	if !CheckCrcFiles(treeFS, options.VerifyCrc, options.Verbose) {
+		filesRepresentedCorrectly = false
+	}
+
 	if !filesRepresentedCorrectly {
 		fmt.Println("FATAL: Some files missing from index or not present in tree")
-		if !*fullyCheck {
-			log.Fatal("Stopping because of FATAL error.")
+	}
+	return yamlDocumentsMap, verified, filesRepresentedCorrectly
+}
+
+// Records date as the verification date of each location on the volume, in the catalog in filename, whose path is
+// among verified (the files found to match their MD5 checksums), and saves the catalog if any changed.
+// Returns the number of locations changed.
+func RecordVerified(filename string, volumeID string, verified []string, date string) (int, error) {
+	documents, err := catalog.Load(filename)
+	if err != nil {
+		return 0, err
+	}
+	verifiedPaths := make(map[string]bool)
+	for _, path := range verified {
+		verifiedPaths[path] = true
+	}
+	recorded := 0
+	for key, doc := range documents {
+		for _, location := range doc.Copies {
+			if (location.Volume == volumeID) && verifiedPaths[location.Path] && document.MarkVerified(&doc, location.String(), date) {
+				documents[key] = doc
+				recorded += 1
+			}
+		}
+	}
+	if recorded == 0 {
+		return 0, nil
+	}
+	return recorded, catalog.Save(filename, documents)
+}
+
+// Returns the keys of a map of filepaths, sorted, so that problems are reported in the same order on every run
+func sortedPaths[V any](paths map[string]V) []string {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Returns the filepaths, sorted, of the documents that cannot be opened without a password and of those encrypted only
+// to restrict printing, copying etc. (see Document.Encryption)
+func EncryptedDocuments(documents map[string]Document) ([]string, []string) {
+	var passwordProtected, restricted []string
+	for _, doc := range documents {
+		switch doc.Encryption {
+		case document.EncryptionPassword:
+			passwordProtected = append(passwordProtected, doc.Filepath)
+		case document.EncryptionRestricted:
+			restricted = append(restricted, doc.Filepath)
 		}
 	}
+	sort.Strings(passwordProtected)
+	sort.Strings(restricted)
+	return passwordProtected, restricted
+}
 
-	fmt.Printf("INFO:  Found (in YAML) %d documents\n", len(yamlDocumentsMap))
+// Compares the size of the named file in the tree with the size recorded in the catalog.
+// Returns a description of the problem, or "" if the sizes match.
+// A file smaller than expected has probably been truncated (e.g. by an interrupted copy); any other mismatch
+// suggests the file has been replaced.
+func CheckFileSize(treeFS fs.FS, name string, expectedSize int64) string {
+	info, err := fs.Stat(treeFS, name)
+	if err != nil {
+		return fmt.Sprintf("cannot stat (%v)", err)
+	}
+	actualSize := info.Size()
+	if actualSize < expectedSize {
+		return fmt.Sprintf("size %d is less than recorded size %d, file probably truncated", actualSize, expectedSize)
+	} else if actualSize > expectedSize {
+		return fmt.Sprintf("size %d is more than recorded size %d, file probably replaced", actualSize, expectedSize)
+	}
+	return ""
+}
 
+// Checks that every PAR2 recovery file listed in the volume's recovery.yaml is present.
+// A volume without recovery data is only a problem if required is set. Returns false if there is a problem.
+func CheckRecoveryFiles(treeFS fs.FS, required bool) bool {
+	manifest, err := par2.ReadManifest(treeFS)
+	if errors.Is(err, fs.ErrNotExist) {
+		if required {
+			exitcode.ErrorAt("missing-recovery-data", par2.ManifestFilename, "FATAL: Volume has no PAR2 recovery data (%s)\n", par2.ManifestFilename)
+			return false
+		}
+		events.Info("check", "", "INFO:  No PAR2 recovery data\n")
+		return true
+	} else if err != nil {
+		exitcode.ErrorAt("malformed-metafile", par2.ManifestFilename, "FATAL: Cannot read %s: %v\n", par2.ManifestFilename, err)
+		return false
+	}
+	events.Info("check", "", "INFO:  Checking %d PAR2 recovery files (%d%% redundancy)\n", len(manifest.Files), manifest.Redundancy)
+	missing := manifest.MissingFiles(treeFS)
+	for _, name := range missing {
+		exitcode.ErrorAt("missing-recovery-file", name, "FATAL: PAR2 recovery file listed in %s not present: %s\n", par2.ManifestFilename, name)
+	}
+	return len(missing) == 0
+}
+
+// Checks that every legacy DEC checksum file (*.CRC) in the tree is in a recognised format and, if verify is set, that
+// every file it lists still has the recorded CRC. Returns false if there is a problem.
+func CheckCrcFiles(treeFS fs.FS, verify bool, verbose bool) bool {
+	names, err := crcfile.Find(treeFS)
+	if err != nil {
+		exitcode.ErrorAt("unreadable-file", "", "FATAL: cannot look for CRC files: %v\n", err)
+		return false
+	}
+	ok := true
+	for _, name := range names {
+		if !verify {
+			data, err := fs.ReadFile(treeFS, name)
+			if err == nil {
+				var file crcfile.File
+				if file, err = crcfile.Parse(data); err == nil {
+					events.Info("check", name, "INFO:  CRC file %s lists %d files (%s)\n", name, len(file.Entries), file.Format())
+				}
+			}
+			if err != nil {
+				exitcode.ErrorAt("malformed-metafile", name, "FATAL: Cannot read %s: %v\n", name, err)
+				ok = false
+			}
+			continue
+		}
+		if interrupt.Requested() {
+			interrupt.Exit("CRCs were not all re-calculated; re-run to check them again")
+		}
+		algorithm, results, err := crcfile.Verify(treeFS, name)
+		if err != nil {
+			exitcode.ErrorAt("malformed-metafile", name, "FATAL: Cannot read %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+		events.Info("check", name, "INFO:  Checking %d files against %s (%s)\n", len(results), name, algorithm)
+		for _, result := range results {
+			if result.Problem != "" {
+				exitcode.ErrorAt("crc-mismatch", result.Filepath, "FATAL: CRC check failed for %s (listed in %s): %s\n", result.Filepath, name, result.Problem)
+				ok = false
+			} else if verbose {
+				events.Info("crc-match", result.Filepath, "INFO:  Calculated CRC matches for: %s\n", result.Filepath)
+			}
+		}
+	}
+	return ok
 }
 
 // A helper function that checks for possibly problematic characters
@@ -293,88 +711,64 @@ func HasProblematicCharacters(data *[]byte) bool {
 // The metafiles include index.yaml and index.csv.
 // This function reads them, performs some minimal sanity checks and
 // then loads appropriate data to return to the caller.
-func HandleMetalFiles(treePrefix string, metafiles []MetaFiles) (map[string]Document, [][]string, map[string]string, error) {
+func HandleMetalFiles(treeFS fs.FS, metafiles []MetaFiles) (map[string]Document, []indexcsv.Record, map[string]string, error) {
 
 	documentsMap := make(map[string]Document)
-	var csvRecords [][]string
+	var csvRecords []indexcsv.Record
 	md5Map := make(map[string]string)
 
 	var problematic_essential_files []string
 	major_issue := false
 	for _, mf := range metafiles {
-		filePath := treePrefix + mf.path
-
-		fileInfo, err := os.Stat(filePath)
+		fileInfo, err := fs.Stat(treeFS, mf.path)
 		if err != nil {
-			fmt.Printf("FATAL: Cannot stat %s\n", mf.path)
+			exitcode.ErrorAt("unreadable-file", mf.path, "FATAL: Cannot stat %s\n", mf.path)
 			major_issue = true
 		} else {
 			mode := fileInfo.Mode()
 			if (mode&0200 != 0) || (mode&0020 != 0) || (mode&0002 != 0) {
-				fmt.Printf("FATAL: Metafile is writeable %s (mode=%o)\n", mf.path, mode)
+				exitcode.ErrorAt("writeable-metafile", mf.path, "FATAL: Metafile is writeable %s (mode=%o)\n", mf.path, mode)
 				major_issue = true
 			}
 		}
-		content, err := os.ReadFile(filePath)
+		content, err := fs.ReadFile(treeFS, mf.path)
 		if err == nil {
 			mf.present = true
 			mf.correct = true
 			mf.fileContents = &content
 			if !HasProblematicCharacters(mf.fileContents) {
 				mf.correct = false
-				fmt.Printf("FATAL: Metafile with non-ASCII characters: %s\n", mf.path)
+				exitcode.ErrorAt("non-ascii-metafile", mf.path, "FATAL: Metafile with non-ASCII characters: %s\n", mf.path)
 				major_issue = true
 			} else {
 				// Apply special processing
 				switch mf.category {
 				case MF_YAML:
-					err = yaml.Unmarshal(*mf.fileContents, &documentsMap)
+					err = document.UnmarshalYaml(*mf.fileContents, &documentsMap)
 					if err != nil {
-						fmt.Printf("FATAL: YAML unmarshal error for %s: %v", mf.path, err)
+						exitcode.ErrorAt("malformed-metafile", mf.path, "FATAL: YAML unmarshal error for %s: %v", mf.path, err)
 						major_issue = true
 					}
 				case MF_CSV:
-					reader := csv.NewReader(bytes.NewReader(*mf.fileContents))
-
-					// Read all the records from the CSV
-					csvRecords, err = reader.ReadAll()
+					// Read all the records from the CSV (the header record, if any, is skipped)
+					csvRecords, err = indexcsv.Read(bytes.NewReader(*mf.fileContents))
 					if err != nil {
-						fmt.Printf("FATAL: CSV record reading error for %s: %v", mf.path, err)
+						exitcode.ErrorAt("malformed-metafile", mf.path, "FATAL: CSV record reading error for %s: %v", mf.path, err)
 						major_issue = true
 					}
-					// TODO perform minimal sanity checks: e.g. header record as expected
 				case MF_MD5:
-					// A line from md5sum should look like this:
-					// 4556f5bdf78aa195b18e06e35a64c89f *mvxaaig1.pdf
-					// That's exactly 32 characters of md5 checksum, a space, either a space or an asterisk and finally a filepath (relative to the md5sum)
-					// The asterisk is present if the checksum was generated in binary mode; on my Linux system the result is the same whether binary mode is selected or not.
-					md5Regex := regexp.MustCompile(`^([a-f0-9]{32})\s(?:\s|\*)(.+)$`)
-					scanner := bufio.NewScanner(bytes.NewReader(*mf.fileContents))
-					lineCount := 0
-					for scanner.Scan() {
-						line := scanner.Text()
-						lineCount += 1
-						// Match the line using the regex
-						matches := md5Regex.FindStringSubmatch(line)
-						if matches == nil {
-							fmt.Printf("FATAL: md5sum invalid format on line %d: %s", lineCount, line)
-							major_issue = true
-						}
-
-						md5sum := matches[1]
-						filepath := matches[2]
-						md5Map[filepath] = md5sum
-					}
-					if err := scanner.Err(); err != nil {
-						fmt.Printf("FATAL: md5sum record reading error for %s: %v", mf.path, err)
+					entries, err := md5sums.Read(bytes.NewReader(*mf.fileContents))
+					if err != nil {
+						exitcode.ErrorAt("malformed-metafile", mf.path, "FATAL: md5sum record reading error for %s: %v\n", mf.path, err)
 						major_issue = true
 					}
+					md5Map = md5sums.ToMap(entries)
 				case MF_Undefined:
 				}
 
 			}
 		} else {
-			fmt.Printf("FATAL: Cannot read %s: %v\n", mf.path, err)
+			exitcode.ErrorAt("unreadable-file", mf.path, "FATAL: Cannot read %s: %v\n", mf.path, err)
 			problematic_essential_files = append(problematic_essential_files, mf.path)
 			major_issue = true
 		}
@@ -382,7 +776,7 @@ func HandleMetalFiles(treePrefix string, metafiles []MetaFiles) (map[string]Docu
 	}
 
 	if len(problematic_essential_files) > 0 {
-		fmt.Println("FATAL: Missing essential file(s): ", strings.Join(problematic_essential_files, ","))
+		exitcode.ErrorAt("missing-essential-file", "", "FATAL: Missing essential file(s): %s\n", strings.Join(problematic_essential_files, ","))
 	}
 
 	if major_issue {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/testkit"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRecordVerified(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "local.yaml")
+	documents := catalog.Catalog{
+		"a": {Md5: "0123", Filepath: "file:///DEC_0042/vax/ka630.pdf"},
+		"b": {Md5: "4567", Filepath: "file:///DEC_0043/vax/ka630.pdf"},
+		"c": {Md5: "89ab", Filepath: "file:///DEC_0042/vax/ka655.pdf"},
+	}
+	if err := catalog.Save(filename, documents); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, err := RecordVerified(filename, "DEC_0042", []string{"index.csv", "vax/ka630.pdf"}, "2024-06-01"); (err != nil) || (recorded != 1) {
+		t.Fatalf(`RecordVerified() = %d, %v`, recorded, err)
+	}
+	saved, err := catalog.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (saved["a"].Copies[0].Verified != "2024-06-01") || (saved["b"].Copies[0].Verified != "") || (saved["c"].Copies[0].Verified != "") {
+		t.Errorf(`RecordVerified() saved %+v`, saved)
+	}
+}
+
+func TestCheckTree(t *testing.T) {
+	intact := testkit.Build(t, testkit.Spec{Category: archivecategory.CSV, Seed: 3233})
+	testkit.CaptureEvents(t)
+	documents, verified, ok := CheckTree(os.DirFS(intact.Root), CheckOptions{ForceMd5: true})
+	if !ok || (len(documents) != len(intact.Documents)) {
+		t.Errorf(`CheckTree() of an intact volume = %d documents, %v`, len(documents), ok)
+	}
+	if !slices.Contains(verified, intact.Documents[0].Path) || !slices.IsSorted(verified) {
+		t.Errorf(`CheckTree() verified %q`, verified)
+	}
+
+	tests := []struct {
+		corruption testkit.Corruption
+		eventType  string
+	}{
+		{testkit.MissingFile, "unexpected-document"},
+		{testkit.UnlistedFile, "missing-document"},
+		{testkit.BadChecksum, "md5-mismatch"},
+		{testkit.Truncated, "size-mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.corruption), func(t *testing.T) {
+			volume := testkit.Build(t, testkit.Spec{Category: archivecategory.CSV, Seed: 3233, Corruptions: []testkit.Corruption{test.corruption}})
+			reported := testkit.CaptureEvents(t)
+			if _, _, ok := CheckTree(os.DirFS(volume.Root), CheckOptions{FullyCheck: true, ForceMd5: true}); ok {
+				t.Errorf(`CheckTree() passed a volume with a %s`, test.corruption)
+			}
+			if doc, _ := volume.Corrupted(test.corruption); !testkit.HasEvent(reported(), test.eventType, doc.Path) {
+				t.Errorf(`CheckTree() did not report %s for %s: %+v`, test.eventType, doc.Path, reported())
+			}
+		})
+	}
+}
+
+func TestCheckCrcFilesOfBuiltVolume(t *testing.T) {
+	intact := testkit.Build(t, testkit.Spec{Category: archivecategory.Custom, Seed: 3233})
+	testkit.CaptureEvents(t)
+	if !CheckCrcFiles(os.DirFS(intact.Root), true, false) {
+		t.Errorf(`CheckCrcFiles() failed an intact volume`)
+	}
+
+	volume := testkit.Build(t, testkit.Spec{Category: archivecategory.Custom, Seed: 3233, Corruptions: []testkit.Corruption{testkit.BadChecksum}})
+	reported := testkit.CaptureEvents(t)
+	if CheckCrcFiles(os.DirFS(volume.Root), true, false) {
+		t.Errorf(`CheckCrcFiles() passed a volume with a bad checksum`)
+	}
+	if doc, _ := volume.Corrupted(testkit.BadChecksum); !testkit.HasEvent(reported(), "crc-mismatch", doc.Path) {
+		t.Errorf(`CheckCrcFiles() did not report %s: %+v`, doc.Path, reported())
+	}
+}
+
+func TestCheckVolumeSeal(t *testing.T) {
+	intact := testkit.Build(t, testkit.Spec{Category: archivecategory.CSV, Seed: 3233})
+	seal, err := volumes.ComputeSeal(os.DirFS(intact.Root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := testkit.CaptureEvents(t)
+	CheckVolumeSeal(os.DirFS(intact.Root), "DEC_0001", volumes.Volume{Seal: &seal})
+	if !testkit.HasEvent(reported(), "seal-intact", "DEC_0001") {
+		t.Errorf(`CheckVolumeSeal() did not pass an intact volume: %+v`, reported())
+	}
+
+	volume := testkit.Build(t, testkit.Spec{Category: archivecategory.CSV, Seed: 3233, Corruptions: []testkit.Corruption{testkit.Truncated}})
+	reported = testkit.CaptureEvents(t)
+	CheckVolumeSeal(os.DirFS(volume.Root), "DEC_0001", volumes.Volume{Seal: &seal})
+	if doc, _ := volume.Corrupted(testkit.Truncated); !testkit.HasEvent(reported(), "seal-broken", path.Dir(doc.Path)) {
+		t.Errorf(`CheckVolumeSeal() did not report the directory of %s: %+v`, doc.Path, reported())
+	}
+}
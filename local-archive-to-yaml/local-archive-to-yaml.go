@@ -28,7 +28,9 @@ package main
 //
 // All the existing archived optical media has a top-level index.htm that provides a list of documents and their properties
 // or links to further HTML files that perform that function. There is some inconsistency in how these index files are laid out
-// but that will be resolved over time.
+// but that will be resolved over time. The layout of each volume is autodetected (see internal/archivecategory); an
+// archive: line in the indirect file may end with category=regular|html|metadata|custom|csv to force it instead, and
+// with expected-count=N to check that N documents are found in the volume.
 //
 // index.txt and index.pdf provide the same information but in a harder to parse form. These files were in fact generated
 // from the index.htm and so contain no additional information.
@@ -45,13 +47,44 @@ package main
 //
 //   go run local-archive-to-yaml/local-archive-to-yaml.go --verbose --md5-cache bin/md5.store  --md5-sum --indirect-file INDIRECT.txt --yaml DOCS.YAML
 //
-//  --verbose turns on additional messages that may be useful in tracking program operation
+//  --verbose (or -v) turns on additional messages that may be useful in tracking program operation; -vv adds per-file detail
+//  --quiet prints only the final summary line; --no-color turns off the colouring of warnings and errors (see internal/console)
 //  --md5-sum causes MD5 checksums to be calculated if not already in the store
+//  --sha256 (with --md5-sum) records SHA-256 checksums too, computed in the same pass over each file; the store then holds "MD5 SHA256" for each path (see hashing.Checksums)
 //  --md5-cache-create allows an MD5 cache to be created if the one specified does not exist
 //  --md5-cache indicates where the cache of MD5 data can be found; this will be created if it does not exist and --md5-cache-create is specified and will be updated if --md5-sum is specified
 //  --indirect-file indicates the indirect file that specifies which index files to analyse
 //  --exif causes PDF metadata to be extracted and stored
+//  --exif-cache indicates where the cache of PDF metadata (keyed by MD5) can be found; --exif-create-cache allows it to be created
+//  --refresh-exif causes cached PDF metadata to be ignored and re-extracted
+//  --duplicate-policy RULES chooses which of two documents with the same MD5 checksum found in different indexes or volumes
+//                     is kept, e.g. local,richer (see internal/retention); the default keeps the first found
+//  --volumes records the checksums of each volume's index files in the volume registry (see internal/volumes)
+//  --metadata-config selects the metadata backend for each format: exiftool (the default) or an Apache Tika server (see pdfmetadata.Config)
+//  --autosave-files N, --autosave-minutes M checkpoint the MD5 store, the PDF metadata cache and a partial catalog (YAML-OUTPUT.partial)
+//                     after every N files or M minutes, and after each volume; within a volume the partial catalog also holds
+//                     the documents found so far in the volume in progress
+//  --resume continues an interrupted run from the partial catalog, skipping volumes that were already completed and
+//                     reusing the documents already found in the volume in progress (so they are not hashed or --exec'd again)
+//  --exif-workers sets how many PDF metadata extractions run concurrently (default: one per CPU)
+//  --exec "COMMAND {path} {md5}" runs COMMAND for each document and merges the key=value lines it prints (e.g. title=...) into the document
 //  --yaml-output specifies where the YAML data should be stored
+//  --summary-output also writes a summary of each directory (see catalog.Summarise), for browsing a large volume
+//  --only-volume, --path-prefix, --since and --limit restrict the run to the named volume(s), to files under a path prefix,
+//                     to files modified on or after a date and to a maximum number of files respectively (useful when debugging)
+//
+// An archive root in the indirect file may also be a directory on a machine that can be reached but not mounted,
+// given as sftp://[user@]host[:port]/path (read over ssh) or smb://[user@]host/share/path (read with smbclient),
+// or a prefix in S3-compatible object storage, given as s3://bucket/prefix; see internal/archivefs. The endpoint and
+// credentials for s3:// roots come from --s3-config FILE and/or the usual AWS environment variables. Where an object's
+// ETag is its MD5 checksum it is used as such, so the object is not downloaded just to hash it.
+// PDF metadata (--exif) and --exec need a local file, so a remote file is fetched to a temporary copy for them.
+//
+// Every archive root named in the indirect file is checked before any work starts. One that is missing or empty
+// (typically a NAS share that is not mounted) is skipped with a warning and listed again at the end of the run.
+//
+// Ctrl-C (or SIGTERM) stops the run cleanly: the stores and the partial catalog (including the documents found so far
+// in the volume in progress) are saved and the run can then be continued with --resume. A second Ctrl-C stops immediately.
 //
 // NOTES
 //
@@ -76,19 +109,45 @@ package main
 
 import (
 	"bufio"
-	"crypto/md5"
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/enrich"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/indexhtml"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/metrics"
 	"docs-to-yaml/internal/pdfmetadata"
 	"docs-to-yaml/internal/persistentstore"
-	"encoding/hex"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/profiling"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/internal/runlimit"
+	"docs-to-yaml/internal/textnorm"
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -99,8 +158,10 @@ type PdfMetadata = pdfmetadata.PdfMetadata
 // PathAndVolume represents a single local archive.
 // PathAndVolume is used when parsing the indirect file.
 type PathAndVolume struct {
-	Path       string // Path to the root of the local archive
-	VolumeName string // Name of the local archive
+	Path       string                   // Path to the root of the local archive
+	VolumeName string                   // Name of the local archive
+	Category   archivecategory.Category // The category forced by a category= option; Undefined means autodetect
+	Expected   int                      // The number of documents declared by an expected-count= option; 0 if not declared
 }
 
 // MissingFile represents the relative path of a missing file.
@@ -115,8 +176,9 @@ type SubstituteFile struct {
 }
 
 type FileHandlingExceptions struct {
-	FileSubstitutes []SubstituteFile
-	MissingFiles    []MissingFile
+	FileSubstitutes  []SubstituteFile
+	MissingFiles     []MissingFile
+	ProblemFilenames fsutil.ProblemFilenames // Files that could not be processed; reported at the end of the run
 }
 
 type IndirectFileEntry interface{}
@@ -125,25 +187,24 @@ type ProgamFlags struct {
 	Statistics  bool // display statistics
 	Verbose     bool // display extra infomational messages
 	GenerateMD5 bool // generate MD5 checksums
-	ReadEXIF    bool // Read EXIF data from PDF files
-}
-
-// Implement an enum for ArchiveCategory
-type ArchiveCategory int
-
-// These are the legal ArchiveCategory enum values
-const (
-	AC_Undefined ArchiveCategory = iota
-	AC_CSV
-	AC_Regular
-	AC_HTML
-	AC_Metadata
-	AC_Custom
-)
-
-// This turns ArchiveCategory enums into a text string
-func (ac ArchiveCategory) String() string {
-	return [...]string{"AC_Undefined", "AC_CSV", "AC_Regular", "AC_HTML", "AC_Metadata", "AC_Custom"}[ac]
+	// GenerateSha256 also generates SHA-256 checksums, in the same pass over each file as its MD5 checksum
+	GenerateSha256 bool
+	ReadEXIF       bool // Read EXIF data from PDF files
+	ExifWorkers    int  // Number of PDF metadata extractions to run concurrently
+	RefreshExif    bool // Ignore cached PDF metadata
+	// ExifCache holds PDF metadata extracted on previous runs, keyed by MD5 checksum
+	ExifCache *pdfmetadata.Cache
+	// CaseSensitive is set per archive: true if the archive lives on a case-sensitive filesystem,
+	// in which case links in HTML files must be matched case-insensitively against the files present.
+	CaseSensitive bool
+	// Limits restricts the run to a subset of volumes and files (nil means no restriction)
+	Limits *runlimit.Limits
+	// ExecHook is run for each document to supply extra metadata (nil if --exec is not used)
+	ExecHook *exechook.Hook
+	// DuplicatePolicy chooses which of two documents with the same MD5 checksum, found in different indexes or volumes, is kept
+	DuplicatePolicy retention.Policy
+	// Progress checkpoints the documents found in the volume in progress (nil if there is nothing to checkpoint)
+	Progress *VolumeProgress
 }
 
 // Main entry point.
@@ -152,16 +213,56 @@ func (ac ArchiveCategory) String() string {
 // Finally outputs the cumulative YAML file.
 func main() {
 	statistics := flag.Bool("statistics", false, "Enable statistics reporting")
-	verbose := flag.Bool("verbose", false, "Enable verbose reporting")
+	verbose := console.Flags("Enable verbose reporting")
 	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output file to hold the generated yaml")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	summaryOutputFilename := flag.String("summary-output", "", "filepath of a YAML summary of each directory (documents, size, formats and representative titles), for browsing a large volume")
+	splitOutputBy := flag.String("split-output-by", "", "write the output catalog as a directory of one YAML file per collection, volume or format, plus an index")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
 	md5Gen := flag.Bool("md5-sum", false, "Enable generation of MD5 sums")
+	sha256Gen := flag.Bool("sha256", false, "also record SHA-256 checksums, computed in the same pass as the MD5 sums (needs --md5-sum)")
 	exifRead := flag.Bool("exif", false, "Enable EXIF reading")
+	exifCacheFilename := flag.String("exif-cache", "", "filepath of the file that holds the MD5 => PDF metadata cache")
+	exifCacheCreate := flag.Bool("exif-create-cache", false, "allow for the case of a non-existent PDF metadata cache file")
+	refreshExif := flag.Bool("refresh-exif", false, "ignore any cached PDF metadata and extract it again")
+	exifWorkers := flag.Int("exif-workers", pipeline.DefaultWorkers(), "number of PDF metadata extractions to run concurrently")
 	indirectFile := flag.String("indirect-file", "", "a file that contains a set of directories to process")
 	md5CacheFilename := flag.String("md5-cache", "", "filepath of the file that holds the volume path => MD5sum map")
 	md5CacheCreate := flag.Bool("md5-create-cache", false, "allow for the case of a non-existent MD5 cache file")
+	autosaveFiles := flag.Int("autosave-files", 0, "checkpoint the stores and partial catalog after this many files (0 disables)")
+	autosaveMinutes := flag.Int("autosave-minutes", 0, "checkpoint the stores and partial catalog after this many minutes (0 disables)")
+	resume := flag.Bool("resume", false, "resume from the partial catalog left by an interrupted run")
+	limit := flag.Int("limit", 0, "process at most this many files (0 means no limit)")
+	onlyVolume := flag.String("only-volume", "", "process only the named volume(s); a comma-separated list")
+	pathPrefix := flag.String("path-prefix", "", "process only files whose path within the volume starts with this prefix")
+	since := flag.String("since", "", "process only files modified on or after this date (YYYY-MM-DD)")
+	execCommand := flag.String("exec", "", "a command, with {path} and {md5} placeholders, to run for each document; key=value lines it prints are merged into the document")
+	s3ConfigFilename := flag.String("s3-config", "", "filepath of a YAML file giving the endpoint and credentials for s3:// archive roots")
+	duplicatePolicy := flag.String("duplicate-policy", "", "how to choose between documents with the same MD5 checksum: comma-separated rules from first, local, richer, newer and quality (default: first)")
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml) in which to record the index checksums of each volume")
+	metadataConfigFilename := flag.String("metadata-config", "", "filepath of a YAML file choosing the metadata backend (exiftool or tika) for each format")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file (see 'go tool pprof')")
+	memProfile := flag.String("memprofile", "", "write a memory profile to this file when the run ends")
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store listing documents removed on purpose, which are left out")
+	enrichmentFilename := flag.String("enrichment", enrich.DefaultFilename, "filepath of the enrichment rules applied to the documents before the catalog is written")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	metricsLogFilename := flag.String("metrics-log", "", "filepath of a file to which this run's hashing, cache and exiftool metrics are appended as NDJSON")
 
 	flag.Parse()
 
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if err := catalog.ValidatePartitioning(*splitOutputBy); err != nil {
+		exitcode.UsageError(err)
+	}
+	if err := profiling.Start(*cpuProfile, *memProfile); err != nil {
+		exitcode.UsageErrorf("Cannot start profiling: %s", err)
+	}
+	metrics.Start(*statistics, *metricsLogFilename)
+
+	interrupt.Watch()
+
 	fatal_error_seen := false
 
 	if *yamlOutputFilename == "" {
@@ -174,8 +275,51 @@ func main() {
 		fatal_error_seen = true
 	}
 
+	limits, err := runlimit.New(*limit, *onlyVolume, *pathPrefix, *since)
+	if err != nil {
+		log.Print(err)
+		fatal_error_seen = true
+	}
+
+	execHook, err := exechook.Parse(*execCommand)
+	if err != nil {
+		log.Printf("--exec: %s", err)
+		fatal_error_seen = true
+	}
+
+	s3Config, err := archivefs.ReadS3Config(*s3ConfigFilename)
+	if err != nil {
+		log.Printf("--s3-config: %s", err)
+		fatal_error_seen = true
+	}
+	archivefs.ConfigureS3(s3Config)
+
+	volumeRegistry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		log.Printf("--volumes: %s", err)
+		fatal_error_seen = true
+	}
+
+	policy, err := retention.Parse(*duplicatePolicy)
+	if err != nil {
+		log.Printf("--duplicate-policy: %s", err)
+		fatal_error_seen = true
+	}
+
+	metadataConfig, err := pdfmetadata.ReadConfig(*metadataConfigFilename)
+	if err != nil {
+		log.Printf("--metadata-config: %s", err)
+		fatal_error_seen = true
+	}
+	pdfmetadata.Configure(metadataConfig)
+
+	if *sha256Gen && !*md5Gen {
+		log.Printf("--sha256 needs --md5-sum")
+		fatal_error_seen = true
+	}
+
 	if fatal_error_seen {
-		log.Fatal("Unable to continue because of one or more fatal errors")
+		exitcode.UsageError("Unable to continue because of one or more fatal errors")
 	}
 
 	var programFlags ProgamFlags
@@ -183,29 +327,110 @@ func main() {
 	programFlags.Statistics = *statistics
 	programFlags.Verbose = *verbose
 	programFlags.ReadEXIF = *exifRead
+	programFlags.ExifWorkers = *exifWorkers
+	programFlags.RefreshExif = *refreshExif
 	programFlags.GenerateMD5 = *md5Gen
+	programFlags.GenerateSha256 = *sha256Gen
+	programFlags.Limits = limits
+	programFlags.ExecHook = execHook
+	programFlags.DuplicatePolicy = policy
+	if limits != nil {
+		fmt.Printf("Partial run: %s\n", limits)
+	}
 
 	md5StoreInstantiation := persistentstore.Store[string, string]{}
 	md5Store, err := md5StoreInstantiation.Init(*md5CacheFilename, *md5CacheCreate, programFlags.Verbose)
 	if err != nil {
-		fmt.Printf("Problem initialising MD5 Store: %+v\n", err)
+		exitcode.Warning("Problem initialising MD5 Store: %+v\n", err)
 	} else if *verbose {
 		fmt.Println("Size of new MD5 store: ", len(md5Store.Data))
 	}
 
+	exifCacheInstantiation := pdfmetadata.Cache{}
+	programFlags.ExifCache, err = exifCacheInstantiation.Init(*exifCacheFilename, *exifCacheCreate, programFlags.Verbose)
+	if err != nil {
+		exitcode.Warning("Problem initialising PDF metadata cache: %+v\n", err)
+	}
+
+	// During a long run the stores and the catalog built so far are checkpointed periodically,
+	// so that an interrupted run can be resumed with --resume.
+	autosavePolicy := checkpoint.Policy{EveryItems: *autosaveFiles, Every: time.Duration(*autosaveMinutes) * time.Minute}
+	md5Store.EnableAutosave(*md5CacheFilename, autosavePolicy)
+	programFlags.ExifCache.EnableAutosave(*exifCacheFilename, autosavePolicy)
+	partialCatalogFilename, err := workspace.Companion(*yamlOutputFilename, ".partial")
+	if err != nil {
+		exitcode.UsageErrorf("Bad --yaml-output: %v", err)
+	}
+	partialCatalogTimer := checkpoint.NewTimer(autosavePolicy)
+
 	documentsMap := make(map[string]Document)
+	completedVolumes := make(map[string]bool)
+	progress := &VolumeProgress{timer: partialCatalogTimer}
+	progress.save = func() {
+		SavePartialCatalog(partialCatalogFilename, completedVolumes, documentsMap, progress)
+		md5Store.Save(*md5CacheFilename)
+		programFlags.ExifCache.Save(*exifCacheFilename)
+	}
+	programFlags.Progress = progress
+
+	if *resume {
+		var partial PartialCatalog
+		found, err := checkpoint.LoadState(partialCatalogFilename, &partial)
+		if err != nil {
+			exitcode.Fatalf("Failed to read partial catalog %s: %s", partialCatalogFilename, err)
+		}
+		if found {
+			if partial.Documents != nil {
+				documentsMap = partial.Documents
+			}
+			for _, volume := range partial.CompletedVolumes {
+				completedVolumes[volume] = true
+			}
+			progress.Resume(partial.InProgressVolume, partial.InProgressDocuments)
+			fmt.Printf("Resuming from %s: %d volumes and %d documents already processed\n", partialCatalogFilename, len(partial.CompletedVolumes), len(documentsMap))
+			if partial.InProgressVolume != "" {
+				fmt.Printf("Continuing volume %s: %d documents already found\n", partial.InProgressVolume, len(partial.InProgressDocuments))
+			}
+		} else {
+			fmt.Printf("No partial catalog %s found: starting from the beginning\n", partialCatalogFilename)
+		}
+	}
 
 	indirectFileEntry, err := ParseIndirectFile(*indirectFile)
 	if err != nil {
-		log.Fatalf("Failed to parse indirect file: %s", err)
+		exitcode.Fatalf("Failed to parse indirect file: %s", err)
 	}
 
 	var fileExceptions FileHandlingExceptions
 
+	// Check every archive root up front, so that an unmounted share is skipped rather than ending the run part way through
+	skippedVolumes := FindUnavailableArchives(indirectFileEntry, limits)
+
 	for _, item := range indirectFileEntry {
+		if interrupt.Requested() {
+			break
+		}
 		switch t := item.(type) {
 		case PathAndVolume:
+			if !limits.VolumeSelected(item.(PathAndVolume).VolumeName) || limits.Exhausted() {
+				continue
+			}
+			if _, skipped := skippedVolumes[item.(PathAndVolume).VolumeName]; skipped {
+				continue
+			}
+			if completedVolumes[item.(PathAndVolume).VolumeName] {
+				fmt.Printf("Skipping volume %s: already processed before the previous run was interrupted\n", item.(PathAndVolume).VolumeName)
+				continue
+			}
+			progress.Start(item.(PathAndVolume).VolumeName)
 			extraDocumentsMap := ProcessArchive(item.(PathAndVolume), &fileExceptions, md5Store, programFlags)
+			// A volume that was interrupted part way through is incomplete, so it is not added to the catalog; the documents
+			// found in it so far are kept in the partial catalog, and reused when it is processed again on resumption
+			if interrupt.Requested() {
+				fmt.Printf("Volume %s interrupted after %d documents\n", item.(PathAndVolume).VolumeName, len(progress.Documents))
+				continue
+			}
+			progress.Finish()
 			if *verbose {
 				for i, doc := range extraDocumentsMap {
 					fmt.Println("doc", i, "=>", doc)
@@ -221,8 +446,9 @@ func main() {
 						if *verbose {
 							fmt.Printf("WARNING(1a): Document [%s] already exists, identical to original %v (was %v)\n", k, v, val)
 						}
+						v, _ = programFlags.DuplicatePolicy.Resolve(val, v)
 					} else {
-						fmt.Printf("WARNING(1): Document [%s] in %s already exists (was %s)\n", k, v.Filepath, val.Filepath)
+						exitcode.WarningAt("duplicate-document", v.Filepath, "WARNING(1): Document [%s] in %s already exists (was %s)\n", k, v.Filepath, val.Filepath)
 						key = k + "DUPLICATE-of-" + val.Filepath
 					}
 				}
@@ -231,6 +457,22 @@ func main() {
 			if programFlags.Statistics {
 				fmt.Printf("Found %4d documents in volume %s\n", len(extraDocumentsMap), item.(PathAndVolume).VolumeName)
 			}
+			// A partial run finds fewer documents by design, so the count can only be checked on a full run
+			if expected := item.(PathAndVolume).Expected; (expected > 0) && (limits == nil) && (len(extraDocumentsMap) != expected) {
+				exitcode.ErrorAt("count-mismatch", item.(PathAndVolume).Path, "ERROR: Found %d documents in volume %s, but the indirect file expects %d\n", len(extraDocumentsMap), item.(PathAndVolume).VolumeName, expected)
+			}
+			if *volumesFilename != "" {
+				RecordVolume(volumeRegistry, item.(PathAndVolume))
+			}
+			// A partial run may have skipped some of the volume's files, so the volume is not complete
+			if limits == nil {
+				completedVolumes[item.(PathAndVolume).VolumeName] = true
+			}
+			// The documents in the volume have been counted towards the next checkpoint as they were found, and a completed
+			// volume is always worth recording
+			if autosavePolicy.Enabled() {
+				progress.save()
+			}
 		case SubstituteFile:
 			fileExceptions.FileSubstitutes = append(fileExceptions.FileSubstitutes, item.(SubstituteFile))
 		case MissingFile:
@@ -241,233 +483,321 @@ func main() {
 		}
 	}
 
+	// If interrupted, save everything done so far and explain how to carry on
+	if interrupt.Requested() {
+		md5Store.Save(*md5CacheFilename)
+		programFlags.ExifCache.Save(*exifCacheFilename)
+		SavePartialCatalog(partialCatalogFilename, completedVolumes, documentsMap, progress)
+		SaveVolumeRegistry(volumeRegistry, *volumesFilename)
+		interrupt.Exit("To continue, re-run with the same arguments plus --resume")
+	}
+
 	if programFlags.Statistics {
 		fmt.Printf("Final tally of %d documents being written to YAML\n", len(documentsMap))
 	}
 
+	ReportSkippedVolumes(skippedVolumes)
+	fileExceptions.ProblemFilenames.Report(os.Stdout)
+	exitcode.AddWarnings(len(fileExceptions.ProblemFilenames.Entries))
+	for _, entry := range fileExceptions.ProblemFilenames.Entries {
+		events.Emit(events.SeverityWarning, "problem-filename", entry.Path, entry.Problem)
+	}
+
 	// If the MD5 Store is active and it has been modified ... save it
 	md5Store.Save(*md5CacheFilename)
+	programFlags.ExifCache.Save(*exifCacheFilename)
+	SaveVolumeRegistry(volumeRegistry, *volumesFilename)
 
-	// Write the output YAML file
-	err = document.WriteDocumentsMapToOrderedYaml(documentsMap, *yamlOutputFilename)
-	if err != nil {
-		log.Fatal("Failed YAML write: ", err)
-	}
-
-}
+	// Leave out the documents that were removed on purpose, so that rebuilding the catalog does not bring them back
+	tombstones.ExcludeForCatalog(*tombstonesFilename, documentsMap, *verbose)
 
-// ProcessArchive examines a single archive volume, determines the category it belongs to
-// and calls the appropriate processing function.
-// It returns a map of Document objects that have been found.
-func ProcessArchive(archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
-	category := DetermineCategory((archive.Path))
+	// Apply the recurring fixups kept as enrichment rules
+	enrich.ApplyForCatalog(*enrichmentFilename, documentsMap, *verbose)
 
-	switch category {
-	case AC_Undefined:
-		fmt.Printf("Cannot process undefined category for %s\n", archive.Path)
-	case AC_CSV:
-		fmt.Printf("Cannot process CSV category for %s\n", archive.Path)
-	case AC_Regular:
-		return ParseIndexHtml(archive.Path+"index.htm", archive.VolumeName, archive.Path, fileExceptions, md5Store, programFlags)
-	case AC_HTML:
-		return ProcessCategoryHTML(archive, fileExceptions, md5Store, programFlags)
-	case AC_Metadata:
-		return ProcessCategoryMetadata(archive, fileExceptions, md5Store, programFlags)
-	case AC_Custom:
-		return ProcessCategoryCustom(archive, fileExceptions, md5Store, programFlags)
+	// Keep any tags and notes that were added by hand to the catalog being replaced
+	if _, err := catalog.Catalog(documentsMap).PreserveAnnotationsFrom(*yamlOutputFilename); err != nil {
+		exitcode.Warning("WARNING: cannot carry forward tags and notes from %s: %s\n", *yamlOutputFilename, err)
 	}
-	return nil
-}
 
-func ProcessCategoryHTML(archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
-	// 1. Find all links in INDEX.HTM ... each one must point to HTML/XXXX.HTM; build a list of these targets
-	// 2. Verify that every file in HTML/ (regardless of filetype) appears in the list of targets
-	// process each .HTM file
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documentsMap, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
 
-	// Read INDEX.HTM
-	indexPath := archive.Path + "INDEX.HTM"
-	bytes, err := os.ReadFile(indexPath)
+	// Write the output YAML file
+	err = catalog.SaveSplit(*yamlOutputFilename, documentsMap, *splitOutputBy)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal("Failed YAML write: ", err)
 	}
-
-	// Build  alist of links found in INDEX.HTM
-	var links []string
-	re := regexp.MustCompile(`(?m)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>\s+<\/TD>`)
-	matches := re.FindAllStringSubmatch(string(bytes), -1)
-	if len(matches) == 0 {
-		log.Fatal("No matches found")
-	} else {
-		for _, v := range matches {
-			links = append(links, strings.ToUpper(v[1]))
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documentsMap); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+	if *summaryOutputFilename != "" {
+		if err := catalog.SaveSummary(*summaryOutputFilename, documentsMap); err != nil {
+			exitcode.Fatal("Failed summary write: ", err)
 		}
 	}
 
-	if programFlags.Verbose {
-		fmt.Printf("Found %d links in %s\n", len(links), indexPath)
+	// The run is complete, so any partial catalog is no longer needed
+	if err := os.Remove(partialCatalogFilename); err == nil {
+		fmt.Printf("Removed partial catalog %s\n", partialCatalogFilename)
 	}
 
-	subdir := archive.Path + "HTML/"
+	exitcode.Exit()
+}
 
-	var containsDir bool
+// PartialCatalog is the checkpoint written during a long run.
+// It records which volumes have been completely processed and the documents found in them, and the documents found so
+// far in the volume in progress (if any).
+type PartialCatalog struct {
+	CompletedVolumes    []string
+	Documents           map[string]Document
+	InProgressVolume    string              `yaml:",omitempty"`
+	InProgressDocuments map[string]Document `yaml:",omitempty"` // Keyed by filepath
+}
 
-	// Walk through the directory and its contents
-	err = filepath.Walk(subdir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Handle any error that occurs during file walking
-			fmt.Println("Error:", err)
-			return err
-		}
-		// Skip the top-level directory itself
-		if path == subdir {
-			return nil
-		}
+// Writes a checkpoint of the catalog built so far, including the documents found in the volume in progress (if any).
+func SavePartialCatalog(filename string, completedVolumes map[string]bool, documentsMap map[string]Document, progress *VolumeProgress) {
+	var partial PartialCatalog
+	for volume := range completedVolumes {
+		partial.CompletedVolumes = append(partial.CompletedVolumes, volume)
+	}
+	sort.Strings(partial.CompletedVolumes)
+	partial.Documents = documentsMap
+	if (progress != nil) && (progress.Volume != "") {
+		partial.InProgressVolume = progress.Volume
+		partial.InProgressDocuments = progress.Documents
+	}
+	err := checkpoint.SaveState(filename, partial)
+	if err != nil {
+		exitcode.WarningAt("checkpoint-failed", filename, "WARNING: failed to write partial catalog %s: %s\n", filename, err)
+		return
+	}
+	if partial.InProgressVolume != "" {
+		fmt.Printf("Checkpoint: %d volumes, %d documents and %d documents of volume %s written to %s\n", len(partial.CompletedVolumes), len(documentsMap), len(partial.InProgressDocuments), partial.InProgressVolume, filename)
+		return
+	}
+	fmt.Printf("Checkpoint: %d volumes and %d documents written to %s\n", len(partial.CompletedVolumes), len(documentsMap), filename)
+}
 
-		// Check if the current path is a directory
-		if info.IsDir() {
-			// Mark that we have encountered a directory
-			containsDir = true
-			fmt.Printf("WARNING Found subdirectory %s in %s\n", path, subdir)
-			return nil
-		}
+// VolumeProgress records the documents found in the volume in progress, so that a checkpoint taken part way through a
+// long volume keeps them, and a resumed run can reuse them rather than hashing each file (and running --exec) again.
+type VolumeProgress struct {
+	Volume    string              // The volume in progress, if any
+	Documents map[string]Document // The documents found so far in Volume, by filepath
+	timer     *checkpoint.Timer   // Decides when a checkpoint is due
+	save      func()              // Writes a checkpoint
 
-		// All files in HTML/ should have completely uppercase names
-		// if strings.ToUpper(path) != path {
-		//	fmt.Printf("WARNING Found not-all-uppercase file %s in %s\n", path, subdir)
-		//}
+	resumedVolume    string              // The volume that was in progress when the previous run stopped
+	resumedDocuments map[string]Document // The documents found in it, by filepath
+}
 
-		// TODO
-		// All files in HTML/ should appear in links
-		// relativePath, err := filepath.Rel(subdir, path)
-		//relativePath := path
-		//if !links.Contains(relativePath) {
-		//	fmt.Printf("WARNING Found not-all-uppercase file %s in %\n", path, subdir)
-		//}
+// Records the documents found in a volume that was in progress when the previous run stopped
+func (progress *VolumeProgress) Resume(volume string, documents map[string]Document) {
+	progress.resumedVolume = volume
+	progress.resumedDocuments = documents
+}
 
-		return nil
-	})
+// Starts recording the documents found in a volume
+func (progress *VolumeProgress) Start(volume string) {
+	progress.Volume = volume
+	progress.Documents = make(map[string]Document)
+	if volume == progress.resumedVolume {
+		// Anything found before the previous run stopped counts as found in this one, should it be interrupted too
+		for filepath, doc := range progress.resumedDocuments {
+			progress.Documents[filepath] = doc
+		}
+	}
+}
 
-	documentsMap := make(map[string]Document)
+// Stops recording once the volume in progress is complete; its documents are then part of the catalog proper
+func (progress *VolumeProgress) Finish() {
+	if progress.Volume == progress.resumedVolume {
+		progress.resumedVolume = ""
+		progress.resumedDocuments = nil
+	}
+	progress.Volume = ""
+	progress.Documents = nil
+}
 
-	if err != nil {
-		fmt.Println("Error walking the path:", err)
-		return documentsMap
+// Records a document found in the volume in progress, writing a checkpoint if one is due.
+// Does nothing if progress is nil (i.e. nothing is being checkpointed).
+func (progress *VolumeProgress) Add(doc Document) {
+	if (progress == nil) || (progress.Volume == "") {
+		return
+	}
+	progress.Documents[doc.Filepath] = doc
+	if progress.timer.Add(1) && (progress.save != nil) {
+		progress.save()
 	}
+}
 
-	// Report whether any directories were found
-	if containsDir {
-		fmt.Println("HTML/ contains directories.")
+// Returns the document found at a filepath of the volume in progress before the previous run stopped, if any
+func (progress *VolumeProgress) Resumed(documentPath string) (Document, bool) {
+	if (progress == nil) || (progress.Volume == "") || (progress.Volume != progress.resumedVolume) {
+		return Document{}, false
 	}
+	doc, found := progress.resumedDocuments[fsutil.EscapeInvalidUTF8(documentPath)]
+	return doc, found
+}
 
-	// For each link ... process it
-	for _, idx := range links {
-		extraDocumentsMap := ParseIndexHtml(archive.Path+idx, archive.VolumeName, archive.Path, fileExceptions, md5Store, programFlags)
-		if programFlags.Verbose {
-			for i, doc := range extraDocumentsMap {
-				fmt.Println("doc", i, "=>", doc)
-			}
-			fmt.Println("found ", len(extraDocumentsMap), "new documents")
+// Checks the root of every selected archive in the indirect file and returns the unavailable ones, as a map of
+// volume name to the reason. Each is reported as a warning, so the run finishes with a warning exit status.
+func FindUnavailableArchives(indirectFileEntry []IndirectFileEntry, limits *runlimit.Limits) map[string]string {
+	unavailable := make(map[string]string)
+	for _, item := range indirectFileEntry {
+		archive, ok := item.(PathAndVolume)
+		if !ok || !limits.VolumeSelected(archive.VolumeName) {
+			continue
 		}
-		for k, v := range extraDocumentsMap {
-			val, key_exists := documentsMap[k]
-			if key_exists {
-				if (v.Md5 != "") && (v.Md5 == val.Md5) {
-					if programFlags.Verbose {
-						fmt.Printf("WARNING(2a): Document [%s] already exists, identical to original %v (was %v)\n", k, v, val)
-					}
-				} else {
-					fmt.Printf("WARNING(2): Document [%s] already exists but being overwritten by %v (was %v)\n", k, v, val)
-				}
-			}
-			documentsMap[k] = v
+		if err := archivefs.CheckAvailable(archive.Path); err != nil {
+			unavailable[archive.VolumeName] = err.Error()
+			exitcode.WarningAt("archive-unavailable", archive.Path, "WARNING: SKIPPING VOLUME %s: %s\n", archive.VolumeName, err)
 		}
 	}
-	return documentsMap
+	return unavailable
 }
 
-func ProcessCategoryMetadata(archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
-	// 1. Find all links in index.htm ... each one must point to HTML/XXXX.HTM; build a list of these targets
-	// 2. Verify that every file in metadata/ (regardless of filetype) appears in the list of targets
-	// process each .HTM file
-
-	// Read index.htm
-	indexPath := archive.Path + "index.htm"
-	bytes, err := os.ReadFile(indexPath)
-	if err != nil {
-		log.Fatal(err)
+// Lists the volumes that were skipped because their archive root was unavailable
+func ReportSkippedVolumes(skippedVolumes map[string]string) {
+	if len(skippedVolumes) == 0 {
+		return
+	}
+	volumes := make([]string, 0, len(skippedVolumes))
+	for volume := range skippedVolumes {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	fmt.Printf("%d volume(s) skipped because the archive root was unavailable; their documents are missing from this catalog:\n", len(volumes))
+	for _, volume := range volumes {
+		fmt.Printf("  %s: %s\n", volume, skippedVolumes[volume])
 	}
+}
 
-	// Build a list of links found in index.htm
-	var links []string
-	re := regexp.MustCompile(`(?ms)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>`)
-	matches := re.FindAllStringSubmatch(string(bytes), -1)
-	if len(matches) == 0 {
-		log.Fatalf("No matches found in %s", indexPath)
-	} else {
-		for _, v := range matches {
-			links = append(links, v[1])
+// Records the checksums of a volume's index files in the volume registry, so that a later change to the volume's
+// index can be spotted. The rest of the volume's description is left as it was.
+func RecordVolume(registry volumes.Registry, archive PathAndVolume) {
+	archiveFS, err := archivefs.Open(archive.Path)
+	if err == nil {
+		var checksums map[string]string
+		if checksums, err = volumes.IndexChecksums(archiveFS); err == nil {
+			registry.Update(archive.VolumeName, volumes.Volume{IndexMd5s: checksums})
+			return
 		}
 	}
+	exitcode.WarningAt("volume-unrecorded", archive.Path, "WARNING: cannot record volume %s in the volume registry: %s\n", archive.VolumeName, err)
+}
 
-	if programFlags.Verbose {
-		fmt.Printf("Found %d links in %s\n", len(links), indexPath)
+// Saves the volume registry, if one was requested
+func SaveVolumeRegistry(registry volumes.Registry, filename string) {
+	if filename == "" {
+		return
 	}
+	if err := registry.Save(filename); err != nil {
+		exitcode.Error("Cannot write the volume registry %s: %s\n", filename, err)
+	}
+}
 
-	subdir := archive.Path + "metadata/"
-
-	var containsDir bool
-
-	// Walk through the directory and its contents
-	err = filepath.Walk(subdir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Handle any error that occurs during file walking
-			fmt.Println("Error:", err)
-			return err
-		}
-		// Skip the top-level directory itself
-		if path == subdir {
-			return nil
+// ProcessArchive examines a single archive volume, determines the category it belongs to
+// and calls the appropriate processing function.
+// It returns a map of Document objects that have been found.
+func ProcessArchive(archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
+	events.SetVolume(archive.VolumeName)
+	metrics.SetScope(archive.VolumeName)
+	archiveFS, err := archivefs.Open(archive.Path)
+	if err != nil {
+		exitcode.ErrorAt("archive-unavailable", archive.Path, "Cannot open %s: %s\n", archive.Path, err)
+		return nil
+	}
+	detected := archivecategory.DetectFS(archiveFS, archive.Path)
+	if archive.Category != archivecategory.Undefined {
+		// The autodetected category is still reported, so that the detection can be improved
+		fmt.Printf("Category for %s: %s from the indirect file (autodetected %s)\n", archive.Path, archive.Category, detected)
+		detected.Category = archive.Category
+	} else {
+		for _, problem := range detected.Problems {
+			exitcode.WarningAt("category-problem", archive.Path, "%s in %s\n", problem, archive.Path)
 		}
-
-		// Check if the current path is a directory
-		if info.IsDir() {
-			// Mark that we have encountered a directory
-			containsDir = true
-			fmt.Printf("WARNING Found subdirectory %s in %s\n", path, subdir)
-			return nil
+		if programFlags.Verbose {
+			fmt.Printf("Category for %s: %s\n", archive.Path, detected)
 		}
+	}
+	programFlags.CaseSensitive = archiveFS.CaseSensitive()
+	if programFlags.Verbose {
+		fmt.Printf("Case-sensitive filesystem for %s: %t\n", archive.Path, programFlags.CaseSensitive)
+	}
 
-		// All files in HTML/ should have completely uppercase names
-		// if strings.ToUpper(path) != path {
-		//	fmt.Printf("WARNING Found not-all-uppercase file %s in %s\n", path, subdir)
-		//}
+	switch detected.Category {
+	case archivecategory.Undefined:
+		exitcode.ErrorAt("undefined-category", archive.Path, "Cannot process undefined category for %s\n", archive.Path)
+	case archivecategory.CSV:
+		fmt.Printf("Cannot process CSV category for %s\n", archive.Path)
+	case archivecategory.Regular:
+		return ParseIndexHtml(archiveFS, "index.htm", archive.VolumeName, fileExceptions, md5Store, programFlags)
+	case archivecategory.HTML:
+		return ProcessCategoryContents(archiveFS, archive, "INDEX.HTM", "HTML", indexhtml.LayoutHTMLContents, fileExceptions, md5Store, programFlags)
+	case archivecategory.Metadata:
+		return ProcessCategoryContents(archiveFS, archive, "index.htm", "metadata", indexhtml.LayoutMetadataContents, fileExceptions, md5Store, programFlags)
+	case archivecategory.Custom:
+		return ProcessCategoryCustom(archiveFS, archive, fileExceptions, md5Store, programFlags)
+	}
+	return nil
+}
 
-		// TODO
-		// All files in HTML/ should appear in links
-		// relativePath, err := filepath.Rel(subdir, path)
-		//relativePath := path
-		//if !links.Contains(relativePath) {
-		//	fmt.Printf("WARNING Found not-all-uppercase file %s in %\n", path, subdir)
-		//}
+// This function processes an archive whose top-level index file only contains links to further index files,
+// all of which live in a single flat subdirectory (HTML/ or metadata/). Each of those index files is then parsed
+// as a list of documents.
+func ProcessCategoryContents(archiveFS archivefs.FS, archive PathAndVolume, indexName string, subdirName string, layout indexhtml.Layout, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
+	index := ReadIndexHtml(archiveFS, indexName, layout)
 
-		return nil
-	})
+	if programFlags.Verbose {
+		fmt.Printf("Found %d links in %s\n", len(index.SubIndexes), archiveFS.Location(indexName))
+	}
 
 	documentsMap := make(map[string]Document)
 
+	// The subdirectory holding the index files is expected to be flat
+	subdirectories, err := indexhtml.FindSubdirectories(archiveFS, subdirName)
 	if err != nil {
 		fmt.Println("Error walking the path:", err)
 		return documentsMap
 	}
+	for _, subdirectory := range subdirectories {
+		exitcode.WarningAt("unexpected-subdirectory", subdirectory, "WARNING Found subdirectory %s in %s\n", subdirectory, subdirName)
+	}
+	if len(subdirectories) > 0 {
+		fmt.Printf("%s/ contains directories.\n", subdirName)
+	}
 
-	// Report whether any directories were found
-	if containsDir {
-		fmt.Println("metadata/ contains directories.")
+	// Every file in the subdirectory should be linked from the top-level index, and every link should name a file
+	check, err := indexhtml.CheckSubIndexes(archiveFS, subdirName, index.SubIndexes)
+	if err != nil {
+		fmt.Println("Error walking the path:", err)
+		return documentsMap
+	}
+	for _, file := range check.Unreferenced {
+		exitcode.WarningAt("unreferenced-index", archiveFS.Location(file), "WARNING: %s is not linked from %s\n", archiveFS.Location(file), indexName)
+	}
+	for _, link := range check.Dangling {
+		exitcode.WarningAt("dangling-index", archiveFS.Location(indexName), "WARNING: %s links to %s, which does not exist\n", archiveFS.Location(indexName), link)
+	}
+	if programFlags.Verbose || (len(check.Unreferenced) > 0) || (len(check.Dangling) > 0) {
+		fmt.Printf("Volume %s: %d sub-indexes linked from %s, %d files in %s/ not linked, %d links to missing files\n", archive.VolumeName, len(check.Resolved), indexName, len(check.Unreferenced), subdirName, len(check.Dangling))
 	}
 
 	// For each link ... process it
-	for _, idx := range links {
-		extraDocumentsMap := ParseIndexHtml(archive.Path+idx, archive.VolumeName, archive.Path, fileExceptions, md5Store, programFlags)
+	for _, idx := range index.SubIndexes {
+		subIndex, found := check.Resolved[idx]
+		if !found {
+			continue
+		}
+		extraDocumentsMap := ParseIndexHtml(archiveFS, subIndex, archive.VolumeName, fileExceptions, md5Store, programFlags)
 		if programFlags.Verbose {
 			for i, doc := range extraDocumentsMap {
 				fmt.Println("doc", i, "=>", doc)
@@ -477,84 +807,84 @@ func ProcessCategoryMetadata(archive PathAndVolume, fileExceptions *FileHandling
 		for k, v := range extraDocumentsMap {
 			val, key_exists := documentsMap[k]
 			if key_exists {
-				var _ = val
-				fmt.Printf("WARNING(3): Document [%s] already exists but being overwritten (was %v)\n", k, val)
+				if (v.Md5 != "") && (v.Md5 == val.Md5) {
+					if programFlags.Verbose {
+						fmt.Printf("WARNING(2a): Document [%s] already exists, identical to original %v (was %v)\n", k, v, val)
+					}
+					v, _ = programFlags.DuplicatePolicy.Resolve(val, v)
+				} else {
+					exitcode.WarningAt("duplicate-document", "", "WARNING(2): Document [%s] already exists but being overwritten by %v (was %v)\n", k, v, val)
+				}
 			}
 			documentsMap[k] = v
 		}
 	}
-
 	return documentsMap
 }
 
 // This function processes the one local archive that has an index.htm that both contains links to actual documents but also
 // to further .htm files which also contain links to actual documents. Any .htm files in these further .htm files are not
 // processed as contains of links but as actual documents.
-
-func ProcessCategoryCustom(archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
-
-	// Read index.htm
-	indexPath := archive.Path + "index.htm"
-	bytes, err := os.ReadFile(indexPath)
-	if err != nil {
-		log.Fatal(err)
-	}
+func ProcessCategoryCustom(archiveFS archivefs.FS, archive PathAndVolume, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
+	indexPath := archiveFS.Location("index.htm")
+	index := ReadIndexHtml(archiveFS, "index.htm", indexhtml.LayoutCustom)
 
 	documentsMap := make(map[string]Document)
+	pendingExif := make(map[string]string)
 
-	// Build a list of links found in index.htm
-	var links []string
-	re := regexp.MustCompile(`(?ms)<TD>\s*<A HREF=\"(.*?)\">\s+(.*?)<\/A>\s*?<TD>\s*(.*?)\s*</TR>`)
-	matches := re.FindAllStringSubmatch(string(bytes), -1)
-	if len(matches) == 0 {
-		log.Fatalf("No matches found in %s", indexPath)
-	} else {
-		for _, v := range matches {
-			target := v[1]
-			partNum := v[2]
-			title := v[3]
-			if strings.HasSuffix(target, ".htm") {
-				links = append(links, v[1])
-			} else {
-				fullFilepath := archive.Path + target
-				absoluteFilepath, _ := filepath.Abs(fullFilepath)
-				modifiedVolumePath := absoluteFilepath[len(archive.Path):]
-				documentPath := "file:///" + "DEC_0040" + "/" + modifiedVolumePath
-				// fmt.Println("full=[", fullFilepath, "] abs=[", absoluteFilepath, "] mod=[", modifiedVolumePath, "] a.P=[", archive.Path, "]")
-				md5Checksum := ""
-				if programFlags.GenerateMD5 {
-					md5Checksum, err = CalculateMd5Sum(archive.VolumeName+"//"+modifiedVolumePath, fullFilepath, md5Store, programFlags.Verbose)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-				newDoc := BuildNewLocalDocument(title, partNum, archive.Path+target, documentPath, md5Checksum, programFlags.ReadEXIF)
-				newDoc.Collection = "local:" + archive.VolumeName
-				key := md5Checksum
-				if key == "" {
-					key = partNum + "~" + newDoc.Format
-					if key == "" {
-						key = title + "~" + newDoc.Format
-					}
+	// Documents linked directly from index.htm
+	for _, entry := range index.Entries {
+		modifiedVolumePath := path.Clean(entry.Target)
+		fullFilepath := archiveFS.Location(modifiedVolumePath)
+		if !programFlags.Limits.FileSelectedFS(archiveFS, modifiedVolumePath) {
+			continue
+		}
+		documentPath := "file:///" + "DEC_0040" + "/" + modifiedVolumePath
+		newDoc, resumed := programFlags.Progress.Resumed(documentPath)
+		if !resumed {
+			var checksums hashing.Checksums
+			var err error
+			if programFlags.GenerateMD5 {
+				checksums, err = CalculateChecksums(archive.VolumeName+"//"+modifiedVolumePath, archiveFS, modifiedVolumePath, md5Store, programFlags.GenerateSha256, programFlags.Verbose)
+				if err != nil {
+					fileExceptions.ProblemFilenames.Add(fullFilepath, fmt.Sprintf("cannot compute MD5: %s", err))
+					continue
 				}
-				documentsMap[key] = newDoc
+			}
+			newDoc, err = BuildNewLocalDocument(entry.Title, entry.PartNum, archiveFS, modifiedVolumePath, documentPath, checksums.Md5)
+			if err != nil {
+				fileExceptions.ProblemFilenames.Add(fullFilepath, err.Error())
+				continue
+			}
+			newDoc.Sha256 = checksums.Sha256
+			newDoc.Collection = "local:" + archive.VolumeName
+			newDoc.VolumeID = archive.VolumeName
+			RunExecHook(&newDoc, archiveFS, modifiedVolumePath, programFlags)
+		}
+		programFlags.Progress.Add(newDoc)
+		md5Checksum := newDoc.Md5
+		key := md5Checksum
+		if key == "" {
+			key = entry.PartNum + "~" + newDoc.Format
+			if key == "" {
+				key = entry.Title + "~" + newDoc.Format
 			}
 		}
+		documentsMap[key] = newDoc
+		if programFlags.ReadEXIF {
+			pendingExif[key] = modifiedVolumePath
+		}
 	}
+	AddPdfMetadata(archiveFS, documentsMap, pendingExif, programFlags)
 
 	if programFlags.Verbose {
-		fmt.Printf("Found %d links in %s\n", len(links), indexPath)
-	}
-
-	if err != nil {
-		fmt.Println("Error walking the path:", err)
-		return documentsMap
+		fmt.Printf("Found %d links in %s\n", len(index.SubIndexes), indexPath)
 	}
 
 	// Process each .htm link
-	for _, idx := range links {
+	for _, idx := range index.SubIndexes {
 		// Link in index.htm ends in .htm, so process it as a container of links to documents
-		extraDocumentsMap := ParseIndexHtml(archive.Path+idx, archive.VolumeName, archive.Path, fileExceptions, md5Store, programFlags)
+		extraDocumentsMap := ParseIndexHtml(archiveFS, path.Clean(idx), archive.VolumeName, fileExceptions, md5Store, programFlags)
 		if programFlags.Verbose {
 			for i, doc := range extraDocumentsMap {
 				fmt.Println("doc", i, "=>", doc)
@@ -564,8 +894,7 @@ func ProcessCategoryCustom(archive PathAndVolume, fileExceptions *FileHandlingEx
 		for k, v := range extraDocumentsMap {
 			val, key_exists := documentsMap[k]
 			if key_exists {
-				var _ = val
-				fmt.Printf("WARNING(3): Document [%s] already exists but being overwritten (was %v)\n", k, val)
+				exitcode.WarningAt("duplicate-document", "", "WARNING(3): Document [%s] already exists but being overwritten (was %v)\n", k, val)
 			}
 			documentsMap[k] = v
 		}
@@ -574,108 +903,14 @@ func ProcessCategoryCustom(archive PathAndVolume, fileExceptions *FileHandlingEx
 	return documentsMap
 }
 
-// Given the path to the root of a document archive, this function works out the
-// category that the archive falls into and returns the result.
-// The category will be used to determine how to process the archive to extract document information.
-func DetermineCategory(archiveRoot string) ArchiveCategory {
-	// Make sure that archiveRoot has a trailing /
-	if archiveRoot[len(archiveRoot)-1:] != "/" {
-		archiveRoot += "/"
-	}
-
-	found_index_dot_htm := true
-	if _, err := os.Stat(archiveRoot + "index.htm"); os.IsNotExist(err) {
-		found_index_dot_htm = false
-	}
-
-	found_INDEX_dot_HTM := true
-	if _, err := os.Stat(archiveRoot + "INDEX.HTM"); os.IsNotExist(err) {
-		found_INDEX_dot_HTM = false
-	}
-
-	found_custom_indicator := true
-	if _, err := os.Stat(archiveRoot + "DEC_0040.CRC"); os.IsNotExist(err) {
-		found_custom_indicator = false
-	}
-
-	found_dir_HTML := SubdirectoryExists(archiveRoot + "HTML")
-	found_dir_metadata := SubdirectoryExists(archiveRoot + "metadata")
-
-	var category ArchiveCategory = AC_Undefined
-
-	valid := true
-
-	if found_INDEX_dot_HTM {
-		if !found_dir_HTML {
-			fmt.Printf("found INDEX.HTM but no /HTML in %s\n", archiveRoot)
-			valid = false
-		}
-		if found_index_dot_htm || found_dir_metadata || found_custom_indicator {
-			fmt.Printf("found INDEX.HTM with one or more of index.htm, metdata/ or DEC_0040.CRC in %s\n", archiveRoot)
-			valid = false
-		}
-		if valid {
-			category = AC_HTML
-		}
-	} else if found_dir_HTML {
-		fmt.Printf("found /HTML but no INDEX.HTM in %s\n", archiveRoot)
-		valid = false
-	}
-
-	if !found_index_dot_htm && category != AC_HTML {
-		fmt.Printf("No index.htm found in %s\n", archiveRoot)
-		valid = false
-	}
-
-	if found_dir_metadata {
-		if found_custom_indicator {
-			fmt.Printf("Found both metadata/ and DEC_0040.CRC in %s\n", archiveRoot)
-			valid = false
-		}
-		if valid {
-			category = AC_Metadata
-		}
-	}
-
-	if found_custom_indicator {
-		if valid {
-			category = AC_Custom
-		}
-	}
-
-	if valid && category == AC_Undefined {
-		category = AC_Regular
-	}
-
-	// fmt.Printf("index.htm: %-7t  INDEX.HTM: %-7t /HTML: %-7t /metadata: %-7t custom: %-7t cat: %-12s in %s\n", found_index_dot_htm, found_INDEX_dot_HTM, found_dir_HTML, found_dir_metadata, found_custom_indicator, category, archiveRoot)
-
-	return category
-}
-
-// Returns true if the specified path is a subdirectory
-func SubdirectoryExists(path string) bool {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Does not exist at all, either as a file or as a directory
-		return false
-	} else {
-		// Check that it is actually a directory
-		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-			// Confirmed to be a path
-			return true
-		} else {
-			// Exists but is a file not a path
-			return false
-		}
-	}
-
-}
-
 // Each line of the indirect file consist of:
 //
-//	archive: full-path-to-archive-root archive-name
+//	archive: full-path-to-archive-root archive-name [category=regular|html|metadata|custom|csv] [expected-count=N]
 //
 // If full-path-to-HTML-index starts with a double quote, then it ends with one too.
 // Note there must be exactly one space between the full-path and the prefix.
+// The optional category= bypasses the autodetection of the volume's category (see internal/archivecategory), and
+// expected-count= declares how many documents the volume's index lists, so that a parsing regression is caught.
 func ParseIndirectFile(indirectFile string) ([]IndirectFileEntry, error) {
 	var result []IndirectFileEntry
 
@@ -729,6 +964,8 @@ func ParseIndirectFile(indirectFile string) ([]IndirectFileEntry, error) {
 						// Handle unknown types
 						fmt.Printf("Unknown type: %v\n", reflect.TypeOf(v))
 					}
+				} else {
+					exitcode.Warning("WARNING: ignoring line %d of %s: %s\n", lineNumber, indirectFile, err)
 				}
 
 				break
@@ -758,17 +995,31 @@ func IndirectFileProcessPathAndVolume(line string, lineNumber int) (interface{},
 	}
 
 	q0 := StripOptionalLeadingAndTrailingDoubleQuotes(quotedString[0])
-	switch len(quotedString) {
-	case 2:
-		return PathAndVolume{Path: q0, VolumeName: quotedString[1]}, nil
-	case 0:
-	case 1:
-		return result, fmt.Errorf("indirect file line %d, too few elements: %d", lineNumber, len(quotedString))
-	default:
-		return result, fmt.Errorf("indirect file line %d, too many elements: %d", lineNumber, len(quotedString))
+	result = PathAndVolume{Path: q0, VolumeName: quotedString[1]}
+	// Anything after the volume name is an option
+	for _, option := range quotedString[2:] {
+		name, value, found := strings.Cut(option, "=")
+		if !found {
+			return PathAndVolume{}, fmt.Errorf("indirect file line %d, expected OPTION=VALUE, found %s", lineNumber, option)
+		}
+		switch name {
+		case "category":
+			category, err := archivecategory.Parse(value)
+			if err != nil {
+				return PathAndVolume{}, fmt.Errorf("indirect file line %d, %w", lineNumber, err)
+			}
+			result.Category = category
+		case "expected-count":
+			count, err := strconv.Atoi(value)
+			if (err != nil) || (count < 1) {
+				return PathAndVolume{}, fmt.Errorf("indirect file line %d, expected-count must be a positive number, found %s", lineNumber, value)
+			}
+			result.Expected = count
+		default:
+			return PathAndVolume{}, fmt.Errorf("indirect file line %d, unknown option %s (expected category or expected-count)", lineNumber, name)
+		}
 	}
-
-	return result, fmt.Errorf("indirect file line %d, too many elements: %d", lineNumber, len(quotedString))
+	return result, nil
 }
 
 // This function is called to indicate that a specific filepath refers to a file that is expected not to exist.
@@ -798,166 +1049,182 @@ func IndirectFileProcessSubstituteFilepath(text string, lineNumber int) (interfa
 	return result, nil
 }
 
-// The index HTML files written to the DVDs are almost all in one of two (similar) formats.
+// Reads an index HTML file, warning about each table row that cannot be read. An index in which nothing can be read
+// is reported as an error and gives nothing, so that one badly formed file does not stop the run.
+func ReadIndexHtml(archiveFS archivefs.FS, indexName string, layout indexhtml.Layout) indexhtml.Index {
+	filename := archiveFS.Location(indexName)
+	index, err := indexhtml.ReadFile(archiveFS, indexName, layout)
+	for _, skip := range index.Skipped {
+		exitcode.WarningAt("malformed-index-row", filename, "WARNING: %s line %d: cannot read index row, skipped: %s\n", filename, skip.Line, skip.Text)
+	}
+	if errors.Is(err, indexhtml.ErrNoMatches) {
+		exitcode.ErrorAt("unreadable-index", filename, "ERROR: %s: no links could be read as %s\n", filename, layout)
+	} else if err != nil {
+		exitcode.Fatal(err)
+	}
+	return index
+}
+
+// The index HTML files written to the DVDs are almost all in one of two (similar) formats (see indexhtml.LayoutDocuments).
 // This function parses any such HTML file to produce a list of files that the index HTML links to
 // and the associated part number and title recorded in the index HTML.
 // If required then an MD5 checksum is generated and PDF metadata is extracted and recorded.
-func ParseIndexHtml(filename string, volume string, root string, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
-
+// indexName is the "/"-separated path of the index HTML within the archive.
+func ParseIndexHtml(archiveFS archivefs.FS, indexName string, volume string, fileExceptions *FileHandlingExceptions, md5Store *persistentstore.Store[string, string], programFlags ProgamFlags) map[string]Document {
+	filename := archiveFS.Location(indexName)
 	if programFlags.Verbose {
 		fmt.Println("Processing index for ", filename)
 	}
-	path := filepath.Dir(filename)
-	bytes, err := os.ReadFile(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
+	indexDir := path.Dir(indexName)
+	index := ReadIndexHtml(archiveFS, indexName, indexhtml.LayoutDocuments)
 
 	documentsMap := make(map[string]Document)
+	pendingExif := make(map[string]string) // document key => name in archiveFS, for documents whose PDF metadata is still to be read
 
-	// Each entry we care about looks like this:
-	//	<TR VALIGN=TOP>
-	//	<TD> <A HREF="decmate/ssm.txt"> DEC-S8-OSSMB-A-D
-	//	<TD> OS/8 SOFTWARE SUPPORT MANUAL
-	//	</TR>
-	//
-	// Exceptionally, an entry in DEC_0002 looks like this:
-	// <TR> <TD VALIGN=TOP>
-	// <A HREF="../manuals/internal/pvaxfw.pdf"> PVAX FW </A>
-	// <TD> Functional Specification for PVAX0 System Firmware Rev 0.3</TR>
-
-	re := regexp.MustCompile(`(?ms)<TR(?:>\s*<TD)?\s+VALIGN=TOP>.*?(?:<TD>)?\s*<A HREF=\"(.*?)\">\s+(.*?)(?:</A>)?\s+<TD>\s+(.*?)</TR>`)
-	title_matches := re.FindAllStringSubmatch(string(bytes), -1)
-	if len(title_matches) == 0 {
-		log.Fatal("No matches found")
-	} else {
-		if programFlags.Verbose {
-			fmt.Println("Found", len(title_matches), "documents in HTML")
+	if programFlags.Verbose {
+		fmt.Println("Found", len(index.Entries), "documents in HTML")
+	}
+	for _, entry := range index.Entries {
+		pathInVolumerelativetoHTML := entry.Target
+		partNumber := entry.PartNum
+		title := entry.Title
+		modifiedVolumePathInHTML := path.Join(indexDir, pathInVolumerelativetoHTML)
+		fullFilepath := archiveFS.Location(modifiedVolumePathInHTML)
+
+		candidateFile, err := FindCandidateFiles(archiveFS, modifiedVolumePathInHTML, programFlags.CaseSensitive)
+		if err != nil {
+			exitcode.Fatal(err)
 		}
-		for _, match := range title_matches {
-			if len(match) != 4 {
-				log.Fatal("Bad match")
-			} else {
-				pathInVolumerelativetoHTML := match[1]
-				partNumber := strings.TrimSpace(match[2])
-				title := TidyDocumentTitle(match[3])
-				fullFilepath := path + "/" + pathInVolumerelativetoHTML
-				absoluteFilepath, err := filepath.Abs(fullFilepath)
-				modifiedVolumePathInHTML := absoluteFilepath[len(root):]
-				if err != nil {
-					log.Fatal(err)
-				}
+		if len(candidateFile) == 0 {
 
-				cifp := BuildCaseInsensitivePathGlob(absoluteFilepath)
-				candidateFile, err := filepath.Glob(cifp)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if len(candidateFile) == 0 {
-
-					// See if the missing file has a substitute filepath, and if so try using that
-					fileFound := false
-					for idx, v := range fileExceptions.FileSubstitutes {
-						if v.MistypedFilepath == modifiedVolumePathInHTML {
-							if programFlags.Verbose {
-								fmt.Printf("Found in mistyping [%s] in fileExceptions and swapping for %s\n", modifiedVolumePathInHTML, v.ActualFilepath)
-							}
-							fullFilepath = path + "/" + v.ActualFilepath
-							absoluteFilepath, _ = filepath.Abs(fullFilepath)
-							cifp := BuildCaseInsensitivePathGlob(absoluteFilepath)
-							candidateFile, err = filepath.Glob(cifp)
-							if err != nil {
-								log.Fatal(err)
-							}
-							if len(candidateFile) == 0 {
-								fmt.Printf("WARNING: Found mistyping [%s] in fileExceptions but swapping for %s (%s), file still not found\n", modifiedVolumePathInHTML, v.ActualFilepath, fullFilepath)
-								continue
-							} else {
-								if programFlags.Verbose {
-									fmt.Printf("File found after fixing bad path [%s]  to be %s (%s) in %s\n", modifiedVolumePathInHTML, v.ActualFilepath, fullFilepath, filename)
-								}
-								fileFound = true
-								// Swap the last element into the slot occupied by the now used and to-be-discarded element, then shorten by one
-								// This would be quicker (which won't matter in this use case) and is simpler for me to understand 9which does!)
-								fileExcLen := len(fileExceptions.FileSubstitutes)
-								fileExceptions.FileSubstitutes[idx] = fileExceptions.FileSubstitutes[fileExcLen-1]
-								fileExceptions.FileSubstitutes = fileExceptions.FileSubstitutes[:fileExcLen-1]
-								break
-							}
+			// See if the missing file has a substitute filepath, and if so try using that
+			fileFound := false
+			for idx, v := range fileExceptions.FileSubstitutes {
+				if v.MistypedFilepath == modifiedVolumePathInHTML {
+					if programFlags.Verbose {
+						fmt.Printf("Found in mistyping [%s] in fileExceptions and swapping for %s\n", modifiedVolumePathInHTML, v.ActualFilepath)
+					}
+					actualFilepath := path.Join(indexDir, v.ActualFilepath)
+					fullFilepath = archiveFS.Location(actualFilepath)
+					candidateFile, err = FindCandidateFiles(archiveFS, actualFilepath, programFlags.CaseSensitive)
+					if err != nil {
+						exitcode.Fatal(err)
+					}
+					if len(candidateFile) == 0 {
+						exitcode.WarningAt("mistyped-filename", fullFilepath, "WARNING: Found mistyping [%s] in fileExceptions but swapping for %s (%s), file still not found\n", modifiedVolumePathInHTML, v.ActualFilepath, fullFilepath)
+						continue
+					} else {
+						if programFlags.Verbose {
+							fmt.Printf("File found after fixing bad path [%s]  to be %s (%s) in %s\n", modifiedVolumePathInHTML, v.ActualFilepath, fullFilepath, filename)
 						}
+						fileFound = true
+						// Swap the last element into the slot occupied by the now used and to-be-discarded element, then shorten by one
+						// This would be quicker (which won't matter in this use case) and is simpler for me to understand 9which does!)
+						fileExcLen := len(fileExceptions.FileSubstitutes)
+						fileExceptions.FileSubstitutes[idx] = fileExceptions.FileSubstitutes[fileExcLen-1]
+						fileExceptions.FileSubstitutes = fileExceptions.FileSubstitutes[:fileExcLen-1]
+						break
 					}
+				}
+			}
 
-					// If missing file has not been substituted, see if it is in the set of "missing files"
-					fileTrulyMissing := true
-					if !fileFound {
-
-						for idx, v := range fileExceptions.MissingFiles {
-							if v.Filepath == modifiedVolumePathInHTML {
-								fileTrulyMissing = false
-								fileExcLen := len(fileExceptions.MissingFiles)
-								fileExceptions.MissingFiles[idx] = fileExceptions.MissingFiles[fileExcLen-1]
-								fileExceptions.MissingFiles = fileExceptions.MissingFiles[:fileExcLen-1]
-							}
-						}
+			// If missing file has not been substituted, see if it is in the set of "missing files"
+			fileTrulyMissing := true
+			if !fileFound {
 
-						if fileTrulyMissing {
-							fmt.Printf("Missing file not mentioned in indirect-file\n")
-						}
+				for idx, v := range fileExceptions.MissingFiles {
+					if v.Filepath == modifiedVolumePathInHTML {
+						fileTrulyMissing = false
+						fileExcLen := len(fileExceptions.MissingFiles)
+						fileExceptions.MissingFiles[idx] = fileExceptions.MissingFiles[fileExcLen-1]
+						fileExceptions.MissingFiles = fileExceptions.MissingFiles[:fileExcLen-1]
 					}
+				}
 
-					// If the missing file is still missing (i.e. not found even if a substitue is available) then skip to avoid generating a document entry
-					if !fileFound {
-						if fileTrulyMissing {
-							log.Printf("MISSING file: %s [%s] linked from %s\n", fullFilepath, modifiedVolumePathInHTML, filename)
-						}
-						continue
-					}
+				if fileTrulyMissing {
+					exitcode.WarningAt("unlisted-missing-file", fullFilepath, "Missing file not mentioned in indirect-file\n")
+				}
+			}
 
-				} else if len(candidateFile) != 1 {
-					log.Fatal("Too many files found:", candidateFile)
+			// If the missing file is still missing (i.e. not found even if a substitue is available) then skip to avoid generating a document entry
+			if !fileFound {
+				if fileTrulyMissing {
+					log.Printf("MISSING file: %s [%s] linked from %s\n", fullFilepath, modifiedVolumePathInHTML, filename)
+					events.Emit(events.SeverityWarning, "missing-file", fullFilepath, fmt.Sprintf("MISSING file: %s linked from %s", modifiedVolumePathInHTML, filename))
 				}
+				continue
+			}
 
-				// Find the actal pathname withing the volume rather than whatever might have been specified in an HTML file 9which may be the wrong case)
-				modifiedVolumePath := candidateFile[0][len(root):]
+		} else if len(candidateFile) != 1 {
+			exitcode.Fatal("Too many files found:", candidateFile)
+		}
 
-				// If requested, find the file's MD5 checksum
-				md5Checksum := ""
-				if programFlags.GenerateMD5 {
-					md5Checksum, err = CalculateMd5Sum(volume+"//"+modifiedVolumePath, candidateFile[0], md5Store, programFlags.Verbose)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
+		// Find the actal pathname withing the volume rather than whatever might have been specified in an HTML file 9which may be the wrong case)
+		modifiedVolumePath := candidateFile[0]
+		candidateFilepath := archiveFS.Location(modifiedVolumePath)
 
-				documentRelativePath := "file:///" + volume + "/" + modifiedVolumePath
-				newDocument := BuildNewLocalDocument(title, partNumber, candidateFile[0], documentRelativePath, md5Checksum, programFlags.ReadEXIF)
-				newDocument.Collection = "local:" + volume
+		if !programFlags.Limits.FileSelectedFS(archiveFS, modifiedVolumePath) {
+			continue
+		}
 
-				key := md5Checksum
-				if key == "" {
-					key = partNumber + "~" + newDocument.Format
-					if key == "" {
-						key = title + "~" + newDocument.Format
-					}
+		documentRelativePath := "file:///" + volume + "/" + modifiedVolumePath
+		fileExceptions.ProblemFilenames.Check(modifiedVolumePath)
+
+		// A document found before the previous run was interrupted is reused as it stands
+		newDocument, resumed := programFlags.Progress.Resumed(documentRelativePath)
+		if !resumed {
+			// If requested, find the file's MD5 checksum
+			var checksums hashing.Checksums
+			if programFlags.GenerateMD5 {
+				checksums, err = CalculateChecksums(volume+"//"+modifiedVolumePath, archiveFS, modifiedVolumePath, md5Store, programFlags.GenerateSha256, programFlags.Verbose)
+				if err != nil {
+					fileExceptions.ProblemFilenames.Add(candidateFilepath, fmt.Sprintf("cannot compute MD5: %s", err))
+					continue
 				}
+			}
+			newDocument, err = BuildNewLocalDocument(title, partNumber, archiveFS, modifiedVolumePath, documentRelativePath, checksums.Md5)
+			if err != nil {
+				fileExceptions.ProblemFilenames.Add(candidateFilepath, err.Error())
+				continue
+			}
+			newDocument.Sha256 = checksums.Sha256
+			newDocument.Collection = "local:" + volume
+			newDocument.VolumeID = volume
+			RunExecHook(&newDocument, archiveFS, modifiedVolumePath, programFlags)
+		}
+		programFlags.Progress.Add(newDocument)
+		md5Checksum := newDocument.Md5
+
+		key := md5Checksum
+		if key == "" {
+			key = partNumber + "~" + newDocument.Format
+			if key == "" {
+				key = title + "~" + newDocument.Format
+			}
+		}
 
-				// If a duplicate is found, keep the previous entry
-				if _, ok := documentsMap[key]; ok {
-					// If the duplicated entries share the same filepath, then the same file is linked to
-					// more than once. This is not a true "conflicting" duplicate, so suppress the report.
-					if newDocument.Filepath != documentsMap[key].Filepath {
-						previousFilePath := documentsMap[key].Filepath
-						// TODO here should warn if warning set and should count duplicates
-						// TODO fmt.Println("WARNING(1) Duplicate entry for ", key, " path: ", newDocument.Filepath, " previous: ", previousFilePath)
-						newKey := key + "DUPLICATE" + strings.Replace(previousFilePath, "/", "_", 20)
-						documentsMap[newKey] = newDocument
-					}
-				} else {
-					documentsMap[key] = newDocument
+		// If a duplicate is found, keep the previous entry
+		if _, ok := documentsMap[key]; ok {
+			// If the duplicated entries share the same filepath, then the same file is linked to
+			// more than once. This is not a true "conflicting" duplicate, so suppress the report.
+			if newDocument.Filepath != documentsMap[key].Filepath {
+				previousFilePath := documentsMap[key].Filepath
+				// TODO here should warn if warning set and should count duplicates
+				// TODO fmt.Println("WARNING(1) Duplicate entry for ", key, " path: ", newDocument.Filepath, " previous: ", previousFilePath)
+				newKey := key + "DUPLICATE" + strings.Replace(previousFilePath, "/", "_", 20)
+				documentsMap[newKey] = newDocument
+				if programFlags.ReadEXIF {
+					pendingExif[newKey] = modifiedVolumePath
 				}
 			}
+		} else {
+			documentsMap[key] = newDocument
+			if programFlags.ReadEXIF {
+				pendingExif[key] = modifiedVolumePath
+			}
 		}
 	}
+	AddPdfMetadata(archiveFS, documentsMap, pendingExif, programFlags)
 
 	if programFlags.Verbose {
 		fmt.Printf("Returning %d documents after processing HTML in %s\n", len(documentsMap), filename)
@@ -972,36 +1239,129 @@ func ParseIndexHtml(filename string, volume string, root string, fileExceptions
 //
 // title:         document title
 // partNum:       document part number
-// filePath:      path to document
+// archiveFS:     the archive holding the document
+// filePath:      "/"-separated path to document within the archive
 // documentPath:  psudo
 // md5Checksum:   MD5 checksum (may be blank)
-// readExif:      true if PDF metadata should be extracted, false otherwise
-func BuildNewLocalDocument(title string, partNum string, filePath string, documentPath string, md5Checksum string, readExif bool) Document {
-	filestats, err := os.Stat(filePath)
+//
+// PDF metadata is not filled in here: it is slow to extract, so callers gather the documents that need it
+// and call AddPdfMetadata once to extract it in parallel.
+//
+// Any text that is not valid UTF-8 (e.g. Latin-1 in an old HTML index or filename) is escaped so that the YAML remains readable.
+// An error is returned if the file cannot be examined.
+func BuildNewLocalDocument(title string, partNum string, archiveFS fs.FS, filePath string, documentPath string, md5Checksum string) (Document, error) {
+	filestats, err := fs.Stat(archiveFS, filePath)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	pdfMetadata := PdfMetadata{}
-	if readExif {
-		pdfMetadata = pdfmetadata.ExtractPdfMetadata(filePath)
+		return Document{}, err
 	}
 
 	var newDocument Document
 	newDocument.Format = DetermineFileFormat(filePath)
 	newDocument.Size = filestats.Size()
 	newDocument.Md5 = md5Checksum
-	newDocument.Title = strings.TrimSuffix(strings.TrimSpace(title), "\n")
+	newDocument.Title = textnorm.ValidText(strings.TrimSuffix(strings.TrimSpace(title), "\n"))
 	newDocument.PubDate = "" // Not available anywhere
-	newDocument.PartNum = strings.TrimSpace(partNum)
-	newDocument.PdfCreator = pdfMetadata.Creator
-	newDocument.PdfProducer = pdfMetadata.Producer
-	newDocument.PdfVersion = pdfMetadata.Format
-	newDocument.PdfModified = pdfMetadata.Modified
-	newDocument.Filepath = documentPath
+	newDocument.PartNum = textnorm.ValidText(strings.TrimSpace(partNum))
+	newDocument.Filepath = fsutil.EscapeInvalidUTF8(documentPath)
 	newDocument.Collection = "local-archive"
+	document.NormaliseLocations(&newDocument)
+
+	return newDocument, nil
+}
+
+// Runs the --exec hook (if any) for the document whose file is the named file in archiveFS, merging the metadata it
+// supplies into the document. A remote file is fetched to a temporary copy for the command.
+// A failure is reported but the document is kept as it stands.
+func RunExecHook(doc *Document, archiveFS archivefs.FS, name string, programFlags ProgamFlags) {
+	if programFlags.ExecHook == nil {
+		return
+	}
+	filePath := archiveFS.Location(name)
+	localPath, release, err := archivefs.LocalFile(archiveFS, name)
+	if err != nil {
+		exitcode.WarningAt("exec-failed", filePath, "--exec failed for %s: %s", filePath, err)
+		return
+	}
+	defer release()
+	unrecognised, err := programFlags.ExecHook.Process(doc, localPath)
+	if err != nil {
+		exitcode.WarningAt("exec-failed", filePath, "--exec failed for %s: %s", filePath, err)
+	}
+	for _, key := range unrecognised {
+		exitcode.WarningAt("exec-unknown-key", filePath, "--exec printed unrecognised key %q (first seen for %s)", key, filePath)
+	}
+}
+
+// Extracts the PDF metadata for each pending document (a map of document key => name in archiveFS) and records it in the documents map.
+// Up to programFlags.ExifWorkers extractions run concurrently; the results are applied in key order so that the outcome is deterministic.
+// Metadata already in programFlags.ExifCache is used unless programFlags.RefreshExif is set.
+func AddPdfMetadata(archiveFS archivefs.FS, documentsMap map[string]Document, pendingExif map[string]string, programFlags ProgamFlags) {
+	if len(pendingExif) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(pendingExif))
+	for key := range pendingExif {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := make([]string, len(keys))
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = pendingExif[key]
+		cacheKeys[i] = pdfmetadata.CacheKeyFS(documentsMap[key].Md5, archiveFS, names[i])
+	}
+	results := pdfmetadata.ExtractPdfMetadataFSCached(archiveFS, names, cacheKeys, programFlags.ExifCache, programFlags.RefreshExif, programFlags.ExifWorkers)
+
+	for i, key := range keys {
+		doc := documentsMap[key]
+		pdfmetadata.Apply(&doc, results[i])
+		documentsMap[key] = doc
+	}
+}
 
-	return newDocument
+// Finds the file(s) in the archive that match the specified "/"-separated path.
+// On a case-sensitive filesystem the match is performed case-insensitively (see BuildCaseInsensitivePathGlob).
+// On a case-insensitive filesystem there is at most one match; each element of the path is looked up in its directory's
+// listing so that the file is named as it is on disc rather than as the index spelt it.
+// A path that leads outside the archive (e.g. "../x.pdf") matches nothing.
+func FindCandidateFiles(archiveFS fs.FS, filename string, caseSensitive bool) ([]string, error) {
+	if !fs.ValidPath(filename) {
+		return nil, nil
+	}
+	if caseSensitive {
+		return fs.Glob(archiveFS, BuildCaseInsensitivePathGlob(filename))
+	}
+	found := "."
+	for _, element := range strings.Split(filename, "/") {
+		entries, err := fs.ReadDir(archiveFS, found)
+		if errors.Is(err, fs.ErrNotExist) || ((err != nil) && !isDir(archiveFS, found)) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		name := ""
+		for _, entry := range entries {
+			if entry.Name() == element {
+				name = element
+				break
+			}
+			if (name == "") && strings.EqualFold(entry.Name(), element) {
+				name = entry.Name()
+			}
+		}
+		if name == "" {
+			return nil, nil
+		}
+		found = path.Join(found, name)
+	}
+	return []string{found}, nil
+}
+
+// Reports whether name is a directory in fsys
+func isDir(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return (err == nil) && info.IsDir()
 }
 
 // The index HTML files written to the various DVDs were tested on a Windows system, which performs case-insensitive
@@ -1046,49 +1406,56 @@ func DetermineFileFormat(filename string) string {
 			return filetype
 		}
 	}
-	log.Fatal("Unknown filetype: ", filetype)
+	exitcode.Fatal("Unknown filetype: ", filetype)
 	return "???"
 }
 
-// Clean up a document title that has been read from HTML.
-//
-//	o remove leading/trailing whitespace
-//	o remove CRLF
-//	o collapse duplicate whitespace
-//	o replace "<BR><BR>", " <BR>" and "<BR>" with something sensible
-func TidyDocumentTitle(untidyTitle string) string {
-	title := strings.TrimSpace(untidyTitle)
-	title = strings.Replace(title, "\r\n", "", -1)
-	title = strings.Join(strings.Fields(title), " ") // Collapse duplicate whitespace
-	re := regexp.MustCompile(`\s*<BR>(?:\s*<BR>\s*)*\s*`)
-	title = re.ReplaceAllString(title, ". ")
-	return title
-}
-
-// Return the MD5 sum for the specified file.
-// Start by looking up the filename (path) in the cache and return a pre-computed MD5 sum if found.
-// Otherwise, compute the MD5 sum, add the entry to the cache, mark the cache as dirty and return the computed MD5 sum.
-func CalculateMd5Sum(filenameInCache string, fullFilepath string, md5Store *persistentstore.Store[string, string], verbose bool) (string, error) {
-
-	// Lookup the filename (path) in the cache; if found report that as the MD5 sum
-	if md5, found := md5Store.Lookup(filenameInCache); found {
-		if verbose {
-			fmt.Printf("MD5 Store: Found %s for %s\n", md5, filenameInCache)
+// Return the MD5 sum, and if withSha256 is set the SHA-256 sum, for the specified file.
+// Start by looking up the filename (path) in the cache and return the pre-computed sums if found (an entry recorded
+// without a SHA-256 sum only counts if one is not wanted).
+// Otherwise, compute the sums, add the entry to the cache, mark the cache as dirty and return the computed sums.
+func CalculateChecksums(filenameInCache string, archiveFS archivefs.FS, filePath string, md5Store *persistentstore.Store[string, string], withSha256 bool, verbose bool) (hashing.Checksums, error) {
+	// The store is YAML, so the key must be valid UTF-8
+	filenameInCache = fsutil.EscapeInvalidUTF8(filenameInCache)
+
+	// Lookup the filename (path) in the cache; if found report that as the checksums
+	if entry, found := md5Store.Lookup(filenameInCache); found {
+		if checksums := hashing.ParseChecksums(entry); !withSha256 || (checksums.Sha256 != "") {
+			console.Debugf("MD5 Store: Found %s for %s\n", entry, filenameInCache)
+			metrics.Add(metrics.Md5CacheHits, 1)
+			return checksums, nil
 		}
-		return md5, nil
 	}
+	metrics.Add(metrics.Md5CacheMisses, 1)
 
-	// The filename (path) is not in the cache.
-	// Generate the MD5 sum, add the value to the cache and mark the cache as Dirty
-	fileBytes, err := os.ReadFile(fullFilepath)
-	if err != nil {
-		return "", err
+	// Hashing is the slow part of a run, so do not start hashing another file once an interrupt has been received
+	if interrupt.Requested() {
+		return hashing.Checksums{}, interrupt.ErrInterrupted
+	}
+
+	// The filename (path) is not in the cache (or lacks a SHA-256 sum).
+	// Generate the sums, add the value to the cache and mark the cache as Dirty. The archive may already know the MD5
+	// sum, as object storage may, in which case the file need not be read unless its SHA-256 sum is wanted too.
+	var checksums hashing.Checksums
+	md5Checksum, known := archivefs.KnownMd5(archiveFS, filePath)
+	switch {
+	case withSha256:
+		digests, err := hashing.HashFS(archiveFS, filePath, hashing.MD5|hashing.SHA256)
+		if err != nil {
+			return hashing.Checksums{}, err
+		}
+		checksums = hashing.Checksums{Md5: digests.Md5, Sha256: digests.Sha256}
+	case known:
+		checksums.Md5 = md5Checksum
+	default:
+		var err error
+		if checksums.Md5, err = hashing.Md5FS(archiveFS, filePath); err != nil {
+			return hashing.Checksums{}, err
+		}
 	}
-	md5Hash := md5.Sum(fileBytes)
-	md5Checksum := hex.EncodeToString(md5Hash[:])
-	md5Store.Update(filenameInCache, md5Checksum)
-	fmt.Printf("MD5 Store: wrote %s for [%s] (full path %s)\n", md5Checksum, filenameInCache, fullFilepath)
-	return md5Checksum, nil
+	md5Store.Update(filenameInCache, checksums.String())
+	fmt.Printf("MD5 Store: wrote %s for [%s] (full path %s)\n", checksums, filenameInCache, archiveFS.Location(filePath))
+	return checksums, nil
 }
 
 // Helper function to remove leading and trailing double quotes, if present.
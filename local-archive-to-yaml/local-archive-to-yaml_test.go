@@ -1,7 +1,22 @@
 package main
 
 import (
+	"docs-to-yaml/internal/archivecategory"
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/indexhtml"
+	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/testkit"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 // func TestParseIndirectFile(t *testing.T) {
@@ -105,51 +120,6 @@ import (
 
 // }
 
-func TestTidyDocumentTitle(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		// Test case 1: Trim whitespace
-		{"  Hello World  ", "Hello World"}, // Leading and trailing spaces
-
-		// Test case 2: Removing CRLF
-		{"Title\r\nwith CRLF", "Titlewith CRLF"}, // CRLF characters should be removed
-
-		// Test case 3: Collapsing multiple spaces into a single space
-		{"Hello     World", "Hello World"}, // Multiple spaces should collapse
-
-		// Test case 4: Handling <BR> tags
-		{"Hello <BR> World", "Hello. World"},      // Single <BR> should be replaced with ". "
-		{"Hello <BR><BR> World", "Hello. World"},  // Multiple <BR> should be replaced with ". "
-		{"Hello <BR> <BR> World", "Hello. World"}, // Spaces around <BR> should be handled
-		{"Hello World <BR>", "Hello World. "},     // <BR> at the end should be replaced
-
-		// Test case 5: Combination of multiple rules
-		{"  Hello <BR>  World  <BR><BR> !  ", "Hello. World. !"}, // Multiple issues: spaces, <BR>, etc.
-
-		// Test case 6: Empty string
-		{"", ""}, // Empty string should return empty string
-
-		// Test case 7: Only <BR> tags, should replace all <BR> tags with ". "
-		{"<BR><BR><BR>", ". "}, // All <BR> should be replaced with ". "
-
-		// Test case 8: Special case of leading and trailing <BR> tags
-		{"<BR>Hello World<BR>", ". Hello World. "}, // <BR> before and after should be replaced
-
-		// Test case 9: String with no spaces or <BR> tags (no change expected)
-		{"HelloWorld", "HelloWorld"}, // No spaces, no <BR> tags, should remain the same
-	}
-
-	for _, test := range tests {
-		t.Run(test.input, func(t *testing.T) {
-			result := TidyDocumentTitle(test.input)
-			if result != test.expected {
-				t.Errorf("For input '%s', expected '%s' but got '%s'", test.input, test.expected, result)
-			}
-		})
-	}
-}
 func TestStripOptionalLeadingAndTrailingDoubleQuotes(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -175,3 +145,222 @@ func TestStripOptionalLeadingAndTrailingDoubleQuotes(t *testing.T) {
 		})
 	}
 }
+
+func TestIndirectFileProcessPathAndVolume(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected PathAndVolume
+	}{
+		{`/mnt/archive/DEC_0001 DEC_0001`, PathAndVolume{Path: "/mnt/archive/DEC_0001", VolumeName: "DEC_0001"}},
+		{`"/mnt/my archive/DEC_0002" DEC_0002 category=metadata`, PathAndVolume{Path: "/mnt/my archive/DEC_0002", VolumeName: "DEC_0002", Category: archivecategory.Metadata}},
+		{`/mnt/DEC_0004 DEC_0004 expected-count=123 category=html`, PathAndVolume{Path: "/mnt/DEC_0004", VolumeName: "DEC_0004", Category: archivecategory.HTML, Expected: 123}},
+	}
+	for _, test := range tests {
+		if result, err := IndirectFileProcessPathAndVolume(test.line, 1); (err != nil) || (result != test.expected) {
+			t.Errorf("IndirectFileProcessPathAndVolume(%q) = %v, %v", test.line, result, err)
+		}
+	}
+	for _, line := range []string{`/mnt/DEC_0003 DEC_0003 category=dvd`, `/mnt/DEC_0003 DEC_0003 metadata`, `/mnt/DEC_0003 DEC_0003 expected-count=0`, `/mnt/DEC_0003 DEC_0003 size=1`} {
+		if _, err := IndirectFileProcessPathAndVolume(line, 1); err == nil {
+			t.Errorf("IndirectFileProcessPathAndVolume(%q) succeeded", line)
+		}
+	}
+}
+
+func TestFindUnavailableArchives(t *testing.T) {
+	root := t.TempDir()
+	mounted := filepath.Join(root, "DEC_0001")
+	unmounted := filepath.Join(root, "DEC_0002")
+	if err := os.MkdirAll(unmounted, 0755); err != nil {
+		t.Fatalf(`cannot create test directory: %v`, err)
+	}
+	if err := os.MkdirAll(mounted, 0755); err != nil {
+		t.Fatalf(`cannot create test directory: %v`, err)
+	}
+	if err := os.WriteFile(filepath.Join(mounted, "index.htm"), []byte("x"), 0644); err != nil {
+		t.Fatalf(`cannot create test file: %v`, err)
+	}
+
+	entries := []IndirectFileEntry{
+		PathAndVolume{Path: mounted, VolumeName: "DEC_0001"},
+		MissingFile{Filepath: "x.pdf"},
+		PathAndVolume{Path: unmounted, VolumeName: "DEC_0002"},
+		PathAndVolume{Path: filepath.Join(root, "DEC_0003"), VolumeName: "DEC_0003"},
+	}
+	unavailable := FindUnavailableArchives(entries, nil)
+	volumes := make([]string, 0)
+	for volume := range unavailable {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	if !reflect.DeepEqual(volumes, []string{"DEC_0002", "DEC_0003"}) {
+		t.Errorf(`FindUnavailableArchives() = %v`, unavailable)
+	}
+}
+
+func TestFindCandidateFiles(t *testing.T) {
+	fsys := fstest.MapFS{"VAX/KA630.PDF": {Data: []byte("x")}}
+	found, err := FindCandidateFiles(fsys, "vax/ka630.pdf", true)
+	if (err != nil) || !reflect.DeepEqual(found, []string{"VAX/KA630.PDF"}) {
+		t.Errorf(`FindCandidateFiles() = %v, %v`, found, err)
+	}
+	// A case-insensitive match reports the name from the directory listing, not the one asked for
+	for _, name := range []string{"VAX/KA630.PDF", "vax/ka630.pdf"} {
+		found, err = FindCandidateFiles(fsys, name, false)
+		if (err != nil) || !reflect.DeepEqual(found, []string{"VAX/KA630.PDF"}) {
+			t.Errorf(`FindCandidateFiles(%s) = %v, %v`, name, found, err)
+		}
+	}
+	for _, missing := range []string{"vax/ka655.pdf", "../DEC_0002/ka630.pdf", "vax/ka630.pdf/x"} {
+		for _, caseSensitive := range []bool{true, false} {
+			if found, err := FindCandidateFiles(fsys, missing, caseSensitive); (err != nil) || (len(found) != 0) {
+				t.Errorf(`FindCandidateFiles(%s, %t) = %v, %v`, missing, caseSensitive, found, err)
+			}
+		}
+	}
+}
+
+func TestCalculateChecksums(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "fox.txt"), []byte("The quick brown fox jumps over the lazy dog"), 0644)
+	archiveFS, err := archivefs.Open(root)
+	if err != nil {
+		t.Fatalf(`archivefs.Open() failed: %v`, err)
+	}
+	md5Store, _ := persistentstore.Store[string, string]{}.Init("", false, false)
+	md5 := "9e107d9d372bb6826bd81d3542a419d6"
+	sha256 := "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592"
+
+	if checksums, err := CalculateChecksums("DEC_0001//fox.txt", archiveFS, "fox.txt", md5Store, false, false); (err != nil) || (checksums != hashing.Checksums{Md5: md5}) {
+		t.Errorf(`CalculateChecksums() = %+v, %v`, checksums, err)
+	}
+	// An entry without a SHA-256 checksum is hashed again when one is wanted, and then holds both
+	if checksums, err := CalculateChecksums("DEC_0001//fox.txt", archiveFS, "fox.txt", md5Store, true, false); (err != nil) || (checksums != hashing.Checksums{Md5: md5, Sha256: sha256}) {
+		t.Errorf(`CalculateChecksums() with SHA-256 = %+v, %v`, checksums, err)
+	}
+	if entry, _ := md5Store.Lookup("DEC_0001//fox.txt"); entry != md5+" "+sha256 {
+		t.Errorf(`CalculateChecksums() stored %q`, entry)
+	}
+	// Which is then found in the store
+	os.Remove(filepath.Join(root, "fox.txt"))
+	if checksums, err := CalculateChecksums("DEC_0001//fox.txt", archiveFS, "fox.txt", md5Store, true, false); (err != nil) || (checksums.Sha256 != sha256) {
+		t.Errorf(`CalculateChecksums() of a stored entry = %+v, %v`, checksums, err)
+	}
+}
+
+func TestProcessArchiveLayouts(t *testing.T) {
+	for _, category := range []archivecategory.Category{archivecategory.Regular, archivecategory.HTML, archivecategory.Metadata, archivecategory.Custom} {
+		corruptions := []testkit.Corruption{testkit.MissingFile, testkit.UnlistedFile}
+		if category == archivecategory.HTML {
+			corruptions = append(corruptions, testkit.WrongCase)
+		}
+		if category == archivecategory.Custom {
+			corruptions = append(corruptions, testkit.BadChecksum)
+		}
+		t.Run(category.String(), func(t *testing.T) {
+			volume := testkit.Build(t, testkit.Spec{Category: category, Seed: 3233, Corruptions: corruptions})
+			reported := testkit.CaptureEvents(t)
+			md5Store, _ := persistentstore.Store[string, string]{}.Init("", false, false)
+			fileExceptions := FileHandlingExceptions{}
+			documents := ProcessArchive(PathAndVolume{Path: volume.Root, VolumeName: volume.Name}, &fileExceptions, md5Store, ProgamFlags{GenerateMD5: true})
+
+			// A file in the wrong case is still found; a file that is not listed is not a document of the volume; a
+			// checksum file is not consulted
+			expected := volume.Intact(testkit.WrongCase, testkit.BadChecksum)
+			if len(documents) != len(expected) {
+				t.Errorf(`ProcessArchive() found %d documents, want %d`, len(documents), len(expected))
+			}
+			for _, doc := range expected {
+				found, ok := documents[doc.Md5]
+				switch {
+				case !ok:
+					t.Errorf(`ProcessArchive() did not find %s`, doc.Path)
+				case (found.PartNum != doc.PartNum) || (found.Title != doc.Title) || (found.Size != doc.Size):
+					t.Errorf(`ProcessArchive() found %s as %q %q %d`, doc.Path, found.PartNum, found.Title, found.Size)
+				case !strings.EqualFold(found.Filepath, "file:///"+volume.Name+"/"+doc.Path):
+					t.Errorf(`ProcessArchive() found %s at %s`, doc.Path, found.Filepath)
+				}
+			}
+			// A missing file is reported; one listed directly in a Custom volume's index is recorded as a problem
+			missing, _ := volume.Corrupted(testkit.MissingFile)
+			reportedMissing := testkit.HasEvent(reported(), "missing-file", missing.Path)
+			if category == archivecategory.Custom {
+				reportedMissing = slices.ContainsFunc(fileExceptions.ProblemFilenames.Entries, func(problem fsutil.ProblemFilename) bool {
+					return strings.HasSuffix(problem.Path, missing.Path)
+				})
+			}
+			if !reportedMissing {
+				t.Errorf(`ProcessArchive() did not report %s as missing`, missing.Path)
+			}
+		})
+	}
+
+	volume := testkit.Build(t, testkit.Spec{Category: archivecategory.CSV, Seed: 3233})
+	md5Store, _ := persistentstore.Store[string, string]{}.Init("", false, false)
+	if documents := ProcessArchive(PathAndVolume{Path: volume.Root, VolumeName: volume.Name}, &FileHandlingExceptions{}, md5Store, ProgamFlags{GenerateMD5: true}); documents != nil {
+		t.Errorf(`ProcessArchive() of a CSV volume = %v`, documents)
+	}
+}
+
+func TestVolumeProgress(t *testing.T) {
+	volume := testkit.Build(t, testkit.Spec{Category: archivecategory.Regular, Seed: 3157})
+	archive := PathAndVolume{Path: volume.Root, VolumeName: volume.Name}
+	md5Store, _ := persistentstore.Store[string, string]{}.Init("", false, false)
+
+	// A checkpoint is written part way through the volume, holding the documents found so far
+	checkpointFilename := filepath.Join(t.TempDir(), "catalog.yaml.partial")
+	saved := 0
+	progress := &VolumeProgress{timer: checkpoint.NewTimer(checkpoint.Policy{EveryItems: 1})}
+	progress.save = func() {
+		saved += 1
+		SavePartialCatalog(checkpointFilename, map[string]bool{}, map[string]Document{}, progress)
+	}
+	progress.Start(volume.Name)
+	documents := ProcessArchive(archive, &FileHandlingExceptions{}, md5Store, ProgamFlags{GenerateMD5: true, Progress: progress})
+	if (saved != len(documents)) || (len(progress.Documents) != len(documents)) {
+		t.Fatalf(`ProcessArchive() wrote %d checkpoints holding %d documents, for %d documents`, saved, len(progress.Documents), len(documents))
+	}
+	var partial PartialCatalog
+	if found, err := checkpoint.LoadState(checkpointFilename, &partial); !found || (err != nil) || (partial.InProgressVolume != volume.Name) || (len(partial.InProgressDocuments) != len(documents)) {
+		t.Fatalf(`checkpoint holds %q with %d documents: %v`, partial.InProgressVolume, len(partial.InProgressDocuments), err)
+	}
+
+	// On resumption the documents already found are reused rather than made again
+	var reused Document
+	for filepath, doc := range partial.InProgressDocuments {
+		doc.Title = "Found before the interrupt"
+		partial.InProgressDocuments[filepath] = doc
+		reused = doc
+		break
+	}
+	resumed := &VolumeProgress{}
+	resumed.Resume(partial.InProgressVolume, partial.InProgressDocuments)
+	resumed.Start(volume.Name)
+	documents = ProcessArchive(archive, &FileHandlingExceptions{}, md5Store, ProgamFlags{GenerateMD5: true, Progress: resumed})
+	if documents[reused.Md5].Title != reused.Title {
+		t.Errorf(`ProcessArchive() on resumption made %+v again`, documents[reused.Md5])
+	}
+	resumed.Finish()
+	if resumed.Start(volume.Name); len(resumed.Documents) != 0 {
+		t.Errorf(`VolumeProgress.Start() after Finish() reused %d documents`, len(resumed.Documents))
+	}
+}
+
+func TestReadIndexHtmlToleratesMalformedMarkup(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.htm"), []byte("<TABLE>\n<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/a.pdf\"> EK-A\n</TR>\n<TR VALIGN=TOP>\n<TD> <A HREF=\"vax/b.pdf\"> EK-B\n<TD> A manual\n</TR>\n</TABLE>\n"), 0644)
+	os.WriteFile(filepath.Join(root, "other.htm"), []byte("<HTML><BODY><P>Nothing to see</P></BODY></HTML>\n"), 0644)
+	archiveFS, err := archivefs.Open(root)
+	if err != nil {
+		t.Fatalf(`archivefs.Open() failed: %v`, err)
+	}
+	reported := testkit.CaptureEvents(t)
+
+	index := ReadIndexHtml(archiveFS, "index.htm", indexhtml.LayoutDocuments)
+	if (len(index.Entries) != 1) || (index.Entries[0].Target != "vax/b.pdf") || !testkit.HasEvent(reported(), "malformed-index-row", "index.htm") {
+		t.Errorf(`ReadIndexHtml() of an index with a malformed row = %+v`, index)
+	}
+	if index := ReadIndexHtml(archiveFS, "other.htm", indexhtml.LayoutDocuments); (len(index.Entries) != 0) || !testkit.HasEvent(reported(), "unreadable-index", "other.htm") {
+		t.Errorf(`ReadIndexHtml() of an index without links = %+v`, index)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/workspace"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//
+// This program looks after the workspace (see internal/workspace): the directory, by default bin/workspace (or that
+// named by DOCS_TO_YAML_WORKSPACE), where the tools keep the catalogs, stores and reports given workspace names such as
+// ws:catalogs/local. Each time an artifact is written it is kept as a new timestamped file, with a "latest" link to
+// the newest.
+//
+// By default every name in the workspace is listed with the number and size of its artifacts and the time of the
+// latest; --verbose lists each artifact.
+//
+// With --clean the older artifacts of each name are removed, keeping the newest --keep of them (by default 3, including
+// the latest) and any written within --older-than. An artifact newer than the latest, which may still be being
+// written by another tool, is never removed. --dry-run lists what would be removed.
+//
+// To run the program:
+//   go run manage-workspace/manage-workspace.go
+//   go run manage-workspace/manage-workspace.go --clean --keep 2 --older-than 168h
+//
+
+func main() {
+	root := flag.String("root", workspace.Root(), "the workspace directory")
+	clean := flag.Bool("clean", false, "remove the older artifacts of each name")
+	keep := flag.Int("keep", 3, "with --clean, the number of artifacts of each name to keep, including the latest")
+	olderThan := flag.Duration("older-than", 0, "with --clean, remove only artifacts written longer ago than this, e.g. 168h")
+	dryRun := flag.Bool("dry-run", false, "with --clean, list what would be removed without removing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if len(flag.Args()) > 0 {
+		exitcode.UsageError("Unexpected arguments: ", flag.Args())
+	}
+	if *keep < 1 {
+		exitcode.UsageErrorf("--keep must be at least 1, found %d", *keep)
+	}
+
+	if *clean {
+		removed, err := workspace.Clean(*root, *keep, *olderThan, time.Now(), *dryRun)
+		verb := "Removed"
+		if *dryRun {
+			verb = "Would remove"
+		}
+		for _, filename := range removed {
+			if *verbose || *dryRun {
+				fmt.Printf("%s %s\n", verb, filename)
+			}
+		}
+		if err != nil {
+			exitcode.Fatalf("Cannot clean %s: %v", *root, err)
+		}
+		fmt.Printf("%s %d artifacts from %s\n", verb, len(removed), *root)
+		exitcode.Exit()
+	}
+
+	entries, err := workspace.List(*root)
+	if err != nil {
+		exitcode.Fatalf("Cannot list %s: %v", *root, err)
+	}
+	WriteList(os.Stdout, entries, *verbose)
+	exitcode.Exit()
+}
+
+// Writes each name in the workspace with its artifacts (each of them, if verbose is set)
+func WriteList(out io.Writer, entries []workspace.Entry, verbose bool) {
+	for _, entry := range entries {
+		var size int64
+		for _, artifact := range entry.Artifacts {
+			size += artifact.Size
+		}
+		latest := "no latest"
+		if entry.Latest != "" {
+			latest = "latest " + filepath.Base(entry.Latest)
+		}
+		fmt.Fprintf(out, "%-40s %3d artifacts %12d bytes  %s\n", entry.Ref.String(), len(entry.Artifacts), size, latest)
+		if !verbose {
+			continue
+		}
+		for _, artifact := range entry.Artifacts {
+			marker := " "
+			if artifact.Path == entry.Latest {
+				marker = "*"
+			}
+			fmt.Fprintf(out, "  %s %s %12d  %s\n", marker, artifact.Time.Format(time.DateTime), artifact.Size, filepath.Base(artifact.Path))
+		}
+	}
+	fmt.Fprintf(out, "%d names\n", len(entries))
+}
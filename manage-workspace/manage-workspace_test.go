@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/workspace"
+	"strings"
+	"testing"
+)
+
+func TestWriteList(t *testing.T) {
+	t.Setenv(workspace.RootVariable, t.TempDir())
+	for _, content := range []string{"first", "second"} {
+		if _, err := workspace.WriteFile("ws:reports/unique.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := workspace.List(workspace.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	WriteList(&out, entries, true)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if (len(lines) != 4) || !strings.HasPrefix(lines[0], "ws:reports/unique.txt") || !strings.Contains(lines[0], "2 artifacts") || !strings.Contains(lines[0], "11 bytes") {
+		t.Fatalf("WriteList() wrote:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[2], "  *") || (lines[3] != "1 names") {
+		t.Errorf("WriteList() did not mark the latest:\n%s", out.String())
+	}
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"bufio"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/enrich"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/md5url"
+	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/tombstones"
 	"encoding/csv"
 	"flag"
 	"fmt"
@@ -10,8 +17,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 // This program takes the manx database dump and tries to produce a YAML file
@@ -32,7 +37,11 @@ import (
 // * Date of publication
 // * MD5
 //
-// Also produce a pair of URL => MD5
+// Also produce a map of MD5 => URL, as an MD5-to-URL store (see internal/md5url), so that other programs can find an
+// online copy of a file from its checksum.
+//
+// Documents removed on purpose with edit-catalog (see internal/tombstones) are left out, and the enrichment rules
+// (see internal/enrich) are applied to the documents, before the YAML file is written.
 //
 
 // At the moment the table filenames are hard coded as the only publically available SQL dump is from
@@ -113,7 +122,7 @@ type PubHistory struct {
 func parseManxCopyTable(filename string) []Copy {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 	defer file.Close()
 
@@ -132,7 +141,7 @@ func parseManxCopyTable(filename string) []Copy {
 			data, err := r.Read()
 			if err != nil {
 				fmt.Println("Problem with line: [", data_text, "]")
-				log.Fatal(err)
+				exitcode.Fatal(err)
 			}
 
 			// data := strings.Split(data_text, ",")
@@ -164,7 +173,7 @@ func parseManxCopyTable(filename string) []Copy {
 func parseManxPubTable(filename string) map[int]Pub {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 	defer file.Close()
 
@@ -196,7 +205,7 @@ func parseManxPubTable(filename string) map[int]Pub {
 func parseManxPubHistoryTable(filename string) map[int]PubHistory {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 	defer file.Close()
 
@@ -270,6 +279,9 @@ func parseManxPubHistoryTable(filename string) map[int]PubHistory {
 				pubHistory.Part = ""
 			}
 			pubHistory.AltPart = data[8]
+			if pubHistory.AltPart == "NULL" {
+				pubHistory.AltPart = ""
+			}
 			pubHistory.Revision = data[9]
 			pubHistory.PubDate = data[10]
 			if pubHistory.PubDate == "NULL" {
@@ -295,18 +307,20 @@ func parseManxPubHistoryTable(filename string) map[int]PubHistory {
 }
 
 func main() {
-	copyTable := parseManxCopyTable("data/manx-mysql-dump-20100609-COPY")
-	fmt.Println("COPY size", len(copyTable))
-	pubMap := parseManxPubTable("data/manx-mysql-dump-20100609-PUB")
-	fmt.Println("PUB size", len(pubMap))
-	pubHistoryMap := parseManxPubHistoryTable("data/manx-mysql-dump-20100609-PUB_HISTORY")
-	fmt.Println("PUBHISTORY size", len(pubHistoryMap))
-
 	output_yaml_file := flag.String("yaml-output", "", "filepath of the output file to hold the generated yaml")
-	output_md5_file := flag.String("md5-output", "", "filepath of the output file to hold the generated yaml")
+	output_jsonl_file := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	output_md5_file := flag.String("md5-output", "", "filepath of an MD5-to-URL store to write (see internal/md5url), e.g. "+md5url.DefaultFilename)
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store listing documents removed on purpose, which are left out")
+	enrichmentFilename := flag.String("enrichment", enrich.DefaultFilename, "filepath of the enrichment rules applied to the documents before the catalog is written")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	verbose := console.Flags("Enable verbose reporting")
 
 	flag.Parse()
 
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
 	fatal_error_seen := false
 
 	if *output_yaml_file == "" {
@@ -315,9 +329,16 @@ func main() {
 	}
 
 	if fatal_error_seen {
-		log.Fatal("Unable to continue because of one or more fatal errors")
+		exitcode.UsageError("Unable to continue because of one or more fatal errors")
 	}
 
+	copyTable := parseManxCopyTable("data/manx-mysql-dump-20100609-COPY")
+	fmt.Println("COPY size", len(copyTable))
+	pubMap := parseManxPubTable("data/manx-mysql-dump-20100609-PUB")
+	fmt.Println("PUB size", len(pubMap))
+	pubHistoryMap := parseManxPubHistoryTable("data/manx-mysql-dump-20100609-PUB_HISTORY")
+	fmt.Println("PUBHISTORY size", len(pubHistoryMap))
+
 	// We want to produce a map of unique documents.
 	// If an MD5 is present, that's enough to guarantee uniqueness.
 	// If no MD5 is present, use the part number
@@ -326,7 +347,7 @@ func main() {
 	documentsMap := make(map[string]Document)
 
 	// Build a map of MD5 to URL
-	manxMd5Map := make(map[string]string)
+	manxMd5Map := md5url.New()
 
 	for _, entry := range copyTable {
 		var pubHistory PubHistory
@@ -355,9 +376,12 @@ func main() {
 			}
 		}
 
-		// Eliminate any duplicate entries
-		if _, ok := documentsMap[key]; ok {
+		// Eliminate any duplicate entries, but keep the URL of each copy
+		if existing, ok := documentsMap[key]; ok {
 			//fmt.Println("Repeated key", key, " for COPY", entry.Id, "PUB_HISTORY", pubHistory.Id)
+			if document.AddLocations(&existing, document.LocationOf(publicUrl, entry.Size)) {
+				documentsMap[key] = existing
+			}
 			continue
 		}
 
@@ -370,11 +394,12 @@ func main() {
 		newDocument.PubDate = pubHistory.PubDate
 		newDocument.PartNum = partNum
 		newDocument.PublicUrl = publicUrl
+		// manx records a second part number (e.g. the order number as well as the document number) as AltPart
+		document.AddAltPartNums(&newDocument, StripOptionalLeadingAndTrailingSingleQuotes(pubHistory.AltPart))
+		document.NormaliseLocations(&newDocument)
 
 		documentsMap[key] = newDocument
-		if entry.Md5 != "" {
-			manxMd5Map[entry.Md5] = entry.Url
-		}
+		md5url.Add(manxMd5Map, entry.Md5, publicUrl)
 
 	}
 	fmt.Println("Documents size", len(documentsMap))
@@ -383,29 +408,39 @@ func main() {
 	//	fmt.Println("Part", document.PartNum, "Title", document.Title)
 	//}
 
-	data, err := yaml.Marshal(&documentsMap)
+	// Leave out the documents that were removed on purpose, so that rebuilding the catalog does not bring them back
+	tombstones.ExcludeForCatalog(*tombstonesFilename, documentsMap, *verbose)
+
+	// Apply the recurring fixups kept as enrichment rules
+	enrich.ApplyForCatalog(*enrichmentFilename, documentsMap, *verbose)
+
+	data, err := document.MarshalYaml(documentsMap)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 
 	err = os.WriteFile(*output_yaml_file, data, 0644)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.Fatal(err)
 	}
 
-	manxData, err := yaml.Marshal(&manxMd5Map)
-	if err != nil {
-		log.Fatal(err)
+	if *output_jsonl_file != "" {
+		err = document.WriteDocumentsMapToOrderedJsonl(documentsMap, *output_jsonl_file)
+		if err != nil {
+			exitcode.Fatal(err)
+		}
 	}
 
 	// The output MD5 file is optional
 	if *output_md5_file != "" {
-		err = os.WriteFile(*output_md5_file, manxData, 0644)
+		err = manxMd5Map.SaveAs(*output_md5_file, persistentstore.FormatFromFilename(*output_md5_file))
 		if err != nil {
-			log.Fatal(err)
+			exitcode.Fatal(err)
 		}
+		fmt.Println("MD5-to-URL store size", len(manxMd5Map.Data))
 	}
 
+	exitcode.Exit()
 }
 
 // Helper function to remove leading and trailing single quotes, if present.
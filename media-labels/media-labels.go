@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/pdfdraw"
+	"docs-to-yaml/internal/qrcode"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"text/template"
+)
+
+//
+// This program produces a PDF of printable labels for archived media, from the volume registry (see internal/volumes).
+// Each volume gets a page holding a disc label, with the cut lines of the disc and its hole, and a case spine insert.
+//
+// What is printed comes from text/template templates, executed with the volume's registry entry (.ID, .Label, .Medium,
+// .BurnDate, .Location, .Container, .Slot, .Capacity), the number of documents on the volume (.Documents, counted from
+// the catalogs named on the command line) and .URL, the result of the --url template. If --url is given, the disc
+// label also carries a QR code of the URL, e.g. --url 'https://example.org/archive/{{.ID}}.html'.
+//
+// The sizes and positions of the labels, and the templates, are taken from a YAML --layout file (see Layout); any
+// field it omits keeps its default, which suits a 120 mm disc and a CD jewel case on A4 paper. For a DVD case:
+//
+//   spinelength: 183
+//   spinewidth: 14
+//
+// To run the program:
+//   go run media-labels/media-labels.go --volumes bin/volumes.yaml --output labels.pdf --volume DEC_0041 bin/local.yaml
+//
+
+// Layout is the arrangement of a page of labels. Lengths are in millimetres and font sizes in points.
+type Layout struct {
+	PageWidth    float64
+	PageHeight   float64
+	Margin       float64  // From the top of the page to the disc label, and between the disc label and the spine insert
+	DiscDiameter float64  // The printable diameter of the disc label
+	HoleDiameter float64  // The diameter of the hole in its centre
+	DiscLines    []string // Templates for the lines printed above the hole; the first is printed at TitleSize. Blank lines are left out.
+	QrSize       float64  // The size of the QR code printed below the hole, including its quiet zone; 0 for none
+	SpineLength  float64
+	SpineWidth   float64
+	SpineText    string // Template for the text along the spine insert
+	TitleSize    float64
+	FontSize     float64
+}
+
+// The layout used for anything a --layout file does not give: a 120 mm disc and a CD jewel case spine on A4 paper
+var DefaultLayout = Layout{
+	PageWidth:    210,
+	PageHeight:   297,
+	Margin:       15,
+	DiscDiameter: 117,
+	HoleDiameter: 41,
+	DiscLines:    []string{"{{.ID}}", "{{.Label}}", "{{.Medium}} {{.BurnDate}}", "{{if .Documents}}{{.Documents}} documents{{end}}"},
+	QrSize:       24,
+	SpineLength:  118,
+	SpineWidth:   6.5,
+	SpineText:    "{{.ID}}   {{.Label}}   {{.BurnDate}}",
+	TitleSize:    16,
+	FontSize:     10,
+}
+
+// Label is what the templates are executed with
+type Label struct {
+	ID string
+	volumes.Volume
+	Documents int    // The number of documents on the volume
+	URL       string // The result of the --url template
+}
+
+func main() {
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml)")
+	outputFilename := flag.String("output", "", "filepath of the PDF file to write")
+	layoutFilename := flag.String("layout", "", "filepath of a YAML file giving the layout of the labels (see Layout)")
+	urlTemplate := flag.String("url", "", "template for the URL of each volume's catalog entry, printed as a QR code on the disc label")
+	var selected []string
+	flag.Func("volume", "print labels for this volume (repeatable; default: every volume in the registry)", func(s string) error {
+		selected = append(selected, strings.TrimSpace(s))
+		return nil
+	})
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if *volumesFilename == "" {
+		exitcode.UsageError("Please supply --volumes")
+	}
+	if *outputFilename == "" {
+		exitcode.UsageError("Please supply --output")
+	}
+	layout, err := ReadLayout(*layoutFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--layout: %s", err)
+	}
+	if _, err := template.New("url").Parse(*urlTemplate); err != nil {
+		exitcode.UsageErrorf("--url: %s", err)
+	}
+	registry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+	if len(selected) == 0 {
+		selected = registry.IDs()
+	}
+	if len(selected) == 0 {
+		exitcode.UsageErrorf("%s lists no volumes", *volumesFilename)
+	}
+
+	var catalogs []catalog.Catalog
+	for _, filename := range flag.Args() {
+		documents, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		catalogs = append(catalogs, documents)
+	}
+	counts := CountDocuments(catalogs)
+
+	pdf := pdfdraw.New()
+	for _, id := range selected {
+		volume, found := registry[id]
+		if !found {
+			exitcode.WarningAt("unknown-volume", id, "WARNING: %s is not in %s; its labels show only its ID\n", id, *volumesFilename)
+		}
+		label := Label{ID: id, Volume: volume, Documents: counts[id]}
+		if *urlTemplate != "" {
+			if label.URL, err = execute(*urlTemplate, label); err != nil {
+				exitcode.Fatalf("--url: %s", err)
+			}
+		}
+		if err := layout.Draw(pdf, label); err != nil {
+			exitcode.Fatalf("%s: %s", id, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := pdf.WriteTo(&out); err != nil {
+		exitcode.Fatal(err)
+	}
+	if _, err := workspace.WriteFile(*outputFilename, out.Bytes(), 0644); err != nil {
+		exitcode.Fatal(err)
+	}
+	fmt.Printf("Wrote labels for %d volumes to %s\n", len(selected), *outputFilename)
+
+	exitcode.Exit()
+}
+
+// Reads a layout, starting from DefaultLayout so that any field the file omits keeps its default. An empty filename
+// gives DefaultLayout.
+func ReadLayout(filename string) (Layout, error) {
+	layout := DefaultLayout
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return layout, err
+		}
+		if err := document.UnmarshalYaml(data, &layout); err != nil {
+			return layout, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+		}
+	}
+	return layout, layout.Validate()
+}
+
+// Checks that the labels fit on the page and that the templates are well formed
+func (l Layout) Validate() error {
+	switch {
+	case (l.PageWidth <= 0) || (l.PageHeight <= 0) || (l.DiscDiameter <= 0) || (l.SpineLength <= 0) || (l.SpineWidth <= 0) || (l.TitleSize <= 0) || (l.FontSize <= 0):
+		return errors.New("the page, disc, spine and font sizes must all be positive")
+	case l.HoleDiameter >= l.DiscDiameter:
+		return errors.New("the hole must be smaller than the disc")
+	case (l.DiscDiameter > l.PageWidth) || (l.SpineLength > l.PageWidth):
+		return errors.New("the disc label and spine insert must fit across the page")
+	case 2*l.Margin+l.DiscDiameter+l.SpineWidth > l.PageHeight:
+		return errors.New("the disc label and spine insert must fit down the page")
+	case (l.QrSize > 0) && ((math.Hypot(l.QrSize/2, (l.DiscDiameter+l.HoleDiameter)/4+l.QrSize/2) > l.DiscDiameter/2) || ((l.DiscDiameter+l.HoleDiameter)/4-l.QrSize/2 < l.HoleDiameter/2)):
+		return errors.New("the QR code must fit between the hole and the edge of the disc")
+	}
+	for _, text := range append([]string{l.SpineText}, l.DiscLines...) {
+		if _, err := template.New("layout").Parse(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds a page holding the disc label and spine insert of a volume
+func (l Layout) Draw(pdf *pdfdraw.Document, label Label) error {
+	mm := func(length float64) float64 { return length * pdfdraw.PointsPerMm }
+	page := pdf.AddPage(mm(l.PageWidth), mm(l.PageHeight))
+
+	// The disc label: its cut lines, the text above the hole and the QR code below it
+	centreX, centreY := mm(l.PageWidth/2), mm(l.PageHeight-l.Margin-l.DiscDiameter/2)
+	radius, hole := mm(l.DiscDiameter/2), mm(l.HoleDiameter/2)
+	page.SetGrey(0.6)
+	page.SetLineWidth(0.3)
+	page.Circle(centreX, centreY, radius)
+	page.Circle(centreX, centreY, hole)
+	page.SetGrey(0)
+
+	var lines []string
+	for _, text := range l.DiscLines {
+		line, err := execute(text, label)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	top := centreY + radius - mm(8)
+	for i, line := range lines {
+		size := l.FontSize
+		if i == 0 {
+			size = l.TitleSize
+		}
+		baseline := top - size
+		if baseline-size*0.3 < centreY+hole+mm(2) {
+			break // The lines that do not fit above the hole are left out
+		}
+		// The text must fit within the chord of the disc at the top of the line
+		rise := baseline + size*0.75 - centreY
+		chord := 2*math.Sqrt(math.Max(radius*radius-rise*rise, 0)) - mm(8)
+		size = math.Min(size, size*chord/math.Max(pdfdraw.TextWidth(line, size), 1))
+		page.CentredText(centreX, baseline, size, line)
+		top = baseline - size*0.4
+	}
+
+	if (l.QrSize > 0) && (label.URL != "") {
+		code, err := qrcode.Encode([]byte(label.URL))
+		if err != nil {
+			return err
+		}
+		size := mm(l.QrSize)
+		module := size / float64(code.Size+8)
+		left := centreX - size/2 + 4*module
+		bottom := centreY - mm((l.DiscDiameter+l.HoleDiameter)/4) - size/2 + 4*module
+		for y := 0; y < code.Size; y++ {
+			// Each run of dark modules along a row is drawn as one rectangle
+			for x := 0; x < code.Size; x++ {
+				run := 0
+				for (x+run < code.Size) && code.Dark(x+run, y) {
+					run++
+				}
+				if run > 0 {
+					page.Rect(left+float64(x)*module, bottom+float64(code.Size-1-y)*module, float64(run)*module, module, true)
+					x += run
+				}
+			}
+		}
+	}
+
+	// The spine insert: its cut lines and the text along it
+	spineLeft, spineBottom := mm((l.PageWidth-l.SpineLength)/2), mm(l.PageHeight-2*l.Margin-l.DiscDiameter-l.SpineWidth)
+	page.SetGrey(0.6)
+	page.Rect(spineLeft, spineBottom, mm(l.SpineLength), mm(l.SpineWidth), false)
+	page.SetGrey(0)
+	text, err := execute(l.SpineText, label)
+	if err != nil {
+		return err
+	}
+	text = strings.TrimSpace(text)
+	size := math.Min(l.FontSize, mm(l.SpineWidth)*0.6)
+	size = math.Min(size, size*(mm(l.SpineLength)-mm(4))/math.Max(pdfdraw.TextWidth(text, size), 1))
+	page.CentredText(mm(l.PageWidth/2), spineBottom+(mm(l.SpineWidth)-size*0.7)/2, size, text)
+	return nil
+}
+
+// Executes a template with a label
+func execute(text string, label Label) (string, error) {
+	t, err := template.New("label").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, label); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Returns the number of distinct documents (by MD5 checksum, or failing that by key) held on each volume, counting the
+// volume of every location of each document
+func CountDocuments(catalogs []catalog.Catalog) map[string]int {
+	seen := make(map[string]map[string]bool)
+	for _, documents := range catalogs {
+		for key, doc := range documents {
+			id := doc.Md5
+			if id == "" {
+				id = key
+			}
+			onVolumes := make(map[string]bool)
+			if doc.VolumeID != "" {
+				onVolumes[doc.VolumeID] = true
+			}
+			document.NormaliseLocations(&doc)
+			for _, location := range doc.Copies {
+				if location.Volume != "" {
+					onVolumes[location.Volume] = true
+				}
+			}
+			for volume := range onVolumes {
+				if seen[volume] == nil {
+					seen[volume] = make(map[string]bool)
+				}
+				seen[volume][id] = true
+			}
+		}
+	}
+	counts := make(map[string]int)
+	for volume, ids := range seen {
+		counts[volume] = len(ids)
+	}
+	return counts
+}
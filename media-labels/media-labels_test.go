@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/pdfdraw"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLayout(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "layout.yaml")
+	if err := os.WriteFile(filename, []byte("spinelength: 183\nspinewidth: 14\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := ReadLayout(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultLayout
+	expected.SpineLength, expected.SpineWidth = 183, 14
+	if !reflect.DeepEqual(layout, expected) {
+		t.Errorf("ReadLayout() = %+v, expected %+v", layout, expected)
+	}
+
+	for _, bad := range []string{"qrsize: 40\n", "holediameter: 120\n", "spinetext: '{{.ID'\n", "pagewidth: 100\n"} {
+		if err := os.WriteFile(filename, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadLayout(filename); err == nil {
+			t.Errorf("ReadLayout() accepted %q", bad)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	pdf := pdfdraw.New()
+	label := Label{ID: "DEC_0041", Volume: volumes.Volume{Label: "DEC manuals (VAX)", Medium: "DVD-R", BurnDate: "2004-03-17"}, Documents: 312, URL: "https://example.org/archive/DEC_0041.html"}
+	if err := DefaultLayout.Draw(pdf, label); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := pdf.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"(DEC_0041) Tj", "(DEC manuals \\(VAX\\)) Tj", "(DVD-R 2004-03-17) Tj", "(312 documents) Tj", "(DEC_0041   DEC manuals \\(VAX\\)   2004-03-17) Tj", " re f\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("the labels do not contain %s", expected)
+		}
+	}
+
+	// Lines that come out blank are left out, and without a URL there is no QR code
+	pdf = pdfdraw.New()
+	if err := DefaultLayout.Draw(pdf, Label{ID: "DEC_0042"}); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	pdf.WriteTo(&out)
+	if strings.Contains(out.String(), "documents) Tj") || strings.Contains(out.String(), " re f\n") {
+		t.Errorf("the labels of an unregistered volume contain a blank line or a QR code")
+	}
+}
+
+func TestCountDocuments(t *testing.T) {
+	local := catalog.Catalog{
+		"a": {Md5: "a", Filepath: "file:///DEC_0001/vax/ka630.pdf"},
+		"b": {Md5: "b", Filepath: "vax/ka630.txt", VolumeID: "DEC_0002"},
+	}
+	master := catalog.Catalog{
+		"a": {Md5: "a", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf", Copies: []document.FileLocation{{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf"}, {Type: document.LocalLocation, Volume: "DEC_0002", Path: "copy/ka630.pdf"}}},
+		"c": {Filepath: "file:///DEC_0002/vms/notes.txt"},
+	}
+	counts := CountDocuments([]catalog.Catalog{local, master})
+	expected := map[string]int{"DEC_0001": 1, "DEC_0002": 3}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("CountDocuments() = %v, expected %v", counts, expected)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/textlayer"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// This program finds the image-only documents in a catalog (scanned PDFs without a text layer, and TIFFs) and runs
+// an OCR program over each of them, recording the outcome in the catalog:
+//
+//   ocrstatus  not-needed (the PDF already has a text layer), pending (queued for OCR), done or failed
+//   ocroutput  the filepath of the OCRed copy
+//
+// Documents that have no ocrstatus yet are checked first (see internal/textlayer); the pending ones (and, with
+// --retry-failed, the failed ones) are then passed to the OCR command, --workers at a time. The catalog is saved after
+// every document, so a run that is interrupted or killed can be resumed simply by running it again. With --list the
+// queue is checked and listed, but nothing is run.
+//
+// The OCR command is run directly (not via a shell) with {input} replaced by the document's file and {output} by the
+// file to write, e.g. (the default)
+//
+//   --command "ocrmypdf --skip-text {input} {output}"
+//
+// or, for tesseract, which adds the .pdf itself: --command "tesseract {input} {outputbase} pdf".
+// The OCRed copy of file:///VOLUME/path is written to OUTPUT-DIR/VOLUME/path (with a .pdf extension), and that of a
+// filepath relative to a tree to OUTPUT-DIR/path, so the originals are never changed.
+//
+// Local documents are found under --archive-root and/or --volume VOLUME=PATH; filepaths relative to a tree (as written
+// by file-tree-to-yaml) are found under --tree-root (see internal/locator). A root may be remote, in which case each
+// document is fetched to a temporary copy for the OCR command. --where restricts the documents considered.
+// The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run ocr-queue/ocr-queue.go --archive-root /mnt/archive --output-dir /mnt/ocr --workers 2 bin/local.yaml
+//
+
+type Document = document.Document
+
+// The values of Document.OcrStatus
+const (
+	StatusNotNeeded = "not-needed"
+	StatusPending   = "pending"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+)
+
+// The command used if --command is not given
+const DefaultCommand = "ocrmypdf --skip-text {input} {output}"
+
+// Job is one document to OCR
+type Job struct {
+	Key      string
+	Filepath string // The document's filepath in the catalog
+	Output   string // The file to write
+}
+
+func main() {
+	locator := locator.Flags()
+	command := flag.String("command", DefaultCommand, "the OCR command, with {input}, {output} and {outputbase} (the output without .pdf) placeholders")
+	outputDir := flag.String("output-dir", "", "directory to receive the OCRed copies")
+	workers := flag.Int("workers", 1, "number of OCR commands to run concurrently")
+	list := flag.Bool("list", false, "check and list the queue, but do not run any OCR")
+	retryFailed := flag.Bool("retry-failed", false, "queue the documents whose OCR failed on an earlier run again")
+	where := flag.String("where", "", "consider only documents selected by this filter expression, e.g. 'collection = local:DEC_0001'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	hook, err := exechook.Parse(*command)
+	if (err == nil) && (hook == nil) {
+		err = errors.New("no command given")
+	}
+	if err != nil {
+		exitcode.UsageErrorf("--command: %s", err)
+	}
+	if !*list && (*outputDir == "") {
+		exitcode.UsageError("Please supply --output-dir (or --list to only list the queue)")
+	}
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that documents can be found")
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+	selected := documents.Where(filter).Keys()
+	// The JSON Lines copy is written once the catalog has been, rather than after each document
+	saveJsonl := func() {
+		if *jsonlOutputFilename == "" {
+			return
+		}
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	checked := Classify(documents, selected, locator)
+	if checked > 0 {
+		fmt.Printf("Checked %d documents for a text layer\n", checked)
+		if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed YAML write: ", err)
+		}
+		saveJsonl()
+	}
+
+	jobs := BuildQueue(documents, selected, locator, *outputDir, *retryFailed)
+	if *list {
+		for _, job := range jobs {
+			fmt.Printf("%s %s\n", documents[job.Key].OcrStatus, documents[job.Key].Filepath)
+		}
+		fmt.Printf("%d documents queued for OCR\n", len(jobs))
+		exitcode.Exit()
+	}
+
+	fmt.Printf("Running OCR for %d documents using %d workers\n", len(jobs), *workers)
+	var mutex sync.Mutex
+	results, completed := RunQueue(jobs, hook, locator, *workers, func(job Job, err error) {
+		// Record each outcome as soon as it is known, so that nothing is lost if the run is killed
+		mutex.Lock()
+		defer mutex.Unlock()
+		doc := documents[job.Key]
+		RecordOutcome(&doc, job, err)
+		documents[job.Key] = doc
+		if err != nil {
+			exitcode.ErrorAt("ocr-failed", doc.Filepath, "FAILED: %s (%s)\n", doc.Filepath, err)
+		} else if *verbose {
+			fmt.Printf("DONE:   %s => %s\n", doc.Filepath, job.Output)
+		}
+		if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed YAML write: ", err)
+		}
+	})
+	failed := 0
+	for _, err := range results[:completed] {
+		if err != nil {
+			failed += 1
+		}
+	}
+	fmt.Printf("OCR done for %d documents, failed for %d\n", completed-failed, failed)
+	if completed > 0 {
+		saveJsonl()
+	}
+
+	if completed < len(jobs) {
+		interrupt.Exit(fmt.Sprintf("%d documents are still queued; re-run to continue", len(jobs)-completed))
+	}
+
+	exitcode.Exit()
+}
+
+// Reports whether a document is of a format that may need OCR
+func IsCandidate(doc Document) bool {
+	return (doc.Format == "PDF") || (doc.Format == "TIFF")
+}
+
+// Sets the OCR status of every selected document that may need OCR and has not been checked yet: PDFs with a text
+// layer do not need OCR, other PDFs and all TIFFs do. Documents whose file cannot be found or read are reported and
+// left unchecked. Returns the number of documents checked.
+func Classify(documents catalog.Catalog, keys []string, locator *locator.Locator) int {
+	checked := 0
+	for _, key := range keys {
+		doc := documents[key]
+		if !IsCandidate(doc) || (doc.OcrStatus != "") || interrupt.Requested() {
+			continue
+		}
+		fsys, name, err := locator.Locate(doc.Filepath)
+		if err != nil {
+			exitcode.WarningAt("ocr-unchecked", doc.Filepath, "UNCHECKED: %s (%s)\n", doc.Filepath, err)
+			continue
+		}
+		doc.OcrStatus = StatusPending
+		if doc.Format == "PDF" {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				exitcode.WarningAt("ocr-unchecked", doc.Filepath, "UNCHECKED: %s (%s)\n", doc.Filepath, err)
+				continue
+			}
+			if textlayer.PdfHasText(data) {
+				doc.OcrStatus = StatusNotNeeded
+			}
+		}
+		documents[key] = doc
+		checked += 1
+	}
+	return checked
+}
+
+// Returns the jobs for the selected documents that are pending (or failed, if retryFailed is set), in key order
+func BuildQueue(documents catalog.Catalog, keys []string, locator *locator.Locator, outputDir string, retryFailed bool) []Job {
+	var jobs []Job
+	for _, key := range keys {
+		doc := documents[key]
+		if (doc.OcrStatus != StatusPending) && !(retryFailed && (doc.OcrStatus == StatusFailed)) {
+			continue
+		}
+		if _, _, err := locator.Locate(doc.Filepath); err != nil {
+			exitcode.WarningAt("ocr-unchecked", doc.Filepath, "SKIPPED: %s (%s)\n", doc.Filepath, err)
+			continue
+		}
+		jobs = append(jobs, Job{Key: key, Filepath: doc.Filepath, Output: OutputFilepath(outputDir, doc.Filepath)})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Key < jobs[j].Key })
+	return jobs
+}
+
+// Runs the OCR command for each job, up to workers at once, calling record with the outcome of each as it finishes.
+// If the run is interrupted no further jobs are started; the number run (which are always the first ones) is returned
+// along with the outcomes.
+func RunQueue(jobs []Job, hook *exechook.Hook, locator *locator.Locator, workers int, record func(job Job, err error)) ([]error, int) {
+	keepGoing := func() bool { return !interrupt.Requested() }
+	return pipeline.MapWhile(jobs, workers, keepGoing, func(worker int, job Job) error {
+		err := RunOcr(hook, locator, job)
+		record(job, err)
+		return err
+	})
+}
+
+// Runs the OCR command for one job. It fails if the command fails or writes no output. A document in a remote root is
+// fetched to a temporary copy for the command.
+func RunOcr(hook *exechook.Hook, locator *locator.Locator, job Job) error {
+	if err := os.MkdirAll(filepath.Dir(job.Output), 0755); err != nil {
+		return err
+	}
+	input, release, err := locator.LocalFile(job.Filepath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	command := hook.Expand(map[string]string{"{input}": input, "{output}": job.Output, "{outputbase}": strings.TrimSuffix(job.Output, ".pdf")})
+	cmd := exec.Command(command[0], command[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			return fmt.Errorf("%w: %s", err, last)
+		}
+		return err
+	}
+	if _, err := os.Stat(job.Output); err != nil {
+		return fmt.Errorf("%s wrote no %s", command[0], job.Output)
+	}
+	return nil
+}
+
+// Records the outcome of a job in its document
+func RecordOutcome(doc *Document, job Job, err error) {
+	if err != nil {
+		doc.OcrStatus, doc.OcrOutput = StatusFailed, ""
+	} else {
+		doc.OcrStatus, doc.OcrOutput = StatusDone, job.Output
+	}
+}
+
+// Returns the file that the OCRed copy of a document is written to: file:///VOLUME/path becomes
+// outputDir/VOLUME/path and a relative filepath becomes outputDir/path, in each case with a .pdf extension.
+func OutputFilepath(outputDir string, catalogPath string) string {
+	relative := strings.TrimPrefix(catalogPath, "file:///")
+	relative = strings.TrimSuffix(relative, path.Ext(relative)) + ".pdf"
+	return filepath.Join(outputDir, filepath.FromSlash(relative))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFilepath(t *testing.T) {
+	tests := map[string]string{
+		"file:///DEC_0001/vax/ka630.tif": filepath.FromSlash("/ocr/DEC_0001/vax/ka630.pdf"),
+		"file:///DEC_0001/vax/ka630.PDF": filepath.FromSlash("/ocr/DEC_0001/vax/ka630.pdf"),
+		"scans/rn.pdf":                   filepath.FromSlash("/ocr/scans/rn.pdf"),
+	}
+	for catalogPath, expected := range tests {
+		if got := OutputFilepath(filepath.FromSlash("/ocr"), catalogPath); got != expected {
+			t.Errorf(`OutputFilepath(%q) = %q, expected %q`, catalogPath, got, expected)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree")
+	os.MkdirAll(tree, 0755)
+	os.WriteFile(filepath.Join(tree, "text.pdf"), []byte("%PDF-1.4\n<< /Type /Page /Resources << /Font << /F1 5 0 R >> >> >>\n%%EOF\n"), 0644)
+	os.WriteFile(filepath.Join(tree, "scan.pdf"), []byte("%PDF-1.4\n<< /Type /Page /Resources << /XObject << /Im1 5 0 R >> >> >>\n%%EOF\n"), 0644)
+	os.WriteFile(filepath.Join(tree, "scan.tif"), []byte("II*\x00"), 0644)
+	documents := catalog.Catalog{
+		"text":    {Filepath: "text.pdf", Format: "PDF"},
+		"scan":    {Filepath: "scan.pdf", Format: "PDF"},
+		"tiff":    {Filepath: "scan.tif", Format: "TIFF"},
+		"missing": {Filepath: "missing.pdf", Format: "PDF"},
+		"done":    {Filepath: "done.pdf", Format: "PDF", OcrStatus: StatusDone},
+		"text2":   {Filepath: "readme.txt", Format: "TXT"},
+	}
+	locator := &locator.Locator{TreeRoot: tree}
+
+	if checked := Classify(documents, documents.Keys(), locator); checked != 3 {
+		t.Errorf(`Classify() checked %d documents, expected 3`, checked)
+	}
+	expected := map[string]string{"text": StatusNotNeeded, "scan": StatusPending, "tiff": StatusPending, "missing": "", "done": StatusDone, "text2": ""}
+	for key, status := range expected {
+		if documents[key].OcrStatus != status {
+			t.Errorf(`Classify() status of %s = %q, expected %q`, key, documents[key].OcrStatus, status)
+		}
+	}
+
+	output := filepath.Join(dir, "ocr")
+	jobs := BuildQueue(documents, documents.Keys(), locator, output, false)
+	if (len(jobs) != 2) || (jobs[0].Key != "scan") || (jobs[1].Key != "tiff") || (jobs[1].Output != filepath.Join(output, "scan.pdf")) {
+		t.Fatalf(`BuildQueue() = %+v`, jobs)
+	}
+
+	// The second job fails because its input has gone
+	hook, _ := exechook.Parse("cp {input} {output}")
+	jobs[1].Filepath = "vanished.tif"
+	recorded := 0
+	results, completed := RunQueue(jobs, hook, locator, 1, func(job Job, err error) {
+		doc := documents[job.Key]
+		RecordOutcome(&doc, job, err)
+		documents[job.Key] = doc
+		recorded += 1
+	})
+	if (completed != 2) || (recorded != 2) || (results[0] != nil) || (results[1] == nil) {
+		t.Errorf(`RunQueue() = %v, %d`, results, completed)
+	}
+	if (documents["scan"].OcrStatus != StatusDone) || (documents["scan"].OcrOutput != filepath.Join(output, "scan.pdf")) {
+		t.Errorf(`RunQueue() recorded %+v`, documents["scan"])
+	}
+	if (documents["tiff"].OcrStatus != StatusFailed) || (documents["tiff"].OcrOutput != "") {
+		t.Errorf(`RunQueue() recorded %+v for a failed job`, documents["tiff"])
+	}
+
+	// A resumed queue holds only the failed document, and only when asked to retry it
+	if jobs := BuildQueue(documents, documents.Keys(), locator, output, false); len(jobs) != 0 {
+		t.Errorf(`BuildQueue() after the run = %+v`, jobs)
+	}
+	if jobs := BuildQueue(documents, documents.Keys(), locator, output, true); (len(jobs) != 1) || (jobs[0].Key != "tiff") {
+		t.Errorf(`BuildQueue() retrying failures = %+v`, jobs)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/pdfa"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// This program records which local PDFs in a catalog are PDF/A, or could simply be converted to it, in each document's
+// pdfa (see internal/pdfa), and reports how many PDFs there are of each kind:
+//
+//   valid-1b      validated by veraPDF as conforming to the level the file claims (here PDF/A-1b)
+//   invalid-1b    found by veraPDF not to conform to the level it claims
+//   claims-1b     claims the level in its metadata, but has not been validated
+//   convertible   claims no level, but has nothing that would stop a simple conversion
+//   none          claims no level, and is encrypted, or has JavaScript, launch actions, embedded files or fonts that
+//                 are not embedded
+//
+// With --validate each PDF that claims a level is also passed to veraPDF, run as --verapdf (by default
+// "verapdf --format mrr {input}"). PDFs that already have a pdfa are skipped unless --recheck is given; a claim that
+// has not been validated is checked again whenever --validate is given.
+//
+// Local documents are found under --archive-root, --volume VOLUME=PATH and --tree-root (see internal/locator); a
+// document in a remote root is fetched to a temporary copy to be checked. --where restricts the documents considered. The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run pdfa-check/pdfa-check.go --archive-root /mnt/archive --validate bin/local.yaml
+//
+
+type Document = document.Document
+
+// The veraPDF command used if --verapdf is not given
+const DefaultVerapdf = "verapdf --format mrr {input}"
+
+func main() {
+	locator := locator.Flags()
+	validate := flag.Bool("validate", false, "validate the PDFs that claim a PDF/A level with veraPDF")
+	verapdf := flag.String("verapdf", DefaultVerapdf, "the veraPDF command, with an {input} placeholder, writing a machine-readable report")
+	recheck := flag.Bool("recheck", false, "check PDFs again even if they already have a pdfa")
+	where := flag.String("where", "", "consider only documents selected by this filter expression, e.g. 'collection = local:DEC_0001'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	var hook *exechook.Hook
+	if *validate {
+		var err error
+		if hook, err = exechook.Parse(*verapdf); (err == nil) && (hook == nil) {
+			err = fmt.Errorf("no command given")
+		}
+		if err != nil {
+			exitcode.UsageErrorf("--verapdf: %s", err)
+		}
+	}
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that documents can be found")
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selected := documents.Where(filter)
+	checked := 0
+	for _, key := range selected.Keys() {
+		doc := documents[key]
+		if !NeedsCheck(doc, *recheck, hook != nil) || interrupt.Requested() {
+			continue
+		}
+		filename, release, err := locator.LocalFile(doc.Filepath)
+		if err == nil {
+			var obstacles []string
+			obstacles, err = CheckDocument(&doc, filename, hook)
+			release()
+			if (err == nil) && *verbose {
+				fmt.Printf("%-12s %s", doc.PdfA, doc.Filepath)
+				if len(obstacles) > 0 {
+					fmt.Printf(" (%s)", strings.Join(obstacles, ", "))
+				}
+				fmt.Println()
+			}
+		}
+		if err != nil {
+			exitcode.WarningAt("pdfa-unchecked", doc.Filepath, "UNCHECKED: %s (%s)\n", doc.Filepath, err)
+			continue
+		}
+		documents[key] = doc
+		checked += 1
+	}
+	fmt.Printf("Checked %d PDFs\n", checked)
+	WriteDistribution(os.Stdout, pdfa.Distribution(documents.Where(filter)))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	if interrupt.Requested() {
+		interrupt.Exit("some PDFs were not checked; re-run to continue")
+	}
+	exitcode.Exit()
+}
+
+// Reports whether a document is a local PDF to be checked: one that has not been checked yet, or (if validating) one
+// whose claim has not been validated, or any if recheck is set
+func NeedsCheck(doc Document, recheck bool, validating bool) bool {
+	if (doc.Format != "PDF") || !retention.IsLocal(doc) {
+		return false
+	}
+	return recheck || (doc.PdfA == "") || (validating && strings.HasPrefix(doc.PdfA, pdfa.ClaimsPrefix))
+}
+
+// Checks a document's file and records the outcome in its pdfa, validating any claim with veraPDF if hook is not nil.
+// Returns what PDF/A forbids that the file has.
+func CheckDocument(doc *Document, filename string, hook *exechook.Hook) ([]string, error) {
+	report, err := pdfa.CheckFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	status := report.Status()
+	if (hook != nil) && (report.Level != "") {
+		if status, err = pdfa.Validate(hook.Expand(map[string]string{"{input}": filename})); err != nil {
+			return nil, err
+		}
+	}
+	doc.PdfA = status
+	return report.Obstacles, nil
+}
+
+// Writes the number of PDFs of each kind, followed by the number that are PDF/A or could simply be converted
+func WriteDistribution(out io.Writer, counts map[string]int) {
+	total, ready := 0, 0
+	for _, status := range pdfa.Statuses(counts) {
+		fmt.Fprintf(out, "%-12s %7d\n", status, counts[status])
+		total += counts[status]
+		if strings.HasPrefix(status, pdfa.ValidPrefix) || strings.HasPrefix(status, pdfa.ClaimsPrefix) || (status == pdfa.Convertible) {
+			ready += counts[status]
+		}
+	}
+	fmt.Fprintf(out, "%d of %d PDFs are PDF/A or could simply be converted\n", ready, total)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/pdfa"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNeedsCheck(t *testing.T) {
+	for _, test := range []struct {
+		doc        Document
+		recheck    bool
+		validating bool
+		expected   bool
+	}{
+		{Document{Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf"}, false, false, true},
+		{Document{Format: "PDF", Filepath: "http://bitsavers.org/pdf/dec/ka630.pdf"}, false, false, false},
+		{Document{Format: "TXT", Filepath: "file:///DEC_0001/ka630.txt"}, false, false, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PdfA: "convertible"}, false, true, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PdfA: "convertible"}, true, false, true},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PdfA: "claims-1b"}, false, false, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", PdfA: "claims-1b"}, false, true, true},
+	} {
+		if needed := NeedsCheck(test.doc, test.recheck, test.validating); needed != test.expected {
+			t.Errorf("NeedsCheck(%+v, %v, %v) = %v", test.doc, test.recheck, test.validating, needed)
+		}
+	}
+}
+
+func TestCheckDocument(t *testing.T) {
+	defer func(validate func([]string) (string, error)) { pdfa.Validate = validate }(pdfa.Validate)
+	var commands [][]string
+	pdfa.Validate = func(command []string) (string, error) {
+		commands = append(commands, command)
+		return "invalid-1b", nil
+	}
+	dir := t.TempDir()
+	claims := filepath.Join(dir, "claims.pdf")
+	scan := filepath.Join(dir, "scan.pdf")
+	os.WriteFile(claims, []byte(`%PDF-1.4 <rdf:Description pdfaid:part="1" pdfaid:conformance="B"/>`), 0644)
+	os.WriteFile(scan, []byte("%PDF-1.4 /Encrypt 4 0 R"), 0644)
+	hook, _ := exechook.Parse("verapdf --format mrr {input}")
+
+	var doc Document
+	if obstacles, err := CheckDocument(&doc, claims, nil); (err != nil) || (doc.PdfA != "claims-1b") || (len(obstacles) != 0) {
+		t.Errorf("CheckDocument() = %v, %v, %+v", obstacles, err, doc)
+	}
+	if _, err := CheckDocument(&doc, claims, hook); (err != nil) || (doc.PdfA != "invalid-1b") {
+		t.Errorf("CheckDocument() with validation = %v, %+v", err, doc)
+	}
+	// Only a claim is validated
+	if obstacles, err := CheckDocument(&doc, scan, hook); (err != nil) || (doc.PdfA != "none") || !reflect.DeepEqual(obstacles, []string{"encrypted"}) {
+		t.Errorf("CheckDocument() of an encrypted file = %v, %v, %+v", obstacles, err, doc)
+	}
+	if !reflect.DeepEqual(commands, [][]string{{"verapdf", "--format", "mrr", claims}}) {
+		t.Errorf("veraPDF was run as %q", commands)
+	}
+	if _, err := CheckDocument(&doc, filepath.Join(dir, "missing.pdf"), nil); err == nil {
+		t.Errorf("CheckDocument() of a missing file did not fail")
+	}
+}
+
+func TestWriteDistribution(t *testing.T) {
+	var out bytes.Buffer
+	WriteDistribution(&out, map[string]int{"valid-1b": 2, "invalid-1b": 1, "convertible": 5, "none": 3, "unchecked": 1})
+	expected := "invalid-1b         1\nvalid-1b           2\nconvertible        5\nnone               3\nunchecked          1\n7 of 12 PDFs are PDF/A or could simply be converted\n"
+	if out.String() != expected {
+		t.Errorf("WriteDistribution() wrote:\n%s", out.String())
+	}
+}
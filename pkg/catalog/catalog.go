@@ -0,0 +1,453 @@
+package catalog
+
+import (
+	"docs-to-yaml/internal/catalogmerge"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/pubdate"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/internal/workspace"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// This package is the public interface for programs that read, manipulate and write the catalogs produced by
+// the tools in this repository. A catalog is a YAML file that maps a key (usually the MD5 checksum) to a Document.
+//
+// A small program might look like this:
+//
+//	docs, err := catalog.Load("bin/local.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	pdfs := docs.Filter(func(key string, doc catalog.Document) bool { return doc.Format == "PDF" })
+//	byPartNum := pdfs.IndexByPartNum()
+//	...
+//	err = catalog.Save("bin/local-pdfs.yaml", pdfs)
+
+type Document = document.Document
+
+// Catalog is a set of documents, indexed by key
+type Catalog map[string]Document
+
+// Reads a catalog from a YAML file.
+// The text of every document is normalised (see document.NormaliseText), so catalogs from different sources match,
+// and its Filepath and PublicUrl are added to its Copies (see document.NormaliseLocations), so that catalogs
+// written before documents had Copies load as if they had been written with them.
+// A partition directory (see SaveSplit) is read as a single catalog, and a workspace name (e.g. "ws:catalogs/local",
+// see internal/workspace) as its latest catalog.
+func Load(filename string) (Catalog, error) {
+	filename, err := workspace.Resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	if IsPartitioned(filename) {
+		return loadPartitions(filename)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	documents := make(Catalog)
+	if err := document.UnmarshalYaml(data, &documents); err != nil {
+		return nil, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	for key, doc := range documents {
+		normaliseLoaded(&doc)
+		documents[key] = doc
+	}
+	return documents, nil
+}
+
+// Normalises the text of a document just read and completes its locations
+func normaliseLoaded(doc *Document) {
+	document.NormaliseText(doc)
+	document.NormaliseLocations(doc)
+}
+
+// Reads a catalog from a YAML file, returning an empty catalog if the file does not exist.
+func LoadIfExists(filename string) (Catalog, error) {
+	documents, err := Load(filename)
+	if os.IsNotExist(err) {
+		return make(Catalog), nil
+	}
+	return documents, err
+}
+
+// Reads a reference catalog (e.g. someone else's bitsavers catalog) whose documents must never be changed by a merge:
+// each is marked read-only, with the filename as its Origin (see document.MarkReadOnly). Documents that are already
+// read-only keep the Origin they were given.
+func LoadReadOnly(filename string) (Catalog, error) {
+	documents, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	for key, doc := range documents {
+		document.MarkReadOnly(&doc, filename)
+		documents[key] = doc
+	}
+	return documents, nil
+}
+
+// Writes a catalog to a YAML file, in the canonical order (see document.OrderedKeys).
+// The file is written atomically, so an interrupted program never leaves a truncated catalog behind.
+// A partition directory (see SaveSplit) is rewritten with the same partitioning, and a workspace name is written as
+// a new catalog that becomes its latest.
+func Save(filename string, documents Catalog) error {
+	if IsPartitioned(filename) {
+		index, err := readIndex(filename)
+		if err != nil {
+			return err
+		}
+		return SaveSplit(filename, documents, index.By)
+	}
+	return saveArtifact(filename, documents, document.WriteDocumentsMapToOrderedYaml)
+}
+
+// Writes a catalog to a JSON Lines file: one JSON object per document, in the same order as Save, with the
+// document's key in an "id" field. This is an export format for other tools; catalogs are always read from YAML.
+func SaveJsonl(filename string, documents Catalog) error {
+	return saveArtifact(filename, documents, document.WriteDocumentsMapToOrderedJsonl)
+}
+
+// Writes a catalog with write to a file whose filepath may be a workspace name
+func saveArtifact(filename string, documents Catalog, write func(map[string]Document, string) error) error {
+	artifact, err := workspace.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(documents, artifact.Path); err != nil {
+		artifact.Abandon()
+		return err
+	}
+	return artifact.Commit()
+}
+
+// Returns the keys of the catalog, sorted
+func (c Catalog) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns a new catalog holding only those documents for which keep returns true.
+func (c Catalog) Filter(keep func(key string, doc Document) bool) Catalog {
+	filtered := make(Catalog)
+	for key, doc := range c {
+		if keep(key, doc) {
+			filtered[key] = doc
+		}
+	}
+	return filtered
+}
+
+// Returns a new catalog holding only the documents that have every tag in required and none of the tags in excluded.
+func (c Catalog) Tagged(required []string, excluded []string) Catalog {
+	return c.Filter(func(key string, doc Document) bool { return HasTags(doc, required, excluded) })
+}
+
+// Reports whether a document has every tag in required and none of the tags in excluded
+func HasTags(doc Document, required []string, excluded []string) bool {
+	for _, tag := range required {
+		if !document.HasTag(doc, tag) {
+			return false
+		}
+	}
+	for _, tag := range excluded {
+		if document.HasTag(doc, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a new catalog holding only the documents that an audience ("public", "restricted" or "private") may see
+// (see internal/visibility)
+func (c Catalog) Visible(audience string) Catalog {
+	return c.Filter(func(key string, doc Document) bool { return visibility.Allowed(doc, audience) })
+}
+
+// Query selects documents by key, MD5 checksum, part number, title or file path.
+// A document is selected if it matches any of the values given; an empty Query selects nothing.
+type Query struct {
+	Keys      []string // Catalog keys
+	Md5s      []string // MD5 checksums
+	PartNums  []string // Part numbers, compared without regard to case against the part number and its aliases
+	PathGlobs []string // Patterns (see path.Match) for the whole Filepath, e.g. file:///DEC_0001/vax/*.pdf
+	Titles    []string // Fragments of the title, compared without regard to case, e.g. "ka630"
+}
+
+// Checks that every path glob in the query is well formed
+func (q Query) Validate() error {
+	for _, glob := range q.PathGlobs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("bad path glob %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// Reports whether the query selects the document (whose catalog key is key)
+func (q Query) Matches(key string, doc Document) bool {
+	if slices.Contains(q.Keys, key) {
+		return true
+	}
+	for _, md5 := range q.Md5s {
+		if (md5 != "") && (md5 == doc.Md5) {
+			return true
+		}
+	}
+	for _, partNum := range q.PartNums {
+		for _, docPartNum := range document.PartNumbers(doc) {
+			if (partNum != "") && strings.EqualFold(partNum, docPartNum) {
+				return true
+			}
+		}
+	}
+	for _, glob := range q.PathGlobs {
+		if matched, _ := path.Match(glob, doc.Filepath); matched {
+			return true
+		}
+	}
+	for _, title := range q.Titles {
+		if (title != "") && strings.Contains(strings.ToLower(doc.Title), strings.ToLower(title)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the query has nothing to select on
+func (q Query) Empty() bool {
+	return (len(q.Keys) == 0) && (len(q.Md5s) == 0) && (len(q.PartNums) == 0) && (len(q.PathGlobs) == 0) && (len(q.Titles) == 0)
+}
+
+// Returns a new catalog holding only the documents selected by the query
+func (c Catalog) Select(q Query) Catalog {
+	return c.Filter(q.Matches)
+}
+
+// Index maps a value (e.g. a part number) to the keys of the documents that have that value, in key order
+type Index map[string][]string
+
+// Builds an index of the catalog on the value returned by field. Documents for which field returns "" are not indexed.
+func (c Catalog) IndexBy(field func(doc Document) string) Index {
+	index := make(Index)
+	for _, key := range c.Keys() {
+		if value := field(c[key]); value != "" {
+			index[value] = append(index[value], key)
+		}
+	}
+	return index
+}
+
+// Builds an index of the catalog on every value returned by fields, so a document may appear under several values.
+// Blank values are not indexed.
+func (c Catalog) IndexByAll(fields func(doc Document) []string) Index {
+	index := make(Index)
+	for _, key := range c.Keys() {
+		for _, value := range fields(c[key]) {
+			if (value != "") && !slices.Contains(index[value], key) {
+				index[value] = append(index[value], key)
+			}
+		}
+	}
+	return index
+}
+
+// Builds an index of the catalog by MD5 checksum
+func (c Catalog) IndexByMd5() Index {
+	return c.IndexBy(func(doc Document) string { return doc.Md5 })
+}
+
+// Builds an index of the catalog by part number, including the aliases in AltPartNums
+func (c Catalog) IndexByPartNum() Index {
+	return c.IndexByAll(document.PartNumbers)
+}
+
+// Builds an index of the catalog by file path (e.g. file:///DEC_0001/vax/ka630.pdf or a URL)
+func (c Catalog) IndexByFilepath() Index {
+	return c.IndexBy(func(doc Document) string { return doc.Filepath })
+}
+
+// Builds an index of the catalog by filename, i.e. the last element of the file path
+func (c Catalog) IndexByFilename() Index {
+	return c.IndexBy(func(doc Document) string {
+		if doc.Filepath == "" {
+			return ""
+		}
+		return path.Base(doc.Filepath)
+	})
+}
+
+// Builds an index of the catalog by normalised part number (see document.NormalisePartNumber), which groups the
+// format variants (PDF, TXT, RNO, ...) of each publication together.
+func (c Catalog) IndexByPublication() Index {
+	return c.IndexBy(func(doc Document) string { return document.NormalisePartNumber(doc.PartNum) })
+}
+
+// Publication is the set of documents in a catalog that share a normalised part number
+type Publication struct {
+	PartNum string   // The normalised part number
+	Keys    []string // The keys of the documents, sorted
+	Formats []string // The distinct formats of the documents, sorted
+}
+
+// Groups the documents that have a part number into publications, in order of part number.
+func (c Catalog) Publications() []Publication {
+	index := c.IndexByPublication()
+	publications := make([]Publication, 0, len(index))
+	for partNum, keys := range index {
+		publication := Publication{PartNum: partNum, Keys: keys}
+		for _, key := range keys {
+			if format := c[key].Format; !slices.Contains(publication.Formats, format) {
+				publication.Formats = append(publication.Formats, format)
+			}
+		}
+		sort.Strings(publication.Formats)
+		publications = append(publications, publication)
+	}
+	sort.Slice(publications, func(i, j int) bool { return publications[i].PartNum < publications[j].PartNum })
+	return publications
+}
+
+// Returns the publications that exist in more than one format
+func (c Catalog) FormatVariants() []Publication {
+	var variants []Publication
+	for _, publication := range c.Publications() {
+		if len(publication.Formats) > 1 {
+			variants = append(variants, publication)
+		}
+	}
+	return variants
+}
+
+// Adds every document in other whose key is not already present.
+// Returns the keys that were already present (whose documents were left unchanged), sorted.
+func (c Catalog) Add(other Catalog) []string {
+	var existing []string
+	for _, key := range other.Keys() {
+		if _, found := c[key]; found {
+			existing = append(existing, key)
+		} else {
+			c[key] = other[key]
+		}
+	}
+	return existing
+}
+
+// Copies the user annotations (Notes, Tags, the Location of any physical copy, the Visibility and a DocType given by
+// hand), the OCR status recorded by ocr-queue, the PDF/A status recorded by pdfa-check, the quality score recorded by
+// score-catalog, a publication date inferred by infer-pubdates, the other Copies of an unchanged file and
+// the known Redirects of its URLs from previous, e.g. the catalog written by an earlier run, into the matching
+// documents of c, so that regenerating a catalog does not lose them. A document matches one in previous with the same
+// key or, failing that, the same filepath. Returns the number of documents that gained annotations.
+func (c Catalog) PreserveAnnotations(previous Catalog) int {
+	previousByFilepath := previous.IndexByFilepath()
+	annotated := 0
+	for key, doc := range c {
+		old, found := previous[key]
+		if !found {
+			keys := previousByFilepath[doc.Filepath]
+			if len(keys) == 0 {
+				continue
+			}
+			old = previous[keys[0]]
+		}
+		changed := document.AddTags(&doc, old.Tags...)
+		for _, line := range strings.Split(old.Notes, "\n") {
+			changed = document.AppendNotes(&doc, line) || changed
+		}
+		if (doc.Location == "") && (old.Location != "") {
+			doc.Location = old.Location
+			changed = true
+		}
+		if (doc.Visibility == "") && (old.Visibility != "") {
+			doc.Visibility = old.Visibility
+			changed = true
+		}
+		// A type given by hand (see internal/doctype) takes precedence over one the classification rules would give
+		if codeSet := document.CodeSetFlags["DocType"]; (old.DocType != "") && !strings.Contains(old.Flags, codeSet) && (doc.DocType != old.DocType) {
+			doc.DocType = old.DocType
+			document.ClearFlags(&doc, codeSet)
+			changed = true
+		}
+		// The OCR and PDF/A statuses and the quality score belong to the file, so they are only carried over if the file is unchanged
+		if (doc.OcrStatus == "") && (old.OcrStatus != "") && (old.Md5 == doc.Md5) {
+			doc.OcrStatus, doc.OcrOutput = old.OcrStatus, old.OcrOutput
+			changed = true
+		}
+		if (doc.Sha256 == "") && (old.Sha256 != "") && (old.Md5 == doc.Md5) {
+			doc.Sha256 = old.Sha256
+			changed = true
+		}
+		if (doc.PdfA == "") && (old.PdfA != "") && (old.Md5 == doc.Md5) {
+			doc.PdfA = old.PdfA
+			changed = true
+		}
+		if (doc.Quality == 0) && (old.Quality != 0) && (old.Md5 == doc.Md5) {
+			doc.Quality, doc.Pages, doc.Dpi = old.Quality, old.Pages, old.Dpi
+			changed = true
+		}
+		// As is a publication date inferred from the file's first page, unless a date has been found since
+		if (doc.PubDate == "") && (old.DateSource == pubdate.Inferred) && (old.Md5 == doc.Md5) {
+			pubdate.Apply(&doc, pubdate.Inference{Date: old.PubDate, Confidence: old.Confidence})
+			changed = true
+		}
+		// So are the other places the file was known to be held (and when each was last verified)
+		if (old.Md5 == doc.Md5) && (doc.Md5 != "") && (len(old.Copies) > 0) {
+			doc.Copies = slices.Clone(doc.Copies)
+			changed = document.AddLocations(&doc, old.Copies...) || changed
+		}
+		// A URL known to redirect is moved to where it leads, whatever the file
+		changed = document.AddRedirects(&doc, old.Redirects) || changed
+		if changed {
+			c[key] = doc
+			annotated += 1
+		}
+	}
+	return annotated
+}
+
+// Preserves the annotations in the catalog file (if it exists) that c is about to replace. See PreserveAnnotations.
+func (c Catalog) PreserveAnnotationsFrom(filename string) (int, error) {
+	previous, err := LoadIfExists(filename)
+	if err != nil {
+		return 0, err
+	}
+	return c.PreserveAnnotations(previous), nil
+}
+
+// Conflict describes a change that could not be merged automatically
+type Conflict = catalogmerge.Conflict
+
+// MergeResult is the outcome of a Merge
+type MergeResult = catalogmerge.Result
+
+// Merges two copies of a catalog that have diverged. base is their common ancestor; if it is nil, a two-way merge is performed.
+// See MergeResult for the merged catalog and any conflicts.
+func Merge(base Catalog, ours Catalog, theirs Catalog) MergeResult {
+	return catalogmerge.Merge(base, ours, theirs)
+}
+
+// DuplicatePolicy chooses which of two documents with the same MD5 checksum to keep, e.g. "local,richer"
+// (see internal/retention)
+type DuplicatePolicy = retention.Policy
+
+// Parses a duplicate policy: a comma-separated list of the rules first, local, richer, newer and quality, tried in order
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	return retention.Parse(s)
+}
+
+// Merges two copies of a catalog as Merge does, except that the policy chooses which copy of a document added on both
+// sides is kept, rather than reporting conflicts between them. The decision is recorded in the document's Provenance.
+func MergeWithPolicy(base Catalog, ours Catalog, theirs Catalog, policy DuplicatePolicy) MergeResult {
+	return catalogmerge.MergeWithPolicy(base, ours, theirs, policy)
+}
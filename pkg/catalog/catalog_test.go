@@ -0,0 +1,341 @@
+package catalog
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/pubdate"
+	"docs-to-yaml/internal/workspace"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testCatalog() Catalog {
+	return Catalog{
+		"md5-a": {Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM", Md5: "md5-a", Format: "PDF", Filepath: "file:///DEC_0001/vax/ka630.pdf"},
+		"md5-b": {Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM", Md5: "md5-b", Format: "TXT", Filepath: "file:///DEC_0002/vax/ka630.txt"},
+		"md5-c": {Title: "VAX Field Guide", Md5: "md5-c", Format: "PDF", Filepath: "http://www.vaxhaven.com/images/f/ka630.pdf"},
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "catalog.yaml")
+	if err := Save(filename, testCatalog()); err != nil {
+		t.Fatalf(`Save() failed: %v`, err)
+	}
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatalf(`Load() failed: %v`, err)
+	}
+	// Each document's filepath becomes its first location
+	expected := testCatalog()
+	for key, doc := range expected {
+		doc.Copies = []document.FileLocation{document.LocationOf(doc.Filepath, doc.Size)}
+		expected[key] = doc
+	}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf(`Load() = %+v, expected %+v`, loaded, expected)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf(`Load() of a missing file succeeded`)
+	}
+	empty, err := LoadIfExists(filepath.Join(dir, "missing.yaml"))
+	if (err != nil) || (empty == nil) || (len(empty) != 0) {
+		t.Errorf(`LoadIfExists() of a missing file = %v, %v`, empty, err)
+	}
+}
+
+func TestLoadSaveWorkspace(t *testing.T) {
+	t.Setenv(workspace.RootVariable, t.TempDir())
+	if empty, err := LoadIfExists("ws:catalogs/local"); (err != nil) || (len(empty) != 0) {
+		t.Errorf(`LoadIfExists() of a workspace name never written = %v, %v`, empty, err)
+	}
+	if err := Save("ws:catalogs/local", testCatalog()); err != nil {
+		t.Fatalf(`Save() to a workspace name failed: %v`, err)
+	}
+	smaller := testCatalog()
+	delete(smaller, "md5-c")
+	if err := Save("ws:catalogs/local", smaller); err != nil {
+		t.Fatalf(`Save() to a workspace name again failed: %v`, err)
+	}
+	loaded, err := Load("ws:catalogs/local")
+	if (err != nil) || (len(loaded) != 2) {
+		t.Errorf(`Load() of a workspace name = %d documents, %v; expected the latest`, len(loaded), err)
+	}
+	entries, _ := workspace.List(workspace.Root())
+	if (len(entries) != 1) || (len(entries[0].Artifacts) != 2) {
+		t.Errorf(`Save() to a workspace name twice gave %+v`, entries)
+	}
+}
+
+func TestLoadReadOnly(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "theirs.yaml")
+	documents := Catalog{
+		"a": {Format: "PDF", Filepath: "http://bitsavers.org/pdf/a.pdf"},
+		"b": {Format: "PDF", Filepath: "http://bitsavers.org/pdf/b.pdf", Origin: "original.yaml"},
+	}
+	if err := Save(filename, documents); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReadOnly(filename)
+	if err != nil {
+		t.Fatalf("LoadReadOnly() failed: %v", err)
+	}
+	if (loaded["a"].Origin != filename) || (loaded["b"].Origin != "original.yaml") {
+		t.Errorf("LoadReadOnly() gave origins %q and %q", loaded["a"].Origin, loaded["b"].Origin)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	c := testCatalog()
+	if index := c.IndexByPartNum(); !reflect.DeepEqual(index, Index{"EK-KA630-TM": {"md5-a", "md5-b"}}) {
+		t.Errorf(`IndexByPartNum() = %v`, index)
+	}
+	if index := c.IndexByFilename(); !reflect.DeepEqual(index["ka630.pdf"], []string{"md5-a", "md5-c"}) {
+		t.Errorf(`IndexByFilename() = %v`, index)
+	}
+	if index := c.IndexByMd5(); len(index) != 3 {
+		t.Errorf(`IndexByMd5() = %v`, index)
+	}
+	if index := c.IndexByFilepath(); !reflect.DeepEqual(index["file:///DEC_0002/vax/ka630.txt"], []string{"md5-b"}) {
+		t.Errorf(`IndexByFilepath() = %v`, index)
+	}
+}
+
+func TestFilterAndAdd(t *testing.T) {
+	c := testCatalog()
+	pdfs := c.Filter(func(key string, doc Document) bool { return doc.Format == "PDF" })
+	if !reflect.DeepEqual(pdfs.Keys(), []string{"md5-a", "md5-c"}) {
+		t.Errorf(`Filter() kept %v`, pdfs.Keys())
+	}
+
+	other := Catalog{"md5-a": {Title: "Changed"}, "md5-d": {Title: "New"}}
+	existing := pdfs.Add(other)
+	if !reflect.DeepEqual(existing, []string{"md5-a"}) {
+		t.Errorf(`Add() reported %v as existing`, existing)
+	}
+	if (pdfs["md5-a"].Title != c["md5-a"].Title) || (pdfs["md5-d"].Title != "New") {
+		t.Errorf(`Add() gave %+v`, pdfs)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := testCatalog()
+	ours := testCatalog()
+	theirs := testCatalog()
+	doc := ours["md5-c"]
+	doc.PubDate = "1985"
+	ours["md5-c"] = doc
+	delete(theirs, "md5-b")
+
+	result := Merge(base, ours, theirs)
+	if len(result.Conflicts) != 0 {
+		t.Errorf(`Merge() conflicts: %+v`, result.Conflicts)
+	}
+	if !reflect.DeepEqual(Catalog(result.Documents).Keys(), []string{"md5-a", "md5-c"}) || (result.Documents["md5-c"].PubDate != "1985") {
+		t.Errorf(`Merge() = %+v`, result.Documents)
+	}
+}
+
+func TestPreserveAnnotations(t *testing.T) {
+	previous := testCatalog()
+	a := previous["md5-a"]
+	a.Notes = "page 37 missing"
+	a.Tags = []string{"rare"}
+	previous["md5-a"] = a
+	c := previous["md5-c"]
+	c.Notes = "mirror of vaxhaven"
+	previous["old-key-c"] = c
+	delete(previous, "md5-c")
+
+	current := testCatalog()
+	if annotated := current.PreserveAnnotations(previous); annotated != 2 {
+		t.Errorf(`PreserveAnnotations() annotated %d documents`, annotated)
+	}
+	if (current["md5-a"].Notes != "page 37 missing") || !reflect.DeepEqual(current["md5-a"].Tags, []string{"rare"}) {
+		t.Errorf(`PreserveAnnotations() by key gave %+v`, current["md5-a"])
+	}
+	if current["md5-c"].Notes != "mirror of vaxhaven" {
+		t.Errorf(`PreserveAnnotations() by filepath gave %+v`, current["md5-c"])
+	}
+	if current.PreserveAnnotations(previous) != 0 {
+		t.Errorf(`PreserveAnnotations() is not idempotent`)
+	}
+}
+
+func TestPreserveInferredPubDate(t *testing.T) {
+	previous := testCatalog()
+	a := previous["md5-a"]
+	pubdate.Apply(&a, pubdate.Inference{Date: "1987-03", Confidence: 0.8})
+	previous["md5-a"] = a
+	b := previous["md5-b"]
+	pubdate.Apply(&b, pubdate.Inference{Date: "1985", Confidence: 0.5})
+	previous["md5-b"] = b
+
+	current := testCatalog()
+	b = current["md5-b"]
+	b.PubDate = "1986-01"
+	current["md5-b"] = b
+	current.PreserveAnnotations(previous)
+	if a := current["md5-a"]; (a.PubDate != "1987-03") || (a.DateSource != pubdate.Inferred) || (a.Confidence != 0.8) {
+		t.Errorf(`PreserveAnnotations() of an inferred date gave %+v`, a)
+	}
+	if b := current["md5-b"]; (b.PubDate != "1986-01") || (b.DateSource != "") {
+		t.Errorf(`PreserveAnnotations() replaced a date found since with %+v`, b)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	c := testCatalog()
+	a := c["md5-a"]
+	a.Visibility = "private"
+	c["md5-a"] = a
+	b := c["md5-b"]
+	b.Visibility = "restricted"
+	c["md5-b"] = b
+
+	if public := c.Visible("public"); (len(public) != len(c)-2) || (public["md5-a"].Md5 != "") || (public["md5-b"].Md5 != "") {
+		t.Errorf(`Visible("public") = %v`, public.Keys())
+	}
+	if restricted := c.Visible("restricted"); (len(restricted) != len(c)-1) || (restricted["md5-b"].Md5 == "") {
+		t.Errorf(`Visible("restricted") = %v`, restricted.Keys())
+	}
+	if everything := c.Visible("private"); len(everything) != len(c) {
+		t.Errorf(`Visible("private") = %v`, everything.Keys())
+	}
+
+	// The visibility is given by hand, so it survives regenerating the catalog
+	current := testCatalog()
+	if (current.PreserveAnnotations(c) != 2) || (current["md5-a"].Visibility != "private") {
+		t.Errorf(`PreserveAnnotations() gave %+v`, current["md5-a"])
+	}
+}
+
+func TestPreserveDocType(t *testing.T) {
+	previous := testCatalog()
+	a := previous["md5-a"]
+	a.DocType = "print-set"
+	previous["md5-a"] = a
+	b := previous["md5-b"]
+	b.DocType, b.Flags = "manual", "K"
+	previous["md5-b"] = b
+
+	// Only the type given by hand is kept; the rules decide the other afresh
+	current := testCatalog()
+	if (current.PreserveAnnotations(previous) != 1) || (current["md5-a"].DocType != "print-set") || (current["md5-b"].DocType != "") {
+		t.Errorf(`PreserveAnnotations() gave %+v, %+v`, current["md5-a"], current["md5-b"])
+	}
+}
+
+func TestPreserveOcrStatus(t *testing.T) {
+	previous := testCatalog()
+	a := previous["md5-a"]
+	a.OcrStatus, a.OcrOutput = "done", "/ocr/DEC_0001/vax/ka630.pdf"
+	previous["md5-a"] = a
+
+	current := testCatalog()
+	if current.PreserveAnnotations(previous) != 1 || (current["md5-a"].OcrStatus != "done") || (current["md5-a"].OcrOutput != a.OcrOutput) {
+		t.Errorf(`PreserveAnnotations() gave %+v`, current["md5-a"])
+	}
+
+	// A changed file needs checking again
+	current = testCatalog()
+	changed := current["md5-a"]
+	changed.Md5 = "md5-changed"
+	current["md5-a"] = changed
+	if current.PreserveAnnotations(previous) != 0 || (current["md5-a"].OcrStatus != "") {
+		t.Errorf(`PreserveAnnotations() of a changed file gave %+v`, current["md5-a"])
+	}
+}
+
+func TestPreserveLocations(t *testing.T) {
+	previous := testCatalog()
+	a := previous["md5-a"]
+	a.Copies = []document.FileLocation{
+		{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf", Verified: "2024-05-01"},
+		{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+	}
+	previous["md5-a"] = a
+
+	current := testCatalog()
+	a = current["md5-a"]
+	document.NormaliseLocations(&a)
+	current["md5-a"] = a
+	if current.PreserveAnnotations(previous) != 1 || !reflect.DeepEqual(current["md5-a"].Copies, previous["md5-a"].Copies) {
+		t.Errorf(`PreserveAnnotations() gave %+v`, current["md5-a"].Copies)
+	}
+}
+
+func TestPreserveRedirects(t *testing.T) {
+	redirects := map[string]string{"http://www.vaxhaven.com/images/ka630.pdf": "https://vaxhaven.com/images/ka630.pdf"}
+	previous := Catalog{"EK-KA630-TM-001": {PartNum: "EK-KA630-TM-001", Filepath: "https://vaxhaven.com/images/ka630.pdf", Redirects: redirects}}
+	current := Catalog{"EK-KA630-TM-001": {PartNum: "EK-KA630-TM-001", Filepath: "http://www.vaxhaven.com/images/ka630.pdf"}}
+	if (current.PreserveAnnotations(previous) != 1) || (current["EK-KA630-TM-001"].Filepath != "https://vaxhaven.com/images/ka630.pdf") || !reflect.DeepEqual(current["EK-KA630-TM-001"].Redirects, redirects) {
+		t.Errorf(`PreserveAnnotations() gave %+v`, current["EK-KA630-TM-001"])
+	}
+}
+
+func TestLoadLegacyLocations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "catalog.yaml")
+	legacy := "md5-a:\n  format: PDF\n  size: 1234\n  md5: md5-a\n  filepath: file:///DEC_0001/vax/ka630.pdf\n  publicurl: http://bitsavers.org/pdf/dec/vax/ka630.pdf\n  altfilepaths:\n    - file:///DEC_0002/ka630.pdf\n"
+	if err := os.WriteFile(filename, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatalf(`Load() failed: %v`, err)
+	}
+	expected := []document.FileLocation{
+		{Type: document.LocalLocation, Volume: "DEC_0001", Path: "vax/ka630.pdf", Size: 1234},
+		{Type: document.RemoteLocation, Url: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		{Type: document.LocalLocation, Volume: "DEC_0002", Path: "ka630.pdf", Size: 1234},
+	}
+	if !reflect.DeepEqual(loaded["md5-a"].Copies, expected) || (loaded["md5-a"].AltFilepaths != nil) {
+		t.Errorf(`Load() locations = %+v`, loaded["md5-a"].Copies)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	c := testCatalog()
+	query := Query{Keys: []string{"md5-c"}, PartNums: []string{"ek-ka630-tm"}}
+	if selected := c.Select(query).Keys(); !reflect.DeepEqual(selected, []string{"md5-a", "md5-b", "md5-c"}) {
+		t.Errorf(`Select() = %v`, selected)
+	}
+	query = Query{PathGlobs: []string{"file:///DEC_000?/vax/*.pdf"}}
+	if selected := c.Select(query).Keys(); !reflect.DeepEqual(selected, []string{"md5-a"}) {
+		t.Errorf(`Select() = %v`, selected)
+	}
+	query = Query{Titles: []string{"field GUIDE"}}
+	if selected := c.Select(query).Keys(); !reflect.DeepEqual(selected, []string{"md5-c"}) {
+		t.Errorf(`Select() by title = %v`, selected)
+	}
+	if err := (Query{PathGlobs: []string{"["}}).Validate(); err == nil {
+		t.Errorf(`Validate() accepted a bad glob`)
+	}
+	c["md5-d"] = Document{PartNum: "AA-0196C-TK", AltPartNums: []string{"AA-0196B-TK"}, Md5: "md5-d"}
+	query = Query{PartNums: []string{"aa-0196b-tk"}}
+	if selected := c.Select(query).Keys(); !reflect.DeepEqual(selected, []string{"md5-d"}) {
+		t.Errorf(`Select() by alias = %v`, selected)
+	}
+	if index := c.IndexByPartNum(); !reflect.DeepEqual(index["AA-0196B-TK"], []string{"md5-d"}) || !reflect.DeepEqual(index["AA-0196C-TK"], []string{"md5-d"}) {
+		t.Errorf(`IndexByPartNum() with aliases = %v`, index)
+	}
+	if !(Query{}).Empty() || (len(c.Select(Query{})) != 0) {
+		t.Errorf(`an empty Query selected documents`)
+	}
+}
+
+func TestPublications(t *testing.T) {
+	c := testCatalog()
+	c["md5-d"] = Document{PartNum: "ek-ka630-tm", Format: "PDF", Md5: "md5-d"}
+	variants := c.FormatVariants()
+	expected := []Publication{{PartNum: "EKKA630TM", Keys: []string{"md5-a", "md5-b", "md5-d"}, Formats: []string{"PDF", "TXT"}}}
+	if !reflect.DeepEqual(variants, expected) {
+		t.Errorf(`FormatVariants() = %+v`, variants)
+	}
+	if publications := c.Publications(); len(publications) != 1 {
+		t.Errorf(`Publications() = %+v`, publications)
+	}
+}
@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// CollisionReport describes two distinct documents that were given the same key
+type CollisionReport struct {
+	Key           string        // The key both documents map to
+	Fields        []FieldChange // The fields that differ, Old from the first document and New from the second
+	Disambiguator string        // What would separate the documents: see Disambiguators
+}
+
+// The values of CollisionReport.Disambiguator, in the order they are preferred. A revision, date or size says how the
+// documents really differ; failing those, the MD5 checksum or filepath at least keeps them apart.
+var Disambiguators = []string{"revision", "date", "size", "md5", "filepath"}
+
+// A revision given in a title or filename, e.g. "Rev B", "revision 2" or "_revC"
+var revisionRegexp = regexp.MustCompile(`(?i)(?:^|[^a-z])rev(?:ision)?[ ._-]*([a-z0-9]{1,3})\b`)
+
+// Returns the revision given in a document's title or filename, upper-cased, or "" if there is none
+func Revision(doc Document) string {
+	for _, text := range []string{doc.Title, path.Base(doc.Filepath)} {
+		if match := revisionRegexp.FindStringSubmatch(text); match != nil {
+			return strings.ToUpper(match[1])
+		}
+	}
+	return ""
+}
+
+// Compares two documents that were given the same key: which fields differ, and which disambiguator would separate them
+func ReportCollision(key string, first Document, second Document) CollisionReport {
+	report := CollisionReport{Key: key, Fields: diffDocument(first, second)}
+	firstRevision, secondRevision := Revision(first), Revision(second)
+	switch {
+	case (firstRevision != secondRevision) && (firstRevision != "") && (secondRevision != ""):
+		report.Disambiguator = "revision"
+	case (first.PubDate != second.PubDate) && (first.PubDate != "") && (second.PubDate != ""):
+		report.Disambiguator = "date"
+	case (first.Size != second.Size) && (first.Size != 0) && (second.Size != 0):
+		report.Disambiguator = "size"
+	case (first.Md5 != second.Md5) && (first.Md5 != "") && (second.Md5 != ""):
+		report.Disambiguator = "md5"
+	default:
+		report.Disambiguator = "filepath"
+	}
+	return report
+}
+
+// Writes a collision report with the differing fields of the two documents side by side, headed by the names the
+// documents are known by (e.g. their old keys), and the suggested disambiguator
+func WriteCollision(w io.Writer, report CollisionReport, firstName string, secondName string) {
+	fmt.Fprintf(w, "Collision on %s:\n", report.Key)
+	fmt.Fprintf(w, "    %-12s %-40s %s\n", "", firstName, secondName)
+	for _, field := range report.Fields {
+		fmt.Fprintf(w, "    %-12s %-40s %s\n", strings.ToLower(field.Field), quoteIfBlank(field.Old), quoteIfBlank(field.New))
+	}
+	fmt.Fprintf(w, "    suggested disambiguator: %s\n", report.Disambiguator)
+}
+
+// Shows a blank value as "" so that the columns still line up
+func quoteIfBlank(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+package catalog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReportCollision(t *testing.T) {
+	first := Document{PartNum: "EK-KA630-TM", Title: "KA630 Technical Manual", Size: 1000, Filepath: "file:///DEC_0001/ka630.pdf"}
+	for _, test := range []struct {
+		second   Document
+		expected string
+	}{
+		{Document{PartNum: "EK-KA630-TM", Title: "KA630 Technical Manual Rev B", Size: 1000, Filepath: "file:///DEC_0001/ka630_revC.pdf"}, "filepath"}, // Only one has a revision
+		{Document{PartNum: "EK-KA630-TM", Title: "KA630 Technical Manual", Size: 2000, Filepath: "file:///DEC_0002/ka630.pdf"}, "size"},
+		{Document{PartNum: "EK-KA630-TM", Title: "KA630 Technical Manual", Size: 1000, Md5: "aaaa", Filepath: "file:///DEC_0002/ka630.pdf"}, "filepath"},
+	} {
+		if report := ReportCollision("EK-KA630-TM.pdf", first, test.second); report.Disambiguator != test.expected {
+			t.Errorf(`ReportCollision(%+v) = %q, expected %q`, test.second, report.Disambiguator, test.expected)
+		}
+	}
+	// Revisions are preferred to dates, and dates to sizes
+	revised := Document{Title: "KA630 Technical Manual, Revision A", PubDate: "1986-01", Size: 1000}
+	if report := ReportCollision("k", revised, Document{Title: "KA630 Technical Manual", PubDate: "1987-03", Size: 2000, Filepath: "x/ka630-rev-b.pdf"}); report.Disambiguator != "revision" {
+		t.Errorf(`ReportCollision() of revisions = %q`, report.Disambiguator)
+	}
+	if report := ReportCollision("k", revised, Document{Title: "KA630 Technical Manual, Revision A", PubDate: "1987-03", Size: 2000}); report.Disambiguator != "date" {
+		t.Errorf(`ReportCollision() of dates = %q`, report.Disambiguator)
+	}
+	if revision := Revision(Document{Title: "Previous Edition"}); revision != "" {
+		t.Errorf(`Revision() found %q in "Previous Edition"`, revision)
+	}
+
+	second := Document{PartNum: "EK-KA630-TM", Title: "KA630 Technical Manual", Size: 2000}
+	var out bytes.Buffer
+	WriteCollision(&out, ReportCollision("EK-KA630-TM.pdf", first, second), "old-1", "old-2")
+	report := out.String()
+	for _, line := range []string{`Collision on EK-KA630-TM.pdf:`, `    size         1000                                     2000`, `    filepath     file:///DEC_0001/ka630.pdf               ""`, `    suggested disambiguator: size`} {
+		if !strings.Contains(report, line+"\n") {
+			t.Errorf(`WriteCollision() lacks %q:\n%s`, line, report)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package catalog
+
+import (
+	"bufio"
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// The legal values for Change.Kind
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// FieldChange is a change to one field of a document
+type FieldChange struct {
+	Field string // The Document field, e.g. "Title"
+	Old   string
+	New   string
+}
+
+// Change describes how one document differs between two catalogs
+type Change struct {
+	Key    string
+	Kind   string        // Added, Removed or Modified
+	Fields []FieldChange // The fields that differ, for a Modified document
+}
+
+// Returns the differences between the catalog old and its replacement new, in key order.
+// The documents of new are compared as Save would write them (see document.NormaliseText), so text that Save would
+// normalise is not reported as a change.
+func Diff(old Catalog, new Catalog) []Change {
+	var changes []Change
+	keys := old.Keys()
+	for key := range new {
+		if _, found := old[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		oldDoc, inOld := old[key]
+		newDoc, inNew := new[key]
+		if !inNew {
+			changes = append(changes, Change{Key: key, Kind: Removed})
+			continue
+		} else if !inOld {
+			changes = append(changes, Change{Key: key, Kind: Added})
+			continue
+		}
+		newDoc.AltPartNums, newDoc.Tags = slices.Clone(newDoc.AltPartNums), slices.Clone(newDoc.Tags)
+		document.NormaliseText(&newDoc)
+		if fields := diffDocument(oldDoc, newDoc); len(fields) > 0 {
+			changes = append(changes, Change{Key: key, Kind: Modified, Fields: fields})
+		}
+	}
+	return changes
+}
+
+// Returns the fields that differ between two versions of a document, in field order
+func diffDocument(old Document, new Document) []FieldChange {
+	var fields []FieldChange
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		oldField, newField := oldValue.Field(i), newValue.Field(i)
+		// A nil and an empty list (or map) are written identically
+		isList := (oldField.Kind() == reflect.Slice) || (oldField.Kind() == reflect.Map)
+		if (oldField.IsZero() && newField.IsZero()) || (isList && (oldField.Len() == 0) && (newField.Len() == 0)) {
+			continue
+		}
+		if !reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			fields = append(fields, FieldChange{Field: oldValue.Type().Field(i).Name, Old: fmt.Sprint(oldField.Interface()), New: fmt.Sprint(newField.Interface())})
+		}
+	}
+	return fields
+}
+
+// Writes a concise report of the changes: one line per added or removed document (with its title) and one line per
+// changed field of a modified document, followed by the totals.
+func WriteDiff(w io.Writer, changes []Change, old Catalog, new Catalog) {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Kind] += 1
+		switch change.Kind {
+		case Added:
+			fmt.Fprintf(w, "+ %s %q\n", change.Key, new[change.Key].Title)
+		case Removed:
+			fmt.Fprintf(w, "- %s %q\n", change.Key, old[change.Key].Title)
+		case Modified:
+			fmt.Fprintf(w, "~ %s\n", change.Key)
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "    %s: %q => %q\n", strings.ToLower(field.Field), field.Old, field.New)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d modified\n", counts[Added], counts[Removed], counts[Modified])
+}
+
+// Shows what saving documents to filename would change (see Diff and WriteDiff) and asks on out for confirmation,
+// read as a line from in. Returns true if the catalog should be written, i.e. if the answer is "y" or "yes".
+// Returns false, without asking, if nothing would change. A file that does not exist yet is treated as empty.
+func Preview(filename string, documents Catalog, in io.Reader, out io.Writer) (bool, error) {
+	old, err := LoadIfExists(filename)
+	if err != nil {
+		return false, err
+	}
+	changes := Diff(old, documents)
+	if len(changes) == 0 {
+		fmt.Fprintf(out, "No changes to %s\n", filename)
+		return false, nil
+	}
+	WriteDiff(out, changes, old, documents)
+	fmt.Fprintf(out, "Write %s? [y/N] ", filename)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if (err != nil) && (err != io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return (answer == "y") || (answer == "yes"), nil
+}
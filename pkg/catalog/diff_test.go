@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := Catalog{
+		"a": {Title: "VAX Handbook", PartNum: "EK-VAXAR-HB", Tags: []string{}},
+		"b": {Title: "PDP-11 Handbook"},
+		"c": {Title: "Unchanged"},
+	}
+	new := Catalog{
+		"a": {Title: "VAX Architecture Handbook", PartNum: "EK-VAXAR-HB", Tags: []string{"rare"}},
+		"c": {Title: "Unchanged"},
+		"d": {Title: "New Manual"},
+	}
+	expected := []Change{
+		{Key: "a", Kind: Modified, Fields: []FieldChange{{"Title", "VAX Handbook", "VAX Architecture Handbook"}, {"Tags", "[]", "[rare]"}}},
+		{Key: "b", Kind: Removed},
+		{Key: "d", Kind: Added},
+	}
+	changes := Diff(old, new)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf(`Diff() = %+v, expected %+v`, changes, expected)
+	}
+
+	var out bytes.Buffer
+	WriteDiff(&out, changes, old, new)
+	report := out.String()
+	for _, line := range []string{`~ a`, `    title: "VAX Handbook" => "VAX Architecture Handbook"`, `- b "PDP-11 Handbook"`, `+ d "New Manual"`, `1 added, 1 removed, 1 modified`} {
+		if !strings.Contains(report, line+"\n") {
+			t.Errorf(`WriteDiff() lacks %q:\n%s`, line, report)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "catalog.yaml")
+	documents := Catalog{"a": {Title: "VAX Handbook"}}
+	answers := map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false}
+	for answer, expected := range answers {
+		var out bytes.Buffer
+		confirmed, err := Preview(filename, documents, strings.NewReader(answer), &out)
+		if (err != nil) || (confirmed != expected) || !strings.Contains(out.String(), "Write "+filename+"? [y/N]") {
+			t.Errorf(`Preview() answered %q = %v, %v:\n%s`, answer, confirmed, err, out.String())
+		}
+	}
+
+	Save(filename, documents)
+	var out bytes.Buffer
+	if confirmed, err := Preview(filename, documents, strings.NewReader("y\n"), &out); confirmed || (err != nil) || !strings.HasPrefix(out.String(), "No changes") {
+		t.Errorf(`Preview() of an unchanged catalog = %v, %v: %s`, confirmed, err, out.String())
+	}
+}
@@ -0,0 +1,324 @@
+package catalog
+
+import (
+	"docs-to-yaml/internal/document"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled filter expression that selects documents by any of their fields, for example:
+//
+//	format = PDF and pubdate >= 1985 and pubdate < 1991 and collection = "DEC_0001" and not md5
+//
+// A comparison is FIELD OP VALUE, where FIELD is "key", "partnums" (the part number and its aliases) or any
+// Document field (in any case) and OP is one of:
+//
+//	=  !=         equal (or not), without regard to case
+//	<  <=  >  >=  ordered, numerically if both sides are numbers (e.g. size) and otherwise as strings (e.g. pubdate)
+//	~  !~         matches (or does not match) a regular expression
+//
+// A FIELD on its own is true if the field is not blank. For a list field (tags, altpartnums, partnums) a comparison
+// is true if any element satisfies it, and != and !~ are true only if no element matches.
+// VALUE is a single word or is quoted with ' or ". Comparisons are combined with "not", "and" and "or" (or "!",
+// "&&" and "||"), in that order of precedence, and grouped with parentheses.
+type Expr struct {
+	source string
+	root   exprNode
+}
+
+type exprNode interface {
+	matches(key string, doc Document) bool
+}
+
+type notNode struct{ operand exprNode }
+type andNode struct{ left, right exprNode }
+type orNode struct{ left, right exprNode }
+
+type compareNode struct {
+	field string // The field name, in lower case
+	op    string // The operator, or "" for a field on its own
+	value string
+	re    *regexp.Regexp // Compiled value for ~ and !~
+}
+
+func (n notNode) matches(key string, doc Document) bool { return !n.operand.matches(key, doc) }
+func (n andNode) matches(key string, doc Document) bool {
+	return n.left.matches(key, doc) && n.right.matches(key, doc)
+}
+func (n orNode) matches(key string, doc Document) bool {
+	return n.left.matches(key, doc) || n.right.matches(key, doc)
+}
+
+func (n compareNode) matches(key string, doc Document) bool {
+	values := exprFieldValues(n.field, key, doc)
+	switch n.op {
+	case "":
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		return !(compareNode{field: n.field, op: "=", value: n.value}).matches(key, doc)
+	case "!~":
+		return !(compareNode{field: n.field, op: "~", re: n.re}).matches(key, doc)
+	}
+	for _, v := range values {
+		if n.compare(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the operator to one value of the field
+func (n compareNode) compare(v string) bool {
+	switch n.op {
+	case "=":
+		return strings.EqualFold(v, n.value)
+	case "~":
+		return n.re.MatchString(v)
+	}
+	order := strings.Compare(v, n.value)
+	if a, err := strconv.ParseFloat(v, 64); err == nil {
+		if b, err := strconv.ParseFloat(n.value, 64); err == nil {
+			order = 0
+			if a < b {
+				order = -1
+			} else if a > b {
+				order = 1
+			}
+		}
+	}
+	switch n.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// Returns the value(s) of the named field of a document as strings
+func exprFieldValues(field string, key string, doc Document) []string {
+	switch field {
+	case "key":
+		return []string{key}
+	case "partnums":
+		return document.PartNumbers(doc)
+	}
+	value := reflect.ValueOf(doc).FieldByNameFunc(func(name string) bool { return strings.ToLower(name) == field })
+	if value.Kind() == reflect.Slice {
+		values := make([]string, value.Len())
+		for i := range values {
+			values[i] = fmt.Sprint(value.Index(i).Interface())
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value.Interface())}
+}
+
+// Returns the value(s) of a document field named as in a filter expression (see Expr), e.g. "format", "tags" or "key",
+// as strings. A list field gives one value per element.
+func FieldValues(name string, key string, doc Document) []string {
+	return exprFieldValues(strings.ToLower(name), key, doc)
+}
+
+// Reports whether name (in any case) is a field that can be used in a filter expression or given to FieldValues
+func IsField(name string) bool {
+	return exprFieldExists(strings.ToLower(name))
+}
+
+// Reports whether name is a field that can be used in an expression
+func exprFieldExists(name string) bool {
+	if (name == "key") || (name == "partnums") {
+		return true
+	}
+	_, found := reflect.TypeOf(Document{}).FieldByNameFunc(func(field string) bool { return strings.ToLower(field) == name })
+	return found
+}
+
+// Compiles a filter expression (see Expr). A blank expression gives a nil *Expr, which matches every document.
+func ParseExpr(source string) (*Expr, error) {
+	tokens, err := tokeniseExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", source, err)
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if (err == nil) && (p.pos < len(p.tokens)) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", source, err)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+// Reports whether the expression selects the document (whose catalog key is key). A nil *Expr selects everything.
+func (e *Expr) Matches(key string, doc Document) bool {
+	return (e == nil) || e.root.matches(key, doc)
+}
+
+// Returns the expression as it was written
+func (e *Expr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.source
+}
+
+// Returns a new catalog holding only the documents selected by the expression
+func (c Catalog) Where(e *Expr) Catalog {
+	return c.Filter(e.Matches)
+}
+
+type exprToken struct {
+	kind byte // 'w' for a word, 's' for a quoted string, 'o' for an operator, '(' or ')'
+	text string
+}
+
+var exprOperators = []string{"&&", "||", "!=", "!~", "<=", ">=", "==", "=", "<", ">", "~", "!"}
+
+// Splits an expression into tokens
+func tokeniseExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case (c == ' ') || (c == '\t') || (c == '\n'):
+			i += 1
+		case (c == '(') || (c == ')'):
+			tokens = append(tokens, exprToken{kind: c, text: string(c)})
+			i += 1
+		case (c == '"') || (c == '\''):
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: 's', text: s[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			op := ""
+			for _, candidate := range exprOperators {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, exprToken{kind: 'o', text: op})
+				i += len(op)
+				continue
+			}
+			start := i
+			for (i < len(s)) && !strings.ContainsRune(" \t\n()\"'&|!=<>~", rune(s[i])) {
+				i += 1
+			}
+			if i == start {
+				return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+			}
+			tokens = append(tokens, exprToken{kind: 'w', text: s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// Reports whether the next token is the keyword or operator given (keywords are not case sensitive) and if so consumes it
+func (p *exprParser) accept(keyword string, op string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	if ((t.kind == 'w') && strings.EqualFold(t.text, keyword)) || ((t.kind == 'o') && (t.text == op)) {
+		p.pos += 1
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for (err == nil) && p.accept("or", "||") {
+		var right exprNode
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	for (err == nil) && p.accept("and", "&&") {
+		var right exprNode
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("not", "!") {
+		operand, err := p.parseUnary()
+		return notNode{operand}, err
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos += 1
+	if t.kind == '(' {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if (p.pos >= len(p.tokens)) || (p.tokens[p.pos].kind != ')') {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos += 1
+		return node, nil
+	}
+	if t.kind != 'w' {
+		return nil, fmt.Errorf("expected a field name, found %q", t.text)
+	}
+	node := compareNode{field: strings.ToLower(t.text)}
+	if !exprFieldExists(node.field) {
+		return nil, fmt.Errorf("unknown field %q", t.text)
+	}
+	if (p.pos >= len(p.tokens)) || (p.tokens[p.pos].kind != 'o') || (p.tokens[p.pos].text == "!") ||
+		(p.tokens[p.pos].text == "&&") || (p.tokens[p.pos].text == "||") {
+		return node, nil
+	}
+	node.op = p.tokens[p.pos].text
+	if node.op == "==" {
+		node.op = "="
+	}
+	p.pos += 1
+	if (p.pos >= len(p.tokens)) || ((p.tokens[p.pos].kind != 'w') && (p.tokens[p.pos].kind != 's')) {
+		return nil, fmt.Errorf("expected a value after %s %s", t.text, node.op)
+	}
+	node.value = p.tokens[p.pos].text
+	p.pos += 1
+	if (node.op == "~") || (node.op == "!~") {
+		re, err := regexp.Compile(node.value)
+		if err != nil {
+			return nil, fmt.Errorf("bad regular expression %q: %w", node.value, err)
+		}
+		node.re = re
+	}
+	return node, nil
+}
@@ -0,0 +1,46 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	c := testCatalog()
+	c["md5-d"] = Document{Title: "ALGOL Reference", PartNum: "AA-0196C-TK", AltPartNums: []string{"AA-0196B-TK"}, PubDate: "1991-02", Format: "PDF", Size: 2048, Tags: []string{"rare"}, Collection: "DEC_0003"}
+	c["md5-a"] = Document{Title: "KA630", PartNum: "EK-KA630-TM", Md5: "md5-a", PubDate: "1986-03", Format: "PDF", Size: 100000, Collection: "DEC_0001"}
+
+	tests := map[string][]string{
+		"format = pdf": {"md5-a", "md5-c", "md5-d"},
+		"format = PDF and pubdate >= 1985 and pubdate < 1991": {"md5-a"},
+		"format == PDF && !md5":                               {"md5-d"},
+		"not (format = PDF or collection = DEC_0001)":         {"md5-b"},
+		"size > 4096":                          {"md5-a"},
+		"tags = rare":                          {"md5-d"},
+		"tags != rare and format = 'PDF'":      {"md5-a", "md5-c"},
+		"partnums = aa-0196b-tk":               {"md5-d"},
+		`filepath ~ "^http://" or key = md5-b`: {"md5-b", "md5-c"},
+		"title !~ KA630":                       {"md5-c", "md5-d"},
+	}
+	for source, expected := range tests {
+		expr, err := ParseExpr(source)
+		if err != nil {
+			t.Errorf(`ParseExpr(%q) failed: %v`, source, err)
+			continue
+		}
+		if selected := c.Where(expr).Keys(); !reflect.DeepEqual(selected, expected) {
+			t.Errorf(`Where(%q) = %v, expected %v`, source, selected, expected)
+		}
+	}
+
+	for _, source := range []string{"colour = red", "format =", "(format = PDF", "format = PDF PDF", "title ~ '['", "title = 'open", "and"} {
+		if _, err := ParseExpr(source); err == nil {
+			t.Errorf(`ParseExpr(%q) accepted a bad expression`, source)
+		}
+	}
+
+	expr, err := ParseExpr("  ")
+	if (expr != nil) || (err != nil) || (len(c.Where(expr)) != len(c)) {
+		t.Errorf(`ParseExpr() of a blank expression = %v, %v`, expr, err)
+	}
+}
@@ -0,0 +1,179 @@
+package catalog
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/fsutil"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A large catalog can be written as a partition directory rather than a single file: one YAML catalog per partition
+// (e.g. per volume), each an ordinary catalog, plus an index file listing them. Load reads a partition directory
+// as if it were one file, and Save rewrites one with the same partitioning, so any program can be pointed at either.
+
+// The name of the index file in a partition directory
+const IndexFilename = "index.yaml"
+
+// The partition given to documents that have no value for the field partitioned by
+const OtherPartition = "other"
+
+// The ways a catalog can be partitioned, each returning the name of a document's partition (or "" if it has none)
+var Partitioners = map[string]func(doc Document) string{
+	"collection": func(doc Document) string { return doc.Collection },
+	"format":     func(doc Document) string { return doc.Format },
+	"volume": func(doc Document) string {
+		if doc.VolumeID != "" {
+			return doc.VolumeID
+		}
+		return document.LocationOf(doc.Filepath, 0).Volume
+	},
+}
+
+// PartitionIndex is the content of the index file of a partition directory
+type PartitionIndex struct {
+	By         string           // The field partitioned by: one of the keys of Partitioners
+	Partitions []PartitionEntry // In order of name
+}
+
+// PartitionEntry describes one partition of a catalog
+type PartitionEntry struct {
+	Name      string // The value of the field partitioned by, or OtherPartition
+	File      string // The name of the partition's catalog within the directory
+	Documents int    // The number of documents in the partition
+}
+
+// Checks that by names a way of partitioning a catalog. A blank by (no partitioning) is valid.
+func ValidatePartitioning(by string) error {
+	if _, found := Partitioners[by]; (by != "") && !found {
+		return fmt.Errorf("cannot partition a catalog by %q: expected collection, volume or format", by)
+	}
+	return nil
+}
+
+// Splits a catalog into partitions, by the named field (see Partitioners)
+func (c Catalog) Partition(by string) (map[string]Catalog, error) {
+	partitioner, found := Partitioners[by]
+	if !found {
+		return nil, fmt.Errorf("cannot partition a catalog by %q: expected collection, volume or format", by)
+	}
+	partitions := make(map[string]Catalog)
+	for key, doc := range c {
+		name := partitioner(doc)
+		if name == "" {
+			name = OtherPartition
+		}
+		if partitions[name] == nil {
+			partitions[name] = make(Catalog)
+		}
+		partitions[name][key] = doc
+	}
+	return partitions, nil
+}
+
+// Writes a catalog as a partition directory, with one catalog per partition (see Partition) and an index file.
+// Partition files left over from an earlier write that are no longer needed are removed. If by is blank, the catalog
+// is written as a single file, as by Save.
+func SaveSplit(dir string, documents Catalog, by string) error {
+	if by == "" {
+		return Save(dir, documents)
+	}
+	partitions, err := documents.Partition(by)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	previous, _ := readIndex(dir)
+
+	index := PartitionIndex{By: by}
+	used := make(map[string]bool)
+	for _, name := range sortedNames(partitions) {
+		file := partitionFilename(name, used)
+		if err := Save(filepath.Join(dir, file), partitions[name]); err != nil {
+			return err
+		}
+		index.Partitions = append(index.Partitions, PartitionEntry{Name: name, File: file, Documents: len(partitions[name])})
+	}
+	data, err := document.MarshalYaml(index)
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(dir, IndexFilename), data, 0644); err != nil {
+		return err
+	}
+	for _, entry := range previous.Partitions {
+		if !used[strings.ToLower(entry.File)] {
+			if err := os.Remove(filepath.Join(dir, entry.File)); (err != nil) && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reads every partition of a partition directory into one catalog
+func loadPartitions(dir string) (Catalog, error) {
+	index, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	documents := make(Catalog)
+	for _, entry := range index.Partitions {
+		partition, err := Load(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, err
+		}
+		if duplicates := documents.Add(partition); len(duplicates) > 0 {
+			return nil, fmt.Errorf("%s: document %s is in more than one partition", dir, duplicates[0])
+		}
+	}
+	return documents, nil
+}
+
+// Reads the index of a partition directory
+func readIndex(dir string) (PartitionIndex, error) {
+	var index PartitionIndex
+	data, err := os.ReadFile(filepath.Join(dir, IndexFilename))
+	if err != nil {
+		return index, err
+	}
+	if err := document.UnmarshalYaml(data, &index); err != nil {
+		return index, fmt.Errorf("unmarshal error for %s: %w", filepath.Join(dir, IndexFilename), err)
+	}
+	return index, nil
+}
+
+// Reports whether filename is a partition directory, i.e. a directory holding an index file
+func IsPartitioned(filename string) bool {
+	info, err := os.Stat(filepath.Join(filename, IndexFilename))
+	return (err == nil) && info.Mode().IsRegular()
+}
+
+// Returns a filename for a partition that is safe on any filesystem and not already used, and marks it as used
+func partitionFilename(name string, used map[string]bool) string {
+	safe := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || (r == '-') || (r == '_') || (r == '.') {
+			return r
+		}
+		return '_'
+	}, name)
+	file := safe + ".yaml"
+	for i := 2; used[strings.ToLower(file)] || (strings.ToLower(file) == IndexFilename); i++ {
+		file = fmt.Sprintf("%s-%d.yaml", safe, i)
+	}
+	used[strings.ToLower(file)] = true
+	return file
+}
+
+func sortedNames(partitions map[string]Catalog) []string {
+	var names []string
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
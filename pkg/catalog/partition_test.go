@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	documents := Catalog{
+		"a": {Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf", Collection: "local"},
+		"b": {Format: "TXT", Filepath: "vax/notes.txt", VolumeID: "DEC_0002", Collection: "local"},
+		"c": {Format: "PDF", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf", Collection: "bitsavers"},
+	}
+	partitions, err := documents.Partition("volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"DEC_0001": {"a"}, "DEC_0002": {"b"}, OtherPartition: {"c"}}
+	got := make(map[string][]string)
+	for name, partition := range partitions {
+		got[name] = partition.Keys()
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Partition(volume) returned %v, expected %v", got, expected)
+	}
+
+	if _, err := documents.Partition("title"); err == nil {
+		t.Errorf("Partition(title) succeeded")
+	}
+	if err := ValidatePartitioning("title"); err == nil {
+		t.Errorf("ValidatePartitioning(title) succeeded")
+	}
+	if err := ValidatePartitioning(""); err != nil {
+		t.Errorf("ValidatePartitioning(\"\") failed: %v", err)
+	}
+}
+
+func TestSaveSplit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "local")
+	documents := Catalog{
+		"a": {Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf", Title: "KA630 Technical Manual"},
+		"b": {Format: "TXT", Filepath: "file:///DEC_0001/ka630.txt", Title: "KA630 Technical Manual"},
+		"c": {Format: "PDF", Filepath: "file:///DEC 0001/algol.pdf", Title: "VAX ALGOL"},
+	}
+	if err := SaveSplit(dir, documents, "volume"); err != nil {
+		t.Fatalf("SaveSplit() failed: %v", err)
+	}
+	if !IsPartitioned(dir) {
+		t.Fatalf("%s is not a partition directory", dir)
+	}
+	index, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PartitionIndex{By: "volume", Partitions: []PartitionEntry{{Name: "DEC 0001", File: "DEC_0001.yaml", Documents: 1}, {Name: "DEC_0001", File: "DEC_0001-2.yaml", Documents: 2}}}
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("index is %+v, expected %+v", index, expected)
+	}
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !slices.Equal(loaded.Keys(), documents.Keys()) {
+		t.Errorf("Load() returned %v, expected %v", loaded.Keys(), documents.Keys())
+	}
+
+	// Saving to the directory again keeps the partitioning and removes partitions that are no longer needed
+	delete(loaded, "c")
+	if err := Save(dir, loaded); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if !slices.Equal(files, []string{"DEC_0001.yaml", IndexFilename}) {
+		t.Errorf("directory holds %v after Save()", files)
+	}
+}
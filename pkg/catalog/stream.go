@@ -0,0 +1,318 @@
+package catalog
+
+import (
+	"bufio"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/workspace"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A catalog of a million documents takes gigabytes once loaded as a Catalog, so the programs that only need to look
+// at each document once (filtering, export, statistics) can instead stream it: a Reader returns the documents one at
+// a time, in the order of the file, and a Writer writes them one at a time, so that memory use does not grow with the
+// size of the catalog:
+//
+//	reader, err := catalog.OpenReader("bin/master.yaml")
+//	...
+//	defer reader.Close()
+//	for {
+//		key, doc, err := reader.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//
+// The catalogs written by Save have one top-level key per document, each starting a line, and a Reader relies on that
+// to find where each document ends; a catalog written another way (e.g. in YAML flow style) is read whole, as by Load.
+// A Writer writes each document exactly as Save would, so writing the documents of a catalog in the order a Reader
+// returns them gives the same file. Unlike Load, a Reader does not detect a key used twice.
+
+// Reader reads the documents of a catalog one at a time
+type Reader struct {
+	filenames []string      // The files still to be read, for a partition directory
+	filename  string        // The file being read
+	file      *os.File      // The open file
+	lines     *bufio.Reader // Reading file
+	line      int           // The number of lines read from file
+	next      string        // A line read that starts the next document
+	nextLine  int           // The line number of next
+	whole     []string      // The keys still to be returned from a file read whole
+	wholeDocs Catalog       // The documents of a file read whole
+}
+
+// Opens a catalog to be read one document at a time. A partition directory (see SaveSplit) is read one partition
+// after another, and a workspace name as its latest catalog.
+func OpenReader(filename string) (*Reader, error) {
+	filename, err := workspace.Resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{}
+	if IsPartitioned(filename) {
+		index, err := readIndex(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range index.Partitions {
+			r.filenames = append(r.filenames, filepath.Join(filename, entry.File))
+		}
+	} else {
+		r.filenames = []string{filename}
+	}
+	if err := r.openNext(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Opens the next file to be read, if there is one
+func (r *Reader) openNext() error {
+	r.Close()
+	if len(r.filenames) == 0 {
+		return nil
+	}
+	file, err := os.Open(r.filenames[0])
+	if err != nil {
+		return err
+	}
+	r.filename, r.filenames = r.filenames[0], r.filenames[1:]
+	r.file, r.lines, r.line, r.next = file, bufio.NewReader(file), 0, ""
+	return nil
+}
+
+// Reads a line, without its line ending. Returns io.EOF once there are no more lines.
+func (r *Reader) readLine() (string, error) {
+	line, err := r.lines.ReadString('\n')
+	if (err == io.EOF) && (line != "") {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.line += 1
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Reports whether a line starts a top-level key, i.e. a new document
+func startsDocument(line string) bool {
+	if (line == "") || (line == "---") || (line == "...") {
+		return false
+	}
+	switch line[0] {
+	case ' ', '\t', '#', ':':
+		return false
+	}
+	return true
+}
+
+// Returns the next document of the catalog and its key, or io.EOF once every document has been returned.
+// The text of the document is normalised and its locations completed, as by Load.
+func (r *Reader) Next() (string, Document, error) {
+	for {
+		if len(r.whole) > 0 {
+			key := r.whole[0]
+			r.whole = r.whole[1:]
+			return key, r.wholeDocs[key], nil
+		}
+		if r.file == nil {
+			return "", Document{}, io.EOF
+		}
+		key, doc, err := r.nextInFile()
+		if err != io.EOF {
+			return key, doc, err
+		}
+		if err := r.openNext(); err != nil {
+			return "", Document{}, err
+		}
+	}
+}
+
+// Returns the next document of the file being read, or io.EOF at its end
+func (r *Reader) nextInFile() (string, Document, error) {
+	// Find the line that starts the document
+	for r.next == "" {
+		line, err := r.readLine()
+		if err != nil {
+			return "", Document{}, err
+		}
+		if startsDocument(line) {
+			if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "- ") {
+				return r.readWhole(line)
+			}
+			r.next, r.nextLine = line, r.line
+		}
+	}
+
+	// Gather the lines up to the start of the following document
+	var entry strings.Builder
+	entry.WriteString(r.next + "\n")
+	first := r.nextLine
+	r.next = ""
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", Document{}, err
+		}
+		if startsDocument(line) {
+			r.next, r.nextLine = line, r.line
+			break
+		}
+		entry.WriteString(line + "\n")
+	}
+
+	documents := make(Catalog)
+	if err := document.UnmarshalYaml([]byte(entry.String()), &documents); err != nil {
+		return "", Document{}, fmt.Errorf("unmarshal error for %s at line %d: %w", r.filename, first, err)
+	}
+	if len(documents) != 1 {
+		return "", Document{}, fmt.Errorf("unmarshal error for %s at line %d: expected one document, found %d", r.filename, first, len(documents))
+	}
+	var key string
+	var doc Document
+	for key, doc = range documents {
+	}
+	normaliseLoaded(&doc)
+	return key, doc, nil
+}
+
+// Reads the rest of a file that is not written one document per top-level key, whole, starting with the line given
+func (r *Reader) readWhole(line string) (string, Document, error) {
+	rest, err := io.ReadAll(r.lines)
+	if err != nil {
+		return "", Document{}, err
+	}
+	documents := make(Catalog)
+	if err := document.UnmarshalYaml(append([]byte(line+"\n"), rest...), &documents); err != nil {
+		return "", Document{}, fmt.Errorf("unmarshal error for %s: %w", r.filename, err)
+	}
+	for key, doc := range documents {
+		normaliseLoaded(&doc)
+		documents[key] = doc
+	}
+	r.wholeDocs, r.whole = documents, documents.Keys()
+	r.file.Close()
+	r.file = nil
+	if err := r.openNext(); err != nil {
+		return "", Document{}, err
+	}
+	return r.Next()
+}
+
+// Closes the file being read
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Reads a catalog one document at a time (see Reader), calling fn for each document in the order of the file.
+// Stops at the first error returned by fn, and returns it.
+func Each(filename string, fn func(key string, doc Document) error) error {
+	reader, err := OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for {
+		key, doc, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(key, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// Writer writes a catalog one document at a time, as YAML or, for a filename ending .jsonl, as JSON Lines (see
+// SaveJsonl). The documents are written in the order given, not sorted. Nothing replaces the file until Close, so an
+// interrupted program never leaves a truncated catalog behind.
+type Writer struct {
+	artifact *workspace.Artifact
+	temp     *os.File
+	buffer   *bufio.Writer
+	jsonl    bool
+	count    int
+}
+
+// Creates a catalog to be written one document at a time. The filename may be a workspace name, which is written as
+// a new catalog that becomes its latest once the Writer is closed.
+func CreateWriter(filename string) (*Writer, error) {
+	artifact, err := workspace.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(artifact.Path), filepath.Base(artifact.Path)+".tmp*")
+	if err != nil {
+		artifact.Abandon()
+		return nil, err
+	}
+	jsonl := strings.EqualFold(filepath.Ext(filename), ".jsonl")
+	return &Writer{artifact: artifact, temp: temp, buffer: bufio.NewWriter(temp), jsonl: jsonl}, nil
+}
+
+// Writes a document, exactly as Save (or SaveJsonl) would
+func (w *Writer) Write(key string, doc Document) error {
+	var data []byte
+	var err error
+	if w.jsonl {
+		data, err = document.MarshalJsonLine(key, doc)
+	} else {
+		data, err = document.MarshalOrderedYaml(map[string]Document{key: doc})
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.buffer.Write(data); err != nil {
+		return err
+	}
+	w.count += 1
+	return nil
+}
+
+// Returns the number of documents written
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// Finishes the catalog, replacing any file of the same name
+func (w *Writer) Close() error {
+	err := w.buffer.Flush()
+	if err == nil {
+		err = w.temp.Sync()
+	}
+	if closeErr := w.temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(w.temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.temp.Name(), w.artifact.Path)
+	}
+	if err != nil {
+		os.Remove(w.temp.Name())
+		w.artifact.Abandon()
+		return err
+	}
+	return w.artifact.Commit()
+}
+
+// Abandons the catalog, leaving any file of the same name as it was
+func (w *Writer) Abandon() {
+	w.temp.Close()
+	os.Remove(w.temp.Name())
+	w.artifact.Abandon()
+}
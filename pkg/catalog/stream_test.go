@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Reads a whole catalog with a Reader, returning the keys in the order read
+func readAll(t *testing.T, filename string) (Catalog, []string) {
+	t.Helper()
+	documents := make(Catalog)
+	var keys []string
+	err := Each(filename, func(key string, doc Document) error {
+		documents[key] = doc
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each(%s) failed: %v", filename, err)
+	}
+	return documents, keys
+}
+
+func TestReader(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "catalog.yaml")
+	documents := testCatalog()
+	doc := documents["md5-a"]
+	doc.Notes = "page 37 missing\nsecond line"
+	doc.Tags = []string{"rare"}
+	documents["md5-a"] = doc
+	if err := Save(filename, documents); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, keys := readAll(t, filename)
+	if !reflect.DeepEqual(streamed, loaded) {
+		t.Errorf("Each() read %+v, expected %+v", streamed, loaded)
+	}
+	if !reflect.DeepEqual(keys, []string{"md5-a", "md5-b", "md5-c"}) {
+		t.Errorf("Each() read the keys in the order %v", keys)
+	}
+
+	// Writing the documents in the order read gives the file that Save writes
+	rewritten := filepath.Join(dir, "rewritten.yaml")
+	writer, err := CreateWriter(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := writer.Write(key, streamed[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(rewritten); err == nil {
+		t.Errorf("Writer wrote %s before Close()", rewritten)
+	}
+	if err := writer.Close(); (err != nil) || (writer.Count() != 3) {
+		t.Fatalf("Close() = %v after %d documents", err, writer.Count())
+	}
+	if err := Save(filename, loaded); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(filename)
+	written, _ := os.ReadFile(rewritten)
+	if string(written) != string(saved) {
+		t.Errorf("Writer wrote\n%s\nexpected\n%s", written, saved)
+	}
+
+	// A stray key, and a file that is not one document per key
+	if err := os.WriteFile(filename, []byte("md5-a:\n  title: [unterminated\nmd5-b:\n  title: B\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Each(filename, func(string, Document) error { return nil }); (err == nil) || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Each() of a bad document = %v", err)
+	}
+	if err := os.WriteFile(filename, []byte("# A catalog\n{md5-a: {title: A}, md5-b: {title: B}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if flow, keys := readAll(t, filename); (flow["md5-b"].Title != "B") || (len(keys) != 2) {
+		t.Errorf("Each() of a flow-style catalog read %v", flow)
+	}
+
+	// fn stops the walk
+	stop := errors.New("stop")
+	if err := Each(rewritten, func(string, Document) error { return stop }); err != stop {
+		t.Errorf("Each() = %v, expected fn's error", err)
+	}
+	if _, err := OpenReader(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("OpenReader() of a missing file succeeded")
+	}
+}
+
+func TestReaderPartitions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "split")
+	if err := SaveSplit(dir, testCatalog(), "volume"); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed, _ := readAll(t, dir); !reflect.DeepEqual(streamed, loaded) {
+		t.Errorf("Each() of a partition directory read %+v, expected %+v", streamed, loaded)
+	}
+
+	reader, err := OpenReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for i := 0; i < len(loaded); i++ {
+		reader.Next()
+	}
+	if _, _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() after the last document = %v", err)
+	}
+}
+
+func TestWriterJsonl(t *testing.T) {
+	dir := t.TempDir()
+	documents := testCatalog()
+	if err := SaveJsonl(filepath.Join(dir, "saved.jsonl"), documents); err != nil {
+		t.Fatal(err)
+	}
+	writer, err := CreateWriter(filepath.Join(dir, "written.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"md5-a", "md5-b", "md5-c"} {
+		writer.Write(key, documents[key])
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, "saved.jsonl"))
+	written, _ := os.ReadFile(filepath.Join(dir, "written.jsonl"))
+	if string(written) != string(saved) {
+		t.Errorf("Writer wrote\n%s\nexpected\n%s", written, saved)
+	}
+
+	abandoned, err := CreateWriter(filepath.Join(dir, "abandoned.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abandoned.Write("md5-a", documents["md5-a"])
+	abandoned.Abandon()
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Abandon() left %d files", len(entries))
+	}
+}
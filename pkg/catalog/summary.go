@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/workspace"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A catalog of a large volume (10,000 files or more) is slow to browse, so a summary of it can be written alongside:
+// one entry per directory, giving how many documents it holds, their total size, their formats and a few
+// representative titles, along with the same totals for everything below it and the names of its subdirectories, so
+// that a navigation tree can be drawn from the summary alone:
+//
+//	- path: DEC_0001/vax
+//	  documents: 12
+//	  size: 104857600
+//	  formats: {PDF: 11, TXT: 1}
+//	  titles: [VAX Architecture Handbook, KA630 CPU Module Technical Manual, VAX 11/780 Hardware Handbook]
+//	  alldocuments: 40
+//	  allsize: 354418688
+//	  subdirectories: [manuals, prints]
+
+// The number of representative titles given for a directory
+const SummaryTitles = 3
+
+// DirectorySummary summarises the documents in one directory
+type DirectorySummary struct {
+	Path           string         // The directory, e.g. "DEC_0001/vax" (without any URL scheme), or "." for the top
+	Documents      int            // The number of documents directly in the directory
+	Size           int64          // Their total size in bytes
+	Formats        map[string]int `yaml:",omitempty"`   // The number of those documents in each format
+	Titles         []string       `yaml:",omitempty"`   // The titles of the largest documents in the directory, without repeats
+	AllDocuments   int            `yaml:"alldocuments"` // The number of documents in the directory and every directory below it
+	AllSize        int64          `yaml:"allsize"`      // Their total size in bytes
+	Subdirectories []string       `yaml:",omitempty"`   // The names of the directories immediately below, sorted
+}
+
+// Returns the directory of a document's filepath, without any URL scheme (e.g. "file:///" or "http://")
+func directoryOf(filepath string) string {
+	if _, rest, found := strings.Cut(filepath, "://"); found {
+		filepath = rest
+	}
+	return path.Dir(strings.TrimLeft(filepath, "/"))
+}
+
+// Returns a summary of every directory holding documents (and every directory above those), in order of path
+func (c Catalog) Summarise() []DirectorySummary {
+	summaries := make(map[string]*DirectorySummary)
+	var summary func(dir string) *DirectorySummary
+	summary = func(dir string) *DirectorySummary {
+		if s, found := summaries[dir]; found {
+			return s
+		}
+		s := &DirectorySummary{Path: dir, Formats: make(map[string]int)}
+		summaries[dir] = s
+		if dir != "." {
+			parent := summary(path.Dir(dir))
+			parent.Subdirectories = append(parent.Subdirectories, path.Base(dir))
+		}
+		return s
+	}
+
+	byDirectory := make(map[string][]Document)
+	for _, key := range c.Keys() {
+		doc := c[key]
+		dir := directoryOf(doc.Filepath)
+		byDirectory[dir] = append(byDirectory[dir], doc)
+		s := summary(dir)
+		s.Documents += 1
+		s.Size += doc.Size
+		if doc.Format != "" {
+			s.Formats[doc.Format] += 1
+		}
+		for ancestor := dir; ; ancestor = path.Dir(ancestor) {
+			summaries[ancestor].AllDocuments += 1
+			summaries[ancestor].AllSize += doc.Size
+			if ancestor == "." {
+				break
+			}
+		}
+	}
+	for dir, documents := range byDirectory {
+		summaries[dir].Titles = representativeTitles(documents)
+	}
+
+	result := make([]DirectorySummary, 0, len(summaries))
+	for _, s := range summaries {
+		sort.Strings(s.Subdirectories)
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Returns the titles of the largest documents, without repeats, up to SummaryTitles of them. The largest documents
+// are usually the main manuals of a directory, rather than its release notes and errata.
+func representativeTitles(documents []Document) []string {
+	sort.SliceStable(documents, func(i, j int) bool { return documents[i].Size > documents[j].Size })
+	var titles []string
+	for _, doc := range documents {
+		if (doc.Title != "") && !containsFold(titles, doc.Title) {
+			titles = append(titles, doc.Title)
+			if len(titles) == SummaryTitles {
+				break
+			}
+		}
+	}
+	return titles
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the directory summary of a catalog (see Summarise) to a YAML file
+func SaveSummary(filename string, documents Catalog) error {
+	data, err := document.MarshalYaml(documents.Summarise())
+	if err != nil {
+		return err
+	}
+	_, err = workspace.WriteFile(filename, data, 0644)
+	return err
+}
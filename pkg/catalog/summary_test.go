@@ -0,0 +1,37 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarise(t *testing.T) {
+	documents := Catalog{
+		"a": {Title: "VAX Architecture Handbook", Format: "PDF", Size: 300, Filepath: "file:///DEC_0001/vax/vaxarch.pdf"},
+		"b": {Title: "VAX Architecture Handbook", Format: "TXT", Size: 100, Filepath: "file:///DEC_0001/vax/vaxarch.txt"},
+		"c": {Title: "KA630 Technical Manual", Format: "PDF", Size: 200, Filepath: "file:///DEC_0001/vax/ka630.pdf"},
+		"d": {Title: "KA630 Print Set", Format: "PDF", Size: 50, Filepath: "file:///DEC_0001/vax/prints/ka630mp.pdf"},
+		"e": {Title: "Read Me", Format: "TXT", Size: 1, Filepath: "README.TXT"},
+	}
+	expected := []DirectorySummary{
+		{Path: ".", Documents: 1, Size: 1, Formats: map[string]int{"TXT": 1}, Titles: []string{"Read Me"}, AllDocuments: 5, AllSize: 651, Subdirectories: []string{"DEC_0001"}},
+		{Path: "DEC_0001", Formats: map[string]int{}, AllDocuments: 4, AllSize: 650, Subdirectories: []string{"vax"}},
+		{Path: "DEC_0001/vax", Documents: 3, Size: 600, Formats: map[string]int{"PDF": 2, "TXT": 1}, Titles: []string{"VAX Architecture Handbook", "KA630 Technical Manual"}, AllDocuments: 4, AllSize: 650, Subdirectories: []string{"prints"}},
+		{Path: "DEC_0001/vax/prints", Documents: 1, Size: 50, Formats: map[string]int{"PDF": 1}, Titles: []string{"KA630 Print Set"}, AllDocuments: 1, AllSize: 50},
+	}
+	if summaries := documents.Summarise(); !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("Summarise() =\n%+v\nexpected\n%+v", summaries, expected)
+	}
+
+	filename := filepath.Join(t.TempDir(), "summary.yaml")
+	if err := SaveSummary(filename, documents); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if (err != nil) || !strings.Contains(string(data), "- path: DEC_0001/vax\n") || !strings.Contains(string(data), "  alldocuments: 4\n") {
+		t.Errorf("SaveSummary() wrote %q, %v", data, err)
+	}
+}
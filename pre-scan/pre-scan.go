@@ -0,0 +1,113 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/prescan"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// This program answers "is this worth scanning?" before an hour is spent scanning a paper document. Given part numbers
+// and/or title fragments, it lists every known copy of the document in every configured catalog, with what is recorded
+// about the quality of each (see internal/prescan): whether it holds page images or only a transcription, whether it
+// has a text layer, its PDF version and producer, any needs-rescan tag, notes and paper copy. It finishes with advice
+// on whether a scan looks worthwhile.
+//
+// The catalogs are named as for build-master: on the command line as NAME=FILEPATH or FILEPATH, or in a --config file
+// (see internal/sourceconfig). --json writes the report as JSON, as serve-catalog's /prescan endpoint does.
+//
+// To run the program:
+//   go run pre-scan/pre-scan.go --config bin/sources.yaml --part-num EK-KA630-TM-001
+//   go run pre-scan/pre-scan.go --title "ka630 technical" local=bin/local.yaml bin/yaml/bitsavers.yaml bin/yaml/manx.yaml
+//
+
+func main() {
+	var query catalog.Query
+	flag.Func("part-num", "find copies with this part number (repeatable)", func(s string) error {
+		query.PartNums = append(query.PartNums, strings.TrimSpace(s))
+		return nil
+	})
+	flag.Func("title", "find copies whose title contains this, ignoring case (repeatable)", func(s string) error {
+		query.Titles = append(query.Titles, strings.TrimSpace(s))
+		return nil
+	})
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the catalogs to search (name and path), as for build-master")
+	jsonOutput := flag.Bool("json", false, "write the report as JSON")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if query.Empty() {
+		exitcode.UsageError("Please supply at least one --part-num or --title")
+	}
+	sources, err := sourceconfig.Gather(*configFilename, flag.Args())
+	if err != nil {
+		exitcode.UsageErrorf("--config: %s", err)
+	}
+	if len(sources) == 0 {
+		exitcode.UsageError("Please supply at least one catalog, with --config or on the command line")
+	}
+
+	var catalogs []prescan.Catalog
+	for _, source := range sources {
+		documents, err := catalog.Load(source.Path)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", source.Path, err)
+		}
+		catalogs = append(catalogs, prescan.Catalog{Name: source.Name, Documents: documents})
+	}
+
+	report := prescan.Find(catalogs, query)
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			exitcode.Fatal(err)
+		}
+	} else {
+		WriteReport(os.Stdout, strings.Join(append(query.PartNums, query.Titles...), ", "), report)
+	}
+
+	exitcode.Exit()
+}
+
+// Writes a report for people: each copy, with where it is held and what is known of its quality, then the advice
+func WriteReport(w io.Writer, heading string, report prescan.Report) {
+	fmt.Fprintf(w, "%s: %d copies, %d distinct files\n", heading, len(report.Copies), report.Files)
+	for _, c := range report.Copies {
+		var details []string
+		for _, detail := range []string{c.Format, c.PubDate} {
+			if detail != "" {
+				details = append(details, detail)
+			}
+		}
+		if c.Size > 0 {
+			details = append(details, fmt.Sprintf("%d bytes", c.Size))
+		}
+		line := c.Catalog + ": " + strings.TrimSpace(c.PartNum+" "+c.Title)
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		fmt.Fprintf(w, "    %s\n", line)
+		if c.Filepath != "" {
+			fmt.Fprintf(w, "        %s\n", c.Filepath)
+		}
+		if len(c.Quality) > 0 {
+			fmt.Fprintf(w, "        %s\n", strings.Join(c.Quality, "; "))
+		}
+	}
+	fmt.Fprintf(w, "Advice: %s\n", report.Advice)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"docs-to-yaml/internal/prescan"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	report := prescan.Report{
+		Copies: []prescan.Copy{
+			{Catalog: "local", Key: "abc", PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Format: "PDF", PubDate: "1987-01", Size: 1234, Filepath: "file:///DEC_0001/vax/ka630.pdf", Quality: []string{"PDF-1.2", "no text layer"}},
+			{Catalog: "manx", Key: "EK-KA630-TM-001", PartNum: "EK-KA630-TM-001"},
+		},
+		Files:  2,
+		Advice: "a scan is already held: a rescan is only worthwhile if its quality is poor",
+	}
+	var out strings.Builder
+	WriteReport(&out, "EK-KA630-TM-001", report)
+	expected := `EK-KA630-TM-001: 2 copies, 2 distinct files
+    local: EK-KA630-TM-001 KA630 CPU Module Technical Manual (PDF, 1987-01, 1234 bytes)
+        file:///DEC_0001/vax/ka630.pdf
+        PDF-1.2; no text layer
+    manx: EK-KA630-TM-001
+Advice: a scan is already held: a rescan is only worthwhile if its quality is poor
+`
+	if out.String() != expected {
+		t.Errorf("WriteReport() wrote\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// This program publishes a set of catalogs as a numbered release, so that those who mirror them can tell when and what
+// changed, and check that what they fetched is what was published.
+//
+// The catalogs are named as for build-master: on the command line as NAME=FILEPATH or FILEPATH, or in a --config file
+// (see internal/sourceconfig). Each release is a directory in the --release-dir (by default bin/releases) named after
+// its version, e.g. bin/releases/v1.4.0, holding
+//
+//   NAME.yaml          each catalog, holding only the documents the --audience (by default the public) may see
+//   CHANGES.txt        the documents added, removed and changed in each catalog since the previous release
+//   manifest.yaml      the version, the time of publication, and the size, SHA-256 and MD5 checksums, document count
+//                      and numbers of changes of each catalog
+//   manifest.yaml.sig  the signature of the manifest, if --sign is given
+//
+// The previous releases are kept as they were. releases.yaml lists every release, and "latest" is a link to the newest.
+//
+// The version is that of the previous release with --bump major, minor (the default) or patch increased, or that given
+// by --version, which must be newer. The first release is 1.0.0. Nothing is published if no catalog has changed since
+// the previous release, unless --force is given. --dry-run shows what would be published.
+//
+// --sign runs a command to sign the manifest; it is split into arguments as for an --exec hook (no shell is involved),
+// with {manifest} replaced by the manifest's filepath and {signature} by that of the signature to be written, e.g.
+//
+//   --sign 'gpg --batch --yes --detach-sign --armor --output {signature} {manifest}'
+//
+// The release is only published if the command succeeds and writes the signature.
+//
+// To run the program:
+//   go run publish-catalogs/publish-catalogs.go --release-dir bin/releases master=bin/yaml/master.yaml local=bin/local.yaml
+//   go run publish-catalogs/publish-catalogs.go --bump major --sign 'gpg --detach-sign --armor --output {signature} {manifest}' --config data/publish.yaml
+//
+
+// The names of the files in each release directory, and in the release directory itself
+const (
+	DefaultReleaseDir = "bin/releases"
+	ManifestFilename  = "manifest.yaml"
+	SignatureFilename = ManifestFilename + ".sig"
+	ChangesFilename   = "CHANGES.txt"
+	ReleasesFilename  = "releases.yaml"
+	LatestLink        = "latest"
+)
+
+type Document = document.Document
+
+// Source is a catalog to be published
+type Source = sourceconfig.Source
+
+// Version is a release version, MAJOR.MINOR.PATCH
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// The parts of a version that --bump can increase
+var BumpParts = []string{"major", "minor", "patch"}
+
+// Parses a version given as MAJOR.MINOR.PATCH, with or without a leading "v"
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("expected MAJOR.MINOR.PATCH, found %q", s)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if (err != nil) || (number < 0) {
+			return Version{}, fmt.Errorf("expected MAJOR.MINOR.PATCH, found %q", s)
+		}
+		numbers[i] = number
+	}
+	return Version{numbers[0], numbers[1], numbers[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Returns the name of the directory holding the release
+func (v Version) Dir() string {
+	return "v" + v.String()
+}
+
+// Compares two versions, returning -1, 0 or +1
+func (v Version) Compare(other Version) int {
+	for _, difference := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if difference != 0 {
+			return max(-1, min(1, difference))
+		}
+	}
+	return 0
+}
+
+// Returns the version with one part (one of BumpParts) increased, and the parts after it reset
+func (v Version) Bump(part string) Version {
+	switch part {
+	case "major":
+		return Version{v.Major + 1, 0, 0}
+	case "minor":
+		return Version{v.Major, v.Minor + 1, 0}
+	}
+	return Version{v.Major, v.Minor, v.Patch + 1}
+}
+
+// Changes counts the documents added, removed and changed in a catalog since the previous release
+type Changes struct {
+	Added    int `yaml:"added"`
+	Removed  int `yaml:"removed"`
+	Modified int `yaml:"modified"`
+}
+
+// Returns the number of documents added, removed or changed
+func (c Changes) Total() int {
+	return c.Added + c.Removed + c.Modified
+}
+
+// ManifestFile describes one catalog in a release
+type ManifestFile struct {
+	Name      string  `yaml:"name"`
+	File      string  `yaml:"file"` // Relative to the release directory
+	Size      int64   `yaml:"size"`
+	Sha256    string  `yaml:"sha256"`
+	Md5       string  `yaml:"md5"`
+	Documents int     `yaml:"documents"`
+	Changes   Changes `yaml:"changes"`
+}
+
+// Manifest describes a release
+type Manifest struct {
+	Version   string         `yaml:"version"`
+	Previous  string         `yaml:"previous,omitempty"` // The version of the previous release, if any
+	Published string         `yaml:"published"`          // RFC 3339, UTC
+	Audience  string         `yaml:"audience"`           // Only the documents this audience may see are published
+	Files     []ManifestFile `yaml:"files"`
+	Changes   Changes        `yaml:"changes"`           // The changes in every catalog
+	Removed   []string       `yaml:"removed,omitempty"` // The catalogs in the previous release that are no longer published
+}
+
+// Release is an entry in releases.yaml
+type Release struct {
+	Version   string  `yaml:"version"`
+	Published string  `yaml:"published"`
+	Sha256    string  `yaml:"sha256"` // The SHA-256 checksum of the release's manifest
+	Documents int     `yaml:"documents"`
+	Changes   Changes `yaml:"changes"`
+	Signed    bool    `yaml:"signed,omitempty"`
+}
+
+// Options controls a release
+type Options struct {
+	Bump     string         // One of BumpParts
+	Version  *Version       // The version to publish, instead of bumping that of the previous release
+	Audience string         // One of visibility.Levels
+	Sign     *exechook.Hook // Signs the manifest, if set
+	Force    bool           // Publish even if nothing has changed
+	DryRun   bool           // Report what would be published without publishing it
+}
+
+// Runs a command, such as the --sign command.
+// Tests replace this to avoid running gpg.
+var RunCommand = func(args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	var messages bytes.Buffer
+	cmd.Stdout, cmd.Stderr = os.Stdout, &messages
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(messages.String()))
+	}
+	return nil
+}
+
+func main() {
+	var options Options
+	releaseDir := flag.String("release-dir", DefaultReleaseDir, "the directory holding the releases")
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the catalogs to publish (name and path), as for build-master")
+	flag.StringVar(&options.Bump, "bump", "minor", "the part of the previous version to increase: major, minor or patch")
+	version := flag.String("version", "", "the version to publish, as MAJOR.MINOR.PATCH, instead of bumping the previous version")
+	flag.StringVar(&options.Audience, "audience", visibility.Public, "publish only the documents this audience may see: public, restricted or private")
+	sign := flag.String("sign", "", "a command to sign the manifest, with {manifest} and {signature} for the filepaths, e.g. 'gpg --detach-sign --armor --output {signature} {manifest}'")
+	flag.BoolVar(&options.Force, "force", false, "publish a release even if no catalog has changed")
+	flag.BoolVar(&options.DryRun, "dry-run", false, "show what would be published without publishing it")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if !slices.Contains(BumpParts, options.Bump) {
+		exitcode.UsageErrorf("--bump must be one of %s, found %q", strings.Join(BumpParts, ", "), options.Bump)
+	}
+	if *version != "" {
+		v, err := ParseVersion(*version)
+		if err != nil {
+			exitcode.UsageErrorf("--version: %s", err)
+		}
+		options.Version = &v
+	}
+	if !visibility.IsLevel(options.Audience) {
+		exitcode.UsageErrorf("--audience must be one of %s, found %q", strings.Join(visibility.Levels, ", "), options.Audience)
+	}
+	if *sign != "" {
+		hook, err := exechook.Parse(*sign)
+		if err != nil {
+			exitcode.UsageErrorf("--sign: %s", err)
+		}
+		options.Sign = hook
+	}
+	sources, err := sourceconfig.Gather(*configFilename, flag.Args())
+	if err != nil {
+		exitcode.UsageErrorf("--config: %s", err)
+	}
+	if len(sources) == 0 {
+		exitcode.UsageError("Please supply at least one catalog, with --config or on the command line")
+	}
+
+	manifest, changes, err := Publish(*releaseDir, sources, options, time.Now())
+	if errors.Is(err, ErrUnchanged) {
+		fmt.Printf("Nothing has changed since %s; nothing published (use --force to publish anyway)\n", manifest.Previous)
+		exitcode.Exit()
+	} else if err != nil {
+		exitcode.Fatal(err)
+	}
+	WriteSummary(os.Stdout, manifest)
+	if console.CurrentLevel() >= console.Verbose {
+		os.Stdout.Write(changes)
+	}
+	if options.DryRun {
+		fmt.Printf("Would publish %s in %s\n", manifest.Version, *releaseDir)
+	} else {
+		fmt.Printf("Published %s in %s\n", manifest.Version, filepath.Join(*releaseDir, "v"+manifest.Version))
+	}
+	exitcode.Exit()
+}
+
+// ErrUnchanged is returned by Publish when no catalog has changed since the previous release
+var ErrUnchanged = errors.New("nothing has changed")
+
+// Returns the releases listed in releases.yaml, oldest first
+func LoadReleases(releaseDir string) ([]Release, error) {
+	var releases []Release
+	_, err := checkpoint.LoadState(filepath.Join(releaseDir, ReleasesFilename), &releases)
+	return releases, err
+}
+
+// Publishes the catalogs as a new release in releaseDir, returning its manifest and the report of what changed.
+// With options.DryRun nothing is written. ErrUnchanged is returned (with the manifest, whose Previous is the previous
+// version) if nothing has changed and options.Force is not set.
+func Publish(releaseDir string, sources []Source, options Options, now time.Time) (Manifest, []byte, error) {
+	releases, err := LoadReleases(releaseDir)
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("cannot read %s: %w", filepath.Join(releaseDir, ReleasesFilename), err)
+	}
+	var previous *Manifest
+	var version Version
+	if len(releases) > 0 {
+		last := releases[len(releases)-1]
+		previousVersion, err := ParseVersion(last.Version)
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("%s: %w", ReleasesFilename, err)
+		}
+		previous = &Manifest{}
+		if _, err := checkpoint.LoadState(filepath.Join(releaseDir, previousVersion.Dir(), ManifestFilename), previous); err != nil {
+			return Manifest{}, nil, err
+		}
+		version = previousVersion.Bump(options.Bump)
+		if (options.Version != nil) && (options.Version.Compare(previousVersion) <= 0) {
+			return Manifest{}, nil, fmt.Errorf("version %s is not newer than the previous release, %s", options.Version, previousVersion)
+		}
+	} else {
+		version = Version{1, 0, 0}
+	}
+	if options.Version != nil {
+		version = *options.Version
+	}
+
+	manifest := Manifest{Version: version.String(), Published: now.UTC().Format(time.RFC3339), Audience: options.Audience}
+	if previous != nil {
+		manifest.Previous = previous.Version
+	}
+	partial := filepath.Join(releaseDir, "."+version.Dir()+".partial")
+	if !options.DryRun {
+		if _, err := os.Stat(filepath.Join(releaseDir, version.Dir())); err == nil {
+			return manifest, nil, fmt.Errorf("release %s already exists", version)
+		}
+		os.RemoveAll(partial)
+		if err := os.MkdirAll(partial, 0755); err != nil {
+			return manifest, nil, err
+		}
+		defer os.RemoveAll(partial)
+	}
+
+	var report bytes.Buffer
+	for _, source := range sources {
+		file, err := publishCatalog(source, previous, releaseDir, partial, options, &report)
+		if err != nil {
+			return manifest, nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+		manifest.Changes.Added += file.Changes.Added
+		manifest.Changes.Removed += file.Changes.Removed
+		manifest.Changes.Modified += file.Changes.Modified
+	}
+	if previous != nil {
+		for _, file := range previous.Files {
+			if !slices.ContainsFunc(manifest.Files, func(f ManifestFile) bool { return f.Name == file.Name }) {
+				manifest.Removed = append(manifest.Removed, file.Name)
+				fmt.Fprintf(&report, "## %s\nno longer published\n\n", file.Name)
+			}
+		}
+		if (manifest.Changes.Total() == 0) && (len(manifest.Removed) == 0) && !options.Force && unchangedFiles(previous, manifest) {
+			return Manifest{Previous: previous.Version}, nil, ErrUnchanged
+		}
+	}
+	if options.DryRun {
+		return manifest, report.Bytes(), nil
+	}
+
+	if err := os.WriteFile(filepath.Join(partial, ChangesFilename), report.Bytes(), 0644); err != nil {
+		return manifest, nil, err
+	}
+	manifestFilename := filepath.Join(partial, ManifestFilename)
+	if err := checkpoint.SaveState(manifestFilename, manifest); err != nil {
+		return manifest, nil, err
+	}
+	signed := false
+	if options.Sign != nil {
+		signature := filepath.Join(partial, SignatureFilename)
+		if err := RunCommand(options.Sign.Expand(map[string]string{"{manifest}": manifestFilename, "{signature}": signature})); err != nil {
+			return manifest, nil, fmt.Errorf("cannot sign the manifest: %w", err)
+		}
+		if info, err := os.Stat(signature); (err != nil) || (info.Size() == 0) {
+			return manifest, nil, fmt.Errorf("the --sign command did not write %s", SignatureFilename)
+		}
+		signed = true
+	}
+	digests, err := hashing.HashFile(manifestFilename, hashing.SHA256)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	if err := os.Rename(partial, filepath.Join(releaseDir, version.Dir())); err != nil {
+		return manifest, nil, err
+	}
+	documents := 0
+	for _, file := range manifest.Files {
+		documents += file.Documents
+	}
+	releases = append(releases, Release{Version: manifest.Version, Published: manifest.Published, Sha256: digests.Sha256, Documents: documents, Changes: manifest.Changes, Signed: signed})
+	if err := checkpoint.SaveState(filepath.Join(releaseDir, ReleasesFilename), releases); err != nil {
+		return manifest, nil, err
+	}
+	return manifest, report.Bytes(), linkLatest(releaseDir, version.Dir())
+}
+
+// Writes the documents of one catalog that the audience may see to the release being built (unless options.DryRun is
+// set), and reports how they differ from those in the previous release, if any
+func publishCatalog(source Source, previous *Manifest, releaseDir string, partial string, options Options, report io.Writer) (ManifestFile, error) {
+	file := ManifestFile{Name: source.Name, File: source.Name + ".yaml"}
+	if (source.Name == "") || (filepath.Base(source.Name) != source.Name) || strings.HasPrefix(source.Name, ".") {
+		return file, fmt.Errorf("cannot publish a catalog named %q", source.Name)
+	}
+	documents, err := catalog.Load(source.Path)
+	if err != nil {
+		return file, fmt.Errorf("cannot read %s: %w", source.Path, err)
+	}
+	documents = documents.Visible(options.Audience)
+	file.Documents = len(documents)
+
+	old := make(catalog.Catalog)
+	if previous != nil {
+		index := slices.IndexFunc(previous.Files, func(f ManifestFile) bool { return f.Name == source.Name })
+		if index >= 0 {
+			previousFilename := filepath.Join(releaseDir, "v"+previous.Version, previous.Files[index].File)
+			if old, err = catalog.Load(previousFilename); err != nil {
+				return file, fmt.Errorf("cannot read the previous release of %s: %w", source.Name, err)
+			}
+		}
+	}
+	changes := catalog.Diff(old, documents)
+	for _, change := range changes {
+		switch change.Kind {
+		case catalog.Added:
+			file.Changes.Added += 1
+		case catalog.Removed:
+			file.Changes.Removed += 1
+		case catalog.Modified:
+			file.Changes.Modified += 1
+		}
+	}
+	fmt.Fprintf(report, "## %s\n", source.Name)
+	catalog.WriteDiff(report, changes, old, documents)
+	fmt.Fprintln(report)
+
+	if options.DryRun {
+		return file, nil
+	}
+	filename := filepath.Join(partial, file.File)
+	if err := catalog.Save(filename, documents); err != nil {
+		return file, err
+	}
+	digests, err := hashing.HashFile(filename, hashing.SHA256|hashing.MD5)
+	if err != nil {
+		return file, err
+	}
+	file.Size, file.Sha256, file.Md5 = digests.Size, digests.Sha256, digests.Md5
+	return file, nil
+}
+
+// Reports whether every catalog in a manifest was in the previous one (whose documents have not changed), so that a
+// catalog published for the first time, even if empty, is a change
+func unchangedFiles(previous *Manifest, manifest Manifest) bool {
+	for _, file := range manifest.Files {
+		if !slices.ContainsFunc(previous.Files, func(f ManifestFile) bool { return f.Name == file.Name }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Points the "latest" link in the release directory at a release, replacing the link atomically
+func linkLatest(releaseDir string, target string) error {
+	temporary := filepath.Join(releaseDir, "."+LatestLink+".new")
+	os.Remove(temporary)
+	if err := os.Symlink(target, temporary); err != nil {
+		return err
+	}
+	return os.Rename(temporary, filepath.Join(releaseDir, LatestLink))
+}
+
+// Writes a summary of a release: each catalog with its document count, changes and checksum
+func WriteSummary(out io.Writer, manifest Manifest) {
+	previous := "first release"
+	if manifest.Previous != "" {
+		previous = "previous " + manifest.Previous
+	}
+	fmt.Fprintf(out, "Release %s (%s), %s\n", manifest.Version, previous, manifest.Published)
+	for _, file := range manifest.Files {
+		fmt.Fprintf(out, "  %-20s %7d documents  +%d -%d ~%d  %.16s\n", file.Name, file.Documents, file.Changes.Added, file.Changes.Removed, file.Changes.Modified, file.Sha256)
+	}
+	for _, name := range manifest.Removed {
+		fmt.Fprintf(out, "  %-20s no longer published\n", name)
+	}
+	fmt.Fprintf(out, "%d added, %d removed, %d modified\n", manifest.Changes.Added, manifest.Changes.Removed, manifest.Changes.Modified)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersion(t *testing.T) {
+	for _, test := range []struct {
+		version  string
+		bump     string
+		expected string
+	}{
+		{"1.2.3", "major", "2.0.0"},
+		{"v1.2.3", "minor", "1.3.0"},
+		{"1.2.3", "patch", "1.2.4"},
+	} {
+		version, err := ParseVersion(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bumped := version.Bump(test.bump); (bumped.String() != test.expected) || (bumped.Compare(version) != 1) {
+			t.Errorf("%s bumped %s = %s", test.version, test.bump, bumped)
+		}
+	}
+	for _, bad := range []string{"1.2", "1.2.x", "1.-2.3", ""} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("ParseVersion(%q) succeeded", bad)
+		}
+	}
+	if (Version{1, 10, 0}).Compare(Version{1, 9, 9}) != 1 {
+		t.Errorf("1.10.0 is not newer than 1.9.9")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	releaseDir := filepath.Join(dir, "releases")
+	localFilename := filepath.Join(dir, "local.yaml")
+	local := catalog.Catalog{
+		"EK-KA630-TM-001": {Format: "PDF", Title: "KA630 Technical Manual", Md5: "0123456789abcdef0123456789abcdef"},
+		"EK-SECRET-001":   {Format: "PDF", Title: "Secret Manual", Visibility: "private"},
+	}
+	if err := catalog.Save(localFilename, local); err != nil {
+		t.Fatal(err)
+	}
+	sources := []Source{sourceconfig.Parse(localFilename)}
+	options := Options{Bump: "minor", Audience: "public"}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	manifest, _, err := Publish(releaseDir, sources, options, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (manifest.Version != "1.0.0") || (manifest.Previous != "") || (manifest.Files[0].Documents != 1) || (manifest.Changes.Added != 1) {
+		t.Errorf("first release = %+v", manifest)
+	}
+	published := filepath.Join(releaseDir, "v1.0.0", "local.yaml")
+	digests, _ := hashing.HashFile(published, hashing.SHA256)
+	if digests.Sha256 != manifest.Files[0].Sha256 {
+		t.Errorf("manifest gives SHA-256 %s, the file has %s", manifest.Files[0].Sha256, digests.Sha256)
+	}
+	if data, _ := os.ReadFile(published); bytes.Contains(data, []byte("Secret")) {
+		t.Errorf("a private document was published")
+	}
+
+	// Nothing has changed
+	if _, _, err := Publish(releaseDir, sources, options, now.Add(time.Hour)); !errors.Is(err, ErrUnchanged) {
+		t.Errorf("Publish() of unchanged catalogs gave %v", err)
+	}
+
+	// A changed document and a new one, signed
+	local["EK-KA630-TM-001"] = Document{Format: "PDF", Title: "KA630 CPU Module Technical Manual", Md5: "0123456789abcdef0123456789abcdef"}
+	local["EK-KA630-UG-001"] = Document{Format: "PDF", Title: "KA630 User's Guide"}
+	if err := catalog.Save(localFilename, local); err != nil {
+		t.Fatal(err)
+	}
+	defer func(run func([]string) error) { RunCommand = run }(RunCommand)
+	RunCommand = func(args []string) error {
+		if args[0] == "broken" {
+			return errors.New("broken: exit status 2")
+		}
+		return os.WriteFile(args[len(args)-1], []byte("signature of "+args[1]), 0644)
+	}
+	options.Sign, _ = exechook.Parse("broken {manifest} {signature}")
+	if _, _, err := Publish(releaseDir, sources, options, now.Add(time.Hour)); err == nil {
+		t.Errorf("Publish() succeeded although signing failed")
+	}
+	if _, err := os.Stat(filepath.Join(releaseDir, "v1.1.0")); !os.IsNotExist(err) {
+		t.Errorf("a release whose signing failed was published")
+	}
+
+	options.Sign, _ = exechook.Parse("gpg {manifest} --output {signature}")
+	manifest, changes, err := Publish(releaseDir, sources, options, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (manifest.Version != "1.1.0") || (manifest.Previous != "1.0.0") || (manifest.Changes != Changes{Added: 1, Modified: 1}) {
+		t.Errorf("second release = %+v", manifest)
+	}
+	if !strings.Contains(string(changes), `+ EK-KA630-UG-001 "KA630 User's Guide"`) {
+		t.Errorf("changes do not list the new document:\n%s", changes)
+	}
+	if _, err := os.Stat(filepath.Join(releaseDir, "v1.1.0", SignatureFilename)); err != nil {
+		t.Errorf("the manifest was not signed: %v", err)
+	}
+	if target, _ := os.Readlink(filepath.Join(releaseDir, LatestLink)); target != "v1.1.0" {
+		t.Errorf("latest links to %q", target)
+	}
+	releases, err := LoadReleases(releaseDir)
+	if (err != nil) || (len(releases) != 2) || !releases[1].Signed || releases[0].Signed {
+		t.Errorf("releases = %+v, %v", releases, err)
+	}
+	if _, err := os.Stat(filepath.Join(releaseDir, "v1.0.0", "local.yaml")); err != nil {
+		t.Errorf("the previous release was not kept: %v", err)
+	}
+
+	// An explicit version must be newer
+	options.Force, options.Version = true, &Version{1, 0, 5}
+	if _, _, err := Publish(releaseDir, sources, options, now.Add(2*time.Hour)); err == nil {
+		t.Errorf("Publish() accepted an older version")
+	}
+}
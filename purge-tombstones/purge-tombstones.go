@@ -0,0 +1,120 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/tombstones"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+//
+// This program maintains the tombstone store (see internal/tombstones), which records the documents removed from a
+// catalog on purpose (with edit-catalog --remove) so that the generators leave them out. Deleting a tombstone lets
+// the document it buried come back the next time its catalog is rebuilt.
+//
+// The tombstones to delete are chosen with:
+//
+//   --before DATE   those recorded before DATE (YYYY-MM-DD), e.g. once the superseded files have been deleted
+//   --key KEY       the tombstone of the document with this key (may be repeated), to undo a removal
+//   --all           every tombstone
+//
+// One of these must be given unless --list is, which lists the tombstones chosen (or every tombstone, if none are)
+// and deletes nothing. The store is bin/tombstones.yaml unless another is named.
+//
+// To run the program:
+//   go run purge-tombstones/purge-tombstones.go --before 2024-01-01 bin/tombstones.yaml
+//
+
+type Tombstone = tombstones.Tombstone
+
+func main() {
+	var keys []string
+	before := flag.String("before", "", "delete the tombstones recorded before this date (YYYY-MM-DD)")
+	flag.Func("key", "delete the tombstone of the document with this key (repeatable)", func(s string) error {
+		keys = append(keys, s)
+		return nil
+	})
+	all := flag.Bool("all", false, "delete every tombstone")
+	list := flag.Bool("list", false, "list the tombstones that would be deleted (or every tombstone) and delete nothing")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if (*before != "") && !document.IsPubDate(*before) {
+		exitcode.UsageErrorf("--before must be a date (YYYY, YYYY-MM or YYYY-MM-DD), found %q", *before)
+	}
+	filename := tombstones.DefaultFilename
+	if len(flag.Args()) > 1 {
+		exitcode.UsageError("Please supply at most one tombstone store")
+	} else if len(flag.Args()) == 1 {
+		filename = flag.Arg(0)
+	}
+
+	store, err := tombstones.Open(filename, *verbose)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", filename, err)
+	}
+
+	chosen := (*before != "") || (len(keys) > 0) || *all
+	if !chosen && !*list {
+		exitcode.UsageError("Please supply --before, --key or --all to choose the tombstones to delete, or --list")
+	}
+	purge := Selector(*before, keys, *all || !chosen)
+	if *list {
+		var selected []string
+		for _, key := range store.Keys() {
+			if purge(key, store.Data[key]) {
+				selected = append(selected, key)
+			}
+		}
+		WriteTombstones(os.Stdout, store, selected)
+		fmt.Printf("%d of %d tombstones selected\n", len(selected), len(store.Data))
+		exitcode.Exit()
+	}
+	purged := store.Purge(purge)
+	for _, key := range keys {
+		if !slices.Contains(purged, key) {
+			exitcode.WarningAt("no-tombstone", filename, "WARNING: no tombstone for %s\n", key)
+		}
+	}
+	if *verbose {
+		for _, key := range purged {
+			fmt.Printf("Deleted tombstone for %s\n", key)
+		}
+	}
+	fmt.Printf("Deleted %d tombstones, %d left\n", len(purged), len(store.Data))
+	store.Save(filename)
+
+	exitcode.Exit()
+}
+
+// Returns a function that reports whether a tombstone is to be deleted: one recorded before the date before (if not
+// blank), one for a document whose key is in keys, or any if all is set
+func Selector(before string, keys []string, all bool) func(key string, tombstone Tombstone) bool {
+	return func(key string, tombstone Tombstone) bool {
+		return all || ((before != "") && (tombstone.Removed < before)) || slices.Contains(keys, key)
+	}
+}
+
+// Writes a line for each of the named tombstones: the key, when it was recorded, why, and the document's title
+func WriteTombstones(out io.Writer, store *tombstones.Store, keys []string) {
+	for _, key := range keys {
+		tombstone := store.Data[key]
+		fmt.Fprintf(out, "%-10s %s: %s", tombstone.Removed, key, tombstone.Reason)
+		if tombstone.Title != "" {
+			fmt.Fprintf(out, " (%q)", tombstone.Title)
+		}
+		fmt.Fprintln(out)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/tombstones"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	old := Tombstone{Reason: "superseded", Removed: "2023-06-30"}
+	recent := Tombstone{Reason: "bad scan", Removed: "2024-05-01"}
+	for _, test := range []struct {
+		before   string
+		keys     []string
+		all      bool
+		expected [2]bool // For old and recent
+	}{
+		{"2024-01-01", nil, false, [2]bool{true, false}},
+		{"2023", nil, false, [2]bool{false, false}},
+		{"", []string{"b"}, false, [2]bool{false, true}},
+		{"", nil, true, [2]bool{true, true}},
+	} {
+		purge := Selector(test.before, test.keys, test.all)
+		if result := [2]bool{purge("a", old), purge("b", recent)}; result != test.expected {
+			t.Errorf(`Selector(%q, %q, %v) gave %v, expected %v`, test.before, test.keys, test.all, result, test.expected)
+		}
+	}
+}
+
+func TestWriteTombstones(t *testing.T) {
+	store, err := tombstones.Open("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update("0123456789abcdef0123456789abcdef", Tombstone{Title: "KA630 Technical Manual", Reason: "superseded", Removed: "2024-05-01"})
+	var out bytes.Buffer
+	WriteTombstones(&out, store, store.Keys())
+	if expected := "2024-05-01 0123456789abcdef0123456789abcdef: superseded (\"KA630 Technical Manual\")\n"; out.String() != expected {
+		t.Errorf(`WriteTombstones() wrote %q, expected %q`, out.String(), expected)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+)
+
+//
+// This program reconciles two copies of a catalog that have diverged, for example because the master catalog
+// has been updated independently on two machines.
+//
+// If the common ancestor of the two copies is supplied (--base), a three-way merge is performed: changes made on
+// only one side are merged automatically, including additions and deletions. Without an ancestor, every document
+// found in either copy is kept and blank fields are filled in from the other copy.
+//
+// Changes that cannot be merged automatically are reported as conflicts and written to a separate YAML file
+// for manual resolution. The merged catalog holds "our" value for each conflict.
+//
+// A document added to both copies (the same file catalogued from two sources) would otherwise be merged field by field.
+// --duplicate-policy chooses which copy wins instead, e.g. --duplicate-policy local,richer (see internal/retention);
+// blank fields are still filled in from the other copy, and the decision is recorded in the document's provenance.
+//
+// To run the program:
+//   go run reconcile-catalogs/reconcile-catalogs.go --ours a.yaml --theirs b.yaml [--base ancestor.yaml] --yaml-output merged.yaml
+//
+
+type Document = document.Document
+
+func main() {
+	baseFilename := flag.String("base", "", "filepath of the common ancestor of the two catalogs (optional)")
+	oursFilename := flag.String("ours", "", "filepath of our copy of the catalog")
+	theirsFilename := flag.String("theirs", "", "filepath of their copy of the catalog")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output file to hold the merged catalog")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	duplicatePolicy := flag.String("duplicate-policy", "", "how to choose between copies of a document added on both sides: comma-separated rules from first, local, richer, newer and quality (default: merge them field by field)")
+	conflictsFilename := flag.String("conflicts", "", "filepath of the output file to hold any conflicts (default: the output YAML filepath plus .conflicts)")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if (*oursFilename == "") || (*theirsFilename == "") {
+		exitcode.UsageError("--ours and --theirs are mandatory - specify the two catalogs to reconcile")
+	}
+	if *yamlOutputFilename == "" {
+		exitcode.UsageError("Please supply a filespec for the output YAML")
+	}
+	policy, err := catalog.ParseDuplicatePolicy(*duplicatePolicy)
+	if err != nil {
+		exitcode.UsageErrorf("--duplicate-policy: %s", err)
+	}
+	if *conflictsFilename == "" {
+		*conflictsFilename = *yamlOutputFilename + ".conflicts"
+		if ref, err := workspace.Parse(*yamlOutputFilename); err == nil {
+			*conflictsFilename = workspace.Prefix + "reports/" + ref.Name + "-conflicts.yaml"
+		}
+	}
+
+	var base catalog.Catalog
+	if *baseFilename != "" {
+		base = ReadCatalog(*baseFilename)
+	}
+	ours := ReadCatalog(*oursFilename)
+	theirs := ReadCatalog(*theirsFilename)
+
+	result := catalog.MergeWithPolicy(base, ours, theirs, policy)
+
+	for _, conflict := range result.Conflicts {
+		if conflict.Field == "" {
+			exitcode.WarningAt("merge-conflict", conflict.Key, "CONFLICT: document %s is %s in ours but %s in theirs\n", conflict.Key, conflict.Ours, conflict.Theirs)
+		} else {
+			exitcode.WarningAt("merge-conflict", conflict.Key, "CONFLICT: document %s field %s: ours [%s] theirs [%s]\n", conflict.Key, conflict.Field, conflict.Ours, conflict.Theirs)
+		}
+	}
+
+	if *verbose {
+		fmt.Printf("Ours: %d documents, theirs: %d documents, base: %d documents\n", len(ours), len(theirs), len(base))
+	}
+	fmt.Printf("Merged %d documents with %d conflicts\n", len(result.Documents), len(result.Conflicts))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, result.Documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, result.Documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, result.Documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	// Only leave a conflicts file behind if there is something to resolve
+	if len(result.Conflicts) > 0 {
+		data, err := document.MarshalYaml(result.Conflicts)
+		if err != nil {
+			exitcode.Fatal("Bad YAML data: ", err)
+		}
+		if _, err := workspace.WriteFile(*conflictsFilename, data, 0644); err != nil {
+			exitcode.Fatal("Failed conflicts write: ", err)
+		}
+		fmt.Printf("Conflicts written to %s\n", *conflictsFilename)
+	} else if err := os.Remove(*conflictsFilename); (err != nil) && !os.IsNotExist(err) {
+		exitcode.Warning("WARNING: cannot remove stale conflicts file %s: %s\n", *conflictsFilename, err)
+	}
+
+	exitcode.Exit()
+}
+
+// Reads a catalog, exiting if it cannot be read.
+func ReadCatalog(filename string) catalog.Catalog {
+	documents, err := catalog.Load(filename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", filename, err)
+	}
+	return documents
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//
+// This program recomputes the key of every document in a catalog, so that a catalog written under an older
+// key strategy can be compared with (or merged into) catalogs written under the current one.
+//
+// The keying scheme is selected with --scheme:
+//
+//   document:  the current document.BuildKeyFromDocument rules (the default)
+//   md5:       the MD5 checksum (documents without one keep their existing key)
+//   filepath:  the document filepath
+//
+// Two documents that end up with the same key are a collision. Identical documents are simply merged;
+// otherwise the first (in order of the old key) is kept and the others are reported. With --collision-report each
+// collision is also shown in full: the fields that differ between the two documents, side by side, and which of
+// revision, date, size (or failing those the MD5 checksum or filepath) would separate them (see catalog.ReportCollision).
+//
+// To run the program:
+//   go run rekey-catalog/rekey-catalog.go --scheme document --yaml-output rekeyed.yaml catalog.yaml
+//
+
+type Document = document.Document
+
+// KeySchemes maps each --scheme name to the function that computes a document's new key.
+// The old key is supplied for schemes that cannot always compute a key.
+var KeySchemes = map[string]func(oldKey string, doc Document) string{
+	"document": func(oldKey string, doc Document) string {
+		return document.BuildKeyFromDocument(doc)
+	},
+	"md5": func(oldKey string, doc Document) string {
+		if doc.Md5 != "" {
+			return doc.Md5
+		}
+		return oldKey
+	},
+	"filepath": func(oldKey string, doc Document) string {
+		return doc.Filepath
+	},
+}
+
+// Collision describes a document that could not be given its new key because another document already has it
+type Collision struct {
+	NewKey  string // The key both documents map to
+	KeptKey string // The old key of the document that was kept
+	LostKey string // The old key of the document that was dropped
+}
+
+func main() {
+	scheme := flag.String("scheme", "document", "the keying scheme to apply: "+SchemeNames())
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output file to hold the re-keyed catalog")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	collisionReport := flag.Bool("collision-report", false, "show the differing fields of each pair of colliding documents side by side, with a suggested disambiguator")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	keyFunction, found := KeySchemes[*scheme]
+	if !found {
+		exitcode.UsageErrorf("Unknown --scheme %q; expected one of %s", *scheme, SchemeNames())
+	}
+	if *yamlOutputFilename == "" {
+		exitcode.UsageError("Please supply a filespec for the output YAML")
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to re-key")
+	}
+
+	inputFilename := flag.Arg(0)
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	rekeyed, changed, collisions := Rekey(documents, keyFunction)
+	for _, collision := range collisions {
+		exitcode.WarningAt("key-collision", collision.NewKey, "WARNING: %s and %s both re-key to %s - dropped latter\n", collision.KeptKey, collision.LostKey, collision.NewKey)
+		if *collisionReport {
+			report := catalog.ReportCollision(collision.NewKey, documents[collision.KeptKey], documents[collision.LostKey])
+			catalog.WriteCollision(os.Stdout, report, collision.KeptKey, collision.LostKey)
+		}
+	}
+
+	if *verbose {
+		fmt.Printf("Scheme: %s\n", *scheme)
+	}
+	fmt.Printf("Re-keyed %d documents: %d keys changed, %d collisions, %d documents written\n", len(documents), changed, len(collisions), len(rekeyed))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, rekeyed, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, rekeyed); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, rekeyed); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Returns the names of the known keying schemes, for messages
+func SchemeNames() string {
+	var names []string
+	for name := range KeySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Re-keys every document using keyFunction.
+// Returns the re-keyed catalog, the number of documents whose key changed, and any collisions.
+// Documents are processed in order of their old key so that the outcome of a collision is deterministic.
+func Rekey(documents map[string]Document, keyFunction func(string, Document) string) (map[string]Document, int, []Collision) {
+	oldKeys := make([]string, 0, len(documents))
+	for key := range documents {
+		oldKeys = append(oldKeys, key)
+	}
+	sort.Strings(oldKeys)
+
+	rekeyed := make(map[string]Document)
+	keptKeys := make(map[string]string) // new key => old key
+	changed := 0
+	var collisions []Collision
+	for _, oldKey := range oldKeys {
+		doc := documents[oldKey]
+		newKey := keyFunction(oldKey, doc)
+		if newKey != oldKey {
+			changed += 1
+		}
+		if existing, found := rekeyed[newKey]; found {
+			// An exact duplicate is harmless: keep just one copy
+			if !reflect.DeepEqual(existing, doc) {
+				collisions = append(collisions, Collision{NewKey: newKey, KeptKey: keptKeys[newKey], LostKey: oldKey})
+			}
+			continue
+		}
+		rekeyed[newKey] = doc
+		keptKeys[newKey] = oldKey
+	}
+	return rekeyed, changed, collisions
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	documents := map[string]Document{
+		"EK-KA630-TM":     {PartNum: "EK-KA630-TM", Md5: "aaaa", Filepath: "file:///DEC_0001/ka630.pdf"},
+		"file:///x/y.txt": {Title: "Notes", Filepath: "file:///x/y.txt"},
+		"bbbb":            {PartNum: "EK-1", Md5: "bbbb", Filepath: "file:///DEC_0001/one.pdf"},
+		"cccc":            {PartNum: "EK-2", Filepath: "file:///DEC_0001/two.pdf"},
+		"dddd":            {PartNum: "EK-2", Filepath: "file:///DEC_0002/two.pdf"},
+		"eeee":            {PartNum: "EK-1", Md5: "bbbb", Filepath: "file:///DEC_0001/one.pdf"}, // identical to "bbbb"
+	}
+
+	rekeyed, changed, collisions := Rekey(documents, KeySchemes["document"])
+
+	expected := map[string]Document{
+		"aaaa":      documents["EK-KA630-TM"],
+		"Notes.txt": documents["file:///x/y.txt"],
+		"bbbb":      documents["bbbb"],
+		"EK-2.pdf":  documents["cccc"],
+	}
+	if !reflect.DeepEqual(rekeyed, expected) {
+		t.Errorf("Rekey() mismatch:\n%v\n%v", rekeyed, expected)
+	}
+	if changed != 5 {
+		t.Errorf("Rekey() changed = %d, expected 5", changed)
+	}
+	expectedCollisions := []Collision{{NewKey: "EK-2.pdf", KeptKey: "cccc", LostKey: "dddd"}}
+	if !reflect.DeepEqual(collisions, expectedCollisions) {
+		t.Errorf("Rekey() collisions = %v, expected %v", collisions, expectedCollisions)
+	}
+}
+
+func TestRekeyMd5KeepsKeyWithoutChecksum(t *testing.T) {
+	documents := map[string]Document{"EK-1.pdf": {PartNum: "EK-1"}}
+	rekeyed, changed, _ := Rekey(documents, KeySchemes["md5"])
+	if _, found := rekeyed["EK-1.pdf"]; !found || (changed != 0) {
+		t.Errorf("Rekey(md5) = %v, %d", rekeyed, changed)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//
+// This program renders one or more catalogs through a user-supplied Go text/template, so that a new output
+// format (a wiki page, a Markdown inventory, a sheet of labels, ...) needs only a template, not a new tool.
+//
+// The template is executed with a TemplateData value:
+//
+//   .Documents  every document, each with its catalog .Key, in the canonical catalog order
+//   .Catalog    the catalog itself (key => Document)
+//   .Sources    the catalog files that were read
+//
+// The helper functions (see Funcs) take the list of documents last, so that they can be chained:
+//
+//   {{range .Documents | where "Format" "PDF" | sortBy "PartNum"}}| {{.PartNum}} | {{.Title}} |
+//   {{end}}
+//
+//   {{range groupBy "Collection" .Documents}}## {{.Key}} ({{len .Documents}})
+//   {{range .Documents}}* {{.Title}}
+//   {{end}}{{end}}
+//
+// Field names may be given as in Go (PartNum) or as in the YAML (partnum).
+//
+// .Documents, sortBy and groupBy order text in the collation order (see internal/collate): ignoring case and accents,
+// unless --collation (or DOCS_TO_YAML_COLLATION) names a locale that places some accented letters elsewhere, e.g. sv.
+//
+// --tag and --without-tag (each repeatable) restrict the catalog to documents with (or without) the given tags;
+// within a template, "tagged TAG" does the same and "hasTag TAG ." tests a single document.
+// --where restricts the catalog to the documents selected by a filter expression (see catalog.Expr).
+//
+// Only the documents the --audience may see are rendered (see internal/visibility): by default the public, so that
+// restricted and private documents never reach a published page; --audience private renders every document, e.g. for
+// an internal report.
+//
+// To run the program:
+//   go run render-catalog/render-catalog.go --template inventory.tmpl --output inventory.md bin/local.yaml
+//
+
+type Document = document.Document
+
+// Entry is a document along with its key in the catalog
+type Entry struct {
+	Key string
+	Document
+}
+
+// TemplateData is the value the template is executed with
+type TemplateData struct {
+	Documents []Entry
+	Catalog   catalog.Catalog
+	Sources   []string
+}
+
+// Group is a set of documents that share the value of a field
+type Group struct {
+	Key       string
+	Documents []Entry
+}
+
+// Funcs holds the helper functions available to templates
+var Funcs = template.FuncMap{
+	"sortBy":   SortBy,
+	"reverse":  Reverse,
+	"where":    Where,
+	"whereNot": WhereNot,
+	"match":    Match,
+	"groupBy":  GroupBy,
+	"tagged":   Tagged,
+	"hasTag":   func(tag string, entry Entry) bool { return document.HasTag(entry.Document, tag) },
+	"field":    Field,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"replace":  func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+	"default":  Default,
+	"size":     HumanSize,
+}
+
+func main() {
+	templateFilename := flag.String("template", "", "filepath of the Go text/template to render")
+	outputFilename := flag.String("output", "", "filepath of the rendered output (default: standard output)")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	where := flag.String("where", "", "include only documents selected by this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	var requiredTags, excludedTags []string
+	flag.Func("tag", "include only documents with this tag (repeatable)", func(s string) error {
+		requiredTags = append(requiredTags, s)
+		return nil
+	})
+	flag.Func("without-tag", "exclude documents with this tag (repeatable)", func(s string) error {
+		excludedTags = append(excludedTags, s)
+		return nil
+	})
+
+	audience := flag.String("audience", visibility.Public, "render only the documents this audience may see: public, restricted or private")
+	collate.Flag()
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if *templateFilename == "" {
+		exitcode.UsageError("Please supply a template with --template")
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog to render")
+	}
+
+	if !visibility.IsLevel(*audience) {
+		exitcode.UsageErrorf("--audience must be one of %s, found %q", strings.Join(visibility.Levels, ", "), *audience)
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+
+	tmpl, err := template.New(filepath.Base(*templateFilename)).Funcs(Funcs).ParseFiles(*templateFilename)
+	if err != nil {
+		exitcode.UsageErrorf("Bad template: %s", err)
+	}
+
+	documents := make(catalog.Catalog)
+	for _, filename := range flag.Args() {
+		loaded, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		for _, key := range documents.Add(loaded) {
+			exitcode.WarningAt("duplicate-document", filename, "WARNING: document %s in %s already seen - dropped latter\n", key, filename)
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Read %d documents from %s\n", len(loaded), filename)
+		}
+	}
+
+	var output bytes.Buffer
+	for _, key := range visibility.Withheld(documents, *audience) {
+		events.Info("withheld", documents[key].Filepath, "%s withheld: %s", key, visibility.Of(documents[key]))
+	}
+	documents = documents.Visible(*audience).Tagged(requiredTags, excludedTags).Where(filter)
+	if err := tmpl.Execute(&output, NewTemplateData(documents, flag.Args())); err != nil {
+		exitcode.Fatalf("Failed to render %s: %s", *templateFilename, err)
+	}
+
+	if *outputFilename == "" {
+		os.Stdout.Write(output.Bytes())
+	} else if _, err := workspace.WriteFile(*outputFilename, output.Bytes(), 0644); err != nil {
+		exitcode.Fatal("Failed output write: ", err)
+	}
+
+	exitcode.Exit()
+}
+
+// Builds the template data for a catalog. Documents are listed in the same order as in a written catalog.
+func NewTemplateData(documents catalog.Catalog, sources []string) TemplateData {
+	data := TemplateData{Catalog: documents, Sources: sources}
+	for _, key := range document.OrderedKeys(documents) {
+		data.Documents = append(data.Documents, Entry{Key: key, Document: documents[key]})
+	}
+	return data
+}
+
+// Returns the named field of an entry; the name is matched without regard to case.
+func fieldValue(entry Entry, name string) (reflect.Value, error) {
+	value := reflect.ValueOf(entry).FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+	if !value.IsValid() {
+		return value, fmt.Errorf("unknown document field %q", name)
+	}
+	return value, nil
+}
+
+// Returns the named field of an entry as a string
+func Field(name string, entry Entry) (string, error) {
+	value, err := fieldValue(entry, name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value.Interface()), nil
+}
+
+// Returns a copy of the entries sorted on the named field (numerically for a number such as Size, and otherwise in the
+// collation order).
+// The sort is stable, so sorting on a second field and then a first gives a two-level sort.
+func SortBy(name string, entries []Entry) ([]Entry, error) {
+	if _, err := fieldValue(Entry{}, name); err != nil {
+		return nil, err
+	}
+	sorted := append([]Entry(nil), entries...)
+	collator := collate.Default()
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := fieldValue(sorted[i], name)
+		b, _ := fieldValue(sorted[j], name)
+		if a.Kind() == reflect.Int64 {
+			return a.Int() < b.Int()
+		}
+		return collator.Less(a.String(), b.String())
+	})
+	return sorted, nil
+}
+
+// Returns a copy of the entries in reverse order
+func Reverse(entries []Entry) []Entry {
+	reversed := make([]Entry, len(entries))
+	for i, entry := range entries {
+		reversed[len(entries)-1-i] = entry
+	}
+	return reversed
+}
+
+// Returns the entries for which keep returns true for the named field's value
+func filter(name string, entries []Entry, keep func(value string) bool) ([]Entry, error) {
+	var kept []Entry
+	for _, entry := range entries {
+		value, err := Field(name, entry)
+		if err != nil {
+			return nil, err
+		}
+		if keep(value) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// Returns the entries whose named field has the specified value
+func Where(name string, value string, entries []Entry) ([]Entry, error) {
+	return filter(name, entries, func(v string) bool { return v == value })
+}
+
+// Returns the entries whose named field does not have the specified value
+func WhereNot(name string, value string, entries []Entry) ([]Entry, error) {
+	return filter(name, entries, func(v string) bool { return v != value })
+}
+
+// Returns the entries that have the specified tag
+func Tagged(tag string, entries []Entry) []Entry {
+	var kept []Entry
+	for _, entry := range entries {
+		if document.HasTag(entry.Document, tag) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// Returns the entries whose named field matches the regular expression
+func Match(name string, pattern string, entries []Entry) ([]Entry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return filter(name, entries, re.MatchString)
+}
+
+// Groups the entries by the value of the named field. Groups are in the collation order of their value; within a group
+// the entries keep their original order.
+func GroupBy(name string, entries []Entry) ([]Group, error) {
+	groups := make(map[string][]Entry)
+	for _, entry := range entries {
+		value, err := Field(name, entry)
+		if err != nil {
+			return nil, err
+		}
+		groups[value] = append(groups[value], entry)
+	}
+	var result []Group
+	for value, members := range groups {
+		result = append(result, Group{Key: value, Documents: members})
+	}
+	collator := collate.Default()
+	sort.Slice(result, func(i, j int) bool { return collator.Less(result[i].Key, result[j].Key) })
+	return result, nil
+}
+
+// Returns s, or fallback if s is blank
+func Default(fallback string, s string) string {
+	if strings.TrimSpace(s) == "" {
+		return fallback
+	}
+	return s
+}
+
+// Formats a size in bytes for people, e.g. 1.5 MB
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	divisor, exponent := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(divisor), "KMGTPE"[exponent])
+}
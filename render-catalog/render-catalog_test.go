@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/pkg/catalog"
+	"testing"
+	"text/template"
+)
+
+func testData() TemplateData {
+	documents := catalog.Catalog{
+		"a": {Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM", Format: "PDF", Size: 2048, Collection: "local:DEC_0001", Tags: []string{"rare"}},
+		"b": {Title: "ALGOL Programmer's Guide", PartNum: "AA-0196C-TK", Format: "PDF", Size: 100, Collection: "VaxHaven"},
+		"c": {Title: "VAX Field Guide", Format: "TXT", Size: 5000000, Collection: "VaxHaven"},
+	}
+	return NewTemplateData(documents, []string{"test.yaml"})
+}
+
+func render(t *testing.T, text string) string {
+	t.Helper()
+	tmpl, err := template.New("test").Funcs(Funcs).Parse(text)
+	if err != nil {
+		t.Fatalf(`Parse(%q) failed: %v`, text, err)
+	}
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, testData()); err != nil {
+		t.Fatalf(`Execute(%q) failed: %v`, text, err)
+	}
+	return output.String()
+}
+
+func TestTemplates(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{range .Documents}}{{.Key}}{{end}}`, "abc"},
+		{`{{range .Documents | where "format" "PDF" | sortBy "PartNum"}}{{.PartNum}};{{end}}`, "AA-0196C-TK;EK-KA630-TM;"},
+		{`{{range sortBy "Size" .Documents | reverse}}{{.Key}}{{end}}`, "cab"},
+		{`{{range groupBy "Collection" .Documents}}{{.Key}}={{len .Documents}};{{end}}`, "local:DEC_0001=1;VaxHaven=2;"},
+		{`{{range match "Title" "^VAX" .Documents}}{{upper .Title}} {{size .Size}}{{end}}`, "VAX FIELD GUIDE 4.8 MB"},
+		{`{{range whereNot "Format" "PDF" .Documents}}[{{default "none" .PartNum}}]{{end}}`, "[none]"},
+		{`{{with index .Catalog "a"}}{{.Title}}{{end}}`, "KA630 CPU Module Technical Manual"},
+		{`{{range tagged "rare" .Documents}}{{.Key}}{{end}}{{range .Documents}}{{if hasTag "rare" .}}!{{end}}{{end}}`, "a!"},
+	}
+	for _, test := range tests {
+		if output := render(t, test.template); output != test.expected {
+			t.Errorf(`%s rendered %q, expected %q`, test.template, output, test.expected)
+		}
+	}
+}
+
+func TestBytesCollation(t *testing.T) {
+	defer func(locale string) { collate.SetDefault(locale) }(collate.Default().Locale)
+	if err := collate.SetDefault(collate.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	// Upper case sorts before lower case, as in catalogs written by older versions of the tools
+	if output := render(t, `{{range .Documents}}{{.Key}}{{end}}`); output != "bca" {
+		t.Errorf("with bytes collation rendered %q", output)
+	}
+}
+
+func TestUnknownField(t *testing.T) {
+	if _, err := SortBy("Colour", testData().Documents); err == nil {
+		t.Errorf(`SortBy() accepted an unknown field`)
+	}
+	if _, err := GroupBy("Colour", testData().Documents); err == nil {
+		t.Errorf(`GroupBy() accepted an unknown field`)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 3 * 1024 * 1024 * 1024: "3.0 GB"}
+	for size, expected := range tests {
+		if result := HumanSize(size); result != expected {
+			t.Errorf(`HumanSize(%d) = %s, expected %s`, size, result, expected)
+		}
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exechook"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/notify"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+//
+// This program runs the whole workflow (the collectors, scanning the local volumes, building the master catalog, the
+// uniqueness analysis and the exports) as a series of stages described in a YAML file (by default data/pipeline.yaml):
+//
+//   cache: bin/cache
+//   stages:
+//     - name: bitsavers
+//       run: bin/bitsavers-to-yaml --yaml-output bin/yaml/bitsavers.yaml --events {events}
+//       inputs: [data/bitsavers-IndexByDate.txt]
+//       outputs: [bin/yaml/bitsavers.yaml]
+//     - name: local
+//       run: bin/local-archive-to-yaml --exif-cache {cache}/exif.store --yaml-output bin/local.yaml --events {events}
+//       outputs: [bin/local.yaml]
+//     - name: master
+//       run: bin/build-master --yaml-output bin/yaml/master.yaml --events {events} bin/local.yaml bin/yaml/bitsavers.yaml
+//       inputs: [bin/local.yaml, bin/yaml/*.yaml]
+//       outputs: [bin/yaml/master.yaml]
+//
+// The stages run in order, each command being split into arguments as for an --exec hook (no shell is involved). In
+// each argument {cache} is replaced by the cache directory, which every stage shares (e.g. for the PDF metadata
+// cache), and {events} by a file in it receiving the stage's events, from which its warnings and errors are counted.
+//
+// A stage whose command and inputs (files or glob patterns) are unchanged since it last succeeded, and whose outputs
+// all exist, is up to date and not run again. A stage that lists no inputs (e.g. one reading the local volumes) always
+// runs. What each stage last ran with is recorded in the cache directory (pipeline-state.yaml).
+//
+//   --force NAME    run the stage even if it is up to date ("all" for every stage; may be repeated)
+//   --skip NAME     do not run the stage (may be repeated); a stage may also be marked "skip: true"
+//   --from NAME     skip the stages before this one
+//   --dry-run       show what would be run
+//   --keep-going    carry on with the later stages after a stage fails
+//
+// At the end a summary gives the outcome, time taken, warnings and errors of every stage. A stage that completes with
+// warnings (exit status 1) counts as a warning, and one that fails as an error; by default the stages after a failure
+// are not run.
+//
+// --notify FILE sends a summary of the run (see internal/notify) by email, or to a webhook or ntfy topic, if anything
+// noteworthy happened: the warnings, errors and bit rot reported in the events of every stage, the stages that failed
+// and, if the pipeline names its main catalog (e.g. "catalog: bin/yaml/master.yaml"), the documents added to and
+// removed from it.
+//
+// To run the program:
+//   go run run-pipeline/run-pipeline.go --config data/pipeline.yaml --force master
+//
+
+// The configuration file read if no other is given
+const DefaultConfigFilename = "data/pipeline.yaml"
+
+// The cache directory used if the configuration does not give one
+const DefaultCacheDir = "bin/cache"
+
+// The name of the file in the cache directory recording what each stage last ran with
+const StateFilename = "pipeline-state.yaml"
+
+// Stage is one step of the pipeline
+type Stage struct {
+	Name    string
+	Run     string   // The command, with {cache} and {events} placeholders
+	Inputs  []string `yaml:",omitempty"` // The files (or glob patterns) the stage reads
+	Outputs []string `yaml:",omitempty"` // The files the stage writes
+	Skip    bool     `yaml:",omitempty"` // True if the stage is never run
+}
+
+// Config is the description of a pipeline
+type Config struct {
+	Cache   string // The directory shared by every stage
+	Catalog string `yaml:",omitempty"` // The catalog (e.g. the master catalog) whose documents added and removed are notified
+	Stages  []Stage
+}
+
+// Reads a pipeline description, checking that every stage has a distinct name and a command
+func ReadConfig(filename string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	if err := document.UnmarshalYaml(data, &config); err != nil {
+		return config, fmt.Errorf("unmarshal error for %s: %w", filename, err)
+	}
+	if config.Cache == "" {
+		config.Cache = DefaultCacheDir
+	}
+	if len(config.Stages) == 0 {
+		return config, fmt.Errorf("%s: no stages", filename)
+	}
+	var names []string
+	for i, stage := range config.Stages {
+		switch {
+		case stage.Name == "":
+			return config, fmt.Errorf("%s: stage %d has no name", filename, i+1)
+		case slices.Contains(names, stage.Name):
+			return config, fmt.Errorf("%s: more than one stage named %q", filename, stage.Name)
+		case strings.TrimSpace(stage.Run) == "":
+			return config, fmt.Errorf("%s: stage %s has no command", filename, stage.Name)
+		}
+		if _, err := exechook.Parse(stage.Run); err != nil {
+			return config, fmt.Errorf("%s: stage %s: %w", filename, stage.Name, err)
+		}
+		names = append(names, stage.Name)
+	}
+	return config, nil
+}
+
+// Returns the stage with the given name, and whether there is one
+func (config Config) Stage(name string) (Stage, bool) {
+	for _, stage := range config.Stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return Stage{}, false
+}
+
+// StageState is what a stage last succeeded with
+type StageState struct {
+	Fingerprint string    // Of the command and inputs (see Fingerprint)
+	Finished    time.Time // When it finished
+}
+
+// State is the StageState of each stage, by name
+type State map[string]StageState
+
+// Options says which stages to run
+type Options struct {
+	Force     []string // The stages to run even if up to date; "all" for every stage
+	Skip      []string // The stages not to run
+	From      string   // The first stage to run, if not the first
+	DryRun    bool     // Only report what would be run
+	KeepGoing bool     // Run the later stages after a stage fails
+}
+
+// The outcome of a stage
+const (
+	StatusRan         = "ran"
+	StatusWarnings    = "warnings"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+	StatusUpToDate    = "up-to-date"
+	StatusSkipped     = "skipped"
+	StatusWouldRun    = "would-run"
+	StatusNotRun      = "not-run"
+)
+
+// Result is the outcome of one stage
+type Result struct {
+	Stage    string
+	Status   string
+	Duration time.Duration
+	ExitCode int
+	Warnings int    // The warnings the stage reported in its events file
+	Errors   int    // The errors the stage reported in its events file
+	Message  string // Why the stage could not be run, if it could not
+}
+
+// Runs a command, connected to the console, and returns its exit status.
+// Tests replace this to avoid running programs.
+var RunCommand = func(args []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+func main() {
+	var options Options
+	configFilename := flag.String("config", DefaultConfigFilename, "filepath of the YAML file describing the pipeline")
+	flag.Func("force", "run the stage with this name even if it is up to date, or \"all\" (repeatable)", func(s string) error {
+		options.Force = append(options.Force, s)
+		return nil
+	})
+	flag.Func("skip", "do not run the stage with this name (repeatable)", func(s string) error {
+		options.Skip = append(options.Skip, s)
+		return nil
+	})
+	flag.StringVar(&options.From, "from", "", "skip the stages before the one with this name")
+	flag.BoolVar(&options.DryRun, "dry-run", false, "show what would be run without running it")
+	flag.BoolVar(&options.KeepGoing, "keep-going", false, "run the later stages after a stage fails")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	notifyFilename := flag.String("notify", "", "filepath of a YAML file saying where to send a summary of the run if anything noteworthy happened")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if len(flag.Args()) > 0 {
+		exitcode.UsageError("Unexpected arguments: ", flag.Args())
+	}
+
+	interrupt.Watch()
+
+	config, err := ReadConfig(*configFilename)
+	if err != nil {
+		exitcode.UsageErrorf("Bad --config: %v", err)
+	}
+	var notifyConfig notify.Config
+	if *notifyFilename != "" {
+		if notifyConfig, err = notify.ReadConfig(*notifyFilename); err != nil {
+			exitcode.UsageErrorf("Bad --notify: %v", err)
+		}
+	}
+	for _, name := range slices.Concat(options.Force, options.Skip, []string{options.From}) {
+		if _, found := config.Stage(name); !found && (name != "") && (name != "all") {
+			exitcode.UsageErrorf("No stage named %q in %s", name, *configFilename)
+		}
+	}
+
+	stateFilename := filepath.Join(config.Cache, StateFilename)
+	state := make(State)
+	if _, err := checkpoint.LoadState(stateFilename, &state); err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", stateFilename, err)
+	}
+	if !options.DryRun {
+		if err := os.MkdirAll(filepath.Join(config.Cache, "events"), 0755); err != nil {
+			exitcode.Fatalf("Cannot create the cache directory %s: %v", config.Cache, err)
+		}
+	}
+
+	// The documents of the catalog before the run, to tell which the run added
+	var before map[string]bool
+	if (*notifyFilename != "") && (config.Catalog != "") && !options.DryRun {
+		if before, err = CatalogKeys(config.Catalog); err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", config.Catalog, err)
+		}
+	}
+
+	results := RunPipeline(config, state, stateFilename, options)
+
+	fmt.Println()
+	WriteSummary(os.Stdout, results)
+	for _, result := range results {
+		switch result.Status {
+		case StatusWarnings:
+			exitcode.AddWarnings(1)
+		case StatusFailed:
+			exitcode.AddErrors(1)
+		}
+	}
+
+	if (*notifyFilename != "") && !options.DryRun {
+		summary, err := SummariseRun(config, results, before)
+		if err != nil {
+			exitcode.Warning("Cannot summarise the run: %v\n", err)
+		}
+		if summary.Noteworthy(notifyConfig) {
+			if err := notify.Send(notifyConfig, summary); err != nil {
+				exitcode.Warning("Cannot send the notification: %v\n", err)
+			} else {
+				fmt.Printf("Sent the notification: %s\n", summary.Subject())
+			}
+		}
+	}
+	if interrupt.Requested() || slices.ContainsFunc(results, func(r Result) bool { return r.Status == StatusInterrupted }) {
+		interrupt.Exit("re-run to carry on; the stages that completed are up to date")
+	}
+	exitcode.Exit()
+}
+
+// Runs the stages of the pipeline in order, recording each that succeeds in state (and, unless stateFilename is
+// blank, saving it). Returns the outcome of every stage, in order.
+func RunPipeline(config Config, state State, stateFilename string, options Options) []Result {
+	var results []Result
+	started := options.From == ""
+	stopped := false
+	for _, stage := range config.Stages {
+		started = started || (stage.Name == options.From)
+		result := Result{Stage: stage.Name}
+		switch {
+		case stopped:
+			result.Status = StatusNotRun
+		case !started || stage.Skip || slices.Contains(options.Skip, stage.Name):
+			result.Status = StatusSkipped
+		default:
+			result = runStage(config, stage, state, stateFilename, options)
+		}
+		switch result.Status {
+		case StatusFailed:
+			stopped = !options.KeepGoing
+		case StatusInterrupted:
+			stopped = true
+		}
+		stopped = stopped || interrupt.Requested()
+		results = append(results, result)
+	}
+	return results
+}
+
+// Runs one stage unless it is up to date
+func runStage(config Config, stage Stage, state State, stateFilename string, options Options) Result {
+	result := Result{Stage: stage.Name}
+	fingerprint, err := Fingerprint(stage, config.Cache)
+	if err != nil {
+		result.Status, result.Message = StatusFailed, err.Error()
+		fmt.Printf("Stage %s: %s\n", stage.Name, err)
+		return result
+	}
+	forced := slices.Contains(options.Force, stage.Name) || slices.Contains(options.Force, "all")
+	if !forced && UpToDate(stage, fingerprint, state[stage.Name]) {
+		result.Status = StatusUpToDate
+		return result
+	}
+
+	eventsFilename := filepath.Join(config.Cache, "events", stage.Name+".ndjson")
+	hook, _ := exechook.Parse(stage.Run)
+	args := hook.Expand(map[string]string{"{cache}": config.Cache, "{events}": eventsFilename})
+	if options.DryRun {
+		fmt.Printf("Would run stage %s: %s\n", stage.Name, strings.Join(args, " "))
+		result.Status = StatusWouldRun
+		return result
+	}
+
+	fmt.Println(console.Colour(console.Green, fmt.Sprintf("=== Stage %s: %s", stage.Name, strings.Join(args, " "))))
+	os.Remove(eventsFilename)
+	start := time.Now()
+	result.ExitCode, err = RunCommand(args)
+	result.Duration = time.Since(start)
+	result.Warnings, result.Errors = CountEvents(eventsFilename)
+	switch {
+	case err != nil:
+		result.Status, result.Message = StatusFailed, err.Error()
+	case result.ExitCode == interrupt.ExitCode:
+		result.Status = StatusInterrupted
+	case (result.ExitCode != exitcode.Clean) && (result.ExitCode != exitcode.CompletedWithWarnings):
+		result.Status = StatusFailed
+	case result.ExitCode == exitcode.CompletedWithWarnings:
+		result.Status = StatusWarnings
+	default:
+		result.Status = StatusRan
+	}
+	if (result.Status == StatusRan) || (result.Status == StatusWarnings) {
+		state[stage.Name] = StageState{Fingerprint: fingerprint, Finished: time.Now().UTC().Truncate(time.Second)}
+		if stateFilename != "" {
+			if err := checkpoint.SaveState(stateFilename, state); err != nil {
+				exitcode.Warning("Cannot save the state of the pipeline in %s: %v\n", stateFilename, err)
+			}
+		}
+	}
+	return result
+}
+
+// Returns the fingerprint of a stage: the MD5 checksum of its command, the cache directory and the name and content
+// of every file matching its inputs (a missing input counting as such). A stage without inputs has no fingerprint.
+func Fingerprint(stage Stage, cacheDir string) (string, error) {
+	if len(stage.Inputs) == 0 {
+		return "", nil
+	}
+	hash := md5.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00", stage.Run, cacheDir)
+	for _, pattern := range stage.Inputs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", fmt.Errorf("bad input %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(hash, "%s\x00missing\x00", pattern)
+		}
+		for _, match := range matches {
+			md5, err := hashing.Md5File(match)
+			if err != nil {
+				return "", fmt.Errorf("cannot read input %s: %w", match, err)
+			}
+			fmt.Fprintf(hash, "%s\x00%s\x00", match, md5)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Reports whether a stage with the given fingerprint need not be run again: it has inputs, they are unchanged since
+// it last succeeded, and its outputs all exist
+func UpToDate(stage Stage, fingerprint string, previous StageState) bool {
+	if (fingerprint == "") || (fingerprint != previous.Fingerprint) {
+		return false
+	}
+	for _, output := range stage.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of warnings and errors in an events file, or none if there is no such file
+func CountEvents(filename string) (int, int) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+	warnings, errorCount := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event events.Event
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		switch event.Severity {
+		case events.SeverityWarning:
+			warnings += 1
+		case events.SeverityError:
+			errorCount += 1
+		}
+	}
+	return warnings, errorCount
+}
+
+// Returns the keys of the documents in a catalog, or none if there is no such catalog
+func CatalogKeys(filename string) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	if _, err := os.Stat(filename); errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	}
+	err := catalog.Each(filename, func(key string, doc document.Document) error {
+		keys[key] = true
+		return nil
+	})
+	return keys, err
+}
+
+// Summarises a run for a notification (see internal/notify): the events reported by every stage that ran, the stages
+// that failed and, if the pipeline names a catalog, the documents added to and removed from it since before (its keys
+// before the run)
+func SummariseRun(config Config, results []Result, before map[string]bool) (notify.Summary, error) {
+	summary := notify.NewSummary(filepath.Base(os.Args[0]))
+	summary.Outcome = exitcode.Summary(exitcode.Code())
+	var errs []error
+	for _, result := range results {
+		switch result.Status {
+		case StatusRan, StatusWarnings, StatusFailed, StatusInterrupted:
+			if err := summary.AddEventsFile(filepath.Join(config.Cache, "events", result.Stage+".ndjson")); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		switch {
+		case (result.Status == StatusFailed) && (result.Message != ""):
+			summary.Fatal = append(summary.Fatal, fmt.Sprintf("Stage %s failed: %s", result.Stage, result.Message))
+		case result.Status == StatusFailed:
+			summary.Fatal = append(summary.Fatal, fmt.Sprintf("Stage %s failed (exit status %d)", result.Stage, result.ExitCode))
+		}
+	}
+	if (config.Catalog != "") && (before != nil) {
+		after, err := CatalogKeys(config.Catalog)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for key := range after {
+			if !before[key] {
+				summary.DocumentsAdded += 1
+			}
+		}
+		for key := range before {
+			if !after[key] {
+				summary.DocumentsRemoved += 1
+			}
+		}
+	}
+	var table bytes.Buffer
+	WriteSummary(&table, results)
+	summary.Details = strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+	return summary, errors.Join(errs...)
+}
+
+// Writes a table of the outcome of every stage
+func WriteSummary(out io.Writer, results []Result) {
+	width := len("Stage")
+	for _, result := range results {
+		width = max(width, len(result.Stage))
+	}
+	fmt.Fprintf(out, "%-*s  %-11s  %8s  %8s  %6s\n", width, "Stage", "Status", "Time", "Warnings", "Errors")
+	var total time.Duration
+	for _, result := range results {
+		status := result.Status
+		if (result.Status == StatusFailed) && (result.ExitCode != 0) {
+			status = fmt.Sprintf("failed (%d)", result.ExitCode)
+		}
+		elapsed := "-"
+		if result.Duration > 0 {
+			elapsed = result.Duration.Round(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(out, "%-*s  %-11s  %8s  %8d  %6d", width, result.Stage, status, elapsed, result.Warnings, result.Errors)
+		if result.Message != "" {
+			fmt.Fprintf(out, "  %s", result.Message)
+		}
+		fmt.Fprintln(out)
+		total += result.Duration
+	}
+	fmt.Fprintf(out, "Total time %s\n", total.Round(100*time.Millisecond))
+}
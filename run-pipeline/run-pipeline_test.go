@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/checkpoint"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		text     string
+		expected string // A fragment of the error expected, if any
+	}{
+		{"stages:\n  - name: a\n    run: true\n  - name: b\n    run: echo {cache}\n", ""},
+		{"cache: /tmp/x\n", "no stages"},
+		{"stages:\n  - run: true\n", "stage 1 has no name"},
+		{"stages:\n  - name: a\n    run: true\n  - name: a\n    run: false\n", `more than one stage named "a"`},
+		{"stages:\n  - name: a\n", "stage a has no command"},
+		{"stages:\n  - name: a\n    run: echo 'unterminated\n", "unterminated"},
+	} {
+		filename := filepath.Join(dir, "pipeline.yaml")
+		if err := os.WriteFile(filename, []byte(test.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := ReadConfig(filename)
+		switch {
+		case (test.expected == "") && (err != nil):
+			t.Errorf("ReadConfig(%q) failed: %v", test.text, err)
+		case (test.expected == "") && (config.Cache != DefaultCacheDir):
+			t.Errorf("ReadConfig(%q) gave cache %q", test.text, config.Cache)
+		case (test.expected != "") && ((err == nil) || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("ReadConfig(%q) = %v, expected an error containing %q", test.text, err, test.expected)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stage := Stage{Name: "a", Run: "collect", Inputs: []string{filepath.Join(dir, "*.txt")}}
+	first, err := Fingerprint(stage, "cache")
+	if (err != nil) || (first == "") {
+		t.Fatalf("Fingerprint() = %q, %v", first, err)
+	}
+	if again, _ := Fingerprint(stage, "cache"); again != first {
+		t.Errorf("Fingerprint() of the same stage differs")
+	}
+	if other, _ := Fingerprint(Stage{Name: "a", Run: "collect --all", Inputs: stage.Inputs}, "cache"); other == first {
+		t.Errorf("Fingerprint() does not change with the command")
+	}
+	if err := os.WriteFile(input, []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := Fingerprint(stage, "cache"); changed == first {
+		t.Errorf("Fingerprint() does not change with an input")
+	}
+	if none, _ := Fingerprint(Stage{Name: "a", Run: "collect"}, "cache"); none != "" {
+		t.Errorf("Fingerprint() of a stage without inputs = %q", none)
+	}
+}
+
+// Runs a pipeline of stages that "run" by writing their outputs, recording which were run
+func TestRunPipeline(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data.txt")
+	collected := filepath.Join(dir, "collected.yaml")
+	master := filepath.Join(dir, "master.yaml")
+	if err := os.WriteFile(data, []byte("listing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Cache: filepath.Join(dir, "cache"), Stages: []Stage{
+		{Name: "collect", Run: "collect " + collected + " {events}", Inputs: []string{data}, Outputs: []string{collected}},
+		{Name: "scan", Run: "scan"},
+		{Name: "master", Run: "master " + master, Inputs: []string{collected}, Outputs: []string{master}},
+		{Name: "export", Run: "export", Skip: true},
+	}}
+	if err := os.MkdirAll(filepath.Join(config.Cache, "events"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(runCommand func([]string) (int, error)) { RunCommand = runCommand }(RunCommand)
+	var ran []string
+	exitCodes := make(map[string]int)
+	RunCommand = func(args []string) (int, error) {
+		ran = append(ran, args[0])
+		switch args[0] {
+		case "collect":
+			os.WriteFile(args[1], []byte("catalog"), 0644)
+			os.WriteFile(args[2], []byte(`{"type":"missing-file","severity":"warning","message":"MISSING"}`+"\n"), 0644)
+		case "master":
+			os.WriteFile(args[1], []byte("master"), 0644)
+		}
+		return exitCodes[args[0]], nil
+	}
+	statuses := func(results []Result) []string {
+		var s []string
+		for _, result := range results {
+			s = append(s, result.Status)
+		}
+		return s
+	}
+
+	exitCodes["collect"] = 1
+	stateFilename := filepath.Join(config.Cache, StateFilename)
+	state := make(State)
+	results := RunPipeline(config, state, stateFilename, Options{})
+	if expected := []string{StatusWarnings, StatusRan, StatusRan, StatusSkipped}; !slices.Equal(statuses(results), expected) {
+		t.Errorf("RunPipeline() = %v, expected %v", statuses(results), expected)
+	}
+	if results[0].Warnings != 1 {
+		t.Errorf("RunPipeline() counted %d warnings for collect", results[0].Warnings)
+	}
+
+	// Nothing has changed, so only the stage without inputs is run again (and the state was saved)
+	ran = nil
+	state = make(State)
+	if _, err := checkpoint.LoadState(stateFilename, &state); err != nil {
+		t.Fatal(err)
+	}
+	results = RunPipeline(config, state, stateFilename, Options{})
+	if expected := []string{StatusUpToDate, StatusRan, StatusUpToDate, StatusSkipped}; !slices.Equal(statuses(results), expected) {
+		t.Errorf("RunPipeline() again = %v, expected %v", statuses(results), expected)
+	}
+	if !slices.Equal(ran, []string{"scan"}) {
+		t.Errorf("RunPipeline() again ran %v", ran)
+	}
+
+	// A forced stage runs; a missing output makes a stage run
+	ran = nil
+	os.Remove(master)
+	results = RunPipeline(config, state, "", Options{Force: []string{"collect"}, Skip: []string{"scan"}})
+	if !slices.Equal(ran, []string{"collect", "master"}) {
+		t.Errorf("RunPipeline() with --force collect ran %v (%v)", ran, statuses(results))
+	}
+
+	// A failure stops the later stages, unless keeping going
+	ran = nil
+	exitCodes["scan"] = 3
+	results = RunPipeline(config, state, "", Options{Force: []string{"all"}})
+	if expected := []string{StatusWarnings, StatusFailed, StatusNotRun, StatusNotRun}; !slices.Equal(statuses(results), expected) {
+		t.Errorf("RunPipeline() with a failure = %v, expected %v", statuses(results), expected)
+	}
+	results = RunPipeline(config, state, "", Options{Force: []string{"all"}, KeepGoing: true})
+	if expected := []string{StatusWarnings, StatusFailed, StatusRan, StatusSkipped}; !slices.Equal(statuses(results), expected) {
+		t.Errorf("RunPipeline() with --keep-going = %v, expected %v", statuses(results), expected)
+	}
+
+	// --from skips the earlier stages; --dry-run runs nothing
+	ran = nil
+	results = RunPipeline(config, state, "", Options{From: "master", Force: []string{"all"}, DryRun: true})
+	if expected := []string{StatusSkipped, StatusSkipped, StatusWouldRun, StatusSkipped}; !slices.Equal(statuses(results), expected) || (len(ran) != 0) {
+		t.Errorf("RunPipeline() --from master --dry-run = %v, ran %v", statuses(results), ran)
+	}
+
+	var summary bytes.Buffer
+	WriteSummary(&summary, []Result{{Stage: "collect", Status: StatusWarnings, Warnings: 2}, {Stage: "scan", Status: StatusFailed, ExitCode: 3}})
+	if text := summary.String(); !strings.Contains(text, "failed (3)") || !strings.Contains(text, "Total time") {
+		t.Errorf("WriteSummary() wrote:\n%s", text)
+	}
+}
+
+func TestSummariseRun(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Cache: dir, Catalog: filepath.Join(dir, "master.yaml")}
+	if err := os.MkdirAll(filepath.Join(dir, "events"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "events", "verify.ndjson"), []byte(`{"type":"changed-document","severity":"error","path":"a.pdf","message":"CHANGED"}`+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "events", "old.ndjson"), []byte(`{"type":"missing-file","severity":"warning","message":"MISSING"}`+"\n"), 0644)
+
+	before, err := CatalogKeys(config.Catalog)
+	if (err != nil) || (len(before) != 0) {
+		t.Fatalf("CatalogKeys() of a missing catalog = %v, %v", before, err)
+	}
+	before = map[string]bool{"md5-a": true, "md5-gone": true}
+	os.WriteFile(config.Catalog, []byte("md5-a:\n  title: A\nmd5-b:\n  title: B\nmd5-c:\n  title: C\n"), 0644)
+
+	results := []Result{
+		{Stage: "old", Status: StatusUpToDate},
+		{Stage: "verify", Status: StatusFailed, ExitCode: 3, Errors: 1},
+		{Stage: "export", Status: StatusNotRun},
+	}
+	summary, err := SummariseRun(config, results, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events of a stage that did not run this time are old news
+	if (summary.Warnings != 0) || (summary.Errors != 1) || (len(summary.BitRot) != 1) || (len(summary.Fatal) != 1) {
+		t.Errorf("SummariseRun() = %+v", summary)
+	}
+	if (summary.DocumentsAdded != 2) || (summary.DocumentsRemoved != 1) {
+		t.Errorf("SummariseRun() found %d added and %d removed", summary.DocumentsAdded, summary.DocumentsRemoved)
+	}
+	if !strings.Contains(strings.Join(summary.Details, "\n"), "failed (3)") {
+		t.Errorf("SummariseRun() gave the details %v", summary.Details)
+	}
+}
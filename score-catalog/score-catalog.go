@@ -0,0 +1,192 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/quality"
+	"docs-to-yaml/internal/retention"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+//
+// This program scores how good each copy of a document in a catalog is, from 0 to 100, recording the score in the
+// document's quality, so that the copies of a publication (documents sharing a part number) can be ranked. The score
+// (see internal/quality) comes from the resolution of the page images, the page count against the other copies, the
+// text layer (see ocr-queue), completeness tags such as "needs-rescan", the PDF version and the bytes per page.
+//
+// The page count and resolution of each local PDF are measured by pdfinfo and pdfimages (from poppler-utils) and
+// recorded in pages and dpi. Local documents are found under --archive-root, --volume VOLUME=PATH and --tree-root
+// (see internal/locator), a document in a remote root being fetched to a temporary copy; without any of them nothing is measured and documents are scored on what the catalog already records.
+// PDFs that have been measured already are skipped unless --remeasure is given.
+//
+// With --ranking the copies of every publication held more than once are listed, best first. The score is also used by
+// the "quality" duplicate policy rule and shown by pre-scan and compare-scans.
+//
+// --where restricts the documents scored. The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run score-catalog/score-catalog.go --archive-root /mnt/archive --ranking bin/local.yaml
+//
+
+type Document = document.Document
+
+func main() {
+	locator := locator.Flags()
+	remeasure := flag.Bool("remeasure", false, "measure PDFs again even if their pages and resolution are already recorded")
+	ranking := flag.Bool("ranking", false, "list the copies of each publication held more than once, best first")
+	where := flag.String("where", "", "score only documents selected by this filter expression, e.g. 'collection = local:DEC_0001'")
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	interrupt.Watch()
+
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selected := documents.Where(filter)
+	measured := 0
+	if locator.Configured() {
+		for _, key := range selected.Keys() {
+			doc := documents[key]
+			if !NeedsMeasuring(doc, *remeasure) || interrupt.Requested() {
+				continue
+			}
+			filename, release, err := locator.LocalFile(doc.Filepath)
+			var measurement quality.Measurement
+			if err == nil {
+				measurement, err = quality.MeasurePdf(filename)
+				release()
+			}
+			if err != nil {
+				exitcode.WarningAt("unmeasured", doc.Filepath, "UNMEASURED: %s (%s)\n", doc.Filepath, err)
+				continue
+			}
+			doc.Pages, doc.Dpi = measurement.Pages, measurement.Dpi
+			documents[key] = doc
+			measured += 1
+			if *verbose {
+				fmt.Printf("%5d pages %4d dpi  %s\n", doc.Pages, doc.Dpi, doc.Filepath)
+			}
+		}
+		fmt.Printf("Measured %d PDFs\n", measured)
+	}
+
+	changed := ScoreCatalog(documents, filter)
+	if *verbose {
+		for _, key := range changed {
+			events.Info("scored", documents[key].Filepath, "%3d %s\n", documents[key].Quality, documents[key].Filepath)
+		}
+	}
+	fmt.Printf("Scored %d documents, %d changed\n", len(selected), len(changed))
+	if *ranking {
+		WriteRanking(os.Stdout, documents.Where(filter))
+	}
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	if interrupt.Requested() {
+		interrupt.Exit("some PDFs were not measured; re-run to continue")
+	}
+	exitcode.Exit()
+}
+
+// Reports whether a document is a local PDF to be measured: one that has not been measured yet, or any if remeasure is
+// set. Read-only documents are never changed.
+func NeedsMeasuring(doc Document, remeasure bool) bool {
+	if (doc.Format != "PDF") || !retention.IsLocal(doc) || document.IsReadOnly(doc) {
+		return false
+	}
+	return remeasure || (doc.Pages == 0)
+}
+
+// Scores every document matched by filter (all of them if it is nil) that is not read-only, against the other copies
+// of its publication in the whole catalog. Returns the keys of the documents whose score changed, sorted.
+func ScoreCatalog(documents catalog.Catalog, filter *catalog.Expr) []string {
+	index := documents.IndexByPublication()
+	var changed []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if document.IsReadOnly(doc) || !filter.Matches(key, doc) {
+			continue
+		}
+		copies := []Document{doc}
+		if partNum := document.NormalisePartNumber(doc.PartNum); partNum != "" {
+			copies = nil
+			for _, other := range index[partNum] {
+				copies = append(copies, documents[other])
+			}
+		}
+		score, _ := quality.Score(doc, quality.PeersOf(copies))
+		if score != doc.Quality {
+			doc.Quality = score
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Writes the copies of each publication held more than once, best first, with their scores
+func WriteRanking(out io.Writer, documents catalog.Catalog) {
+	for _, publication := range documents.Publications() {
+		if len(publication.Keys) < 2 {
+			continue
+		}
+		scores := make(map[string]int)
+		sizes := make(map[string]int64)
+		for _, key := range publication.Keys {
+			scores[key], sizes[key] = documents[key].Quality, documents[key].Size
+		}
+		fmt.Fprintf(out, "%s (%d copies)\n", publication.PartNum, len(publication.Keys))
+		for _, key := range quality.Rank(publication.Keys, scores, sizes) {
+			doc := documents[key]
+			fmt.Fprintf(out, "  %3d  %-4s %5d pages %4d dpi %12d bytes  %s\n", doc.Quality, doc.Format, doc.Pages, doc.Dpi, doc.Size, doc.Filepath)
+		}
+	}
+}
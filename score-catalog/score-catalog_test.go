@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/pkg/catalog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNeedsMeasuring(t *testing.T) {
+	for _, test := range []struct {
+		doc       Document
+		remeasure bool
+		expected  bool
+	}{
+		{Document{Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf"}, false, true},
+		{Document{Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf", Pages: 120}, false, false},
+		{Document{Format: "PDF", Filepath: "file:///DEC_0001/ka630.pdf", Pages: 120}, true, true},
+		{Document{Format: "TXT", Filepath: "rsx/notes.txt"}, false, false},
+		{Document{Format: "PDF", Filepath: "http://bitsavers.org/pdf/dec/ka630.pdf"}, false, false},
+		{Document{Format: "PDF", Filepath: "ka630.pdf", Origin: "reference.yaml"}, true, false},
+	} {
+		if needed := NeedsMeasuring(test.doc, test.remeasure); needed != test.expected {
+			t.Errorf("NeedsMeasuring(%+v, %v) = %v", test.doc, test.remeasure, needed)
+		}
+	}
+}
+
+func TestScoreCatalog(t *testing.T) {
+	documents := catalog.Catalog{
+		"good":      {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "file:///DEC_0001/good.pdf", Size: 40 << 20, Pages: 200, Dpi: 600, PdfVersion: "PDF-1.5", OcrStatus: "not-needed"},
+		"short":     {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "file:///DEC_0002/short.pdf", Size: 10 << 20, Pages: 100, Dpi: 300, PdfVersion: "PDF-1.3", OcrStatus: "pending"},
+		"reference": {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "ka630.pdf", Origin: "reference.yaml"},
+		"other":     {Format: "PDF", PartNum: "EK-VAXAR-HB-002", Filepath: "file:///DEC_0001/vaxar.pdf"},
+	}
+
+	changed := ScoreCatalog(documents, nil)
+	if !slices.Equal(changed, []string{"good", "other", "short"}) {
+		t.Errorf("ScoreCatalog() changed %v", changed)
+	}
+	if documents["good"].Quality != 100 {
+		t.Errorf("ScoreCatalog() scored the best copy %d", documents["good"].Quality)
+	}
+	if documents["short"].Quality >= documents["good"].Quality {
+		t.Errorf("ScoreCatalog() scored the short copy %d, not below %d", documents["short"].Quality, documents["good"].Quality)
+	}
+	if documents["reference"].Quality != 0 {
+		t.Errorf("ScoreCatalog() scored a read-only document")
+	}
+
+	// Scoring again changes nothing
+	if changed := ScoreCatalog(documents, nil); len(changed) != 0 {
+		t.Errorf("ScoreCatalog() again changed %v", changed)
+	}
+
+	// Only documents matching the filter are scored
+	filter, err := catalog.ParseExpr("filepath ~ vaxar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := documents["other"]
+	other.Quality = 0
+	documents["other"] = other
+	short := documents["short"]
+	short.Quality = 0
+	documents["short"] = short
+	if changed := ScoreCatalog(documents, filter); !slices.Equal(changed, []string{"other"}) {
+		t.Errorf("ScoreCatalog() with a filter changed %v", changed)
+	}
+}
+
+func TestWriteRanking(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "a.pdf", Quality: 60, Size: 100},
+		"b": {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "b.pdf", Quality: 85, Size: 50},
+		"c": {Format: "PDF", PartNum: "EK-KA630-UG-001", Filepath: "c.pdf", Quality: 60, Size: 200},
+		"d": {Format: "PDF", PartNum: "EK-VAXAR-HB-002", Filepath: "d.pdf", Quality: 70},
+	}
+	var out bytes.Buffer
+	WriteRanking(&out, documents)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if (len(lines) != 4) || !strings.HasPrefix(lines[0], "EKKA630UG001 (3 copies)") {
+		t.Fatalf("WriteRanking() wrote:\n%s", out.String())
+	}
+	for i, name := range []string{"b.pdf", "c.pdf", "a.pdf"} {
+		if !strings.HasSuffix(lines[i+1], name) {
+			t.Errorf("WriteRanking() line %d = %q, expected %s", i+1, lines[i+1], name)
+		}
+	}
+}
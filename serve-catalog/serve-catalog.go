@@ -0,0 +1,989 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/convert"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/pdfmetadata"
+	"docs-to-yaml/internal/prescan"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// This program serves a set of catalogs over HTTP, so that other machines can ask questions of them.
+//
+// The catalogs are named on the command line as NAME=FILEPATH, or simply as FILEPATH (when the name is the filename
+// without its extension, e.g. "bitsavers" for bin/yaml/bitsavers.yaml), or listed in a --config file as for build-master
+// (see internal/sourceconfig). They are read once, when the server starts.
+//
+// /check answers "do I already have this?": given a file, or just its MD5 checksum, it reports whether the document is
+// already known, in which collections, and under which titles. The file (or checksum) can be sent as
+//
+//   GET  /check?md5=MD5
+//   POST /check with a form holding an "md5" field or a "file" upload (multipart/form-data), e.g. curl -F file=@ka630.pdf
+//   POST /check with the file itself as the body, e.g. curl --data-binary @ka630.pdf
+//
+// An uploaded file is hashed as it arrives and is never stored. The answer is JSON (see CheckResult).
+//
+// /prescan answers "is this worth scanning?", as pre-scan does: GET /prescan?part-num=PN&title=FRAGMENT (each may be
+// repeated) lists every known copy of the document, with what is recorded about its quality, and advice on whether a
+// scan looks worthwhile. The answer is JSON (see prescan.Report).
+//
+// /intake is a page for registering a new scan from a browser. Given a file upload (saved in --intake-dir, and no larger
+// than --max-upload MB) or the catalog filepath of a file under --archive-root, --volume or --tree-root, it computes the
+// file's MD5 checksum and size, guesses the title, part number and publication date from its name (and, with --exif,
+// reads its PDF metadata), and shows them in a form, along with any copies that are already known. Once the form is
+// confirmed the file is hashed again and the document appended to the --pending catalog, in the "local-pending"
+// collection. Each form carries a token that must come back with it, so that another site cannot post to it. The
+// intake pages are only served if --pending is given.
+//
+// With --browse the catalogs can also be browsed: /browse is an HTML page listing the documents (GET /browse?q=TEXT
+// lists those whose title or part number contains TEXT), /opds is the same list as an OPDS (Atom) feed for e-book
+// readers, and /document/CATALOG/KEY fetches a document. A document held elsewhere is redirected to; a local one is
+// served from the archive, found under --archive-root, --volume VOLUME=PATH and --tree-root (see internal/locator).
+// Only the documents the --audience may see are listed or served (see internal/visibility).
+//
+// A local document in a format a browser cannot show (TXT, RNO, ...) can be converted on request, as
+// /document/CATALOG/KEY?as=html or ?as=pdf, by a converter given with --convert FROM:TO=COMMAND (see internal/convert),
+// e.g. --convert 'RNO:html=rno2html {input}'. TXT and MEM documents are shown as HTML without one. Converted documents
+// are kept in --conversion-cache, if given, so that each is converted once. Documents are listed in the --collation
+// order (see internal/collate).
+//
+// To run the program:
+//   go run serve-catalog/serve-catalog.go --listen :8080 local=bin/local.yaml bin/yaml/bitsavers.yaml bin/yaml/manx.yaml
+//   go run serve-catalog/serve-catalog.go --pending bin/pending.yaml --intake-dir /mnt/scans/incoming local=bin/local.yaml
+//   go run serve-catalog/serve-catalog.go --browse --archive-root /mnt/archive --convert 'TXT:pdf=text2pdf {input}' local=bin/local.yaml
+//
+
+type Document = document.Document
+
+// Source is a catalog to be served
+type Source = sourceconfig.Source
+
+// Server answers questions about the documents in a set of catalogs
+type Server struct {
+	Sources  []Source
+	catalogs []catalog.Catalog
+	byMd5    []catalog.Index
+	Intake   *Intake // The intake pages are only served if this is set
+	Browse   *Browse // The browsing pages are only served if this is set
+	verbose  bool
+}
+
+// Browse says which documents may be browsed, where local documents are, and how they are converted
+type Browse struct {
+	Audience   string           // Only the documents this audience may see are listed or served (see internal/visibility)
+	Locator    *locator.Locator // Where the files of local documents are
+	Converters convert.Set      // The converters available, in addition to plain text to HTML
+	Cache      convert.Cache
+}
+
+// Intake records newly acquired documents in a pending catalog
+type Intake struct {
+	Pending   string           // The filepath of the catalog that confirmed documents are appended to
+	Dir       string           // The directory that uploaded files are saved in; uploads are refused if this is blank
+	Exif      bool             // Whether to read the PDF metadata of each file
+	Locator   *locator.Locator // Where files named by their catalog filepath are found; such files are refused if nil
+	MaxUpload int64            // The largest request accepted by /intake, in bytes; DefaultMaxUpload if 0
+	mutex     sync.Mutex       // Serialises updates to the pending catalog
+	tokenOnce sync.Once
+	token     string // Sent with each form, and checked when the form is posted
+}
+
+// The largest request accepted by /intake if Intake.MaxUpload is not given, and by /intake/confirm
+const (
+	DefaultMaxUpload = 1 << 30
+	maxConfirmForm   = 1 << 20
+)
+
+// errFormToken is returned when an intake form is posted without the token it was sent with
+var errFormToken = errors.New("the form did not come from this server, or the server has been restarted: please reload it")
+
+// Match is a document found by a query
+type Match struct {
+	Catalog    string   `json:"catalog"`              // The name of the catalog holding the document
+	Key        string   `json:"key"`                  // The document's key in that catalog
+	Collection string   `json:"collection,omitempty"` // The collection that supplied the document
+	Title      string   `json:"title,omitempty"`
+	PartNum    string   `json:"partnum,omitempty"`
+	Filepath   string   `json:"filepath,omitempty"`
+	Locations  []string `json:"locations,omitempty"` // Every place a copy of the file is held (see document.FileLocation)
+}
+
+// CheckResult is the answer to /check
+type CheckResult struct {
+	Md5         string   `json:"md5"`
+	Size        int64    `json:"size,omitempty"` // The size of the file uploaded, if any
+	Known       bool     `json:"known"`
+	Collections []string `json:"collections,omitempty"` // The collections (or, failing that, catalogs) holding the document
+	Titles      []string `json:"titles,omitempty"`      // The distinct titles it is known by
+	Matches     []Match  `json:"matches,omitempty"`
+}
+
+func main() {
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the catalogs to serve (name and path), as for build-master")
+	listen := flag.String("listen", "localhost:8080", "the address (HOST:PORT, or :PORT for every interface) to listen on")
+	verbose := console.Flags("log each request")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	pending := flag.String("pending", "", "filepath of the catalog that documents registered through /intake are appended to")
+	intakeDir := flag.String("intake-dir", "", "the directory that files uploaded to /intake are saved in")
+	exifRead := flag.Bool("exif", false, "read the PDF metadata of files registered through /intake")
+	maxUploadMB := flag.Int64("max-upload", DefaultMaxUpload>>20, "the largest file, in MB, that may be uploaded to /intake")
+	browse := flag.Bool("browse", false, "serve the /browse, /opds and /document pages")
+	audience := flag.String("audience", visibility.Public, "with --browse, list and serve only the documents this audience may see: public, restricted or private")
+	locator := locator.Flags()
+	var converters convert.Set
+	flag.Func("convert", "a converter for /document?as=html|pdf, as FROM:TO=COMMAND with {input} for the file (may be repeated)", func(s string) error {
+		converter, err := convert.Parse(s)
+		converters = append(converters, converter)
+		return err
+	})
+	conversionCache := flag.String("conversion-cache", "", "the directory that converted documents are kept in")
+	collate.Flag()
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if (*intakeDir != "") && (*pending == "") {
+		exitcode.UsageError("--intake-dir needs --pending")
+	}
+	if !visibility.IsLevel(*audience) {
+		exitcode.UsageErrorf("--audience must be one of %s, found %q", strings.Join(visibility.Levels, ", "), *audience)
+	}
+	sources, err := sourceconfig.Gather(*configFilename, flag.Args())
+	if err != nil {
+		exitcode.UsageErrorf("--config: %s", err)
+	}
+	if len(sources) == 0 {
+		exitcode.UsageError("Please supply at least one catalog, with --config or on the command line")
+	}
+
+	server, err := NewServer(sources)
+	if err != nil {
+		exitcode.Fatal(err)
+	}
+	server.verbose = *verbose
+	if *pending != "" {
+		server.Intake = &Intake{Pending: *pending, Dir: *intakeDir, Exif: *exifRead, Locator: locator, MaxUpload: *maxUploadMB << 20}
+	}
+	if *browse {
+		server.Browse = &Browse{Audience: *audience, Locator: locator, Converters: converters, Cache: convert.Cache{Dir: *conversionCache}}
+	}
+	documents := 0
+	for _, documentsInCatalog := range server.catalogs {
+		documents += len(documentsInCatalog)
+	}
+	fmt.Printf("Serving %d documents from %d catalogs on %s\n", documents, len(sources), *listen)
+	if err := http.ListenAndServe(*listen, server.Handler()); err != nil {
+		exitcode.Fatal(err)
+	}
+
+	exitcode.Exit()
+}
+
+// Reads the catalogs to be served
+func NewServer(sources []Source) (*Server, error) {
+	server := &Server{Sources: sources}
+	for _, source := range sources {
+		documents, err := catalog.Load(source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", source.Path, err)
+		}
+		server.catalogs = append(server.catalogs, documents)
+		server.byMd5 = append(server.byMd5, documents.IndexByMd5())
+	}
+	return server, nil
+}
+
+// Returns the handler for every endpoint
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/check", s.handleCheck)
+	mux.HandleFunc("/prescan", s.handlePrescan)
+	if s.Intake != nil {
+		mux.HandleFunc("/intake", s.handleIntake)
+		mux.HandleFunc("/intake/confirm", s.handleIntakeConfirm)
+	}
+	if s.Browse != nil {
+		mux.HandleFunc("/browse", s.handleBrowse)
+		mux.HandleFunc("/opds", s.handleOpds)
+		mux.HandleFunc("/document/{catalog}/{key...}", s.handleDocument)
+	}
+	return mux
+}
+
+// Reports what is known of the document with an MD5 checksum
+func (s *Server) Check(md5 string) CheckResult {
+	result := CheckResult{Md5: md5}
+	for i, source := range s.Sources {
+		for _, key := range s.byMd5[i][md5] {
+			doc := s.catalogs[i][key]
+			match := Match{Catalog: source.Name, Key: key, Collection: doc.Collection, Title: doc.Title, PartNum: doc.PartNum, Filepath: doc.Filepath}
+			document.NormaliseLocations(&doc)
+			for _, location := range doc.Copies {
+				match.Locations = append(match.Locations, location.String())
+			}
+			result.Matches = append(result.Matches, match)
+
+			collection := doc.Collection
+			if collection == "" {
+				collection = source.Name
+			}
+			if !slices.Contains(result.Collections, collection) {
+				result.Collections = append(result.Collections, collection)
+			}
+			if (doc.Title != "") && !slices.Contains(result.Titles, doc.Title) {
+				result.Titles = append(result.Titles, doc.Title)
+			}
+		}
+	}
+	result.Known = len(result.Matches) > 0
+	return result
+}
+
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	var md5 string
+	var size int64
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		md5 = r.URL.Query().Get("md5")
+	case http.MethodPost:
+		md5, size, err = checkedMd5(r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	normalised := NormaliseMd5(md5)
+	if normalised == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expected an MD5 checksum (32 hex digits) or a file, found %q", md5))
+		return
+	}
+
+	result := s.Check(normalised)
+	result.Size = size
+	if s.verbose {
+		fmt.Printf("%s /check %s: %d matches\n", r.RemoteAddr, normalised, len(result.Matches))
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+// Reports every known copy of a document, and whether a scan looks worthwhile (see internal/prescan)
+func (s *Server) handlePrescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	query := catalog.Query{PartNums: r.URL.Query()["part-num"], Titles: r.URL.Query()["title"]}
+	query.PartNums = slices.DeleteFunc(query.PartNums, func(s string) bool { return strings.TrimSpace(s) == "" })
+	query.Titles = slices.DeleteFunc(query.Titles, func(s string) bool { return strings.TrimSpace(s) == "" })
+	if query.Empty() {
+		writeError(w, http.StatusBadRequest, "expected part-num or title")
+		return
+	}
+	var catalogs []prescan.Catalog
+	for i, source := range s.Sources {
+		catalogs = append(catalogs, prescan.Catalog{Name: source.Name, Documents: s.catalogs[i]})
+	}
+	report := prescan.Find(catalogs, query)
+	if s.verbose {
+		fmt.Printf("%s /prescan %s: %d copies\n", r.RemoteAddr, strings.Join(append(query.PartNums, query.Titles...), ", "), len(report.Copies))
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+// Returns the MD5 checksum sent in a POST to /check: an "md5" form field, the MD5 checksum of a "file" upload, or
+// that of the body itself. The size of a file sent is also returned.
+func checkedMd5(r *http.Request) (string, int64, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return r.FormValue("md5"), 0, nil
+	case "multipart/form-data":
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return "", 0, err
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", 0, errors.New("no md5 field or file in the form")
+			}
+			if err != nil {
+				return "", 0, err
+			}
+			switch part.FormName() {
+			case "md5":
+				value, err := io.ReadAll(io.LimitReader(part, 1024))
+				return string(value), 0, err
+			case "file":
+				digests, err := hashing.HashReader(part, hashing.MD5)
+				return digests.Md5, digests.Size, err
+			}
+		}
+	}
+	digests, err := hashing.HashReader(r.Body, hashing.MD5)
+	return digests.Md5, digests.Size, err
+}
+
+var md5Pattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Returns an MD5 checksum in lower case, or "" if it is not one
+func NormaliseMd5(md5 string) string {
+	md5 = strings.ToLower(strings.TrimSpace(md5))
+	if !md5Pattern.MatchString(md5) {
+		return ""
+	}
+	return md5
+}
+
+// intakePage is what the intake page shows
+type intakePage struct {
+	Pending string      // The pending catalog
+	Doc     Document    // The document to be confirmed; blank (no Md5) to ask for a file
+	Known   CheckResult // What is already known of the document
+	Added   string      // The key of the document just added, if any
+	Token   string      // The token the form must be posted with
+	Error   string
+}
+
+var intakeTemplate = template.Must(template.New("intake").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Register a document</title></head>
+<body>
+<h1>Register a document</h1>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Added}}<p>Added {{.Added}} to {{.Pending}}.</p>{{end}}
+{{if .Doc.Md5}}
+{{if .Known.Known}}<p>Already known:</p>
+<ul>
+{{range .Known.Matches}}<li>{{.Catalog}}: {{.PartNum}} {{.Title}} ({{.Filepath}})</li>
+{{end}}</ul>
+{{end}}
+<form method="post" action="/intake/confirm">
+<input type="hidden" name="token" value="{{.Token}}">
+<table>
+<tr><td>File</td><td>{{.Doc.Filepath}} ({{.Doc.Format}}, {{.Doc.Size}} bytes, MD5 {{.Doc.Md5}})</td></tr>
+<tr><td><label for="title">Title</label></td><td><input id="title" name="title" value="{{.Doc.Title}}" size="80"></td></tr>
+<tr><td><label for="partnum">Part number</label></td><td><input id="partnum" name="partnum" value="{{.Doc.PartNum}}"></td></tr>
+<tr><td><label for="pubdate">Publication date</label></td><td><input id="pubdate" name="pubdate" value="{{.Doc.PubDate}}"></td></tr>
+</table>
+<input type="hidden" name="filepath" value="{{.Doc.Filepath}}">
+<input type="hidden" name="format" value="{{.Doc.Format}}">
+<input type="hidden" name="pdfcreator" value="{{.Doc.PdfCreator}}">
+<input type="hidden" name="pdfproducer" value="{{.Doc.PdfProducer}}">
+<input type="hidden" name="pdfversion" value="{{.Doc.PdfVersion}}">
+<input type="hidden" name="pdfmodified" value="{{.Doc.PdfModified}}">
+<p><input type="submit" value="Add to {{.Pending}}"></p>
+</form>
+{{else}}
+<form method="post" action="/intake" enctype="multipart/form-data">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>Upload a file: <input type="file" name="file"></label></p>
+<p><label>or give its catalog filepath (file:///VOLUME/path, or a path under the tree root): <input name="path" size="80"></label></p>
+<p><input type="submit" value="Examine"></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// Shows the intake form (GET), or examines a file uploaded or named in it (POST) and shows the document to be confirmed
+func (s *Server) handleIntake(w http.ResponseWriter, r *http.Request) {
+	page := intakePage{Pending: s.Intake.Pending}
+	switch r.Method {
+	case http.MethodGet:
+		s.writeIntakePage(w, http.StatusOK, page)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.Intake.maxUpload())
+	filename, digests, err := s.Intake.receive(r)
+	if err != nil {
+		page.Error = err.Error()
+		s.writeIntakePage(w, intakeErrorStatus(err), page)
+		return
+	}
+	page.Doc = s.Intake.Describe(filename, digests)
+	page.Known = s.Check(page.Doc.Md5)
+	if s.verbose {
+		fmt.Printf("%s /intake %s: %s\n", r.RemoteAddr, filename, page.Doc.Md5)
+	}
+	s.writeIntakePage(w, http.StatusOK, page)
+}
+
+// Appends the document confirmed in the intake form to the pending catalog
+func (s *Server) handleIntakeConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	page := intakePage{Pending: s.Intake.Pending}
+	r.Body = http.MaxBytesReader(w, r.Body, maxConfirmForm)
+	err := r.ParseForm()
+	if err == nil {
+		err = s.Intake.checkToken(r.PostFormValue("token"))
+	}
+	doc, formErr := confirmedDocument(r)
+	if err == nil {
+		err = formErr
+	}
+	if err == nil {
+		// The checksum and size are those of the file as it is now, whatever the form says
+		var digests hashing.Digests
+		if digests, err = s.Intake.hash(doc.Filepath); err == nil {
+			doc.Md5, doc.Size = digests.Md5, digests.Size
+			page.Added, err = s.Intake.Append(doc)
+		}
+	}
+	if err != nil {
+		page.Doc = doc
+		page.Known = s.Check(doc.Md5)
+		page.Error = err.Error()
+		s.writeIntakePage(w, intakeErrorStatus(err), page)
+		return
+	}
+	if s.verbose {
+		fmt.Printf("%s /intake/confirm: added %s to %s\n", r.RemoteAddr, page.Added, s.Intake.Pending)
+	}
+	s.writeIntakePage(w, http.StatusOK, page)
+}
+
+func (s *Server) writeIntakePage(w http.ResponseWriter, status int, page intakePage) {
+	page.Token = s.Intake.formToken()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := intakeTemplate.Execute(w, page); err != nil {
+		exitcode.Warning("cannot write the intake page: %s\n", err)
+	}
+}
+
+// Returns the HTTP status for an error in an intake form
+func intakeErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.Is(err, errFormToken) {
+		return http.StatusForbidden
+	} else if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// Returns the largest request accepted by /intake
+func (intake *Intake) maxUpload() int64 {
+	if intake.MaxUpload > 0 {
+		return intake.MaxUpload
+	}
+	return DefaultMaxUpload
+}
+
+// Returns the token that the intake forms are sent with. It is chosen at random when first needed, and lasts as long
+// as the server does: a page on another site cannot read it, so cannot post a form that carries it.
+func (intake *Intake) formToken() string {
+	intake.tokenOnce.Do(func() {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			exitcode.Fatalf("Cannot choose the intake form token: %s", err)
+		}
+		intake.token = hex.EncodeToString(random)
+	})
+	return intake.token
+}
+
+// Checks the token an intake form was posted with
+func (intake *Intake) checkToken(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(intake.formToken())) != 1 {
+		return errFormToken
+	}
+	return nil
+}
+
+// Returns the filepath of the file uploaded to (and saved in Dir), or named in, the intake form, along with its digests.
+// The form's token must come before the file or its path.
+func (intake *Intake) receive(r *http.Request) (string, hashing.Digests, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", hashing.Digests{}, err
+	}
+	tokenErr := errFormToken
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", hashing.Digests{}, errors.New("please upload a file or give its path")
+		}
+		if err != nil {
+			return "", hashing.Digests{}, err
+		}
+		switch part.FormName() {
+		case "token":
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				return "", hashing.Digests{}, err
+			}
+			tokenErr = intake.checkToken(string(value))
+		case "path":
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if path := strings.TrimSpace(string(value)); (err == nil) && (path != "") {
+				if tokenErr != nil {
+					return "", hashing.Digests{}, tokenErr
+				}
+				digests, err := intake.hash(path)
+				return path, digests, err
+			}
+		case "file":
+			if part.FileName() != "" {
+				if tokenErr != nil {
+					return "", hashing.Digests{}, tokenErr
+				}
+				return intake.save(part, part.FileName())
+			}
+		}
+	}
+}
+
+// Returns the root holding a file given in an intake form, and the file's name within it: a file uploaded to Dir, or a
+// local document found by the locator from its catalog filepath. Any other file is refused, so that the form cannot be
+// used to read files elsewhere on the server.
+func (intake *Intake) locate(filename string) (fs.FS, string, error) {
+	if relative, found := intake.uploaded(filename); found {
+		return os.DirFS(intake.Dir), relative, nil
+	}
+	if (intake.Locator == nil) || !intake.Locator.Configured() {
+		return nil, "", fmt.Errorf("%s is not in the intake directory, and no archive or tree root was given", filename)
+	}
+	fsys, name, err := intake.Locator.Locate(filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", filename, err)
+	}
+	return fsys, name, nil
+}
+
+// Returns the "/"-separated name within Dir of a file uploaded to it, if filename is one
+func (intake *Intake) uploaded(filename string) (string, bool) {
+	if intake.Dir == "" {
+		return "", false
+	}
+	relative, err := filepath.Rel(intake.Dir, filename)
+	if (err != nil) || !filepath.IsLocal(relative) {
+		return "", false
+	}
+	return filepath.ToSlash(relative), true
+}
+
+// Returns the digests of a file given in an intake form (see locate)
+func (intake *Intake) hash(filename string) (hashing.Digests, error) {
+	fsys, name, err := intake.locate(filename)
+	if err != nil {
+		return hashing.Digests{}, err
+	}
+	return hashing.HashFS(fsys, name, hashing.MD5)
+}
+
+// Saves an uploaded file in Dir, under its own name unless a file of that name is already there, hashing it as it is saved
+func (intake *Intake) save(r io.Reader, name string) (string, hashing.Digests, error) {
+	if intake.Dir == "" {
+		return "", hashing.Digests{}, errors.New("uploads are not accepted: give the path of the file on the server")
+	}
+	name = filepath.Base(filepath.Clean("/" + filepath.FromSlash(name)))
+	if (name == "") || (name == string(filepath.Separator)) {
+		return "", hashing.Digests{}, errors.New("the uploaded file has no name")
+	}
+	if err := os.MkdirAll(intake.Dir, 0755); err != nil {
+		return "", hashing.Digests{}, err
+	}
+	extension := filepath.Ext(name)
+	filename := filepath.Join(intake.Dir, name)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for i := 2; os.IsExist(err); i++ {
+		filename = filepath.Join(intake.Dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), i, extension))
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return "", hashing.Digests{}, err
+	}
+	digests, err := hashing.HashReader(io.TeeReader(r, file), hashing.MD5)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return filename, digests, err
+}
+
+// Returns the document for a file, as file-tree-to-yaml would describe it: with the title, part number and publication
+// date guessed from its name, and its PDF metadata if Exif is set
+func (intake *Intake) Describe(filename string, digests hashing.Digests) Document {
+	data := document.DetermineDocumentPropertiesFromPath(filename, false)
+	doc := Document{Format: data.Format, Size: digests.Size, Md5: digests.Md5, Title: data.Title, PubDate: data.PubDate, PartNum: data.PartNum, AltPartNums: data.AltPartNums}
+	doc.Collection = "local-pending"
+	doc.Filepath = filename
+	document.SetFlags(&doc, "PTD")
+	if intake.Exif {
+		if _, found := intake.uploaded(filename); found {
+			pdfmetadata.Apply(&doc, pdfmetadata.ExtractPdfMetadata(filename))
+		} else if localPath, release, err := intake.Locator.LocalFile(filename); err == nil {
+			pdfmetadata.Apply(&doc, pdfmetadata.ExtractPdfMetadata(localPath))
+			release()
+		}
+	}
+	return doc
+}
+
+// Returns the document confirmed in the intake form. The title, part number and date were checked by a person, so the
+// document has no flags. Its checksum and size are left for the caller to compute.
+func confirmedDocument(r *http.Request) (Document, error) {
+	value := func(name string) string { return strings.TrimSpace(r.PostFormValue(name)) }
+	doc := Document{Format: value("format"), Title: value("title"), PubDate: value("pubdate"), PartNum: value("partnum")}
+	doc.PdfCreator = value("pdfcreator")
+	doc.PdfProducer = value("pdfproducer")
+	doc.PdfVersion = value("pdfversion")
+	doc.PdfModified = value("pdfmodified")
+	doc.Collection = "local-pending"
+	doc.Filepath = value("filepath")
+	if doc.Filepath == "" {
+		return doc, errors.New("no file given")
+	}
+	return doc, nil
+}
+
+// Appends a document to the pending catalog (which is created if need be), returning its key.
+// A document that is already in the pending catalog is not added again.
+func (intake *Intake) Append(doc Document) (string, error) {
+	intake.mutex.Lock()
+	defer intake.mutex.Unlock()
+	documents, err := catalog.LoadIfExists(intake.Pending)
+	if err != nil {
+		return "", err
+	}
+	key := document.BuildKeyFromDocument(doc)
+	if _, found := documents[key]; found {
+		return "", fmt.Errorf("%s is already in %s", key, intake.Pending)
+	}
+	documents[key] = doc
+	return key, catalog.Save(intake.Pending, documents)
+}
+
+// The number of documents on each page of /browse and /opds
+const PageSize = 100
+
+// Entry is a document listed by /browse and /opds
+type Entry struct {
+	Catalog string // The name of the catalog holding the document
+	Key     string // The document's key in that catalog
+	Doc     Document
+	Local   bool     // Whether the document is served from the archive (rather than redirected to)
+	Targets []string // The formats the document can be converted to
+}
+
+// Returns the path that fetches the document, converted to a format if one is given
+func (entry Entry) Link(as string) string {
+	link := "/document/" + url.PathEscape(entry.Catalog) + "/" + url.PathEscape(entry.Key)
+	if as != "" {
+		link += "?as=" + url.QueryEscape(as)
+	}
+	return link
+}
+
+// Returns the documents that the audience may see whose title or part number contains some text (ignoring case), by
+// catalog and, within a catalog, in the catalog order (see document.OrderedKeys)
+func (s *Server) Entries(text string) []Entry {
+	text = strings.ToLower(strings.TrimSpace(text))
+	var entries []Entry
+	for i, source := range s.Sources {
+		documents := s.catalogs[i].Visible(s.Browse.Audience)
+		for _, key := range document.OrderedKeys(documents) {
+			doc := documents[key]
+			if (text != "") && !strings.Contains(strings.ToLower(doc.Title), text) && !strings.Contains(strings.ToLower(doc.PartNum), text) {
+				continue
+			}
+			entry := Entry{Catalog: source.Name, Key: key, Doc: doc}
+			if _, _, err := s.Browse.Locator.Locate(doc.Filepath); err == nil {
+				entry.Local = true
+				entry.Targets = s.Browse.Converters.TargetsOf(doc.Format)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Returns the entries on a page (counting from 1) and the number of pages
+func paged(entries []Entry, page int) ([]Entry, int) {
+	pages := max(1, (len(entries)+PageSize-1)/PageSize)
+	page = min(max(page, 1), pages)
+	return entries[min((page-1)*PageSize, len(entries)):min(page*PageSize, len(entries))], pages
+}
+
+// Returns the page asked for by a request (1 if it is not given or not a number)
+func requestedPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		return 1
+	}
+	return page
+}
+
+// browsePage is what the browsing page shows
+type browsePage struct {
+	Query   string
+	Entries []Entry
+	Found   int // The number of documents found, on every page
+	Page    int
+	Pages   int
+}
+
+var browseTemplate = template.Must(template.New("browse").Funcs(template.FuncMap{
+	"previous": func(page int) int { return page - 1 },
+	"next":     func(page int) int { return page + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Documents</title><link rel="alternate" type="application/atom+xml;profile=opds-catalog" href="/opds"></head>
+<body>
+<h1>Documents</h1>
+<form method="get" action="/browse"><p><input name="q" value="{{.Query}}" size="60"> <input type="submit" value="Search"></p></form>
+<p>{{.Found}} documents{{if gt .Pages 1}}, page {{.Page}} of {{.Pages}}{{end}}</p>
+<table>
+<tr><th>Part number</th><th>Title</th><th>Date</th><th>Format</th><th>Catalog</th></tr>
+{{range .Entries}}<tr><td>{{.Doc.PartNum}}</td><td><a href="{{.Link ""}}">{{or .Doc.Title .Key}}</a>{{$entry := .}}{{range .Targets}} [<a href="{{$entry.Link .}}">{{.}}</a>]{{end}}</td><td>{{.Doc.PubDate}}</td><td>{{.Doc.Format}}</td><td>{{.Catalog}}</td></tr>
+{{end}}</table>
+<p>{{if gt .Page 1}}<a href="/browse?q={{.Query}}&amp;page={{.Page | previous}}">Previous</a> {{end}}{{if lt .Page .Pages}}<a href="/browse?q={{.Query}}&amp;page={{.Page | next}}">Next</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// Lists the documents that may be browsed, with links to fetch (or convert) each of them
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	page := browsePage{Query: r.URL.Query().Get("q"), Page: requestedPage(r)}
+	entries := s.Entries(page.Query)
+	page.Found = len(entries)
+	page.Entries, page.Pages = paged(entries, page.Page)
+	page.Page = min(max(page.Page, 1), page.Pages)
+	if s.verbose {
+		fmt.Printf("%s /browse %q: %d documents\n", r.RemoteAddr, page.Query, page.Found)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browseTemplate.Execute(w, page); err != nil {
+		exitcode.Warning("cannot write the browsing page: %s\n", err)
+	}
+}
+
+// The OPDS (Atom) feed served by /opds
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []opdsLink  `xml:"link"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	Id         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Identifier string     `xml:"http://purl.org/dc/terms/ identifier,omitempty"` // The part number
+	Issued     string     `xml:"http://purl.org/dc/terms/ issued,omitempty"`     // The publication date
+	Links      []opdsLink `xml:"link"`
+}
+
+type opdsLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+const opdsAcquisition = "http://opds-spec.org/acquisition"
+
+// Returns the content type of a document format, e.g. "application/pdf" for "PDF"
+func formatType(format string) string {
+	if contentType := mime.TypeByExtension("." + strings.ToLower(format)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// Lists the documents that may be browsed as an OPDS acquisition feed, with a link to fetch each document in its own
+// format and in each format it can be converted to
+func (s *Server) handleOpds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	query, page := r.URL.Query().Get("q"), requestedPage(r)
+	entries, pages := paged(s.Entries(query), page)
+	page = min(max(page, 1), pages)
+	updated := time.Now().UTC().Format(time.RFC3339)
+	link := func(page int) string {
+		return "/opds?" + url.Values{"q": {query}, "page": {strconv.Itoa(page)}}.Encode()
+	}
+
+	feed := opdsFeed{Id: "urn:docs-to-yaml:opds", Title: "Documents", Updated: updated}
+	feed.Links = append(feed.Links, opdsLink{Rel: "self", Href: link(page), Type: "application/atom+xml;profile=opds-catalog;kind=acquisition"})
+	feed.Links = append(feed.Links, opdsLink{Rel: "start", Href: "/opds", Type: "application/atom+xml;profile=opds-catalog;kind=acquisition"})
+	if page < pages {
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: link(page + 1), Type: "application/atom+xml;profile=opds-catalog;kind=acquisition"})
+	}
+	for _, entry := range entries {
+		item := opdsEntry{Id: "urn:docs-to-yaml:" + entry.Catalog + ":" + entry.Key, Title: entry.Doc.Title, Updated: updated, Identifier: entry.Doc.PartNum, Issued: entry.Doc.PubDate}
+		if item.Title == "" {
+			item.Title = entry.Key
+		}
+		item.Links = append(item.Links, opdsLink{Rel: opdsAcquisition, Href: entry.Link(""), Type: formatType(entry.Doc.Format)})
+		for _, target := range entry.Targets {
+			item.Links = append(item.Links, opdsLink{Rel: opdsAcquisition, Href: entry.Link(target), Type: convert.Targets[target]})
+		}
+		feed.Entries = append(feed.Entries, item)
+	}
+	if s.verbose {
+		fmt.Printf("%s /opds %q: page %d of %d\n", r.RemoteAddr, query, page, pages)
+	}
+	w.Header().Set("Content-Type", "application/atom+xml;profile=opds-catalog;kind=acquisition")
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		exitcode.Warning("cannot write the OPDS feed: %s\n", err)
+	}
+}
+
+// Serves a document: a local document from the archive (converted if ?as= is given), and any other by redirecting to
+// where it is held. A document the audience may not see is not found.
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	if (r.Method != http.MethodGet) && (r.Method != http.MethodHead) {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	name, key, as := r.PathValue("catalog"), r.PathValue("key"), strings.ToLower(r.URL.Query().Get("as"))
+	i := slices.IndexFunc(s.Sources, func(source Source) bool { return source.Name == name })
+	var doc Document
+	found := false
+	if i >= 0 {
+		doc, found = s.catalogs[i][key]
+	}
+	if !found || !visibility.Allowed(doc, s.Browse.Audience) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no document %s in %s", key, name))
+		return
+	}
+
+	fsys, filename, err := s.Browse.Locator.Locate(doc.Filepath)
+	if err != nil {
+		remote := doc.PublicUrl
+		if strings.HasPrefix(doc.Filepath, "http://") || strings.HasPrefix(doc.Filepath, "https://") {
+			remote = doc.Filepath
+		}
+		if (remote == "") || (as != "") {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s is not held locally: %s", key, err))
+			return
+		}
+		http.Redirect(w, r, remote, http.StatusFound)
+		return
+	}
+
+	if as == "" {
+		file, err := fsys.Open(filename)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", formatType(doc.Format))
+		if seeker, ok := file.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, path.Base(filename), info.ModTime(), seeker)
+		} else {
+			// A file in a remote root can only be read through, so ranges cannot be served
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			io.Copy(w, file)
+		}
+		return
+	}
+	converter, found := s.Browse.Converters.Find(doc.Format, as)
+	if !found {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("cannot convert %s to %q", doc.Format, as))
+		return
+	}
+	localPath, release, err := archivefs.LocalFile(fsys, filename)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	data, err := s.Browse.Cache.Convert(converter, localPath, doc.Md5)
+	release()
+	if err != nil {
+		exitcode.WarningAt("convert", doc.Filepath, "cannot convert %s to %s: %s\n", doc.Filepath, as, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.verbose {
+		fmt.Printf("%s /document %s/%s as %s\n", r.RemoteAddr, name, key, as)
+	}
+	w.Header().Set("Content-Type", convert.Targets[as])
+	w.Write(data)
+}
+
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
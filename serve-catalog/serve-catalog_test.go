@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/prescan"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testServer(t *testing.T, content []byte) *Server {
+	dir := t.TempDir()
+	sum := md5.Sum(content)
+	known := hex.EncodeToString(sum[:])
+	local := catalog.Catalog{known: {Md5: known, Title: "KA630 Technical Manual", Collection: "local", Filepath: "file:///DEC_0001/ka630.pdf"}}
+	bitsavers := catalog.Catalog{known: {Md5: known, Title: "KA630 CPU Module Technical Manual", Collection: "bitsavers", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"}}
+	for name, documents := range map[string]catalog.Catalog{"local.yaml": local, "bitsavers.yaml": bitsavers} {
+		if err := catalog.Save(filepath.Join(dir, name), documents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := NewServer([]Source{sourceconfig.Parse("mine=" + filepath.Join(dir, "local.yaml")), sourceconfig.Parse(filepath.Join(dir, "bitsavers.yaml"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestCheck(t *testing.T) {
+	content := []byte("%PDF-1.4 KA630")
+	server := testServer(t, content)
+	sum := md5.Sum(content)
+	known := hex.EncodeToString(sum[:])
+
+	var upload bytes.Buffer
+	writer := multipart.NewWriter(&upload)
+	part, _ := writer.CreateFormFile("file", "ka630.pdf")
+	part.Write(content)
+	writer.Close()
+
+	for _, test := range []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        func() *bytes.Buffer
+		status      int
+		known       bool
+		size        int64
+	}{
+		{"GET", http.MethodGet, "/check?md5=" + strings.ToUpper(known), "", nil, http.StatusOK, true, 0},
+		{"GET unknown", http.MethodGet, "/check?md5=0123456789abcdef0123456789abcdef", "", nil, http.StatusOK, false, 0},
+		{"GET bad", http.MethodGet, "/check?md5=xyz", "", nil, http.StatusBadRequest, false, 0},
+		{"form", http.MethodPost, "/check", "application/x-www-form-urlencoded", func() *bytes.Buffer {
+			return bytes.NewBufferString(url.Values{"md5": {known}}.Encode())
+		}, http.StatusOK, true, 0},
+		{"body", http.MethodPost, "/check", "application/pdf", func() *bytes.Buffer { return bytes.NewBuffer(content) }, http.StatusOK, true, int64(len(content))},
+		{"upload", http.MethodPost, "/check", writer.FormDataContentType(), func() *bytes.Buffer { return &upload }, http.StatusOK, true, int64(len(content))},
+		{"PUT", http.MethodPut, "/check", "", nil, http.StatusMethodNotAllowed, false, 0},
+	} {
+		body := &bytes.Buffer{}
+		if test.body != nil {
+			body = test.body()
+		}
+		request := httptest.NewRequest(test.method, test.target, body)
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, expected %d: %s", test.name, recorder.Code, test.status, recorder.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var result CheckResult
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if (result.Known != test.known) || (result.Size != test.size) {
+			t.Errorf("%s: got %+v", test.name, result)
+		}
+	}
+
+	result := server.Check(known)
+	if !reflect.DeepEqual(result.Collections, []string{"local", "bitsavers"}) {
+		t.Errorf("Check() gave collections %v", result.Collections)
+	}
+	if !reflect.DeepEqual(result.Titles, []string{"KA630 Technical Manual", "KA630 CPU Module Technical Manual"}) {
+		t.Errorf("Check() gave titles %v", result.Titles)
+	}
+	if (result.Matches[0].Catalog != "mine") || !reflect.DeepEqual(result.Matches[0].Locations, []string{"file:///DEC_0001/ka630.pdf"}) {
+		t.Errorf("Check() gave %+v", result.Matches[0])
+	}
+}
+
+func TestIntake(t *testing.T) {
+	content := []byte("%PDF-1.4 new scan")
+	server := testServer(t, []byte("%PDF-1.4 KA630"))
+	dir := t.TempDir()
+	treeRoot := t.TempDir()
+	os.WriteFile(filepath.Join(treeRoot, "ka630.pdf"), content, 0644)
+	server.Intake = &Intake{Pending: filepath.Join(dir, "pending.yaml"), Dir: filepath.Join(dir, "incoming"), Locator: &locator.Locator{TreeRoot: treeRoot}}
+	handler := server.Handler()
+	token := server.Intake.formToken()
+	sum := md5.Sum(content)
+	expectedMd5 := hex.EncodeToString(sum[:])
+
+	post := func(target string, contentType string, body *bytes.Buffer) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, target, body)
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	upload := func(field string, name string, value []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("token", token)
+		if field == "file" {
+			part, _ := writer.CreateFormFile(field, name)
+			part.Write(value)
+		} else {
+			writer.WriteField(field, string(value))
+		}
+		writer.Close()
+		return post("/intake", writer.FormDataContentType(), &body)
+	}
+
+	recorder := upload("file", "../EK-KA630-TM-001_KA630_Technical_Manual_Jan87.pdf", content)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	saved := filepath.Join(dir, "incoming", "EK-KA630-TM-001_KA630_Technical_Manual_Jan87.pdf")
+	if data, err := os.ReadFile(saved); (err != nil) || !bytes.Equal(data, content) {
+		t.Errorf("upload was not saved as %s: %v", saved, err)
+	}
+	for _, expected := range []string{`value="KA630 Technical Manual"`, `value="EK-KA630-TM-001"`, `value="1987-01"`, expectedMd5, `value="` + token + `"`} {
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("intake form does not contain %s", expected)
+		}
+	}
+
+	// A second upload of the same name is saved alongside the first; the path of a file on the server is also accepted
+	if upload("file", "EK-KA630-TM-001_KA630_Technical_Manual_Jan87.pdf", content).Code != http.StatusOK {
+		t.Errorf("second upload failed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "incoming", "EK-KA630-TM-001_KA630_Technical_Manual_Jan87-2.pdf")); err != nil {
+		t.Errorf("second upload was not saved: %v", err)
+	}
+	if recorder := upload("path", "", []byte(saved)); (recorder.Code != http.StatusOK) || !strings.Contains(recorder.Body.String(), expectedMd5) {
+		t.Errorf("path: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := upload("path", "", []byte("ka630.pdf")); (recorder.Code != http.StatusOK) || !strings.Contains(recorder.Body.String(), expectedMd5) {
+		t.Errorf("path under the tree root: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	// Only files in the intake directory or under a root may be named
+	for _, path := range []string{filepath.Join(dir, "incoming", "missing.pdf"), filepath.Join(dir, "pending.yaml"), filepath.Join(treeRoot, "ka630.pdf"), "../ka630.pdf"} {
+		if recorder := upload("path", "", []byte(path)); recorder.Code != http.StatusBadRequest {
+			t.Errorf("path %s: status %d", path, recorder.Code)
+		}
+	}
+	server.Intake.MaxUpload = 100
+	if recorder := upload("file", "large.pdf", bytes.Repeat(content, 10)); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload larger than MaxUpload: status %d", recorder.Code)
+	}
+	server.Intake.MaxUpload = 0
+
+	// The checksum and size are computed by the server, not taken from the form
+	form := url.Values{"token": {token}, "title": {"KA630 CPU Module Technical Manual"}, "partnum": {"EK-KA630-TM-001"}, "pubdate": {"1987-01"}, "filepath": {saved}, "format": {"PDF"}, "size": {"1"}, "md5": {strings.Repeat("0", 32)}}
+	forged := url.Values{}
+	for name, value := range form {
+		forged[name] = value
+	}
+	forged.Del("token")
+	if recorder := post("/intake/confirm", "application/x-www-form-urlencoded", bytes.NewBufferString(forged.Encode())); recorder.Code != http.StatusForbidden {
+		t.Errorf("confirm without token: status %d", recorder.Code)
+	}
+	forged.Set("token", token)
+	forged.Set("filepath", filepath.Join(dir, "pending.yaml"))
+	if recorder := post("/intake/confirm", "application/x-www-form-urlencoded", bytes.NewBufferString(forged.Encode())); recorder.Code != http.StatusBadRequest {
+		t.Errorf("confirm of a file outside the roots: status %d", recorder.Code)
+	}
+	if recorder := post("/intake/confirm", "application/x-www-form-urlencoded", bytes.NewBufferString(form.Encode())); recorder.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	pending, err := catalog.Load(server.Intake.Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Document{Format: "PDF", Size: int64(len(content)), Md5: expectedMd5, Title: "KA630 CPU Module Technical Manual", PubDate: "1987-01", PartNum: "EK-KA630-TM-001", Collection: "local-pending", Filepath: saved}
+	got := pending[expectedMd5]
+	got.Copies = nil // Filled in from Filepath when the catalog is read
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("pending catalog holds %+v, expected %+v", got, expected)
+	}
+	if recorder := post("/intake/confirm", "application/x-www-form-urlencoded", bytes.NewBufferString(form.Encode())); recorder.Code != http.StatusBadRequest {
+		t.Errorf("second confirm: status %d", recorder.Code)
+	}
+
+	server.Intake.Dir = ""
+	if recorder := upload("file", "scan.pdf", content); recorder.Code != http.StatusBadRequest {
+		t.Errorf("upload without --intake-dir: status %d", recorder.Code)
+	}
+	token = "forged"
+	if recorder := upload("path", "", []byte("ka630.pdf")); recorder.Code != http.StatusForbidden {
+		t.Errorf("path with the wrong token: status %d", recorder.Code)
+	}
+}
+
+func TestPrescan(t *testing.T) {
+	server := testServer(t, []byte("%PDF-1.4 KA630"))
+	for _, test := range []struct {
+		target string
+		status int
+		copies int
+	}{
+		{"/prescan?title=ka630+cpu", http.StatusOK, 1},
+		{"/prescan?title=KA630&part-num=EK-KA630-TM-001", http.StatusOK, 2},
+		{"/prescan?title=", http.StatusBadRequest, 0},
+	} {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, expected %d", test.target, recorder.Code, test.status)
+			continue
+		}
+		var report prescan.Report
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v", test.target, err)
+		}
+		if len(report.Copies) != test.copies {
+			t.Errorf("%s: found %+v", test.target, report.Copies)
+		}
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	if err := os.MkdirAll(filepath.Join(archive, "DEC_0001", "notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(archive, "DEC_0001", "ka630.pdf"), []byte("%PDF-1.4 KA630"), 0644)
+	os.WriteFile(filepath.Join(archive, "DEC_0001", "notes", "release.txt"), []byte("Release <notes>"), 0644)
+	local := catalog.Catalog{
+		"EK-KA630-TM-001":  {Format: "PDF", Title: "KA630 Technical Manual", PartNum: "EK-KA630-TM-001", Filepath: "file:///DEC_0001/ka630.pdf"},
+		"AA-1234A-TC/1":    {Format: "TXT", Title: "Release Notes", PartNum: "AA-1234A-TC", Filepath: "file:///DEC_0001/notes/release.txt"},
+		"EK-SECRET-TM-001": {Format: "PDF", Title: "Secret Manual", Filepath: "file:///DEC_0001/secret.pdf", Visibility: "private"},
+	}
+	bitsavers := catalog.Catalog{"EK-KA630-UG-001": {Format: "PDF", Title: "KA630 User's Guide", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630ug.pdf"}}
+	for name, documents := range map[string]catalog.Catalog{"local.yaml": local, "bitsavers.yaml": bitsavers} {
+		if err := catalog.Save(filepath.Join(dir, name), documents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := NewServer([]Source{sourceconfig.Parse(filepath.Join(dir, "local.yaml")), sourceconfig.Parse(filepath.Join(dir, "bitsavers.yaml"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Browse = &Browse{Audience: "public", Locator: &locator.Locator{ArchiveRoot: archive}}
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	page := get("/browse?q=ka630").Body.String()
+	for _, expected := range []string{"KA630 Technical Manual", "KA630 User&#39;s Guide", `href="/document/local/EK-KA630-TM-001"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("/browse does not contain %s:\n%s", expected, page)
+		}
+	}
+	if strings.Contains(page, "Release Notes") {
+		t.Errorf("/browse?q=ka630 lists the release notes")
+	}
+	if page := get("/browse").Body.String(); strings.Contains(page, "Secret") || !strings.Contains(page, `href="/document/local/AA-1234A-TC%2F1?as=html"`) {
+		t.Errorf("/browse lists a private document, or no conversion:\n%s", page)
+	}
+
+	feed := get("/opds?q=release").Body.String()
+	for _, expected := range []string{`<title>Release Notes</title>`, `href="/document/local/AA-1234A-TC%2F1" type="text/plain; charset=utf-8"`, `href="/document/local/AA-1234A-TC%2F1?as=html" type="text/html; charset=utf-8"`} {
+		if !strings.Contains(feed, expected) {
+			t.Errorf("/opds does not contain %s:\n%s", expected, feed)
+		}
+	}
+
+	for _, test := range []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/document/local/EK-KA630-TM-001", http.StatusOK, "%PDF-1.4 KA630"},
+		{"/document/local/AA-1234A-TC%2F1?as=html", http.StatusOK, "<pre>Release &lt;notes&gt;</pre>"},
+		{"/document/local/AA-1234A-TC%2F1?as=pdf", http.StatusBadRequest, "cannot convert"},
+		{"/document/local/EK-SECRET-TM-001", http.StatusNotFound, "no document"},
+		{"/document/local/EK-MISSING", http.StatusNotFound, "no document"},
+		{"/document/bitsavers/EK-KA630-UG-001", http.StatusFound, ""},
+	} {
+		recorder := get(test.target)
+		if (recorder.Code != test.status) || !strings.Contains(recorder.Body.String(), test.body) {
+			t.Errorf("%s: status %d: %s", test.target, recorder.Code, recorder.Body.String())
+		}
+	}
+	if location := get("/document/bitsavers/EK-KA630-UG-001").Header().Get("Location"); location != "http://bitsavers.org/pdf/dec/vax/ka630ug.pdf" {
+		t.Errorf("remote document redirected to %q", location)
+	}
+
+	// Without --browse none of it is served
+	server.Browse = nil
+	if recorder := get("/browse"); recorder.Code != http.StatusNotFound {
+		t.Errorf("/browse without --browse: status %d", recorder.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/snapshots"
+	"docs-to-yaml/internal/sourceconfig"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"time"
+)
+
+//
+// This program records snapshots of catalogs in a snapshot store (see internal/snapshots), so that catalog-history can
+// later say when a document entered a catalog, how it changed, and what a catalog looked like at any time.
+//
+// Each snapshot is stored once, however many times it is taken, under the SHA-256 checksum of the catalog, and a
+// catalog that has not changed since its last snapshot is not snapshotted again. The catalogs are named as for
+// build-master: on the command line as NAME=FILEPATH or FILEPATH, or in a --config file (see internal/sourceconfig).
+// Running it after each catalog is rebuilt (e.g. from cron, or at the end of make) builds up the history.
+//
+// To run the program:
+//   go run snapshot-catalog/snapshot-catalog.go --store bin/snapshots local=bin/local.yaml bin/yaml/bitsavers.yaml
+//
+
+func main() {
+	storeDir := flag.String("store", "bin/snapshots", "the directory holding the snapshot store")
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the catalogs to snapshot (name and path), as for build-master")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	sources, err := sourceconfig.Gather(*configFilename, flag.Args())
+	if err != nil {
+		exitcode.UsageErrorf("--config: %s", err)
+	}
+	if len(sources) == 0 {
+		exitcode.UsageError("Please supply at least one catalog, with --config or on the command line")
+	}
+
+	store := snapshots.Open(*storeDir)
+	now := time.Now()
+	for _, source := range sources {
+		documents, err := catalog.Load(source.Path)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", source.Path, err)
+		}
+		entry, taken, err := store.Take(source.Name, source.Path, documents, now)
+		if err != nil {
+			exitcode.Fatalf("Cannot snapshot %s: %v", source.Path, err)
+		}
+		if taken {
+			fmt.Printf("%s: snapshot %.12s (%d documents)\n", source.Name, entry.Hash, entry.Documents)
+		} else {
+			fmt.Printf("%s: unchanged since %s\n", source.Name, entry.Time)
+		}
+	}
+
+	exitcode.Exit()
+}
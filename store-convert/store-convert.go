@@ -0,0 +1,90 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/pdfmetadata"
+	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/remotestore"
+	"docs-to-yaml/internal/tombstones"
+	"flag"
+	"fmt"
+	"os"
+)
+
+//
+// This program converts a persistent store (e.g. bin/md5.store) from one file format to another,
+// so that stores can be moved to a new format one at a time. The tools detect the format of each store when
+// they read it and write it back in the same format, so a converted store can be used straight away.
+//
+// The kind of store must be specified, as each holds a different type of data:
+//
+//   md5:           volume path => MD5 checksum (local-archive-to-yaml; formerly also URL => MD5 for bitsavers-to-yaml)
+//   filesize:      URL => file size (formerly vaxhaven-to-yaml)
+//   remote:        URL => MD5 checksum, size, last seen and status (bitsavers-to-yaml and vaxhaven-to-yaml; see internal/remotestore)
+//   pdf-metadata:  MD5 checksum => PDF metadata (the --exif-cache of local-archive-to-yaml and file-tree-to-yaml)
+//   tombstones:    document key => why and when it was removed (see internal/tombstones)
+//
+// To run the program:
+//   go run store-convert/store-convert.go --kind md5 --format gob --output bin/md5.gob bin/md5.store
+//   go run store-convert/store-convert.go --kind md5 --output bin/md5.sqlite bin/md5.store
+//
+
+// StoreKinds maps each --kind name to the function that converts that kind of store.
+var StoreKinds = map[string]func(inputFilename string, outputFilename string, format persistentstore.Format) (int, error){
+	"md5":          persistentstore.Convert[string, string],
+	"filesize":     persistentstore.Convert[string, int64],
+	"remote":       persistentstore.Convert[string, remotestore.Entry],
+	"pdf-metadata": persistentstore.Convert[string, pdfmetadata.PdfMetadata],
+	"tombstones":   persistentstore.Convert[string, tombstones.Tombstone],
+}
+
+func main() {
+	kind := flag.String("kind", "", "the kind of store: md5, filesize, remote, pdf-metadata or tombstones")
+	formatName := flag.String("format", "", "the output format: yaml, yaml.gz, gob or sqlite (default: implied by the output filename)")
+	outputFilename := flag.String("output", "", "filepath of the converted store")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	console.Flags("Enable verbose reporting")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	convert, found := StoreKinds[*kind]
+	if !found {
+		exitcode.UsageErrorf("Unknown --kind %q; expected md5, filesize, remote, pdf-metadata or tombstones", *kind)
+	}
+	if *outputFilename == "" {
+		exitcode.UsageError("Please supply a filespec for the converted store")
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one store to convert")
+	}
+	inputFilename := flag.Arg(0)
+	if inputFilename == *outputFilename {
+		exitcode.UsageError("The converted store must be written to a different file")
+	}
+
+	format := persistentstore.FormatFromFilename(*outputFilename)
+	if *formatName != "" {
+		var err error
+		format, err = persistentstore.ParseFormat(*formatName)
+		if err != nil {
+			exitcode.UsageError(err)
+		}
+	}
+
+	if _, err := os.Stat(inputFilename); err != nil {
+		exitcode.Fatal(err)
+	}
+	count, err := convert(inputFilename, *outputFilename, format)
+	if err != nil {
+		exitcode.Fatalf("Failed to convert %s: %v", inputFilename, err)
+	}
+	fmt.Printf("Converted %d entries from %s to %s (%s)\n", count, inputFilename, *outputFilename, format)
+
+	exitcode.Exit()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/subscriptions"
+	"flag"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+//
+// This program keeps a local copy of the catalogs that other collectors publish, as listed in a subscriptions file
+// (see internal/subscriptions; by default data/subscriptions.yaml). Each catalog that is due to be checked (its
+// refresh interval has passed since it was last checked) is fetched with a conditional request and, if it has
+// changed, cached as bin/subscriptions/NAME.yaml. find-locally-unique then treats every cached catalog as a remote
+// catalog.
+//
+//   --force         check every subscription, whether or not it is due
+//   --name NAME     check only this subscription (may be repeated)
+//
+// A subscription that cannot be fetched, or whose URL does not give a catalog, is reported and its cached copy kept.
+//
+// To run the program:
+//   go run sync-subscriptions/sync-subscriptions.go --config data/subscriptions.yaml
+//
+
+// The client used for requests to the remote servers, counting each request (see the metrics package)
+var httpClient = &http.Client{Transport: metrics.Transport(nil)}
+
+func main() {
+	var names []string
+	configFilename := flag.String("config", subscriptions.DefaultFilename, "filepath of the YAML file listing the subscriptions")
+	cacheDir := flag.String("cache-dir", subscriptions.DefaultCacheDir, "directory holding the cached catalogs")
+	force := flag.Bool("force", false, "check every subscription, whether or not its refresh interval has passed")
+	flag.Func("name", "check only the subscription with this name (repeatable)", func(s string) error {
+		names = append(names, s)
+		return nil
+	})
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if len(flag.Args()) > 0 {
+		exitcode.UsageError("Unexpected arguments: ", flag.Args())
+	}
+
+	subscriptionList, err := subscriptions.ReadConfig(*configFilename)
+	if err != nil {
+		exitcode.UsageErrorf("Bad --config: %v", err)
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(subscriptionList, func(s subscriptions.Subscription) bool { return s.Name == name }) {
+			exitcode.UsageErrorf("No subscription named %q in %s", name, *configFilename)
+		}
+	}
+
+	state, err := subscriptions.OpenState(*cacheDir, *verbose)
+	if err != nil {
+		exitcode.Fatalf("Cannot read the state of the subscriptions in %s: %v", *cacheDir, err)
+	}
+
+	fetched, unchanged, skipped := 0, 0, 0
+	now := time.Now()
+	for _, subscription := range subscriptionList {
+		if (len(names) > 0) && !slices.Contains(names, subscription.Name) {
+			continue
+		}
+		previous, _ := state.Lookup(subscription.Name)
+		if !*force && !subscriptions.Due(subscription, previous, now) {
+			if *verbose {
+				fmt.Printf("%s: checked %s, not yet due\n", subscription.Name, previous.Checked.Format(time.RFC3339))
+			}
+			skipped += 1
+			continue
+		}
+		current, changed, err := subscriptions.Sync(httpClient, subscription, *cacheDir, previous, now)
+		if err != nil {
+			exitcode.WarningAt("sync-failed", subscription.URL, "WARNING: cannot sync %s: %v\n", subscription.Name, err)
+			continue
+		}
+		state.Update(subscription.Name, current)
+		if changed {
+			events.Info("synced", subscription.URL, "%s: fetched %d documents", subscription.Name, current.Documents)
+			fmt.Printf("%s: fetched %d documents into %s\n", subscription.Name, current.Documents, subscriptions.CachePath(*cacheDir, subscription))
+			fetched += 1
+		} else {
+			if *verbose {
+				fmt.Printf("%s: not modified\n", subscription.Name)
+			}
+			unchanged += 1
+		}
+	}
+	state.Save(filepath.Join(*cacheDir, subscriptions.StateFilename))
+	fmt.Printf("Fetched %d catalogs, %d not modified, %d not yet due\n", fetched, unchanged, skipped)
+
+	exitcode.Exit()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/doctype"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//
+// This program adds tags to, or removes tags from, the documents in a catalog. Tags are arbitrary labels
+// (e.g. "needs-rescan", "rare", "loaned-out") that other tools can filter on with --tag and --without-tag.
+//
+// It also gives documents their type by hand: --doc-type TYPE (e.g. print-set) sets the doctype, which
+// classify-catalog then leaves alone, and --doc-type auto clears it so that classify-catalog decides it again.
+//
+// The documents to change are selected with any combination of:
+//
+//   --key KEY         a document's key in the catalog
+//   --md5 MD5         a document's MD5 checksum
+//   --part-num PN     a document's part number (compared without regard to case)
+//   --path GLOB       a pattern matched against the whole filepath, e.g. 'file:///DEC_0001/vax/*.pdf'
+//
+// Each may be given more than once; a document is changed if it matches any of them.
+// The catalog is rewritten in place unless --yaml-output is given.
+//
+// To run the program:
+//   go run tag-catalog/tag-catalog.go --add needs-rescan --part-num EK-KA630-TM bin/local.yaml
+//
+
+type Document = document.Document
+
+// Returns a flag.Func handler that appends each value (split at commas) to list
+func appendTo(list *[]string) func(string) error {
+	return func(s string) error {
+		for _, value := range strings.Split(s, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				*list = append(*list, value)
+			}
+		}
+		return nil
+	}
+}
+
+func main() {
+	var addTags, removeTags []string
+	var query catalog.Query
+	flag.Func("add", "tag(s) to add to each selected document (repeatable, or comma-separated)", appendTo(&addTags))
+	flag.Func("remove", "tag(s) to remove from each selected document (repeatable, or comma-separated)", appendTo(&removeTags))
+	docType := flag.String("doc-type", "", "type to give each selected document, e.g. print-set, or 'auto' to leave it to classify-catalog")
+	flag.Func("key", "select the document with this catalog key (repeatable)", func(s string) error {
+		query.Keys = append(query.Keys, s)
+		return nil
+	})
+	flag.Func("md5", "select the document with this MD5 checksum (repeatable)", appendTo(&query.Md5s))
+	flag.Func("part-num", "select documents with this part number (repeatable)", appendTo(&query.PartNums))
+	flag.Func("path", "select documents whose filepath matches this glob (repeatable)", func(s string) error {
+		query.PathGlobs = append(query.PathGlobs, s)
+		return nil
+	})
+	yamlOutputFilename := flag.String("yaml-output", "", "filepath of the output YAML (default: rewrite the input catalog)")
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if (len(addTags) == 0) && (len(removeTags) == 0) && (*docType == "") {
+		exitcode.UsageError("Please supply at least one tag to --add or --remove, or a --doc-type")
+	}
+	if (*docType != "") && (*docType != AutoDocType) && !doctype.IsType(*docType) {
+		exitcode.UsageErrorf("--doc-type must be lower-case words joined by hyphens (e.g. print-set), not %q", *docType)
+	}
+	if query.Empty() {
+		exitcode.UsageError("Please select documents with --key, --md5, --part-num or --path")
+	}
+	if err := query.Validate(); err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) != 1 {
+		exitcode.UsageError("Please supply exactly one catalog to tag")
+	}
+	inputFilename := flag.Arg(0)
+	if *yamlOutputFilename == "" {
+		*yamlOutputFilename = inputFilename
+	}
+
+	documents, err := catalog.Load(inputFilename)
+	if err != nil {
+		exitcode.Fatalf("Cannot read %s: %v", inputFilename, err)
+	}
+
+	selected, changed := ApplyTags(documents, query, addTags, removeTags, *docType)
+	if *verbose {
+		for _, key := range changed {
+			fmt.Printf("Tagged %s: %s", key, strings.Join(documents[key].Tags, ","))
+			if documents[key].DocType != "" {
+				fmt.Printf(" (%s)", documents[key].DocType)
+			}
+			fmt.Println()
+		}
+	}
+	if selected == 0 {
+		exitcode.Warning("WARNING: no document in %s was selected\n", inputFilename)
+	}
+	fmt.Printf("Selected %d documents, changed %d\n", selected, len(changed))
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*yamlOutputFilename, documents, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *yamlOutputFilename)
+			exitcode.Exit()
+		}
+	}
+	if err := catalog.Save(*yamlOutputFilename, documents); err != nil {
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documents); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// The --doc-type that clears a type given by hand
+const AutoDocType = "auto"
+
+// Adds and removes tags on every document selected by the query, and sets its type unless docType is blank (see
+// SetDocType). Returns the number of documents selected and the keys of those actually changed, sorted.
+func ApplyTags(documents catalog.Catalog, query catalog.Query, addTags []string, removeTags []string, docType string) (int, []string) {
+	selected := 0
+	var changed []string
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		if !query.Matches(key, doc) {
+			continue
+		}
+		selected += 1
+		added := document.AddTags(&doc, addTags...)
+		removed := document.RemoveTags(&doc, removeTags...)
+		retyped := SetDocType(&doc, docType)
+		if added || removed || retyped {
+			documents[key] = doc
+			changed = append(changed, key)
+		}
+	}
+	return selected, changed
+}
+
+// Gives a document a type by hand, so that classify-catalog leaves it alone. AutoDocType clears the type (and "" leaves
+// it as it is). Returns true if the document changed.
+func SetDocType(doc *Document, docType string) bool {
+	before := *doc
+	switch docType {
+	case "":
+		return false
+	case AutoDocType:
+		doc.DocType = ""
+	default:
+		doc.DocType = docType
+	}
+	document.ClearFlags(doc, document.CodeSetFlags["DocType"])
+	return (doc.DocType != before.DocType) || (doc.Flags != before.Flags)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"docs-to-yaml/pkg/catalog"
+	"reflect"
+	"testing"
+)
+
+func TestApplyTags(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {PartNum: "EK-KA630-TM", Md5: "a", Filepath: "file:///DEC_0001/vax/ka630.pdf"},
+		"b": {PartNum: "AA-0196C-TK", Md5: "b", Filepath: "file:///DEC_0001/algol/aa-0196c-tk.pdf", Tags: []string{"rare"}},
+		"c": {Md5: "c", Filepath: "file:///DEC_0002/vax/field-guide.txt", Tags: []string{"needs-rescan"}},
+	}
+	query := catalog.Query{PartNums: []string{"ek-ka630-tm"}, PathGlobs: []string{"file:///DEC_0001/algol/*.pdf"}, Md5s: []string{"c"}}
+
+	selected, changed := ApplyTags(documents, query, []string{"needs-rescan"}, []string{"rare"}, "")
+	if (selected != 3) || !reflect.DeepEqual(changed, []string{"a", "b"}) {
+		t.Errorf(`ApplyTags() = %d, %q`, selected, changed)
+	}
+	for key, doc := range documents {
+		if !reflect.DeepEqual(doc.Tags, []string{"needs-rescan"}) {
+			t.Errorf(`document %s has tags %q`, key, doc.Tags)
+		}
+	}
+
+	selected, changed = ApplyTags(documents, catalog.Query{PathGlobs: []string{"file:///DEC_0001/*"}}, nil, []string{"needs-rescan"}, "")
+	if (selected != 0) || (len(changed) != 0) {
+		t.Errorf(`ApplyTags() with a glob that does not cross "/" = %d, %q`, selected, changed)
+	}
+}
+
+func TestSetDocType(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {PartNum: "MP-01234-00", DocType: "print-set", Flags: "PK"},
+		"b": {PartNum: "EK-KA630-TM", DocType: "manual"},
+	}
+	selected, changed := ApplyTags(documents, catalog.Query{Keys: []string{"a", "b"}}, nil, nil, "schematic")
+	if (selected != 2) || !reflect.DeepEqual(changed, []string{"a", "b"}) || (documents["a"].DocType != "schematic") || (documents["a"].Flags != "P") {
+		t.Errorf(`ApplyTags() with a type = %d, %q, %+v`, selected, changed, documents)
+	}
+	doc := documents["b"]
+	if !SetDocType(&doc, AutoDocType) || (doc.DocType != "") {
+		t.Errorf(`SetDocType() with auto gave %+v`, doc)
+	}
+	if SetDocType(&doc, "") {
+		t.Errorf(`SetDocType() with no type reported a change`)
+	}
+}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"docs-to-yaml/internal/checkpoint"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
-	"docs-to-yaml/internal/persistentstore"
+	"docs-to-yaml/internal/enrich"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/metrics"
+	"docs-to-yaml/internal/redirect"
+	"docs-to-yaml/internal/remotestore"
+	"docs-to-yaml/internal/tombstones"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -16,23 +28,52 @@ import (
 
 // This program takes the a subset of VaxHaven documentation index pages and the set of known VaxHaven documents
 // and updates the latter with new information from the former.
-// As a side effect the File Size Store may be updated with new values.
+// The pages to process (hardware docs, software docs, field guides, etc.) and the layout of each are listed in
+// the --config file (see VaxHavenConfig); each Document records the section it came from.
+// As a side effect the Remote Store (see internal/remotestore) may be updated with new values.
+//
+// Sizes captured long ago may be stale. With --refresh-sizes, any document whose size was last confirmed more than
+// --max-size-age ago is re-checked with a conditional HEAD request (If-Modified-Since the time the size was recorded).
+// A changed size suggests that the remote file has been replaced, so it is reported.
+//
+// The HEAD requests follow redirects (see internal/redirect). Each permanent redirect found is kept in the Remote
+// Store, and every document whose link is known to redirect is recorded at the URL it leads to, with the redirect in
+// its redirects field, so the catalog converges on VaxHaven's current links even though the index pages give the old ones.
+//
+// Ctrl-C (or SIGTERM) stops the run cleanly: no more sizes are fetched, the Remote Store is saved, and the documents
+// found so far are written to a partial catalog (YAML-OUTPUT.partial). Running again with the same arguments carries on,
+// as the sizes already fetched are found in the Remote Store.
 
 type Document = document.Document
 
-type Store = persistentstore.Store[string, int64]
+type Store = remotestore.Store
 
 var vaxhaven_prefix = "http://www.vaxhaven.com"
 
 func main() {
 
-	vaxhaven_data := "data/VaxHaven.txt"
+	configFilename := flag.String("config", "", "filepath of a YAML file listing the VaxHaven index pages to process (default: data/VaxHaven.txt only)")
 	output_file := flag.String("yaml-output", "", "filepath of the output file to hold the generated yaml")
-	fileSizeStoreFilename := "bin/filesize.store"
-	fileSizeStoreCreate := true
-	verbose := false
+	jsonlOutputFilename := flag.String("jsonl-output", "", "filepath of a JSON Lines (NDJSON) copy of the output catalog, one document per line")
+	splitOutputBy := flag.String("split-output-by", "", "write the output catalog as a directory of one YAML file per collection, volume or format, plus an index")
+	preview := flag.Bool("preview", false, "show what would change in the output catalog and ask for confirmation before writing it")
+	refreshSizes := flag.Bool("refresh-sizes", false, "re-check the size of remote documents whose stored size is older than --max-size-age")
+	maxSizeAge := flag.Duration("max-size-age", 365*24*time.Hour, "the age beyond which a stored size is re-checked by --refresh-sizes")
+	tombstonesFilename := flag.String("tombstones", tombstones.DefaultFilename, "filepath of the tombstone store listing documents removed on purpose, which are left out")
+	enrichmentFilename := flag.String("enrichment", enrich.DefaultFilename, "filepath of the enrichment rules applied to the documents before the catalog is written")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	remoteStoreFilename := remotestore.DefaultFilename
+	verbosity := console.Flags("Enable verbose reporting")
 
 	flag.Parse()
+	verbose := *verbosity
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if err := catalog.ValidatePartitioning(*splitOutputBy); err != nil {
+		exitcode.UsageError(err)
+	}
 
 	fatal_error_seen := false
 
@@ -42,102 +83,297 @@ func main() {
 	}
 
 	if fatal_error_seen {
-		log.Fatal("Unable to continue because of one or more fatal errors")
+		exitcode.UsageError("Unable to continue because of one or more fatal errors")
 	}
+	partialCatalogFilename, err := workspace.Companion(*output_file, ".partial")
+	if err != nil {
+		exitcode.UsageErrorf("Bad --yaml-output: %v", err)
+	}
+
+	interrupt.Watch()
 
-	fileSizeStoreInstantiation := persistentstore.Store[string, int64]{}
-	fileSizeStore, err := fileSizeStoreInstantiation.Init(fileSizeStoreFilename, fileSizeStoreCreate, verbose)
+	config := DefaultConfig
+	if *configFilename != "" {
+		var err error
+		config, err = ReadConfig(*configFilename)
+		if err != nil {
+			exitcode.UsageError("Bad --config: ", err)
+		}
+	}
+
+	remoteStore, err := remotestore.Open(remoteStoreFilename, verbose)
 	if err != nil {
-		fmt.Printf("Problem initialising FileSize Store: %+v\n", err)
+		exitcode.Warning("Problem initialising Remote Store: %+v\n", err)
 	} else if !verbose {
-		fmt.Println("Size of new FileSize store: ", len(fileSizeStore.Data))
+		fmt.Println("Size of Remote store: ", len(remoteStore.Data))
 	}
 
-	documentsMap := ParseNewData(vaxhaven_data, fileSizeStore, verbose)
+	// A zero maximum age disables refreshing
+	refreshAge := time.Duration(0)
+	if *refreshSizes {
+		refreshAge = *maxSizeAge
+	}
+
+	documentsMap := ParseNewData(config, remoteStore, refreshAge, verbose)
 
-	// If the FileSize Store is active and it has been modified ... save it
-	fileSizeStore.Save(fileSizeStoreFilename)
+	// If the Remote Store is active and it has been modified ... save it
+	remoteStore.Save(remoteStoreFilename)
+
+	// If interrupted, keep the documents found so far; the sizes fetched are safe in the Remote Store
+	if interrupt.Requested() {
+		SavePartialCatalog(partialCatalogFilename, documentsMap)
+		interrupt.Exit("To continue, re-run with the same arguments")
+	}
+
+	// Leave out the documents that were removed on purpose, so that rebuilding the catalog does not bring them back
+	tombstones.ExcludeForCatalog(*tombstonesFilename, documentsMap, verbose)
+
+	// Apply the recurring fixups kept as enrichment rules
+	enrich.ApplyForCatalog(*enrichmentFilename, documentsMap, verbose)
+
+	// Keep any tags and notes that were added by hand to the catalog being replaced
+	if _, err := catalog.Catalog(documentsMap).PreserveAnnotationsFrom(*output_file); err != nil {
+		exitcode.Warning("WARNING: cannot carry forward tags and notes from %s: %s\n", *output_file, err)
+	}
+
+	if *preview {
+		if confirmed, err := catalog.Preview(*output_file, documentsMap, os.Stdin, os.Stdout); err != nil {
+			exitcode.Fatal("Cannot preview the changes: ", err)
+		} else if !confirmed {
+			fmt.Printf("Nothing written to %s\n", *output_file)
+			exitcode.Exit()
+		}
+	}
 
 	// Write the output YAML file
-	err = document.WriteDocumentsMapToOrderedYaml(documentsMap, *output_file)
+	err = catalog.SaveSplit(*output_file, documentsMap, *splitOutputBy)
 	if err != nil {
-		log.Fatal("Failed YAML write: ", err)
+		exitcode.Fatal("Failed YAML write: ", err)
+	}
+	if *jsonlOutputFilename != "" {
+		if err := catalog.SaveJsonl(*jsonlOutputFilename, documentsMap); err != nil {
+			exitcode.Fatal("Failed JSON Lines write: ", err)
+		}
+	}
+
+	// The run is complete, so any partial catalog is no longer needed
+	if err := os.Remove(partialCatalogFilename); err == nil {
+		fmt.Printf("Removed partial catalog %s\n", partialCatalogFilename)
 	}
-}
 
-// This function parses the VaxHaven HTML that indexes the documents and produces a set of
-// corresponding YAML data. The input HTML should be a concatenation of the individual VaxHaven
-// documentation index pages.
-func ParseNewData(filename string, fileSizeStore *Store, verbose bool) map[string]Document {
+	exitcode.Exit()
+}
 
-	// Open the bitsavers index file, complaining loudly on failure
-	file, err := os.ReadFile(filename)
+// Writes the documents found before an interrupt, in a form that catalog.Load can read back.
+func SavePartialCatalog(filename string, documentsMap map[string]Document) {
+	err := checkpoint.SaveState(filename, documentsMap)
 	if err != nil {
-		log.Fatal(err)
+		exitcode.WarningAt("checkpoint-failed", filename, "WARNING: failed to write partial catalog %s: %s\n", filename, err)
+		return
 	}
+	fmt.Printf("Partial catalog: %d documents written to %s\n", len(documentsMap), filename)
+}
 
-	// The interesting data looks like this:
-	// <tr>
-	// <td><a href="/images/d/dd/AA-0196C-TK.pdf" class="internal" title="AA-0196C-TK.pdf">AA-0196C-TK</a></td>
-	// <td>DECsystem10/20 ALGOL Programmer's Guide</td>
-	// <td>1977 April
-	// </td></tr>
-	//
-	// So the part number, document title, date and path are all available
+// VaxHavenConfig lists the VaxHaven documentation index pages to process.
+// It is read from the YAML file supplied with --config, which looks like this:
+//
+//	pages:
+//	  - section: Hardware
+//	    file: data/VaxHaven-hardware.txt
+//	  - section: Field Guides
+//	    file: data/VaxHaven-field-guides.txt
+//	    columns: [part, date, title]
+type VaxHavenConfig struct {
+	Pages []VaxHavenPage
+}
 
-	documentsMap := make(map[string]Document)
+// VaxHavenPage describes one (saved) VaxHaven index page, or a concatenation of pages that share a layout.
+type VaxHavenPage struct {
+	Section string   // The section of the website the page comes from; recorded in each Document
+	File    string   // The saved HTML
+	Columns []string // The meaning of each table column (see the Column* constants); default: part, title, date
+}
 
-	r_rows := regexp.MustCompile(`(?ms)<tr>(.*?)</tr>`)
-	r_data := regexp.MustCompile(`(?ms)<td>\s*<a\s+href="(.*?)".*?>(.*?)</a></td>.*?<td>(.*?)</td>.*?<td>(.*?)</td>`)
-	r_data_no_date := regexp.MustCompile(`(?ms)<td>\s*<a\s+href="(.*?)".*?>(.*?)</a></td>.*?<td>(.*?)</td>`)
-	fmt.Println(r_rows)
-	rows := r_rows.FindAllStringSubmatch(string(file), -1)
-	fmt.Println("Found ", len(rows), "matches")
-	for i, match := range rows {
-		data := r_data.FindAllStringSubmatch(match[1], -1)
-		// data[0][1] => path (href)
-		// data[0][2] => part num
-		// data[0][3] => title
-		// data[0][4] => date
-		docDate := ""
-		if data == nil {
-			data = r_data_no_date.FindAllStringSubmatch(match[1], -1)
-			if data == nil {
-				fmt.Println("No match at all for [", match[1], "] #", i)
-				continue
-			}
-		} else {
-			docDate = data[0][4]
-		}
-		// fmt.Println("data size = ", len(data), " => ", len(data[0]), "=>", data[0][1], "=> ", data[0][2], " => ", data[0][3], data[0][4])
-		document := CreateVaxHavenDocument(vaxhaven_prefix + data[0][1])
-		document.PartNum = strings.TrimSpace(data[0][2])
-		document.Title = strings.TrimSuffix(strings.TrimSpace(data[0][3]), "\n")
-		if len(data[0]) >= 4 {
-			document.PubDate = ConvertVaxHavenDate(docDate)
-			if document.PubDate == "XXXX" {
-				fmt.Printf("Suspicious date [%s] for %s (%s)\n", docDate, document.Title, document.Filepath)
+// The legal values for VaxHavenPage.Columns
+const (
+	ColumnPart      = "part"       // A link to the document, whose text is the part number
+	ColumnTitle     = "title"      // The document title
+	ColumnTitleLink = "title-link" // A link to the document, whose text is the title (for sections without part numbers)
+	ColumnDate      = "date"       // The publication date, as "YYYY Month" or "YYYY"
+	ColumnIgnore    = "ignore"     // Anything else
+)
+
+// The columns used by the pages this program was originally written for
+var DefaultColumns = []string{ColumnPart, ColumnTitle, ColumnDate}
+
+// The configuration used when no --config is supplied
+var DefaultConfig = VaxHavenConfig{Pages: []VaxHavenPage{{File: "data/VaxHaven.txt"}}}
+
+// Reads and validates the configuration file
+func ReadConfig(filename string) (VaxHavenConfig, error) {
+	var config VaxHavenConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	if err := document.UnmarshalYaml(data, &config); err != nil {
+		return config, err
+	}
+	if len(config.Pages) == 0 {
+		return config, fmt.Errorf("%s lists no pages", filename)
+	}
+	for i, page := range config.Pages {
+		if page.File == "" {
+			return config, fmt.Errorf("%s: page %d has no file", filename, i+1)
+		}
+		links := 0
+		for _, column := range page.Columns {
+			switch column {
+			case ColumnPart, ColumnTitleLink:
+				links += 1
+			case ColumnTitle, ColumnDate, ColumnIgnore:
+			default:
+				return config, fmt.Errorf("%s: page %d has unknown column %q", filename, i+1, column)
 			}
 		}
+		if (len(page.Columns) > 0) && (links != 1) {
+			return config, fmt.Errorf("%s: page %d must have exactly one %s or %s column", filename, i+1, ColumnPart, ColumnTitleLink)
+		}
+	}
+	return config, nil
+}
+
+// This function parses each configured VaxHaven documentation index page and produces a set of
+// corresponding YAML data. Each input file may be a concatenation of several pages with the same layout.
+// Once an interrupt has been received no more sizes are fetched, and the documents found so far are returned.
+func ParseNewData(config VaxHavenConfig, remoteStore *Store, refreshAge time.Duration, verbose bool) map[string]Document {
+	documentsMap := make(map[string]Document)
 
-		fileSize, err := CalculatefileSize(document.Filepath, fileSizeStore, verbose)
+pages:
+	for _, page := range config.Pages {
+		// Open the index file, complaining loudly on failure
+		file, err := os.ReadFile(page.File)
 		if err != nil {
-			log.Fatal(err)
+			exitcode.Fatal(err)
 		}
-		document.Size = fileSize
-		// fmt.Println("document: ", document)
 
-		if _, found := documentsMap[document.PartNum]; found {
-			fmt.Printf("VaxHaven docuemnt repeated: Found [%s, %s] repeated as %s\n", document.PartNum, documentsMap[document.PartNum].Filepath, document.Filepath)
-		} else {
-			documentsMap[document.PartNum] = document
-		}
+		documents := ParseVaxHavenRows(string(file), page)
+		fmt.Printf("Found %d documents in %s (section %q)\n", len(documents), page.File, page.Section)
+		for _, document := range documents {
+			if interrupt.Requested() {
+				break pages
+			}
+			if document.PubDate == "XXXX" {
+				fmt.Printf("Suspicious date for %s (%s)\n", document.Title, document.Filepath)
+			}
 
+			fileSize, err := CalculatefileSize(document.Filepath, remoteStore, refreshAge, verbose)
+			if err != nil {
+				exitcode.Fatal(err)
+			}
+			document.Size = fileSize
+
+			if existing, found := documentsMap[document.PartNum]; found {
+				fmt.Printf("VaxHaven docuemnt repeated: Found [%s, %s] repeated as %s\n", document.PartNum, existing.Filepath, document.Filepath)
+				// Keep the repeat as another location of the document
+				AddVaxHavenLocation(&existing, document)
+				documentsMap[document.PartNum] = existing
+			} else {
+				AddVaxHavenLocation(&document, document)
+				documentsMap[document.PartNum] = document
+			}
+		}
 	}
+	FollowKnownRedirects(documentsMap, remoteStore.Redirects())
 	fmt.Println("Number of docs found: ", len(documentsMap))
 	return documentsMap
 }
 
+// The interesting data looks like this:
+//
+//	<tr>
+//	<td><a href="/images/d/dd/AA-0196C-TK.pdf" class="internal" title="AA-0196C-TK.pdf">AA-0196C-TK</a></td>
+//	<td>DECsystem10/20 ALGOL Programmer's Guide</td>
+//	<td>1977 April
+//	</td></tr>
+//
+// So the part number, document title, date and path are all available.
+// Some sections order the columns differently, omit the date or have no part number; page.Columns describes the layout.
+var rowRegex = regexp.MustCompile(`(?ms)<tr>(.*?)</tr>`)
+var cellRegex = regexp.MustCompile(`(?ms)<td[^>]*>(.*?)</td>`)
+var linkRegex = regexp.MustCompile(`(?ms)<a\s+href="(.*?)".*?>(.*?)</a>`)
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Extracts a Document from each table row of a VaxHaven index page. Rows without a link to a document
+// (e.g. headings) are skipped. Missing trailing columns (typically the date) are allowed.
+func ParseVaxHavenRows(html string, page VaxHavenPage) []Document {
+	columns := page.Columns
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+
+	var documents []Document
+	for _, row := range rowRegex.FindAllStringSubmatch(html, -1) {
+		cells := cellRegex.FindAllStringSubmatch(row[1], -1)
+		var document Document
+		linked := false
+		for i, column := range columns {
+			if i >= len(cells) {
+				break
+			}
+			cell := cells[i][1]
+			switch column {
+			case ColumnPart, ColumnTitleLink:
+				link := linkRegex.FindStringSubmatch(cell)
+				if link == nil {
+					break
+				}
+				linked = true
+				document.Filepath = vaxhaven_prefix + link[1]
+				if column == ColumnPart {
+					document.PartNum = strings.TrimSpace(link[2])
+				} else {
+					document.Title = CellText(link[2])
+				}
+			case ColumnTitle:
+				document.Title = CellText(cell)
+			case ColumnDate:
+				document.PubDate = ConvertVaxHavenDate(strings.TrimSpace(cell))
+			}
+		}
+		if !linked {
+			continue
+		}
+		newDocument := CreateVaxHavenDocument(document.Filepath)
+		newDocument.PartNum = document.PartNum
+		newDocument.Title = document.Title
+		newDocument.PubDate = document.PubDate
+		newDocument.Section = page.Section
+		documents = append(documents, newDocument)
+	}
+	return documents
+}
+
+// Returns the text of a table cell, without any markup
+func CellText(cell string) string {
+	return strings.Join(strings.Fields(tagRegex.ReplaceAllString(cell, "")), " ")
+}
+
+// Adds the location of the file described by found (which may be doc itself) to doc's Copies.
+func AddVaxHavenLocation(doc *Document, found Document) {
+	document.NormaliseLocations(doc)
+	document.AddLocations(doc, document.LocationOf(found.Filepath, found.Size))
+}
+
+// Moves each document to the URL that its URLs are known to permanently redirect to, if any, recording the redirects.
+func FollowKnownRedirects(documentsMap map[string]Document, redirects map[string]string) {
+	for key, doc := range documentsMap {
+		if document.AddRedirects(&doc, redirects) {
+			documentsMap[key] = doc
+		}
+	}
+}
+
 // This function function creates a Document struct with some default values set.
 func CreateVaxHavenDocument(path string) Document {
 	var newDocument Document
@@ -165,7 +401,7 @@ func ConvertVaxHavenDate(date string) string {
 		return "XXXX"
 	}
 	year := date[0:4]
-	month := strings.ToLower(strings.TrimSpace(date[5:]))
+	month := strings.ToLower(strings.TrimSpace(date[4:])) // Some sections give just the year
 	result := "YYYY-MM"
 	if len(month) < 1 {
 		result = year
@@ -175,7 +411,7 @@ func ConvertVaxHavenDate(date string) string {
 		if monthNumber, ok := monthNames[month]; ok {
 			result = year + "-" + monthNumber
 		} else {
-			log.Fatalf("Bad date: [%s] year=[%s] month=[%s]", date, year, month)
+			exitcode.Fatalf("Bad date: [%s] year=[%s] month=[%s]", date, year, month)
 		}
 	}
 	return result
@@ -184,35 +420,104 @@ func ConvertVaxHavenDate(date string) string {
 // Return the fileSize for the specified file.
 // Start by looking up the filename (path) in the store and return a pre-computed fileSize sum if found.
 // Otherwise, compute the fileSize sum, add the entry to the store and return the computed fileSize sum.
+// If refreshAge is non-zero, a stored size older than refreshAge is re-checked with the remote server.
+// Any permanent redirects found on the way to the remote server are recorded in the store.
 var tempCount int = 0
 
-func CalculatefileSize(filename string, fileSizeStore *Store, verbose bool) (int64, error) {
+func CalculatefileSize(filename string, remoteStore *Store, refreshAge time.Duration, verbose bool) (int64, error) {
 
 	// Lookup the filename (path) in the store; if found report that as the fileSize sum
-	if fileSize, found := fileSizeStore.Lookup(filename); found {
+	storedSize, found := remoteStore.Size(filename)
+	if found && ((refreshAge == 0) || !remoteStore.IsStale(filename, refreshAge)) {
 		if verbose {
-			fmt.Printf("fileSize Store: Found %d for %s\n", fileSize, filename)
+			fmt.Printf("Remote Store: Found %d for %s\n", storedSize, filename)
 		}
-		return fileSize, nil
+		return storedSize, nil
 	}
 	tempCount += 1
 	if tempCount > 10000 {
 		fmt.Println("Too many URL lookups")
-		return 0, nil
+		return storedSize, nil
 	}
 
-	// The filename (path) is not in the store.
-	// Ask for the remote file size
-	url := filename
-	resp, err := http.Head(url)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// The filename (path) is not in the store, or its size needs to be re-checked.
+	// Ask for the remote file size, only if it has changed since it was last recorded.
+	var since time.Time
+	if found {
+		since, _ = remoteStore.LastSeen(filename)
 	}
+	format, _ := document.DetermineDocumentFormat(filename)
+	fileSize, modified, chain, err := FetchRemoteSize(filename, format, since)
 	time.Sleep(2 * time.Second)
-	fileSize, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-	fmt.Printf("fileSize Store: saved %d for %s\n", fileSize, filename)
-	fileSizeStore.Update(filename, fileSize)
+	for url, target := range chain.Redirects() {
+		if entry, _ := remoteStore.Lookup(url); entry.MovedTo != target {
+			fmt.Printf("Remote Store: %s moved to %s\n", url, target)
+			remoteStore.SetMoved(url, target)
+		}
+	}
+	if chain.Landing {
+		exitcode.WarningAt("redirect-to-page", filename, "WARNING: %s redirects to a web page at %s\n", filename, chain.Hops[len(chain.Hops)-1])
+	}
+	if errors.Is(err, ErrNotFound) {
+		// Keep any size we already have, but remember that the file has gone
+		remoteStore.SetStatus(filename, remotestore.StatusMissing)
+		exitcode.WarningAt("remote-missing", filename, "WARNING: %s\n", err)
+		return storedSize, nil
+	}
+	if err != nil {
+		if !found {
+			exitcode.Fatalf("Cannot determine size of %s: %s", filename, err)
+		}
+		// Keep the size we already have and try again next time
+		exitcode.WarningAt("size-refresh-failed", filename, "WARNING: cannot refresh size of %s: %s\n", filename, err)
+		return storedSize, nil
+	}
+
+	if found && !modified {
+		remoteStore.Seen(filename)
+		if verbose {
+			fmt.Printf("Remote Store: confirmed %d for %s\n", storedSize, filename)
+		}
+		return storedSize, nil
+	}
+	if found && (fileSize != storedSize) {
+		exitcode.WarningAt("remote-size-changed", filename, "WARNING: size of %s changed from %d to %d - remote file probably replaced\n", filename, storedSize, fileSize)
+	}
+	fmt.Printf("Remote Store: saved %d for %s\n", fileSize, filename)
+	remoteStore.SetSize(filename, fileSize)
 
 	return fileSize, nil
 }
+
+// ErrNotFound is returned by FetchRemoteSize when the server says that the file does not exist
+var ErrNotFound = errors.New("remote file not found")
+
+// The client used for requests to the remote server, counting each request (see the metrics package)
+var httpClient = &http.Client{Transport: metrics.Transport(nil)}
+
+// Asks the remote server for the size of a file of the given format with a HEAD request, following any redirects.
+// If since is set, the request is conditional: if the server reports that the file has not been modified since then,
+// modified is false and the size is not returned. The redirects followed are returned too.
+func FetchRemoteSize(url string, format string, since time.Time) (size int64, modified bool, chain redirect.Chain, err error) {
+	request, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false, chain, err
+	}
+	if !since.IsZero() {
+		request.Header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	}
+	resp, chain, err := redirect.Follow(httpClient, request, format)
+	if err != nil {
+		return 0, false, chain, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return 0, false, chain, nil
+	}
+	if (resp.StatusCode == http.StatusNotFound) || (resp.StatusCode == http.StatusGone) {
+		return 0, false, chain, fmt.Errorf("%s: %w (%s)", url, ErrNotFound, resp.Status)
+	}
+	// As before, any other response is taken at face value (a missing file will show up as a size change)
+	size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return size, true, chain, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVaxHavenRows(t *testing.T) {
+	html := `<table>
+<tr><th>Part</th><th>Title</th><th>Date</th></tr>
+<tr>
+<td><a href="/images/d/dd/AA-0196C-TK.pdf" class="internal" title="AA-0196C-TK.pdf">AA-0196C-TK</a></td>
+<td>DECsystem10/20 ALGOL Programmer's Guide</td>
+<td>1977 April
+</td></tr>
+<tr>
+<td><a href="/images/a/ab/EK-KA630-TM.pdf" class="internal">EK-KA630-TM</a></td>
+<td>KA630 CPU Module
+ Technical Manual</td>
+</tr>
+</table>`
+
+	documents := ParseVaxHavenRows(html, VaxHavenPage{Section: "Hardware"})
+	expected := []Document{
+		{Collection: "VaxHaven", Filepath: "http://www.vaxhaven.com/images/d/dd/AA-0196C-TK.pdf", PartNum: "AA-0196C-TK", Title: "DECsystem10/20 ALGOL Programmer's Guide", PubDate: "1977-04", Section: "Hardware"},
+		{Collection: "VaxHaven", Filepath: "http://www.vaxhaven.com/images/a/ab/EK-KA630-TM.pdf", PartNum: "EK-KA630-TM", Title: "KA630 CPU Module Technical Manual", Section: "Hardware"},
+	}
+	if !reflect.DeepEqual(documents, expected) {
+		t.Errorf("ParseVaxHavenRows() mismatch:\n%+v\n%+v", documents, expected)
+	}
+}
+
+func TestParseVaxHavenRowsColumns(t *testing.T) {
+	html := `<tr><td>1985</td><td><a href="/images/f/fg.pdf">VAX Field Guide</a></td></tr>`
+
+	documents := ParseVaxHavenRows(html, VaxHavenPage{Section: "Field Guides", Columns: []string{ColumnDate, ColumnTitleLink}})
+	expected := []Document{
+		{Collection: "VaxHaven", Filepath: "http://www.vaxhaven.com/images/f/fg.pdf", Title: "VAX Field Guide", PubDate: "1985", Section: "Field Guides"},
+	}
+	if !reflect.DeepEqual(documents, expected) {
+		t.Errorf("ParseVaxHavenRows() mismatch:\n%+v\n%+v", documents, expected)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	if err := os.WriteFile(good, []byte("pages:\n  - section: Hardware\n    file: hw.txt\n  - section: Field Guides\n    file: fg.txt\n    columns: [date, title-link]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(good)
+	if err != nil {
+		t.Fatalf(`ReadConfig() failed: %v`, err)
+	}
+	if (len(config.Pages) != 2) || (config.Pages[1].Section != "Field Guides") || !reflect.DeepEqual(config.Pages[1].Columns, []string{"date", "title-link"}) {
+		t.Errorf(`ReadConfig() = %+v`, config)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("pages:\n  - file: hw.txt\n    columns: [title, date]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(bad); err == nil {
+		t.Errorf(`ReadConfig() accepted a page without a link column`)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"docs-to-yaml/internal/archivefs"
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/interrupt"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/notify"
+	"docs-to-yaml/internal/pipeline"
+	"docs-to-yaml/internal/profiling"
+	"docs-to-yaml/pkg/catalog"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// This program answers the question "has anything changed on disk since this catalog was made?". Every document
+// in the catalog(s) that records an MD5 checksum is hashed again and reported as:
+//
+//   ok         the file is still there and still has the recorded MD5 checksum
+//   changed    the file's size or MD5 checksum no longer matches the catalog (bit rot, or a replaced file)
+//   missing    the file no longer exists
+//   unreadable the file exists but could not be read (often a failing disc)
+//   unchecked  the document cannot be checked: it records no MD5 checksum, is not held locally (e.g. a URL) or
+//              is on a volume that is not available (e.g. not mounted)
+//
+// followed by the totals for each. The size is compared first, so a truncated file is found without hashing it.
+//
+// Local documents have filepaths of the form file:///VOLUME/path (as written by local-archive-to-yaml), so the root of
+// each volume must be supplied, either individually (--volume VOLUME=PATH) or as a directory that holds every volume
+// (--archive-root). Filepaths relative to a tree root (as written by file-tree-to-yaml) are found under --tree-root.
+// Any root may be remote (sftp://, smb:// or s3://, see internal/archivefs).
+//
+// Changed, missing and unreadable documents are errors (exit status 3); unchecked documents are warnings.
+// --notify FILE sends a summary of the run, listing any bit rot found, by email or to a webhook or ntfy topic if
+// anything noteworthy happened (see internal/notify), e.g. after a nightly check from cron.
+// --where restricts the check to the documents selected by a filter expression (see catalog.Expr).
+// --update records the date each ok document was verified against its location (see document.FileLocation) in the
+// catalog it came from, so that the catalog shows when each copy was last known to be good.
+//
+// To run the program:
+//   go run verify-catalog/verify-catalog.go --archive-root /mnt/archive bin/local.yaml
+//
+
+type Document = document.Document
+
+// Status is the outcome of verifying one document
+type Status string
+
+const (
+	StatusOK         Status = "ok"
+	StatusChanged    Status = "changed"
+	StatusMissing    Status = "missing"
+	StatusUnreadable Status = "unreadable"
+	StatusUnchecked  Status = "unchecked"
+)
+
+// The statuses in the order they are totalled
+var statuses = []Status{StatusOK, StatusChanged, StatusMissing, StatusUnreadable, StatusUnchecked}
+
+// Result records the outcome of verifying one document
+type Result struct {
+	Key      string // The document's key in the catalog
+	Filepath string // The document's filepath in the catalog
+	Status   Status
+	Detail   string // What is wrong, for any status other than ok
+}
+
+func main() {
+	locator := locator.Flags()
+	where := flag.String("where", "", "verify only documents selected by this filter expression, e.g. 'collection = local:DEC_0001'")
+	workers := flag.Int("workers", 1, "number of files to hash concurrently (more may help with SSDs or volumes on different discs)")
+	update := flag.Bool("update", false, "record the date each document was found to match its MD5 checksum in the catalog it came from")
+	verbose := console.Flags("list the documents that are ok as well as those that are not")
+	s3ConfigFilename := flag.String("s3-config", "", "filepath of a YAML file giving the endpoint and credentials for s3:// roots")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to this file (see 'go tool pprof')")
+	memProfile := flag.String("memprofile", "", "write a memory profile to this file when the run ends")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	notifyFilename := flag.String("notify", "", "filepath of a YAML file saying where to send a summary of the run if anything noteworthy happened")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+	if *notifyFilename != "" {
+		notifyConfig, err := notify.ReadConfig(*notifyFilename)
+		if err != nil {
+			exitcode.UsageErrorf("Bad --notify: %v", err)
+		}
+		notify.Watch(notifyConfig)
+	}
+	if err := profiling.Start(*cpuProfile, *memProfile); err != nil {
+		exitcode.UsageErrorf("Cannot start profiling: %s", err)
+	}
+
+	interrupt.Watch()
+
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog")
+	}
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that documents can be found")
+	}
+	s3Config, err := archivefs.ReadS3Config(*s3ConfigFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--s3-config: %s", err)
+	}
+	archivefs.ConfigureS3(s3Config)
+
+	documents := make(catalog.Catalog)
+	catalogs := make(map[string]catalog.Catalog)
+	for _, filename := range flag.Args() {
+		loaded, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		catalogs[filename] = loaded
+		for _, key := range documents.Add(loaded) {
+			exitcode.WarningAt("duplicate-document", filename, "WARNING: document %s in %s already seen - dropped latter\n", key, filename)
+		}
+	}
+
+	results, completed := VerifyCatalog(documents.Where(filter), locator, *workers)
+	for _, result := range results[:completed] {
+		ReportResult(result, *verbose)
+	}
+	ReportTotals(os.Stdout, results[:completed])
+
+	// The documents verified before any interruption are recorded as well
+	if *update {
+		for _, filename := range RecordVerified(results[:completed], flag.Args(), catalogs, time.Now().Format("2006-01-02")) {
+			if err := catalog.Save(filename, catalogs[filename]); err != nil {
+				exitcode.Fatalf("Cannot write %s: %v", filename, err)
+			}
+			fmt.Printf("Recorded verification dates in %s\n", filename)
+		}
+	}
+	if completed < len(results) {
+		interrupt.Exit(fmt.Sprintf("%d of %d documents were not verified; re-run to check them", len(results)-completed, len(results)))
+	}
+
+	exitcode.Exit()
+}
+
+// Verifies every document in the catalog, in key order, hashing up to workers files at once. If the run is
+// interrupted no further documents are started; the number verified (which are always the first ones) is returned
+// along with the results.
+func VerifyCatalog(documents catalog.Catalog, locator *locator.Locator, workers int) ([]Result, int) {
+	type job struct {
+		result Result
+		fsys   fs.FS
+		name   string
+		doc    Document
+	}
+	var jobs []job
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		j := job{result: Result{Key: key, Filepath: doc.Filepath}, doc: doc}
+		if doc.Md5 == "" {
+			j.result.Status, j.result.Detail = StatusUnchecked, "no MD5 checksum in the catalog"
+		} else if fsys, name, err := locator.Locate(doc.Filepath); err != nil {
+			j.result.Status, j.result.Detail = StatusUnchecked, err.Error()
+		} else {
+			j.fsys, j.name = fsys, name
+		}
+		jobs = append(jobs, j)
+	}
+
+	keepGoing := func() bool { return !interrupt.Requested() }
+	return pipeline.MapWhile(jobs, workers, keepGoing, func(worker int, j job) Result {
+		if j.fsys != nil {
+			j.result.Status, j.result.Detail = VerifyFile(j.fsys, j.name, j.doc)
+		}
+		return j.result
+	})
+}
+
+// Checks the named file against the size and MD5 checksum recorded for it in the catalog
+func VerifyFile(fsys fs.FS, name string, doc Document) (Status, string) {
+	info, err := fs.Stat(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return StatusMissing, "no such file"
+	} else if err != nil {
+		return StatusUnreadable, err.Error()
+	}
+	if (doc.Size != 0) && (info.Size() != doc.Size) {
+		return StatusChanged, fmt.Sprintf("size is %d, catalog says %d", info.Size(), doc.Size)
+	}
+	md5Checksum, err := hashing.Md5FS(fsys, name)
+	if err != nil {
+		return StatusUnreadable, err.Error()
+	}
+	if !strings.EqualFold(md5Checksum, doc.Md5) {
+		return StatusChanged, fmt.Sprintf("MD5 is %s, catalog says %s", md5Checksum, doc.Md5)
+	}
+	return StatusOK, ""
+}
+
+// Records date as the verification date of the location of each ok document, in the first of the catalogs (taken in
+// the order of filenames) that holds it, as that is the copy that was verified. Returns the filenames of the catalogs
+// that changed.
+func RecordVerified(results []Result, filenames []string, catalogs map[string]catalog.Catalog, date string) []string {
+	changed := make(map[string]bool)
+	for _, result := range results {
+		if result.Status != StatusOK {
+			continue
+		}
+		for _, filename := range filenames {
+			doc, found := catalogs[filename][result.Key]
+			if !found {
+				continue
+			}
+			if document.MarkVerified(&doc, result.Filepath, date) {
+				catalogs[filename][result.Key] = doc
+				changed[filename] = true
+			}
+			break
+		}
+	}
+	var updated []string
+	for _, filename := range filenames {
+		if changed[filename] {
+			updated = append(updated, filename)
+			delete(changed, filename)
+		}
+	}
+	return updated
+}
+
+// Reports the outcome for one document (counting any problem as an error or warning).
+// Documents that are ok are only listed if verbose is set.
+func ReportResult(result Result, verbose bool) {
+	switch result.Status {
+	case StatusOK:
+		if verbose {
+			fmt.Printf("OK:         %s\n", result.Filepath)
+		}
+	case StatusChanged:
+		exitcode.ErrorAt("changed-document", result.Filepath, "CHANGED:    %s (%s)\n", result.Filepath, result.Detail)
+	case StatusMissing:
+		exitcode.ErrorAt("missing-document", result.Filepath, "MISSING:    %s\n", result.Filepath)
+	case StatusUnreadable:
+		exitcode.ErrorAt("unreadable-file", result.Filepath, "UNREADABLE: %s (%s)\n", result.Filepath, result.Detail)
+	case StatusUnchecked:
+		exitcode.WarningAt("unchecked-document", result.Filepath, "UNCHECKED:  %s [%s] (%s)\n", result.Filepath, result.Key, result.Detail)
+	}
+}
+
+// Writes the number of documents with each status, followed by the total
+func ReportTotals(out io.Writer, results []Result) {
+	counts := make(map[Status]int)
+	for _, result := range results {
+		counts[result.Status] += 1
+	}
+	for _, status := range statuses {
+		fmt.Fprintf(out, "%-11s %d\n", string(status)+":", counts[status])
+	}
+	fmt.Fprintf(out, "%-11s %d\n", "total:", len(results))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyCatalog(t *testing.T) {
+	archiveRoot := t.TempDir()
+	treeRoot := t.TempDir()
+	write := func(path string, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf(`cannot create test directory: %v`, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf(`cannot create test file: %v`, err)
+		}
+	}
+	// The MD5 checksum of "The quick brown fox jumps over the lazy dog"
+	const foxMd5 = "9e107d9d372bb6826bd81d3542a419d6"
+	write(filepath.Join(archiveRoot, "DEC_0001", "vax", "ok.pdf"), "The quick brown fox jumps over the lazy dog")
+	write(filepath.Join(archiveRoot, "DEC_0001", "vax", "rotten.pdf"), "The quick brown fox jumps over the lazy cog")
+	write(filepath.Join(archiveRoot, "DEC_0001", "vax", "truncated.pdf"), "The quick")
+	write(filepath.Join(treeRoot, "tree.pdf"), "The quick brown fox jumps over the lazy dog")
+
+	documents := catalog.Catalog{
+		"a": {Md5: foxMd5, Size: 43, Filepath: "file:///DEC_0001/vax/ok.pdf"},
+		"b": {Md5: foxMd5, Size: 43, Filepath: "file:///DEC_0001/vax/rotten.pdf"},
+		"c": {Md5: foxMd5, Size: 43, Filepath: "file:///DEC_0001/vax/truncated.pdf"},
+		"d": {Md5: foxMd5, Filepath: "file:///DEC_0001/vax/gone.pdf"},
+		"e": {Filepath: "file:///DEC_0001/vax/ok.pdf"},
+		"f": {Md5: foxMd5, Filepath: "http://www.vaxhaven.com/images/f/ka630.pdf"},
+		"g": {Md5: foxMd5, Filepath: "file:///DEC_0002/elsewhere.pdf"},
+		"h": {Md5: "9E107D9D372BB6826BD81D3542A419D6", Filepath: "tree.pdf"},
+	}
+	locator := &locator.Locator{ArchiveRoot: archiveRoot, VolumeRoots: map[string]string{"DEC_0002": filepath.Join(archiveRoot, "missing-volume")}, TreeRoot: treeRoot}
+	results, completed := VerifyCatalog(documents, locator, 2)
+	if completed != len(documents) {
+		t.Fatalf(`VerifyCatalog() verified %d of %d documents`, completed, len(documents))
+	}
+	var statuses []Status
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	expected := []Status{StatusOK, StatusChanged, StatusChanged, StatusMissing, StatusUnchecked, StatusUnchecked, StatusUnchecked, StatusOK}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf(`VerifyCatalog() = %+v`, results)
+	}
+	if results[2].Detail != "size is 9, catalog says 43" {
+		t.Errorf(`VerifyCatalog() reported truncation as %q`, results[2].Detail)
+	}
+
+	var out bytes.Buffer
+	ReportTotals(&out, results)
+	expectedTotals := `ok:         2
+changed:    2
+missing:    1
+unreadable: 0
+unchecked:  3
+total:      8
+`
+	if out.String() != expectedTotals {
+		t.Errorf("ReportTotals() wrote:\n%s\nexpected:\n%s", out.String(), expectedTotals)
+	}
+}
+
+func TestRecordVerified(t *testing.T) {
+	first := catalog.Catalog{"a": {Filepath: "file:///DEC_0001/ok.pdf"}, "b": {Filepath: "file:///DEC_0001/rotten.pdf"}}
+	second := catalog.Catalog{"a": {Filepath: "file:///DEC_0002/ok.pdf"}, "c": {Filepath: "tree.pdf"}}
+	for _, documents := range []catalog.Catalog{first, second} {
+		for key, doc := range documents {
+			document.NormaliseLocations(&doc)
+			documents[key] = doc
+		}
+	}
+	catalogs := map[string]catalog.Catalog{"first.yaml": first, "second.yaml": second}
+	results := []Result{
+		{Key: "a", Filepath: "file:///DEC_0001/ok.pdf", Status: StatusOK},
+		{Key: "b", Filepath: "file:///DEC_0001/rotten.pdf", Status: StatusChanged},
+	}
+	updated := RecordVerified(results, []string{"first.yaml", "second.yaml"}, catalogs, "2024-06-01")
+	if !reflect.DeepEqual(updated, []string{"first.yaml"}) {
+		t.Errorf(`RecordVerified() changed %q`, updated)
+	}
+	if (first["a"].Copies[0].Verified != "2024-06-01") || (first["b"].Copies[0].Verified != "") || (second["a"].Copies[0].Verified != "") {
+		t.Errorf(`RecordVerified() gave %+v and %+v`, first, second)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/volumes"
+	"docs-to-yaml/pkg/catalog"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+//
+// This program answers "where is my copy of this document?". Given MD5 checksums and/or part numbers, it finds every
+// matching document in the catalogs and reports each place a copy is held:
+//
+//   - the archive volume holding it, with the medium and its physical location from the volume registry
+//     (--volumes, see internal/volumes), e.g. a DVD-R in a particular sleeve of a particular binder
+//   - the location of a physical copy, as recorded by annotate-catalog --location
+//   - the public URL of a copy that is online
+//
+// Copies with the same MD5 checksum are reported together, whichever catalogs they were found in, as are the other
+// locations recorded for each document (e.g. by build-master).
+//
+// To run the program:
+//   go run where-is/where-is.go --volumes bin/volumes.yaml --part-num EK-KA630-TM-001 bin/local.yaml bin/bitsavers.yaml
+//
+
+type Document = document.Document
+
+// Holding describes one document and the places copies of it are held
+type Holding struct {
+	Heading string   // Part number, title and MD5 checksum
+	Places  []string // One line per place, in the order found
+}
+
+func main() {
+	var query catalog.Query
+	flag.Func("md5", "find the document with this MD5 checksum (repeatable)", func(s string) error {
+		query.Md5s = append(query.Md5s, strings.ToLower(strings.TrimSpace(s)))
+		return nil
+	})
+	flag.Func("part-num", "find documents with this part number (repeatable)", func(s string) error {
+		query.PartNums = append(query.PartNums, strings.TrimSpace(s))
+		return nil
+	})
+	volumesFilename := flag.String("volumes", "", "filepath of the volume registry (e.g. bin/volumes.yaml) describing each volume's medium and location")
+	console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if query.Empty() {
+		exitcode.UsageError("Please supply at least one --md5 or --part-num")
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog")
+	}
+	registry, err := volumes.Load(*volumesFilename)
+	if err != nil {
+		exitcode.UsageErrorf("--volumes: %s", err)
+	}
+
+	var found []Document
+	for _, filename := range flag.Args() {
+		documents, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		selected := documents.Select(query)
+		for _, key := range selected.Keys() {
+			found = append(found, selected[key])
+		}
+	}
+	if len(found) == 0 {
+		exitcode.Warning("WARNING: no document found for %s\n", strings.Join(append(query.Md5s, query.PartNums...), ", "))
+		exitcode.Exit()
+	}
+
+	for _, holding := range FindHoldings(found, registry) {
+		fmt.Println(holding.Heading)
+		for _, place := range holding.Places {
+			fmt.Printf("    %s\n", place)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Gathers the places that each document is held, combining copies with the same MD5 checksum, sorted by heading
+func FindHoldings(found []Document, registry volumes.Registry) []Holding {
+	var holdings []Holding
+	byMd5 := make(map[string]int)
+	for _, doc := range found {
+		index, seen := byMd5[doc.Md5]
+		if !seen || (doc.Md5 == "") {
+			index = len(holdings)
+			holdings = append(holdings, Holding{Heading: Heading(doc)})
+			if doc.Md5 != "" {
+				byMd5[doc.Md5] = index
+			}
+		}
+		for _, place := range Places(doc, registry) {
+			if !slices.Contains(holdings[index].Places, place) {
+				holdings[index].Places = append(holdings[index].Places, place)
+			}
+		}
+	}
+	sort.SliceStable(holdings, func(i, j int) bool { return holdings[i].Heading < holdings[j].Heading })
+	return holdings
+}
+
+// Returns the heading for a document: its part number, title and MD5 checksum, where known
+func Heading(doc Document) string {
+	var parts []string
+	for _, part := range []string{doc.PartNum, doc.Title} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if doc.Md5 != "" {
+		parts = append(parts, "(md5 "+doc.Md5+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Returns the places where a copy of the document is held: its volume, any physical copy and any online copy
+func Places(doc Document, registry volumes.Registry) []string {
+	var places []string
+	volumeID := doc.VolumeID
+	if volumeID == "" {
+		volumeID = volumes.IDFromFilepath(doc.Filepath)
+	}
+	if volumeID != "" {
+		places = append(places, VolumePlace(volumeID, doc.Filepath, registry))
+	} else if isOnline(doc.Filepath) {
+		places = append(places, "online: "+doc.Filepath)
+	}
+	// The other copies of the file (e.g. those combined into this document by build-master)
+	for _, filepath := range document.OtherLocations(doc) {
+		if volumeID := volumes.IDFromFilepath(filepath); volumeID != "" {
+			places = append(places, VolumePlace(volumeID, filepath, registry))
+		} else if isOnline(filepath) {
+			places = append(places, "online: "+filepath)
+		}
+	}
+	if doc.Location != "" {
+		places = append(places, "physical copy: "+doc.Location)
+	}
+	if (doc.PublicUrl != "") && (doc.PublicUrl != doc.Filepath) {
+		places = append(places, "online: "+doc.PublicUrl)
+	}
+	return places
+}
+
+func isOnline(filepath string) bool {
+	return strings.HasPrefix(filepath, "http://") || strings.HasPrefix(filepath, "https://")
+}
+
+// Describes the volume holding a document, e.g.
+// DEC_0001 (DVD-R "DEC manuals 1") at Study, shelf 2 / Binder 3 / sleeve 12: vax/ka630.pdf
+func VolumePlace(volumeID string, filepath string, registry volumes.Registry) string {
+	relative := strings.TrimPrefix(filepath, "file:///"+volumeID+"/")
+	volume, registered := registry[volumeID]
+	if !registered {
+		return fmt.Sprintf("%s (not in the volume registry): %s", volumeID, relative)
+	}
+	description := volumeID
+	var medium []string
+	if volume.Medium != "" {
+		medium = append(medium, volume.Medium)
+	}
+	if volume.Label != "" {
+		medium = append(medium, fmt.Sprintf("%q", volume.Label))
+	}
+	if len(medium) > 0 {
+		description += " (" + strings.Join(medium, " ") + ")"
+	}
+	if location := volume.PhysicalLocation(); location != "" {
+		description += " at " + location
+	} else {
+		description += ", location not recorded"
+	}
+	return description + ": " + relative
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"docs-to-yaml/internal/volumes"
+	"reflect"
+	"testing"
+)
+
+func TestFindHoldings(t *testing.T) {
+	registry := volumes.Registry{
+		"DEC_0001": {Label: "DEC manuals 1", Medium: "DVD-R", Location: "Study, shelf 2", Container: "Binder 3", Slot: "sleeve 12"},
+		"DEC_0002": {Medium: "CD-R"},
+	}
+	found := []Document{
+		{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Md5: "abc", Filepath: "file:///DEC_0001/vax/ka630.pdf", Location: "Loft, box 7"},
+		{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Md5: "abc", Filepath: "http://bitsavers.org/pdf/dec/vax/ka630.pdf", PublicUrl: "http://bitsavers.org/pdf/dec/vax/ka630.pdf"},
+		{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Md5: "abc", Filepath: "file:///DEC_0002/ka630.pdf"},
+		{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", Md5: "abc", Filepath: "ka630.pdf", VolumeID: "DEC_0009"},
+		{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual (scan)", Filepath: "file:///DEC_0001/scans/ka630.tif"},
+	}
+	expected := []Holding{
+		{Heading: "EK-KA630-TM-001 KA630 CPU Module Technical Manual (md5 abc)", Places: []string{
+			`DEC_0001 (DVD-R "DEC manuals 1") at Study, shelf 2 / Binder 3 / sleeve 12: vax/ka630.pdf`,
+			"physical copy: Loft, box 7",
+			"online: http://bitsavers.org/pdf/dec/vax/ka630.pdf",
+			"DEC_0002 (CD-R), location not recorded: ka630.pdf",
+			"DEC_0009 (not in the volume registry): ka630.pdf",
+		}},
+		{Heading: "EK-KA630-TM-001 KA630 CPU Module Technical Manual (scan)", Places: []string{
+			`DEC_0001 (DVD-R "DEC manuals 1") at Study, shelf 2 / Binder 3 / sleeve 12: scans/ka630.tif`,
+		}},
+	}
+	if holdings := FindHoldings(found, registry); !reflect.DeepEqual(holdings, expected) {
+		t.Errorf(`FindHoldings() = %#v`, holdings)
+	}
+}
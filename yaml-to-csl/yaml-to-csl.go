@@ -0,0 +1,269 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/visibility"
+	"docs-to-yaml/internal/workspace"
+	"docs-to-yaml/pkg/catalog"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// This program exports one or more catalogs as CSL-JSON, the citation format read by Zotero (File > Import) and
+// most other reference managers, so that the manuals can be cited like any other publication.
+//
+// Each document becomes one item:
+//
+//   id      the document's catalog key
+//   type    "book", or "report" for the documents selected by --report-where
+//   title   the title
+//   number  the part number
+//   issued  the publication date (as much of YYYY-MM-DD as is known)
+//   URL     the public URL, or the filepath if that is a URL
+//   medium  the file format (PDF, TXT, ...)
+//
+// CSL-JSON cannot say which collection an item belongs in, but Zotero places the items from each imported file into a
+// new collection named after the file. So with --output-dir, one file is written per Zotero collection, each named for
+// the collection; --collection PATTERN=NAME (repeatable, first match wins) maps the catalog collections matching
+// PATTERN (a glob, e.g. 'local*') to the Zotero collection NAME. A catalog collection that matches no pattern is its own
+// Zotero collection. With --output, every item is written to the one file.
+//
+// --tag, --without-tag and --where restrict the export as for yaml-to-csv. Only the documents the --audience may see
+// are exported, by default the public ones (see internal/visibility).
+//
+// To run the program:
+//   go run yaml-to-csl/yaml-to-csl.go --output-dir bin/zotero --collection 'local*=Local Scans' bin/local.yaml bin/bitsavers.yaml
+//
+
+type Document = document.Document
+
+// Item is one CSL-JSON item. Only the variables that a catalog can supply are included.
+type Item struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Number string `json:"number,omitempty"`
+	Issued *Date  `json:"issued,omitempty"`
+	URL    string `json:"URL,omitempty"`
+	Medium string `json:"medium,omitempty"`
+}
+
+// Date is a CSL-JSON date variable: a single date of one, two or three parts (year, month, day)
+type Date struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// CollectionRule maps the catalog collections matching Pattern to the Zotero collection Name
+type CollectionRule struct {
+	Pattern string
+	Name    string
+}
+
+// CollectionMap is the rules given with --collection, in order
+type CollectionMap []CollectionRule
+
+// The Zotero collection for documents with no collection
+const UncollectedName = "Uncollected"
+
+func main() {
+	outputFilename := flag.String("output", "", "filepath of a CSL-JSON file to hold every item")
+	outputDir := flag.String("output-dir", "", "directory to receive one CSL-JSON file per Zotero collection")
+	var collections CollectionMap
+	flag.Func("collection", "map catalog collections matching PATTERN to the Zotero collection NAME, as PATTERN=NAME (repeatable)", func(s string) error {
+		pattern, name, found := strings.Cut(s, "=")
+		if !found || (pattern == "") || (name == "") {
+			return fmt.Errorf("expected PATTERN=NAME, found %q", s)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		collections = append(collections, CollectionRule{Pattern: pattern, Name: name})
+		return nil
+	})
+	reportWhere := flag.String("report-where", "", "export the documents selected by this filter expression as reports rather than books, e.g. 'title ~ Specification'")
+	where := flag.String("where", "", "include only documents selected by this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	var requiredTags, excludedTags []string
+	flag.Func("tag", "include only documents with this tag (repeatable)", func(s string) error {
+		requiredTags = append(requiredTags, s)
+		return nil
+	})
+	flag.Func("without-tag", "exclude documents with this tag (repeatable)", func(s string) error {
+		excludedTags = append(excludedTags, s)
+		return nil
+	})
+	verbose := console.Flags("Enable verbose reporting")
+	audience := flag.String("audience", visibility.Public, "export only the documents this audience may see: public, restricted or private")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if (*outputFilename == "") == (*outputDir == "") {
+		exitcode.UsageError("Please supply exactly one of --output and --output-dir")
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply at least one catalog to export")
+	}
+	if !visibility.IsLevel(*audience) {
+		exitcode.UsageErrorf("--audience must be one of %s, found %q", strings.Join(visibility.Levels, ", "), *audience)
+	}
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+	reportFilter, err := catalog.ParseExpr(*reportWhere)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
+
+	documents := make(catalog.Catalog)
+	for _, filename := range flag.Args() {
+		loaded, err := catalog.Load(filename)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", filename, err)
+		}
+		for _, key := range documents.Add(loaded) {
+			exitcode.WarningAt("duplicate-document", filename, "WARNING: document %s in %s already seen - dropped latter\n", key, filename)
+		}
+		if *verbose {
+			fmt.Printf("Read %d documents from %s\n", len(loaded), filename)
+		}
+	}
+
+	for _, key := range visibility.Withheld(documents, *audience) {
+		events.Info("withheld", documents[key].Filepath, "%s withheld: %s", key, visibility.Of(documents[key]))
+	}
+	grouped := BuildItems(documents.Visible(*audience).Tagged(requiredTags, excludedTags).Where(filter), reportFilter, collections)
+
+	if *outputFilename != "" {
+		var items []Item
+		for _, name := range sortedNames(grouped) {
+			items = append(items, grouped[name]...)
+		}
+		if err := WriteItems(*outputFilename, items); err != nil {
+			exitcode.Fatalf("CSL-JSON write failed for %s: %v", *outputFilename, err)
+		}
+		fmt.Printf("Wrote %d items to %s\n", len(items), *outputFilename)
+	} else {
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			exitcode.Fatal(err)
+		}
+		for _, name := range sortedNames(grouped) {
+			filename := filepath.Join(*outputDir, CollectionFilename(name))
+			if err := WriteItems(filename, grouped[name]); err != nil {
+				exitcode.Fatalf("CSL-JSON write failed for %s: %v", filename, err)
+			}
+			fmt.Printf("Wrote %d items for Zotero collection %q to %s\n", len(grouped[name]), name, filename)
+		}
+	}
+
+	exitcode.Exit()
+}
+
+// Builds the CSL-JSON items for a catalog, grouped by Zotero collection. Within each collection the items are in
+// catalog key order. Documents selected by reportFilter are reports; a nil reportFilter selects none.
+func BuildItems(documents catalog.Catalog, reportFilter *catalog.Expr, collections CollectionMap) map[string][]Item {
+	grouped := make(map[string][]Item)
+	for _, key := range documents.Keys() {
+		doc := documents[key]
+		itemType := "book"
+		if (reportFilter != nil) && reportFilter.Matches(key, doc) {
+			itemType = "report"
+		}
+		name := collections.ZoteroCollection(doc.Collection)
+		grouped[name] = append(grouped[name], ItemFromDocument(key, doc, itemType))
+	}
+	return grouped
+}
+
+// Converts one document into a CSL-JSON item of the given type
+func ItemFromDocument(key string, doc Document, itemType string) Item {
+	item := Item{ID: key, Type: itemType, Title: doc.Title, Number: doc.PartNum, Issued: IssuedDate(doc.PubDate), URL: doc.PublicUrl, Medium: doc.Format}
+	if (item.URL == "") && (strings.HasPrefix(doc.Filepath, "http://") || strings.HasPrefix(doc.Filepath, "https://")) {
+		item.URL = doc.Filepath
+	}
+	return item
+}
+
+// Converts a publication date (YYYY, YYYY-MM or YYYY-MM-DD) into a CSL-JSON date. Returns nil for a blank or
+// unrecognised date.
+func IssuedDate(pubDate string) *Date {
+	if pubDate == "" {
+		return nil
+	}
+	fields := strings.Split(pubDate, "-")
+	if len(fields) > 3 {
+		return nil
+	}
+	var parts []int
+	for i, field := range fields {
+		value, err := strconv.Atoi(field)
+		if (err != nil) || (value < 1) || ((i == 0) && (len(field) != 4)) || ((i == 1) && (value > 12)) || ((i == 2) && (value > 31)) {
+			return nil
+		}
+		parts = append(parts, value)
+	}
+	return &Date{DateParts: [][]int{parts}}
+}
+
+// Returns the Zotero collection for a catalog collection: the name of the first rule whose pattern matches, or else
+// the catalog collection itself.
+func (m CollectionMap) ZoteroCollection(collection string) string {
+	for _, rule := range m {
+		if matched, _ := path.Match(rule.Pattern, collection); matched {
+			return rule.Name
+		}
+	}
+	if collection == "" {
+		return UncollectedName
+	}
+	return collection
+}
+
+// Returns the name of the file that holds a Zotero collection. Zotero names the collection it creates on import after
+// the file, so only characters that cannot appear in a filename are replaced.
+func CollectionFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name) + ".json"
+}
+
+// Writes a list of items as a CSL-JSON file
+func WriteItems(filename string, items []Item) error {
+	if items == nil {
+		items = []Item{}
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = workspace.WriteFile(filename, append(data, '\n'), 0644)
+	return err
+}
+
+// Returns the Zotero collection names, sorted
+func sortedNames(grouped map[string][]Item) []string {
+	var names []string
+	for name := range grouped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"docs-to-yaml/pkg/catalog"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildItems(t *testing.T) {
+	documents := catalog.Catalog{
+		"a": {Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM-001", PubDate: "1987-01", Format: "PDF", Collection: "local:DEC_0001"},
+		"b": {Title: "PVAX0 System Firmware Specification", PubDate: "1988", Format: "PDF", Collection: "local:DEC_0002"},
+		"c": {Title: "VAX Field Guide", Format: "TXT", Collection: "VaxHaven", Filepath: "http://www.vaxhaven.com/images/vfg.txt"},
+		"d": {Title: "Unsorted"},
+	}
+	reports, err := catalog.ParseExpr("title ~ Specification")
+	if err != nil {
+		t.Fatalf(`ParseExpr() failed: %v`, err)
+	}
+	collections := CollectionMap{{Pattern: "local:*", Name: "Local Scans"}}
+
+	grouped := BuildItems(documents, reports, collections)
+	expected := map[string][]Item{
+		"Local Scans": {
+			{ID: "a", Type: "book", Title: "KA630 CPU Module Technical Manual", Number: "EK-KA630-TM-001", Issued: &Date{[][]int{{1987, 1}}}, Medium: "PDF"},
+			{ID: "b", Type: "report", Title: "PVAX0 System Firmware Specification", Issued: &Date{[][]int{{1988}}}, Medium: "PDF"},
+		},
+		"VaxHaven":      {{ID: "c", Type: "book", Title: "VAX Field Guide", URL: "http://www.vaxhaven.com/images/vfg.txt", Medium: "TXT"}},
+		UncollectedName: {{ID: "d", Type: "book", Title: "Unsorted"}},
+	}
+	if !reflect.DeepEqual(grouped, expected) {
+		t.Errorf(`BuildItems() = %+v`, grouped)
+	}
+
+	// Without a report filter, everything is a book
+	for _, item := range BuildItems(documents, nil, nil)["local:DEC_0002"] {
+		if item.Type != "book" {
+			t.Errorf(`BuildItems() without a report filter gave type %s`, item.Type)
+		}
+	}
+}
+
+func TestIssuedDate(t *testing.T) {
+	tests := map[string][]int{
+		"1987-01-31": {1987, 1, 31},
+		"1987-01":    {1987, 1},
+		"1987":       {1987},
+		"":           nil,
+		"87":         nil,
+		"1987-13":    nil,
+		"Jan 1987":   nil,
+	}
+	for pubDate, expected := range tests {
+		issued := IssuedDate(pubDate)
+		if expected == nil {
+			if issued != nil {
+				t.Errorf(`IssuedDate(%q) = %v, expected nil`, pubDate, issued)
+			}
+		} else if (issued == nil) || !reflect.DeepEqual(issued.DateParts, [][]int{expected}) {
+			t.Errorf(`IssuedDate(%q) = %v, expected %v`, pubDate, issued, expected)
+		}
+	}
+}
+
+func TestWriteItems(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), CollectionFilename("Local: DEC/VAX"))
+	if filepath.Base(filename) != "Local_ DEC_VAX.json" {
+		t.Errorf(`CollectionFilename() = %s`, filepath.Base(filename))
+	}
+	items := []Item{{ID: "a", Type: "book", Title: "KA630", Number: "EK-KA630-TM-001", Issued: &Date{[][]int{{1987, 1}}}}}
+	if err := WriteItems(filename, items); err != nil {
+		t.Fatalf(`WriteItems() failed: %v`, err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf(`cannot read %s: %v`, filename, err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf(`WriteItems() wrote invalid JSON: %v`, err)
+	}
+	expected := []map[string]interface{}{{"id": "a", "type": "book", "title": "KA630", "number": "EK-KA630-TM-001",
+		"issued": map[string]interface{}{"date-parts": []interface{}{[]interface{}{1987.0, 1.0}}}}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf(`WriteItems() wrote %s`, data)
+	}
+}
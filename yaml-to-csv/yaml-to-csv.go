@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"docs-to-yaml/internal/collate"
+	"docs-to-yaml/internal/console"
 	"docs-to-yaml/internal/document"
-	"encoding/csv"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/pkg/catalog"
 	"flag"
 	"fmt"
-	"log"
 	"os"
-
-	"gopkg.in/yaml.v2"
 )
 
 //
@@ -25,39 +28,85 @@ type Document = document.Document
 // Finally the accumulated CSV records are written to the specified CSV file.
 //
 // No deduplication or other validation or processing is performed.
+// --tag and --without-tag (each repeatable) restrict the output to documents with (or without) the given tags,
+// and --where to those selected by a filter expression (see catalog.Expr).
+// --ascii transliterates the titles, dates, part numbers and options to 7-bit ASCII (e.g. "é" => "e"), as required
+// when the CSV is to be the index.csv of an archive.
+// The records of each YAML file are written in the same order as the catalog, which is the collation order set by
+// --collation (see internal/collate).
+//
+// --stream reads each catalog one document at a time and writes each record as it goes (see catalog.Reader), so that
+// a catalog too large to load (e.g. a master catalog of a million documents) is exported with little memory. The
+// records are then written in the order of the file, which is the same for a catalog written in the same collation.
 //
 // To run the program:
 //   go run yaml-to-csv/yaml-to-csv.go yaml-file(s) --verbose --csv output-csv-file  YAML-FILE-1 [, YAML-FILE-2 [, ...]]
 
 func main() {
-	verbose := flag.Bool("verbose", false, "Enable verbose reporting")
+	verbose := console.Flags("Enable verbose reporting")
 	csvOutputFilename := flag.String("csv", "", "filepath of the output file to hold the generated CSV")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+	ascii := flag.Bool("ascii", false, "transliterate the text to 7-bit ASCII, as required for an archive's index.csv")
+	stream := flag.Bool("stream", false, "read the catalogs one document at a time, writing the records in file order, to save memory")
+	where := flag.String("where", "", "include only documents selected by this filter expression, e.g. 'format = PDF and pubdate < 1990'")
+	collate.Flag()
+	var requiredTags, excludedTags []string
+	flag.Func("tag", "include only documents with this tag (repeatable)", func(s string) error {
+		requiredTags = append(requiredTags, s)
+		return nil
+	})
+	flag.Func("without-tag", "exclude documents with this tag (repeatable)", func(s string) error {
+		excludedTags = append(excludedTags, s)
+		return nil
+	})
 
 	flag.Parse()
 
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
 	if *csvOutputFilename == "" {
-		log.Fatal("Please supply a filespec for the output CSV")
+		exitcode.UsageError("Please supply a filespec for the output CSV")
 	}
 
-	var csvDocs [][]string
+	filter, err := catalog.ParseExpr(*where)
+	if err != nil {
+		exitcode.UsageError(err)
+	}
 
-	for _, yaml_file := range flag.Args() {
-		documentsMap := make(map[string]Document)
+	if *stream {
+		count, err := StreamCsv(*csvOutputFilename, flag.Args(), func(key string, doc Document) bool {
+			return catalog.HasTags(doc, requiredTags, excludedTags) && filter.Matches(key, doc)
+		}, *ascii, *verbose)
+		if err != nil {
+			exitcode.Fatal(err)
+		}
+		fmt.Printf("Found %d records in total\n", count)
+		exitcode.Exit()
+	}
+
+	var csvDocs []indexcsv.Record
 
+	for _, yaml_file := range flag.Args() {
 		if *verbose {
 			fmt.Printf("Processing YAML file: [%s]\n", yaml_file)
 		}
-		yaml_text, err := os.ReadFile(yaml_file)
-		if err != nil {
-			log.Printf("yamlFile read err for %s,  #%v ", yaml_file, err)
-		}
-		err = yaml.Unmarshal(yaml_text, &documentsMap)
-		if err != nil {
-			log.Fatalf("Unmarshal error for %s: %v", yaml_file, err)
+		documentsMap, err := catalog.Load(yaml_file)
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			exitcode.WarningAt("unreadable-file", yaml_file, "yamlFile read err for %s,  #%v\n", yaml_file, err)
+			continue
+		} else if err != nil {
+			exitcode.Fatal(err)
 		}
 
-		for _, doc := range documentsMap {
-			csvDocs = append(csvDocs, ConvertDocumentToCsv(doc))
+		selected := documentsMap.Tagged(requiredTags, excludedTags).Where(filter)
+		for _, key := range document.OrderedKeys(selected) {
+			record := indexcsv.RecordFromDocument(selected[key])
+			if *ascii {
+				record = record.ASCII()
+			}
+			csvDocs = append(csvDocs, record)
 		}
 
 		if *verbose {
@@ -66,55 +115,55 @@ func main() {
 	}
 	fmt.Printf("Found %d records in total\n", len(csvDocs))
 
-	csvFile, err := os.Create(*csvOutputFilename)
-
+	err = indexcsv.WriteFile(*csvOutputFilename, csvDocs)
 	if err != nil {
-		log.Fatalf("CSV file open failed for %s, %v\n", *csvOutputFilename, err)
+		exitcode.Fatalf("CSV write failed for %s, %v\n", *csvOutputFilename, err)
 	}
-	defer csvFile.Close()
 
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush()
+	exitcode.Exit()
+}
 
-	header := []string{"Record", "Title", "File", "URL", "Date", "Part Number", "MD5 Checksum", "Options"}
-	err = csvWriter.Write(header)
+// Writes a CSV record for each document in the catalogs that keep selects, reading each catalog one document at a
+// time. Returns the number of records written.
+func StreamCsv(csvOutputFilename string, filenames []string, keep func(key string, doc Document) bool, ascii bool, verbose bool) (int, error) {
+	file, err := os.Create(csvOutputFilename)
 	if err != nil {
-		fmt.Println("Error writing header to CSV:", err)
+		return 0, err
 	}
-
-	for _, rec := range csvDocs {
-		err = csvWriter.Write(rec)
-		if err != nil {
-			fmt.Println("Error writing record to CSV:", err)
+	defer file.Close()
+	buffer := bufio.NewWriter(file)
+	writer, err := indexcsv.NewWriter(buffer)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, filename := range filenames {
+		if verbose {
+			fmt.Printf("Processing YAML file: [%s]\n", filename)
+		}
+		err := catalog.Each(filename, func(key string, doc Document) error {
+			if !keep(key, doc) {
+				return nil
+			}
+			record := indexcsv.RecordFromDocument(doc)
+			if ascii {
+				record = record.ASCII()
+			}
+			count += 1
+			return writer.Write(record)
+		})
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			exitcode.WarningAt("unreadable-file", filename, "yamlFile read err for %s,  #%v\n", filename, err)
+			continue
+		} else if err != nil {
+			return count, err
 		}
 	}
-}
-
-// This table shows the fields in a CSV record and the Document members from which each CSV field is derived.
-//
-// | Field #  | Contents             | CSV field
-// |----------|----------------------|----------------
-// |       1  | _ Record type_       | "Doc" (fixed text)
-// |       2  | _Document title_     | .Title
-// |       3  | _Local file path_    | .Filepath
-// |       4  | _Original URL_       | .PublicUrl
-// |       5  | _Document date_      | .PubDate
-// |       6  | _Part number_        | .PartNum
-// |       7  | _Options_            |
-//
-// The CSV 'options' field contains the following sub-options:
-//
-//	collection='' taken from Document.Collection
-func ConvertDocumentToCsv(doc Document) []string {
-	options := fmt.Sprintf("'collection=%s'", doc.Collection)
-	return []string{
-		"Doc",
-		doc.Title,
-		doc.Filepath,
-		doc.PublicUrl,
-		doc.PubDate,
-		doc.PartNum,
-		doc.Md5,
-		options,
+	if err := writer.Flush(); err != nil {
+		return count, err
+	}
+	if err := buffer.Flush(); err != nil {
+		return count, err
 	}
+	return count, file.Close()
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"docs-to-yaml/internal/indexcsv"
+	"docs-to-yaml/pkg/catalog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStreamCsv(t *testing.T) {
+	dir := t.TempDir()
+	documents := catalog.Catalog{
+		"md5-a": {Title: "KA630 CPU Module Technical Manual", PartNum: "EK-KA630-TM", Md5: "md5-a", Format: "PDF", Filepath: "file:///DEC_0001/vax/ka630.pdf", Collection: "local"},
+		"md5-b": {Title: "VAX Field Guide", Md5: "md5-b", Format: "TXT", Filepath: "file:///DEC_0002/vax/guide.txt", Collection: "local"},
+		"md5-c": {Title: "Étude", Md5: "md5-c", Format: "PDF", Filepath: "file:///DEC_0002/etude.pdf", Collection: "local"},
+	}
+	filename := filepath.Join(dir, "local.yaml")
+	if err := catalog.Save(filename, documents); err != nil {
+		t.Fatal(err)
+	}
+	csvFilename := filepath.Join(dir, "index.csv")
+	pdfs := func(key string, doc Document) bool { return doc.Format == "PDF" }
+	count, err := StreamCsv(csvFilename, []string{filename}, pdfs, true, false)
+	if (err != nil) || (count != 2) {
+		t.Fatalf("StreamCsv() = %d, %v", count, err)
+	}
+	records, err := indexcsv.ReadFile(csvFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []indexcsv.Record
+	for _, key := range []string{"md5-c", "md5-a"} {
+		expected = append(expected, indexcsv.RecordFromDocument(documents[key]).ASCII())
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("StreamCsv() wrote %+v, expected %+v", records, expected)
+	}
+
+	if _, err := StreamCsv(filepath.Join(dir, "missing", "index.csv"), []string{filename}, pdfs, false, false); !os.IsNotExist(err) {
+		t.Errorf("StreamCsv() to a missing directory = %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"docs-to-yaml/internal/console"
+	"docs-to-yaml/internal/document"
+	"docs-to-yaml/internal/events"
+	"docs-to-yaml/internal/exitcode"
+	"docs-to-yaml/internal/fsutil"
+	"docs-to-yaml/internal/hashing"
+	"docs-to-yaml/internal/locator"
+	"docs-to-yaml/internal/md5sums"
+	"docs-to-yaml/internal/visibility"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// This program takes the YAML produced by find-locally-unique (i.e. documents held locally that are not
+// available on any of the internet repositories) and assembles a submission bundle for bitsavers:
+//
+//   o a staging directory holding a copy of each document, renamed to follow bitsavers conventions
+//   o a manifest (manifest.yaml) recording the title, part number, date and origin of each staged file
+//   o a checksum file (md5sums) in GNU md5sum format
+//
+// bitsavers filenames look like this:
+//
+//   EK-KA630-TM-001_KA630_CPU_Module_Technical_Manual_Jan87.pdf
+//
+// i.e. the part number, the title (with each run of punctuation and white space replaced by "_") and the
+// publication date (as MonYY, or just the year), separated by "_". Any missing element is simply left out.
+//
+// Only public documents may be submitted (see internal/visibility): if any document given is restricted or private,
+// each is reported and nothing is staged.
+//
+// Local documents have filepaths of the form file:///VOLUME/path, so the root of each volume must be supplied,
+// either individually (--volume VOLUME=PATH) or as a directory that holds every volume (--archive-root); the relative
+// filepaths written by file-tree-to-yaml are found under --tree-root (see internal/locator). Any root may be remote.
+//
+// To run the program:
+//   go run yaml-to-submission/yaml-to-submission.go --staging-dir DIR --archive-root ROOT unique.yaml [more.yaml ...]
+//
+
+type Document = document.Document
+
+// The name of the manifest written to the staging directory
+const ManifestFilename = "manifest.yaml"
+
+// ManifestEntry describes one staged file. The manifest is a map of staged filename => ManifestEntry.
+type ManifestEntry struct {
+	Title   string // Document title
+	PartNum string // The manufacturer identifier or part number for the document
+	PubDate string // The publication date (YYYY or YYYY-MM), if known
+	Format  string // File format (PDF, TXT, etc.)
+	Size    int64  // File size in bytes
+	Md5     string // File MD5 checksum
+	Source  string // Filepath of the document in the local catalog
+}
+
+func main() {
+	locator := locator.Flags()
+	stagingDir := flag.String("staging-dir", "", "directory in which to assemble the submission bundle")
+	verbose := console.Flags("Enable verbose reporting")
+	eventsFilename := flag.String("events", "", "filepath of a file to receive warning, error and info events as NDJSON")
+
+	flag.Parse()
+
+	if err := events.Open(*eventsFilename); err != nil {
+		exitcode.UsageErrorf("Cannot open events file: %s", err)
+	}
+
+	if *stagingDir == "" {
+		exitcode.UsageError("--staging-dir is mandatory - specify a directory to hold the submission bundle")
+	}
+	if len(flag.Args()) == 0 {
+		exitcode.UsageError("Please supply one or more YAML files describing the documents to submit")
+	}
+	if !locator.Configured() {
+		exitcode.UsageError("Please supply --archive-root, --tree-root or at least one --volume so that local documents can be found")
+	}
+
+	documents := make(map[string]Document)
+	for _, yamlFile := range flag.Args() {
+		data, err := os.ReadFile(yamlFile)
+		if err != nil {
+			exitcode.Fatalf("Cannot read %s: %v", yamlFile, err)
+		}
+		fileDocuments := make(map[string]Document)
+		if err := document.UnmarshalYaml(data, &fileDocuments); err != nil {
+			exitcode.Fatalf("Unmarshal error for %s: %v", yamlFile, err)
+		}
+		for k, v := range fileDocuments {
+			documents[k] = v
+		}
+	}
+
+	if withheld := visibility.Withheld(documents, visibility.Public); len(withheld) > 0 {
+		for _, key := range withheld {
+			exitcode.ErrorAt("not-public", documents[key].Filepath, "%s (%s) is %s and cannot be submitted\n", key, documents[key].Filepath, visibility.Of(documents[key]))
+		}
+		exitcode.Fatalf("Refusing to stage %d documents that are not public; remove them from the input", len(withheld))
+	}
+
+	if err := PrepareStagingDirectory(*stagingDir); err != nil {
+		exitcode.Fatal(err)
+	}
+
+	// Process the documents in a fixed order so that any name clashes are always resolved the same way
+	keys := make([]string, 0, len(documents))
+	for k := range documents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	manifest := make(map[string]ManifestEntry)
+	var checksums []md5sums.Entry
+	for _, k := range keys {
+		doc := documents[k]
+		fsys, name, err := locator.Locate(doc.Filepath)
+		if err != nil {
+			exitcode.WarningAt("unlocatable-document", doc.Filepath, "WARNING: cannot locate %s: %s\n", doc.Filepath, err)
+			continue
+		}
+
+		sourcePath := fsys.Location(name)
+		stagedName := UniqueFilename(SubmissionFilename(doc), manifest)
+		if *verbose {
+			fmt.Printf("Staging %s as %s\n", sourcePath, stagedName)
+		}
+		digests, err := CopyFile(fsys, name, filepath.Join(*stagingDir, stagedName))
+		if err != nil {
+			exitcode.ErrorAt("copy-failed", sourcePath, "Failed to stage %s: %s\n", sourcePath, err)
+			continue
+		}
+		if (doc.Md5 != "") && (doc.Md5 != digests.Md5) {
+			exitcode.WarningAt("md5-mismatch", sourcePath, "WARNING: MD5 of %s is %s but the catalog says %s\n", sourcePath, digests.Md5, doc.Md5)
+		}
+
+		manifest[stagedName] = ManifestEntry{
+			Title:   doc.Title,
+			PartNum: doc.PartNum,
+			PubDate: doc.PubDate,
+			Format:  doc.Format,
+			Size:    digests.Size,
+			Md5:     digests.Md5,
+			Source:  doc.Filepath,
+		}
+		checksums = append(checksums, md5sums.Entry{Md5: digests.Md5, Filepath: stagedName})
+	}
+
+	data, err := document.MarshalYaml(manifest)
+	if err != nil {
+		exitcode.Fatal("Bad YAML data: ", err)
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(*stagingDir, ManifestFilename), data, 0644); err != nil {
+		exitcode.Fatal("Failed manifest write: ", err)
+	}
+	if err := md5sums.WriteFile(filepath.Join(*stagingDir, md5sums.Md5sumsFilename), checksums); err != nil {
+		exitcode.Fatal("Failed md5sums write: ", err)
+	}
+
+	fmt.Printf("Staged %d of %d documents in %s\n", len(manifest), len(documents), *stagingDir)
+	exitcode.Exit()
+}
+
+// Creates the staging directory if necessary. An existing directory must be empty, so that a bundle
+// never mixes files from two runs.
+func PrepareStagingDirectory(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0755)
+	} else if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("staging directory %s is not empty", dir)
+	}
+	return nil
+}
+
+var nonAlphanumericRegex = regexp.MustCompile(`[^A-Za-z0-9]+`)
+var nonPartNumRegex = regexp.MustCompile(`[^A-Z0-9-]+`)
+
+var monthAbbreviations = [...]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// Builds the bitsavers-style filename for a document: PARTNUM_Title_Words_MonYY.ext
+// If the document has neither a part number nor a title, its existing filename is kept.
+func SubmissionFilename(doc Document) string {
+	base := filepath.Base(filepath.FromSlash(doc.Filepath))
+	extension := strings.ToLower(filepath.Ext(base))
+
+	var elements []string
+	if partNum := strings.Trim(nonPartNumRegex.ReplaceAllString(strings.ToUpper(doc.PartNum), "-"), "-"); partNum != "" {
+		elements = append(elements, partNum)
+	}
+	if title := strings.Trim(nonAlphanumericRegex.ReplaceAllString(doc.Title, "_"), "_"); title != "" {
+		elements = append(elements, title)
+	}
+	if len(elements) == 0 {
+		return base
+	}
+	if date := SubmissionDate(doc.PubDate); date != "" {
+		elements = append(elements, date)
+	}
+	return strings.Join(elements, "_") + extension
+}
+
+// Converts a publication date (YYYY, YYYY-MM or YYYY-MM-DD) into the form used in bitsavers filenames (YYYY or
+// MonYY); the day, which bitsavers filenames do not record, is dropped. Anything else is dropped.
+func SubmissionDate(pubDate string) string {
+	parts := strings.Split(pubDate, "-")
+	year := parts[0]
+	if (len(year) != 4) || (document.ValidateDate(year) == "") || (len(parts) > 3) {
+		return ""
+	}
+	if len(parts) == 1 {
+		return year
+	}
+	month := parts[1]
+	monthNumber, err := strconv.Atoi(month)
+	if (err != nil) || (monthNumber < 1) || (monthNumber > 12) {
+		return year
+	}
+	return monthAbbreviations[monthNumber-1] + year[2:]
+}
+
+// Returns name, or (if that is already in the manifest) name with _2, _3 etc. added before the extension.
+func UniqueFilename(name string, manifest map[string]ManifestEntry) string {
+	if _, found := manifest[name]; !found {
+		return name
+	}
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s_%d%s", stem, n, extension)
+		if _, found := manifest[candidate]; !found {
+			return candidate
+		}
+	}
+}
+
+// Copies the named file in fsys (e.g. an archive root) to destination, computing the MD5 checksum of the data as it is
+// copied.
+func CopyFile(fsys fs.FS, source string, destination string) (hashing.Digests, error) {
+	in, err := fsys.Open(source)
+	if err != nil {
+		return hashing.Digests{}, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return hashing.Digests{}, err
+	}
+	digests, err := hashing.HashReader(io.TeeReader(in, out), hashing.MD5)
+	if err != nil {
+		out.Close()
+		os.Remove(destination)
+		return digests, err
+	}
+	return digests, out.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubmissionFilename(t *testing.T) {
+	tests := []struct {
+		doc      Document
+		expected string
+	}{
+		{Document{PartNum: "EK-KA630-TM-001", Title: "KA630 CPU Module Technical Manual", PubDate: "1987-01", Filepath: "file:///DEC_0001/vax/ka630.PDF"}, "EK-KA630-TM-001_KA630_CPU_Module_Technical_Manual_Jan87.pdf"},
+		{Document{PartNum: "aa-h500b-te", Title: "VMS Release Notes, V4.4", PubDate: "1986", Filepath: "file:///DEC_0002/vms/rn.pdf"}, "AA-H500B-TE_VMS_Release_Notes_V4_4_1986.pdf"},
+		{Document{Title: "  OS/8 Software Support Manual ", Filepath: "file:///DEC_0003/os8/ssm.txt"}, "OS_8_Software_Support_Manual.txt"},
+		{Document{PartNum: "EY-1234E-SG", PubDate: "bad", Filepath: "file:///DEC_0003/a.pdf"}, "EY-1234E-SG.pdf"},
+		{Document{Filepath: "file:///DEC_0004/misc/odd-name.pdf"}, "odd-name.pdf"},
+	}
+	for _, test := range tests {
+		if got := SubmissionFilename(test.doc); got != test.expected {
+			t.Errorf(`SubmissionFilename(%+v) = %q, expected %q`, test.doc, got, test.expected)
+		}
+	}
+}
+
+func TestSubmissionDate(t *testing.T) {
+	tests := map[string]string{"1987-01": "Jan87", "1987-01-15": "Jan87", "1999-12": "Dec99", "1999-12-31-1": "", "1986": "1986", "1986-13": "1986", "": "", "Jan87": "", "1850": ""}
+	for input, expected := range tests {
+		if got := SubmissionDate(input); got != expected {
+			t.Errorf(`SubmissionDate(%q) = %q, expected %q`, input, got, expected)
+		}
+	}
+}
+
+func TestUniqueFilename(t *testing.T) {
+	manifest := map[string]ManifestEntry{"a.pdf": {}, "a_2.pdf": {}}
+	if got := UniqueFilename("b.pdf", manifest); got != "b.pdf" {
+		t.Errorf(`UniqueFilename("b.pdf") = %q`, got)
+	}
+	if got := UniqueFilename("a.pdf", manifest); got != "a_3.pdf" {
+		t.Errorf(`UniqueFilename("a.pdf") = %q`, got)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	destination := filepath.Join(dir, "staged.txt")
+	digests, err := CopyFile(os.DirFS(dir), "source.txt", destination)
+	if err != nil {
+		t.Fatalf(`CopyFile() failed: %v`, err)
+	}
+	if (digests.Md5 != "b1946ac92492d2347c6235b4d2611184") || (digests.Size != 6) {
+		t.Errorf(`CopyFile() digests = %+v`, digests)
+	}
+	if data, err := os.ReadFile(destination); (err != nil) || (string(data) != "hello\n") {
+		t.Errorf(`staged file contains %q (%v)`, data, err)
+	}
+
+	if err := PrepareStagingDirectory(dir); err == nil {
+		t.Errorf(`PrepareStagingDirectory() accepted a non-empty directory`)
+	}
+	if err := PrepareStagingDirectory(filepath.Join(dir, "new")); err != nil {
+		t.Errorf(`PrepareStagingDirectory() failed for a new directory: %v`, err)
+	}
+}